@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,105 +13,646 @@ import (
 	"time"
 
 	"stream-rag-agent/internal/api"
+	"stream-rag-agent/internal/atrest"
+	"stream-rag-agent/internal/category"
+	"stream-rag-agent/internal/codec"
 	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/demo"
 	"stream-rag-agent/internal/embedding"
+	"stream-rag-agent/internal/faults"
+	"stream-rag-agent/internal/governance"
+	"stream-rag-agent/internal/ingestion"
 	"stream-rag-agent/internal/kafka"
 	"stream-rag-agent/internal/llm"
+	"stream-rag-agent/internal/outbox"
+	"stream-rag-agent/internal/patterns"
+	"stream-rag-agent/internal/schemaregistry"
+	"stream-rag-agent/internal/slo"
+	"stream-rag-agent/internal/source"
+	"stream-rag-agent/internal/source/amqp"
+	"stream-rag-agent/internal/source/kinesis"
+	"stream-rag-agent/internal/source/mqtt"
+	"stream-rag-agent/internal/source/nats"
+	"stream-rag-agent/internal/source/redis"
 	"stream-rag-agent/internal/vectordb"
+	"stream-rag-agent/internal/views"
+	"stream-rag-agent/internal/webhook"
 	"stream-rag-agent/internal/window"
+	"stream-rag-agent/internal/workspaces"
+	"stream-rag-agent/pkg/hooks"
 )
 
+// defaultDevDataDir is where dev mode keeps its local store unless dev.data_dir is set.
+const defaultDevDataDir = "./devdata"
+
 type MainProcessor struct {
 	embeddingService *embedding.Service
-	esClient         *vectordb.ElasticsearchClient
+	store            vectordb.WindowStore
+	sloTracker       *slo.Tracker
+	topics           map[string]config.KafkaTopicConfig
+	outbox           *outbox.Outbox             // nil when the local outbox is disabled
+	classifier       *category.Classifier       // nil when categories are disabled
+	publisher        *kafka.Publisher           // nil when kafka.output is disabled
+	patterns         *patterns.Detector         // nil when pattern alerting is disabled
+	webhooks         map[string]*webhook.Client // By topic, only topics with a processor webhook
+	summarizers      map[string]window.Summarizer
 }
 
-func NewMainProcessor(es *vectordb.ElasticsearchClient, embedSvc *embedding.Service) *MainProcessor {
+func NewMainProcessor(store vectordb.WindowStore, embedSvc *embedding.Service, tracker *slo.Tracker, topics []config.KafkaTopicConfig, ob *outbox.Outbox, classifier *category.Classifier, publisher *kafka.Publisher, detector *patterns.Detector) (*MainProcessor, error) {
+	topicConfigs := make(map[string]config.KafkaTopicConfig, len(topics))
+	webhooks := make(map[string]*webhook.Client)
+	summarizers := make(map[string]window.Summarizer, len(topics))
+	for _, t := range topics {
+		topicConfigs[t.Name] = t
+		if c := webhook.New(t.Webhook); c != nil {
+			webhooks[t.Name] = c
+		}
+		summarizer, err := window.NewSummarizer(t)
+		if err != nil {
+			return nil, err
+		}
+		summarizers[t.Name] = summarizer
+	}
 	return &MainProcessor{
 		embeddingService: embedSvc,
-		esClient:         es,
-	}
+		store:            store,
+		sloTracker:       tracker,
+		topics:           topicConfigs,
+		outbox:           ob,
+		classifier:       classifier,
+		publisher:        publisher,
+		patterns:         detector,
+		webhooks:         webhooks,
+		summarizers:      summarizers,
+	}, nil
 }
 
 func (mp *MainProcessor) ProcessWindow(w *window.Window) error {
 	log.Printf("Processing window %s (Topic: %s, Messages: %d)", w.ID, w.Topic, w.MessageCount)
 
-	// 1. Convert window messages to a single context string
-	contextText, err := w.ToContextString()
+	mp.sloTracker.Begin()
+	defer func() {
+		// Every window counts towards the SLO, including dropped, outboxed and failed ones
+		mp.sloTracker.Observe(w.Topic, time.Since(w.ClosedAt))
+		mp.sloTracker.Done()
+	}()
+
+	// Under SLO pressure, drop windows of low-priority topics entirely
+	if mp.sloTracker.ShouldDrop(w.Topic, mp.topics[w.Topic].Priority) {
+		log.Printf("Shedding: dropping window %s of low-priority topic %s", w.ID, w.Topic)
+		return nil
+	}
+
+	if w.TombstoneOnly() {
+		log.Printf("Skipping window %s of topic %s: it only contains %d deletions", w.ID, w.Topic, len(w.Deletions))
+		return nil
+	}
+
+	// 1. Convert window messages to a single context string (reduced while shedding)
+	maxRendered := mp.sloTracker.MaxRenderedMessages(w.Topic)
+	summarizer, ok := mp.summarizers[w.Topic]
+	if !ok {
+		summarizer, _ = window.NewSummarizer(config.KafkaTopicConfig{Name: w.Topic})
+	}
+	contextText, err := summarizer.Summarize(w, maxRendered)
 	if err != nil {
 		return fmt.Errorf("failed to convert window to context string: %w", err)
 	}
 
+	// 1b. Let the topic's processor webhook enrich or veto the window
+	var annotations map[string]string
+	if hook, ok := mp.webhooks[w.Topic]; ok {
+		decision, err := hook.Review(w, contextText)
+		if err != nil {
+			log.Printf("Processor webhook failed for window %s: %v", w.ID, err)
+		}
+		if decision.Action == webhook.ActionVeto {
+			log.Printf("Window %s of topic %s vetoed by processor webhook: %s", w.ID, w.Topic, decision.Reason)
+			return nil
+		}
+		contextText = decision.Apply(contextText)
+		annotations = decision.Annotations
+	}
+
 	// 2. Get embedding from Ollama
-	embeddingVector, err := mp.embeddingService.GetEmbedding(contextText)
+	embeddingVector, chunkRanges, err := mp.embeddingService.GetEmbeddingLimited(contextText, mp.topics[w.Topic].EmbeddingMaxChars)
 	if err != nil {
 		return fmt.Errorf("failed to get embedding for window %s: %w", w.ID, err)
 	}
 
 	// 3. Create EmbeddedWindow struct
+	embeddedAt := time.Now()
 	embeddedWindow := &window.EmbeddedWindow{
-		WindowID:     w.ID,
-		Topic:        w.Topic,
-		Partition:    w.Partition,
-		StartTime:    w.StartTime,
-		EndTime:      w.EndTime,
-		MessageCount: w.MessageCount,
-		ContextText:  contextText,
-		Embedding:    embeddingVector,
+		WindowID:             w.ID,
+		Topic:                w.Topic,
+		Partition:            w.Partition,
+		GroupKey:             w.GroupKey,
+		StartTime:            w.StartTime,
+		EndTime:              w.EndTime,
+		MessageCount:         w.MessageCount,
+		ContextText:          contextText,
+		TopicContext:         w.Context,
+		ContextVersion:       w.ContextVersion,
+		Embedding:            embeddingVector,
+		EmbeddingModel:       mp.embeddingService.Model(),
+		EmbeddingModelDigest: mp.embeddingService.ModelDigest(),
+		TemplateVersion:      summarizer.Version(),
+		EmbeddedAt:           &embeddedAt,
+		SimHash:              window.FormatSimHash(window.SimHash(contextText)),
+		Annotations:          annotations,
+		CloseReason:          w.CloseReason,
+		Truncated:            summarizer.Truncated(w, maxRendered),
+		ParseFailures:        w.ParseFailures,
+		Category:             mp.classifier.Classify(w, contextText),
+		Headers:              w.HeaderValues(),
+	}
+	if len(chunkRanges) > 1 {
+		embeddedWindow.EmbeddingChunks = len(chunkRanges)
+		embeddedWindow.ChunkRanges = chunkRanges
 	}
+	if w.SamplingPolicy != "" {
+		embeddedWindow.SamplingPolicy = w.SamplingPolicy
+		embeddedWindow.SamplingRate = w.SamplingRate()
+		embeddedWindow.SampledFrom = w.SeenCount
+	}
+	if !w.ContextEffectiveFrom.IsZero() {
+		embeddedWindow.ContextEffectiveFrom = &w.ContextEffectiveFrom
+	}
+	if w.Shard >= 0 {
+		shard := w.Shard
+		embeddedWindow.Shard = &shard
+	}
+	if w.FirstOffset >= 0 {
+		first, last := w.FirstOffset, w.LastOffset
+		embeddedWindow.FirstOffset, embeddedWindow.LastOffset = &first, &last
+	}
+
+	// 3b. Alert when the window resembles a known-bad pattern
+	mp.patterns.Check(embeddedWindow)
 
 	// 4. Save to Elasticsearch
-	err = mp.esClient.SaveEmbeddedWindow(embeddedWindow)
+	err = mp.store.SaveEmbeddedWindow(embeddedWindow)
 	if err != nil {
-		return fmt.Errorf("failed to save embedded window to Elasticsearch: %w", err)
+		if mp.outbox == nil {
+			return fmt.Errorf("failed to save embedded window to Elasticsearch: %w", err)
+		}
+		// Keep the computed embedding in the local outbox until Elasticsearch is reachable again
+		log.Printf("Failed to save window %s to Elasticsearch, storing it in the outbox: %v", w.ID, err)
+		if outboxErr := mp.outbox.Put(embeddedWindow); outboxErr != nil {
+			return fmt.Errorf("failed to save embedded window to Elasticsearch (%v) and to outbox: %w", err, outboxErr)
+		}
+		return mp.publisher.Publish(embeddedWindow, w.KeyStats)
+	}
+	if err := mp.publisher.Publish(embeddedWindow, w.KeyStats); err != nil {
+		log.Printf("Error publishing summary of window %s: %v", w.ID, err)
+	}
+
+	// 5. Index structured fields of every message for aggregation queries
+	if fields := mp.topics[w.Topic].StructuredFields; len(fields) > 0 {
+		if err := mp.store.SaveEvents(structuredEvents(w, fields)); err != nil {
+			log.Printf("Error indexing structured events of window %s: %v", w.ID, err)
+		}
 	}
 
 	log.Printf("Successfully processed and saved window %s to Elasticsearch.", w.ID)
 	return nil
 }
 
+// structuredEvents extracts the configured fields of each message in the window.
+func structuredEvents(w *window.Window, fields []string) []vectordb.StructuredEvent {
+	events := make([]vectordb.StructuredEvent, 0, len(w.Messages))
+	for _, msg := range w.Messages {
+		extracted := window.ExtractFields(msg, fields)
+		if extracted == nil {
+			continue
+		}
+		events = append(events, vectordb.StructuredEvent{
+			Topic:     w.Topic,
+			WindowID:  w.ID,
+			Partition: msg.Partition,
+			Offset:    msg.Offset,
+			Timestamp: msg.Timestamp,
+			Fields:    extracted,
+		})
+	}
+	return events
+}
+
+// loadHooks builds the LLM/embedding hook registry from the configured plugins and webhooks.
+func loadHooks(cfg config.HooksConfig) (*hooks.Registry, error) {
+	registry := hooks.NewRegistry()
+	for _, path := range cfg.Plugins {
+		if err := hooks.LoadPlugin(path, registry); err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded hook plugin %s", path)
+	}
+	for _, wh := range cfg.Webhooks {
+		timeout := time.Duration(wh.TimeoutMs) * time.Millisecond
+		if timeout <= 0 {
+			timeout = 5 * time.Second
+		}
+		if err := hooks.NewWebhook(wh.URL, timeout).Register(registry, wh.Points); err != nil {
+			return nil, fmt.Errorf("hook webhook %s: %w", wh.URL, err)
+		}
+		log.Printf("Registered hook webhook %s for %v", wh.URL, wh.Points)
+	}
+	return registry, nil
+}
+
 func main() {
-	cfg, err := config.LoadConfig("../configs/configs.yml")
+	if len(os.Args) > 1 && os.Args[1] == "repl" {
+		runREPL(os.Args[2:])
+		return
+	}
+
+	configPath := flag.String("config", "../configs/configs.yml", "Path to the agent configuration file")
+	profile := flag.String("profile", "", "Config profile whose overlay file (e.g. configs.staging.yml) is merged over the config file; defaults to "+config.ProfileEnv)
+	demoMode := flag.Bool("demo", false, "Feed synthetic transactions into the windows instead of consuming from Kafka")
+	devMode := flag.Bool("dev", false, "Keep windows in an embedded local store instead of Elasticsearch")
+	replayFromFlag := flag.String("replay-from", "", "Reset the Kafka topics' consumer group offsets to this RFC3339 time before consuming, to backfill the index")
+	decryptPath := flag.String("decrypt", "", "Print the plaintext of a file the agent wrote with encryption enabled, e.g. a dead-letter file, and exit")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath, *profile)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	if *demoMode {
+		cfg.Demo.Enabled = true
+	}
+	if *devMode {
+		cfg.Dev.Enabled = true
+	}
+	var replayFrom time.Time
+	if *replayFromFlag != "" {
+		replayFrom, err = time.Parse(time.RFC3339, *replayFromFlag)
+		if err != nil {
+			log.Fatalf("Invalid --replay-from '%s': expected an RFC3339 time such as 2024-01-01T00:00:00Z", *replayFromFlag)
+		}
+		log.Printf("Replaying Kafka topics from %s; Kinesis and NATS topics keep their checkpoints", replayFrom.Format(time.RFC3339))
+	}
+
+	if err := atrest.Configure(cfg.Encryption); err != nil {
+		log.Fatalf("Failed to set up encryption: %v", err)
+	}
+	if *decryptPath != "" {
+		plain, err := atrest.Decrypt(*decryptPath)
+		if err != nil {
+			log.Fatalf("Failed to decrypt %s: %v", *decryptPath, err)
+		}
+		os.Stdout.Write(plain)
+		return
+	}
+	if atrest.Enabled() {
+		log.Println("Local files (outbox, dev journal, dead letters, views, patterns, checkpoints) are encrypted at rest")
+	}
+
+	if cfg.Faults.Enabled {
+		log.Println("WARNING: fault injection is enabled; dependency failures can be injected via /admin/faults")
+		faults.Enable()
+	}
 
 	// Setup Services
-	esClient, err := vectordb.NewElasticsearchClient(&cfg.Elasticsearch)
+	var store vectordb.WindowStore
+	if cfg.Dev.Enabled {
+		dataDir := cfg.Dev.DataDir
+		if dataDir == "" {
+			dataDir = defaultDevDataDir
+		}
+		localStore, err := vectordb.NewLocalStore(dataDir, cfg.Dev.MaxWindows)
+		if err != nil {
+			log.Fatalf("Failed to initialize local store: %v", err)
+		}
+		defer localStore.Close()
+		log.Println("Dev mode: windows are stored locally, Elasticsearch-only endpoints are disabled")
+		store = localStore
+		// Windows are written locally, so only summary events may need to be held back in an outbox
+		if !cfg.Kafka.Output.Enabled {
+			cfg.Outbox.Dir = ""
+		}
+	} else {
+		store, err = vectordb.NewElasticsearchClient(&cfg.Elasticsearch)
+		if err != nil {
+			log.Fatalf("Failed to initialize Elasticsearch client: %v", err)
+		}
+	}
+
+	hookRegistry, err := loadHooks(cfg.Hooks)
 	if err != nil {
-		log.Fatalf("Failed to initialize Elasticsearch client: %v", err)
+		log.Fatalf("Failed to load hooks: %v", err)
 	}
+	embedSvc := embedding.NewService(&cfg.Ollama, hookRegistry)
+	llmSvc := llm.NewService(&cfg.Ollama, hookRegistry)
 
-	embedSvc := embedding.NewService(&cfg.Ollama)
-	llmSvc := llm.NewService(&cfg.Ollama)
+	var windowOutbox *outbox.Outbox
+	if cfg.Outbox.Dir != "" {
+		windowOutbox, err = outbox.New(cfg.Outbox.Dir, time.Duration(cfg.Outbox.FlushIntervalSeconds)*time.Second)
+		if err != nil {
+			log.Fatalf("Failed to initialize outbox: %v", err)
+		}
+	}
+
+	reportingLocation, err := cfg.Reporting.Location()
+	if err != nil {
+		log.Fatalf("Failed to load reporting time zone: %v", err)
+	}
 
-	mainProcessor := NewMainProcessor(esClient, embedSvc)
+	classifier, err := category.New(cfg.Categories, llmSvc)
+	if err != nil {
+		log.Fatalf("Invalid categories config: %v", err)
+	}
+
+	var publisher *kafka.Publisher
+	if out := cfg.Kafka.Output; out.Enabled {
+		for _, t := range cfg.Kafka.Topics {
+			if t.Name == out.Topic {
+				log.Fatalf("kafka.output.topic %s is also consumed; the agent would process its own summaries", out.Topic)
+			}
+		}
+		cluster, err := cfg.Kafka.ClusterFor(config.KafkaTopicConfig{Name: out.Topic, Cluster: out.Cluster})
+		if err != nil {
+			log.Fatalf("Failed to resolve Kafka cluster of the output topic: %v", err)
+		}
+		publisher, err = kafka.NewPublisher(out, cluster, windowOutbox)
+		if err != nil {
+			log.Fatalf("Invalid Kafka output config: %v", err)
+		}
+	}
+
+	detector, err := patterns.NewDetector(cfg.Patterns, embedSvc)
+	if err != nil {
+		log.Fatalf("Failed to load patterns: %v", err)
+	}
+
+	var decoder *schemaregistry.Decoder
+	descriptorDecoders := make(map[string]*schemaregistry.DescriptorDecoder) // By topic
+	for _, t := range cfg.Kafka.Topics {
+		switch t.ValueFormat {
+		case "", "json":
+			continue
+		case schemaregistry.ValueFormat, schemaregistry.ProtobufValueFormat:
+		default:
+			log.Fatalf("Unknown value_format '%s' of topic %s (expected json, %s or %s)", t.ValueFormat, t.Name, schemaregistry.ValueFormat, schemaregistry.ProtobufValueFormat)
+		}
+		if t.PayloadCompression != "" && t.PayloadCompression != codec.CompressionNone {
+			log.Fatalf("Topic %s: payload_compression cannot be combined with value_format %s", t.Name, t.ValueFormat)
+		}
+		if t.Source == kinesis.SourceName || t.Source == nats.SourceName || t.Source == mqtt.SourceName || t.Source == redis.SourceName || t.Source == amqp.SourceName {
+			log.Fatalf("Topic %s: value_format %s is only supported for Kafka topics", t.Name, t.ValueFormat)
+		}
+		if t.ValueFormat == schemaregistry.ProtobufValueFormat {
+			if t.ProtobufDescriptor == "" || t.ProtobufMessage == "" {
+				log.Fatalf("Topic %s: value_format %s needs protobuf_descriptor and protobuf_message", t.Name, t.ValueFormat)
+			}
+			d, err := schemaregistry.NewDescriptorDecoder(t.ProtobufDescriptor, t.ProtobufMessage)
+			if err != nil {
+				log.Fatalf("Topic %s: %v", t.Name, err)
+			}
+			descriptorDecoders[t.Name] = d
+			continue
+		}
+		if decoder == nil && !cfg.Demo.Enabled {
+			client, err := schemaregistry.NewClient(cfg.Kafka.SchemaRegistry)
+			if err != nil {
+				log.Fatalf("Invalid Schema Registry config: %v", err)
+			}
+			decoder = schemaregistry.NewDecoder(client)
+		}
+	}
+
+	sloTracker := slo.NewTracker(cfg.ProcessingSLO)
+	mainProcessor, err := NewMainProcessor(store, embedSvc, sloTracker, cfg.Kafka.Topics, windowOutbox, classifier, publisher, detector)
+	if err != nil {
+		log.Fatalf("Failed to create window processor: %v", err)
+	}
 
 	// Context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	var wg sync.WaitGroup
 
+	if windowOutbox != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var events outbox.EventPublisher
+			if publisher != nil {
+				events = publisher
+			}
+			windowOutbox.Run(ctx, store, events)
+		}()
+	}
+
+	if esClient, ok := store.(*vectordb.ElasticsearchClient); ok {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			esClient.RunOptimizer(ctx, reportingLocation)
+		}()
+	}
+
+	memoryBudget := window.NewMemoryBudget(int64(cfg.MemoryBudget.MaxBufferedMB) << 20)
+	if memoryBudget != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			memoryBudget.Run(ctx)
+		}()
+	}
+
+	windowJanitor := window.NewJanitor(cfg.WindowJanitor)
+	if windowJanitor != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			windowJanitor.Run(ctx)
+		}()
+	}
+
+	windowWAL, err := window.NewWAL(cfg.WindowWAL)
+	if err != nil {
+		log.Fatalf("Failed to open window WAL: %v", err)
+	}
+	if windowWAL != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			windowWAL.Run(ctx)
+		}()
+	}
+
+	// Topics can be skipped or paused at runtime through /admin/ingestion
+	ingestionStore, err := ingestion.NewStore(cfg.Ingestion)
+	if err != nil {
+		log.Fatalf("Failed to load ingestion states: %v", err)
+	}
+
 	// Start Kafka Consumers and Window Managers
 	consumers := []*kafka.Consumer{}
+	sources := []source.Source{}
 	windowManagers := []*window.Manager{}
 
 	for _, topicCfg := range cfg.Kafka.Topics {
-		wm := window.NewManager(topicCfg, mainProcessor)
+		groupID := cfg.Kafka.ConsumerGroupID
+		if topicCfg.ConsumerGroupID != "" {
+			groupID = topicCfg.ConsumerGroupID
+		}
+
+		// JetStream, MQTT, Redis and AMQP messages are acknowledged once their window has been processed
+		var processor window.WindowProcessor = mainProcessor
+		var natsConsumer *nats.Consumer
+		var mqttConsumer *mqtt.Consumer
+		var redisConsumer *redis.Consumer
+		var amqpConsumer *amqp.Consumer
+		if !cfg.Demo.Enabled && topicCfg.Source == nats.SourceName {
+			natsConsumer, err = nats.NewConsumer(topicCfg, cfg.NATS, groupID)
+			if err != nil {
+				log.Fatalf("Failed to create NATS consumer for topic %s: %v", topicCfg.Name, err)
+			}
+			processor = natsConsumer.AckAfter(mainProcessor, window.NewAssigner(topicCfg))
+		}
+		if !cfg.Demo.Enabled && topicCfg.Source == mqtt.SourceName {
+			mqttConsumer, err = mqtt.NewConsumer(topicCfg, cfg.MQTT, groupID)
+			if err != nil {
+				log.Fatalf("Failed to create MQTT consumer for topic %s: %v", topicCfg.Name, err)
+			}
+			processor = mqttConsumer.AckAfter(mainProcessor, window.NewAssigner(topicCfg))
+		}
+		if !cfg.Demo.Enabled && topicCfg.Source == redis.SourceName {
+			redisConsumer, err = redis.NewConsumer(topicCfg, cfg.Redis, groupID)
+			if err != nil {
+				log.Fatalf("Failed to create Redis consumer for topic %s: %v", topicCfg.Name, err)
+			}
+			processor = redisConsumer.AckAfter(mainProcessor, window.NewAssigner(topicCfg))
+		}
+		if !cfg.Demo.Enabled && topicCfg.Source == amqp.SourceName {
+			amqpConsumer, err = amqp.NewConsumer(topicCfg, cfg.AMQP, groupID)
+			if err != nil {
+				log.Fatalf("Failed to create AMQP consumer for topic %s: %v", topicCfg.Name, err)
+			}
+			processor = amqpConsumer.AckAfter(mainProcessor, window.NewAssigner(topicCfg))
+		}
+
+		wm := window.NewManager(topicCfg, processor, reportingLocation)
 		windowManagers = append(windowManagers, wm)
-		wm.Start(0)
+		memoryBudget.Attach(wm)
+		windowJanitor.Attach(wm)
+
+		var src source.Source
+		switch {
+		case cfg.Demo.Enabled:
+			wm.Start(0)
+			src = demo.NewSource(topicCfg.Name, 0, time.Duration(cfg.Demo.IntervalMs)*time.Millisecond)
+		case topicCfg.Source == kinesis.SourceName:
+			consumer, err := kinesis.NewConsumer(topicCfg, cfg.Kinesis, groupID)
+			if err != nil {
+				log.Fatalf("Failed to create Kinesis consumer for stream %s: %v", topicCfg.Name, err)
+			}
+			src = consumer
 
-		consumer := kafka.NewConsumer(topicCfg, cfg.Kafka.ConsumerGroupID, cfg.Kafka.Brokers, wm)
-		consumers = append(consumers, consumer)
+			// Open a window per shard, including shards created by resharding
+			wg.Add(1)
+			go func(c *kinesis.Consumer, m *window.Manager) {
+				defer wg.Done()
+				c.DiscoverShards(ctx, m.Start)
+			}(consumer, wm)
+		case natsConsumer != nil:
+			wm.UseOffsetIDs() // Stream sequences
+			wm.Start(0)
+			src = natsConsumer
+		case mqttConsumer != nil:
+			wm.Start(0)
+			src = mqttConsumer
+		case redisConsumer != nil:
+			// A window per stream key
+			for i := range redisConsumer.Streams() {
+				wm.Start(int32(i))
+			}
+			src = redisConsumer
+		case amqpConsumer != nil:
+			wm.Start(0)
+			src = amqpConsumer
+		case topicCfg.Source != "" && topicCfg.Source != "kafka":
+			log.Fatalf("Unknown source '%s' of topic %s (expected kafka, %s, %s, %s, %s or %s)", topicCfg.Source, topicCfg.Name, kinesis.SourceName, nats.SourceName, mqtt.SourceName, redis.SourceName, amqp.SourceName)
+		default:
+			cluster, err := cfg.Kafka.ClusterFor(topicCfg)
+			if err != nil {
+				log.Fatalf("Failed to resolve Kafka cluster: %v", err)
+			}
+			log.Printf("Topic %s is read from Kafka cluster '%s' (%v)", topicCfg.Name, cluster.Name, cluster.Brokers)
+			consumer, err := kafka.NewConsumer(topicCfg, cluster)
+			if err != nil {
+				log.Fatalf("Failed to create Kafka consumer for topic %s: %v", topicCfg.Name, err)
+			}
+			switch topicCfg.ValueFormat {
+			case schemaregistry.ValueFormat:
+				consumer.SetDecoder(decoder)
+			case schemaregistry.ProtobufValueFormat:
+				consumer.SetDecoder(descriptorDecoders[topicCfg.Name])
+			}
+			if err := consumer.ApplyStartOffset(ctx, replayFrom); err != nil {
+				log.Fatalf("Failed to position consumer group for topic %s: %v", topicCfg.Name, err)
+			}
+			// Windows of revoked partitions are processed before the group moves them
+			consumer.SetRebalanceListener(wm)
+			// Replayed offsets overwrite the windows indexed from them
+			wm.UseOffsetIDs()
+			consumers = append(consumers, consumer)
+			src = consumer
+
+			// Open a window per partition, including partitions added while running
+			wg.Add(2)
+			go func(c *kafka.Consumer, m *window.Manager) {
+				defer wg.Done()
+				c.DiscoverPartitions(ctx, m.Start)
+			}(consumer, wm)
+			go func(c *kafka.Consumer) {
+				defer wg.Done()
+				c.MonitorLag(ctx)
+			}(consumer)
+		}
+
+		ackAfter := natsConsumer != nil || mqttConsumer != nil || redisConsumer != nil || amqpConsumer != nil
+		// Messages acknowledged once their window is processed are delivered again after a
+		// crash, others are restored from the WAL before consuming resumes
+		if !ackAfter {
+			if err := windowWAL.Attach(wm); err != nil {
+				log.Fatalf("Failed to restore windows of topic %s: %v", topicCfg.Name, err)
+			}
+		}
+
+		// Messages acknowledged once their window is processed cannot be skipped
+		ingestionStore.Register(topicCfg.Name, !ackAfter)
 
 		wg.Add(1)
-		go func(c *kafka.Consumer, p int32) {
+		sources = append(sources, src)
+		go func(topic string, s source.Source, sink *ingestion.Sink) {
 			defer wg.Done()
-			c.StartConsuming(ctx, p)
-		}(consumer, 0)
+			if err := source.Run(ctx, topic, s, sink); err != nil {
+				log.Printf("Source for topic %s stopped: %v", topic, err)
+			}
+		}(topicCfg.Name, src, ingestionStore.Sink(topicCfg.Name, wm))
 	}
 
 	// Start API Server
-	apiServer := api.NewAPIServer(embedSvc, llmSvc, esClient)
+	viewStore, err := views.NewStore(cfg.Views)
+	if err != nil {
+		log.Fatalf("Failed to load views: %v", err)
+	}
+	workspaceStore, err := workspaces.NewStore(cfg.Workspaces)
+	if err != nil {
+		log.Fatalf("Failed to load workspaces: %v", err)
+	}
+	egressPolicy, err := governance.NewPolicy(cfg.DataGovernance, cfg.Kafka.Topics, cfg.Ollama.LLMModel)
+	if err != nil {
+		log.Fatalf("Invalid data governance config: %v", err)
+	}
+	apiServer := api.NewAPIServer(embedSvc, llmSvc, store, cfg.Query, viewStore, consumers, cfg.Kafka.Topics, reportingLocation, cfg.API, egressPolicy, detector, ingestionStore, workspaceStore)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		apiServer.RunAnalyticsReports(ctx)
+	}()
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -136,17 +678,21 @@ func main() {
 
 	// Flush any remaining windows before closing
 	for _, wm := range windowManagers {
-		wm.FlushAllWindows()
+		wm.FlushAllWindows(window.CloseShutdown)
 	}
 	// Give a small grace period for window processing to complete
 	time.Sleep(5 * time.Second)
 
-	// Close Kafka consumers
-	for _, consumer := range consumers {
-		if err := consumer.Close(); err != nil {
-			log.Printf("Error closing Kafka consumer: %v", err)
+	// Close Kafka consumers and other sources
+	for _, s := range sources {
+		if err := s.Close(); err != nil {
+			log.Printf("Error closing source: %v", err)
 		}
 	}
+	// Flush summaries of the windows processed during shutdown
+	if err := publisher.Close(); err != nil {
+		log.Printf("Error closing Kafka output publisher: %v", err)
+	}
 
 	wg.Wait()
 
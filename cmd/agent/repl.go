@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+	"stream-rag-agent/internal/api"
+	"stream-rag-agent/internal/atrest"
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/embedding"
+	"stream-rag-agent/internal/governance"
+	"stream-rag-agent/internal/llm"
+	"stream-rag-agent/internal/vectordb"
+	"stream-rag-agent/internal/views"
+)
+
+const (
+	replPrompt       = "rag> "
+	replHistoryFile  = ".stream-rag-agent_history" // In the home directory
+	replHistoryLimit = 1000
+)
+
+const replHelp = `Type a question to answer it from the stored windows, or a command:
+  :topics [a,b,...]   only retrieve windows of these topics; without topics, all topics
+  :view [name]        scope retrieval to a saved view; without a name, no view
+  :mode [mode]        rag, structured, tail or auto; without a mode, as configured
+  :context [on|off]   print the context text of the windows an answer was generated from
+  :history            list the questions asked
+  :help               show this help
+  :quit               leave (or Ctrl-D)
+`
+
+// runREPL runs "agent repl": an interactive prompt answering questions from the stores of the
+// configuration, the way POST /query does, without starting consumers or the HTTP server.
+func runREPL(args []string) {
+	fs := flag.NewFlagSet("repl", flag.ExitOnError)
+	configPath := fs.String("config", "../configs/configs.yml", "Path to the agent configuration file")
+	profile := fs.String("profile", "", "Config profile whose overlay file is merged over the config file; defaults to "+config.ProfileEnv)
+	devMode := fs.Bool("dev", false, "Query the local store of dev mode instead of Elasticsearch")
+	topics := fs.String("topics", "", "Comma-separated topics to retrieve windows from; all topics if empty")
+	view := fs.String("view", "", "Saved view scoping retrieval")
+	mode := fs.String("mode", "", "Answering mode: rag, structured, tail or auto; as configured if empty")
+	showContext := fs.Bool("show-context", false, "Print the context text of the windows each answer was generated from")
+	historyPath := fs.String("history", defaultREPLHistoryPath(), "File keeping the questions asked across sessions, empty to keep none")
+	verbose := fs.Bool("verbose", false, "Print the agent's log output while answering")
+	fs.Parse(args)
+
+	cfg, err := config.Load(*configPath, *profile)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if *devMode {
+		cfg.Dev.Enabled = true
+	}
+	if err := atrest.Configure(cfg.Encryption); err != nil {
+		log.Fatalf("Failed to set up encryption: %v", err)
+	}
+
+	var store vectordb.WindowStore
+	if cfg.Dev.Enabled {
+		dataDir := cfg.Dev.DataDir
+		if dataDir == "" {
+			dataDir = defaultDevDataDir
+		}
+		// An agent in dev mode may be writing the journal, so it is only read
+		store, err = vectordb.LoadLocalStore(dataDir, cfg.Dev.MaxWindows)
+	} else {
+		store, err = vectordb.NewElasticsearchClient(&cfg.Elasticsearch)
+	}
+	if err != nil {
+		log.Fatalf("Failed to open the window store: %v", err)
+	}
+	hookRegistry, err := loadHooks(cfg.Hooks)
+	if err != nil {
+		log.Fatalf("Failed to load hooks: %v", err)
+	}
+	reportingLocation, err := cfg.Reporting.Location()
+	if err != nil {
+		log.Fatalf("Failed to load reporting time zone: %v", err)
+	}
+	viewStore, err := views.NewStore(cfg.Views)
+	if err != nil {
+		log.Fatalf("Failed to load views: %v", err)
+	}
+	egressPolicy, err := governance.NewPolicy(cfg.DataGovernance, cfg.Kafka.Topics, cfg.Ollama.LLMModel)
+	if err != nil {
+		log.Fatalf("Invalid data governance config: %v", err)
+	}
+	server := api.NewAPIServer(embedding.NewService(&cfg.Ollama, hookRegistry), llm.NewService(&cfg.Ollama, hookRegistry), store, cfg.Query, viewStore, nil, cfg.Kafka.Topics, reportingLocation, cfg.API, egressPolicy, nil, nil, nil)
+
+	history, err := loadREPLHistory(*historyPath)
+	if err != nil {
+		log.Fatalf("Failed to load REPL history: %v", err)
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	session := &replSession{
+		server:      server,
+		out:         os.Stdout,
+		req:         api.QueryRequest{View: *view, Mode: *mode, Topics: splitList(*topics)},
+		showContext: *showContext,
+		history:     history,
+	}
+	var lines lineReader
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		t := term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout}, replPrompt)
+		t.History = history
+		lines = &terminalLines{fd: fd, terminal: t}
+		fmt.Fprintln(os.Stdout, "Ask a question about the stream, or type :help.")
+	} else {
+		// Questions piped in, e.g. from a script
+		scanner := bufio.NewScanner(os.Stdin)
+		lines = scannerLines{scanner}
+	}
+
+	for {
+		line, err := lines.ReadLine()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read input: %v\n", err)
+			os.Exit(1)
+		}
+		if !session.handle(strings.TrimSpace(line)) {
+			return
+		}
+	}
+}
+
+// lineReader reads the lines typed into the REPL, returning io.EOF when the input ends.
+type lineReader interface {
+	ReadLine() (string, error)
+}
+
+// terminalLines reads lines with editing and history recall from a terminal, which is only in
+// raw mode while a line is typed so answers are printed and interrupted as usual.
+type terminalLines struct {
+	fd       int
+	terminal *term.Terminal
+}
+
+func (t *terminalLines) ReadLine() (string, error) {
+	state, err := term.MakeRaw(t.fd)
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(t.fd, state)
+	return t.terminal.ReadLine()
+}
+
+type scannerLines struct {
+	scanner *bufio.Scanner
+}
+
+func (s scannerLines) ReadLine() (string, error) {
+	if !s.scanner.Scan() {
+		if err := s.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return s.scanner.Text(), nil
+}
+
+// replSession holds the settings of a REPL session, which apply to every question.
+type replSession struct {
+	server      *api.APIServer
+	out         io.Writer
+	req         api.QueryRequest // View, topics and mode of the questions
+	showContext bool
+	history     *replHistory
+}
+
+// handle runs a command or answers a question, and reports whether to read the next line.
+func (s *replSession) handle(line string) bool {
+	if line == "" {
+		return true
+	}
+	if !strings.HasPrefix(line, ":") {
+		s.ask(line)
+		return true
+	}
+
+	command, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch command {
+	case ":quit", ":exit", ":q":
+		return false
+	case ":help", ":h":
+		fmt.Fprint(s.out, replHelp)
+	case ":topics":
+		s.req.Topics = splitList(arg)
+		if len(s.req.Topics) == 0 {
+			fmt.Fprintln(s.out, "Retrieving windows of all topics")
+		} else {
+			fmt.Fprintf(s.out, "Retrieving windows of %s\n", strings.Join(s.req.Topics, ", "))
+		}
+	case ":view":
+		s.req.View = arg
+		if arg == "" {
+			fmt.Fprintln(s.out, "No view")
+		} else {
+			fmt.Fprintf(s.out, "Retrieving within view %s\n", arg)
+		}
+	case ":mode":
+		switch arg {
+		case "", api.ModeAuto, api.ModeRAG, api.ModeStructured, api.ModeTail:
+			s.req.Mode = arg
+			fmt.Fprintf(s.out, "Mode: %s\n", valueOr(arg, "as configured"))
+		default:
+			fmt.Fprintf(s.out, "Unknown mode %q (expected %s, %s, %s or %s)\n", arg, api.ModeRAG, api.ModeStructured, api.ModeTail, api.ModeAuto)
+		}
+	case ":context":
+		switch arg {
+		case "", "on":
+			s.showContext = true
+		case "off":
+			s.showContext = false
+		default:
+			fmt.Fprintln(s.out, "Expected :context on or :context off")
+			return true
+		}
+		if s.showContext {
+			fmt.Fprintln(s.out, "Context is shown")
+		} else {
+			fmt.Fprintln(s.out, "Context is hidden")
+		}
+	case ":history":
+		for i := s.history.Len() - 1; i >= 0; i-- {
+			fmt.Fprintf(s.out, "%4d  %s\n", s.history.Len()-i, s.history.At(i))
+		}
+	default:
+		fmt.Fprintf(s.out, "Unknown command %s, type :help for the commands\n", command)
+	}
+	return true
+}
+
+// ask answers the question and prints the answer, how it was found and its sources.
+func (s *replSession) ask(question string) {
+	req := s.req
+	req.Prompt = question
+	started := time.Now()
+	resp, err := s.server.Ask(context.Background(), req)
+	if err != nil {
+		fmt.Fprintf(s.out, "Invalid question: %v\n", err)
+		return
+	}
+	if resp.Error != "" {
+		fmt.Fprintf(s.out, "Error: %s\n", resp.Error)
+		return
+	}
+
+	fmt.Fprintf(s.out, "\n%s\n\n", strings.TrimSpace(resp.Answer))
+	summary := fmt.Sprintf("%s mode", valueOr(resp.Mode, api.ModeRAG))
+	if resp.Intent != nil && resp.Intent.Reason != "" {
+		summary += fmt.Sprintf(" (%s: %s)", resp.Intent.Source, resp.Intent.Reason)
+	}
+	summary += fmt.Sprintf(", %d windows, %s", len(resp.Sources), time.Since(started).Round(time.Millisecond))
+	if resp.Degraded {
+		summary += ", keyword search only"
+	}
+	fmt.Fprintln(s.out, summary)
+	for i, src := range resp.Sources {
+		offsets := ""
+		if src.FirstOffset != nil && src.LastOffset != nil {
+			offsets = fmt.Sprintf(" offsets %d-%d", *src.FirstOffset, *src.LastOffset)
+		}
+		fmt.Fprintf(s.out, "  [%d] %s/%d%s %s - %s  %s\n", i+1, src.Topic, src.Partition, offsets,
+			src.StartTime.Format(time.RFC3339), src.EndTime.Format(time.RFC3339), src.WindowID)
+	}
+	if resp.Aggregation != nil {
+		fmt.Fprintf(s.out, "  aggregated into %d rows\n", len(resp.Aggregation.Rows))
+	}
+	if s.showContext {
+		for i, w := range resp.Windows {
+			fmt.Fprintf(s.out, "\n--- context of [%d] %s ---\n%s\n", i+1, w.WindowID, strings.TrimSpace(w.ContextText))
+		}
+	}
+	fmt.Fprintln(s.out)
+}
+
+// replHistory keeps the lines typed into the REPL for recall with the arrow keys, and appends
+// them to a file so later sessions can recall them too. It is a term.History.
+type replHistory struct {
+	path    string   // Empty when the history is not kept
+	entries []string // Oldest first
+}
+
+// loadREPLHistory reads the last lines of the history file, if any.
+func loadREPLHistory(path string) (*replHistory, error) {
+	h := &replHistory{path: path}
+	if path == "" {
+		return h, nil
+	}
+	data, err := atrest.Decrypt(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			h.entries = append(h.entries, line)
+		}
+	}
+	if len(h.entries) > replHistoryLimit {
+		h.entries = h.entries[len(h.entries)-replHistoryLimit:]
+		if err := h.rewrite(); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// Add records a line unless it repeats the previous one.
+func (h *replHistory) Add(entry string) {
+	entry = strings.TrimSpace(entry)
+	if entry == "" || (len(h.entries) > 0 && h.entries[len(h.entries)-1] == entry) {
+		return
+	}
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > replHistoryLimit {
+		h.entries = h.entries[1:]
+	}
+	if err := h.append(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save REPL history: %v\n", err)
+		h.path = ""
+	}
+}
+
+// Len returns the number of lines recorded.
+func (h *replHistory) Len() int {
+	return len(h.entries)
+}
+
+// At returns a line, 0 being the most recent.
+func (h *replHistory) At(idx int) string {
+	return h.entries[len(h.entries)-1-idx]
+}
+
+func (h *replHistory) append(entry string) error {
+	if h.path == "" {
+		return nil
+	}
+	line, err := atrest.SealLine([]byte(entry))
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rewrite replaces the history file with the recorded lines.
+func (h *replHistory) rewrite() error {
+	var b strings.Builder
+	for _, entry := range h.entries {
+		line, err := atrest.SealLine([]byte(entry))
+		if err != nil {
+			return err
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.path)
+}
+
+// defaultREPLHistoryPath returns the history file in the home directory, or none if there is
+// no home directory.
+func defaultREPLHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, replHistoryFile)
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -3,133 +3,178 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"flag"
 	"log"
-	"math/rand"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/segmentio/kafka-go"
+	"stream-rag-agent/internal/demo"
 )
 
 const (
-	kafkaBroker  = "localhost:9092"
-	topic        = "financial_transactions"
-	numMessages  = 0
-	sendInterval = 100 * time.Millisecond
+	scenarioTransactions = "transactions" // Financial transactions to a single topic
+	scenarioOrders       = "orders"       // Correlated order, payment and shipment events across three topics
 )
 
-type FinancialTransaction struct {
-	TransactionID string    `json:"transaction_id"`
-	Amount        float64   `json:"amount"`
-	Currency      string    `json:"currency"`
-	Type          string    `json:"type"`
-	Timestamp     time.Time `json:"timestamp"`
-	Description   string    `json:"description,omitempty"`
-	AccountID     string    `json:"account_id"`
-}
-
 func main() {
-	log.Println("Starting Kafka Producer for financial_transactions...")
+	brokers := flag.String("brokers", "localhost:9092", "Comma-separated Kafka brokers")
+	scenario := flag.String("scenario", scenarioTransactions, "transactions: financial transactions to one topic; orders: order chains whose order, payment and shipment events share IDs across three topics")
+	count := flag.Int("count", 0, "Transactions, or order chains, to produce; 0 produces until interrupted")
+	interval := flag.Duration("interval", 100*time.Millisecond, "Time between transactions, or between the starts of order chains")
+	topic := flag.String("topic", "financial_transactions", "Topic of the transactions scenario")
+	ordersTopic := flag.String("orders-topic", "orders", "Topic of order events in the orders scenario")
+	paymentsTopic := flag.String("payments-topic", "payments", "Topic of payment events in the orders scenario")
+	shipmentsTopic := flag.String("shipments-topic", "shipments", "Topic of shipment events in the orders scenario")
+	delayScale := flag.Float64("delay-scale", 1, "Multiplies the delays between the events of an order chain (seconds to a minute); 0 produces each chain at once")
+	flag.Parse()
 
 	writer := &kafka.Writer{
-		Addr:     kafka.TCP(kafkaBroker),
-		Topic:    topic,
-		Balancer: &kafka.LeastBytes{},
+		Addr:                   kafka.TCP(strings.Split(*brokers, ",")...),
+		Balancer:               &kafka.LeastBytes{},
+		AllowAutoTopicCreation: true,
 	}
 	defer writer.Close()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-
-	go func() {
-		defer wg.Done()
-		i := 0
-		for {
-			select {
-			case <-ctx.Done():
-				log.Println("Producer received shutdown signal. Exiting message loop.")
-				return
-			default:
-				if numMessages > 0 && i >= numMessages {
-					log.Printf("Finished sending %d messages. Exiting.", numMessages)
-					cancel()
-					return
-				}
+	done := make(chan struct{})
+	switch *scenario {
+	case scenarioTransactions:
+		log.Printf("Starting Kafka Producer for %s...", *topic)
+		go func() {
+			defer close(done)
+			produceTransactions(ctx, writer, *topic, *count, *interval)
+		}()
+	case scenarioOrders:
+		// Events of an order share its ID as key, so they land in the same partition of each topic
+		writer.Balancer = &kafka.Hash{}
+		topics := map[string]string{demo.StageOrder: *ordersTopic, demo.StagePayment: *paymentsTopic, demo.StageShipment: *shipmentsTopic}
+		log.Printf("Starting Kafka Producer for order chains across %s, %s and %s...", *ordersTopic, *paymentsTopic, *shipmentsTopic)
+		go func() {
+			defer close(done)
+			produceOrders(ctx, writer, topics, *count, *interval, *delayScale)
+		}()
+	default:
+		log.Fatalf("Unknown scenario '%s' (expected %s or %s)", *scenario, scenarioTransactions, scenarioOrders)
+	}
 
-				transaction := generateDummyTransaction(i)
-				msgValue, err := json.Marshal(transaction)
-				if err != nil {
-					log.Printf("Error marshalling transaction: %v", err)
-					continue
-				}
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-				msg := kafka.Message{
-					Key:   []byte(transaction.TransactionID),
-					Value: msgValue,
-					Time:  transaction.Timestamp,
-				}
+	select {
+	case <-sigChan:
+		log.Println("Shutting down producer gracefully...")
+		cancel()
+		<-done
+	case <-done:
+	}
 
-				err = writer.WriteMessages(ctx, msg)
-				if err != nil {
-					log.Printf("Error writing message to Kafka: %v", err)
-					if ctx.Err() != nil {
-						return
-					}
-					time.Sleep(sendInterval)
-					continue
-				}
+	log.Println("Producer stopped.")
+}
 
-				log.Printf("Sent message %d (ID: %s, Amount: %.2f) to topic %s", i+1, transaction.TransactionID, transaction.Amount, topic)
-				i++
-				time.Sleep(sendInterval)
+// produceTransactions sends count transactions (0: until ctx is cancelled) to the topic, one
+// per interval.
+func produceTransactions(ctx context.Context, writer *kafka.Writer, topic string, count int, interval time.Duration) {
+	i := 0
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Producer received shutdown signal. Exiting message loop.")
+			return
+		default:
+			if count > 0 && i >= count {
+				log.Printf("Finished sending %d messages. Exiting.", count)
+				return
 			}
-		}
-	}()
-
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	<-sigChan
+			transaction := demo.GenerateDummyTransaction(i)
+			msgValue, err := json.Marshal(transaction)
+			if err != nil {
+				log.Printf("Error marshalling transaction: %v", err)
+				continue
+			}
 
-	log.Println("Shutting down producer gracefully...")
-	cancel()
-	wg.Wait()
+			msg := kafka.Message{
+				Topic: topic,
+				Key:   []byte(transaction.TransactionID),
+				Value: msgValue,
+				Time:  transaction.Timestamp,
+			}
 
-	log.Println("Producer stopped.")
-}
+			err = writer.WriteMessages(ctx, msg)
+			if err != nil {
+				log.Printf("Error writing message to Kafka: %v", err)
+				if ctx.Err() != nil {
+					return
+				}
+				time.Sleep(interval)
+				continue
+			}
 
-func generateDummyTransaction(index int) FinancialTransaction {
-	transactionTypes := []string{"purchase", "refund", "transfer", "withdrawal", "deposit"}
-	currencies := []string{"USD", "EUR", "GBP", "TRY"}
-	descriptions := []string{
-		"Online shopping", "Utility bill payment", "Salary deposit",
-		"ATM withdrawal", "Restaurant bill", "Subscription renewal",
-		"Friend payment", "Loan repayment", "Investment", "Travel expense",
+			log.Printf("Sent message %d (ID: %s, Amount: %.2f) to topic %s", i+1, transaction.TransactionID, transaction.Amount, topic)
+			i++
+			time.Sleep(interval)
+		}
 	}
+}
 
-	return FinancialTransaction{
-		TransactionID: fmt.Sprintf("TXN-%d-%s", index, randSeq(8)),
-		Amount:        float64(rand.Intn(100000)+1) / 100, // 0.01 to 1000.00
-		Currency:      currencies[rand.Intn(len(currencies))],
-		Type:          transactionTypes[rand.Intn(len(transactionTypes))],
-		Timestamp:     time.Now().Add(-time.Duration(rand.Intn(3600)) * time.Second), // Last hour
-		Description:   descriptions[rand.Intn(len(descriptions))],
-		AccountID:     fmt.Sprintf("ACC-%04d", rand.Intn(1000)+1), // 1 to 1000 accounts
+// produceOrders starts count order chains (0: until ctx is cancelled), one per interval. The
+// events of each chain are sent to the topic of their stage when they are due, so chains
+// overlap like real traffic. Once all chains are started, it waits for their last events.
+func produceOrders(ctx context.Context, writer *kafka.Writer, topics map[string]string, count int, interval time.Duration, delayScale float64) {
+	var chains sync.WaitGroup
+	defer chains.Wait()
+	for i := 0; count == 0 || i < count; i++ {
+		events := demo.GenerateOrderChain(i, time.Now(), delayScale)
+		chains.Add(1)
+		go func() {
+			defer chains.Done()
+			produceChain(ctx, writer, topics, events)
+		}()
+
+		select {
+		case <-ctx.Done():
+			log.Println("Producer received shutdown signal. Exiting message loop.")
+			return
+		case <-time.After(interval):
+		}
 	}
+	log.Printf("Started %d order chains, waiting for their last events.", count)
 }
 
-func randSeq(n int) string {
-	var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
-	b := make([]rune, n)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+// produceChain sends the events of an order chain at their timestamps, giving the rest of the
+// chain up when ctx is cancelled or an event cannot be sent.
+func produceChain(ctx context.Context, writer *kafka.Writer, topics map[string]string, events []demo.ChainEvent) {
+	for _, ev := range events {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(ev.Timestamp)):
+		}
+
+		value, err := json.Marshal(ev.Value)
+		if err != nil {
+			log.Printf("Error marshalling %s event of order %s: %v", ev.Stage, ev.Key, err)
+			return
+		}
+		msg := kafka.Message{
+			Topic: topics[ev.Stage],
+			Key:   []byte(ev.Key),
+			Value: value,
+			Time:  ev.Timestamp,
+		}
+		if err := writer.WriteMessages(ctx, msg); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error writing %s event of order %s to Kafka, dropping the rest of its chain: %v", ev.Stage, ev.Key, err)
+			}
+			return
+		}
+		log.Printf("Sent %s event of order %s to topic %s", ev.Stage, ev.Key, msg.Topic)
 	}
-	return string(b)
 }
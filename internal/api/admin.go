@@ -0,0 +1,523 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"stream-rag-agent/internal/faults"
+	"stream-rag-agent/internal/kafka"
+	"stream-rag-agent/internal/vectordb"
+	"stream-rag-agent/internal/window"
+)
+
+type SnapshotRequest struct {
+	Name string `json:"name"`
+}
+
+// Snapshot operations of a SnapshotJob.
+const (
+	SnapshotCreate  = "create"
+	SnapshotRestore = "restore"
+)
+
+// SnapshotJob reports the progress of the most recent snapshot or restore. Only one runs at
+// a time.
+type SnapshotJob struct {
+	Operation    string                 `json:"operation"` // create or restore
+	Name         string                 `json:"name"`
+	Running      bool                   `json:"running"`
+	StartedAt    time.Time              `json:"started_at"`
+	FinishedAt   *time.Time             `json:"finished_at,omitempty"`
+	Snapshot     *vectordb.SnapshotInfo `json:"snapshot,omitempty"`      // The created snapshot
+	PausedTopics []string               `json:"paused_topics,omitempty"` // Topics whose ingestion is paused for the restore
+	Error        string                 `json:"error,omitempty"`
+}
+
+type AdminResponse struct {
+	Status string      `json:"status,omitempty"`
+	Data   interface{} `json:"data,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// handleSnapshots lists snapshots of the windows index (GET) or starts creating a new one
+// (POST), see handleSnapshotStatus.
+func (s *APIServer) handleSnapshots(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		snapshots, err := s.esClient.ListSnapshots()
+		if err != nil {
+			log.Printf("Error listing snapshots: %v", err)
+			writeJSONResponse(w, errorStatus(err), AdminResponse{Error: err.Error()})
+			return
+		}
+		writeJSONResponse(w, http.StatusOK, AdminResponse{Status: "ok", Data: snapshots})
+	case http.MethodPost:
+		var req SnapshotRequest
+		if r.ContentLength > 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+		}
+		job, ok := s.startSnapshotJob(SnapshotCreate, s.esClient.SnapshotName(req.Name))
+		if !ok {
+			writeJSONResponse(w, http.StatusConflict, AdminResponse{Error: "a snapshot or restore is already running"})
+			return
+		}
+		go s.runSnapshotJob(job, func() error {
+			snapshot, err := s.esClient.CreateSnapshot(job.Name)
+			s.snapshotMu.Lock()
+			job.Snapshot = snapshot
+			s.snapshotMu.Unlock()
+			return err
+		})
+		writeJSONResponse(w, http.StatusAccepted, AdminResponse{Status: "started", Data: s.snapshotStatus()})
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSnapshotRestore starts restoring the windows index from the named snapshot, see
+// handleSnapshotStatus. Ingestion of the enabled topics is paused until the restore is done,
+// since the windows indices are closed meanwhile.
+func (s *APIServer) handleSnapshotRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SnapshotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		http.Error(w, "Request body must contain a snapshot name", http.StatusBadRequest)
+		return
+	}
+
+	job, ok := s.startSnapshotJob(SnapshotRestore, req.Name)
+	if !ok {
+		writeJSONResponse(w, http.StatusConflict, AdminResponse{Error: "a snapshot or restore is already running"})
+		return
+	}
+	paused, resume := s.ingestion.Pause(fmt.Sprintf("restoring snapshot '%s'", req.Name))
+	s.snapshotMu.Lock()
+	job.PausedTopics = paused
+	s.snapshotMu.Unlock()
+	go s.runSnapshotJob(job, func() error {
+		defer resume()
+		return s.esClient.RestoreSnapshot(job.Name)
+	})
+	writeJSONResponse(w, http.StatusAccepted, AdminResponse{Status: "started", Data: s.snapshotStatus()})
+}
+
+// handleSnapshotStatus reports the progress of the most recent snapshot or restore.
+func (s *APIServer) handleSnapshotStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := s.snapshotStatus()
+	if status == nil {
+		writeJSONResponse(w, http.StatusOK, AdminResponse{Status: "idle"})
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, AdminResponse{Status: "ok", Data: status})
+}
+
+// startSnapshotJob registers a new snapshot job, unless one is running.
+func (s *APIServer) startSnapshotJob(operation, name string) (*SnapshotJob, bool) {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+	if s.snapshotJob != nil && s.snapshotJob.Running {
+		return nil, false
+	}
+	s.snapshotJob = &SnapshotJob{Operation: operation, Name: name, Running: true, StartedAt: time.Now()}
+	return s.snapshotJob, true
+}
+
+// runSnapshotJob runs the job's operation and records its outcome.
+func (s *APIServer) runSnapshotJob(job *SnapshotJob, run func() error) {
+	err := run()
+	if err != nil {
+		log.Printf("Error running snapshot %s of '%s': %v", job.Operation, job.Name, err)
+	}
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+	now := time.Now()
+	job.Running = false
+	job.FinishedAt = &now
+	if err != nil {
+		job.Error = err.Error()
+	}
+}
+
+// snapshotStatus returns a copy of the most recent snapshot job, nil if none ran.
+func (s *APIServer) snapshotStatus() *SnapshotJob {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+	if s.snapshotJob == nil {
+		return nil
+	}
+	status := *s.snapshotJob // Copy, the job keeps updating the original
+	return &status
+}
+
+type ReembedRequest struct {
+	Topic string    `json:"topic"`
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+}
+
+// ReembedStatus reports the progress of the most recent re-embedding job.
+type ReembedStatus struct {
+	Request    ReembedRequest `json:"request"`
+	Running    bool           `json:"running"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	Processed  int            `json:"processed"`
+	Failed     int            `json:"failed"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// handleReembed starts re-embedding the stored context text of windows in a topic and time
+// range with the current embedding model (POST), or reports the last job's progress (GET).
+// Only one job runs at a time; documents are updated in place under their window ID.
+func (s *APIServer) handleReembed(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.reembedMu.Lock()
+		if s.reembedStatus == nil {
+			s.reembedMu.Unlock()
+			writeJSONResponse(w, http.StatusOK, AdminResponse{Status: "idle"})
+			return
+		}
+		status := *s.reembedStatus // Copy, the job keeps updating the original
+		s.reembedMu.Unlock()
+		writeJSONResponse(w, http.StatusOK, AdminResponse{Status: "ok", Data: status})
+	case http.MethodPost:
+		var req ReembedRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Topic == "" {
+			http.Error(w, "Topic is required", http.StatusBadRequest)
+			return
+		}
+
+		s.reembedMu.Lock()
+		if s.reembedStatus != nil && s.reembedStatus.Running {
+			s.reembedMu.Unlock()
+			writeJSONResponse(w, http.StatusConflict, AdminResponse{Error: "a re-embedding job is already running"})
+			return
+		}
+		status := &ReembedStatus{Request: req, Running: true, StartedAt: time.Now()}
+		s.reembedStatus = status
+		started := *status
+		s.reembedMu.Unlock()
+
+		go s.runReembed(req, status)
+		writeJSONResponse(w, http.StatusAccepted, AdminResponse{Status: "started", Data: started})
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *APIServer) runReembed(req ReembedRequest, status *ReembedStatus) {
+	log.Printf("Re-embedding windows of topic %s (%s - %s) with model %s", req.Topic, req.From, req.To, s.embeddingService.Model())
+	filter := &vectordb.SearchFilter{Topics: []string{req.Topic}, From: req.From, To: req.To}
+
+	endBulkLoad := s.esClient.BeginBulkLoad()
+	defer endBulkLoad()
+	err := s.esClient.ScrollWindows(filter, func(batch []window.EmbeddedWindow) error {
+		embedded := make([]window.EmbeddedWindow, 0, len(batch))
+		for i := range batch {
+			ew := &batch[i]
+			// Chunked like at ingestion, with the topic's embedding_max_chars if it has one
+			embeddingVector, chunkRanges, err := s.embeddingService.GetEmbeddingLimited(ew.ContextText, s.topics[req.Topic].EmbeddingMaxChars)
+			if err != nil {
+				log.Printf("Error re-embedding window %s: %v", ew.WindowID, err)
+				s.reembedMu.Lock()
+				status.Failed++
+				s.reembedMu.Unlock()
+				continue
+			}
+			embeddedAt := time.Now()
+			ew.Embedding = embeddingVector
+			ew.EmbeddingModel = s.embeddingService.Model()
+			ew.EmbeddingModelDigest = s.embeddingService.ModelDigest()
+			ew.EmbeddedAt = &embeddedAt
+			ew.EmbeddingChunks, ew.ChunkRanges = 0, nil
+			if len(chunkRanges) > 1 {
+				ew.EmbeddingChunks, ew.ChunkRanges = len(chunkRanges), chunkRanges
+			}
+			embedded = append(embedded, *ew)
+		}
+
+		// Rejected windows are listed by /admin/index/errors
+		failures, err := s.esClient.SaveEmbeddedWindows(embedded)
+		s.reembedMu.Lock()
+		defer s.reembedMu.Unlock()
+		if err != nil {
+			log.Printf("Error saving %d re-embedded windows: %v", len(embedded), err)
+			status.Failed += len(embedded)
+			return nil
+		}
+		status.Failed += len(failures)
+		status.Processed += len(embedded) - len(failures)
+		return nil
+	})
+
+	s.reembedMu.Lock()
+	defer s.reembedMu.Unlock()
+	now := time.Now()
+	status.Running = false
+	status.FinishedAt = &now
+	if err != nil {
+		status.Error = err.Error()
+	}
+	log.Printf("Re-embedding of topic %s finished: %d processed, %d failed", req.Topic, status.Processed, status.Failed)
+}
+
+type OffsetResetRequest struct {
+	Topic     string    `json:"topic"`
+	To        string    `json:"to"`                  // "earliest", "latest" or "timestamp"
+	Timestamp time.Time `json:"timestamp,omitempty"` // Required when To is "timestamp"
+}
+
+// handleOffsetReset resets the consumer group's offsets for a topic, pausing the topic's
+// consumer for the duration of the reset so reprocessing needs no external Kafka tooling.
+func (s *APIServer) handleOffsetReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req OffsetResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	switch req.To {
+	case kafka.ResetEarliest, kafka.ResetLatest:
+	case kafka.ResetTimestamp:
+		if req.Timestamp.IsZero() {
+			http.Error(w, "Timestamp is required when resetting to a timestamp", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "'to' must be earliest, latest or timestamp", http.StatusBadRequest)
+		return
+	}
+	consumer, ok := s.consumers[req.Topic]
+	if !ok {
+		http.Error(w, fmt.Sprintf("No Kafka consumer for topic '%s'", req.Topic), http.StatusNotFound)
+		return
+	}
+
+	offsets, err := consumer.ResetOffsets(r.Context(), req.To, req.Timestamp)
+	if err != nil {
+		log.Printf("Error resetting offsets for topic %s: %v", req.Topic, err)
+		writeJSONResponse(w, errorStatus(err), AdminResponse{Error: err.Error()})
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, AdminResponse{Status: "reset", Data: offsets})
+}
+
+// handleOffsetCoverage reports which offsets of a partition (topic, partition, from, to) are
+// covered by indexed windows, with the gaps and overlaps between them.
+func (s *APIServer) handleOffsetCoverage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	topic := query.Get("topic")
+	if topic == "" {
+		http.Error(w, "'topic' is required", http.StatusBadRequest)
+		return
+	}
+	partition, err := strconv.ParseInt(query.Get("partition"), 10, 32)
+	if err != nil || partition < 0 {
+		http.Error(w, "'partition' must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+	from, err := strconv.ParseInt(query.Get("from"), 10, 64)
+	if err != nil || from < 0 {
+		http.Error(w, "'from' must be a non-negative offset", http.StatusBadRequest)
+		return
+	}
+	to, err := strconv.ParseInt(query.Get("to"), 10, 64)
+	if err != nil || to < from {
+		http.Error(w, "'to' must be an offset not before 'from'", http.StatusBadRequest)
+		return
+	}
+
+	coverage, err := s.esClient.OffsetCoverage(topic, int32(partition), from, to)
+	if err != nil {
+		log.Printf("Error building offset coverage of topic %s, partition %d: %v", topic, partition, err)
+		writeJSONResponse(w, errorStatus(err), AdminResponse{Error: err.Error()})
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, AdminResponse{Status: "ok", Data: coverage})
+}
+
+const defaultDuplicateDistance = 3
+
+// handleDuplicates reports windows of different topics with near-identical context text,
+// optionally limited to a time range (from/to, RFC3339) and a signature distance (max_distance).
+func (s *APIServer) handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	maxDistance := defaultDuplicateDistance
+	if v := query.Get("max_distance"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 0 || d > vectordb.MaxDuplicateDistance {
+			http.Error(w, fmt.Sprintf("max_distance must be an integer between 0 and %d", vectordb.MaxDuplicateDistance), http.StatusBadRequest)
+			return
+		}
+		maxDistance = d
+	}
+	filter := &vectordb.SearchFilter{}
+	for name, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := query.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("'%s' must be an RFC3339 timestamp", name), http.StatusBadRequest)
+				return
+			}
+			*target = t
+		}
+	}
+
+	report, err := s.esClient.NearDuplicates(filter, maxDistance)
+	if err != nil {
+		log.Printf("Error building near-duplicate report: %v", err)
+		writeJSONResponse(w, errorStatus(err), AdminResponse{Error: err.Error()})
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, AdminResponse{Status: "ok", Data: report})
+}
+
+type FaultRequest struct {
+	Target          string  `json:"target"` // "ollama", "elasticsearch" or "kafka"
+	LatencyMs       int     `json:"latency_ms"`
+	ErrorRate       float64 `json:"error_rate"`
+	DurationSeconds int     `json:"duration_seconds"` // 0 keeps the fault until it is cleared
+}
+
+// handleFaults lists (GET), injects (POST) or clears (DELETE, optionally ?target=) simulated
+// dependency failures. Only available when fault injection is enabled in the config.
+func (s *APIServer) handleFaults(w http.ResponseWriter, r *http.Request) {
+	if !faults.Enabled() {
+		writeJSONResponse(w, http.StatusForbidden, AdminResponse{Error: "fault injection is disabled; set faults.enabled in the config"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSONResponse(w, http.StatusOK, AdminResponse{Status: "ok", Data: faults.List()})
+	case http.MethodPost:
+		var req FaultRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		fault := faults.Fault{Target: req.Target, LatencyMs: req.LatencyMs, ErrorRate: req.ErrorRate}
+		if req.DurationSeconds > 0 {
+			until := time.Now().Add(time.Duration(req.DurationSeconds) * time.Second)
+			fault.Until = &until
+		}
+		if err := faults.Set(fault); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Injecting fault: %+v", req)
+		writeJSONResponse(w, http.StatusOK, AdminResponse{Status: "injected", Data: fault})
+	case http.MethodDelete:
+		target := r.URL.Query().Get("target")
+		faults.Clear(target)
+		log.Printf("Cleared injected faults (target: %q)", target)
+		writeJSONResponse(w, http.StatusOK, AdminResponse{Status: "cleared"})
+	default:
+		http.Error(w, "Only GET, POST and DELETE methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+const (
+	defaultDriftPeriods       = 7
+	defaultDriftIntervalHours = 24
+)
+
+// handleIndexStats reports document counts, storage and time span of the windows index per
+// topic. With ?drift=true it also samples each topic's embedding centroid over ?periods=
+// consecutive periods of ?interval_hours= to expose distribution shifts.
+func (s *APIServer) handleIndexStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	periods, intervalHours := defaultDriftPeriods, defaultDriftIntervalHours
+	for name, target := range map[string]*int{"periods": &periods, "interval_hours": &intervalHours} {
+		if v := query.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, fmt.Sprintf("'%s' must be a positive integer", name), http.StatusBadRequest)
+				return
+			}
+			*target = n
+		}
+	}
+
+	stats, err := s.esClient.IndexStats()
+	if err != nil {
+		log.Printf("Error getting index stats: %v", err)
+		writeJSONResponse(w, errorStatus(err), AdminResponse{Error: err.Error()})
+		return
+	}
+
+	if query.Get("drift") == "true" {
+		interval := time.Duration(intervalHours) * time.Hour
+		for i := range stats.Topics {
+			drift, err := s.esClient.CentroidDrift(stats.Topics[i].Topic, periods, interval)
+			if err != nil {
+				log.Printf("Error computing centroid drift of topic %s: %v", stats.Topics[i].Topic, err)
+				writeJSONResponse(w, errorStatus(err), AdminResponse{Error: err.Error()})
+				return
+			}
+			stats.Topics[i].Drift = drift
+		}
+	}
+	writeJSONResponse(w, http.StatusOK, AdminResponse{Status: "ok", Data: stats})
+}
+
+// handleIndexErrors lists the most recent documents bulk requests failed to index, newest
+// first, with Elasticsearch's reason. ?kind= restricts them to windows or events.
+func (s *APIServer) handleIndexErrors(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	kind := r.URL.Query().Get("kind")
+	if kind != "" && kind != "window" && kind != "event" {
+		http.Error(w, "'kind' must be window or event", http.StatusBadRequest)
+		return
+	}
+	failures := []vectordb.DocumentFailure{}
+	for _, f := range s.esClient.DocumentFailures() {
+		if kind == "" || f.Kind == kind {
+			failures = append(failures, f)
+		}
+	}
+	writeJSONResponse(w, http.StatusOK, AdminResponse{Status: "ok", Data: failures})
+}
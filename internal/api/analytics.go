@@ -0,0 +1,261 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"stream-rag-agent/internal/vectordb"
+	"stream-rag-agent/internal/window"
+)
+
+const (
+	defaultAnalyticsSampleSize = 200
+	maxAnalyticsReports        = 10
+	analyticsReportPrompt      = `You review the questions users asked an assistant that answers from real-time Kafka data.
+Summarize what users are asking about: group the questions into a few themes, name the topics and entities that come up most,
+and point out questions that failed or were answered from no or few sources, since those topics likely need better context
+configuration or windowing. Be concise and use a short bulleted list per section.`
+)
+
+type queryRecordContextKey struct{}
+
+// queryRecordFrom returns the analytics record of the request for the handler to fill in.
+// When analytics are disabled, the record is discarded.
+func queryRecordFrom(ctx context.Context) *vectordb.QueryRecord {
+	if rec, ok := ctx.Value(queryRecordContextKey{}).(*vectordb.QueryRecord); ok {
+		return rec
+	}
+	return &vectordb.QueryRecord{}
+}
+
+// noteSources records the topics of the windows an answer was based on.
+func noteSources(rec *vectordb.QueryRecord, windows []window.EmbeddedWindow) {
+	rec.Sources = len(windows)
+	seen := make(map[string]bool)
+	for _, w := range windows {
+		if !seen[w.Topic] {
+			seen[w.Topic] = true
+			rec.Topics = append(rec.Topics, w.Topic)
+		}
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController flush streams and clear their write deadline.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// trackQuery records each answered question of an LLM endpoint in the queries index. The
+// handler fills in the question, mode and sources; the status and latency are taken here.
+// Requests rejected before a question was read are not recorded. With query.judge, answers
+// noted with noteAnswer are scored by the judge model before the record is saved.
+func (s *APIServer) trackQuery(next http.HandlerFunc) http.HandlerFunc {
+	if !s.queryConfig.Analytics.Enabled || s.esClient == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		rec := &vectordb.QueryRecord{Endpoint: r.URL.Path, APIKey: apiKeyFrom(r.Context()).Name}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		ctx := context.WithValue(r.Context(), queryRecordContextKey{}, rec)
+		var answer *judgedAnswer
+		if s.judgeEnabled() {
+			answer = &judgedAnswer{}
+			ctx = context.WithValue(ctx, judgedAnswerContextKey{}, answer)
+		}
+		next(recorder, r.WithContext(ctx))
+
+		if rec.Question == "" {
+			return
+		}
+		rec.Timestamp = started.UTC()
+		rec.Status = recorder.status
+		rec.Failed = recorder.status >= http.StatusBadRequest
+		rec.LatencyMs = time.Since(started).Milliseconds()
+		go func() {
+			if answer != nil && answer.answer != "" && s.sampleJudge() {
+				rec.Judge = s.judgeAnswer(answer)
+			}
+			if err := s.esClient.SaveQuery(*rec); err != nil {
+				log.Printf("Failed to record query analytics: %v", err)
+			}
+		}()
+	}
+}
+
+// AnalyticsReport is an LLM-written summary of the questions asked in a period.
+type AnalyticsReport struct {
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Questions int       `json:"questions"` // Questions the report is based on
+	Summary   string    `json:"summary"`
+}
+
+type analyticsReports struct {
+	mu      sync.Mutex
+	reports []AnalyticsReport // Newest last
+}
+
+func (a *analyticsReports) add(report AnalyticsReport) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.reports = append(a.reports, report)
+	if len(a.reports) > maxAnalyticsReports {
+		a.reports = a.reports[len(a.reports)-maxAnalyticsReports:]
+	}
+}
+
+func (a *analyticsReports) list() []AnalyticsReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]AnalyticsReport(nil), a.reports...)
+}
+
+// RunAnalyticsReports periodically asks the LLM what users have been asking about since the
+// previous report, until ctx is cancelled. It returns immediately when reports are disabled or in dev mode.
+func (s *APIServer) RunAnalyticsReports(ctx context.Context) {
+	cfg := s.queryConfig.Analytics
+	if !cfg.Enabled || cfg.ReportIntervalMinutes <= 0 || s.esClient == nil {
+		return
+	}
+	interval := time.Duration(cfg.ReportIntervalMinutes) * time.Minute
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			report, err := s.analyticsReport(now.Add(-interval), now)
+			if err != nil {
+				log.Printf("Failed to generate analytics report: %v", err)
+				continue
+			}
+			if report.Questions == 0 {
+				continue
+			}
+			s.reports.add(*report)
+			log.Printf("Analytics report for %d questions since %s generated", report.Questions, report.From.Format(time.RFC3339))
+		}
+	}
+}
+
+func (s *APIServer) analyticsReport(from, to time.Time) (*AnalyticsReport, error) {
+	sampleSize := s.queryConfig.Analytics.ReportSampleSize
+	if sampleSize <= 0 {
+		sampleSize = defaultAnalyticsSampleSize
+	}
+	records, err := s.esClient.RecentQuestions(from, sampleSize)
+	if err != nil {
+		return nil, err
+	}
+	report := &AnalyticsReport{From: from, To: to, Questions: len(records)}
+	if len(records) == 0 {
+		return report, nil
+	}
+	stats, err := s.esClient.QueryAnalytics(from, to, "1h")
+	if err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Period: %s to %s\n", from.In(s.location).Format(time.RFC3339), to.In(s.location).Format(time.RFC3339)))
+	sb.WriteString(fmt.Sprintf("Questions: %d, failed: %d\n", stats.Total, stats.Failed))
+	if len(stats.Topics) > 0 {
+		sb.WriteString("Topics answers were based on:\n")
+		for _, t := range stats.Topics {
+			sb.WriteString(fmt.Sprintf("  - %s: %d questions (%d failed)\n", t.Term, t.Count, t.Failed))
+		}
+	}
+	sb.WriteString("Questions (newest first):\n")
+	for _, rec := range records {
+		var notes []string
+		if rec.Failed {
+			notes = append(notes, "failed")
+		}
+		if rec.Sources == 0 && rec.Mode != ModeStructured {
+			notes = append(notes, "no sources")
+		}
+		if len(rec.Topics) > 0 {
+			notes = append(notes, "topics: "+strings.Join(rec.Topics, ", "))
+		}
+		line := "  - " + rec.Question
+		if len(notes) > 0 {
+			line += " [" + strings.Join(notes, "; ") + "]"
+		}
+		sb.WriteString(line + "\n")
+	}
+
+	summary, err := s.llmService.GenerateWithSystem(analyticsReportPrompt, sb.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate report: %w", err)
+	}
+	report.Summary = strings.TrimSpace(summary)
+	return report, nil
+}
+
+// AnalyticsResponse is the data of GET /admin/analytics.
+type AnalyticsResponse struct {
+	*vectordb.QueryAnalytics
+	Reports []AnalyticsReport `json:"reports,omitempty"` // Most recent periodic reports, newest last
+}
+
+// handleAnalytics aggregates the recorded queries of a period (from/to, RFC3339, defaulting
+// to the last 24 hours) into a timeline of buckets of the given interval (default "1h").
+func (s *APIServer) handleAnalytics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.queryConfig.Analytics.Enabled {
+		writeJSONResponse(w, http.StatusNotFound, AdminResponse{Error: "query analytics are disabled (query.analytics.enabled)"})
+		return
+	}
+
+	query := r.URL.Query()
+	to := time.Now().UTC()
+	from := to.Add(-24 * time.Hour)
+	for name, target := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := query.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("'%s' must be an RFC3339 timestamp", name), http.StatusBadRequest)
+				return
+			}
+			*target = t
+		}
+	}
+	interval := "1h"
+	if v := query.Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Minute {
+			http.Error(w, "'interval' must be a duration of at least 1m, such as 15m or 1h", http.StatusBadRequest)
+			return
+		}
+		interval = fmt.Sprintf("%ds", int64(d.Seconds()))
+	}
+
+	stats, err := s.esClient.QueryAnalytics(from, to, interval)
+	if err != nil {
+		log.Printf("Error aggregating query analytics: %v", err)
+		writeJSONResponse(w, errorStatus(err), AdminResponse{Error: err.Error()})
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, AdminResponse{Status: "ok", Data: AnalyticsResponse{QueryAnalytics: stats, Reports: s.reports.list()}})
+}
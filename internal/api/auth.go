@@ -0,0 +1,162 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/metrics"
+)
+
+var apiKeyDeniedTotal = metrics.NewCounter("api_key_requests_denied_total", "Requests rejected by the API key policy, by key and reason.")
+
+type apiKeyContextKey struct{}
+
+// apiKeyFrom returns the policy of the key that authenticated the request; the zero value
+// (no restrictions) when API keys are not configured.
+func apiKeyFrom(ctx context.Context) config.APIKeyConfig {
+	key, _ := ctx.Value(apiKeyContextKey{}).(config.APIKeyConfig)
+	return key
+}
+
+// modelParams are the request fields an API key policy restricts. /query, /chat and
+// /v1/chat/completions share these names.
+type modelParams struct {
+	Model     string `json:"model"`
+	Verbosity string `json:"verbosity"`
+	MaxTokens int    `json:"max_tokens"`
+}
+
+// requireAPIKey authenticates requests to an LLM endpoint against api.keys and rejects
+// models and parameters the key is not allowed to use, before the handler runs. Without
+// configured keys requests pass through unchanged.
+func (s *APIServer) requireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.apiKeys) == 0 {
+			next(w, r)
+			return
+		}
+		fail := func(status int, message string) {
+			if strings.HasPrefix(r.URL.Path, "/v1/") {
+				writeOpenAIError(w, status, "invalid_request_error", message)
+			} else {
+				http.Error(w, message, status)
+			}
+		}
+
+		key, ok := s.apiKeys[requestAPIKey(r)]
+		if !ok {
+			apiKeyDeniedTotal.Inc("key", "unknown", "reason", "unauthenticated")
+			fail(http.StatusUnauthorized, "A valid API key is required")
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				fail(http.StatusBadRequest, "Invalid request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			var params modelParams
+			_ = json.Unmarshal(body, &params) // Malformed bodies are rejected by the handler
+			if reason, err := s.checkAPIKey(key, params); err != nil {
+				apiKeyDeniedTotal.Inc("key", key.Name, "reason", reason)
+				log.Printf("API key '%s' denied on %s: %v", key.Name, r.URL.Path, err)
+				fail(http.StatusForbidden, err.Error())
+				return
+			}
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	}
+}
+
+// requireKey authenticates requests to the data endpoints (/raw, /search, /stats) and the
+// shared views and patterns against api.keys; any configured key may use them. Without configured keys requests pass through
+// unchanged.
+func (s *APIServer) requireKey(next http.HandlerFunc) http.HandlerFunc {
+	return s.authenticate(next, false)
+}
+
+// requireAdminKey authenticates requests to the /admin endpoints, which may rewind consumers,
+// restore the index or inject faults: only keys with admin: true may use them. Without
+// configured keys requests pass through unchanged.
+func (s *APIServer) requireAdminKey(next http.HandlerFunc) http.HandlerFunc {
+	return s.authenticate(next, true)
+}
+
+func (s *APIServer) authenticate(next http.HandlerFunc, admin bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.apiKeys) == 0 {
+			next(w, r)
+			return
+		}
+		key, ok := s.apiKeys[requestAPIKey(r)]
+		if !ok {
+			apiKeyDeniedTotal.Inc("key", "unknown", "reason", "unauthenticated")
+			http.Error(w, "A valid API key is required", http.StatusUnauthorized)
+			return
+		}
+		if admin && !key.Admin {
+			apiKeyDeniedTotal.Inc("key", key.Name, "reason", "admin")
+			log.Printf("API key '%s' denied on %s: not an admin key", key.Name, r.URL.Path)
+			http.Error(w, fmt.Sprintf("API key '%s' may not use the admin endpoints", key.Name), http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	}
+}
+
+// checkAPIKey validates the requested model and parameters against the key's allowlists,
+// returning the denial reason used as the metric label.
+func (s *APIServer) checkAPIKey(key config.APIKeyConfig, params modelParams) (string, error) {
+	model := params.Model
+	if model == "" {
+		model = s.llmService.Model()
+	}
+	allowed := contains(key.Models, model) || (len(key.Models) == 0 && model == s.llmService.Model())
+	if !allowed {
+		return "model", fmt.Errorf("API key '%s' may not use model '%s'", key.Name, model)
+	}
+
+	verbosity := params.Verbosity
+	if verbosity == "" {
+		verbosity = s.queryConfig.Verbosity.Default
+	}
+	if verbosity == "" {
+		verbosity = VerbosityNormal
+	}
+	if len(key.Verbosities) > 0 && !contains(key.Verbosities, verbosity) {
+		return "verbosity", fmt.Errorf("API key '%s' may not request verbosity '%s'", key.Name, verbosity)
+	}
+
+	if key.MaxTokens > 0 && params.MaxTokens > key.MaxTokens {
+		return "max_tokens", fmt.Errorf("API key '%s' may request at most %d tokens", key.Name, key.MaxTokens)
+	}
+	return "", nil
+}
+
+// requestAPIKey reads the key from "Authorization: Bearer <key>" or the X-API-Key header.
+func requestAPIKey(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
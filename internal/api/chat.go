@@ -0,0 +1,298 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/llm"
+	"stream-rag-agent/internal/window"
+)
+
+const (
+	defaultSessionDecay      = 0.5
+	defaultSessionMinWeight  = 0.2
+	defaultSessionMaxWindows = 8
+	defaultSessionTTLMinutes = 30
+	sessionMaxHistory        = 20 // Messages of history forwarded to the LLM
+)
+
+type ChatRequest struct {
+	SessionID  string `json:"session_id,omitempty"` // Omit to start a new session
+	Message    string `json:"message"`
+	View       string `json:"view,omitempty"`
+	Model      string `json:"model,omitempty"`       // LLM model from ollama.models, empty uses the configured one
+	DeadlineMs int    `json:"deadline_ms,omitempty"` // Response time budget, see QueryRequest
+}
+
+type ChatResponse struct {
+	SessionID string          `json:"session_id"`
+	Answer    string          `json:"answer"`
+	Sources   []SourceWindow  `json:"sources,omitempty"`
+	Citations *CitationReport `json:"citations,omitempty"` // See QueryResponse
+	Deadline  *DeadlineReport `json:"deadline,omitempty"`
+	Degraded  bool            `json:"degraded,omitempty"` // Context was found by keyword search, see QueryResponse
+	Error     string          `json:"error,omitempty"`
+}
+
+// rememberedWindow is a window retrieved in an earlier turn. Its weight starts at 1 and
+// decays every turn it is not retrieved again.
+type rememberedWindow struct {
+	window window.EmbeddedWindow
+	weight float64
+}
+
+type chatSession struct {
+	workspace string // Workspace the session was started in, empty outside of workspaces
+	history   []llm.ChatMessage
+	windows   map[string]*rememberedWindow
+	lastUsed  time.Time
+}
+
+// sessionStore keeps chat sessions in memory; idle sessions expire after the configured TTL.
+type sessionStore struct {
+	cfg      config.SessionsConfig
+	mu       sync.Mutex
+	sessions map[string]*chatSession
+}
+
+func newSessionStore(cfg config.SessionsConfig) *sessionStore {
+	if cfg.Decay <= 0 || cfg.Decay > 1 {
+		cfg.Decay = defaultSessionDecay
+	}
+	if cfg.MinWeight <= 0 {
+		cfg.MinWeight = defaultSessionMinWeight
+	}
+	if cfg.MaxWindows <= 0 {
+		cfg.MaxWindows = defaultSessionMaxWindows
+	}
+	if cfg.TTLMinutes <= 0 {
+		cfg.TTLMinutes = defaultSessionTTLMinutes
+	}
+	return &sessionStore{cfg: cfg, sessions: make(map[string]*chatSession)}
+}
+
+// get returns the session of the workspace with the given ID, creating one (with a new ID if
+// empty) when it does not exist or has expired. Sessions of other workspaces are not
+// continued: a new session with a new ID is started instead.
+func (st *sessionStore) get(id, workspace string) (string, *chatSession) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.expireLocked()
+
+	if s, ok := st.sessions[id]; ok && s.workspace != workspace {
+		id = ""
+	}
+	if id == "" {
+		id = newSessionID()
+	}
+	s, ok := st.sessions[id]
+	if !ok {
+		s = &chatSession{workspace: workspace, windows: make(map[string]*rememberedWindow)}
+		st.sessions[id] = s
+	}
+	s.lastUsed = time.Now()
+	return id, s
+}
+
+// count returns how many sessions of the workspace have not expired.
+func (st *sessionStore) count(workspace string) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.expireLocked()
+	n := 0
+	for _, s := range st.sessions {
+		if s.workspace == workspace {
+			n++
+		}
+	}
+	return n
+}
+
+// dropWorkspace ends the sessions of a workspace and returns how many there were.
+func (st *sessionStore) dropWorkspace(workspace string) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	n := 0
+	for sid, s := range st.sessions {
+		if s.workspace == workspace {
+			delete(st.sessions, sid)
+			n++
+		}
+	}
+	return n
+}
+
+// expireLocked removes the sessions idle for longer than the TTL. Callers hold st.mu.
+func (st *sessionStore) expireLocked() {
+	ttl := time.Duration(st.cfg.TTLMinutes) * time.Minute
+	for sid, s := range st.sessions {
+		if time.Since(s.lastUsed) > ttl {
+			delete(st.sessions, sid)
+		}
+	}
+}
+
+// remember decays the windows retrieved in earlier turns, adds the freshly retrieved ones at
+// full weight and returns the session's context for this turn, highest weight first.
+func (st *sessionStore) remember(s *chatSession, fresh []window.EmbeddedWindow) []window.EmbeddedWindow {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for id, rw := range s.windows {
+		rw.weight *= st.cfg.Decay
+		if rw.weight < st.cfg.MinWeight {
+			delete(s.windows, id)
+		}
+	}
+	for _, w := range fresh {
+		s.windows[w.WindowID] = &rememberedWindow{window: w, weight: 1}
+	}
+
+	remembered := make([]*rememberedWindow, 0, len(s.windows))
+	for _, rw := range s.windows {
+		remembered = append(remembered, rw)
+	}
+	sort.Slice(remembered, func(i, j int) bool { return remembered[i].weight > remembered[j].weight })
+	if len(remembered) > st.cfg.MaxWindows {
+		for _, rw := range remembered[st.cfg.MaxWindows:] {
+			delete(s.windows, rw.window.WindowID)
+		}
+		remembered = remembered[:st.cfg.MaxWindows]
+	}
+
+	windows := make([]window.EmbeddedWindow, 0, len(remembered))
+	for _, rw := range remembered {
+		windows = append(windows, rw.window)
+	}
+	return windows
+}
+
+// record appends a turn to the session history, keeping the most recent messages.
+func (st *sessionStore) record(s *chatSession, messages ...llm.ChatMessage) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s.history = append(s.history, messages...)
+	if len(s.history) > sessionMaxHistory {
+		s.history = s.history[len(s.history)-sessionMaxHistory:]
+	}
+}
+
+// lastQuestion returns the previous user message of the session, if any.
+func (st *sessionStore) lastQuestion(s *chatSession) string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if s.history[i].Role == "user" {
+			return s.history[i].Content
+		}
+	}
+	return ""
+}
+
+func newSessionID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+// handleChat answers a message within a server-side session. Follow-up turns are retrieved
+// with the previous question as additional context, and windows retrieved in earlier turns
+// stay in the context with a decaying weight, so short follow-ups ("and for EUR?") keep
+// the relevant data.
+func (s *APIServer) handleChat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Message == "" {
+		http.Error(w, "Message cannot be empty", http.StatusBadRequest)
+		return
+	}
+	rec := queryRecordFrom(r.Context())
+	rec.Question = req.Message
+	rec.Mode = ModeRAG
+	filter, err := s.viewFilter(workspaceFrom(r.Context()), req.View)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	style, err := s.answerStyle("", "", req.Model)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	style.limitTokens(apiKeyFrom(r.Context()).MaxTokens)
+	rec.Model = style.egress.Model
+	budget, err := s.queryBudget(r.Header.Get(deadlineHeader), req.DeadlineMs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sessionID, session := s.sessions.get(req.SessionID, workspaceFrom(r.Context()))
+	log.Printf("Received chat message in session %s: %s", sessionID, req.Message)
+
+	question, _ := s.translateQuery(req.Message)
+	retrievalQuery := question
+	if previous := s.sessions.lastQuestion(session); previous != "" {
+		retrievalQuery = previous + "\n" + question
+	}
+	var keywordOnly bool
+	fresh, err := budget.retrieve(r.Context(), func(ctx context.Context) ([]window.EmbeddedWindow, error) {
+		windows, keyword, err := s.retrieveContext(ctx, retrievalQuery, style.scope(filter), s.queryConfig.Expansion.Enabled, nil)
+		keywordOnly = keyword
+		return windows, err
+	})
+	if err != nil {
+		log.Printf("Error retrieving context for chat message '%s': %v", req.Message, err)
+		writeJSONResponse(w, retrievalErrorStatus(err), ChatResponse{SessionID: sessionID, Error: retrievalErrorMessage(err), Deadline: budget.deadlineReport()})
+		return
+	}
+	contextWindows := style.allowed(s.sessions.remember(session, fresh))
+	contextWindows, err = s.fitGeneration(budget, &style, contextWindows)
+	if err != nil {
+		log.Printf("Not generating a chat answer to '%s': %v", req.Message, err)
+		writeJSONResponse(w, generationErrorStatus(err), ChatResponse{SessionID: sessionID, Error: generationErrorMessage(err), Deadline: budget.deadlineReport()})
+		return
+	}
+	rec.Model = style.egress.Model
+	noteSources(rec, contextWindows)
+
+	systemPrompt := buildSystemPrompt(s.llmService.SystemPrompt(), contextWindows, style)
+
+	s.sessions.mu.Lock()
+	messages := append([]llm.ChatMessage{{Role: "system", Content: systemPrompt}}, session.history...)
+	s.sessions.mu.Unlock()
+	userMessage := llm.ChatMessage{Role: "user", Content: req.Message}
+	messages = append(messages, userMessage)
+
+	answer, err := s.llmService.ChatWithOptions(r.Context(), messages, style.options())
+	if err != nil {
+		log.Printf("Error generating chat answer: %v", err)
+		writeJSONResponse(w, generationErrorStatus(err), ChatResponse{SessionID: sessionID, Error: generationErrorMessage(err), Deadline: budget.deadlineReport()})
+		return
+	}
+	answer, citations := s.checkCitations(answer, contextWindows, style)
+	// Later turns number their windows anew
+	s.sessions.record(session, userMessage, llm.ChatMessage{Role: "assistant", Content: withoutCitations(answer)})
+	noteAnswer(r.Context(), question, answer, contextWindows)
+
+	answer = s.withFootnotes(answer, contextWindows, style)
+	writeJSONResponse(w, http.StatusOK, ChatResponse{SessionID: sessionID, Answer: answer, Sources: s.sourceWindows(contextWindows), Citations: citations, Deadline: budget.deadlineReport(), Degraded: keywordOnly})
+}
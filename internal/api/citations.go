@@ -0,0 +1,102 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"stream-rag-agent/internal/metrics"
+	"stream-rag-agent/internal/window"
+)
+
+// What happens to citations of windows that were not retrieved, see query.citations.on_invalid.
+const (
+	CitationsStrip = "strip" // Removed from the answer
+	CitationsFlag  = "flag"  // Kept in the answer and listed as invalid
+)
+
+const citationInstruction = "Cite the windows each statement is based on right after it, with the window markers shown below, e.g. [W1] or [W1][W3]. Only cite markers listed below, and do not cite a window for statements it does not support."
+
+var (
+	// citationPattern matches citation markers with the blanks before them, including lists
+	// such as [W1, W3]
+	citationPattern = regexp.MustCompile(`[ \t]*\[W\d+(?:\s*,\s*W?\d+)*\]`)
+	citationNumber  = regexp.MustCompile(`\d+`)
+
+	invalidCitationsTotal = metrics.NewCounter("answer_invalid_citations_total", "Citation markers in answers that name no window given to the LLM, by action (strip or flag).")
+)
+
+// CitationReport maps the citation markers of an answer to the windows they stand for.
+type CitationReport struct {
+	Markers map[string]SourceWindow `json:"markers"`           // Cited markers, e.g. W1, with their window
+	Invalid []string                `json:"invalid,omitempty"` // Cited markers of no window given to the LLM; stripped from the answer unless query.citations.on_invalid is flag
+	Uncited bool                    `json:"uncited,omitempty"` // The answer cites no window although it was given some
+}
+
+// citationMarker returns the marker of the i-th (from 0) window of a prompt.
+func citationMarker(i int) string {
+	return "W" + strconv.Itoa(i+1)
+}
+
+// checkCitations validates the citation markers of an answer generated from windows with
+// citations requested. Lists like [W1, W3] are rewritten as [W1][W3]; markers of windows that
+// were not given to the LLM are stripped, or kept and reported with on_invalid flag. It
+// returns the answer and its report, nil when citations were not requested.
+func (s *APIServer) checkCitations(answer string, windows []window.EmbeddedWindow, style answerStyle) (string, *CitationReport) {
+	if !style.citations || len(windows) == 0 {
+		return answer, nil
+	}
+	flag := s.queryConfig.Citations.OnInvalid == CitationsFlag
+	action := CitationsStrip
+	if flag {
+		action = CitationsFlag
+	}
+	sources := s.sourceWindows(windows)
+	report := &CitationReport{Markers: make(map[string]SourceWindow)}
+	invalid := make(map[string]bool)
+
+	answer = citationPattern.ReplaceAllStringFunc(answer, func(match string) string {
+		var sb strings.Builder
+		list := strings.TrimLeft(match, " \t")
+		for _, digits := range citationNumber.FindAllString(list, -1) {
+			n, err := strconv.Atoi(digits)
+			marker := "W" + digits
+			if err != nil || n < 1 || n > len(windows) {
+				if !invalid[marker] {
+					invalid[marker] = true
+					report.Invalid = append(report.Invalid, marker)
+				}
+				invalidCitationsTotal.Inc("action", action)
+				if !flag {
+					continue
+				}
+			} else {
+				marker = citationMarker(n - 1)
+				report.Markers[marker] = sources[n-1]
+			}
+			sb.WriteString("[" + marker + "]")
+		}
+		if sb.Len() == 0 {
+			return "" // All stripped, with the blanks before them
+		}
+		return match[:len(match)-len(list)] + sb.String()
+	})
+	if len(report.Invalid) > 0 {
+		log.Printf("Answer cites windows that were not retrieved: %s (%s)", strings.Join(report.Invalid, ", "), action)
+	}
+	report.Uncited = len(report.Markers) == 0
+	return answer, report
+}
+
+// withoutCitations removes the citation markers of an answer, e.g. before it is kept in a chat
+// session whose later prompts number their windows differently.
+func withoutCitations(answer string) string {
+	return citationPattern.ReplaceAllString(answer, "")
+}
+
+// citesWindow reports whether an answer cites the i-th (from 0) window of its prompt by marker.
+func citesWindow(answer string, i int) bool {
+	return strings.Contains(answer, fmt.Sprintf("[%s]", citationMarker(i)))
+}
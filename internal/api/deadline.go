@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"stream-rag-agent/internal/window"
+)
+
+const (
+	deadlineHeader               = "X-Deadline-Ms"
+	defaultRetrievalShare        = 0.3
+	defaultMinGenerationMs       = 1000
+	defaultReducedContextWindows = 2
+
+	DegradedFastModel      = "fast_model"      // Generation switched to query.deadline.fast_model
+	DegradedReducedContext = "reduced_context" // Fewer windows were put into the prompt
+	DegradedNoValidation   = "no_validation"   // Numeric validation was skipped for lack of time
+)
+
+var errDeadlineExceeded = errors.New("query deadline exceeded")
+
+// DeadlineReport describes how the deadline of a query was spent.
+type DeadlineReport struct {
+	DeadlineMs   int64    `json:"deadline_ms"`
+	RetrievalMs  int64    `json:"retrieval_ms"`       // Time retrieval took
+	GenerationMs int64    `json:"generation_ms"`      // Time left for generation when it started
+	Degraded     []string `json:"degraded,omitempty"` // What was cut to meet the deadline
+}
+
+// queryBudget splits the deadline of a query between retrieval and generation: retrieval
+// may use its share, generation gets the rest. When retrieval overruns its share, generation
+// is degraded (faster model, smaller context) instead of missing the deadline.
+type queryBudget struct {
+	start     time.Time
+	deadline  time.Time
+	retrieval time.Duration // Share of the deadline retrieval may use
+	minGen    time.Duration // Time generation needs at least
+	report    DeadlineReport
+}
+
+// queryBudget returns the budget of a request from its deadline_ms field, the X-Deadline-Ms
+// header or query.deadline.default_ms, in that order; nil when the query has no deadline.
+// header is the value of the X-Deadline-Ms header, empty if it was not sent.
+func (s *APIServer) queryBudget(header string, deadlineMs int) (*queryBudget, error) {
+	cfg := s.queryConfig.Deadline
+	if deadlineMs == 0 {
+		if header != "" {
+			n, err := strconv.Atoi(header)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be a number of milliseconds", deadlineHeader)
+			}
+			deadlineMs = n
+		}
+	}
+	if deadlineMs == 0 {
+		deadlineMs = cfg.DefaultMs
+	}
+	if deadlineMs < 0 {
+		return nil, fmt.Errorf("deadline must not be negative")
+	}
+	if deadlineMs == 0 {
+		return nil, nil
+	}
+
+	share := cfg.RetrievalShare
+	if share <= 0 || share >= 1 {
+		share = defaultRetrievalShare
+	}
+	minGen := cfg.MinGenerationMs
+	if minGen <= 0 {
+		minGen = defaultMinGenerationMs
+	}
+	total := time.Duration(deadlineMs) * time.Millisecond
+	start := time.Now()
+	return &queryBudget{
+		start:     start,
+		deadline:  start.Add(total),
+		retrieval: time.Duration(float64(total) * share),
+		minGen:    time.Duration(minGen) * time.Millisecond,
+		report:    DeadlineReport{DeadlineMs: int64(deadlineMs)},
+	}, nil
+}
+
+// retrieve runs the retrieval with a context that ends when it would leave generation less
+// than its minimum time, so the embedding, expansion and search calls fn makes are cancelled
+// then; it returns errDeadlineExceeded without waiting for fn to notice. Without a budget it
+// simply runs fn with ctx.
+func (b *queryBudget) retrieve(ctx context.Context, fn func(context.Context) ([]window.EmbeddedWindow, error)) ([]window.EmbeddedWindow, error) {
+	if b == nil {
+		return fn(ctx)
+	}
+	ctx, cancel := context.WithDeadline(ctx, b.deadline.Add(-b.minGen))
+	defer cancel()
+	type result struct {
+		windows []window.EmbeddedWindow
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		windows, err := fn(ctx)
+		done <- result{windows, err}
+	}()
+
+	select {
+	case res := <-done:
+		b.report.RetrievalMs = time.Since(b.start).Milliseconds()
+		if res.err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: retrieval took longer than %dms", errDeadlineExceeded, b.report.RetrievalMs)
+		}
+		return res.windows, res.err
+	case <-ctx.Done():
+		b.report.RetrievalMs = time.Since(b.start).Milliseconds()
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: retrieval took longer than %dms", errDeadlineExceeded, b.report.RetrievalMs)
+	}
+}
+
+// overran reports whether retrieval used more than its share of the deadline.
+func (b *queryBudget) overran() bool {
+	return b != nil && time.Duration(b.report.RetrievalMs)*time.Millisecond > b.retrieval
+}
+
+// remaining returns the time left until the deadline.
+func (b *queryBudget) remaining() time.Duration {
+	return time.Until(b.deadline)
+}
+
+func (b *queryBudget) degraded(what string) {
+	b.report.Degraded = append(b.report.Degraded, what)
+}
+
+// fitGeneration prepares generation for the rest of the budget: when retrieval overran its
+// share, it switches to the configured fast model and keeps only the best windows. The
+// generation call is bounded by the time left. It returns the windows to put in the prompt,
+// or errDeadlineExceeded if no time is left to generate.
+func (s *APIServer) fitGeneration(b *queryBudget, style *answerStyle, windows []window.EmbeddedWindow) ([]window.EmbeddedWindow, error) {
+	if b == nil {
+		return windows, nil
+	}
+	if b.overran() {
+		cfg := s.queryConfig.Deadline
+		if fast := cfg.FastModel; fast != "" && fast != style.egress.Model && s.llmService.HasModel(fast) {
+			log.Printf("Query deadline: retrieval took %dms, generating with fast model '%s'", b.report.RetrievalMs, fast)
+			style.model = fast
+			style.egress = s.egress.Decide(fast)
+			windows = style.allowed(windows)
+			b.degraded(DegradedFastModel)
+		}
+		keep := cfg.ReducedContextWindows
+		if keep <= 0 {
+			keep = defaultReducedContextWindows
+		}
+		if len(windows) > keep {
+			windows = windows[:keep]
+			b.degraded(DegradedReducedContext)
+		}
+	}
+	style.timeout = b.remaining()
+	if style.timeout <= 0 {
+		// A zero timeout would leave generation unbounded
+		return nil, fmt.Errorf("%w: no time left to generate the answer", errDeadlineExceeded)
+	}
+	b.report.GenerationMs = style.timeout.Milliseconds()
+	return windows, nil
+}
+
+// expired reports whether no time is left for optional steps after generation.
+func (b *queryBudget) expired() bool {
+	return b != nil && b.remaining() <= 0
+}
+
+// deadlineReport returns the report for the response, nil without a budget.
+func (b *queryBudget) deadlineReport() *DeadlineReport {
+	if b == nil {
+		return nil
+	}
+	return &b.report
+}
@@ -0,0 +1,15 @@
+package api
+
+import "net/http"
+
+// requireElasticsearch rejects requests to endpoints that query Elasticsearch directly
+// (keyword search, snapshots, re-embedding, index statistics, analytics) when the agent runs
+// in dev mode with the local window store.
+func (s *APIServer) requireElasticsearch(next http.HandlerFunc) http.HandlerFunc {
+	if s.esClient != nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSONResponse(w, http.StatusNotImplemented, AdminResponse{Error: "not available in dev mode: this endpoint needs Elasticsearch"})
+	}
+}
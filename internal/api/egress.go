@@ -0,0 +1,40 @@
+package api
+
+import (
+	"stream-rag-agent/internal/vectordb"
+	"stream-rag-agent/internal/window"
+)
+
+// scope adds the topics the egress policy withholds from the answering model and the
+// recency decay to a filter.
+func (a answerStyle) scope(filter *vectordb.SearchFilter) *vectordb.SearchFilter {
+	if len(a.egress.ExcludeTopics) == 0 && a.recency == nil {
+		return filter
+	}
+	scoped := vectordb.SearchFilter{}
+	if filter != nil {
+		scoped = *filter
+	}
+	if len(a.egress.ExcludeTopics) > 0 {
+		scoped.ExcludeTopics = append(append([]string(nil), scoped.ExcludeTopics...), a.egress.ExcludeTopics...)
+	}
+	if a.recency != nil {
+		scoped.Recency = a.recency
+	}
+	return &scoped
+}
+
+// allowed drops windows of topics the egress policy withholds, e.g. windows a chat session
+// retrieved in an earlier turn answered by a local model.
+func (a answerStyle) allowed(windows []window.EmbeddedWindow) []window.EmbeddedWindow {
+	if len(a.egress.ExcludeTopics) == 0 {
+		return windows
+	}
+	kept := make([]window.EmbeddedWindow, 0, len(windows))
+	for _, w := range windows {
+		if a.egress.Allows(w.Topic) {
+			kept = append(kept, w)
+		}
+	}
+	return kept
+}
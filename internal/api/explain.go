@@ -0,0 +1,242 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"stream-rag-agent/internal/vectordb"
+	"stream-rag-agent/internal/window"
+)
+
+// Retrieval methods reported by explain.
+const (
+	retrievalVector  = "vector"  // Similarity search with the embedded question
+	retrievalKeyword = "keyword" // BM25 search, because the question could not be embedded
+	retrievalLatest  = "latest"  // The latest windows, for tail questions
+)
+
+// charsPerToken roughly converts prompt characters into LLM tokens for English text.
+const charsPerToken = 4
+
+// QueryExplanation tells why the windows of a query's context were chosen and how its prompt
+// was assembled, so retrieval can be tuned by inspection. It is returned for explain on /query.
+type QueryExplanation struct {
+	Retrieval RetrievalExplanation `json:"retrieval"`
+	Windows   []WindowExplanation  `json:"windows"` // Retrieved windows in rank order, including those left out of the prompt
+	Prompt    PromptExplanation    `json:"prompt"`
+}
+
+// RetrievalExplanation describes how windows were retrieved.
+type RetrievalExplanation struct {
+	Method          string              `json:"method"`                   // vector, keyword or latest
+	Queries         []string            `json:"queries,omitempty"`        // Texts searched: the question, then its paraphrases from query expansion
+	K               int                 `json:"k"`                        // Windows retrieved per query
+	NumCandidates   int                 `json:"num_candidates,omitempty"` // kNN candidates per shard, Elasticsearch vector search only
+	Recency         *RecencyExplanation `json:"recency,omitempty"`        // Decay results were reranked with, absent without decay
+	Topics          []string            `json:"topics,omitempty"`         // Filters of the view and the request
+	ExcludedTopics  []string            `json:"excluded_topics,omitempty"`
+	Entities        []string            `json:"entities,omitempty"`
+	Categories      []string            `json:"categories,omitempty"`
+	EmbeddingModels []string            `json:"embedding_models,omitempty"`
+	From            *time.Time          `json:"from,omitempty"`
+	To              *time.Time          `json:"to,omitempty"`
+	MinOverlap      float64             `json:"min_overlap,omitempty"` // Share of a window's offsets in a better-ranked window above which it is left out, absent when all are kept
+}
+
+// RecencyExplanation is the recency decay of a query, see query.recency.
+type RecencyExplanation struct {
+	ScaleMinutes  int     `json:"scale_minutes"`
+	OffsetMinutes int     `json:"offset_minutes,omitempty"`
+	Decay         float64 `json:"decay"`
+	Weight        float64 `json:"weight"`
+}
+
+// WindowExplanation tells where a retrieved window ranked and whether it reached the prompt.
+type WindowExplanation struct {
+	WindowID       string                `json:"window_id"`
+	Topic          string                `json:"topic"`
+	Rank           int                   `json:"rank"`                      // Position in the retrieval results, from 1
+	Score          *vectordb.WindowScore `json:"score,omitempty"`           // Similarity, recency factor and final score; absent for the latest windows
+	MatchedQueries int                   `json:"matched_queries,omitempty"` // Queries whose results held the window, with query expansion
+	EntityMatches  []string              `json:"entity_matches,omitempty"`  // Entities of the filter the window mentions
+	Included       bool                  `json:"included"`
+	Reason         string                `json:"reason,omitempty"`       // Why the window was left out of the prompt
+	PromptChars    int                   `json:"prompt_chars,omitempty"` // Characters of its context text in the prompt
+}
+
+// PromptExplanation describes how the prompt was budgeted.
+type PromptExplanation struct {
+	Model           string `json:"model"`
+	Windows         int    `json:"windows"`                     // Windows in the prompt
+	ContextChars    int    `json:"context_chars"`               // Context text of those windows
+	OtherChars      int    `json:"other_chars"`                 // Instructions, window headers and the question
+	EstimatedTokens int    `json:"estimated_tokens"`            // Of the whole prompt, at about four characters per token
+	MaxAnswerTokens int    `json:"max_answer_tokens,omitempty"` // Cap on generated tokens from the verbosity or the API key, absent if none
+	TimeoutMs       int64  `json:"timeout_ms,omitempty"`        // Time left for generation by the query's deadline, absent without one
+}
+
+// explainer collects the decisions of a query's retrieval. A nil explainer, used when the
+// query is not explained, ignores them.
+type explainer struct {
+	filter  *vectordb.SearchFilter
+	search  vectordb.SearchExplanation
+	method  string
+	queries []string
+	k       int
+	matched map[string]int          // Window ID -> queries whose results held it
+	ranked  []window.EmbeddedWindow // Retrieval results before overlapping windows were left out
+	leftOut map[string]string       // Window ID -> why it was left out of the prompt
+}
+
+func newExplainer(enabled bool) *explainer {
+	if !enabled {
+		return nil
+	}
+	return &explainer{matched: make(map[string]int), leftOut: make(map[string]string)}
+}
+
+// scope returns the filter to search with, recording the scores of the windows it returns.
+func (e *explainer) scope(filter *vectordb.SearchFilter) *vectordb.SearchFilter {
+	if e == nil {
+		return filter
+	}
+	scoped := vectordb.SearchFilter{}
+	if filter != nil {
+		scoped = *filter
+	}
+	scoped.Explain = &e.search
+	e.filter = &scoped
+	return e.filter
+}
+
+// searched records how windows are retrieved.
+func (e *explainer) searched(method string, queries []string, k int) {
+	if e == nil {
+		return
+	}
+	e.method, e.queries, e.k = method, queries, k
+}
+
+// retrieved counts the windows one query returned.
+func (e *explainer) retrieved(windows []window.EmbeddedWindow) {
+	if e == nil {
+		return
+	}
+	for _, w := range windows {
+		e.matched[w.WindowID]++
+	}
+}
+
+// rank records the retrieval results in rank order.
+func (e *explainer) rank(windows []window.EmbeddedWindow) {
+	if e == nil {
+		return
+	}
+	e.ranked = windows
+}
+
+// leaveOut records why a retrieved window is not in the prompt.
+func (e *explainer) leaveOut(windowID, reason string) {
+	if e == nil {
+		return
+	}
+	e.leftOut[windowID] = reason
+}
+
+// fitted records the windows fitGeneration left out.
+func (e *explainer) fitted(before, after []window.EmbeddedWindow, style answerStyle) {
+	if e == nil {
+		return
+	}
+	kept := make(map[string]bool, len(after))
+	for _, w := range after {
+		kept[w.WindowID] = true
+	}
+	for _, w := range before {
+		switch {
+		case kept[w.WindowID]:
+		case !style.egress.Allows(w.Topic):
+			e.leaveOut(w.WindowID, fmt.Sprintf("withheld from model %s by the egress policy", style.egress.Model))
+		default:
+			e.leaveOut(w.WindowID, "left out to meet the query deadline")
+		}
+	}
+}
+
+// explain builds the explanation of a query answered from windows with systemPrompt and
+// question, or returns nil if the query is not explained.
+func (e *explainer) explain(s *APIServer, style answerStyle, windows []window.EmbeddedWindow, systemPrompt, question string) *QueryExplanation {
+	if e == nil {
+		return nil
+	}
+	ex := &QueryExplanation{
+		Retrieval: RetrievalExplanation{Method: e.method, Queries: e.queries, K: e.k},
+		Windows:   make([]WindowExplanation, 0, len(e.ranked)),
+	}
+	r := &ex.Retrieval
+	if e.method == retrievalVector && s.esClient != nil {
+		r.NumCandidates = s.esClient.NumCandidates(e.k)
+	}
+	if f := e.filter; f != nil {
+		r.Topics, r.ExcludedTopics, r.Entities, r.Categories, r.EmbeddingModels = f.Topics, f.ExcludeTopics, f.Entities, f.Categories, f.EmbeddingModels
+		if !f.From.IsZero() {
+			r.From = &f.From
+		}
+		if !f.To.IsZero() {
+			r.To = &f.To
+		}
+		if d := f.Recency; d != nil {
+			r.Recency = &RecencyExplanation{ScaleMinutes: int(d.Scale / time.Minute), OffsetMinutes: int(d.Offset / time.Minute), Decay: d.Decay, Weight: d.Weight}
+		}
+	}
+	if e.method != retrievalLatest && len(e.ranked) > 1 {
+		if minOverlap := s.queryConfig.Overlap.MinOverlap; minOverlap >= 0 {
+			if minOverlap == 0 || minOverlap > 1 {
+				minOverlap = defaultMinOverlap
+			}
+			r.MinOverlap = minOverlap
+		}
+	}
+
+	included := make(map[string]bool, len(windows))
+	for _, w := range windows {
+		included[w.WindowID] = true
+	}
+	for i, w := range e.ranked {
+		we := WindowExplanation{WindowID: w.WindowID, Topic: w.Topic, Rank: i + 1, Included: included[w.WindowID]}
+		if score, ok := e.search.Scores[w.WindowID]; ok {
+			we.Score = &score
+		}
+		if len(e.queries) > 1 {
+			we.MatchedQueries = e.matched[w.WindowID]
+		}
+		if e.filter != nil {
+			text := strings.ToLower(w.ContextText)
+			for _, entity := range e.filter.Entities {
+				if strings.Contains(text, strings.ToLower(entity)) {
+					we.EntityMatches = append(we.EntityMatches, entity)
+				}
+			}
+		}
+		if we.Included {
+			we.PromptChars = len(w.ContextText)
+		} else {
+			we.Reason = e.leftOut[w.WindowID]
+		}
+		ex.Windows = append(ex.Windows, we)
+	}
+
+	p := &ex.Prompt
+	p.Model = style.egress.Model
+	p.Windows = len(windows)
+	for _, w := range windows {
+		p.ContextChars += len(w.ContextText)
+	}
+	total := len(systemPrompt) + len(question)
+	p.OtherChars = total - p.ContextChars
+	p.EstimatedTokens = total / charsPerToken
+	p.MaxAnswerTokens = max(style.maxTokens, 0)
+	p.TimeoutMs = style.timeout.Milliseconds()
+	return ex
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"stream-rag-agent/pkg/errs"
+)
+
+// IngestionRequest switches the ingestion of a topic.
+type IngestionRequest struct {
+	State  string `json:"state"`            // enabled, skip or paused
+	Reason string `json:"reason,omitempty"` // Why, e.g. "upstream sends corrupt payloads"
+}
+
+func (s *APIServer) requireIngestion(next http.HandlerFunc) http.HandlerFunc {
+	if s.ingestion != nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Ingestion control is only available while the agent consumes topics", http.StatusNotFound)
+	}
+}
+
+// handleIngestion lists the ingestion state of every topic.
+func (s *APIServer) handleIngestion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, AdminResponse{Status: "ok", Data: s.ingestion.List()})
+}
+
+// handleTopicIngestion returns (GET) or switches (PUT) the ingestion state of a topic. The
+// state is persisted, so it survives restarts.
+func (s *APIServer) handleTopicIngestion(w http.ResponseWriter, r *http.Request) {
+	topic := r.PathValue("topic")
+	switch r.Method {
+	case http.MethodGet:
+		state, err := s.ingestion.Get(topic)
+		if err != nil {
+			writeJSONResponse(w, errs.HTTPStatus(err, http.StatusBadRequest), AdminResponse{Error: err.Error()})
+			return
+		}
+		writeJSONResponse(w, http.StatusOK, AdminResponse{Status: "ok", Data: state})
+	case http.MethodPut:
+		var req IngestionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		state, err := s.ingestion.Set(topic, req.State, req.Reason)
+		if err != nil {
+			log.Printf("Failed to switch ingestion of topic %s to %s: %v", topic, req.State, err)
+			writeJSONResponse(w, errs.HTTPStatus(err, http.StatusInternalServerError), AdminResponse{Error: err.Error()})
+			return
+		}
+		log.Printf("Ingestion of topic %s switched to %s (%s)", topic, state.State, state.Reason)
+		writeJSONResponse(w, http.StatusOK, AdminResponse{Status: state.State, Data: state})
+	default:
+		http.Error(w, "Only GET and PUT methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
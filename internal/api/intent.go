@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+
+	"stream-rag-agent/internal/vectordb"
+	"stream-rag-agent/internal/window"
+)
+
+const (
+	ModeTail = "tail"
+	ModeAuto = "auto" // Classify the question even when query.intent is disabled
+
+	IntentFromRequest = "request" // The request named the mode
+	IntentFromRules   = "rules"
+	IntentFromLLM     = "llm"
+	IntentDefault     = "default" // Nothing matched, or the matched mode is unavailable
+
+	defaultTailWindows = 5
+)
+
+var (
+	// structuredPattern matches questions about counts, sums and other figures over events,
+	// which aggregations answer exactly.
+	structuredPattern = regexp.MustCompile(`(?i)\b(how many|how much|number of|count|average|avg|mean|sum|total|minimum|maximum|median|group(ed)? by|per (second|minute|hour|day|week|month)|top \d+)\b`)
+	// tailPattern matches questions about what is happening now, which the latest windows answer.
+	tailPattern = regexp.MustCompile(`(?i)\b(latest|most recent|newest|right now|just now|currently|at the moment|(in )?the (last|past) (few )?(\d+ )?(seconds?|minutes?))\b`)
+)
+
+// intentModes describes the strategies to the LLM classifier.
+var intentModes = map[string]string{
+	ModeRAG:        "search the stored windows of stream data for the passages most similar to the question; for questions about specific entities, events, causes or explanations",
+	ModeStructured: "compute exact figures (counts, sums, averages, minimums, maximums, grouped by a field) over all individual events; for quantitative questions",
+	ModeTail:       "read the most recent windows of stream data; for questions about what is happening right now or just happened",
+}
+
+// IntentDecision records how the answering strategy of a question was chosen.
+type IntentDecision struct {
+	Mode   string `json:"mode"`
+	Source string `json:"source"` // request, rules, llm or default
+	Reason string `json:"reason,omitempty"`
+}
+
+// classifyIntent chooses the answering strategy of a question sent with mode, which is empty
+// or auto unless the request named one. Rules are tried first; the LLM is asked when none
+// matches and query.intent.use_llm is set. Questions fall back to rag when structured data is
+// not available.
+func (s *APIServer) classifyIntent(question, mode string) IntentDecision {
+	if mode != "" && mode != ModeAuto {
+		return IntentDecision{Mode: mode, Source: IntentFromRequest}
+	}
+	cfg := s.queryConfig.Intent
+	if mode == "" && !cfg.Enabled {
+		return IntentDecision{Mode: ModeRAG, Source: IntentDefault}
+	}
+
+	var decision IntentDecision
+	switch {
+	case structuredPattern.MatchString(question):
+		decision = IntentDecision{Mode: ModeStructured, Source: IntentFromRules, Reason: fmt.Sprintf("asks for figures (%q)", structuredPattern.FindString(question))}
+	case tailPattern.MatchString(question):
+		decision = IntentDecision{Mode: ModeTail, Source: IntentFromRules, Reason: fmt.Sprintf("asks about recent data (%q)", tailPattern.FindString(question))}
+	case cfg.UseLLM:
+		result, err := s.llmService.ClassifyIntent(question, intentModes)
+		if err != nil {
+			log.Printf("Warning: intent classification failed, answering with %s: %v", ModeRAG, err)
+			return IntentDecision{Mode: ModeRAG, Source: IntentDefault, Reason: "the classifier failed"}
+		}
+		decision = IntentDecision{Mode: result.Mode, Source: IntentFromLLM, Reason: result.Reason}
+	default:
+		return IntentDecision{Mode: ModeRAG, Source: IntentDefault, Reason: "no rule matched"}
+	}
+
+	if decision.Mode == ModeStructured && !s.hasStructuredData() {
+		return IntentDecision{Mode: ModeRAG, Source: IntentDefault, Reason: "structured data is not available"}
+	}
+	log.Printf("Question classified as %s by %s: %s", decision.Mode, decision.Source, decision.Reason)
+	return decision
+}
+
+// hasStructuredData reports whether structured queries can be answered.
+func (s *APIServer) hasStructuredData() bool {
+	if s.esClient == nil {
+		return false
+	}
+	fields, err := s.esClient.EventFields()
+	return err == nil && len(fields) > 0
+}
+
+// tailContext returns the most recent windows in scope, oldest first so the prompt reads
+// in time order. ex, if not nil, records how the windows were found.
+func (s *APIServer) tailContext(ctx context.Context, filter *vectordb.SearchFilter, ex *explainer) ([]window.EmbeddedWindow, error) {
+	n := s.queryConfig.Intent.TailWindows
+	if n <= 0 {
+		n = defaultTailWindows
+	}
+	windows, err := s.store.LatestWindows(ctx, n, ex.scope(filter))
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(windows)-1; i < j; i, j = i+1, j-1 {
+		windows[i], windows[j] = windows[j], windows[i]
+	}
+	ex.searched(retrievalLatest, nil, n)
+	ex.rank(windows)
+	return windows, nil
+}
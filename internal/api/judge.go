@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"stream-rag-agent/internal/metrics"
+	"stream-rag-agent/internal/vectordb"
+	"stream-rag-agent/internal/window"
+)
+
+// maxJudgeContextChars bounds the window text a judge is given with an answer.
+const maxJudgeContextChars = 24000
+
+var (
+	answersJudgedTotal = metrics.NewCounter("answers_judged_total", "Recorded answers scored by the judge model, by config label and result.")
+	judgeScoreSum      = metrics.NewCounter("answer_judge_score_sum", "Sum of the judge scores (1-5) of answers, by config label and score; divide by answers_judged_total{result=\"ok\"} for the average.")
+)
+
+type judgedAnswerContextKey struct{}
+
+// judgedAnswer is what the judge needs of an answer; handlers fill it in with noteAnswer.
+type judgedAnswer struct {
+	question string
+	answer   string
+	windows  []window.EmbeddedWindow
+}
+
+// noteAnswer keeps a generated answer and the windows it was generated from for the judge.
+// It does nothing when the judge is disabled.
+func noteAnswer(ctx context.Context, question, answer string, windows []window.EmbeddedWindow) {
+	if a, ok := ctx.Value(judgedAnswerContextKey{}).(*judgedAnswer); ok {
+		a.question, a.answer, a.windows = question, answer, windows
+	}
+}
+
+// judgeEnabled reports whether recorded answers are scored, which needs query analytics to
+// store the scores.
+func (s *APIServer) judgeEnabled() bool {
+	return s.queryConfig.Judge.Enabled && s.queryConfig.Analytics.Enabled && s.esClient != nil
+}
+
+// sampleJudge decides whether an answer is scored.
+func (s *APIServer) sampleJudge() bool {
+	rate := s.queryConfig.Judge.SampleRate
+	return rate <= 0 || rate >= 1 || rand.Float64() < rate
+}
+
+// judgeAnswer scores an answer with the judge model, returning nil if it could not be judged.
+// The egress policy applies to the judge like to the answering model: an external judge is
+// replaced by the local model or not given the windows of restricted topics.
+func (s *APIServer) judgeAnswer(a *judgedAnswer) *vectordb.Judgment {
+	cfg := s.queryConfig.Judge
+	model := cfg.Model
+	if model == "" {
+		model = s.llmService.Model()
+	}
+	style := answerStyle{egress: s.egress.Decide(model)}
+	model = style.egress.Model
+	label := cfg.ConfigLabel
+	result, err := s.llmService.JudgeAnswer(model, a.question, judgeContext(style.allowed(a.windows), s.location), a.answer)
+	if err != nil {
+		answersJudgedTotal.Inc("config_label", label, "result", "failed")
+		log.Printf("Failed to judge answer to '%s': %v", a.question, err)
+		return nil
+	}
+	answersJudgedTotal.Inc("config_label", label, "result", "ok")
+	judgeScoreSum.Add(float64(result.Groundedness), "config_label", label, "score", "groundedness")
+	judgeScoreSum.Add(float64(result.Completeness), "config_label", label, "score", "completeness")
+	return &vectordb.Judgment{
+		Model:        model,
+		ConfigLabel:  label,
+		Groundedness: result.Groundedness,
+		Completeness: result.Completeness,
+		Reason:       result.Reason,
+	}
+}
+
+// judgeContext renders the windows an answer was generated from, cut at maxJudgeContextChars.
+func judgeContext(windows []window.EmbeddedWindow, location *time.Location) string {
+	if len(windows) == 0 {
+		return "No relevant Kafka data found."
+	}
+	var sb strings.Builder
+	for i, w := range windows {
+		sb.WriteString(fmt.Sprintf("--- Window %d (Topic: %s, Time Range: %s - %s) ---\n",
+			i+1, w.Topic, w.StartTime.In(location).Format(time.RFC3339), w.EndTime.In(location).Format(time.RFC3339)))
+		sb.WriteString(w.ContextText)
+		sb.WriteString("\n\n")
+		if sb.Len() >= maxJudgeContextChars {
+			break
+		}
+	}
+	text := sb.String()
+	if len(text) > maxJudgeContextChars {
+		text = strings.ToValidUTF8(text[:maxJudgeContextChars], "") + "\n[context truncated]"
+	}
+	return text
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"stream-rag-agent/internal/window"
+)
+
+// windowLink renders api.window_links.url_template for a window, empty when no template is
+// configured. Values are query-escaped so they can be placed anywhere in the URL.
+func (s *APIServer) windowLink(w window.EmbeddedWindow) string {
+	tmpl := s.windowLinks.URLTemplate
+	if tmpl == "" {
+		return ""
+	}
+	return strings.NewReplacer(
+		"{window_id}", url.QueryEscape(w.WindowID),
+		"{topic}", url.QueryEscape(w.Topic),
+		"{partition}", strconv.Itoa(int(w.Partition)),
+		"{start_time}", url.QueryEscape(w.StartTime.UTC().Format(time.RFC3339)),
+		"{end_time}", url.QueryEscape(w.EndTime.UTC().Format(time.RFC3339)),
+		"{start_ms}", strconv.FormatInt(w.StartTime.UnixMilli(), 10),
+		"{end_ms}", strconv.FormatInt(w.EndTime.UnixMilli(), 10),
+		"{first_offset}", formatOffset(w.FirstOffset),
+		"{last_offset}", formatOffset(w.LastOffset),
+	).Replace(tmpl)
+}
+
+// formatOffset renders an optional offset, empty for windows indexed without offsets.
+func formatOffset(offset *int64) string {
+	if offset == nil {
+		return ""
+	}
+	return strconv.FormatInt(*offset, 10)
+}
+
+func (s *APIServer) sourceWindows(windows []window.EmbeddedWindow) []SourceWindow {
+	sources := make([]SourceWindow, 0, len(windows))
+	for _, w := range windows {
+		sources = append(sources, SourceWindow{
+			WindowID:       w.WindowID,
+			Topic:          w.Topic,
+			Partition:      w.Partition,
+			Shard:          w.Shard,
+			FirstOffset:    w.FirstOffset,
+			LastOffset:     w.LastOffset,
+			StartTime:      w.StartTime,
+			EndTime:        w.EndTime,
+			ContextVersion: w.ContextVersion,
+			CloseReason:    w.CloseReason,
+			Quality:        w.QualityNotes(),
+			Category:       w.Category,
+			EmbeddingModel: w.EmbeddingModel,
+			EmbeddedAt:     w.EmbeddedAt,
+			Link:           s.windowLink(w),
+		})
+	}
+	return sources
+}
+
+// withFootnotes appends numbered links to the windows an answer cites when footnotes are
+// enabled. Windows named by ID or citation marker in the answer are cited; if it names none,
+// all windows the answer was generated from are. With citations, footnotes are labeled with
+// the markers instead of numbers.
+func (s *APIServer) withFootnotes(answer string, windows []window.EmbeddedWindow, style answerStyle) string {
+	if !s.windowLinks.Footnotes || s.windowLinks.URLTemplate == "" || len(windows) == 0 {
+		return answer
+	}
+	cited := make([]int, 0, len(windows))
+	for i, w := range windows {
+		if strings.Contains(answer, w.WindowID) || style.citations && citesWindow(answer, i) {
+			cited = append(cited, i)
+		}
+	}
+	if len(cited) == 0 {
+		for i := range windows {
+			cited = append(cited, i)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(strings.TrimRight(answer, "\n"))
+	sb.WriteString("\n\nSources:")
+	for n, i := range cited {
+		w := windows[i]
+		label := strconv.Itoa(n + 1)
+		if style.citations {
+			label = citationMarker(i)
+		}
+		sb.WriteString(fmt.Sprintf("\n[%s] %s, %s - %s: %s", label, w.Topic,
+			w.StartTime.In(style.location).Format(time.RFC3339), w.EndTime.In(style.location).Format(time.RFC3339), s.windowLink(w)))
+	}
+	return sb.String()
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"stream-rag-agent/internal/llm"
+)
+
+// degradedHeader is set to "keyword" on chat completions whose context was found by keyword
+// search because the prompt could not be embedded.
+const degradedHeader = "X-RAG-Degraded"
+
+// OpenAI-compatible request/response shapes for /v1/chat/completions. Only the fields
+// the agent uses are modelled; unknown request fields are ignored.
+
+type ChatCompletionRequest struct {
+	Model     string            `json:"model"`
+	Messages  []llm.ChatMessage `json:"messages"`
+	Stream    bool              `json:"stream,omitempty"`
+	MaxTokens int               `json:"max_tokens,omitempty"`
+}
+
+type ChatCompletionChoice struct {
+	Index        int             `json:"index"`
+	Message      llm.ChatMessage `json:"message"`
+	FinishReason string          `json:"finish_reason"`
+}
+
+type ChatCompletionResponse struct {
+	ID      string                 `json:"id"`
+	Object  string                 `json:"object"`
+	Created int64                  `json:"created"`
+	Model   string                 `json:"model"`
+	Choices []ChatCompletionChoice `json:"choices"`
+}
+
+// ChatCompletionChunk is one server-sent event of a streamed chat completion. The answer is
+// generated in one piece, so it is sent as a single content chunk between the chunk with the
+// role and the one with the finish reason.
+type ChatCompletionChunk struct {
+	ID      string                      `json:"id"`
+	Object  string                      `json:"object"`
+	Created int64                       `json:"created"`
+	Model   string                      `json:"model"`
+	Choices []ChatCompletionChunkChoice `json:"choices"`
+}
+
+type ChatCompletionChunkChoice struct {
+	Index        int                 `json:"index"`
+	Delta        ChatCompletionDelta `json:"delta"`
+	FinishReason *string             `json:"finish_reason"` // null until the last chunk
+}
+
+// ChatCompletionDelta is the part of the message a chunk adds.
+type ChatCompletionDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type openAIError struct {
+	Error openAIErrorBody `json:"error"`
+}
+
+type openAIErrorBody struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+// handleChatCompletions exposes the RAG pipeline behind an OpenAI-compatible chat endpoint:
+// the last user message is used for retrieval, the retrieved context is injected as a system
+// message and the whole conversation is forwarded to the LLM. With stream, the answer is sent
+// as chat.completion.chunk events ending with [DONE]; errors found before generation starts
+// are still returned as JSON.
+func (s *APIServer) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "Only POST method is allowed")
+		return
+	}
+
+	var req ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "Invalid request body")
+		return
+	}
+	style, err := s.answerStyle("", "", req.Model)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	style.limitTokens(req.MaxTokens)
+	style.limitTokens(apiKeyFrom(r.Context()).MaxTokens)
+
+	question := lastUserMessage(req.Messages)
+	if question == "" {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "At least one non-empty user message is required")
+		return
+	}
+
+	log.Printf("Received chat completion request: %s", question)
+	rec := queryRecordFrom(r.Context())
+	rec.Question = question
+	rec.Mode = ModeRAG
+	rec.Model = style.egress.Model
+
+	retrievalQuery, _ := s.translateQuery(question)
+	similarWindows, keywordOnly, err := s.retrieveContext(r.Context(), retrievalQuery, style.scope(nil), s.queryConfig.Expansion.Enabled, nil)
+	if err != nil {
+		log.Printf("Error retrieving context for chat completion '%s': %v", question, err)
+		status := retrievalErrorStatus(err)
+		writeOpenAIError(w, status, openAIErrorType(status), retrievalErrorMessage(err))
+		return
+	}
+
+	noteSources(rec, similarWindows)
+
+	// Client-supplied system messages follow the agent's own instructions
+	// so retrieved context is always present.
+	systemPrompt := buildSystemPrompt(s.llmService.SystemPrompt(), similarWindows, style)
+	messages := append([]llm.ChatMessage{{Role: "system", Content: systemPrompt}}, req.Messages...)
+
+	if keywordOnly {
+		// The response body follows the OpenAI schema, so degraded retrieval is reported in a header
+		w.Header().Set(degradedHeader, "keyword")
+	}
+	generate := func(ctx context.Context) (string, error) {
+		answer, err := s.llmService.ChatWithOptions(ctx, messages, style.options())
+		if err != nil {
+			log.Printf("Error generating chat completion: %v", err)
+			return "", err
+		}
+		answer, _ = s.checkCitations(answer, similarWindows, style)
+		noteAnswer(ctx, question, answer, similarWindows)
+		return s.withFootnotes(answer, similarWindows, style), nil
+	}
+	model := style.egress.Model // The model that answers, after egress routing
+	now := time.Now()
+	id := fmt.Sprintf("chatcmpl-%d", now.UnixNano())
+
+	if req.Stream {
+		s.streams.serve(w, r, func(ctx context.Context) func(*sseStream) error {
+			answer, err := generate(ctx)
+			return func(stream *sseStream) error {
+				if err != nil {
+					status := generationErrorStatus(err)
+					if err := stream.Event("", openAIError{Error: openAIErrorBody{Message: generationErrorMessage(err), Type: openAIErrorType(status)}}); err != nil {
+						return err
+					}
+					return stream.Data("[DONE]")
+				}
+				stop := "stop"
+				for _, choice := range []ChatCompletionChunkChoice{
+					{Delta: ChatCompletionDelta{Role: "assistant"}},
+					{Delta: ChatCompletionDelta{Content: answer}},
+					{FinishReason: &stop},
+				} {
+					chunk := ChatCompletionChunk{ID: id, Object: "chat.completion.chunk", Created: now.Unix(), Model: model, Choices: []ChatCompletionChunkChoice{choice}}
+					if err := stream.Event("", chunk); err != nil {
+						return err
+					}
+				}
+				return stream.Data("[DONE]")
+			}
+		})
+		return
+	}
+
+	answer, err := generate(r.Context())
+	if err != nil {
+		status := generationErrorStatus(err)
+		writeOpenAIError(w, status, openAIErrorType(status), generationErrorMessage(err))
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, ChatCompletionResponse{
+		ID:      id,
+		Object:  "chat.completion",
+		Created: now.Unix(),
+		Model:   model,
+		Choices: []ChatCompletionChoice{{
+			Index:        0,
+			Message:      llm.ChatMessage{Role: "assistant", Content: answer},
+			FinishReason: "stop",
+		}},
+	})
+}
+
+func lastUserMessage(messages []llm.ChatMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" && messages[i].Content != "" {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
+
+// openAIErrorType is the OpenAI error type of a failed completion with the status.
+func openAIErrorType(status int) string {
+	if status == http.StatusTooManyRequests {
+		return "rate_limit_error"
+	}
+	return "api_error"
+}
+
+func writeOpenAIError(w http.ResponseWriter, statusCode int, errType, message string) {
+	writeJSONResponse(w, statusCode, openAIError{Error: openAIErrorBody{Message: message, Type: errType}})
+}
@@ -0,0 +1,889 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// opsPaths are operational endpoints, served without an API version.
+var opsPaths = map[string]bool{"/health": true, "/metrics": true, "/openapi.json": true}
+
+// The OpenAPI document is maintained as code next to the handlers. When adding or changing
+// an endpoint, update its path entry and schemas here.
+
+type object = map[string]interface{}
+
+func ref(schema string) object {
+	return object{"$ref": "#/components/schemas/" + schema}
+}
+
+func jsonBody(schema string) object {
+	return object{
+		"required": true,
+		"content":  object{"application/json": object{"schema": ref(schema)}},
+	}
+}
+
+func jsonResponse(description, schema string) object {
+	return object{
+		"description": description,
+		"content":     object{"application/json": object{"schema": ref(schema)}},
+	}
+}
+
+// streamingResponse is a JSON response that can also be streamed as server-sent events, as
+// the events describe.
+func streamingResponse(description, schema, events string) object {
+	resp := jsonResponse(description, schema)
+	resp["content"].(object)["text/event-stream"] = object{"schema": object{"type": "string", "description": events}}
+	return resp
+}
+
+func textResponse(description string) object {
+	return object{
+		"description": description,
+		"content":     object{"text/plain": object{"schema": object{"type": "string"}}},
+	}
+}
+
+func operation(summary string, tags []string, requestBody object, responses object) object {
+	op := object{"summary": summary, "tags": tags, "responses": responses}
+	if requestBody != nil {
+		op["requestBody"] = requestBody
+	}
+	return op
+}
+
+func stringProp(description string) object {
+	return object{"type": "string", "description": description}
+}
+
+func openAPISpec() object {
+	errorResponses := func(ok object) object {
+		return object{
+			"200": ok,
+			"400": textResponse("Invalid request"),
+			"429": textResponse("Ollama or Elasticsearch is rate limiting the agent; retry later"),
+			"500": textResponse("Internal error"),
+			"503": textResponse("Ollama or Elasticsearch is unavailable"),
+		}
+	}
+
+	// LLM endpoints require an API key when api.keys is configured
+	withAPIKey := func(op object) object {
+		op["security"] = []object{{"bearerAuth": []string{}}, {"apiKeyHeader": []string{}}}
+		responses := op["responses"].(object)
+		responses["401"] = textResponse("Missing or unknown API key")
+		responses["403"] = textResponse("Model or parameter not allowed for the API key")
+		return op
+	}
+
+	// Endpoints answering within a per-query deadline
+	withDeadline := func(op object) object {
+		op["parameters"] = []object{{"name": "X-Deadline-Ms", "in": "header", "schema": object{"type": "integer"}, "description": "Response time budget in milliseconds; deadline_ms in the body takes precedence"}}
+		op["responses"].(object)["504"] = textResponse("The query deadline was exceeded")
+		return op
+	}
+
+	// Endpoints working within the workspace of the X-Workspace header
+	withWorkspace := func(op object) object {
+		params, _ := op["parameters"].([]object)
+		op["parameters"] = append(params, object{"name": workspaceHeader, "in": "header", "schema": object{"type": "string"}, "description": "Workspace to work in; without one only artifacts shared by all workspaces are seen"})
+		responses := op["responses"].(object)
+		if _, ok := responses["403"]; !ok {
+			responses["403"] = textResponse("API key may not use the workspace")
+		}
+		if _, ok := responses["404"]; !ok {
+			responses["404"] = textResponse("Unknown workspace")
+		}
+		return op
+	}
+
+	workspaceParam := object{"name": "workspace", "in": "path", "required": true, "schema": object{"type": "string"}}
+	workspaceResponses := func(ok object) object {
+		return object{
+			"200": ok,
+			"401": textResponse("Missing or unknown API key, when api.keys is configured"),
+			"403": textResponse("API key may not use the workspace"),
+			"404": textResponse("Workspace or saved query not found"),
+		}
+	}
+
+	runResponses := errorResponses(jsonResponse("Generated answer", "QueryResponse"))
+	runResponses["404"] = textResponse("Workspace or saved query not found")
+
+	completion := streamingResponse("Chat completion", "ChatCompletionResponse",
+		"With stream: keep-alive comments until the answer is ready, then data events with a ChatCompletionChunk each (or an error object) and a final data: [DONE]")
+	completion["headers"] = object{degradedHeader: object{
+		"description": "keyword when the prompt could not be embedded and context was found by keyword search",
+		"schema":      object{"type": "string", "enum": []string{"keyword"}},
+	}}
+
+	paths := object{
+		"/query": object{
+			"post": withWorkspace(withDeadline(withAPIKey(operation("Answer a question using retrieved stream context", []string{"query"},
+				jsonBody("QueryRequest"),
+				errorResponses(streamingResponse("Generated answer", "QueryResponse",
+					"With stream: keep-alive comments until the answer is ready, then an 'answer' event with a QueryResponse or an 'error' event; a 'shutdown' event ends streams cut short by a server shutdown")))))),
+		},
+		"/chat": object{
+			"post": withWorkspace(withDeadline(withAPIKey(operation("Answer a message in a server-side chat session; follow-ups reuse earlier retrieved windows", []string{"query"},
+				jsonBody("ChatRequest"),
+				errorResponses(jsonResponse("Answer and session ID", "ChatResponse")))))),
+		},
+		"/v1/chat/completions": object{
+			"post": withAPIKey(operation("OpenAI-compatible chat completion backed by RAG", []string{"query"},
+				jsonBody("ChatCompletionRequest"),
+				errorResponses(completion))),
+		},
+		"/search": object{
+			"get": object{
+				"summary": "Page through stored windows by keyword relevance (q) or newest first, without the LLM",
+				"tags":    []string{"query"},
+				"parameters": []object{
+					{"name": "q", "in": "query", "schema": object{"type": "string"}, "description": "Keywords matched against the window text; omit to browse"},
+					{"name": "topic", "in": "query", "schema": object{"type": "array", "items": object{"type": "string"}}, "explode": true},
+					{"name": "category", "in": "query", "schema": object{"type": "array", "items": object{"type": "string"}}, "explode": true},
+					{"name": "header", "in": "query", "schema": object{"type": "array", "items": object{"type": "string"}}, "explode": true, "description": "name:value of a message header the window must carry, e.g. tenant:acme"},
+					{"name": "embedding_model", "in": "query", "schema": object{"type": "array", "items": object{"type": "string"}}, "explode": true, "description": "Only windows embedded by one of these models"},
+					{"name": "from", "in": "query", "schema": object{"type": "string", "format": "date-time"}},
+					{"name": "to", "in": "query", "schema": object{"type": "string", "format": "date-time"}},
+					{"name": "size", "in": "query", "schema": object{"type": "integer", "default": 20, "maximum": 100}},
+					{"name": "cursor", "in": "query", "schema": object{"type": "string"}, "description": "next_cursor of the previous page"},
+				},
+				"responses": object{
+					"200": jsonResponse("One page of windows", "SearchResponse"),
+					"400": textResponse("Invalid parameters or cursor"),
+					"500": textResponse("Search failed"),
+					"503": textResponse("Elasticsearch is unavailable"),
+				},
+			},
+		},
+		"/stats": object{
+			"get": operation("Consumer lag of every Kafka topic and partition: high watermark minus the group's committed offset", []string{"admin"}, nil,
+				object{"200": jsonResponse("Last measured lag", "StatsResponse")}),
+		},
+		"/raw": object{
+			"get": object{
+				"summary": "Read an offset range of a partition directly from Kafka, e.g. the messages behind a cited window",
+				"tags":    []string{"query"},
+				"parameters": []object{
+					{"name": "topic", "in": "query", "required": true, "schema": object{"type": "string"}},
+					{"name": "partition", "in": "query", "schema": object{"type": "integer", "default": 0}},
+					{"name": "from", "in": "query", "required": true, "schema": object{"type": "integer"}, "description": "First offset"},
+					{"name": "to", "in": "query", "schema": object{"type": "integer"}, "description": "Last offset (inclusive); defaults to from + 99. At most 500 messages or 4 MB are returned"},
+				},
+				"responses": object{
+					"200": jsonResponse("Messages of the range", "RawResponse"),
+					"400": textResponse("Invalid request"),
+					"404": textResponse("Topic is not consumed from Kafka"),
+					"500": textResponse("Kafka read failed"),
+				},
+			},
+		},
+		"/views": object{
+			"get": withWorkspace(operation("List the saved views of the workspace and the shared ones", []string{"views"}, nil,
+				object{"200": object{
+					"description": "Saved views",
+					"content":     object{"application/json": object{"schema": object{"type": "array", "items": ref("View")}}},
+				}})),
+			"post": withWorkspace(operation("Create or replace a saved view of the workspace, shared by all without one", []string{"views"},
+				jsonBody("View"),
+				errorResponses(jsonResponse("Saved view", "View")))),
+		},
+		"/views/{name}": object{
+			"parameters": []object{{"name": "name", "in": "path", "required": true, "schema": object{"type": "string"}}},
+			"get": withWorkspace(operation("Get a saved view", []string{"views"}, nil,
+				object{"200": jsonResponse("Saved view", "View"), "404": textResponse("View not found")})),
+			"delete": withWorkspace(operation("Delete a saved view of the workspace", []string{"views"}, nil,
+				object{"204": object{"description": "Deleted"}, "404": textResponse("View not found")})),
+		},
+		"/patterns": object{
+			"get": withWorkspace(operation("List known-bad patterns new windows are compared with, of the workspace and shared", []string{"patterns"}, nil,
+				object{"200": object{
+					"description": "Patterns",
+					"content":     object{"application/json": object{"schema": object{"type": "array", "items": ref("Pattern")}}},
+				}, "404": textResponse("Pattern alerting is disabled")})),
+			"post": withWorkspace(operation("Register or replace a pattern of the workspace from an example incident; its text is embedded", []string{"patterns"},
+				jsonBody("Pattern"),
+				errorResponses(jsonResponse("Registered pattern", "Pattern")))),
+		},
+		"/patterns/{name}": object{
+			"parameters": []object{{"name": "name", "in": "path", "required": true, "schema": object{"type": "string"}}},
+			"get": withWorkspace(operation("Get a pattern", []string{"patterns"}, nil,
+				object{"200": jsonResponse("Pattern", "Pattern"), "404": textResponse("Pattern not found")})),
+			"delete": withWorkspace(operation("Delete a pattern of the workspace registered through the API", []string{"patterns"}, nil,
+				object{"204": object{"description": "Deleted"}, "404": textResponse("Pattern not found")})),
+		},
+		"/patterns/alerts": object{
+			"get": withWorkspace(operation("Most recent windows that matched a pattern of the workspace or a shared one, newest first", []string{"patterns"}, nil,
+				object{"200": object{
+					"description": "Alerts",
+					"content":     object{"application/json": object{"schema": object{"type": "array", "items": ref("PatternAlert")}}},
+				}, "404": textResponse("Pattern alerting is disabled")})),
+		},
+		"/workspaces": object{
+			"get": operation("List the workspaces the API key may use", []string{"workspaces"}, nil,
+				workspaceResponses(object{
+					"description": "Workspaces",
+					"content":     object{"application/json": object{"schema": object{"type": "array", "items": ref("Workspace")}}},
+				})),
+			"post": operation("Create or replace a workspace; with api.keys configured and no api_keys, only the creating key may use it", []string{"workspaces"},
+				jsonBody("Workspace"),
+				workspaceResponses(jsonResponse("Workspace", "Workspace"))),
+		},
+		"/workspaces/{workspace}": object{
+			"parameters": []object{workspaceParam},
+			"get": operation("Get a workspace with the names of its views, patterns and saved queries", []string{"workspaces"}, nil,
+				workspaceResponses(jsonResponse("Workspace and its contents", "WorkspaceContents"))),
+			"delete": operation("Delete a workspace with its views, patterns, saved queries and chat sessions", []string{"workspaces"}, nil,
+				object{"204": object{"description": "Deleted"}, "401": textResponse("Missing or unknown API key, when api.keys is configured"), "403": textResponse("API key may not use the workspace"), "404": textResponse("Workspace not found")}),
+		},
+		"/workspaces/{workspace}/queries": object{
+			"parameters": []object{workspaceParam},
+			"get": operation("List the saved queries of a workspace", []string{"workspaces"}, nil,
+				workspaceResponses(object{
+					"description": "Saved queries",
+					"content":     object{"application/json": object{"schema": object{"type": "array", "items": ref("SavedQuery")}}},
+				})),
+			"post": operation("Save or replace a query of a workspace", []string{"workspaces"},
+				jsonBody("SavedQuery"),
+				workspaceResponses(jsonResponse("Saved query", "SavedQuery"))),
+		},
+		"/workspaces/{workspace}/queries/{query}": object{
+			"parameters": []object{workspaceParam, {"name": "query", "in": "path", "required": true, "schema": object{"type": "string"}}},
+			"get": operation("Get a saved query", []string{"workspaces"}, nil,
+				workspaceResponses(jsonResponse("Saved query", "SavedQuery"))),
+			"delete": operation("Delete a saved query", []string{"workspaces"}, nil,
+				object{"204": object{"description": "Deleted"}, "401": textResponse("Missing or unknown API key, when api.keys is configured"), "403": textResponse("API key may not use the workspace"), "404": textResponse("Workspace or saved query not found")}),
+		},
+		"/workspaces/{workspace}/queries/{query}/run": object{
+			"parameters": []object{workspaceParam, {"name": "query", "in": "path", "required": true, "schema": object{"type": "string"}}},
+			"post": withDeadline(withAPIKey(operation("Answer a saved query like /query, within its workspace", []string{"workspaces"}, nil,
+				runResponses))),
+		},
+		"/admin/snapshots": object{
+			"get": operation("List snapshots of the windows index", []string{"admin"}, nil,
+				errorResponses(jsonResponse("Snapshots", "AdminResponse"))),
+			"post": operation("Start snapshotting the windows index; see /admin/snapshots/status", []string{"admin"},
+				object{"required": false, "content": object{"application/json": object{"schema": ref("SnapshotRequest")}}},
+				object{"202": jsonResponse("Snapshot started", "AdminResponse"), "400": textResponse("Invalid request"), "409": jsonResponse("A snapshot or restore is already running", "AdminResponse")}),
+		},
+		"/admin/snapshots/restore": object{
+			"post": operation("Start restoring the windows index from a snapshot, pausing ingestion until it is done; see /admin/snapshots/status", []string{"admin"},
+				jsonBody("SnapshotRequest"),
+				object{"202": jsonResponse("Restore started", "AdminResponse"), "400": textResponse("Invalid request"), "409": jsonResponse("A snapshot or restore is already running", "AdminResponse")}),
+		},
+		"/admin/snapshots/status": object{
+			"get": operation("Progress of the most recent snapshot or restore", []string{"admin"}, nil,
+				errorResponses(jsonResponse("Job status", "AdminResponse"))),
+		},
+		"/admin/reembed": object{
+			"get": operation("Progress of the most recent re-embedding job", []string{"admin"}, nil,
+				errorResponses(jsonResponse("Job status", "AdminResponse"))),
+			"post": operation("Re-embed stored windows of a topic and time range with the current model", []string{"admin"},
+				jsonBody("ReembedRequest"),
+				object{"202": jsonResponse("Job started", "AdminResponse"), "400": textResponse("Invalid request"), "409": jsonResponse("A job is already running", "AdminResponse")}),
+		},
+		"/admin/offsets/reset": object{
+			"post": operation("Reset the consumer group offsets of a topic, pausing its consumer during the reset", []string{"admin"},
+				jsonBody("OffsetResetRequest"),
+				object{"200": jsonResponse("Offsets per partition", "AdminResponse"), "400": textResponse("Invalid request"), "404": textResponse("No consumer for the topic"), "500": jsonResponse("Reset failed", "AdminResponse")}),
+		},
+		"/admin/offsets/coverage": object{
+			"get": object{
+				"summary": "Report which offsets of a partition are covered by indexed windows, to verify a replay or backfill",
+				"tags":    []string{"admin"},
+				"parameters": []object{
+					{"name": "topic", "in": "query", "required": true, "schema": object{"type": "string"}},
+					{"name": "partition", "in": "query", "required": true, "schema": object{"type": "integer", "minimum": 0}},
+					{"name": "from", "in": "query", "required": true, "schema": object{"type": "integer", "minimum": 0}},
+					{"name": "to", "in": "query", "required": true, "schema": object{"type": "integer", "minimum": 0}},
+				},
+				"responses": errorResponses(jsonResponse("Windows covering the range, with gaps and overlaps", "AdminResponse")),
+			},
+		},
+		"/admin/duplicates": object{
+			"get": object{
+				"summary": "Report near-duplicate windows across topics, e.g. mirrored topics or duplicated pipelines",
+				"tags":    []string{"admin"},
+				"parameters": []object{
+					{"name": "max_distance", "in": "query", "schema": object{"type": "integer", "minimum": 0, "maximum": 7, "default": 3}, "description": "Maximum differing bits between window signatures"},
+					{"name": "from", "in": "query", "schema": object{"type": "string", "format": "date-time"}},
+					{"name": "to", "in": "query", "schema": object{"type": "string", "format": "date-time"}},
+				},
+				"responses": errorResponses(jsonResponse("Topic pairs with near-duplicate windows", "AdminResponse")),
+			},
+		},
+		"/admin/faults": object{
+			"get": operation("List injected dependency faults", []string{"admin"}, nil,
+				object{"200": jsonResponse("Active faults", "AdminResponse"), "403": jsonResponse("Fault injection disabled", "AdminResponse")}),
+			"post": operation("Inject a fault: delay or fail calls to Ollama, Elasticsearch or Kafka", []string{"admin"},
+				jsonBody("FaultRequest"),
+				object{"200": jsonResponse("Fault injected", "AdminResponse"), "400": textResponse("Invalid request"), "403": jsonResponse("Fault injection disabled", "AdminResponse")}),
+			"delete": object{
+				"summary":    "Clear injected faults",
+				"tags":       []string{"admin"},
+				"parameters": []object{{"name": "target", "in": "query", "schema": object{"type": "string"}, "description": "Clear only this target"}},
+				"responses":  object{"200": jsonResponse("Faults cleared", "AdminResponse"), "403": jsonResponse("Fault injection disabled", "AdminResponse")},
+			},
+		},
+		"/admin/index/stats": object{
+			"get": object{
+				"summary": "Document counts, storage size, vector dimensions and time span of the windows index per topic and category",
+				"tags":    []string{"admin"},
+				"parameters": []object{
+					{"name": "drift", "in": "query", "schema": object{"type": "boolean"}, "description": "Include per-topic embedding centroid drift"},
+					{"name": "periods", "in": "query", "schema": object{"type": "integer", "default": 7}},
+					{"name": "interval_hours", "in": "query", "schema": object{"type": "integer", "default": 24}},
+				},
+				"responses": errorResponses(jsonResponse("Index statistics", "AdminResponse")),
+			},
+		},
+		"/admin/index/errors": object{
+			"get": object{
+				"summary": "Most recent documents that bulk indexing failed to store, newest first, with the Elasticsearch error type and reason",
+				"tags":    []string{"admin"},
+				"parameters": []object{
+					{"name": "kind", "in": "query", "schema": object{"type": "string", "enum": []string{"window", "event"}}},
+				},
+				"responses": errorResponses(jsonResponse("Document failures", "AdminResponse")),
+			},
+		},
+		"/admin/analytics": object{
+			"get": object{
+				"summary": "Recorded questions over time: failure rate, topics and modes they were answered from, top questions and the latest LLM-written usage reports",
+				"tags":    []string{"admin"},
+				"parameters": []object{
+					{"name": "from", "in": "query", "schema": object{"type": "string", "format": "date-time"}, "description": "Defaults to 24 hours before to"},
+					{"name": "to", "in": "query", "schema": object{"type": "string", "format": "date-time"}, "description": "Defaults to now"},
+					{"name": "interval", "in": "query", "schema": object{"type": "string", "default": "1h"}, "description": "Timeline bucket size, at least 1m"},
+				},
+				"responses": errorResponses(jsonResponse("Query analytics", "AdminResponse")),
+			},
+		},
+		"/admin/ingestion": object{
+			"get": operation("Ingestion state of every topic", []string{"admin"}, nil,
+				object{"200": jsonResponse("Topic states", "AdminResponse"), "404": textResponse("Ingestion control is not available")}),
+		},
+		"/admin/ingestion/{topic}": object{
+			"parameters": []object{{"name": "topic", "in": "path", "required": true, "schema": object{"type": "string"}}},
+			"get": operation("Ingestion state of a topic", []string{"admin"}, nil,
+				object{"200": jsonResponse("Topic state", "AdminResponse"), "404": jsonResponse("Unknown topic", "AdminResponse")}),
+			"put": operation("Switch the ingestion of a topic: enabled, skip (consume and commit without windowing) or paused (stop fetching); persisted across restarts", []string{"admin"},
+				jsonBody("IngestionRequest"),
+				object{"200": jsonResponse("Topic state", "AdminResponse"), "400": jsonResponse("Invalid state", "AdminResponse"), "404": jsonResponse("Unknown topic", "AdminResponse"), "500": jsonResponse("The state could not be persisted", "AdminResponse")}),
+		},
+		"/health": object{
+			"get": operation("Liveness check", []string{"ops"}, nil, object{"200": textResponse("OK")}),
+		},
+		"/metrics": object{
+			"get": operation("Prometheus metrics", []string{"ops"}, nil, object{"200": textResponse("Metrics in Prometheus text format")}),
+		},
+		"/openapi.json": object{
+			"get": operation("This OpenAPI document", []string{"ops"}, nil,
+				object{"200": object{"description": "OpenAPI 3 document", "content": object{"application/json": object{}}}}),
+		},
+	}
+
+	chatMessage := object{
+		"type":     "object",
+		"required": []string{"role", "content"},
+		"properties": object{
+			"role":    object{"type": "string", "enum": []string{"system", "user", "assistant"}},
+			"content": object{"type": "string"},
+		},
+	}
+
+	schemas := object{
+		"QueryRequest": object{
+			"type":     "object",
+			"required": []string{"prompt"},
+			"properties": object{
+				"prompt":                stringProp("The user's question"),
+				"view":                  stringProp("Name of a saved view scoping retrieval"),
+				"mode":                  object{"type": "string", "enum": []string{"rag", "structured", "tail", "auto"}, "description": "structured answers aggregation questions from indexed message fields, tail answers from the latest windows, auto classifies the question; when omitted, the question is classified if query.intent is enabled and answered with rag otherwise"},
+				"expand":                object{"type": "boolean", "description": "Also retrieve with LLM-generated paraphrases of the prompt; defaults to the configured setting"},
+				"debug":                 object{"type": "boolean", "description": "Include retrieval parameters (k, num_candidates) in the response"},
+				"explain":               object{"type": "boolean", "description": "Include why each retrieved window was or was not used and how the prompt was budgeted"},
+				"citations":             object{"type": "boolean", "description": "Ask for inline citation markers such as [W3] and check them; defaults to query.citations.enabled"},
+				"validate":              object{"type": "boolean", "description": "Recompute figures in the answer from structured fields and report discrepancies; defaults to the configured setting"},
+				"verbosity":             object{"type": "string", "enum": []string{"brief", "normal", "detailed"}, "description": "Answer length; defaults to the configured level"},
+				"time_zone":             stringProp("IANA time zone to report times in, e.g. Europe/Istanbul; defaults to the configured reporting zone"),
+				"model":                 stringProp("LLM model from ollama.models; defaults to ollama.llm_model"),
+				"deadline_ms":           object{"type": "integer", "description": "Response time budget split between retrieval and generation; defaults to the X-Deadline-Ms header or query.deadline.default_ms"},
+				"recency":               object{"type": "boolean", "description": "Rank recent windows above older, similarly relevant ones; defaults to query.recency.enabled"},
+				"recency_scale_minutes": object{"type": "integer", "minimum": 1, "description": "Age at which a window keeps query.recency.decay of its score; turns the recency decay on"},
+				"embedding_models":      object{"type": "array", "items": object{"type": "string"}, "description": "Only retrieve windows embedded by one of these models, overriding those of the view"},
+				"topics":                object{"type": "array", "items": object{"type": "string"}, "description": "Only retrieve windows of these topics, overriding those of the view"},
+				"stream":                object{"type": "boolean", "description": "Answer with server-sent events, which are not bound by the server's write timeout"},
+			},
+		},
+		"QueryResponse": object{
+			"type": "object",
+			"properties": object{
+				"answer":      object{"type": "string"},
+				"mode":        object{"type": "string", "enum": []string{"rag", "structured", "tail"}},
+				"intent":      ref("IntentDecision"),
+				"sources":     object{"type": "array", "items": ref("SourceWindow")},
+				"aggregation": ref("AggregationResult"),
+				"validation":  ref("NumericValidation"),
+				"debug": object{"type": "object", "properties": object{
+					"k":                     object{"type": "integer"},
+					"num_candidates":        object{"type": "integer"},
+					"recency_scale_minutes": object{"type": "integer"},
+				}},
+				"explain":   ref("QueryExplanation"),
+				"citations": ref("CitationReport"),
+				"deadline":  ref("DeadlineReport"),
+				"degraded":  object{"type": "boolean", "description": "The prompt could not be embedded; context was found by keyword (BM25) search"},
+				"error":     object{"type": "string"},
+			},
+		},
+		"CitationReport": object{
+			"type":        "object",
+			"description": "Citation markers of the answer, when citations are requested",
+			"properties": object{
+				"markers": object{"type": "object", "additionalProperties": ref("SourceWindow"), "description": "Cited markers, e.g. W3, with the window each stands for"},
+				"invalid": object{"type": "array", "items": object{"type": "string"}, "description": "Cited markers of no window given to the LLM; stripped from the answer unless query.citations.on_invalid is flag"},
+				"uncited": object{"type": "boolean", "description": "The answer cites no window"},
+			},
+		},
+		"QueryExplanation": object{
+			"type":        "object",
+			"description": "Why the windows of the context were chosen and how the prompt was assembled, for explain",
+			"properties": object{
+				"retrieval": object{"type": "object", "properties": object{
+					"method":         object{"type": "string", "enum": []string{"vector", "keyword", "latest"}, "description": "vector: similarity search; keyword: BM25 search because the prompt could not be embedded; latest: the latest windows, for tail questions"},
+					"queries":        object{"type": "array", "items": object{"type": "string"}, "description": "The question, then its paraphrases from query expansion"},
+					"k":              object{"type": "integer", "description": "Windows retrieved per query"},
+					"num_candidates": object{"type": "integer"},
+					"recency": object{"type": "object", "properties": object{
+						"scale_minutes":  object{"type": "integer"},
+						"offset_minutes": object{"type": "integer"},
+						"decay":          object{"type": "number"},
+						"weight":         object{"type": "number"},
+					}},
+					"topics":           object{"type": "array", "items": object{"type": "string"}},
+					"excluded_topics":  object{"type": "array", "items": object{"type": "string"}, "description": "Topics the egress policy withholds from the model"},
+					"entities":         object{"type": "array", "items": object{"type": "string"}},
+					"categories":       object{"type": "array", "items": object{"type": "string"}},
+					"embedding_models": object{"type": "array", "items": object{"type": "string"}},
+					"from":             object{"type": "string", "format": "date-time"},
+					"to":               object{"type": "string", "format": "date-time"},
+					"min_overlap":      object{"type": "number", "description": "Share of a window's offsets in a better-ranked window above which it was left out"},
+				}},
+				"windows": object{"type": "array", "description": "Retrieved windows in rank order, including those left out of the prompt", "items": object{
+					"type": "object",
+					"properties": object{
+						"window_id": object{"type": "string"},
+						"topic":     object{"type": "string"},
+						"rank":      object{"type": "integer"},
+						"score": object{"type": "object", "properties": object{
+							"kind":           object{"type": "string", "enum": []string{"vector", "keyword"}},
+							"score":          object{"type": "number", "description": "Score the window was ranked by"},
+							"relevance":      object{"type": "number", "description": "Similarity or BM25 score before the recency boost"},
+							"recency_factor": object{"type": "number", "description": "Multiplier of the recency decay, absent without decay"},
+						}},
+						"matched_queries": object{"type": "integer", "description": "Queries whose results held the window, with query expansion"},
+						"entity_matches":  object{"type": "array", "items": object{"type": "string"}},
+						"included":        object{"type": "boolean"},
+						"reason":          stringProp("Why the window was left out of the prompt"),
+						"prompt_chars":    object{"type": "integer"},
+					},
+				}},
+				"prompt": object{"type": "object", "properties": object{
+					"model":             object{"type": "string"},
+					"windows":           object{"type": "integer"},
+					"context_chars":     object{"type": "integer"},
+					"other_chars":       object{"type": "integer", "description": "Instructions, window headers and the question"},
+					"estimated_tokens":  object{"type": "integer", "description": "Of the whole prompt, at about four characters per token"},
+					"max_answer_tokens": object{"type": "integer"},
+					"timeout_ms":        object{"type": "integer"},
+				}},
+			},
+		},
+		"IntentDecision": object{
+			"type":        "object",
+			"description": "How the answering strategy was chosen",
+			"properties": object{
+				"mode":   object{"type": "string"},
+				"source": object{"type": "string", "enum": []string{"request", "rules", "llm", "default"}},
+				"reason": object{"type": "string"},
+			},
+		},
+		"SourceWindow": object{
+			"type": "object",
+			"properties": object{
+				"window_id":       object{"type": "string"},
+				"topic":           object{"type": "string"},
+				"partition":       object{"type": "integer"},
+				"shard":           object{"type": "integer", "description": "Key shard of the partition, for topics with key_sharding"},
+				"first_offset":    object{"type": "integer", "description": "First offset of the partition the window covers; read the messages with /raw"},
+				"last_offset":     object{"type": "integer"},
+				"start_time":      object{"type": "string", "format": "date-time"},
+				"end_time":        object{"type": "string", "format": "date-time"},
+				"context_version": object{"type": "string"},
+				"close_reason":    object{"type": "string", "enum": []string{"timeout", "max_messages", "max_bytes", "watermark", "memory_budget", "flush", "shutdown", "rebalance", "stale"}},
+				"quality":         stringProp("Why the window is partial or degraded (closed early, truncated, sampled, unparsable messages); empty if complete"),
+				"category":        stringProp("Content category assigned at indexing, see categories"),
+				"embedding_model": stringProp("Model that embedded the window"),
+				"embedded_at":     object{"type": "string", "format": "date-time", "description": "When the window was (re-)embedded"},
+				"link":            stringProp("Deep link into the window explorer configured under api.window_links"),
+			},
+		},
+		"AggregationResult": object{
+			"type": "object",
+			"properties": object{
+				"spec": object{"type": "object", "description": "Aggregation spec generated from the question"},
+				"rows": object{"type": "array", "items": object{
+					"type": "object",
+					"properties": object{
+						"group": object{"type": "string"},
+						"value": object{"type": "number"},
+						"count": object{"type": "integer"},
+					},
+				}},
+			},
+		},
+		"NumericValidation": object{
+			"type": "object",
+			"properties": object{
+				"status":        object{"type": "string", "enum": []string{"consistent", "discrepancy", "skipped"}},
+				"answer_values": object{"type": "array", "items": object{"type": "number"}},
+				"computed":      ref("AggregationResult"),
+				"message":       object{"type": "string"},
+			},
+		},
+		"ChatRequest": object{
+			"type":     "object",
+			"required": []string{"message"},
+			"properties": object{
+				"session_id":  stringProp("Session to continue; omit to start a new one"),
+				"message":     object{"type": "string"},
+				"view":        stringProp("Name of a saved view scoping retrieval"),
+				"model":       stringProp("LLM model from ollama.models; defaults to ollama.llm_model"),
+				"deadline_ms": object{"type": "integer", "description": "Response time budget, see QueryRequest"},
+			},
+		},
+		"ChatResponse": object{
+			"type": "object",
+			"properties": object{
+				"session_id": object{"type": "string"},
+				"answer":     object{"type": "string"},
+				"sources":    object{"type": "array", "items": ref("SourceWindow")},
+				"citations":  ref("CitationReport"),
+				"deadline":   ref("DeadlineReport"),
+				"degraded":   object{"type": "boolean", "description": "See QueryResponse"},
+				"error":      object{"type": "string"},
+			},
+		},
+		"DeadlineReport": object{
+			"type": "object",
+			"properties": object{
+				"deadline_ms":   object{"type": "integer"},
+				"retrieval_ms":  object{"type": "integer", "description": "Time retrieval took"},
+				"generation_ms": object{"type": "integer", "description": "Time left for generation when it started"},
+				"degraded":      object{"type": "array", "items": object{"type": "string", "enum": []string{"fast_model", "reduced_context", "no_validation"}}},
+			},
+		},
+		"ChatMessage": chatMessage,
+		"ChatCompletionRequest": object{
+			"type":     "object",
+			"required": []string{"messages"},
+			"properties": object{
+				"model":      stringProp("ollama.llm_model or a model from ollama.models"),
+				"messages":   object{"type": "array", "items": ref("ChatMessage")},
+				"stream":     object{"type": "boolean", "description": "Send the answer as chat.completion.chunk server-sent events ending with [DONE]"},
+				"max_tokens": object{"type": "integer", "description": "Maximum number of tokens to generate"},
+			},
+		},
+		"ChatCompletionResponse": object{
+			"type": "object",
+			"properties": object{
+				"id":      object{"type": "string"},
+				"object":  object{"type": "string"},
+				"created": object{"type": "integer"},
+				"model":   object{"type": "string"},
+				"choices": object{"type": "array", "items": object{
+					"type": "object",
+					"properties": object{
+						"index":         object{"type": "integer"},
+						"message":       ref("ChatMessage"),
+						"finish_reason": object{"type": "string"},
+					},
+				}},
+			},
+		},
+		"ChatCompletionChunk": object{
+			"type": "object",
+			"properties": object{
+				"id":      object{"type": "string"},
+				"object":  object{"type": "string", "enum": []string{"chat.completion.chunk"}},
+				"created": object{"type": "integer"},
+				"model":   object{"type": "string"},
+				"choices": object{"type": "array", "items": object{
+					"type": "object",
+					"properties": object{
+						"index": object{"type": "integer"},
+						"delta": object{"type": "object", "properties": object{
+							"role":    object{"type": "string"},
+							"content": object{"type": "string"},
+						}},
+						"finish_reason": object{"type": "string", "nullable": true},
+					},
+				}},
+			},
+		},
+		"StatsResponse": object{
+			"type": "object",
+			"properties": object{
+				"consumer_lag": object{"type": "array", "items": object{
+					"type": "object",
+					"properties": object{
+						"topic":       object{"type": "string"},
+						"group":       object{"type": "string"},
+						"lag":         object{"type": "integer", "description": "Sum over the partitions"},
+						"measured_at": object{"type": "string", "format": "date-time"},
+						"error":       object{"type": "string", "description": "Why the latest measurement failed; the figures are from the one before"},
+						"partitions": object{"type": "array", "items": object{
+							"type": "object",
+							"properties": object{
+								"partition":      object{"type": "integer"},
+								"high_watermark": object{"type": "integer"},
+								"committed":      object{"type": "integer", "description": "-1 if the group has not committed an offset"},
+								"lag":            object{"type": "integer"},
+							},
+						}},
+					},
+				}},
+			},
+		},
+		"SearchResponse": object{
+			"type": "object",
+			"properties": object{
+				"results": object{"type": "array", "items": object{
+					"allOf": []object{ref("SourceWindow"), {
+						"type": "object",
+						"properties": object{
+							"score":         object{"type": "number"},
+							"message_count": object{"type": "integer"},
+							"context_text":  object{"type": "string"},
+						},
+					}},
+				}},
+				"total":       object{"type": "integer"},
+				"next_cursor": stringProp("Cursor of the next page; absent on the last page"),
+			},
+		},
+		"RawResponse": object{
+			"type": "object",
+			"properties": object{
+				"topic":     object{"type": "string"},
+				"partition": object{"type": "integer"},
+				"from":      object{"type": "integer"},
+				"to":        object{"type": "integer"},
+				"messages": object{"type": "array", "items": object{
+					"type": "object",
+					"properties": object{
+						"partition": object{"type": "integer"},
+						"offset":    object{"type": "integer"},
+						"timestamp": object{"type": "string", "format": "date-time"},
+						"key":       object{"type": "string"},
+						"value":     object{"description": "JSON payload (decoded per value_format and decompressed per payload_compression)"},
+						"text":      stringProp("Payload that is not JSON"),
+						"tombstone": object{"type": "boolean"},
+					},
+				}},
+				"truncated": object{"type": "boolean"},
+				"next_from": object{"type": "integer", "description": "Offset to continue from when truncated"},
+			},
+		},
+		"View": object{
+			"type":     "object",
+			"required": []string{"name"},
+			"properties": object{
+				"name":             object{"type": "string"},
+				"topics":           object{"type": "array", "items": object{"type": "string"}},
+				"last_seconds":     object{"type": "integer", "description": "Relative time policy: windows from the last N seconds"},
+				"from":             object{"type": "string", "format": "date-time"},
+				"to":               object{"type": "string", "format": "date-time"},
+				"entities":         object{"type": "array", "items": object{"type": "string"}},
+				"categories":       object{"type": "array", "items": object{"type": "string"}},
+				"headers":          object{"type": "object", "additionalProperties": object{"type": "string"}, "description": "Message header values the window must carry"},
+				"embedding_models": object{"type": "array", "items": object{"type": "string"}, "description": "Embedding models the window must have been embedded by"},
+				"workspace":        object{"type": "string", "readOnly": true, "description": "Workspace of the view, from the X-Workspace header; absent for shared views"},
+				"source":           object{"type": "string", "readOnly": true},
+			},
+		},
+		"Pattern": object{
+			"type":     "object",
+			"required": []string{"name", "text"},
+			"properties": object{
+				"name":            object{"type": "string"},
+				"text":            stringProp("Example incident, embedded like a window's context text"),
+				"threshold":       object{"type": "number", "minimum": 0, "maximum": 1, "description": "Cosine similarity that triggers an alert; 0 uses patterns.threshold"},
+				"topics":          object{"type": "array", "items": object{"type": "string"}, "description": "Only windows of these topics, all topics if empty"},
+				"embedding_model": object{"type": "string", "readOnly": true},
+				"workspace":       object{"type": "string", "readOnly": true, "description": "Workspace of the pattern, from the X-Workspace header; absent for shared patterns"},
+				"source":          object{"type": "string", "readOnly": true},
+			},
+		},
+		"PatternAlert": object{
+			"type": "object",
+			"properties": object{
+				"pattern":    object{"type": "string"},
+				"workspace":  stringProp("Workspace of the pattern, absent for shared patterns"),
+				"similarity": object{"type": "number"},
+				"threshold":  object{"type": "number"},
+				"window_id":  object{"type": "string"},
+				"topic":      object{"type": "string"},
+				"partition":  object{"type": "integer"},
+				"start_time": object{"type": "string", "format": "date-time"},
+				"end_time":   object{"type": "string", "format": "date-time"},
+				"category":   object{"type": "string"},
+				"at":         object{"type": "string", "format": "date-time"},
+			},
+		},
+		"Workspace": object{
+			"type":     "object",
+			"required": []string{"name"},
+			"properties": object{
+				"name":        stringProp("Letters, digits, '.', '_' and '-'"),
+				"description": object{"type": "string"},
+				"api_keys":    object{"type": "array", "items": object{"type": "string"}, "description": "Names of the API keys from api.keys that may use the workspace; defaults to the creating key"},
+				"created_at":  object{"type": "string", "format": "date-time", "readOnly": true},
+			},
+		},
+		"WorkspaceContents": object{
+			"allOf": []object{ref("Workspace"), {
+				"type": "object",
+				"properties": object{
+					"views":    object{"type": "array", "items": object{"type": "string"}, "description": "Views of the workspace, without the shared ones"},
+					"patterns": object{"type": "array", "items": object{"type": "string"}, "description": "Patterns of the workspace, absent when pattern alerting is disabled"},
+					"queries":  object{"type": "array", "items": object{"type": "string"}},
+					"sessions": object{"type": "integer", "description": "Chat sessions of the workspace that have not expired"},
+				},
+			}},
+		},
+		"SavedQuery": object{
+			"type":     "object",
+			"required": []string{"name", "request"},
+			"properties": object{
+				"name":        stringProp("Letters, digits, '.', '_' and '-'"),
+				"description": object{"type": "string"},
+				"request":     ref("QueryRequest"),
+				"created_at":  object{"type": "string", "format": "date-time", "readOnly": true},
+			},
+		},
+		"SnapshotRequest": object{
+			"type":       "object",
+			"properties": object{"name": stringProp("Snapshot name; generated when omitted on create")},
+		},
+		"ReembedRequest": object{
+			"type":     "object",
+			"required": []string{"topic"},
+			"properties": object{
+				"topic": object{"type": "string"},
+				"from":  object{"type": "string", "format": "date-time"},
+				"to":    object{"type": "string", "format": "date-time"},
+			},
+		},
+		"OffsetResetRequest": object{
+			"type":     "object",
+			"required": []string{"topic", "to"},
+			"properties": object{
+				"topic":     object{"type": "string"},
+				"to":        object{"type": "string", "enum": []string{"earliest", "latest", "timestamp"}},
+				"timestamp": object{"type": "string", "format": "date-time", "description": "Required when to is timestamp"},
+			},
+		},
+		"FaultRequest": object{
+			"type":     "object",
+			"required": []string{"target"},
+			"properties": object{
+				"target":           object{"type": "string", "enum": []string{"ollama", "elasticsearch", "kafka"}},
+				"latency_ms":       object{"type": "integer", "description": "Delay added to each call"},
+				"error_rate":       object{"type": "number", "minimum": 0, "maximum": 1, "description": "Probability of failing a call; 1 makes the dependency unavailable"},
+				"duration_seconds": object{"type": "integer", "description": "Fault lifetime; 0 keeps it until cleared"},
+			},
+		},
+		"IngestionRequest": object{
+			"type":     "object",
+			"required": []string{"state"},
+			"properties": object{
+				"state":  object{"type": "string", "enum": []string{"enabled", "skip", "paused"}, "description": "skip is not available for sources that acknowledge messages once their window is processed (NATS, MQTT, Redis, AMQP)"},
+				"reason": stringProp("Why the topic was switched, e.g. \"upstream sends corrupt payloads\""),
+			},
+		},
+		"AdminResponse": object{
+			"type": "object",
+			"properties": object{
+				"status": object{"type": "string"},
+				"data":   object{},
+				"error":  object{"type": "string"},
+			},
+		},
+	}
+
+	// Data, view, pattern and admin endpoints require a key, an admin key for /admin, when
+	// api.keys is configured
+	for path, item := range paths {
+		admin := strings.HasPrefix(path, "/admin/")
+		shared := strings.HasPrefix(path, "/views") || strings.HasPrefix(path, "/patterns")
+		if !admin && !shared && path != "/raw" && path != "/search" && path != "/stats" {
+			continue
+		}
+		for method, op := range item.(object) {
+			if method == "parameters" {
+				continue
+			}
+			op := op.(object)
+			op["security"] = []object{{"bearerAuth": []string{}}, {"apiKeyHeader": []string{}}}
+			responses := op["responses"].(object)
+			responses["401"] = textResponse("Missing or unknown API key")
+			if admin {
+				responses["403"] = textResponse("The API key is not an admin key")
+			}
+		}
+	}
+
+	// API routes are documented under their versioned path; the unversioned paths serve the
+	// same operations for existing clients
+	versioned := make(object, len(paths))
+	prefix := versionPrefix(CurrentAPIVersion)
+	for path, item := range paths {
+		if !opsPaths[path] && !strings.HasPrefix(path, prefix+"/") {
+			path = prefix + path
+		}
+		versioned[path] = item
+	}
+
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title": "Streaming RAG Agent API",
+			"description": "Query Kafka stream data through retrieval-augmented generation. " +
+				"API routes are versioned under /v1; the same routes without the prefix remain available and link to their successor. " +
+				"Clients may send X-API-Version to request a version, and every API response carries the version that served it.",
+			"version": "1.0.0",
+		},
+		"servers": []object{{"url": "http://localhost:8080"}},
+		"paths":   versioned,
+		"components": object{
+			"schemas": schemas,
+			"securitySchemes": object{
+				"bearerAuth":   object{"type": "http", "scheme": "bearer"},
+				"apiKeyHeader": object{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+	}
+}
+
+func (s *APIServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, openAPISpec())
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"fmt"
+	"log"
+
+	"stream-rag-agent/internal/metrics"
+	"stream-rag-agent/internal/window"
+)
+
+const defaultMinOverlap = 0.5
+
+var overlapDroppedTotal = metrics.NewCounter("retrieval_overlap_dropped_total", "Retrieved windows left out of the prompt because a better-ranked window of the same partition covers most of their messages.")
+
+// dropOverlaps leaves out retrieved windows whose offset range is mostly covered by a
+// better-ranked window of the same topic, partition, key shard and group key, e.g. overlapping
+// sliding windows or a window that was indexed again under another ID, so the prompt does not
+// repeat the same messages. windows are in rank order; windows without recorded offsets are kept.
+// ex, if not nil, records why windows were left out.
+func (s *APIServer) dropOverlaps(windows []window.EmbeddedWindow, ex *explainer) []window.EmbeddedWindow {
+	minOverlap := s.queryConfig.Overlap.MinOverlap
+	if minOverlap < 0 || len(windows) < 2 {
+		return windows
+	}
+	if minOverlap == 0 || minOverlap > 1 {
+		minOverlap = defaultMinOverlap
+	}
+	kept := make([]window.EmbeddedWindow, 0, len(windows))
+	for _, w := range windows {
+		dropped := false
+		for _, better := range kept {
+			if share := overlap(w, better); share >= minOverlap {
+				log.Printf("Leaving window %s out of the context: %.0f%% of its offsets are in window %s", w.WindowID, share*100, better.WindowID)
+				overlapDroppedTotal.Inc()
+				ex.leaveOut(w.WindowID, fmt.Sprintf("%.0f%% of its offsets are in better-ranked window %s", share*100, better.WindowID))
+				dropped = true
+				break
+			}
+		}
+		if !dropped {
+			kept = append(kept, w)
+		}
+	}
+	return kept
+}
+
+// overlap returns the share of w's offsets that other covers as well, 0 unless both hold
+// messages of the same partition (and key shard or group key) over overlapping times. Windows
+// of different group keys interleave their offsets without sharing messages. The time check
+// keeps apart windows of sources whose offsets restart with the agent, e.g. MQTT.
+func overlap(w, other window.EmbeddedWindow) float64 {
+	if w.FirstOffset == nil || w.LastOffset == nil || other.FirstOffset == nil || other.LastOffset == nil {
+		return 0
+	}
+	if w.Topic != other.Topic || w.Partition != other.Partition || !sameShard(w.Shard, other.Shard) || w.GroupKey != other.GroupKey {
+		return 0
+	}
+	if w.EndTime.Before(other.StartTime) || other.EndTime.Before(w.StartTime) {
+		return 0
+	}
+	lo, hi := max(*w.FirstOffset, *other.FirstOffset), min(*w.LastOffset, *other.LastOffset)
+	if hi < lo {
+		return 0
+	}
+	return float64(hi-lo+1) / float64(*w.LastOffset-*w.FirstOffset+1)
+}
+
+func sameShard(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
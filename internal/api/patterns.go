@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"stream-rag-agent/internal/patterns"
+	"stream-rag-agent/pkg/errs"
+)
+
+// requirePatterns rejects requests to the pattern endpoints when pattern alerting is disabled.
+func (s *APIServer) requirePatterns(next http.HandlerFunc) http.HandlerFunc {
+	if s.patterns != nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Pattern alerting is disabled (patterns.enabled)", http.StatusNotFound)
+	}
+}
+
+// handlePatterns lists registered patterns (GET) or registers one from an example incident
+// (POST), embedding its text, in the request's workspace if any.
+func (s *APIServer) handlePatterns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSONResponse(w, http.StatusOK, s.patterns.List(workspaceFrom(r.Context())))
+	case http.MethodPost:
+		var p patterns.Pattern
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		p.Workspace = workspaceFrom(r.Context())
+		if err := s.patterns.Put(&p); err != nil {
+			log.Printf("Error saving pattern '%s': %v", p.Name, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSONResponse(w, http.StatusOK, p)
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePattern returns (GET) or deletes (DELETE) a single pattern.
+func (s *APIServer) handlePattern(w http.ResponseWriter, r *http.Request) {
+	workspace, name := workspaceFrom(r.Context()), r.PathValue("name")
+	switch r.Method {
+	case http.MethodGet:
+		p, err := s.patterns.Get(workspace, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSONResponse(w, http.StatusOK, p)
+	case http.MethodDelete:
+		if err := s.patterns.Delete(workspace, name); err != nil {
+			http.Error(w, err.Error(), errs.HTTPStatus(err, http.StatusBadRequest))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Only GET and DELETE methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePatternAlerts returns the most recent alerts of the request's workspace and of shared
+// patterns, newest first.
+func (s *APIServer) handlePatternAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, s.patterns.Alerts(workspaceFrom(r.Context())))
+}
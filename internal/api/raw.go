@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultRawMessages = 100
+	maxRawMessages     = 500
+	maxRawBytes        = 4 << 20
+)
+
+// RawMessage is a Kafka message as returned by /raw. JSON payloads are returned as JSON,
+// anything else as text.
+type RawMessage struct {
+	Partition int32           `json:"partition"`
+	Offset    int64           `json:"offset"`
+	Timestamp time.Time       `json:"timestamp"`
+	Key       string          `json:"key,omitempty"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	Text      string          `json:"text,omitempty"`
+	Tombstone bool            `json:"tombstone,omitempty"`
+}
+
+type RawResponse struct {
+	Topic     string       `json:"topic"`
+	Partition int          `json:"partition"`
+	From      int64        `json:"from"`
+	To        int64        `json:"to"`
+	Messages  []RawMessage `json:"messages"`
+	Truncated bool         `json:"truncated,omitempty"`
+	NextFrom  *int64       `json:"next_from,omitempty"` // Offset to continue from when truncated
+}
+
+// handleRaw reads an offset range of a partition straight from Kafka, so the messages behind
+// a cited window can be inspected. The range is inclusive; without "to" it covers 100 offsets.
+func (s *APIServer) handleRaw(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	topic := query.Get("topic")
+	consumer, ok := s.consumers[topic]
+	if !ok {
+		http.Error(w, fmt.Sprintf("No Kafka consumer for topic '%s'", topic), http.StatusNotFound)
+		return
+	}
+	partition := 0
+	if v := query.Get("partition"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil || p < 0 {
+			http.Error(w, "'partition' must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		partition = p
+	}
+	from, err := strconv.ParseInt(query.Get("from"), 10, 64)
+	if err != nil || from < 0 {
+		http.Error(w, "'from' must be a non-negative offset", http.StatusBadRequest)
+		return
+	}
+	to := from + defaultRawMessages - 1
+	if v := query.Get("to"); v != "" {
+		to, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || to < from {
+			http.Error(w, "'to' must be an offset not before 'from'", http.StatusBadRequest)
+			return
+		}
+	}
+
+	raw, err := consumer.ReadRange(r.Context(), partition, from, to, maxRawMessages, maxRawBytes)
+	if err != nil {
+		log.Printf("Error reading raw messages of topic %s, partition %d, offsets %d-%d: %v", topic, partition, from, to, err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	resp := RawResponse{Topic: topic, Partition: partition, From: from, To: to, Messages: make([]RawMessage, 0, len(raw.Messages)), Truncated: raw.Truncated}
+	if raw.Truncated {
+		resp.NextFrom = &raw.NextFrom
+	}
+	for _, msg := range raw.Messages {
+		m := RawMessage{Partition: msg.Partition, Offset: msg.Offset, Timestamp: msg.Timestamp.In(s.location), Key: string(msg.Key), Tombstone: msg.IsTombstone()}
+		if json.Valid(msg.Value) {
+			m.Value = msg.Value
+		} else {
+			m.Text = string(msg.Value)
+		}
+		resp.Messages = append(resp.Messages, m)
+	}
+	writeJSONResponse(w, http.StatusOK, resp)
+}
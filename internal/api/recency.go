@@ -0,0 +1,45 @@
+package api
+
+import (
+	"time"
+
+	"stream-rag-agent/internal/vectordb"
+)
+
+const (
+	defaultRecencyScaleMinutes = 24 * 60
+	defaultRecencyDecay        = 0.5
+)
+
+// recencyDecay returns the recency decay applied to retrieval, nil if none. enabled and
+// scaleMinutes override query.recency when set; a scale alone turns the decay on.
+func (s *APIServer) recencyDecay(enabled *bool, scaleMinutes int) *vectordb.RecencyDecay {
+	cfg := s.queryConfig.Recency
+	on := cfg.Enabled || scaleMinutes > 0
+	if enabled != nil {
+		on = *enabled
+	}
+	if !on {
+		return nil
+	}
+	if scaleMinutes <= 0 {
+		scaleMinutes = cfg.ScaleMinutes
+	}
+	if scaleMinutes <= 0 {
+		scaleMinutes = defaultRecencyScaleMinutes
+	}
+	decay := &vectordb.RecencyDecay{
+		Scale:  time.Duration(scaleMinutes) * time.Minute,
+		Offset: time.Duration(cfg.OffsetMinutes) * time.Minute,
+		Decay:  cfg.Decay,
+		Weight: cfg.Weight,
+		Origin: time.Now(),
+	}
+	if decay.Decay <= 0 || decay.Decay >= 1 {
+		decay.Decay = defaultRecencyDecay
+	}
+	if decay.Weight <= 0 || decay.Weight > 1 {
+		decay.Weight = 1
+	}
+	return decay
+}
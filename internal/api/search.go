@@ -0,0 +1,99 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"stream-rag-agent/internal/vectordb"
+	"stream-rag-agent/pkg/errs"
+)
+
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 100
+)
+
+// SearchResult is a window found by /search.
+type SearchResult struct {
+	SourceWindow
+	Score        float64 `json:"score,omitempty"`
+	MessageCount int     `json:"message_count"`
+	ContextText  string  `json:"context_text"`
+}
+
+type SearchResponse struct {
+	Results    []SearchResult `json:"results"`
+	Total      int64          `json:"total"`
+	NextCursor string         `json:"next_cursor,omitempty"` // Pass as "cursor" for the next page; absent on the last page
+}
+
+// handleSearch pages through stored windows without involving the LLM: by keyword
+// relevance when "q" is set, otherwise newest first. Pages are fetched with search_after, so
+// deep pages stay cheap and results do not shift while paging.
+func (s *APIServer) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := &vectordb.SearchFilter{Topics: query["topic"], Categories: query["category"], EmbeddingModels: query["embedding_model"]}
+	for _, h := range query["header"] {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok || name == "" {
+			http.Error(w, "'header' must be name:value", http.StatusBadRequest)
+			return
+		}
+		if filter.Headers == nil {
+			filter.Headers = make(map[string]string)
+		}
+		filter.Headers[name] = value
+	}
+	for name, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := query.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("'%s' must be an RFC3339 timestamp", name), http.StatusBadRequest)
+				return
+			}
+			*target = t
+		}
+	}
+	size := defaultSearchPageSize
+	if v := query.Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSearchPageSize {
+			http.Error(w, fmt.Sprintf("'size' must be between 1 and %d", maxSearchPageSize), http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+
+	page, err := s.esClient.SearchWindows(r.Context(), strings.TrimSpace(query.Get("q")), filter, size, query.Get("cursor"))
+	if err != nil {
+		if errors.Is(err, errs.ErrInvalidRequest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error searching windows: %v", err)
+		http.Error(w, "Failed to search windows", errorStatus(err))
+		return
+	}
+
+	resp := SearchResponse{Results: make([]SearchResult, 0, len(page.Windows)), Total: page.Total, NextCursor: page.NextCursor}
+	sources := s.sourceWindows(page.Windows)
+	for i, ew := range page.Windows {
+		resp.Results = append(resp.Results, SearchResult{
+			SourceWindow: sources[i],
+			Score:        page.Scores[i],
+			MessageCount: ew.MessageCount,
+			ContextText:  ew.ContextText,
+		})
+	}
+	writeJSONResponse(w, http.StatusOK, resp)
+}
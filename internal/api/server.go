@@ -3,40 +3,149 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"stream-rag-agent/internal/config"
 	"stream-rag-agent/internal/embedding"
+	"stream-rag-agent/internal/governance"
+	"stream-rag-agent/internal/ingestion"
+	"stream-rag-agent/internal/kafka"
 	"stream-rag-agent/internal/llm"
+	"stream-rag-agent/internal/metrics"
+	"stream-rag-agent/internal/patterns"
 	"stream-rag-agent/internal/vectordb"
+	"stream-rag-agent/internal/views"
 	"stream-rag-agent/internal/window"
+	"stream-rag-agent/internal/workspaces"
+	"stream-rag-agent/pkg/errs"
 )
 
 type APIServer struct {
 	httpServer       *http.Server
 	embeddingService *embedding.Service
 	llmService       *llm.Service
-	esClient         *vectordb.ElasticsearchClient
+	store            vectordb.WindowStore
+	esClient         *vectordb.ElasticsearchClient // nil in dev mode, see requireElasticsearch
+	queryConfig      config.QueryConfig
+	views            *views.Store
+	consumers        map[string]*kafka.Consumer         // By topic, empty in demo mode
+	topics           map[string]config.KafkaTopicConfig // By name, for every source
+	location         *time.Location                     // Default reporting time zone for answers
+	sessions         *sessionStore                      // /chat sessions
+	apiKeys          map[string]config.APIKeyConfig     // By key, empty when the LLM endpoints are open
+	egress           *governance.Policy                 // nil when no external models are configured
+	reports          analyticsReports                   // Periodic reports on what users ask about
+	streams          *streamTracker                     // Open streaming responses, drained on shutdown
+	drainTimeout     time.Duration
+	windowLinks      config.WindowLinksConfig
+	patterns         *patterns.Detector // nil when pattern alerting is disabled
+	ingestion        *ingestion.Store   // nil when the server does not run the consumers, e.g. in the REPL
+	workspaces       *workspaces.Store  // nil in the REPL
+
+	reembedMu     sync.Mutex
+	reembedStatus *ReembedStatus // Most recent /admin/reembed job, nil if none ran
+
+	snapshotMu  sync.Mutex
+	snapshotJob *SnapshotJob // Most recent snapshot or restore, nil if none ran
 }
 
 type QueryRequest struct {
-	Prompt string `json:"prompt"`
+	Prompt              string   `json:"prompt"`
+	View                string   `json:"view,omitempty"`                  // Name of a saved view scoping retrieval
+	Mode                string   `json:"mode,omitempty"`                  // "rag", "structured" for aggregation questions, "tail" for the latest windows or "auto"; empty is classified per query.intent
+	TimeZone            string   `json:"time_zone,omitempty"`             // IANA zone to report times in, overriding the configured one
+	Expand              *bool    `json:"expand,omitempty"`                // Multi-query expansion, overriding the configured default
+	Verbosity           string   `json:"verbosity,omitempty"`             // "brief", "normal" or "detailed"
+	Model               string   `json:"model,omitempty"`                 // LLM model from ollama.models, empty uses the configured one
+	Validate            *bool    `json:"validate,omitempty"`              // Numeric validation of the answer, overriding the configured default
+	Debug               bool     `json:"debug,omitempty"`                 // Include retrieval parameters in the response
+	Explain             bool     `json:"explain,omitempty"`               // Include why each window was retrieved and how the prompt was budgeted
+	Citations           *bool    `json:"citations,omitempty"`             // Inline citation markers in the answer, overriding query.citations.enabled
+	DeadlineMs          int      `json:"deadline_ms,omitempty"`           // Response time budget, overriding the X-Deadline-Ms header and the configured default
+	Recency             *bool    `json:"recency,omitempty"`               // Recency decay of retrieval scores, overriding the configured default
+	RecencyScaleMinutes int      `json:"recency_scale_minutes,omitempty"` // Overrides query.recency.scale_minutes and turns the decay on
+	EmbeddingModels     []string `json:"embedding_models,omitempty"`      // Only retrieve windows embedded by these models, overriding those of the view
+	Topics              []string `json:"topics,omitempty"`                // Only retrieve windows of these topics, overriding those of the view
+	Stream              bool     `json:"stream,omitempty"`                // Answer with server-sent events instead of a JSON body
 }
 
 type QueryResponse struct {
-	Answer string `json:"answer"`
-	Error  string `json:"error,omitempty"`
+	Answer      string                      `json:"answer"`
+	Mode        string                      `json:"mode,omitempty"`
+	Intent      *IntentDecision             `json:"intent,omitempty"` // How the mode was chosen
+	Sources     []SourceWindow              `json:"sources,omitempty"`
+	Aggregation *vectordb.AggregationResult `json:"aggregation,omitempty"` // Computed figures for structured queries
+	Validation  *NumericValidation          `json:"validation,omitempty"`  // Numeric validation of RAG answers, when requested
+	Debug       *RetrievalDebug             `json:"debug,omitempty"`
+	Explain     *QueryExplanation           `json:"explain,omitempty"`   // Why the context was chosen, when requested
+	Citations   *CitationReport             `json:"citations,omitempty"` // Windows the answer's citation markers stand for, when citations are requested
+	Deadline    *DeadlineReport             `json:"deadline,omitempty"`  // How the deadline was spent, for queries with one
+	Degraded    bool                        `json:"degraded,omitempty"`  // Context was found by keyword search because the prompt could not be embedded
+	Error       string                      `json:"error,omitempty"`
+
+	Windows []window.EmbeddedWindow `json:"-"` // The windows the answer was generated from, for callers in the process such as the REPL
+}
+
+// SourceWindow describes a retrieved window used to answer a query.
+type SourceWindow struct {
+	WindowID       string     `json:"window_id"`
+	Topic          string     `json:"topic"`
+	Partition      int32      `json:"partition"`
+	Shard          *int       `json:"shard,omitempty"`        // Key shard of the partition, for topics with key_sharding
+	FirstOffset    *int64     `json:"first_offset,omitempty"` // Offsets of the partition the window covers, see /raw
+	LastOffset     *int64     `json:"last_offset,omitempty"`
+	StartTime      time.Time  `json:"start_time"`
+	EndTime        time.Time  `json:"end_time"`
+	ContextVersion string     `json:"context_version,omitempty"`
+	CloseReason    string     `json:"close_reason,omitempty"`
+	Quality        string     `json:"quality,omitempty"` // Why the window is partial or degraded, empty if complete
+	Category       string     `json:"category,omitempty"`
+	EmbeddingModel string     `json:"embedding_model,omitempty"`
+	EmbeddedAt     *time.Time `json:"embedded_at,omitempty"`
+	Link           string     `json:"link,omitempty"` // Deep link into the window explorer, see api.window_links
 }
 
-func NewAPIServer(embedSvc *embedding.Service, llmSvc *llm.Service, esClient *vectordb.ElasticsearchClient) *APIServer {
+func NewAPIServer(embedSvc *embedding.Service, llmSvc *llm.Service, store vectordb.WindowStore, queryCfg config.QueryConfig, viewStore *views.Store, consumers []*kafka.Consumer, topics []config.KafkaTopicConfig, loc *time.Location, apiCfg config.APIConfig, egress *governance.Policy, detector *patterns.Detector, ingestionStore *ingestion.Store, workspaceStore *workspaces.Store) *APIServer {
+	consumersByTopic := make(map[string]*kafka.Consumer, len(consumers))
+	for _, c := range consumers {
+		consumersByTopic[c.Topic()] = c
+	}
+	topicConfigs := make(map[string]config.KafkaTopicConfig, len(topics))
+	for _, t := range topics {
+		topicConfigs[t.Name] = t
+	}
+	apiKeys := make(map[string]config.APIKeyConfig, len(apiCfg.Keys))
+	for _, k := range apiCfg.Keys {
+		apiKeys[k.Key] = k
+	}
+	esClient, _ := store.(*vectordb.ElasticsearchClient)
 	mux := http.NewServeMux()
 	server := &APIServer{
 		embeddingService: embedSvc,
 		llmService:       llmSvc,
+		store:            store,
 		esClient:         esClient,
+		queryConfig:      queryCfg,
+		views:            viewStore,
+		consumers:        consumersByTopic,
+		topics:           topicConfigs,
+		location:         loc,
+		sessions:         newSessionStore(queryCfg.Sessions),
+		apiKeys:          apiKeys,
+		egress:           egress,
+		streams:          newStreamTracker(),
+		drainTimeout:     defaultDrainTimeout,
+		windowLinks:      apiCfg.WindowLinks,
+		patterns:         detector,
+		ingestion:        ingestionStore,
+		workspaces:       workspaceStore,
 		httpServer: &http.Server{
 			Addr:         ":8080",
 			Handler:      mux,
@@ -46,8 +155,44 @@ func NewAPIServer(embedSvc *embedding.Service, llmSvc *llm.Service, esClient *ve
 		},
 	}
 
-	mux.HandleFunc("/query", server.handleQuery)
+	if apiCfg.DrainTimeoutSeconds > 0 {
+		server.drainTimeout = time.Duration(apiCfg.DrainTimeoutSeconds) * time.Second
+	}
+
+	// Operational endpoints are unversioned; API routes are served under /v1 and, for existing
+	// clients, under their original paths (see handleVersioned)
+	handleVersioned(mux, "/query", server.requireAPIKey(server.inWorkspace(server.trackQuery(server.handleQuery))))
+	handleVersioned(mux, "/chat", server.requireAPIKey(server.inWorkspace(server.trackQuery(server.handleChat))))
 	mux.HandleFunc("/health", server.handleHealth)
+	handleVersioned(mux, "/raw", server.requireKey(server.handleRaw))
+	handleVersioned(mux, "/search", server.requireKey(server.requireElasticsearch(server.handleSearch)))
+	handleVersioned(mux, "/stats", server.requireKey(server.handleStats))
+	handleVersioned(mux, "/v1/chat/completions", server.requireAPIKey(server.trackQuery(server.handleChatCompletions)))
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/openapi.json", server.handleOpenAPI)
+	handleVersioned(mux, "/views", server.requireKey(server.inWorkspace(server.handleViews)))
+	handleVersioned(mux, "/views/{name}", server.requireKey(server.inWorkspace(server.handleView)))
+	handleVersioned(mux, "/patterns", server.requireKey(server.requirePatterns(server.inWorkspace(server.handlePatterns))))
+	handleVersioned(mux, "/patterns/alerts", server.requireKey(server.requirePatterns(server.inWorkspace(server.handlePatternAlerts))))
+	handleVersioned(mux, "/patterns/{name}", server.requireKey(server.requirePatterns(server.inWorkspace(server.handlePattern))))
+	handleVersioned(mux, "/workspaces", server.requireWorkspaces(server.handleWorkspaces))
+	handleVersioned(mux, "/workspaces/{workspace}", server.inWorkspace(server.handleWorkspace))
+	handleVersioned(mux, "/workspaces/{workspace}/queries", server.inWorkspace(server.handleWorkspaceQueries))
+	handleVersioned(mux, "/workspaces/{workspace}/queries/{query}", server.inWorkspace(server.handleWorkspaceQuery))
+	handleVersioned(mux, "/workspaces/{workspace}/queries/{query}/run", server.requireAPIKey(server.inWorkspace(server.trackQuery(server.handleRunWorkspaceQuery))))
+	handleVersioned(mux, "/admin/snapshots", server.requireAdminKey(server.requireElasticsearch(server.handleSnapshots)))
+	handleVersioned(mux, "/admin/snapshots/restore", server.requireAdminKey(server.requireElasticsearch(server.handleSnapshotRestore)))
+	handleVersioned(mux, "/admin/snapshots/status", server.requireAdminKey(server.requireElasticsearch(server.handleSnapshotStatus)))
+	handleVersioned(mux, "/admin/reembed", server.requireAdminKey(server.requireElasticsearch(server.handleReembed)))
+	handleVersioned(mux, "/admin/offsets/reset", server.requireAdminKey(server.handleOffsetReset))
+	handleVersioned(mux, "/admin/offsets/coverage", server.requireAdminKey(server.requireElasticsearch(server.handleOffsetCoverage)))
+	handleVersioned(mux, "/admin/duplicates", server.requireAdminKey(server.requireElasticsearch(server.handleDuplicates)))
+	handleVersioned(mux, "/admin/faults", server.requireAdminKey(server.handleFaults))
+	handleVersioned(mux, "/admin/index/stats", server.requireAdminKey(server.requireElasticsearch(server.handleIndexStats)))
+	handleVersioned(mux, "/admin/index/errors", server.requireAdminKey(server.requireElasticsearch(server.handleIndexErrors)))
+	handleVersioned(mux, "/admin/analytics", server.requireAdminKey(server.requireElasticsearch(server.handleAnalytics)))
+	handleVersioned(mux, "/admin/ingestion", server.requireAdminKey(server.requireIngestion(server.handleIngestion)))
+	handleVersioned(mux, "/admin/ingestion/{topic}", server.requireAdminKey(server.requireIngestion(server.handleTopicIngestion)))
 	return server
 }
 
@@ -56,8 +201,11 @@ func (s *APIServer) Start() error {
 	return s.httpServer.ListenAndServe()
 }
 
+// Shutdown asks streaming responses to finish, then stops accepting connections and waits
+// for in-flight requests until ctx expires.
 func (s *APIServer) Shutdown(ctx context.Context) error {
 	log.Println("API server shutting down...")
+	s.streams.drain(ctx, s.drainTimeout)
 	return s.httpServer.Shutdown(ctx)
 }
 
@@ -78,73 +226,444 @@ func (s *APIServer) handleQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Prompt == "" {
-		http.Error(w, "Prompt cannot be empty", http.StatusBadRequest)
+	s.respondQuery(w, r, req)
+}
+
+// respondQuery answers a query with a JSON body or, for streamed requests, with server-sent
+// events: keep-alive comments until the answer is ready, then an "answer" event with the
+// response or an "error" event. Streams are not bound by the server's write timeout.
+func (s *APIServer) respondQuery(w http.ResponseWriter, r *http.Request, req QueryRequest) {
+	deadline := r.Header.Get(deadlineHeader)
+	if !req.Stream {
+		resp, status, err := s.answerQuery(r.Context(), req, deadline)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSONResponse(w, status, resp)
 		return
 	}
+	s.streams.serve(w, r, func(ctx context.Context) func(*sseStream) error {
+		resp, _, err := s.answerQuery(ctx, req, deadline)
+		if err != nil {
+			return func(stream *sseStream) error {
+				return stream.Event("error", map[string]string{"error": err.Error()})
+			}
+		}
+		return func(stream *sseStream) error {
+			return stream.Event("answer", resp)
+		}
+	})
+}
+
+// Ask answers a query the way POST /query does, without going through HTTP, e.g. for the
+// REPL. Invalid requests return an error; failures to answer are reported in the response's
+// Error.
+func (s *APIServer) Ask(ctx context.Context, req QueryRequest) (QueryResponse, error) {
+	resp, _, err := s.answerQuery(ctx, req, "")
+	return resp, err
+}
+
+// answerQuery answers a query. It returns an error if the request is invalid, and otherwise
+// the response with its HTTP status. deadline is the value of the X-Deadline-Ms header.
+func (s *APIServer) answerQuery(ctx context.Context, req QueryRequest, deadline string) (QueryResponse, int, error) {
+	if req.Prompt == "" {
+		return QueryResponse{}, 0, errors.New("Prompt cannot be empty")
+	}
+
+	switch req.Mode {
+	case "", ModeAuto, ModeRAG, ModeStructured, ModeTail:
+	default:
+		return QueryResponse{}, 0, fmt.Errorf("'mode' must be one of %s, %s, %s or %s", ModeRAG, ModeStructured, ModeTail, ModeAuto)
+	}
 
 	log.Printf("Received query: %s", req.Prompt)
+	rec := queryRecordFrom(ctx)
+	rec.Question = req.Prompt
 
-	// 1. Get embedding for the user's prompt
-	queryEmbedding, err := s.embeddingService.GetEmbedding(req.Prompt)
+	filter, err := s.viewFilter(workspaceFrom(ctx), req.View)
 	if err != nil {
-		log.Printf("Error getting embedding for prompt '%s': %v", req.Prompt, err)
-		writeJSONResponse(w, http.StatusInternalServerError, QueryResponse{Error: "Failed to embed prompt"})
-		return
+		return QueryResponse{}, 0, err
 	}
+	filter = withTopics(filter, req.Topics)
+	filter = withEmbeddingModels(filter, req.EmbeddingModels)
 
-	// 2. Search for similar windows in Elasticsearch
-	// Adjust 'k' (number of results) as needed for context size vs. LLM token limit
-	topK := 5 // Retrieve top 5 most similar windows
-	similarWindows, err := s.esClient.SearchSimilarWindows(queryEmbedding, topK)
+	style, err := s.answerStyle(req.TimeZone, req.Verbosity, req.Model)
 	if err != nil {
-		log.Printf("Error searching similar windows in Elasticsearch: %v", err)
-		writeJSONResponse(w, http.StatusInternalServerError, QueryResponse{Error: "Failed to retrieve relevant context"})
-		return
+		return QueryResponse{}, 0, err
+	}
+	style.limitTokens(apiKeyFrom(ctx).MaxTokens)
+	if req.Citations != nil {
+		style.citations = *req.Citations
+	}
+	rec.Model = style.egress.Model
+	if req.RecencyScaleMinutes < 0 {
+		return QueryResponse{}, 0, errors.New("'recency_scale_minutes' must not be negative")
+	}
+	if req.Recency != nil || req.RecencyScaleMinutes > 0 {
+		style.recency = s.recencyDecay(req.Recency, req.RecencyScaleMinutes)
+	}
+	budget, err := s.queryBudget(deadline, req.DeadlineMs)
+	if err != nil {
+		return QueryResponse{}, 0, err
 	}
 
-	// 3. Construct RAG prompt with retrieved context
-	ragPrompt := buildRAGPrompt(req.Prompt, similarWindows)
-	log.Printf("Sending RAG prompt to LLM (truncated): %s...", ragPrompt[:min(len(ragPrompt), 500)])
+	// 0. Optionally translate the question into the language of the stream context, and
+	// choose how to answer it
+	question, questionLanguage := s.translateQuery(req.Prompt)
+	intent := s.classifyIntent(question, req.Mode)
+	rec.Mode = intent.Mode
 
-	// 4. Generate LLM response
-	llmAnswer, err := s.llmService.GenerateContent(ragPrompt)
+	if intent.Mode == ModeStructured {
+		answer, aggregation, err := s.answerStructured(ctx, question, style)
+		if err != nil {
+			log.Printf("Error answering structured query '%s': %v", req.Prompt, err)
+			return QueryResponse{Mode: ModeStructured, Intent: &intent, Error: "Failed to answer structured query: " + err.Error()}, errorStatus(err), nil
+		}
+		answer = s.translateAnswer(answer, questionLanguage)
+		return QueryResponse{Answer: answer, Mode: ModeStructured, Intent: &intent, Aggregation: aggregation}, http.StatusOK, nil
+	}
+
+	// 1-2. Embed the prompt and search for similar windows in Elasticsearch, or take the
+	// latest windows for tail questions
+	expand := s.queryConfig.Expansion.Enabled
+	if req.Expand != nil {
+		expand = *req.Expand
+	}
+	var keywordOnly bool
+	ex := newExplainer(req.Explain)
+	similarWindows, err := budget.retrieve(ctx, func(ctx context.Context) ([]window.EmbeddedWindow, error) {
+		if intent.Mode == ModeTail {
+			return s.tailContext(ctx, style.scope(filter), ex)
+		}
+		windows, keyword, err := s.retrieveContext(ctx, question, style.scope(filter), expand, ex)
+		keywordOnly = keyword
+		return windows, err
+	})
+	if err != nil {
+		log.Printf("Error retrieving context for prompt '%s': %v", req.Prompt, err)
+		return QueryResponse{Mode: intent.Mode, Intent: &intent, Error: retrievalErrorMessage(err), Deadline: budget.deadlineReport()}, retrievalErrorStatus(err), nil
+	}
+	retrieved := similarWindows
+	similarWindows, err = s.fitGeneration(budget, &style, similarWindows)
+	if err != nil {
+		log.Printf("Not generating an answer to '%s': %v", req.Prompt, err)
+		return QueryResponse{Mode: intent.Mode, Intent: &intent, Error: generationErrorMessage(err), Deadline: budget.deadlineReport()}, generationErrorStatus(err), nil
+	}
+	ex.fitted(retrieved, similarWindows, style)
+	rec.Model = style.egress.Model
+
+	noteSources(rec, similarWindows)
+
+	// 3. Construct system prompt with instructions and retrieved context
+	systemPrompt := buildSystemPrompt(s.llmService.SystemPrompt(), similarWindows, style)
+	log.Printf("Sending RAG system prompt to LLM (truncated): %s...", systemPrompt[:min(len(systemPrompt), 500)])
+	explanation := ex.explain(s, style, similarWindows, systemPrompt, question)
+
+	// 4. Generate LLM response, keeping the user question separate from the instructions
+	llmAnswer, err := s.llmService.GenerateWithOptions(ctx, systemPrompt, question, style.options())
 	if err != nil {
 		log.Printf("Error generating LLM content: %v", err)
-		writeJSONResponse(w, http.StatusInternalServerError, QueryResponse{Error: "Failed to generate LLM response"})
-		return
+		return QueryResponse{Error: generationErrorMessage(err), Deadline: budget.deadlineReport()}, generationErrorStatus(err), nil
+	}
+
+	llmAnswer, citations := s.checkCitations(llmAnswer, similarWindows, style)
+
+	// 5. Optionally check the figures in the answer against the structured events
+	validate := s.queryConfig.NumericValidation.Enabled
+	if req.Validate != nil {
+		validate = *req.Validate
+	}
+	var validation *NumericValidation
+	if validate && budget.expired() {
+		validation = &NumericValidation{Status: ValidationSkipped, Message: "the query deadline was reached"}
+		budget.degraded(DegradedNoValidation)
+	} else if validate {
+		validation = s.validateNumbers(question, llmAnswer, style)
+	}
+	noteAnswer(ctx, question, llmAnswer, similarWindows)
+	llmAnswer = s.translateAnswer(llmAnswer, questionLanguage)
+	llmAnswer = s.withFootnotes(llmAnswer, similarWindows, style)
+
+	resp := QueryResponse{Answer: llmAnswer, Mode: intent.Mode, Intent: &intent, Sources: s.sourceWindows(similarWindows), Validation: validation, Deadline: budget.deadlineReport(), Degraded: keywordOnly, Windows: similarWindows, Explain: explanation, Citations: citations}
+	if req.Debug && s.esClient != nil && intent.Mode == ModeRAG {
+		resp.Debug = &RetrievalDebug{K: retrievalTopK, NumCandidates: s.esClient.NumCandidates(retrievalTopK)}
+		if style.recency != nil {
+			resp.Debug.RecencyScaleMinutes = int(style.recency.Scale / time.Minute)
+		}
 	}
 
 	log.Printf("Successfully generated LLM answer for query: %s", req.Prompt)
-	writeJSONResponse(w, http.StatusOK, QueryResponse{Answer: llmAnswer})
+	return resp, http.StatusOK, nil
+}
+
+// translateQuery translates the question into the configured target language when translation
+// is enabled. It returns the text to use for retrieval and generation, and the detected language
+// of the original question (empty if unknown or already in the target language).
+func (s *APIServer) translateQuery(prompt string) (string, string) {
+	cfg := s.queryConfig.Translation
+	if !cfg.Enabled || cfg.TargetLanguage == "" {
+		return prompt, ""
+	}
+
+	result, err := s.llmService.DetectAndTranslate(prompt, cfg.TargetLanguage)
+	if err != nil {
+		log.Printf("Warning: query translation failed, using original prompt: %v", err)
+		return prompt, ""
+	}
+	if llm.SameLanguage(result.Language, cfg.TargetLanguage) {
+		return prompt, ""
+	}
+	log.Printf("Translated query from %s to %s: %s", result.Language, cfg.TargetLanguage, result.Translation)
+	return result.Translation, result.Language
+}
+
+// translateAnswer translates the answer back into the language of the question when configured.
+func (s *APIServer) translateAnswer(answer, language string) string {
+	if language == "" || !s.queryConfig.Translation.TranslateAnswer {
+		return answer
+	}
+	translated, err := s.llmService.Translate(answer, language)
+	if err != nil {
+		log.Printf("Warning: answer translation to %s failed, returning untranslated answer: %v", language, err)
+		return answer
+	}
+	return translated
 }
 
-// buildRAGPrompt constructs the prompt to be sent to the LLM, including retrieved context.
-func buildRAGPrompt(userPrompt string, contextWindows []window.EmbeddedWindow) string {
+var (
+	errEmbedPrompt     = errors.New("failed to embed prompt")
+	errRetrieveContext = errors.New("failed to retrieve relevant context")
+
+	keywordFallbackTotal = metrics.NewCounter("retrieval_keyword_fallback_total", "Queries answered from keyword search because the prompt could not be embedded.")
+)
+
+// retrieveContext embeds the prompt and returns the most similar windows from Elasticsearch,
+// restricted by the optional filter. With expand set, LLM-generated paraphrases of the prompt
+// are searched as well and the result lists are merged. When the prompt cannot be embedded,
+// the windows are found by keyword search instead and keywordOnly is set, so the API stays
+// useful while the embedding service is down. Windows mostly covered by a better-ranked one
+// are left out, see dropOverlaps. ex, if not nil, records how the windows were found. The
+// expansion, embedding and search calls give up when ctx is done.
+func (s *APIServer) retrieveContext(ctx context.Context, prompt string, filter *vectordb.SearchFilter, expand bool, ex *explainer) (windows []window.EmbeddedWindow, keywordOnly bool, err error) {
+	topK := retrievalTopK
+	filter = ex.scope(filter)
+
+	queries := []string{prompt}
+	if expand {
+		n := s.queryConfig.Expansion.Queries
+		if n <= 0 {
+			n = defaultExpansionQueries
+		}
+		expanded, err := s.llmService.ExpandQuery(ctx, prompt, n)
+		if err != nil {
+			log.Printf("Warning: query expansion failed, retrieving with the original prompt only: %v", err)
+		} else {
+			log.Printf("Expanded query into: %q", expanded)
+			queries = append(queries, expanded...)
+		}
+	}
+
+	results := make([][]window.EmbeddedWindow, 0, len(queries))
+	for i, q := range queries {
+		queryEmbedding, err := s.embeddingService.GetEmbedding(ctx, q)
+		if err != nil {
+			if i == 0 {
+				ex.searched(retrievalKeyword, queries[:1], topK)
+				windows, err := s.keywordContext(ctx, prompt, topK, filter, err)
+				ex.rank(windows)
+				return s.dropOverlaps(windows, ex), err == nil, err
+			}
+			log.Printf("Warning: failed to embed expanded query %q: %v", q, err)
+			continue
+		}
+		similarWindows, err := s.store.SearchSimilarWindows(ctx, queryEmbedding, topK, filter)
+		if err != nil {
+			if i == 0 {
+				return nil, false, fmt.Errorf("%w: %w", errRetrieveContext, err)
+			}
+			log.Printf("Warning: failed to search with expanded query %q: %v", q, err)
+			continue
+		}
+		ex.retrieved(similarWindows)
+		results = append(results, similarWindows)
+	}
+	ex.searched(retrievalVector, queries, topK)
+	if len(results) == 1 {
+		windows = results[0]
+	} else {
+		windows = mergeResults(results, topK)
+	}
+	ex.rank(windows)
+	return s.dropOverlaps(windows, ex), false, nil
+}
+
+// keywordContext retrieves windows by keyword search after embedding the prompt failed with
+// embedErr. Paraphrases from query expansion are not used; they only help vector search.
+func (s *APIServer) keywordContext(ctx context.Context, prompt string, k int, filter *vectordb.SearchFilter, embedErr error) ([]window.EmbeddedWindow, error) {
+	log.Printf("Warning: failed to embed prompt, falling back to keyword search: %v", embedErr)
+	windows, err := s.store.SearchKeywordWindows(ctx, prompt, k, filter)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w (keyword search: %w)", errEmbedPrompt, embedErr, err)
+	}
+	keywordFallbackTotal.Inc()
+	return windows, nil
+}
+
+const defaultExpansionQueries = 3
+
+// Adjust 'k' (number of results) as needed for context size vs. LLM token limit
+const retrievalTopK = 5 // Retrieve top 5 most similar windows
+
+// RetrievalDebug describes how the context of a query was retrieved.
+type RetrievalDebug struct {
+	K                   int `json:"k"`
+	NumCandidates       int `json:"num_candidates"`                  // kNN candidates per shard, scaled with the index size
+	RecencyScaleMinutes int `json:"recency_scale_minutes,omitempty"` // Scale of the recency decay results were reranked with, absent without decay
+}
+
+// mergeResults fuses ranked result lists with reciprocal rank fusion, dropping duplicate
+// windows, and keeps the best limit windows.
+func mergeResults(results [][]window.EmbeddedWindow, limit int) []window.EmbeddedWindow {
+	const rrfK = 60.0
+	scores := make(map[string]float64)
+	windows := make(map[string]window.EmbeddedWindow)
+	var order []string
+	for _, list := range results {
+		for rank, w := range list {
+			if _, seen := windows[w.WindowID]; !seen {
+				windows[w.WindowID] = w
+				order = append(order, w.WindowID)
+			}
+			scores[w.WindowID] += 1 / (rrfK + float64(rank+1))
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool { return scores[order[i]] > scores[order[j]] })
+	if len(order) > limit {
+		order = order[:limit]
+	}
+	merged := make([]window.EmbeddedWindow, 0, len(order))
+	for _, id := range order {
+		merged = append(merged, windows[id])
+	}
+	return merged
+}
+
+// retrievalErrorMessage maps a retrieveContext error to the message returned to clients.
+func retrievalErrorMessage(err error) string {
+	if errors.Is(err, errDeadlineExceeded) {
+		return "Query deadline exceeded while retrieving context"
+	}
+	if errors.Is(err, errEmbedPrompt) {
+		return "Failed to embed prompt"
+	}
+	return "Failed to retrieve relevant context"
+}
+
+func retrievalErrorStatus(err error) int {
+	if errors.Is(err, errDeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return errorStatus(err)
+}
+
+// generationErrorMessage and generationErrorStatus describe a failed LLM call, which times
+// out when it runs past the query's deadline.
+func generationErrorMessage(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errDeadlineExceeded) {
+		return "Query deadline exceeded while generating the answer"
+	}
+	return "Failed to generate LLM response"
+}
+
+func generationErrorStatus(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errDeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return errorStatus(err)
+}
+
+// errorStatus is the status of a request that failed with err: the status of its category
+// (see errs.HTTPStatus), e.g. 503 while Ollama or Elasticsearch is down, or 500.
+func errorStatus(err error) int {
+	return errs.HTTPStatus(err, http.StatusInternalServerError)
+}
+
+const defaultSystemPrompt = "You are an AI assistant specialized in analyzing Kafka streaming data. " +
+	"Use the provided data from Kafka topics to answer the user's question. " +
+	"If the answer is not in the provided data, state that you don't have enough information. " +
+	"If a window notes that it was sampled, say that counts and totals derived from it are approximate. " +
+	"Do NOT make up information."
+
+// buildSystemPrompt constructs the system message sent to the LLM: the instructions
+// (configured override or default), the answer style, then the retrieved context.
+func buildSystemPrompt(instructions string, contextWindows []window.EmbeddedWindow, style answerStyle) string {
+	if instructions == "" {
+		instructions = defaultSystemPrompt
+	}
+
 	var sb strings.Builder
-	sb.WriteString("You are an AI assistant specialized in analyzing Kafka streaming data. ")
-	sb.WriteString("Use the provided data from Kafka topics to answer the user's question. ")
-	sb.WriteString("If the answer is not in the provided data, state that you don't have enough information. ")
-	sb.WriteString("Do NOT make up information.\n\n")
+	sb.WriteString(instructions)
+	sb.WriteString("\n")
+	sb.WriteString(style.instructions())
+	sb.WriteString("\n\n")
 
 	sb.WriteString("--- RELEVANT KAFKA DATA ---\n")
 	if len(contextWindows) == 0 {
 		sb.WriteString("No relevant Kafka data found.\n")
 	} else {
 		for i, w := range contextWindows {
-			sb.WriteString(fmt.Sprintf("--- Window %d (Topic: %s, ID: %s) ---\n", i+1, w.Topic, w.WindowID))
+			marker := ""
+			if style.citations {
+				marker = fmt.Sprintf(" [%s]", citationMarker(i))
+			}
+			sb.WriteString(fmt.Sprintf("--- Window %d%s (Topic: %s, ID: %s, Topic Context Version: %s, Time Range: %s - %s) ---\n",
+				i+1, marker, w.Topic, w.WindowID, contextVersionLabel(w), w.StartTime.In(style.location).Format(time.RFC3339), w.EndTime.In(style.location).Format(time.RFC3339)))
+			if w.Category != "" {
+				sb.WriteString(fmt.Sprintf("Category: %s\n", w.Category))
+			}
+			if notes := w.QualityNotes(); notes != "" {
+				sb.WriteString(fmt.Sprintf("Data quality: %s. Counts and totals from this window may be incomplete.\n", notes))
+			}
 			sb.WriteString(w.ContextText) // summarized text from Kafka window
 			sb.WriteString("\n\n")
 		}
 	}
-	sb.WriteString("--------------------------\n\n")
-	sb.WriteString("USER QUESTION: ")
-	sb.WriteString(userPrompt)
-	sb.WriteString("\n")
+	sb.WriteString("--------------------------\n")
 
 	return sb.String()
 }
 
+// timeZoneInstruction tells the LLM which zone to report times in. Stored context may have
+// been rendered in a different zone, so the model is asked to convert using the UTC offsets.
+func timeZoneInstruction(loc *time.Location) string {
+	_, offset := time.Now().In(loc).Zone()
+	return fmt.Sprintf("Report all dates and times in the %s time zone (UTC%s), converting timestamps from the data using their UTC offsets.",
+		loc, formatUTCOffset(offset))
+}
+
+func formatUTCOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	return fmt.Sprintf("%s%02d:%02d", sign, seconds/3600, seconds%3600/60)
+}
+
+// contextVersionLabel describes which topic context version a window was rendered with,
+// so the LLM does not interpret older windows with a newer topic description.
+func contextVersionLabel(w window.EmbeddedWindow) string {
+	if w.ContextVersion == "" {
+		return "unknown"
+	}
+	if w.ContextEffectiveFrom != nil {
+		return fmt.Sprintf("%s, effective from %s", w.ContextVersion, w.ContextEffectiveFrom.Format(time.RFC3339))
+	}
+	return w.ContextVersion
+}
+
 func writeJSONResponse(w http.ResponseWriter, statusCode int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
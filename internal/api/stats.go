@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"stream-rag-agent/internal/kafka"
+)
+
+// StatsResponse reports how far the agent's consumers are behind their streams.
+type StatsResponse struct {
+	ConsumerLag []kafka.TopicLag `json:"consumer_lag"` // Kafka topics, by topic name
+}
+
+// handleStats returns the last measured consumer lag of every Kafka topic.
+func (s *APIServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	resp := StatsResponse{ConsumerLag: make([]kafka.TopicLag, 0, len(s.consumers))}
+	for _, c := range s.consumers {
+		resp.ConsumerLag = append(resp.ConsumerLag, c.Lag(r.Context()))
+	}
+	sort.Slice(resp.ConsumerLag, func(i, j int) bool { return resp.ConsumerLag[i].Topic < resp.ConsumerLag[j].Topic })
+	writeJSONResponse(w, http.StatusOK, resp)
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDrainTimeout = 5 * time.Second
+
+	// keepAliveInterval is how often a stream that has nothing to send yet sends a comment, so
+	// proxies do not close it as idle.
+	keepAliveInterval = 15 * time.Second
+)
+
+// errDraining is returned when a stream is opened while the server shuts down.
+var errDraining = errors.New("server is shutting down")
+
+// streamTracker keeps track of open streaming (server-sent events) responses. Shutdown
+// waits for handlers, which streams never finish on their own, so it first asks every
+// stream to end and gives them the drain timeout to send a final event and return.
+type streamTracker struct {
+	mu       sync.Mutex
+	active   map[*sseStream]struct{}
+	draining bool
+	wg       sync.WaitGroup
+}
+
+func newStreamTracker() *streamTracker {
+	return &streamTracker{active: make(map[*sseStream]struct{})}
+}
+
+// sseStream is an open server-sent events response. Handlers must select on Draining
+// alongside their own work and call Close when done.
+type sseStream struct {
+	tracker *streamTracker
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	drain   chan struct{}
+	once    sync.Once
+}
+
+// open starts a server-sent events response. The server's write timeout does not apply to
+// streams, so their length is bounded by the client and by draining.
+func (t *streamTracker) open(w http.ResponseWriter) (*sseStream, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining {
+		return nil, errDraining
+	}
+
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return nil, fmt.Errorf("failed to clear write deadline: %w", err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	s := &sseStream{tracker: t, w: w, rc: rc, drain: make(chan struct{})}
+	t.active[s] = struct{}{}
+	t.wg.Add(1)
+	return s, nil
+}
+
+// Event writes one event with JSON data and flushes it to the client.
+func (s *sseStream) Event(name string, data interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %w", name, err)
+	}
+	if name != "" {
+		if _, err := fmt.Fprintf(s.w, "event: %s\n", name); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", body); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// Data writes one unnamed event with raw data, e.g. "[DONE]", and flushes it to the client.
+func (s *sseStream) Data(data string) error {
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// Comment writes a comment line, which clients ignore, and flushes it to the client.
+func (s *sseStream) Comment(text string) error {
+	if _, err := fmt.Fprintf(s.w, ": %s\n\n", text); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// Draining is closed when the server starts shutting down.
+func (s *sseStream) Draining() <-chan struct{} {
+	return s.drain
+}
+
+// Close ends the stream. If the server is draining, a final "shutdown" event tells the
+// client to reconnect later rather than treating the end as a complete response.
+func (s *sseStream) Close() {
+	s.once.Do(func() {
+		select {
+		case <-s.drain:
+			if err := s.Event("shutdown", map[string]string{"reason": "server is shutting down, reconnect later"}); err != nil {
+				log.Printf("Failed to send shutdown event to stream: %v", err)
+			}
+		default:
+		}
+		s.tracker.mu.Lock()
+		delete(s.tracker.active, s)
+		s.tracker.mu.Unlock()
+		s.tracker.wg.Done()
+	})
+}
+
+// serve answers a request with a stream. work runs while the stream sends keep-alive comments
+// and returns the function sending its result. If the server starts draining or the client
+// goes away first, the stream is closed at once and the ctx given to work is cancelled, so
+// the handler returns as soon as work gives up, discarding its result. Requests that arrive
+// while draining get a 503.
+func (t *streamTracker) serve(w http.ResponseWriter, r *http.Request, work func(ctx context.Context) func(*sseStream) error) {
+	stream, err := t.open(w)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errDraining) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer stream.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	result := make(chan func(*sseStream) error, 1)
+	go func() { result <- work(ctx) }()
+	stop := func() {
+		stream.Close()
+		cancel()
+		<-result
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case send := <-result:
+			if err := send(stream); err != nil {
+				log.Printf("Failed to send stream of %s: %v", r.URL.Path, err)
+			}
+			return
+		case <-keepAlive.C:
+			if err := stream.Comment("keep-alive"); err != nil {
+				stop()
+				return
+			}
+		case <-stream.Draining():
+			stop()
+			return
+		case <-r.Context().Done():
+			stop()
+			return
+		}
+	}
+}
+
+// drain stops new streams, notifies open ones and waits until they closed or the timeout
+// (bounded by ctx) elapsed.
+func (t *streamTracker) drain(ctx context.Context, timeout time.Duration) {
+	t.mu.Lock()
+	t.draining = true
+	open := len(t.active)
+	for s := range t.active {
+		close(s.drain)
+	}
+	t.mu.Unlock()
+	if open == 0 {
+		return
+	}
+
+	log.Printf("Draining %d streaming connections (timeout %s)", open, timeout)
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	select {
+	case <-done:
+		log.Println("All streaming connections drained")
+	case <-ctx.Done():
+		t.mu.Lock()
+		log.Printf("Drain timeout: %d streaming connections still open", len(t.active))
+		t.mu.Unlock()
+	}
+}
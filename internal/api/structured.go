@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"stream-rag-agent/internal/llm"
+	"stream-rag-agent/internal/vectordb"
+)
+
+const (
+	ModeRAG        = "rag"
+	ModeStructured = "structured"
+)
+
+var (
+	errNoStructuredData = errors.New("no structured fields have been indexed; configure structured_fields on a topic")
+	errNoElasticsearch  = errors.New("structured queries need Elasticsearch and are not available in dev mode")
+)
+
+// answerStructured answers a structured question (counts, sums, averages...) by letting the LLM
+// fill in a constrained aggregation spec, running it against the indexed message fields and
+// composing the answer from the exact results.
+func (s *APIServer) answerStructured(ctx context.Context, question string, style answerStyle) (string, *vectordb.AggregationResult, error) {
+	if s.esClient == nil {
+		return "", nil, errNoElasticsearch
+	}
+	fields, err := s.esClient.EventFields()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(fields) == 0 {
+		return "", nil, errNoStructuredData
+	}
+
+	spec, err := s.generateAggregationSpec(question, fields, style.location)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if spec.Topic != "" && !style.egress.Allows(spec.Topic) {
+		return "", nil, fmt.Errorf("topic '%s' is classified above what model '%s' may receive", spec.Topic, style.egress.Model)
+	}
+	spec.ExcludeTopics = style.egress.ExcludeTopics
+	result, err := s.esClient.Aggregate(*spec)
+	if err != nil {
+		return "", nil, err
+	}
+	style.citations = false // The answer is composed from figures, not windows
+
+	system := "You are an AI assistant answering questions about Kafka streaming data. " +
+		"Answer the user's question using ONLY the exact aggregation results below, which were computed over all matching events. " +
+		"State the figures precisely and mention the filters and time range they cover.\n" +
+		style.instructions() + "\n\n" +
+		"--- AGGREGATION RESULTS ---\n" + result.String() + "---------------------------\n"
+	answer, err := s.llmService.GenerateWithOptions(ctx, system, question, style.options())
+	if err != nil {
+		return "", result, fmt.Errorf("failed to compose answer from aggregation: %w", err)
+	}
+	return answer, result, nil
+}
+
+func (s *APIServer) generateAggregationSpec(question string, fields []vectordb.EventField, loc *time.Location) (*vectordb.AggregationSpec, error) {
+	var fieldList strings.Builder
+	for _, f := range fields {
+		fieldList.WriteString(fmt.Sprintf("  - %s (%s)\n", f.Name, f.Type))
+	}
+
+	system := "You translate questions about event data into an aggregation specification.\n" +
+		"Available fields:\n" + fieldList.String() +
+		"Allowed metrics: count, avg, sum, min, max, cardinality. Numeric metrics need a numeric field; group_by needs a keyword field.\n" +
+		fmt.Sprintf("The current time is %s. Express relative periods such as \"today\" as RFC3339 from/to timestamps.\n", time.Now().In(loc).Format(time.RFC3339)) +
+		`Respond ONLY with JSON of the form {"topic": "", "metric": "", "field": "", "group_by": "", "filters": {"<field>": "<value>"}, "from": "", "to": ""}, omitting keys that do not apply.`
+
+	raw, err := s.llmService.GenerateWithSystem(system, question)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate aggregation spec: %w", err)
+	}
+
+	// Models often emit empty strings for keys that do not apply; drop them before decoding
+	var generic map[string]interface{}
+	if err := json.Unmarshal([]byte(llm.ExtractJSONObject(raw)), &generic); err != nil {
+		return nil, fmt.Errorf("failed to parse aggregation spec %q: %w", raw, err)
+	}
+	for k, v := range generic {
+		if v == nil || v == "" {
+			delete(generic, k)
+		}
+	}
+	cleaned, _ := json.Marshal(generic)
+
+	var spec vectordb.AggregationSpec
+	if err := json.Unmarshal(cleaned, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse aggregation spec %q: %w", raw, err)
+	}
+	if err := spec.Validate(fields); err != nil {
+		return nil, fmt.Errorf("invalid aggregation spec %q: %w", raw, err)
+	}
+	log.Printf("Generated aggregation spec for question '%s': %+v", question, spec)
+	return &spec, nil
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"stream-rag-agent/internal/vectordb"
+)
+
+const defaultValidationTolerance = 0.01
+
+const (
+	ValidationConsistent  = "consistent"
+	ValidationDiscrepancy = "discrepancy"
+	ValidationSkipped     = "skipped"
+)
+
+// NumericValidation compares the figures in a generated answer with the same figure
+// recomputed from the structured fields of the underlying events.
+type NumericValidation struct {
+	Status       string                      `json:"status"`
+	AnswerValues []float64                   `json:"answer_values,omitempty"` // Numbers found in the answer
+	Computed     *vectordb.AggregationResult `json:"computed,omitempty"`
+	Message      string                      `json:"message,omitempty"`
+}
+
+var numberPattern = regexp.MustCompile(`-?\d[\d,]*(?:\.\d+)?`)
+
+// answerNumbers extracts the numbers mentioned in an answer, ignoring thousands separators and
+// citation markers.
+func answerNumbers(answer string) []float64 {
+	var values []float64
+	for _, match := range numberPattern.FindAllString(withoutCitations(answer), -1) {
+		v, err := strconv.ParseFloat(strings.ReplaceAll(match, ",", ""), 64)
+		if err == nil {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// validateNumbers recomputes the figure asked for by the question from the indexed structured
+// fields and checks that the answer mentions it. It never fails the query: problems are
+// reported as a skipped validation.
+func (s *APIServer) validateNumbers(question, answer string, style answerStyle) *NumericValidation {
+	values := answerNumbers(answer)
+	if len(values) == 0 {
+		return &NumericValidation{Status: ValidationSkipped, Message: "the answer contains no numbers"}
+	}
+	result := &NumericValidation{AnswerValues: values}
+	if s.esClient == nil {
+		result.Status = ValidationSkipped
+		result.Message = "structured fields are not indexed in dev mode"
+		return result
+	}
+
+	fields, err := s.esClient.EventFields()
+	if err != nil || len(fields) == 0 {
+		result.Status = ValidationSkipped
+		result.Message = "no structured fields are indexed to recompute the figure from"
+		return result
+	}
+	spec, err := s.generateAggregationSpec(question, fields, style.location)
+	if err != nil {
+		log.Printf("Numeric validation skipped: %v", err)
+		result.Status = ValidationSkipped
+		result.Message = "the question could not be expressed as an aggregation"
+		return result
+	}
+	// Recompute over the same topics the answer could draw on
+	spec.ExcludeTopics = style.egress.ExcludeTopics
+	computed, err := s.esClient.Aggregate(*spec)
+	if err != nil {
+		log.Printf("Numeric validation skipped: %v", err)
+		result.Status = ValidationSkipped
+		result.Message = "the aggregation failed"
+		return result
+	}
+	result.Computed = computed
+
+	tolerance := s.queryConfig.NumericValidation.Tolerance
+	if tolerance <= 0 {
+		tolerance = defaultValidationTolerance
+	}
+	for _, row := range computed.Rows {
+		for _, v := range values {
+			if withinTolerance(v, row.Value, tolerance) {
+				result.Status = ValidationConsistent
+				return result
+			}
+		}
+	}
+	result.Status = ValidationDiscrepancy
+	result.Message = "none of the numbers in the answer match the value computed from the events"
+	log.Printf("Numeric validation found a discrepancy for question '%s': answer values %v, computed %s", question, values, computed)
+	return result
+}
+
+// withinTolerance compares two values with a relative tolerance (absolute near zero).
+func withinTolerance(a, b, tolerance float64) bool {
+	diff := math.Abs(a - b)
+	scale := math.Max(math.Abs(a), math.Abs(b))
+	if scale < 1 {
+		return diff <= tolerance
+	}
+	return diff/scale <= tolerance
+}
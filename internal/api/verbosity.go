@@ -0,0 +1,109 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"stream-rag-agent/internal/governance"
+	"stream-rag-agent/internal/llm"
+	"stream-rag-agent/internal/vectordb"
+)
+
+const (
+	VerbosityBrief    = "brief"
+	VerbosityNormal   = "normal"
+	VerbosityDetailed = "detailed"
+)
+
+// verbosityInstructions are appended to the system prompt for each verbosity level.
+var verbosityInstructions = map[string]string{
+	VerbosityBrief:    "Answer in a single short sentence without explanation.",
+	VerbosityNormal:   "",
+	VerbosityDetailed: "Give a thorough answer: explain how you reached it, list the relevant figures and name the windows (by ID) you used.",
+}
+
+// defaultMaxTokens caps generation per verbosity level unless overridden in query.verbosity.max_tokens.
+var defaultMaxTokens = map[string]int{
+	VerbosityBrief:    80,
+	VerbosityNormal:   0, // Model default
+	VerbosityDetailed: 2048,
+}
+
+// answerStyle holds the per-request presentation settings of an answer.
+type answerStyle struct {
+	location  *time.Location
+	verbosity string
+	maxTokens int
+	model     string // Requested LLM model, empty uses the configured one
+	egress    governance.Decision
+	timeout   time.Duration          // Bound on generation from the query's deadline, 0 if none
+	recency   *vectordb.RecencyDecay // Applied to retrieval by scope, nil if none
+	citations bool                   // Ask for citation markers, see checkCitations
+}
+
+// answerStyle resolves the requested time zone, verbosity and model, falling back to the
+// configured defaults. The configured recency decay applies.
+func (s *APIServer) answerStyle(timeZone, verbosity, model string) (answerStyle, error) {
+	style := answerStyle{location: s.location, verbosity: s.queryConfig.Verbosity.Default, model: model, recency: s.recencyDecay(nil, 0), citations: s.queryConfig.Citations.Enabled}
+	if model != "" && !s.llmService.HasModel(model) {
+		return style, fmt.Errorf("unknown model '%s'", model)
+	}
+	if model == "" {
+		model = s.llmService.Model()
+	}
+	style.egress = s.egress.Decide(model)
+	if style.egress.Routed {
+		log.Printf("Egress policy: answering with local model '%s' instead of external model '%s'", style.egress.Model, model)
+		style.model = style.egress.Model
+	}
+	if timeZone != "" {
+		loc, err := time.LoadLocation(timeZone)
+		if err != nil {
+			return style, fmt.Errorf("unknown time zone '%s'", timeZone)
+		}
+		style.location = loc
+	}
+	if verbosity != "" {
+		style.verbosity = verbosity
+	}
+	if style.verbosity == "" {
+		style.verbosity = VerbosityNormal
+	}
+	if _, ok := verbosityInstructions[style.verbosity]; !ok {
+		return style, fmt.Errorf("verbosity must be one of %s, %s or %s", VerbosityBrief, VerbosityNormal, VerbosityDetailed)
+	}
+
+	style.maxTokens = defaultMaxTokens[style.verbosity]
+	if n, ok := s.queryConfig.Verbosity.MaxTokens[style.verbosity]; ok {
+		style.maxTokens = n
+	}
+	return style, nil
+}
+
+// instructions returns the prompt lines describing how the answer should be presented.
+func (a answerStyle) instructions() string {
+	text := timeZoneInstruction(a.location)
+	if v := verbosityInstructions[a.verbosity]; v != "" {
+		text += "\n" + v
+	}
+	if a.citations {
+		text += "\n" + citationInstruction
+	}
+	return text
+}
+
+// options returns the model options for the answer, nil when the model defaults apply.
+func (a answerStyle) options() *llm.GenerateOptions {
+	if a.maxTokens <= 0 && a.model == "" && a.timeout <= 0 {
+		return nil
+	}
+	return &llm.GenerateOptions{NumPredict: max(a.maxTokens, 0), Model: a.model, Timeout: a.timeout}
+}
+
+// limitTokens caps the generated tokens at limit (0 = no cap).
+func (a *answerStyle) limitTokens(limit int) {
+	if limit > 0 && (a.maxTokens <= 0 || a.maxTokens > limit) {
+		a.maxTokens = limit
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	// APIVersionHeader negotiates the API version: clients may send it to request a version,
+	// and every versioned response carries the version that served it.
+	APIVersionHeader = "X-API-Version"
+	// CurrentAPIVersion is the version served under /v1 and by the unversioned paths.
+	CurrentAPIVersion = "1"
+)
+
+// supportedAPIVersions lists the versions this server can serve, oldest first.
+var supportedAPIVersions = []string{CurrentAPIVersion}
+
+// versionPrefix returns the path prefix of an API version, e.g. "/v1".
+func versionPrefix(version string) string {
+	return "/v" + version
+}
+
+// handleVersioned registers an API route under its versioned path (/v1/...) and, for
+// compatibility with existing clients, under its unversioned path. Paths that already carry a
+// version, like the OpenAI-compatible /v1/chat/completions, are registered once.
+func handleVersioned(mux *http.ServeMux, path string, handler http.HandlerFunc) {
+	prefix := versionPrefix(CurrentAPIVersion)
+	if strings.HasPrefix(path, prefix+"/") {
+		mux.HandleFunc(path, negotiateVersion(CurrentAPIVersion, handler))
+		return
+	}
+	mux.HandleFunc(prefix+path, negotiateVersion(CurrentAPIVersion, handler))
+	mux.HandleFunc(path, negotiateVersion("", handler))
+}
+
+// negotiateVersion checks the X-API-Version header against the version of the path (empty
+// for unversioned paths, which serve the requested or the current version) and labels the
+// response with the version served. Unversioned responses link to their versioned successor.
+func negotiateVersion(pathVersion string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requested := strings.TrimPrefix(strings.TrimSpace(r.Header.Get(APIVersionHeader)), "v")
+		version := pathVersion
+		switch {
+		case requested == "":
+			if version == "" {
+				version = CurrentAPIVersion
+			}
+		case !slices.Contains(supportedAPIVersions, requested):
+			w.Header().Set(APIVersionHeader, CurrentAPIVersion)
+			http.Error(w, fmt.Sprintf("Unsupported API version %q; supported versions: %s", requested, strings.Join(supportedAPIVersions, ", ")), http.StatusBadRequest)
+			return
+		case version != "" && requested != version:
+			w.Header().Set(APIVersionHeader, version)
+			http.Error(w, fmt.Sprintf("%s %s does not match the version of the path (%s)", APIVersionHeader, requested, versionPrefix(version)), http.StatusBadRequest)
+			return
+		default:
+			version = requested
+		}
+
+		w.Header().Set(APIVersionHeader, version)
+		if pathVersion == "" {
+			w.Header().Add("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", versionPrefix(CurrentAPIVersion), r.URL.Path))
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"stream-rag-agent/internal/vectordb"
+	"stream-rag-agent/internal/views"
+	"stream-rag-agent/pkg/errs"
+)
+
+// viewFilter resolves a view name from a request in a workspace into a search filter; an empty
+// name means no filter.
+func (s *APIServer) viewFilter(workspace, name string) (*vectordb.SearchFilter, error) {
+	if name == "" {
+		return nil, nil
+	}
+	v, err := s.views.Get(workspace, name)
+	if err != nil {
+		return nil, err
+	}
+	return v.Filter(time.Now()), nil
+}
+
+// withTopics restricts the filter to windows of the topics, keeping it unchanged when topics
+// is empty.
+func withTopics(filter *vectordb.SearchFilter, topics []string) *vectordb.SearchFilter {
+	if len(topics) == 0 {
+		return filter
+	}
+	scoped := vectordb.SearchFilter{}
+	if filter != nil {
+		scoped = *filter
+	}
+	scoped.Topics = topics
+	return &scoped
+}
+
+// withEmbeddingModels restricts the filter to windows embedded by one of the models, keeping
+// it unchanged when models is empty.
+func withEmbeddingModels(filter *vectordb.SearchFilter, models []string) *vectordb.SearchFilter {
+	if len(models) == 0 {
+		return filter
+	}
+	scoped := vectordb.SearchFilter{}
+	if filter != nil {
+		scoped = *filter
+	}
+	scoped.EmbeddingModels = models
+	return &scoped
+}
+
+// handleViews lists saved views (GET) or creates/replaces one (POST), in the request's
+// workspace if any.
+func (s *APIServer) handleViews(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSONResponse(w, http.StatusOK, s.views.List(workspaceFrom(r.Context())))
+	case http.MethodPost:
+		var v views.View
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v.Workspace = workspaceFrom(r.Context())
+		if err := s.views.Put(&v); err != nil {
+			log.Printf("Error saving view '%s': %v", v.Name, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSONResponse(w, http.StatusOK, v)
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleView returns (GET) or deletes (DELETE) a single view.
+func (s *APIServer) handleView(w http.ResponseWriter, r *http.Request) {
+	workspace, name := workspaceFrom(r.Context()), r.PathValue("name")
+	switch r.Method {
+	case http.MethodGet:
+		v, err := s.views.Get(workspace, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSONResponse(w, http.StatusOK, v)
+	case http.MethodDelete:
+		if err := s.views.Delete(workspace, name); err != nil {
+			http.Error(w, err.Error(), errs.HTTPStatus(err, http.StatusBadRequest))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Only GET and DELETE methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,307 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/workspaces"
+	"stream-rag-agent/pkg/errs"
+)
+
+// workspaceHeader selects the workspace of a request to /query, /chat, /views and /patterns.
+const workspaceHeader = "X-Workspace"
+
+type workspaceContextKey struct{}
+
+// workspaceFrom returns the workspace of the request, empty outside of workspaces.
+func workspaceFrom(ctx context.Context) string {
+	name, _ := ctx.Value(workspaceContextKey{}).(string)
+	return name
+}
+
+// WorkspaceContents is a workspace with the names of the artifacts saved in it.
+type WorkspaceContents struct {
+	*workspaces.Workspace
+	Views    []string `json:"views"`
+	Patterns []string `json:"patterns,omitempty"` // Absent when pattern alerting is disabled
+	Queries  []string `json:"queries"`
+	Sessions int      `json:"sessions"` // Chat sessions that have not expired
+}
+
+// requireWorkspaces rejects requests to /workspaces when the server has no workspace store,
+// e.g. in the REPL.
+func (s *APIServer) requireWorkspaces(next http.HandlerFunc) http.HandlerFunc {
+	if s.workspaces != nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Workspaces are not available", http.StatusNotFound)
+	}
+}
+
+// inWorkspace runs the handler in the workspace named by the path or the X-Workspace header,
+// if any, after checking that the request's API key may use it. Requests without a workspace
+// pass through unchanged and only see shared artifacts.
+func (s *APIServer) inWorkspace(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("workspace")
+		if name == "" {
+			name = r.Header.Get(workspaceHeader)
+		}
+		if name == "" {
+			next(w, r)
+			return
+		}
+		if s.workspaces == nil {
+			http.Error(w, "Workspaces are not available", http.StatusNotFound)
+			return
+		}
+		ws, err := s.workspaces.Get(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		key, ok := s.workspaceKey(r)
+		if !ok {
+			apiKeyDeniedTotal.Inc("key", "unknown", "reason", "unauthenticated")
+			http.Error(w, "A valid API key is required", http.StatusUnauthorized)
+			return
+		}
+		if len(s.apiKeys) > 0 && !ws.Allows(key.Name) {
+			apiKeyDeniedTotal.Inc("key", key.Name, "reason", "workspace")
+			log.Printf("API key '%s' denied on %s: not a key of workspace '%s'", key.Name, r.URL.Path, ws.Name)
+			http.Error(w, fmt.Sprintf("API key '%s' may not use workspace '%s'", key.Name, ws.Name), http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), workspaceContextKey{}, ws.Name)))
+	}
+}
+
+// workspaceKey returns the API key of a request, authenticated by requireAPIKey or read from
+// its headers; ok is false if API keys are configured and the request has no valid one.
+func (s *APIServer) workspaceKey(r *http.Request) (config.APIKeyConfig, bool) {
+	if len(s.apiKeys) == 0 {
+		return config.APIKeyConfig{}, true
+	}
+	if key, ok := r.Context().Value(apiKeyContextKey{}).(config.APIKeyConfig); ok {
+		return key, true
+	}
+	key, ok := s.apiKeys[requestAPIKey(r)]
+	return key, ok
+}
+
+// handleWorkspaces lists the workspaces the request's API key may use (GET) or creates or
+// replaces one (POST). With API keys configured, a new workspace without api_keys is only
+// usable with the key that creates it.
+func (s *APIServer) handleWorkspaces(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.workspaceKey(r)
+	if !ok {
+		apiKeyDeniedTotal.Inc("key", "unknown", "reason", "unauthenticated")
+		http.Error(w, "A valid API key is required", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		list := make([]*workspaces.Workspace, 0)
+		for _, ws := range s.workspaces.List() {
+			if len(s.apiKeys) == 0 || ws.Allows(key.Name) {
+				list = append(list, ws)
+			}
+		}
+		writeJSONResponse(w, http.StatusOK, list)
+	case http.MethodPost:
+		var ws workspaces.Workspace
+		if err := json.NewDecoder(r.Body).Decode(&ws); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if len(s.apiKeys) > 0 {
+			if existing, err := s.workspaces.Get(ws.Name); err == nil && !existing.Allows(key.Name) {
+				apiKeyDeniedTotal.Inc("key", key.Name, "reason", "workspace")
+				http.Error(w, fmt.Sprintf("API key '%s' may not use workspace '%s'", key.Name, ws.Name), http.StatusForbidden)
+				return
+			}
+			if len(ws.APIKeys) == 0 {
+				ws.APIKeys = []string{key.Name}
+			}
+			for _, name := range ws.APIKeys {
+				if !s.hasAPIKeyNamed(name) {
+					http.Error(w, fmt.Sprintf("Unknown API key '%s' in api_keys", name), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		if err := s.workspaces.Put(&ws); err != nil {
+			log.Printf("Error saving workspace '%s': %v", ws.Name, err)
+			http.Error(w, err.Error(), errs.HTTPStatus(err, http.StatusInternalServerError))
+			return
+		}
+		writeJSONResponse(w, http.StatusOK, ws)
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// hasAPIKeyNamed reports whether api.keys has a key with the given name.
+func (s *APIServer) hasAPIKeyNamed(name string) bool {
+	for _, k := range s.apiKeys {
+		if k.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// handleWorkspace returns a workspace with its contents (GET) or deletes it (DELETE) with its
+// views, patterns, saved queries and chat sessions.
+func (s *APIServer) handleWorkspace(w http.ResponseWriter, r *http.Request) {
+	name := workspaceFrom(r.Context())
+	ws, err := s.workspaces.Get(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		contents := WorkspaceContents{Workspace: ws, Views: []string{}, Queries: []string{}, Sessions: s.sessions.count(name)}
+		for _, v := range s.views.List(name) {
+			if v.Workspace == name {
+				contents.Views = append(contents.Views, v.Name)
+			}
+		}
+		if s.patterns != nil {
+			contents.Patterns = []string{}
+			for _, p := range s.patterns.List(name) {
+				if p.Workspace == name {
+					contents.Patterns = append(contents.Patterns, p.Name)
+				}
+			}
+		}
+		queries, err := s.workspaces.Queries(name)
+		if err != nil {
+			http.Error(w, err.Error(), errs.HTTPStatus(err, http.StatusInternalServerError))
+			return
+		}
+		for _, q := range queries {
+			contents.Queries = append(contents.Queries, q.Name)
+		}
+		writeJSONResponse(w, http.StatusOK, contents)
+	case http.MethodDelete:
+		views, err := s.views.DeleteWorkspace(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var patterns int
+		if s.patterns != nil {
+			if patterns, err = s.patterns.DeleteWorkspace(name); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		sessions := s.sessions.dropWorkspace(name)
+		if err := s.workspaces.Delete(name); err != nil {
+			http.Error(w, err.Error(), errs.HTTPStatus(err, http.StatusInternalServerError))
+			return
+		}
+		log.Printf("Deleted workspace '%s' with %d views, %d patterns and %d chat sessions", name, views, patterns, sessions)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Only GET and DELETE methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleWorkspaceQueries lists the saved queries of a workspace (GET) or saves one (POST).
+func (s *APIServer) handleWorkspaceQueries(w http.ResponseWriter, r *http.Request) {
+	name := workspaceFrom(r.Context())
+	switch r.Method {
+	case http.MethodGet:
+		queries, err := s.workspaces.Queries(name)
+		if err != nil {
+			http.Error(w, err.Error(), errs.HTTPStatus(err, http.StatusInternalServerError))
+			return
+		}
+		writeJSONResponse(w, http.StatusOK, queries)
+	case http.MethodPost:
+		var q workspaces.SavedQuery
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		var req QueryRequest
+		if len(q.Request) > 0 {
+			if err := json.Unmarshal(q.Request, &req); err != nil {
+				http.Error(w, "Invalid saved query request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if req.Prompt == "" {
+				http.Error(w, "Saved query request needs a prompt", http.StatusBadRequest)
+				return
+			}
+		}
+		if err := s.workspaces.PutQuery(name, &q); err != nil {
+			log.Printf("Error saving query '%s' of workspace '%s': %v", q.Name, name, err)
+			http.Error(w, err.Error(), errs.HTTPStatus(err, http.StatusInternalServerError))
+			return
+		}
+		writeJSONResponse(w, http.StatusOK, q)
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleWorkspaceQuery returns (GET) or deletes (DELETE) a saved query of a workspace.
+func (s *APIServer) handleWorkspaceQuery(w http.ResponseWriter, r *http.Request) {
+	name, query := workspaceFrom(r.Context()), r.PathValue("query")
+	switch r.Method {
+	case http.MethodGet:
+		q, err := s.workspaces.Query(name, query)
+		if err != nil {
+			http.Error(w, err.Error(), errs.HTTPStatus(err, http.StatusInternalServerError))
+			return
+		}
+		writeJSONResponse(w, http.StatusOK, q)
+	case http.MethodDelete:
+		if err := s.workspaces.DeleteQuery(name, query); err != nil {
+			http.Error(w, err.Error(), errs.HTTPStatus(err, http.StatusInternalServerError))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Only GET and DELETE methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRunWorkspaceQuery answers a saved query of a workspace the way POST /query answers
+// its request, within the workspace. The API key must be allowed the saved model parameters.
+func (s *APIServer) handleRunWorkspaceQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := s.workspaces.Query(workspaceFrom(r.Context()), r.PathValue("query"))
+	if err != nil {
+		http.Error(w, err.Error(), errs.HTTPStatus(err, http.StatusInternalServerError))
+		return
+	}
+	var req QueryRequest
+	if err := json.Unmarshal(q.Request, &req); err != nil {
+		http.Error(w, "Invalid saved query request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(s.apiKeys) > 0 {
+		key := apiKeyFrom(r.Context())
+		if reason, err := s.checkAPIKey(key, modelParams{Model: req.Model, Verbosity: req.Verbosity}); err != nil {
+			apiKeyDeniedTotal.Inc("key", key.Name, "reason", reason)
+			log.Printf("API key '%s' denied on %s: %v", key.Name, r.URL.Path, err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	s.respondQuery(w, r, req)
+}
@@ -0,0 +1,223 @@
+// Package atrest encrypts the files the agent persists locally (outbox, dev-mode journal,
+// dead letters, saved views and patterns, checkpoints) with AES-256-GCM when
+// encryption.enabled is set. Files written before encryption was enabled are still read, and
+// are encrypted the next time they are written; encrypted files cannot be read without the key.
+package atrest
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"stream-rag-agent/internal/config"
+)
+
+const (
+	keySize           = 32 // AES-256
+	keyCommandTimeout = 30 * time.Second
+)
+
+var (
+	// magic starts encrypted files, followed by the nonce and the sealed data.
+	magic = []byte("SRAGENC1")
+	// linePrefix starts encrypted lines of JSON lines files, followed by the base64 of an
+	// encrypted file's content.
+	linePrefix = []byte("enc1:")
+)
+
+// ErrNoKey is returned when reading encrypted data while encryption is not configured.
+var ErrNoKey = errors.New("data is encrypted but encryption is not enabled")
+
+var state = struct {
+	mu   sync.RWMutex
+	aead cipher.AEAD // nil while encryption is disabled
+}{}
+
+// Configure enables encryption with the key of the config: encryption.key, which is best set
+// through STREAM_RAG_ENCRYPTION__KEY, or the output of encryption.key_command, e.g. a KMS call
+// that decrypts a wrapped data key. Either holds 32 bytes, base64 encoded. It does nothing when
+// encryption is disabled.
+func Configure(cfg config.EncryptionConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	encoded := strings.TrimSpace(cfg.Key)
+	if encoded == "" && len(cfg.KeyCommand) > 0 {
+		out, err := runKeyCommand(cfg.KeyCommand)
+		if err != nil {
+			return err
+		}
+		encoded = strings.TrimSpace(string(out))
+	}
+	if encoded == "" {
+		return fmt.Errorf("encryption is enabled but neither encryption.key nor encryption.key_command is set")
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("invalid encryption key: expected base64: %w", err)
+	}
+	if len(key) != keySize {
+		return fmt.Errorf("invalid encryption key: expected %d bytes, got %d", keySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("invalid encryption key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("failed to set up AES-GCM: %w", err)
+	}
+
+	state.mu.Lock()
+	state.aead = aead
+	state.mu.Unlock()
+	return nil
+}
+
+// runKeyCommand runs the command and returns its standard output.
+func runKeyCommand(command []string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), keyCommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("encryption.key_command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// Enabled reports whether files are encrypted.
+func Enabled() bool {
+	return current() != nil
+}
+
+func current() cipher.AEAD {
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+	return state.aead
+}
+
+// Seal encrypts data, or returns it unchanged while encryption is disabled.
+func Seal(data []byte) ([]byte, error) {
+	aead := current()
+	if aead == nil {
+		return data, nil
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := make([]byte, 0, len(magic)+len(nonce)+len(data)+aead.Overhead())
+	sealed = append(sealed, magic...)
+	sealed = append(sealed, nonce...)
+	return aead.Seal(sealed, nonce, data, magic), nil
+}
+
+// Open decrypts data written by Seal. Data that is not encrypted is returned unchanged.
+func Open(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, magic) {
+		return data, nil
+	}
+	aead := current()
+	if aead == nil {
+		return nil, ErrNoKey
+	}
+	data = data[len(magic):]
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted data is truncated")
+	}
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, magic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data (wrong key or corrupt data): %w", err)
+	}
+	return plain, nil
+}
+
+// SealLine encrypts a line of a JSON lines file into a line of text, or returns it unchanged
+// while encryption is disabled. The line must not end with a newline.
+func SealLine(line []byte) ([]byte, error) {
+	if current() == nil {
+		return line, nil
+	}
+	sealed, err := Seal(line)
+	if err != nil {
+		return nil, err
+	}
+	encoded := make([]byte, len(linePrefix)+base64.StdEncoding.EncodedLen(len(sealed)))
+	copy(encoded, linePrefix)
+	base64.StdEncoding.Encode(encoded[len(linePrefix):], sealed)
+	return encoded, nil
+}
+
+// OpenLine decrypts a line written by SealLine. Lines that are not encrypted are returned
+// unchanged.
+func OpenLine(line []byte) ([]byte, error) {
+	if !bytes.HasPrefix(line, linePrefix) {
+		return line, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(line[len(linePrefix):]))
+	if err != nil {
+		return nil, fmt.Errorf("encrypted line is corrupt: %w", err)
+	}
+	return Open(sealed)
+}
+
+// WriteFile writes data to the file like os.WriteFile, encrypted when encryption is enabled.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	sealed, err := Seal(data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, sealed, perm)
+}
+
+// ReadFile reads the file like os.ReadFile, decrypting it if it is encrypted.
+func ReadFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := Open(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return plain, nil
+}
+
+// Decrypt returns the plaintext of a file written by the agent, either encrypted as a whole
+// or line by line, e.g. for inspecting dead letters.
+func Decrypt(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, magic) {
+		return Open(data)
+	}
+	var out bytes.Buffer
+	for i, line := range bytes.SplitAfter(data, []byte("\n")) {
+		trimmed := bytes.TrimSuffix(line, []byte("\n"))
+		plain, err := OpenLine(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		out.Write(plain)
+		if len(trimmed) < len(line) {
+			out.WriteByte('\n')
+		}
+	}
+	return out.Bytes(), nil
+}
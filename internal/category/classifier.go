@@ -0,0 +1,155 @@
+package category
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/llm"
+	"stream-rag-agent/internal/metrics"
+	"stream-rag-agent/internal/window"
+)
+
+const (
+	// None is the category of windows no rule or LLM label applies to; they are stored without one.
+	None = "none"
+
+	maxClassifyChars = 4000 // Window text shown to the LLM
+	classifyPrompt   = `You label windows of real-time Kafka data with a content category.
+Answer with exactly one of the following labels and nothing else: %s.
+Answer "none" if no label fits.`
+)
+
+var (
+	validLabel = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+	classifiedTotal = metrics.NewCounter("window_categories_total", "Windows labeled by the category classifier, by category and method.")
+)
+
+type rule struct {
+	category string
+	topics   map[string]bool
+	keywords []string // Lowercased
+}
+
+// Classifier assigns a content category (fraud, ops_error, ...) to windows before they are
+// indexed, so retrieval can be filtered by category and windows can be routed to one index
+// per category.
+type Classifier struct {
+	labels  []string
+	choices []string // labels and None, offered to the LLM
+	rules   []rule
+	llm     *llm.Service // nil unless use_llm is set
+}
+
+// New builds the classifier, nil when categories are disabled.
+func New(cfg config.CategoriesConfig, llmSvc *llm.Service) (*Classifier, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	c := &Classifier{labels: cfg.Labels, choices: append(append([]string(nil), cfg.Labels...), None)}
+	for _, label := range cfg.Labels {
+		if !validLabel.MatchString(label) || label == None {
+			return nil, fmt.Errorf("invalid category label '%s': use lowercase letters, digits and underscores", label)
+		}
+	}
+	for _, r := range cfg.Rules {
+		if !validLabel.MatchString(r.Category) || r.Category == None {
+			return nil, fmt.Errorf("invalid category '%s' in rule: use lowercase letters, digits and underscores", r.Category)
+		}
+		compiled := rule{category: r.Category, topics: make(map[string]bool, len(r.Topics))}
+		for _, t := range r.Topics {
+			compiled.topics[t] = true
+		}
+		for _, k := range r.Keywords {
+			compiled.keywords = append(compiled.keywords, strings.ToLower(k))
+		}
+		c.rules = append(c.rules, compiled)
+	}
+	if cfg.UseLLM {
+		if len(cfg.Labels) == 0 {
+			return nil, fmt.Errorf("categories.use_llm requires categories.labels")
+		}
+		c.llm = llmSvc
+	}
+	return c, nil
+}
+
+// Classify returns the category of a window from its rendered text, empty if none applies.
+// LLM failures are logged and leave the window uncategorized.
+func (c *Classifier) Classify(w *window.Window, contextText string) string {
+	if c == nil {
+		return ""
+	}
+	lower := strings.ToLower(contextText)
+	for _, r := range c.rules {
+		if r.matches(w.Topic, lower) {
+			classifiedTotal.Inc("category", r.category, "method", "rule")
+			return r.category
+		}
+	}
+	if c.llm == nil {
+		return ""
+	}
+
+	category, err := c.classifyWithLLM(w, contextText)
+	if err != nil {
+		log.Printf("Failed to classify window %s: %v", w.ID, err)
+		return ""
+	}
+	classifiedTotal.Inc("category", category, "method", "llm")
+	if category == None {
+		return ""
+	}
+	return category
+}
+
+func (r rule) matches(topic, lowerText string) bool {
+	if len(r.topics) > 0 && !r.topics[topic] {
+		return false
+	}
+	if len(r.keywords) == 0 {
+		return true
+	}
+	for _, k := range r.keywords {
+		if strings.Contains(lowerText, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Classifier) classifyWithLLM(w *window.Window, contextText string) (string, error) {
+	if len(contextText) > maxClassifyChars {
+		contextText = contextText[:maxClassifyChars]
+	}
+	system := fmt.Sprintf(classifyPrompt, strings.Join(c.choices, ", "))
+	prompt := fmt.Sprintf("Topic: %s\nTopic description: %s\n\n%s", w.Topic, w.Context, contextText)
+	answer, err := c.llm.GenerateWithOptions(context.Background(), system, prompt, &llm.GenerateOptions{NumPredict: 10})
+	if err != nil {
+		return "", err
+	}
+	return c.parseLabel(answer)
+}
+
+// parseLabel finds the label in the LLM's answer, tolerating quotes, punctuation and casing.
+func (c *Classifier) parseLabel(answer string) (string, error) {
+	answer = strings.Trim(strings.ToLower(strings.TrimSpace(answer)), "\"'`.")
+	for _, label := range c.choices {
+		if answer == label {
+			return label, nil
+		}
+	}
+	for _, label := range c.labels {
+		if strings.Contains(answer, label) {
+			return label, nil
+		}
+	}
+	if strings.Contains(answer, None) {
+		return None, nil
+	}
+	return "", fmt.Errorf("answer '%s' is not one of the labels", answer)
+}
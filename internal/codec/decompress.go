@@ -0,0 +1,197 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionNone   = "none"
+	CompressionAuto   = "auto"
+	CompressionGzip   = "gzip"
+	CompressionZstd   = "zstd"
+	CompressionSnappy = "snappy"
+)
+
+var (
+	gzipMagic         = []byte{0x1f, 0x8b}
+	zstdMagic         = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	snappyFramedMagic = []byte("\xff\x06\x00\x00sNaPpY")
+)
+
+const (
+	// maxPooledBufferSize keeps unusually large payloads from pinning memory in the buffer pool.
+	maxPooledBufferSize = 4 << 20
+
+	// DefaultMaxDecompressedBytes bounds a decompressed payload unless max_decompressed_bytes
+	// is set, so a single compression bomb cannot exhaust memory.
+	DefaultMaxDecompressedBytes = 16 << 20
+
+	// zstdMaxWindow bounds the history a zstd decoder allocates for a frame, which it does
+	// before decoding any data. Encoders use at most 8 MiB below their --long modes.
+	zstdMaxWindow = 32 << 20
+)
+
+// ErrTooLarge is returned for payloads that decompress to more than the limit.
+var ErrTooLarge = errors.New("decompressed payload exceeds the size limit")
+
+var (
+	bufferPool   = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+	gzipReaders  sync.Pool // *gzip.Reader, reset for each payload
+	zstdDecoders sync.Pool // *zstd.Decoder, reset for each payload
+)
+
+// readAll reads r through a pooled buffer and returns an exactly sized copy, so a payload
+// costs one allocation instead of io.ReadAll's repeated growth. Reading stops with ErrTooLarge
+// past limit bytes.
+func readAll(r io.Reader, limit int64) ([]byte, error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBufferSize {
+			bufferPool.Put(buf)
+		}
+	}()
+	if _, err := buf.ReadFrom(io.LimitReader(r, limit+1)); err != nil {
+		return nil, err
+	}
+	if int64(buf.Len()) > limit {
+		return nil, fmt.Errorf("%w of %d bytes", ErrTooLarge, limit)
+	}
+	// Never nil: an empty payload must not be mistaken for a tombstone
+	return append(make([]byte, 0, buf.Len()), buf.Bytes()...), nil
+}
+
+func gunzip(data []byte, limit int64) ([]byte, error) {
+	r, ok := gzipReaders.Get().(*gzip.Reader)
+	if ok {
+		if err := r.Reset(bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("failed to open gzip payload: %w", err)
+		}
+	} else {
+		var err error
+		if r, err = gzip.NewReader(bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("failed to open gzip payload: %w", err)
+		}
+	}
+	defer gzipReaders.Put(r)
+
+	out, err := readAll(r, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress gzip payload: %w", err)
+	}
+	return out, nil
+}
+
+// unzstd decodes a zstd payload as a stream, so the limit applies before the output is
+// allocated; DecodeAll would size its output from the frame header or grow it unbounded.
+func unzstd(data []byte, limit int64) ([]byte, error) {
+	var h zstd.Header
+	if err := h.Decode(data); err == nil && h.HasFCS && h.FrameContentSize > uint64(limit) {
+		return nil, fmt.Errorf("failed to decompress zstd payload: %w of %d bytes", ErrTooLarge, limit)
+	}
+
+	d, ok := zstdDecoders.Get().(*zstd.Decoder)
+	if ok {
+		if err := d.Reset(bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("failed to open zstd payload: %w", err)
+		}
+	} else {
+		var err error
+		// Without concurrency the decoder decodes synchronously, with no goroutines to leak
+		d, err = zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(zstdMaxWindow))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open zstd payload: %w", err)
+		}
+	}
+	defer zstdDecoders.Put(d)
+
+	out, err := readAll(d, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress zstd payload: %w", err)
+	}
+	return out, nil
+}
+
+// Detect guesses the compression of a payload from its magic bytes. Raw (unframed) snappy
+// has no magic bytes and is never detected.
+func Detect(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		return CompressionGzip
+	case bytes.HasPrefix(data, zstdMagic):
+		return CompressionZstd
+	case bytes.HasPrefix(data, snappyFramedMagic):
+		return CompressionSnappy
+	default:
+		return CompressionNone
+	}
+}
+
+// Decompress decodes an application-level compressed payload. With "auto" the algorithm is
+// detected from the payload; if the configured algorithm fails, detection is used as a
+// fallback, so uncompressed payloads on a compressed topic pass through unchanged. Payloads
+// that decompress to more than maxBytes (DefaultMaxDecompressedBytes if 0) fail with
+// ErrTooLarge.
+func Decompress(data []byte, algorithm string, maxBytes int64) ([]byte, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxDecompressedBytes
+	}
+	switch algorithm {
+	case "", CompressionNone:
+		return data, nil
+	case CompressionAuto:
+		return decompressWith(data, Detect(data), maxBytes)
+	}
+
+	out, err := decompressWith(data, algorithm, maxBytes)
+	if err == nil {
+		return out, nil
+	}
+	detected := Detect(data)
+	if detected == algorithm || errors.Is(err, ErrTooLarge) {
+		return nil, err
+	}
+	fallback, fallbackErr := decompressWith(data, detected, maxBytes)
+	if fallbackErr != nil {
+		return nil, err
+	}
+	return fallback, nil
+}
+
+func decompressWith(data []byte, algorithm string, limit int64) ([]byte, error) {
+	switch algorithm {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		return gunzip(data, limit)
+	case CompressionZstd:
+		return unzstd(data, limit)
+	case CompressionSnappy:
+		if bytes.HasPrefix(data, snappyFramedMagic) {
+			out, err := readAll(snappy.NewReader(bytes.NewReader(data)), limit)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decompress framed snappy payload: %w", err)
+			}
+			return out, nil
+		}
+		// Raw snappy states its decoded length up front
+		if n, err := snappy.DecodedLen(data); err == nil && int64(n) > limit {
+			return nil, fmt.Errorf("failed to decompress snappy payload: %w of %d bytes", ErrTooLarge, limit)
+		}
+		out, err := snappy.Decode(nil, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress snappy payload: %w", err)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unsupported payload compression: %s", algorithm)
+	}
+}
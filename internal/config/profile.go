@@ -0,0 +1,175 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix starts the names of environment variables that override config values. The rest
+// of the name is the option's path with "__" between levels, e.g. STREAM_RAG_KAFKA__BROKERS
+// for kafka.brokers. Values are parsed as YAML, so "[a:9092, b:9092]" sets a list.
+const EnvPrefix = "STREAM_RAG_"
+
+// ProfileEnv selects the config profile when no profile is passed to Load.
+const ProfileEnv = "STREAM_RAG_PROFILE"
+
+// Load reads the config file at path, merges the overlay of the profile (and of
+// STREAM_RAG_PROFILE when profile is empty) over it, then applies overrides from the
+// environment. The overlay of profile "staging" for configs/configs.yml is
+// configs/configs.staging.yml. Overlays only hold the options that differ: maps are merged
+// key by key, lists of named entries (topics, views, clusters) entry by entry matched on
+// name, and other values replace the base value.
+func Load(path, profile string) (*AppConfig, error) {
+	merged, err := readYAML(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if profile == "" {
+		profile = os.Getenv(ProfileEnv)
+	}
+	if profile != "" {
+		overlayPath := OverlayPath(path, profile)
+		overlay, err := readYAML(overlayPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load overlay of profile '%s': %w", profile, err)
+		}
+		merged = mergeYAML(merged, overlay).(map[interface{}]interface{})
+		log.Printf("Applied config profile '%s' from %s", profile, overlayPath)
+	}
+
+	overridden, err := applyEnvOverrides(merged, os.Environ())
+	if err != nil {
+		return nil, err
+	}
+	if len(overridden) > 0 {
+		// Values are not logged; they are often credentials
+		log.Printf("Config options overridden from the environment: %s", strings.Join(overridden, ", "))
+	}
+
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal merged config: %w", err)
+	}
+	var cfg AppConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+	return &cfg, nil
+}
+
+// OverlayPath returns the overlay file of a profile: the config file's name with the profile
+// inserted before the extension.
+func OverlayPath(path, profile string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + profile + ext
+}
+
+func readYAML(path string) (map[interface{}]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	doc := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config file %s: %w", path, err)
+	}
+	return doc, nil
+}
+
+// mergeYAML merges overlay into base and returns the result.
+func mergeYAML(base, overlay interface{}) interface{} {
+	switch o := overlay.(type) {
+	case map[interface{}]interface{}:
+		b, ok := base.(map[interface{}]interface{})
+		if !ok {
+			return o
+		}
+		for k, v := range o {
+			b[k] = mergeYAML(b[k], v)
+		}
+		return b
+	case []interface{}:
+		b, ok := base.([]interface{})
+		if !ok || !namedEntries(b) || !namedEntries(o) {
+			return o
+		}
+		for _, entry := range o {
+			name := entry.(map[interface{}]interface{})["name"]
+			i := indexOfName(b, name)
+			if i < 0 {
+				b = append(b, entry)
+				continue
+			}
+			b[i] = mergeYAML(b[i], entry)
+		}
+		return b
+	default:
+		return overlay
+	}
+}
+
+// namedEntries reports whether every element of the list is a map with a name.
+func namedEntries(list []interface{}) bool {
+	for _, entry := range list {
+		m, ok := entry.(map[interface{}]interface{})
+		if !ok || m["name"] == nil {
+			return false
+		}
+	}
+	return len(list) > 0
+}
+
+func indexOfName(list []interface{}, name interface{}) int {
+	for i, entry := range list {
+		if entry.(map[interface{}]interface{})["name"] == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// applyEnvOverrides sets the options named by EnvPrefix variables in environ ("NAME=value"
+// pairs) and returns the overridden option paths, sorted.
+func applyEnvOverrides(doc interface{}, environ []string) ([]string, error) {
+	var overridden []string
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, EnvPrefix) || name == ProfileEnv {
+			continue
+		}
+		path := strings.Split(strings.ToLower(strings.TrimPrefix(name, EnvPrefix)), "__")
+		var parsed interface{}
+		if err := yaml.Unmarshal([]byte(value), &parsed); err != nil {
+			return nil, fmt.Errorf("invalid value of %s: %w", name, err)
+		}
+		node, ok := doc.(map[interface{}]interface{})
+		for _, key := range path[:len(path)-1] {
+			if !ok {
+				break
+			}
+			child, exists := node[key].(map[interface{}]interface{})
+			if !exists {
+				if node[key] != nil {
+					return nil, fmt.Errorf("%s overrides %s, which is not a map", name, strings.Join(path, "."))
+				}
+				child = map[interface{}]interface{}{}
+				node[key] = child
+			}
+			node = child
+		}
+		if !ok || path[len(path)-1] == "" {
+			return nil, fmt.Errorf("invalid config override %s", name)
+		}
+		node[path[len(path)-1]] = parsed
+		overridden = append(overridden, strings.Join(path, "."))
+	}
+	sort.Strings(overridden)
+	return overridden, nil
+}
@@ -0,0 +1,142 @@
+package demo
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Stages of an order chain; each is produced to its own topic.
+const (
+	StageOrder    = "order"
+	StagePayment  = "payment"
+	StageShipment = "shipment"
+)
+
+// Delays between the events of an order chain before scaling: payments follow their order
+// within seconds, shipments follow authorized payments within a minute or so.
+const (
+	minPaymentDelay  = 2 * time.Second
+	maxPaymentDelay  = 20 * time.Second
+	minShipmentDelay = 10 * time.Second
+	maxShipmentDelay = 60 * time.Second
+	declineRate      = 0.1
+)
+
+type OrderItem struct {
+	SKU      string  `json:"sku"`
+	Quantity int     `json:"quantity"`
+	Price    float64 `json:"price"`
+}
+
+type Order struct {
+	OrderID    string      `json:"order_id"`
+	CustomerID string      `json:"customer_id"`
+	Status     string      `json:"status"` // created, or cancelled once its payment was declined
+	Items      []OrderItem `json:"items"`
+	Total      float64     `json:"total"`
+	Currency   string      `json:"currency"`
+	Timestamp  time.Time   `json:"timestamp"`
+}
+
+type Payment struct {
+	PaymentID     string    `json:"payment_id"`
+	OrderID       string    `json:"order_id"`
+	CustomerID    string    `json:"customer_id"`
+	AccountID     string    `json:"account_id"`
+	Amount        float64   `json:"amount"`
+	Currency      string    `json:"currency"`
+	Method        string    `json:"method"`
+	Status        string    `json:"status"` // authorized or declined
+	DeclineReason string    `json:"decline_reason,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+type Shipment struct {
+	ShipmentID     string    `json:"shipment_id"`
+	OrderID        string    `json:"order_id"`
+	CustomerID     string    `json:"customer_id"`
+	Carrier        string    `json:"carrier"`
+	TrackingNumber string    `json:"tracking_number"`
+	City           string    `json:"city"`
+	Status         string    `json:"status"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// ChainEvent is one event of an order chain, due at Timestamp. Key is the chain's order ID,
+// so all events of an order land in the same partition of their topics.
+type ChainEvent struct {
+	Stage     string
+	Key       string
+	Value     interface{} // Order, Payment or Shipment
+	Timestamp time.Time
+}
+
+// GenerateOrderChain returns the correlated events of one order placed at start: the order,
+// its payment and, if the payment is authorized, its shipment, or else the order again as
+// cancelled. The events share the order and customer IDs, and the delays between them are
+// multiplied by delayScale (0 makes them all due at start).
+func GenerateOrderChain(index int, start time.Time, delayScale float64) []ChainEvent {
+	currencies := []string{"USD", "EUR", "GBP", "TRY"}
+	skus := []string{"SKU-LAPTOP", "SKU-PHONE", "SKU-HEADSET", "SKU-MONITOR", "SKU-KEYBOARD", "SKU-CHARGER"}
+	methods := []string{"card", "bank_transfer", "wallet"}
+	declineReasons := []string{"insufficient_funds", "card_expired", "fraud_suspected"}
+	carriers := []string{"DHL", "UPS", "FedEx"}
+	cities := []string{"Istanbul", "Berlin", "London", "New York", "Amsterdam"}
+
+	orderID := fmt.Sprintf("ORD-%d-%s", index, randSeq(6))
+	customerID := fmt.Sprintf("CUST-%04d", rand.Intn(500)+1)
+	currency := currencies[rand.Intn(len(currencies))]
+
+	order := Order{OrderID: orderID, CustomerID: customerID, Status: "created", Currency: currency, Timestamp: start}
+	for i := rand.Intn(3) + 1; i > 0; i-- {
+		item := OrderItem{SKU: skus[rand.Intn(len(skus))], Quantity: rand.Intn(3) + 1, Price: float64(rand.Intn(50000)+100) / 100}
+		order.Items = append(order.Items, item)
+		order.Total += item.Price * float64(item.Quantity)
+	}
+	order.Total = float64(int(order.Total*100+0.5)) / 100
+	events := []ChainEvent{{Stage: StageOrder, Key: orderID, Value: order, Timestamp: start}}
+
+	paidAt := start.Add(randDelay(minPaymentDelay, maxPaymentDelay, delayScale))
+	payment := Payment{
+		PaymentID:  fmt.Sprintf("PAY-%d-%s", index, randSeq(6)),
+		OrderID:    orderID,
+		CustomerID: customerID,
+		AccountID:  fmt.Sprintf("ACC-%04d", rand.Intn(1000)+1),
+		Amount:     order.Total,
+		Currency:   currency,
+		Method:     methods[rand.Intn(len(methods))],
+		Status:     "authorized",
+		Timestamp:  paidAt,
+	}
+	if rand.Float64() < declineRate {
+		payment.Status = "declined"
+		payment.DeclineReason = declineReasons[rand.Intn(len(declineReasons))]
+	}
+	events = append(events, ChainEvent{Stage: StagePayment, Key: orderID, Value: payment, Timestamp: paidAt})
+
+	if payment.Status == "declined" {
+		cancelled := order
+		cancelled.Status = "cancelled"
+		cancelled.Timestamp = paidAt.Add(randDelay(time.Second, 5*time.Second, delayScale))
+		return append(events, ChainEvent{Stage: StageOrder, Key: orderID, Value: cancelled, Timestamp: cancelled.Timestamp})
+	}
+	shippedAt := paidAt.Add(randDelay(minShipmentDelay, maxShipmentDelay, delayScale))
+	shipment := Shipment{
+		ShipmentID:     fmt.Sprintf("SHP-%d-%s", index, randSeq(6)),
+		OrderID:        orderID,
+		CustomerID:     customerID,
+		Carrier:        carriers[rand.Intn(len(carriers))],
+		TrackingNumber: randSeq(12),
+		City:           cities[rand.Intn(len(cities))],
+		Status:         "shipped",
+		Timestamp:      shippedAt,
+	}
+	return append(events, ChainEvent{Stage: StageShipment, Key: orderID, Value: shipment, Timestamp: shippedAt})
+}
+
+// randDelay returns a random delay between lo and hi, multiplied by scale.
+func randDelay(lo, hi time.Duration, scale float64) time.Duration {
+	d := lo + time.Duration(rand.Int63n(int64(hi-lo)))
+	return time.Duration(float64(d) * scale)
+}
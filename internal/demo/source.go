@@ -0,0 +1,67 @@
+package demo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"stream-rag-agent/pkg/windowing"
+)
+
+const defaultInterval = 100 * time.Millisecond
+
+// Source generates synthetic financial transactions for a topic, standing in for a Kafka
+// consumer so the agent can be demoed with only Ollama and Elasticsearch running. It is a
+// source.Source.
+type Source struct {
+	topic     string
+	partition int32
+	interval  time.Duration
+	ticker    *time.Ticker
+	offset    int64
+}
+
+func NewSource(topic string, partition int32, interval time.Duration) *Source {
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	log.Printf("Starting demo message generator for topic: %s, partition: %d (every %s)", topic, partition, interval)
+	return &Source{topic: topic, partition: partition, interval: interval, ticker: time.NewTicker(interval)}
+}
+
+// Fetch generates the next transaction once the interval has passed.
+func (s *Source) Fetch(ctx context.Context) (windowing.Message, error) {
+	select {
+	case <-ctx.Done():
+		return windowing.Message{}, ctx.Err()
+	case <-s.ticker.C:
+	}
+	transaction := GenerateDummyTransaction(int(s.offset))
+	value, err := json.Marshal(transaction)
+	if err != nil {
+		return windowing.Message{}, fmt.Errorf("failed to marshal demo transaction: %w", err)
+	}
+	msg := windowing.Message{
+		Topic:     s.topic,
+		Partition: s.partition,
+		Offset:    s.offset,
+		Key:       []byte(transaction.TransactionID),
+		Value:     value,
+		Timestamp: transaction.Timestamp,
+	}
+	s.offset++
+	return msg, nil
+}
+
+// Commit does nothing: generated transactions are not replayed.
+func (s *Source) Commit(ctx context.Context, msg windowing.Message) error {
+	return nil
+}
+
+// Close stops the generator.
+func (s *Source) Close() error {
+	s.ticker.Stop()
+	return nil
+}
@@ -0,0 +1,46 @@
+package demo
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+type FinancialTransaction struct {
+	TransactionID string    `json:"transaction_id"`
+	Amount        float64   `json:"amount"`
+	Currency      string    `json:"currency"`
+	Type          string    `json:"type"`
+	Timestamp     time.Time `json:"timestamp"`
+	Description   string    `json:"description,omitempty"`
+	AccountID     string    `json:"account_id"`
+}
+
+func GenerateDummyTransaction(index int) FinancialTransaction {
+	transactionTypes := []string{"purchase", "refund", "transfer", "withdrawal", "deposit"}
+	currencies := []string{"USD", "EUR", "GBP", "TRY"}
+	descriptions := []string{
+		"Online shopping", "Utility bill payment", "Salary deposit",
+		"ATM withdrawal", "Restaurant bill", "Subscription renewal",
+		"Friend payment", "Loan repayment", "Investment", "Travel expense",
+	}
+
+	return FinancialTransaction{
+		TransactionID: fmt.Sprintf("TXN-%d-%s", index, randSeq(8)),
+		Amount:        float64(rand.Intn(100000)+1) / 100, // 0.01 to 1000.00
+		Currency:      currencies[rand.Intn(len(currencies))],
+		Type:          transactionTypes[rand.Intn(len(transactionTypes))],
+		Timestamp:     time.Now().Add(-time.Duration(rand.Intn(3600)) * time.Second), // Last hour
+		Description:   descriptions[rand.Intn(len(descriptions))],
+		AccountID:     fmt.Sprintf("ACC-%04d", rand.Intn(1000)+1), // 1 to 1000 accounts
+	}
+}
+
+func randSeq(n int) string {
+	var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
+	b := make([]rune, n)
+	for i := range b {
+		b[i] = letters[rand.Intn(len(letters))]
+	}
+	return string(b)
+}
@@ -2,15 +2,42 @@ package embedding
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/faults"
+	"stream-rag-agent/internal/metrics"
+	"stream-rag-agent/internal/window"
+	"stream-rag-agent/pkg/errs"
+	"stream-rag-agent/pkg/hooks"
 )
 
+// defaultMaxChars keeps texts well within the context of common embedding models
+// (nomic-embed-text: 2048 tokens at Ollama's default num_ctx).
+const defaultMaxChars = 6000
+
+// defaultConcurrency bounds the chunks of a text embedded at the same time. Ollama queues
+// requests beyond its OLLAMA_NUM_PARALLEL, so more rarely helps.
+const defaultConcurrency = 4
+
+// digestRetryInterval spaces out lookups of the model digest while Ollama cannot tell it.
+const digestRetryInterval = time.Minute
+
+// dependency names Ollama in errs.DependencyError.
+const dependency = "ollama"
+
+var splitTextsTotal = metrics.NewCounter("embedding_split_texts_total", "Texts longer than the embedding limit that were embedded in chunks.")
+
 type OllamaEmbedRequest struct {
 	Model  string `json:"model"`
 	Prompt string `json:"prompt"`
@@ -20,23 +47,215 @@ type OllamaEmbedResponse struct {
 	Embedding []float32 `json:"embedding"`
 }
 
+// OllamaTagsResponse lists the models available in Ollama.
+type OllamaTagsResponse struct {
+	Models []struct {
+		Name   string `json:"name"`
+		Digest string `json:"digest"`
+	} `json:"models"`
+}
+
 type Service struct {
 	ollamaURL      string
 	embeddingModel string
+	hooks          *hooks.Registry // BeforeEmbed hooks, nil if none
+	maxChars       int             // Texts above this are split, -1 never splits
+	concurrency    int             // Chunks of a text embedded at the same time
+	normalize      bool            // Scale vectors to unit length
+	retries        int             // Requests repeated after an invalid vector
+	dimensions     atomic.Int64    // Expected vector dimension, 0 until the first vector
 	httpClient     *http.Client
+
+	digestMu        sync.Mutex
+	digest          string    // Of embeddingModel, empty until looked up
+	digestCheckedAt time.Time // Of the last failed lookup
 }
 
-func NewService(cfg *config.OllamaConfig) *Service {
-	return &Service{
+func NewService(cfg *config.OllamaConfig, hookRegistry *hooks.Registry) *Service {
+	maxChars := cfg.EmbeddingMaxChars
+	if maxChars == 0 {
+		maxChars = defaultMaxChars
+	}
+	concurrency := cfg.EmbeddingConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	retries := cfg.EmbeddingRetries
+	if retries == 0 {
+		retries = defaultInvalidRetries
+	}
+	s := &Service{
 		ollamaURL:      cfg.URL,
 		embeddingModel: cfg.EmbeddingModel,
+		hooks:          hookRegistry,
+		maxChars:       maxChars,
+		concurrency:    concurrency,
+		normalize:      cfg.EmbeddingNormalize,
+		retries:        max(retries, 0),
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
 	}
+	s.dimensions.Store(int64(max(cfg.EmbeddingDimensions, 0)))
+	return s
+}
+
+// Model returns the configured embedding model name.
+func (s *Service) Model() string {
+	return s.embeddingModel
+}
+
+// ModelDigest returns the digest of the embedding model's weights as listed by Ollama, which
+// changes when the model is pulled again under the same name. It is looked up once; while
+// Ollama cannot tell it, an empty string is returned and the lookup is retried every minute.
+func (s *Service) ModelDigest() string {
+	s.digestMu.Lock()
+	defer s.digestMu.Unlock()
+	if s.digest != "" || time.Since(s.digestCheckedAt) < digestRetryInterval {
+		return s.digest
+	}
+	digest, err := s.lookupDigest()
+	if err != nil {
+		s.digestCheckedAt = time.Now()
+		log.Printf("Warning: could not look up the digest of embedding model %s: %v", s.embeddingModel, err)
+		return ""
+	}
+	s.digest = digest
+	return digest
+}
+
+// lookupDigest finds the embedding model among the models Ollama lists. Names without a tag
+// refer to the latest tag.
+func (s *Service) lookupDigest() (string, error) {
+	resp, err := s.httpClient.Get(fmt.Sprintf("%s/api/tags", s.ollamaURL))
+	if err != nil {
+		return "", fmt.Errorf("failed to call ollama tags API: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("ollama tags API returned non-OK status: %d", resp.StatusCode)
+	}
+	var tags OllamaTagsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return "", fmt.Errorf("failed to decode ollama tags response: %w", err)
+	}
+
+	name := s.embeddingModel
+	if !strings.Contains(name, ":") {
+		name += ":latest"
+	}
+	for _, m := range tags.Models {
+		if m.Name == name || m.Name == s.embeddingModel {
+			return m.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("model is not available in ollama")
+}
+
+// GetEmbedding embeds text like GetEmbeddingLimited with the configured chunk size, giving up
+// when ctx is done, e.g. at the retrieval deadline of a query.
+func (s *Service) GetEmbedding(ctx context.Context, text string) ([]float32, error) {
+	vector, _, err := s.getEmbedding(ctx, text, 0)
+	return vector, err
 }
 
-func (s *Service) GetEmbedding(text string) ([]float32, error) {
+// GetEmbeddingLimited embeds text, splitting it into chunks of at most maxChars characters
+// (0 uses ollama.embedding_max_chars, -1 never splits) whose vectors are averaged. Chunks are
+// embedded concurrently, up to ollama.embedding_concurrency at a time. With
+// ollama.embedding_normalize the chunk vectors and their average are scaled to unit length.
+// It also returns where each chunk lies in the text, in chunk order.
+func (s *Service) GetEmbeddingLimited(text string, maxChars int) ([]float32, []window.ChunkRange, error) {
+	return s.getEmbedding(context.Background(), text, maxChars)
+}
+
+func (s *Service) getEmbedding(ctx context.Context, text string, maxChars int) ([]float32, []window.ChunkRange, error) {
+	if maxChars == 0 {
+		maxChars = s.maxChars
+	}
+	text, err := s.hooks.RunBeforeEmbed(text)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	chunks := splitText(text, maxChars)
+	if len(chunks) > 1 {
+		log.Printf("Embedding text of %d characters in %d chunks (limit %d)", utf8.RuneCountInString(text), len(chunks), maxChars)
+		splitTextsTotal.Inc()
+	}
+	vectors, err := s.embedChunks(ctx, chunks)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	weights := make([]int, len(chunks))
+	ranges := make([]window.ChunkRange, len(chunks))
+	for i, chunk := range chunks {
+		if len(vectors[i]) != len(vectors[0]) {
+			return nil, nil, fmt.Errorf("embedding chunks have different dimensions (%d and %d)", len(vectors[0]), len(vectors[i]))
+		}
+		if s.normalize {
+			normalize(vectors[i])
+		}
+		weights[i] = utf8.RuneCountInString(chunk.text)
+		start := utf8.RuneCountInString(text[:chunk.start])
+		ranges[i] = window.ChunkRange{Index: i, Start: start, End: start + utf8.RuneCountInString(text[chunk.start:chunk.end])}
+	}
+	vector := averageVectors(vectors, weights)
+	if s.normalize && len(vectors) > 1 {
+		normalize(vector)
+	}
+	return vector, ranges, nil
+}
+
+// embedChunks embeds the chunks with a pool of up to s.concurrency requests and returns their
+// vectors in chunk order. After a failure no further chunks are started, and the error of the
+// first failed chunk is returned.
+func (s *Service) embedChunks(ctx context.Context, chunks []textChunk) ([][]float32, error) {
+	vectors := make([][]float32, len(chunks))
+	if len(chunks) == 1 {
+		vector, err := s.embedChecked(ctx, chunks[0].text)
+		if err != nil {
+			return nil, err
+		}
+		vectors[0] = vector
+		return vectors, nil
+	}
+
+	failures := make([]error, len(chunks))
+	slots := make(chan struct{}, s.concurrency)
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		slots <- struct{}{}
+		if failed.Load() {
+			<-slots
+			break
+		}
+		wg.Add(1)
+		go func(i int, text string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			vectors[i], failures[i] = s.embedChecked(ctx, text)
+			if failures[i] != nil {
+				failed.Store(true)
+			}
+		}(i, chunk.text)
+	}
+	wg.Wait()
+	for _, err := range failures {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return vectors, nil
+}
+
+// embed requests the embedding of a single text, see embedChecked. Failures of the call are
+// errs.DependencyError.
+func (s *Service) embed(ctx context.Context, text string) ([]float32, error) {
+	if err := faults.Inject(faults.Ollama); err != nil {
+		return nil, errs.Dependency(dependency, 0, fmt.Errorf("failed to call ollama embeddings API: %w", err))
+	}
 	reqBody, err := json.Marshal(OllamaEmbedRequest{
 		Model:  s.embeddingModel,
 		Prompt: text,
@@ -46,20 +265,25 @@ func (s *Service) GetEmbedding(text string) ([]float32, error) {
 	}
 
 	url := fmt.Sprintf("%s/api/embeddings", s.ollamaURL)
-	resp, err := s.httpClient.Post(url, "application/json", bytes.NewReader(reqBody))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ollama embed request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to call ollama embeddings API: %w", err)
+		return nil, errs.Dependency(dependency, 0, fmt.Errorf("failed to call ollama embeddings API: %w", err))
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("ollama embeddings API returned non-OK status: %d, body: %s", resp.StatusCode, string(bodyBytes))
+		return nil, errs.Dependency(dependency, resp.StatusCode, fmt.Errorf("ollama embeddings API returned non-OK status: %d, body: %s", resp.StatusCode, string(bodyBytes)))
 	}
 
 	var embedResp OllamaEmbedResponse
 	if err := json.NewDecoder(resp.Body).Decode(&embedResp); err != nil {
-		return nil, fmt.Errorf("failed to decode ollama embed response: %w", err)
+		return nil, errs.Dependency(dependency, resp.StatusCode, fmt.Errorf("failed to decode ollama embed response: %w", err))
 	}
 
 	return embedResp.Embedding, nil
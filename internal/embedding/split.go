@@ -0,0 +1,119 @@
+package embedding
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// textChunk is a piece of a split text.
+type textChunk struct {
+	text       string
+	start, end int // Byte offsets of the chunk in the text
+}
+
+// splitText splits text into chunks of at most maxChars characters. Chunks end at line
+// breaks where possible so rendered messages stay whole, then at spaces, and only lines
+// without any break are cut mid-word. Chunks are returned in text order with their offsets.
+func splitText(text string, maxChars int) []textChunk {
+	if maxChars <= 0 || utf8.RuneCountInString(text) <= maxChars {
+		return []textChunk{{text: text, start: 0, end: len(text)}}
+	}
+
+	var chunks []textChunk
+	var current strings.Builder
+	currentLen := 0
+	currentStart, currentEnd := 0, 0
+	flush := func() {
+		chunk := current.String()
+		if trimmed := strings.TrimSpace(chunk); trimmed != "" {
+			// Lines are joined as in the text and words carry no spaces, so the chunk's
+			// surrounding spaces are those of the text
+			lead := len(chunk) - len(strings.TrimLeftFunc(chunk, unicode.IsSpace))
+			trail := len(chunk) - len(strings.TrimRightFunc(chunk, unicode.IsSpace))
+			chunks = append(chunks, textChunk{text: trimmed, start: currentStart + lead, end: currentEnd - trail})
+		}
+		current.Reset()
+		currentLen = 0
+	}
+	add := func(piece string, sep string, offset int) {
+		n := utf8.RuneCountInString(piece)
+		if currentLen > 0 && currentLen+len(sep)+n > maxChars {
+			flush()
+		}
+		if currentLen > 0 {
+			current.WriteString(sep)
+			currentLen += len(sep)
+		} else {
+			currentStart = offset
+		}
+		current.WriteString(piece)
+		currentLen += n
+		currentEnd = offset + len(piece)
+	}
+
+	offset := 0
+	for _, line := range strings.Split(text, "\n") {
+		lineStart := offset
+		offset += len(line) + 1
+		if utf8.RuneCountInString(line) <= maxChars {
+			add(line, "\n", lineStart)
+			continue
+		}
+		// An over-long line: continue with words, cutting words longer than a chunk
+		flush()
+		for _, w := range words(line) {
+			word, wordStart := w.text, lineStart+w.start
+			for utf8.RuneCountInString(word) > maxChars {
+				cut := len(string([]rune(word)[:maxChars]))
+				add(word[:cut], " ", wordStart)
+				word, wordStart = word[cut:], wordStart+cut
+			}
+			add(word, " ", wordStart)
+		}
+		flush()
+	}
+	flush()
+	return chunks
+}
+
+// words splits a line into its words with their offsets, like strings.Fields.
+func words(line string) []textChunk {
+	var result []textChunk
+	start := -1
+	for i, r := range line {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				result = append(result, textChunk{text: line[start:i], start: start, end: i})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		result = append(result, textChunk{text: line[start:], start: start, end: len(line)})
+	}
+	return result
+}
+
+// averageVectors returns the mean of the vectors weighted by the length of the chunk each
+// was computed from, so a short trailing chunk does not pull the result as much as a full one.
+func averageVectors(vectors [][]float32, weights []int) []float32 {
+	if len(vectors) == 1 {
+		return vectors[0]
+	}
+	avg := make([]float32, len(vectors[0]))
+	total := 0
+	for i, v := range vectors {
+		w := weights[i]
+		total += w
+		for j := range avg {
+			avg[j] += v[j] * float32(w)
+		}
+	}
+	for j := range avg {
+		avg[j] /= float32(total)
+	}
+	return avg
+}
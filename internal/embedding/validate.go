@@ -0,0 +1,103 @@
+package embedding
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"stream-rag-agent/internal/metrics"
+	"stream-rag-agent/pkg/errs"
+)
+
+// defaultInvalidRetries is how often a text is embedded again after the model returned an
+// unusable vector, which some models do now and then under load.
+const defaultInvalidRetries = 2
+
+// invalidRetryBackoff spaces out the requests for a text whose vector was unusable.
+const invalidRetryBackoff = 250 * time.Millisecond
+
+var invalidVectorsTotal = metrics.NewCounter("embedding_invalid_vectors_total", "Vectors returned by the embedding model that were rejected, by reason (empty, nan, zero, dimensions).")
+
+// embedChecked embeds a single text and validates its vector. Empty vectors, vectors with NaN
+// or infinite values and zero vectors are requested again up to s.retries times before the
+// text fails; a vector of the wrong dimension fails it at once, since asking again does not
+// change the model. Retries stop when ctx is done.
+func (s *Service) embedChecked(ctx context.Context, text string) ([]float32, error) {
+	for attempt := 0; ; attempt++ {
+		vector, err := s.embed(ctx, text)
+		if err != nil {
+			return nil, err
+		}
+		reason := invalidReason(vector)
+		if reason == "" {
+			if err := s.checkDimensions(len(vector)); err != nil {
+				invalidVectorsTotal.Inc("reason", "dimensions")
+				return nil, err
+			}
+			return vector, nil
+		}
+		invalidVectorsTotal.Inc("reason", reason)
+		if attempt >= s.retries {
+			return nil, errs.Dependency(dependency, 0, fmt.Errorf("embedding model %s returned an invalid vector (%s) %d times", s.embeddingModel, reason, attempt+1))
+		}
+		log.Printf("Warning: embedding model %s returned an invalid vector (%s), embedding the text again", s.embeddingModel, reason)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(invalidRetryBackoff):
+		}
+	}
+}
+
+// invalidReason tells why a vector cannot be stored or compared, or returns "" if it can.
+func invalidReason(vector []float32) string {
+	if len(vector) == 0 {
+		return "empty"
+	}
+	zero := true
+	for _, x := range vector {
+		if math.IsNaN(float64(x)) || math.IsInf(float64(x), 0) {
+			return "nan"
+		}
+		if x != 0 {
+			zero = false
+		}
+	}
+	if zero {
+		return "zero"
+	}
+	return ""
+}
+
+// checkDimensions asserts that a vector has ollama.embedding_dimensions values or, when that
+// is not set, as many as the first vector the model returned.
+func (s *Service) checkDimensions(dims int) error {
+	expected := s.dimensions.Load()
+	if expected == 0 {
+		s.dimensions.CompareAndSwap(0, int64(dims))
+		expected = s.dimensions.Load()
+	}
+	if int64(dims) != expected {
+		return fmt.Errorf("embedding model %s returned a vector of %d dimensions, expected %d", s.embeddingModel, dims, expected)
+	}
+	return nil
+}
+
+// normalize scales a vector to unit length in place, so that cosine scores are not skewed by
+// models returning vectors of varying magnitude. Zero vectors are left as they are.
+func normalize(vector []float32) []float32 {
+	var sum float64
+	for _, x := range vector {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return vector
+	}
+	norm := math.Sqrt(sum)
+	for i, x := range vector {
+		vector[i] = float32(float64(x) / norm)
+	}
+	return vector
+}
@@ -0,0 +1,124 @@
+// Package faults is an opt-in fault injection layer for resilience testing. When enabled,
+// calls to Ollama, Elasticsearch and Kafka pass through Inject, which can delay them or
+// fail them with a configured probability.
+package faults
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"stream-rag-agent/internal/metrics"
+)
+
+// Injection targets.
+const (
+	Ollama        = "ollama"
+	Elasticsearch = "elasticsearch"
+	Kafka         = "kafka"
+)
+
+// ErrInjected is returned by Inject for simulated failures.
+var ErrInjected = errors.New("injected fault")
+
+var injectedTotal = metrics.NewCounter("faults_injected_total", "Simulated failures and delays injected into dependency calls.")
+
+// Fault describes how calls to a target are disturbed.
+type Fault struct {
+	Target    string     `json:"target"`
+	LatencyMs int        `json:"latency_ms,omitempty"` // Delay added before each call
+	ErrorRate float64    `json:"error_rate,omitempty"` // Probability of failing a call, 1 makes the target unavailable
+	Until     *time.Time `json:"until,omitempty"`      // When the fault expires, nil keeps it until cleared
+}
+
+var state = struct {
+	mu      sync.Mutex
+	enabled bool
+	faults  map[string]Fault
+}{faults: make(map[string]Fault)}
+
+// Enable turns fault injection on. Without it, Set is rejected and Inject is a no-op.
+func Enable() {
+	state.mu.Lock()
+	state.enabled = true
+	state.mu.Unlock()
+}
+
+func Enabled() bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.enabled
+}
+
+// Set installs or replaces the fault for its target.
+func Set(f Fault) error {
+	switch f.Target {
+	case Ollama, Elasticsearch, Kafka:
+	default:
+		return fmt.Errorf("unknown fault target %q (use %s, %s or %s)", f.Target, Ollama, Elasticsearch, Kafka)
+	}
+	if f.LatencyMs < 0 || f.ErrorRate < 0 || f.ErrorRate > 1 {
+		return fmt.Errorf("latency_ms must be positive and error_rate between 0 and 1")
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if !state.enabled {
+		return fmt.Errorf("fault injection is disabled; set faults.enabled in the config")
+	}
+	state.faults[f.Target] = f
+	return nil
+}
+
+// Clear removes the fault for a target, or all faults when target is empty.
+func Clear(target string) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if target == "" {
+		state.faults = make(map[string]Fault)
+		return
+	}
+	delete(state.faults, target)
+}
+
+// List returns the active faults.
+func List() []Fault {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	list := make([]Fault, 0, len(state.faults))
+	for _, f := range state.faults {
+		if f.Until == nil || time.Now().Before(*f.Until) {
+			list = append(list, f)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Target < list[j].Target })
+	return list
+}
+
+// Inject applies the fault configured for target, if any: it sleeps for the configured
+// latency and then returns ErrInjected with the configured probability.
+func Inject(target string) error {
+	state.mu.Lock()
+	f, ok := state.faults[target]
+	if ok && f.Until != nil && !time.Now().Before(*f.Until) {
+		delete(state.faults, target)
+		ok = false
+	}
+	state.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	if f.LatencyMs > 0 {
+		injectedTotal.Inc("target", target, "kind", "latency")
+		time.Sleep(time.Duration(f.LatencyMs) * time.Millisecond)
+	}
+	if f.ErrorRate > 0 && rand.Float64() < f.ErrorRate {
+		injectedTotal.Inc("target", target, "kind", "error")
+		return fmt.Errorf("%w: %s unavailable", ErrInjected, target)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package governance
+
+import (
+	"fmt"
+	"log"
+
+	"stream-rag-agent/internal/config"
+)
+
+// Classification levels, from least to most sensitive.
+const (
+	Public     = "public"
+	Internal   = "internal"
+	Restricted = "restricted"
+)
+
+// What happens when a request would send data above the external limit to an external model.
+const (
+	OnRestrictedExclude = "exclude" // Retrieve without the topics above the limit (default)
+	OnRestrictedLocal   = "local"   // Answer with the local model instead, keeping all topics
+)
+
+var levels = map[string]int{Public: 0, Internal: 1, Restricted: 2}
+
+// Policy decides which topics' data may be sent to which LLM. A nil Policy allows everything.
+type Policy struct {
+	external   map[string]bool
+	aboveLimit []string // Topics classified above what external models may receive
+	mode       string
+	localModel string
+}
+
+// NewPolicy validates topic classifications against the governance config. It returns nil
+// when no external models are configured, since then no data leaves the organisation.
+func NewPolicy(cfg config.DataGovernanceConfig, topics []config.KafkaTopicConfig, localModel string) (*Policy, error) {
+	maxLevel := Internal
+	if cfg.ExternalMaxClassification != "" {
+		maxLevel = cfg.ExternalMaxClassification
+	}
+	limit, ok := levels[maxLevel]
+	if !ok {
+		return nil, fmt.Errorf("invalid data_governance.external_max_classification '%s'", maxLevel)
+	}
+
+	var aboveLimit []string
+	for _, t := range topics {
+		level, ok := levels[Classification(t)]
+		if !ok {
+			return nil, fmt.Errorf("topic '%s' has invalid classification '%s' (expected %s, %s or %s)", t.Name, t.Classification, Public, Internal, Restricted)
+		}
+		if level > limit {
+			aboveLimit = append(aboveLimit, t.Name)
+		}
+	}
+
+	if len(cfg.ExternalModels) == 0 {
+		return nil, nil
+	}
+	p := &Policy{external: make(map[string]bool), aboveLimit: aboveLimit, mode: cfg.OnRestricted, localModel: localModel}
+	for _, m := range cfg.ExternalModels {
+		p.external[m] = true
+	}
+	switch p.mode {
+	case "":
+		p.mode = OnRestrictedExclude
+	case OnRestrictedExclude:
+	case OnRestrictedLocal:
+		if p.external[localModel] {
+			return nil, fmt.Errorf("data_governance.on_restricted is '%s' but ollama.llm_model '%s' is an external model", OnRestrictedLocal, localModel)
+		}
+	default:
+		return nil, fmt.Errorf("invalid data_governance.on_restricted '%s'", p.mode)
+	}
+	if len(aboveLimit) > 0 {
+		log.Printf("Data governance: topics %v are not sent to external models %v (%s)", aboveLimit, cfg.ExternalModels, p.mode)
+	}
+	return p, nil
+}
+
+// Classification returns the topic's classification, internal when none is configured.
+func Classification(t config.KafkaTopicConfig) string {
+	if t.Classification == "" {
+		return Internal
+	}
+	return t.Classification
+}
+
+// Decision is how a request is answered under the policy.
+type Decision struct {
+	Model         string   // Model to answer with
+	Routed        bool     // Model was replaced by the local model
+	ExcludeTopics []string // Topics that must not be retrieved for this request
+}
+
+// Allows reports whether data of the topic may be used for the request.
+func (d Decision) Allows(topic string) bool {
+	for _, t := range d.ExcludeTopics {
+		if t == topic {
+			return false
+		}
+	}
+	return true
+}
+
+// Decide applies the policy to a request answered by model.
+func (p *Policy) Decide(model string) Decision {
+	if p == nil || !p.external[model] || len(p.aboveLimit) == 0 {
+		return Decision{Model: model}
+	}
+	if p.mode == OnRestrictedLocal {
+		return Decision{Model: p.localModel, Routed: true}
+	}
+	return Decision{Model: model, ExcludeTopics: p.aboveLimit}
+}
@@ -0,0 +1,295 @@
+// Package ingestion switches the ingestion of topics on and off at runtime, e.g. while a
+// topic carries garbage that should not pollute the index. Topic states set through the API
+// are persisted to a JSON file so they survive restarts.
+package ingestion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"stream-rag-agent/internal/atrest"
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/metrics"
+	"stream-rag-agent/pkg/errs"
+	"stream-rag-agent/pkg/windowing"
+)
+
+// Ingestion states of a topic.
+const (
+	StateEnabled = "enabled" // Messages are windowed and indexed
+	StateSkip    = "skip"    // Messages are consumed and committed, but not windowed
+	StatePaused  = "paused"  // Messages are not fetched; consumption resumes where it stopped
+)
+
+// defaultFile is where topic states are persisted unless ingestion.file is set.
+const defaultFile = "./ingestion.json"
+
+var (
+	ErrTopicNotFound = errs.New(errs.ErrNotFound, "topic not found")
+
+	topicState      = metrics.NewGauge("ingestion_state", "Ingestion state of a topic: 1 for the current state, 0 for the others.")
+	skippedMessages = metrics.NewCounter("ingestion_skipped_messages_total", "Messages consumed and committed without being windowed while ingestion of their topic is skipped, by topic.")
+)
+
+// TopicState is the ingestion state of a topic.
+type TopicState struct {
+	Topic     string     `json:"topic"`
+	State     string     `json:"state"`
+	Reason    string     `json:"reason,omitempty"`
+	ChangedAt *time.Time `json:"changed_at,omitempty"` // nil if the topic was never switched
+}
+
+// Store keeps the ingestion state of each topic.
+type Store struct {
+	mu     sync.Mutex
+	path   string
+	topics map[string]*topic
+	saved  map[string]TopicState // Loaded from the file, applied when the topic is registered
+}
+
+type topic struct {
+	state     TopicState
+	skippable bool
+	resumed   chan struct{} // Closed unless the topic is paused
+}
+
+func NewStore(cfg config.IngestionConfig) (*Store, error) {
+	s := &Store{path: cfg.File, topics: make(map[string]*topic), saved: make(map[string]TopicState)}
+	if s.path == "" {
+		s.path = defaultFile
+	}
+	data, err := atrest.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read ingestion state file: %w", err)
+	}
+	if len(data) > 0 {
+		var saved []TopicState
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal ingestion state file: %w", err)
+		}
+		for _, st := range saved {
+			s.saved[st.Topic] = st
+		}
+	}
+	return s, nil
+}
+
+// Register adds a topic, in the state it was saved in or enabled. skippable tells whether
+// messages of the topic can be committed without being windowed: sources that acknowledge
+// messages once their window is processed cannot skip them.
+func (s *Store) Register(name string, skippable bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := &topic{state: TopicState{Topic: name, State: StateEnabled}, skippable: skippable, resumed: make(chan struct{})}
+	close(t.resumed)
+	s.topics[name] = t
+	if saved, ok := s.saved[name]; ok {
+		if saved.State == StateSkip && !skippable {
+			log.Printf("Warning: Topic %s was saved with ingestion skipped, which its source does not support; ingesting it", name)
+		} else {
+			t.set(saved)
+		}
+	}
+	if t.state.State != StateEnabled {
+		log.Printf("Ingestion of topic %s is %s (%s)", name, t.state.State, t.state.Reason)
+	}
+	t.export()
+}
+
+// Set switches the ingestion of a topic to state, persisting it.
+func (s *Store) Set(name, state, reason string) (TopicState, error) {
+	switch state {
+	case StateEnabled, StateSkip, StatePaused:
+	default:
+		return TopicState{}, errs.Errorf(errs.ErrInvalidRequest, "unknown ingestion state '%s' (expected %s, %s or %s)", state, StateEnabled, StateSkip, StatePaused)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.topics[name]
+	if !ok {
+		return TopicState{}, fmt.Errorf("%w: %s", ErrTopicNotFound, name)
+	}
+	if state == StateSkip && !t.skippable {
+		return TopicState{}, errs.Errorf(errs.ErrInvalidRequest, "the source of topic %s acknowledges messages once their window is processed, so they cannot be skipped; pause it instead", name)
+	}
+	now := time.Now().UTC()
+	t.set(TopicState{Topic: name, State: state, Reason: reason, ChangedAt: &now})
+	t.export()
+	s.saved[name] = t.state
+	return t.state, s.saveLocked()
+}
+
+// Pause pauses the ingestion of the enabled topics until resume is called, e.g. while the
+// index is restored, without persisting it: the topics are ingested again after a restart.
+// Topics switched in the meantime keep their new state. It returns the paused topics, by name.
+func (s *Store) Pause(reason string) (paused []string, resume func()) {
+	if s == nil {
+		return nil, func() {}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	held := make(map[string]TopicState) // Paused topic -> state to resume to
+	changed := make(map[string]*time.Time)
+	for name, t := range s.topics {
+		if t.state.State != StateEnabled {
+			continue
+		}
+		now := time.Now().UTC()
+		held[name] = t.state
+		changed[name] = &now
+		t.set(TopicState{Topic: name, State: StatePaused, Reason: reason, ChangedAt: &now})
+		t.export()
+		paused = append(paused, name)
+	}
+	sort.Strings(paused)
+	if len(paused) > 0 {
+		log.Printf("Paused ingestion of %d topics: %s", len(paused), reason)
+	}
+	return paused, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for name, st := range held {
+			t := s.topics[name]
+			if t.state.ChangedAt != changed[name] {
+				continue // Switched in the meantime
+			}
+			t.set(st)
+			t.export()
+		}
+		if len(paused) > 0 {
+			log.Printf("Resumed ingestion of %d topics paused while %s", len(paused), reason)
+		}
+	}
+}
+
+// set applies a state, holding back or releasing fetching.
+func (t *topic) set(st TopicState) {
+	wasPaused := t.state.State == StatePaused
+	t.state = st
+	switch {
+	case st.State == StatePaused && !wasPaused:
+		t.resumed = make(chan struct{})
+	case st.State != StatePaused && wasPaused:
+		close(t.resumed)
+	}
+}
+
+func (t *topic) export() {
+	for _, state := range []string{StateEnabled, StateSkip, StatePaused} {
+		value := 0.0
+		if state == t.state.State {
+			value = 1
+		}
+		topicState.Set(value, "topic", t.state.Topic, "state", state)
+	}
+}
+
+// List returns the states of the registered topics, by name.
+func (s *Store) List() []TopicState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]TopicState, 0, len(s.topics))
+	for _, t := range s.topics {
+		list = append(list, t.state)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Topic < list[j].Topic })
+	return list
+}
+
+// Get returns the state of a topic.
+func (s *Store) Get(name string) (TopicState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.topics[name]
+	if !ok {
+		return TopicState{}, fmt.Errorf("%w: %s", ErrTopicNotFound, name)
+	}
+	return t.state, nil
+}
+
+// saveLocked writes the states that differ from enabled, including those of topics that are
+// not configured at the moment.
+func (s *Store) saveLocked() error {
+	saved := make([]TopicState, 0, len(s.saved))
+	for _, st := range s.saved {
+		if st.State != StateEnabled {
+			saved = append(saved, st)
+		}
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Topic < saved[j].Topic })
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal ingestion states: %w", err)
+	}
+	if err := atrest.WriteFile(s.path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write ingestion state file: %w", err)
+	}
+	return nil
+}
+
+// Sink returns a sink passing the messages of a registered topic on to sink while it is
+// enabled, and dropping them while it is skipped. It is a source.PausableSink, so the source
+// stops fetching while the topic is paused.
+func (s *Store) Sink(name string, sink windowing.Sink) *Sink {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &Sink{store: s, name: name, topic: s.topics[name], sink: sink}
+}
+
+// Sink is the gate of a topic's messages, see Store.Sink.
+type Sink struct {
+	store *Store
+	name  string
+	topic *topic
+	sink  windowing.Sink
+}
+
+func (g *Sink) current() (state string, resumed <-chan struct{}) {
+	g.store.mu.Lock()
+	defer g.store.mu.Unlock()
+	return g.topic.state.State, g.topic.resumed
+}
+
+func (g *Sink) AddMessage(msg windowing.Message) {
+	if state, _ := g.current(); state == StateSkip {
+		skippedMessages.Inc("topic", g.name)
+		return
+	}
+	g.sink.AddMessage(msg)
+}
+
+func (g *Sink) AddMessages(msgs []windowing.Message) {
+	if state, _ := g.current(); state == StateSkip {
+		skippedMessages.Add(float64(len(msgs)), "topic", g.name)
+		return
+	}
+	if batches, ok := g.sink.(windowing.BatchSink); ok {
+		batches.AddMessages(msgs)
+		return
+	}
+	for _, msg := range msgs {
+		g.sink.AddMessage(msg)
+	}
+}
+
+// WaitResumed blocks while the topic is paused, until ctx is cancelled.
+func (g *Sink) WaitResumed(ctx context.Context) {
+	for {
+		_, resumed := g.current()
+		select {
+		case <-resumed:
+			// Paused again in the meantime?
+			if state, _ := g.current(); state != StatePaused {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -2,74 +2,329 @@ package kafka
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 	"stream-rag-agent/internal/config"
-	"stream-rag-agent/internal/window"
+	"stream-rag-agent/internal/faults"
+	"stream-rag-agent/internal/metrics"
+	"stream-rag-agent/pkg/windowing"
 )
 
+const defaultBatchLinger = 100 * time.Millisecond
+
+var fetchedBatchSize = metrics.NewGauge("kafka_consumer_batch_size", "Messages in the most recent batch fetched from a topic with batch.size above 1.")
+
+// Consumer reads a topic through the consumer group; it is the agent's source.Source for Kafka.
 type Consumer struct {
-	reader *kafka.Reader
-	config config.KafkaTopicConfig
-	wm     *window.Manager // Window Manager for this topic's messages
+	reader       groupReader
+	readerConfig kafka.ReaderConfig // Used to recreate the reader after a pause
+	instanceID   string             // Group instance ID for static membership, empty for dynamic membership
+	rebalance    *rebalanceNotifier // Tells the listener about the readers' rebalances
+	config       config.KafkaTopicConfig
+	throttle     *tokenBucket  // nil when the topic is not throttled
+	batchSize    int           // Messages per FetchBatch, at least 1
+	batchLinger  time.Duration // How long FetchBatch waits for more messages
+	fetchedWith  groupReader   // Reader of the last fetched message, used by Commit
+	security     security      // TLS and SASL settings of the topic's cluster
+	decoder      ValueDecoder  // Set for topics with value_format schema_registry or protobuf
+
+	mu      sync.Mutex
+	paused  bool
+	resumed chan struct{} // Closed when a paused consumer resumes
+
+	lagMu sync.Mutex
+	lag   TopicLag // Last measurement, see MonitorLag
 }
 
-func NewConsumer(cfg config.KafkaTopicConfig, consumerGroupID string, brokers []string, wm *window.Manager) *Consumer {
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:  brokers,
-		GroupID:  consumerGroupID,
-		Topic:    cfg.Name,
-		MinBytes: 10e3, // 10KB
-		MaxBytes: 10e6, // 10MB
-		MaxWait:  1 * time.Second,
-	})
-	return &Consumer{
-		reader: reader,
-		config: cfg,
-		wm:     wm,
+// NewConsumer creates the consumer of a topic in the topic's consumer group, or the group of
+// its cluster. With a group_instance_id, it joins the group as a static member.
+func NewConsumer(cfg config.KafkaTopicConfig, cluster config.KafkaClusterConfig) (*Consumer, error) {
+	sec, err := newSecurity(cluster)
+	if err != nil {
+		return nil, err
+	}
+	startOffset := kafka.FirstOffset
+	switch cfg.StartOffset {
+	case "", ResetEarliest:
+	case ResetLatest:
+		startOffset = kafka.LastOffset
+	case ResetTimestamp:
+		if cfg.StartTimestamp.IsZero() {
+			return nil, fmt.Errorf("topic %s has start_offset timestamp but no start_timestamp", cfg.Name)
+		}
+	default:
+		return nil, fmt.Errorf("invalid start_offset '%s' of topic %s (expected earliest, latest or timestamp)", cfg.StartOffset, cfg.Name)
+	}
+	groupID := cluster.ConsumerGroupID
+	if cfg.ConsumerGroupID != "" {
+		groupID = cfg.ConsumerGroupID
+	}
+	var instanceID string
+	if cfg.GroupInstanceID != "" {
+		instance := os.ExpandEnv(cfg.GroupInstanceID)
+		if instance == "" {
+			return nil, fmt.Errorf("group_instance_id '%s' of topic %s expands to an empty string", cfg.GroupInstanceID, cfg.Name)
+		}
+		instanceID = instance + "-" + cfg.Name
 	}
+	readerConfig := kafka.ReaderConfig{
+		Brokers:        cluster.Brokers,
+		GroupID:        groupID,
+		Topic:          cfg.Name,
+		Dialer:         sec.dialer(),
+		MinBytes:       10e3, // 10KB
+		MaxBytes:       10e6, // 10MB
+		MaxWait:        1 * time.Second,
+		StartOffset:    startOffset, // For partitions the group has no committed offset for
+		SessionTimeout: time.Duration(cfg.SessionTimeoutSeconds) * time.Second,
+	}
+	if instanceID == "" {
+		groupCfg := groupConfig(readerConfig)
+		if err := groupCfg.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid consumer group config for topic %s: %w", cfg.Name, err)
+		}
+	}
+	batchLinger := time.Duration(cfg.Batch.LingerMs) * time.Millisecond
+	if batchLinger <= 0 {
+		batchLinger = defaultBatchLinger
+	}
+	c := &Consumer{
+		readerConfig: readerConfig,
+		instanceID:   instanceID,
+		rebalance:    &rebalanceNotifier{topic: cfg.Name},
+		config:       cfg,
+		throttle:     newTokenBucket(cfg.MaxMessagesPerSecond, cfg.ThrottleBurst),
+		batchSize:    max(cfg.Batch.Size, 1),
+		batchLinger:  batchLinger,
+		security:     sec,
+	}
+	c.reader = c.newReader()
+	if instanceID != "" {
+		log.Printf("Topic %s is consumed by static member %s of group %s", cfg.Name, instanceID, groupID)
+	}
+	return c, nil
 }
 
-func (c *Consumer) StartConsuming(ctx context.Context, partition int32) {
-	log.Printf("Starting Kafka consumer for topic: %s, partition: %d", c.config.Name, partition)
+// newReader joins the consumer group.
+func (c *Consumer) newReader() groupReader {
+	if c.instanceID != "" {
+		// Joining waits for the group to rebalance, so requests are bounded by the member
+		client := &kafka.Client{Addr: kafka.TCP(c.readerConfig.Brokers...), Transport: c.security.transport()}
+		return newStaticMember(c.readerConfig, c.instanceID, client, c.rebalance)
+	}
+	return newGroupMember(c.readerConfig, c.rebalance)
+}
 
+// ValueDecoder converts the values of a topic into JSON, e.g. a *schemaregistry.Decoder.
+type ValueDecoder interface {
+	Decode(topic string, payload []byte) ([]byte, error)
+}
+
+// SetDecoder makes the consumer convert values into JSON with d, e.g. Schema Registry framed
+// or raw Protobuf values.
+func (c *Consumer) SetDecoder(d ValueDecoder) {
+	c.decoder = d
+}
+
+// decodeValue converts a value into JSON with the consumer's decoder. Values that cannot be
+// decoded are passed on as they are, so the window still records that they arrived.
+func (c *Consumer) decodeValue(msg kafka.Message) []byte {
+	if c.decoder == nil || msg.Value == nil {
+		return msg.Value
+	}
+	value, err := c.decoder.Decode(c.config.Name, msg.Value)
+	if err != nil {
+		log.Printf("Warning: Could not decode message (Offset: %d) on topic %s: %v. Using raw payload.", msg.Offset, c.config.Name, err)
+		return msg.Value
+	}
+	return value
+}
+
+// messageHeaders returns the headers of the message by name, nil if it has none. Of repeated
+// headers the last one wins.
+func messageHeaders(msg kafka.Message) map[string]string {
+	if len(msg.Headers) == 0 {
+		return nil
+	}
+	headers := make(map[string]string, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	return headers
+}
+
+// client returns an admin client for the topic's cluster.
+func (c *Consumer) client() *kafka.Client {
+	return &kafka.Client{Addr: kafka.TCP(c.readerConfig.Brokers...), Timeout: 10 * time.Second, Transport: c.security.transport()}
+}
+
+// Topic returns the name of the topic this consumer reads.
+func (c *Consumer) Topic() string {
+	return c.config.Name
+}
+
+// Fetch returns the next message of the topic, waiting while the consumer is paused and
+// throttling to the topic's configured rate.
+func (c *Consumer) Fetch(ctx context.Context) (windowing.Message, error) {
 	for {
-		select {
-		case <-ctx.Done():
-			log.Printf("Stopping Kafka consumer for topic: %s, partition: %d", c.config.Name, partition)
-			return
-		default:
-			msg, err := c.reader.FetchMessage(ctx) // Fetch one message
-			if err != nil {
-				log.Printf("Error fetching message from Kafka topic %s: %v", c.config.Name, err)
-				if ctx.Err() != nil {
-					return
-				}
-				time.Sleep(time.Second)
-				continue
+		reader, resumed := c.currentReader()
+		if reader == nil {
+			// Paused, e.g. while the group's offsets are being reset
+			select {
+			case <-ctx.Done():
+				return windowing.Message{}, ctx.Err()
+			case <-resumed:
 			}
+			continue
+		}
 
-			kafkaMsg := window.RawKafkaMessage{
-				Topic:     msg.Topic,
-				Partition: int32(msg.Partition),
-				Offset:    msg.Offset,
-				Key:       msg.Key,
-				Value:     msg.Value,
-				Timestamp: msg.Time,
-			}
-			c.wm.AddMessage(kafkaMsg)
+		// Throttle before fetching so a runaway producer cannot starve other topics
+		if err := c.throttle.Wait(ctx, c.config.Name); err != nil {
+			return windowing.Message{}, err
+		}
 
-			// Commit
-			err = c.reader.CommitMessages(ctx, msg)
-			if err != nil {
-				log.Printf("Error committing offset for topic %s, partition %d, offset %d: %v", msg.Topic, msg.Partition, msg.Offset, err)
+		msg, err := fetchMessage(ctx, reader)
+		if err != nil {
+			if ctx.Err() == nil && c.isPaused() {
+				continue // The reader was closed by Pause
 			}
+			return windowing.Message{}, err
+		}
+		c.fetchedWith = reader
+		return c.message(msg), nil
+	}
+}
+
+// FetchBatch returns the next message followed by those that arrive within the topic's batch
+// linger, up to its batch size. The batch ends early when the consumer is paused.
+func (c *Consumer) FetchBatch(ctx context.Context) ([]windowing.Message, error) {
+	first, err := c.Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	batch := []windowing.Message{first}
+	if c.batchSize == 1 {
+		return batch, nil
+	}
+
+	lingerCtx, cancel := context.WithTimeout(ctx, c.batchLinger)
+	defer cancel()
+	reader := c.fetchedWith
+	for len(batch) < c.batchSize {
+		if err := c.throttle.Wait(lingerCtx, c.config.Name); err != nil {
+			break
+		}
+		// Errors end the batch: the linger elapsed, ctx was cancelled or Pause closed the
+		// reader. Anything else is returned by the next fetch.
+		msg, err := fetchMessage(lingerCtx, reader)
+		if err != nil {
+			break
 		}
+		batch = append(batch, c.message(msg))
+	}
+	fetchedBatchSize.Set(float64(len(batch)), "topic", c.config.Name)
+	return batch, nil
+}
+
+// message converts a fetched Kafka message.
+func (c *Consumer) message(msg kafka.Message) windowing.Message {
+	return windowing.Message{
+		Topic:     msg.Topic,
+		Partition: int32(msg.Partition),
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Value:     c.decodeValue(msg),
+		Timestamp: msg.Time,
+		Headers:   messageHeaders(msg),
+	}
+}
+
+// Commit commits the offset of the last fetched message through the reader that fetched it,
+// so a message fetched before an offset reset cannot overwrite the reset offsets.
+func (c *Consumer) Commit(ctx context.Context, msg windowing.Message) error {
+	return c.CommitBatch(ctx, []windowing.Message{msg})
+}
+
+// CommitBatch commits the offsets of the last fetched batch with a single request, through
+// the reader that fetched it like Commit.
+func (c *Consumer) CommitBatch(ctx context.Context, msgs []windowing.Message) error {
+	if c.fetchedWith == nil {
+		return nil
+	}
+	kafkaMsgs := make([]kafka.Message, len(msgs))
+	for i, msg := range msgs {
+		kafkaMsgs[i] = kafka.Message{Topic: msg.Topic, Partition: int(msg.Partition), Offset: msg.Offset}
 	}
+	return c.fetchedWith.CommitMessages(ctx, kafkaMsgs...)
+}
+
+// fetchMessage fetches one message, passing through the fault injection layer first.
+func fetchMessage(ctx context.Context, reader groupReader) (kafka.Message, error) {
+	if err := faults.Inject(faults.Kafka); err != nil {
+		return kafka.Message{}, err
+	}
+	return reader.FetchMessage(ctx)
+}
+
+// currentReader returns the active reader, or nil and a channel closed on resume while paused.
+func (c *Consumer) currentReader() (groupReader, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused {
+		return nil, c.resumed
+	}
+	return c.reader, nil
+}
+
+func (c *Consumer) isPaused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// Pause stops fetching and closes the reader, which makes this instance leave the consumer
+// group; static members leave explicitly, which they do not on Close.
+func (c *Consumer) Pause() error {
+	c.mu.Lock()
+	if c.paused {
+		c.mu.Unlock()
+		return nil
+	}
+	c.paused = true
+	c.resumed = make(chan struct{})
+	reader := c.reader
+	c.mu.Unlock()
+
+	log.Printf("Pausing Kafka consumer for topic: %s", c.config.Name)
+	if m, ok := reader.(*staticMember); ok {
+		return m.Leave()
+	}
+	return reader.Close()
+}
+
+// Resume rejoins the consumer group with a new reader, starting from the committed offsets.
+func (c *Consumer) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused {
+		return
+	}
+	c.reader = c.newReader()
+	c.paused = false
+	close(c.resumed)
+	log.Printf("Resumed Kafka consumer for topic: %s", c.config.Name)
 }
 
 func (c *Consumer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused {
+		return nil // The reader was already closed by Pause
+	}
 	return c.reader.Close()
 }
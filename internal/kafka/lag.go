@@ -0,0 +1,171 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"stream-rag-agent/internal/metrics"
+)
+
+// lagInterval is how often the consumer lag of a topic is measured.
+const lagInterval = 15 * time.Second
+
+var (
+	partitionLag = metrics.NewGauge("kafka_consumer_lag", "Messages of a partition not yet consumed and committed by the consumer group (high watermark minus committed offset).")
+	topicLag     = metrics.NewGauge("kafka_consumer_topic_lag", "Consumer lag summed over the partitions of a topic.")
+)
+
+// PartitionLag is the consumer lag of a partition.
+type PartitionLag struct {
+	Partition     int   `json:"partition"`
+	HighWatermark int64 `json:"high_watermark"` // Offset the next message written to the partition gets
+	Committed     int64 `json:"committed"`      // Next offset the group reads, -1 if it has not committed any
+	Lag           int64 `json:"lag"`            // Messages between the two; counted from the log start without a committed offset
+}
+
+// TopicLag is the consumer lag of a topic, as last measured.
+type TopicLag struct {
+	Topic      string         `json:"topic"`
+	Group      string         `json:"group"`
+	Lag        int64          `json:"lag"` // Sum over the partitions
+	Partitions []PartitionLag `json:"partitions"`
+	MeasuredAt time.Time      `json:"measured_at"`
+	Error      string         `json:"error,omitempty"` // Why the latest measurement failed; the figures are from the one before
+}
+
+// Lag returns the last measured lag of the topic, measuring it first if it has never been.
+func (c *Consumer) Lag(ctx context.Context) TopicLag {
+	c.lagMu.Lock()
+	lag := c.lag
+	c.lagMu.Unlock()
+	if lag.MeasuredAt.IsZero() && lag.Error == "" {
+		return c.updateLag(ctx)
+	}
+	return lag
+}
+
+// MonitorLag measures the lag of the topic every lagInterval until ctx is cancelled and
+// exports it as kafka_consumer_lag and kafka_consumer_topic_lag.
+func (c *Consumer) MonitorLag(ctx context.Context) {
+	ticker := time.NewTicker(lagInterval)
+	defer ticker.Stop()
+	for {
+		c.updateLag(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// updateLag measures the lag, keeping the previous figures with the error if it fails.
+func (c *Consumer) updateLag(ctx context.Context) TopicLag {
+	measured, err := c.measureLag(ctx)
+	c.lagMu.Lock()
+	defer c.lagMu.Unlock()
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Measuring consumer lag of topic %s failed: %v", c.config.Name, err)
+		}
+		c.lag.Topic, c.lag.Group, c.lag.Error = c.config.Name, c.readerConfig.GroupID, err.Error()
+		return c.lag
+	}
+	c.lag = measured
+	for _, p := range measured.Partitions {
+		partitionLag.Set(float64(p.Lag), "topic", c.config.Name, "partition", strconv.Itoa(p.Partition))
+	}
+	topicLag.Set(float64(measured.Lag), "topic", c.config.Name)
+	return c.lag
+}
+
+// measureLag compares the high watermarks of the topic's partitions with the group's
+// committed offsets.
+func (c *Consumer) measureLag(ctx context.Context) (TopicLag, error) {
+	client := c.client()
+	topic := c.config.Name
+	partitions, err := topicPartitionIDs(ctx, client, topic)
+	if err != nil {
+		return TopicLag{}, err
+	}
+	committed, err := c.committedOffsets(ctx, client, partitions)
+	if err != nil {
+		return TopicLag{}, err
+	}
+	last, err := listOffsets(ctx, client, topic, partitions, kafka.LastOffsetOf)
+	if err != nil {
+		return TopicLag{}, err
+	}
+	var first map[int]kafka.PartitionOffsets
+	for _, p := range partitions {
+		if committed[p] < 0 && first == nil {
+			if first, err = listOffsets(ctx, client, topic, partitions, kafka.FirstOffsetOf); err != nil {
+				return TopicLag{}, err
+			}
+		}
+	}
+
+	lag := TopicLag{Topic: topic, Group: c.readerConfig.GroupID, MeasuredAt: time.Now()}
+	for _, p := range partitions {
+		pl := PartitionLag{Partition: p, HighWatermark: last[p].LastOffset, Committed: committed[p]}
+		from := pl.Committed
+		if from < 0 {
+			from = first[p].FirstOffset
+		}
+		pl.Lag = max(pl.HighWatermark-from, 0)
+		lag.Lag += pl.Lag
+		lag.Partitions = append(lag.Partitions, pl)
+	}
+	return lag, nil
+}
+
+// committedOffsets returns the consumer group's committed offset of each partition, -1 for
+// partitions it has not committed.
+func (c *Consumer) committedOffsets(ctx context.Context, client *kafka.Client, partitions []int) (map[int]int64, error) {
+	topic := c.config.Name
+	resp, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: c.readerConfig.GroupID,
+		Topics:  map[string][]int{topic: partitions},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch committed offsets for topic %s: %w", topic, err)
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("failed to fetch committed offsets for topic %s: %w", topic, resp.Error)
+	}
+	committed := make(map[int]int64, len(partitions))
+	for _, p := range partitions {
+		committed[p] = -1
+	}
+	for _, p := range resp.Topics[topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("failed to fetch committed offset for topic %s, partition %d: %w", topic, p.Partition, p.Error)
+		}
+		committed[p.Partition] = p.CommittedOffset
+	}
+	return committed, nil
+}
+
+// listOffsets lists an offset of every partition of the topic, e.g. kafka.LastOffsetOf.
+func listOffsets(ctx context.Context, client *kafka.Client, topic string, partitions []int, request func(partition int) kafka.OffsetRequest) (map[int]kafka.PartitionOffsets, error) {
+	requests := make([]kafka.OffsetRequest, 0, len(partitions))
+	for _, p := range partitions {
+		requests = append(requests, request(p))
+	}
+	resp, err := client.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: map[string][]kafka.OffsetRequest{topic: requests}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list offsets for topic %s: %w", topic, err)
+	}
+	byPartition := make(map[int]kafka.PartitionOffsets, len(partitions))
+	for _, p := range resp.Topics[topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("failed to list offsets for topic %s, partition %d: %w", topic, p.Partition, p.Error)
+		}
+		byPartition[p.Partition] = p
+	}
+	return byPartition, nil
+}
@@ -0,0 +1,192 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Targets for ResetOffsets.
+const (
+	ResetEarliest  = "earliest"
+	ResetLatest    = "latest"
+	ResetTimestamp = "timestamp"
+)
+
+// PartitionOffset is the offset a partition of the consumer group was reset to.
+type PartitionOffset struct {
+	Partition int   `json:"partition"`
+	Offset    int64 `json:"offset"`
+}
+
+// ResetOffsets moves the consumer group's committed offsets for this topic to the earliest or
+// latest offset, or to the first message at or after the given time. The consumer is paused
+// (leaving the group) while the offsets are committed and resumed afterwards, so it continues
+// from the new position. Other members of the group must be stopped for the commit to succeed.
+func (c *Consumer) ResetOffsets(ctx context.Context, to string, at time.Time) ([]PartitionOffset, error) {
+	switch to {
+	case ResetEarliest, ResetLatest:
+	case ResetTimestamp:
+		if at.IsZero() {
+			return nil, fmt.Errorf("a timestamp is required to reset offsets to a timestamp")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported offset reset target %q (use earliest, latest or timestamp)", to)
+	}
+
+	if err := c.Pause(); err != nil {
+		log.Printf("Error closing reader of topic %s before offset reset: %v", c.config.Name, err)
+	}
+	defer c.Resume()
+
+	client := c.client()
+	offsets, err := c.targetOffsets(ctx, client, to, at)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.commitOffsets(ctx, client, offsets); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Reset offsets of group %s for topic %s to %s: %v", c.readerConfig.GroupID, c.config.Name, to, offsets)
+	return offsets, nil
+}
+
+// ApplyStartOffset positions the consumer group before the topic is consumed. With replayFrom
+// set, every partition is reset to the first message at or after it, so the topic's history
+// is indexed again. Otherwise, for start_offset timestamp, partitions the group has no
+// committed offset for start at start_timestamp; earliest and latest are applied by the reader.
+// kafka-go readers in a consumer group cannot seek, so the position is committed as the
+// group's offset, which requires the other members of the group to be stopped.
+func (c *Consumer) ApplyStartOffset(ctx context.Context, replayFrom time.Time) error {
+	if !replayFrom.IsZero() {
+		_, err := c.ResetOffsets(ctx, ResetTimestamp, replayFrom)
+		return err
+	}
+	if c.config.StartOffset != ResetTimestamp {
+		return nil
+	}
+
+	client := c.client()
+	uncommitted, err := c.uncommittedPartitions(ctx, client)
+	if err != nil || len(uncommitted) == 0 {
+		return err
+	}
+
+	if err := c.Pause(); err != nil {
+		log.Printf("Error closing reader of topic %s before positioning it: %v", c.config.Name, err)
+	}
+	defer c.Resume()
+
+	offsets, err := c.targetOffsets(ctx, client, ResetTimestamp, c.config.StartTimestamp)
+	if err != nil {
+		return err
+	}
+	var start []PartitionOffset
+	for _, o := range offsets {
+		if uncommitted[o.Partition] {
+			start = append(start, o)
+		}
+	}
+	if err := c.commitOffsets(ctx, client, start); err != nil {
+		return err
+	}
+	log.Printf("Topic %s starts at %s on partitions without committed offsets: %v", c.config.Name, c.config.StartTimestamp.Format(time.RFC3339), start)
+	return nil
+}
+
+// uncommittedPartitions returns the partitions of the topic the consumer group has not
+// committed an offset for.
+func (c *Consumer) uncommittedPartitions(ctx context.Context, client *kafka.Client) (map[int]bool, error) {
+	partitions, err := topicPartitionIDs(ctx, client, c.config.Name)
+	if err != nil {
+		return nil, err
+	}
+	committed, err := c.committedOffsets(ctx, client, partitions)
+	if err != nil {
+		return nil, err
+	}
+	uncommitted := make(map[int]bool)
+	for p, offset := range committed {
+		if offset < 0 {
+			uncommitted[p] = true
+		}
+	}
+	return uncommitted, nil
+}
+
+// commitOffsets commits the offsets for the consumer group.
+func (c *Consumer) commitOffsets(ctx context.Context, client *kafka.Client, offsets []PartitionOffset) error {
+	topic := c.config.Name
+	commits := make([]kafka.OffsetCommit, 0, len(offsets))
+	for _, o := range offsets {
+		commits = append(commits, kafka.OffsetCommit{Partition: o.Partition, Offset: o.Offset})
+	}
+	// Generation -1 without a member ID commits as an administrative client, which the
+	// coordinator accepts only while the group has no active members.
+	resp, err := client.OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+		GroupID:      c.readerConfig.GroupID,
+		GenerationID: -1,
+		Topics:       map[string][]kafka.OffsetCommit{topic: commits},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to commit offsets for topic %s: %w", topic, err)
+	}
+	for _, p := range resp.Topics[topic] {
+		if p.Error != nil {
+			return fmt.Errorf("failed to commit offset for topic %s, partition %d: %w", topic, p.Partition, p.Error)
+		}
+	}
+	return nil
+}
+
+// targetOffsets resolves the reset target to an offset for every partition of the topic.
+func (c *Consumer) targetOffsets(ctx context.Context, client *kafka.Client, to string, at time.Time) ([]PartitionOffset, error) {
+	topic := c.config.Name
+	partitions, err := topicPartitionIDs(ctx, client, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	list := func(request func(partition int) kafka.OffsetRequest) (map[int]kafka.PartitionOffsets, error) {
+		return listOffsets(ctx, client, topic, partitions, request)
+	}
+
+	var result []PartitionOffset
+	switch to {
+	case ResetEarliest:
+		first, err := list(kafka.FirstOffsetOf)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range partitions {
+			result = append(result, PartitionOffset{Partition: p, Offset: first[p].FirstOffset})
+		}
+	case ResetLatest, ResetTimestamp:
+		last, err := list(kafka.LastOffsetOf)
+		if err != nil {
+			return nil, err
+		}
+		var byTime map[int]kafka.PartitionOffsets
+		if to == ResetTimestamp {
+			byTime, err = list(func(p int) kafka.OffsetRequest { return kafka.TimeOffsetOf(p, at) })
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, p := range partitions {
+			offset := last[p].LastOffset
+			// Partitions with no message at or after the timestamp start from the end
+			for o := range byTime[p].Offsets {
+				if o >= 0 {
+					offset = o
+				}
+			}
+			result = append(result, PartitionOffset{Partition: p, Offset: offset})
+		}
+	}
+	return result, nil
+}
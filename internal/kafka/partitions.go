@@ -0,0 +1,89 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"stream-rag-agent/internal/metrics"
+)
+
+// partitionDiscoveryInterval is how often topics are checked for added partitions.
+const partitionDiscoveryInterval = time.Minute
+
+var topicPartitions = metrics.NewGauge("kafka_topic_partitions", "Partitions discovered per consumed topic.")
+
+// Partitions returns the partition IDs of the topic, read from the cluster metadata.
+func (c *Consumer) Partitions(ctx context.Context) ([]int, error) {
+	client := c.client()
+	return topicPartitionIDs(ctx, client, c.config.Name)
+}
+
+func topicPartitionIDs(ctx context.Context, client *kafka.Client, topic string) ([]int, error) {
+	meta, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch metadata for topic %s: %w", topic, err)
+	}
+	var partitions []int
+	for _, t := range meta.Topics {
+		if t.Name != topic {
+			continue
+		}
+		if t.Error != nil {
+			return nil, fmt.Errorf("failed to fetch metadata for topic %s: %w", topic, t.Error)
+		}
+		for _, p := range t.Partitions {
+			partitions = append(partitions, p.ID)
+		}
+	}
+	if len(partitions) == 0 {
+		return nil, fmt.Errorf("topic %s has no partitions", topic)
+	}
+	sort.Ints(partitions)
+	return partitions, nil
+}
+
+// DiscoverPartitions calls onPartition for every partition of the topic, then keeps checking
+// the metadata and calls it for partitions added later, until ctx is cancelled. The consumer
+// group reader is assigned all partitions of the topic (including added ones after the next
+// rebalance); discovery lets the agent open a window per partition up front instead of when
+// its first message arrives.
+func (c *Consumer) DiscoverPartitions(ctx context.Context, onPartition func(partition int32)) {
+	known := make(map[int]bool)
+	discover := func() {
+		partitions, err := c.Partitions(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Partition discovery for topic %s failed: %v", c.config.Name, err)
+			}
+			return
+		}
+		for _, p := range partitions {
+			if known[p] {
+				continue
+			}
+			if len(known) > 0 {
+				log.Printf("Discovered new partition %d of topic %s", p, c.config.Name)
+			}
+			known[p] = true
+			onPartition(int32(p))
+		}
+		topicPartitions.Set(float64(len(known)), "topic", c.config.Name)
+	}
+
+	discover()
+	log.Printf("Topic %s has %d partitions", c.config.Name, len(known))
+	ticker := time.NewTicker(partitionDiscoveryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			discover()
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/metrics"
+	"stream-rag-agent/internal/outbox"
+	"stream-rag-agent/internal/window"
+)
+
+const defaultSummaryChars = 500
+
+var summariesPublished = metrics.NewCounter("window_summaries_published_total", "Window summary events written to the output topic, by source topic and result (ok, outboxed, error).")
+
+// WindowSummary is the event published for every processed window.
+type WindowSummary struct {
+	WindowID       string            `json:"window_id"`
+	Topic          string            `json:"topic"`
+	Partition      int32             `json:"partition"`
+	Shard          *int              `json:"shard,omitempty"`
+	FirstOffset    *int64            `json:"first_offset,omitempty"`
+	LastOffset     *int64            `json:"last_offset,omitempty"`
+	StartTime      time.Time         `json:"start_time"`
+	EndTime        time.Time         `json:"end_time"`
+	MessageCount   int               `json:"message_count"`
+	Category       string            `json:"category,omitempty"`
+	CloseReason    string            `json:"close_reason,omitempty"`
+	Quality        string            `json:"quality,omitempty"` // Why the window is partial or degraded, empty if complete
+	Keys           *SummaryKeyStats  `json:"keys,omitempty"`
+	Annotations    map[string]string `json:"annotations,omitempty"`
+	EmbeddingModel string            `json:"embedding_model,omitempty"`
+	Summary        string            `json:"summary,omitempty"` // Beginning of the rendered window text
+	ProcessedAt    time.Time         `json:"processed_at"`
+}
+
+type SummaryKeyStats struct {
+	Field    string            `json:"field,omitempty"` // Empty for the Kafka message key
+	Distinct int               `json:"distinct"`
+	Top      []SummaryKeyCount `json:"top,omitempty"`
+}
+
+type SummaryKeyCount struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// Publisher writes window summaries to the output topic. Events are keyed by window ID, so a
+// window that is published again (after a retry or reprocessing) replaces its earlier event
+// in a compacted topic and can be deduplicated by consumers. Events are written
+// synchronously; those the topic does not accept are kept in the outbox, which publishes
+// them again, so delivery is at-least-once.
+type Publisher struct {
+	writer       *kafka.Writer
+	outbox       *outbox.Outbox // nil when the local outbox is disabled
+	summaryChars int
+}
+
+// NewPublisher creates the publisher, nil when the output is disabled. Events that cannot be
+// published are stored in ob, if not nil.
+func NewPublisher(cfg config.KafkaOutputConfig, cluster config.KafkaClusterConfig, ob *outbox.Outbox) (*Publisher, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.Topic == "" {
+		return nil, fmt.Errorf("kafka.output.topic is required")
+	}
+	sec, err := newSecurity(cluster)
+	if err != nil {
+		return nil, err
+	}
+	summaryChars := cfg.SummaryChars
+	if summaryChars == 0 {
+		summaryChars = defaultSummaryChars
+	}
+	p := &Publisher{outbox: ob, summaryChars: summaryChars}
+	p.writer = &kafka.Writer{
+		Addr:         kafka.TCP(cluster.Brokers...),
+		Transport:    sec.transport(),
+		Topic:        cfg.Topic,
+		Balancer:     &kafka.Hash{}, // All events of a window ID land on one partition
+		RequiredAcks: kafka.RequireAll,
+		BatchTimeout: 50 * time.Millisecond,
+	}
+	log.Printf("Publishing window summaries to topic %s on cluster '%s'", cfg.Topic, cluster.Name)
+	return p, nil
+}
+
+// Publish writes the summary of a processed window to the output topic and waits for the
+// brokers to acknowledge it. When they do not, the event is stored in the outbox; an error
+// is returned only if that fails too, or there is no outbox, and the event is lost.
+func (p *Publisher) Publish(ew *window.EmbeddedWindow, keyStats *window.KeyStats) error {
+	if p == nil {
+		return nil
+	}
+	value, err := json.Marshal(p.summarize(ew, keyStats))
+	if err != nil {
+		return fmt.Errorf("failed to marshal summary of window %s: %w", ew.WindowID, err)
+	}
+	event := &outbox.Event{Key: []byte(ew.WindowID), Value: value, Headers: map[string]string{"source_topic": ew.Topic}}
+	err = p.PublishEvent(event)
+	if err == nil {
+		return nil
+	}
+	if p.outbox == nil {
+		summariesPublished.Inc("topic", ew.Topic, "result", "error")
+		return fmt.Errorf("failed to publish summary of window %s: %w", ew.WindowID, err)
+	}
+	log.Printf("Failed to publish summary of window %s, storing it in the outbox: %v", ew.WindowID, err)
+	if outboxErr := p.outbox.PutEvent(ew.WindowID, event); outboxErr != nil {
+		summariesPublished.Inc("topic", ew.Topic, "result", "error")
+		return fmt.Errorf("failed to publish summary of window %s (%v) and to store it in the outbox: %w", ew.WindowID, err, outboxErr)
+	}
+	summariesPublished.Inc("topic", ew.Topic, "result", "outboxed")
+	return nil
+}
+
+// PublishEvent writes an event to the output topic and waits for the brokers to
+// acknowledge it. It is also used to publish the events kept in the outbox.
+func (p *Publisher) PublishEvent(e *outbox.Event) error {
+	msg := kafka.Message{Key: e.Key, Value: e.Value}
+	for k, v := range e.Headers {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+	if err := p.writer.WriteMessages(context.Background(), msg); err != nil {
+		return err
+	}
+	summariesPublished.Inc("topic", e.Headers["source_topic"], "result", "ok")
+	return nil
+}
+
+func (p *Publisher) summarize(ew *window.EmbeddedWindow, keyStats *window.KeyStats) WindowSummary {
+	summary := WindowSummary{
+		WindowID:       ew.WindowID,
+		Topic:          ew.Topic,
+		Partition:      ew.Partition,
+		Shard:          ew.Shard,
+		FirstOffset:    ew.FirstOffset,
+		LastOffset:     ew.LastOffset,
+		StartTime:      ew.StartTime,
+		EndTime:        ew.EndTime,
+		MessageCount:   ew.MessageCount,
+		Category:       ew.Category,
+		CloseReason:    ew.CloseReason,
+		Quality:        ew.QualityNotes(),
+		Annotations:    ew.Annotations,
+		EmbeddingModel: ew.EmbeddingModel,
+		ProcessedAt:    time.Now().UTC(),
+	}
+	if keyStats != nil {
+		summary.Keys = &SummaryKeyStats{Field: keyStats.Field, Distinct: keyStats.Distinct}
+		for _, kc := range keyStats.Top {
+			summary.Keys.Top = append(summary.Keys.Top, SummaryKeyCount{Key: kc.Key, Count: kc.Count})
+		}
+	}
+	if p.summaryChars > 0 {
+		text := []rune(ew.ContextText)
+		if len(text) > p.summaryChars {
+			text = append(text[:p.summaryChars], '…')
+		}
+		summary.Summary = string(text)
+	}
+	return summary
+}
+
+// Close closes the writer.
+func (p *Publisher) Close() error {
+	if p == nil {
+		return nil
+	}
+	return p.writer.Close()
+}
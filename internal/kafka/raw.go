@@ -0,0 +1,124 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"stream-rag-agent/internal/codec"
+	"stream-rag-agent/pkg/windowing"
+)
+
+// rawReadTimeout bounds a range read; compacted topics may not hold messages up to the end
+// of the requested range, in which case the reader would otherwise wait for new messages.
+const rawReadTimeout = 10 * time.Second
+
+// RawRange is the result of reading an offset range of one partition.
+type RawRange struct {
+	Messages  []windowing.Message
+	Truncated bool  // The range was cut short by the message or size limit
+	NextFrom  int64 // Offset to continue from when truncated
+}
+
+// ReadRange reads the messages of a partition with offsets in [from, to] directly from the
+// brokers, outside the consumer group, so committed offsets are not affected. Offsets past the
+// end of the partition are ignored, and at most maxMessages messages or maxBytes of payload are
+// returned. Payloads are decoded and decompressed like consumed messages.
+func (c *Consumer) ReadRange(ctx context.Context, partition int, from, to int64, maxMessages, maxBytes int) (*RawRange, error) {
+	if from < 0 || to < from {
+		return nil, fmt.Errorf("invalid offset range [%d, %d]", from, to)
+	}
+	topic := c.config.Name
+	client := c.client()
+	resp, err := client.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: map[string][]kafka.OffsetRequest{
+		topic: {kafka.FirstOffsetOf(partition), kafka.LastOffsetOf(partition)},
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list offsets for topic %s: %w", topic, err)
+	}
+	var first, last int64 = -1, -1
+	for _, p := range resp.Topics[topic] {
+		if p.Partition != partition {
+			continue
+		}
+		if p.Error != nil {
+			return nil, fmt.Errorf("failed to list offsets for topic %s, partition %d: %w", topic, partition, p.Error)
+		}
+		if p.FirstOffset >= 0 {
+			first = p.FirstOffset
+		}
+		if p.LastOffset >= 0 {
+			last = p.LastOffset
+		}
+	}
+	if first < 0 || last < 0 {
+		return nil, fmt.Errorf("partition %d of topic %s not found", partition, topic)
+	}
+	// LastOffset is the offset the next message will get
+	to = min(to, last-1)
+	from = max(from, first)
+	result := &RawRange{}
+	if from > to {
+		return result, nil
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   c.readerConfig.Brokers,
+		Dialer:    c.readerConfig.Dialer,
+		Topic:     topic,
+		Partition: partition,
+		MinBytes:  1,
+		MaxBytes:  max(maxBytes, 1e6),
+		MaxWait:   500 * time.Millisecond,
+	})
+	defer reader.Close()
+	if err := reader.SetOffset(from); err != nil {
+		return nil, fmt.Errorf("failed to seek topic %s, partition %d to offset %d: %w", topic, partition, from, err)
+	}
+
+	readCtx, cancel := context.WithTimeout(ctx, rawReadTimeout)
+	defer cancel()
+	size := 0
+	for {
+		msg, err := reader.ReadMessage(readCtx)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+				break
+			}
+			return nil, fmt.Errorf("failed to read topic %s, partition %d: %w", topic, partition, err)
+		}
+		if msg.Offset > to {
+			break
+		}
+		if len(result.Messages) == maxMessages || (size > 0 && size+len(msg.Value) > maxBytes) {
+			result.Truncated = true
+			result.NextFrom = msg.Offset
+			break
+		}
+		value := c.decodeValue(msg)
+		if c.config.PayloadCompression != "" && value != nil {
+			if decompressed, err := codec.Decompress(value, c.config.PayloadCompression, c.config.MaxDecompressedBytes); err != nil {
+				log.Printf("Warning: Could not decompress message (Offset: %d) on topic %s: %v. Using raw payload.", msg.Offset, topic, err)
+			} else {
+				value = decompressed
+			}
+		}
+		size += len(value)
+		result.Messages = append(result.Messages, windowing.Message{
+			Topic:     msg.Topic,
+			Partition: int32(msg.Partition),
+			Offset:    msg.Offset,
+			Key:       msg.Key,
+			Value:     value,
+			Timestamp: msg.Time,
+			Headers:   messageHeaders(msg),
+		})
+		if msg.Offset == to {
+			break
+		}
+	}
+	return result, nil
+}
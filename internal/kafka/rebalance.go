@@ -0,0 +1,313 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"stream-rag-agent/internal/metrics"
+)
+
+// revokeTimeout bounds how long a rebalance waits for the windows of revoked partitions to be
+// flushed, leaving the rest of rebalanceTimeout to rejoin the group.
+const revokeTimeout = 45 * time.Second
+
+var (
+	partitionsRevokedTotal = metrics.NewCounter("kafka_partitions_revoked_total", "Partitions the consumer group revoked from this agent, by topic.")
+	revokeFlushSeconds     = metrics.NewGauge("kafka_rebalance_flush_seconds", "How long the windows of the partitions revoked in the latest rebalance took to flush, by topic.")
+)
+
+// RebalanceListener is told which partitions of a topic the consumer group assigns to the
+// consumer and revokes from it, e.g. a *window.Manager.
+type RebalanceListener interface {
+	PartitionsAssigned(partitions []int32)
+	// PartitionsRevoked is called before the partitions are given up, so the rebalance
+	// waits for it to return (up to revokeTimeout).
+	PartitionsRevoked(partitions []int32)
+}
+
+// SetRebalanceListener makes the consumer tell l about the partitions the group assigns to
+// it and revokes from it.
+func (c *Consumer) SetRebalanceListener(l RebalanceListener) {
+	c.rebalance.mu.Lock()
+	defer c.rebalance.mu.Unlock()
+	c.rebalance.listener = l
+}
+
+// rebalanceNotifier passes the rebalances of a consumer's group readers on to the listener
+// set with SetRebalanceListener, which may be set after the reader joined the group.
+type rebalanceNotifier struct {
+	topic    string
+	mu       sync.Mutex
+	listener RebalanceListener // nil until set
+}
+
+func (n *rebalanceNotifier) current() RebalanceListener {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.listener
+}
+
+func (n *rebalanceNotifier) assigned(partitions []int) {
+	if l := n.current(); l != nil && len(partitions) > 0 {
+		l.PartitionsAssigned(partitionIDs(partitions))
+	}
+}
+
+// revoked tells the listener about revoked partitions and waits until it returns or
+// revokeTimeout elapses, after which the partitions are given up anyway.
+func (n *rebalanceNotifier) revoked(partitions []int) {
+	if len(partitions) == 0 {
+		return
+	}
+	partitionsRevokedTotal.Add(float64(len(partitions)), "topic", n.topic)
+	l := n.current()
+	if l == nil {
+		return
+	}
+	log.Printf("Partitions %v of topic %s were revoked, flushing their windows", partitions, n.topic)
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.PartitionsRevoked(partitionIDs(partitions))
+	}()
+	select {
+	case <-done:
+		revokeFlushSeconds.Set(time.Since(start).Seconds(), "topic", n.topic)
+	case <-time.After(revokeTimeout):
+		revokeFlushSeconds.Set(revokeTimeout.Seconds(), "topic", n.topic)
+		log.Printf("Warning: Windows of revoked partitions %v of topic %s are still being flushed after %s, giving the partitions up", partitions, n.topic, revokeTimeout)
+	}
+}
+
+func partitionIDs(partitions []int) []int32 {
+	ids := make([]int32, len(partitions))
+	for i, p := range partitions {
+		ids[i] = int32(p)
+	}
+	return ids
+}
+
+// groupMember is a dynamic consumer group member built on kafka-go's consumer group API,
+// which, unlike a *kafka.Reader, tells the member when its generation ends. Each generation
+// reads the assigned partitions with one partition reader each, and when the group rebalances
+// the revoked partitions are reported before the member rejoins.
+type groupMember struct {
+	group        *kafka.ConsumerGroup
+	groupID      string
+	topic        string
+	partitionCfg kafka.ReaderConfig // Template of the partition readers
+	rebalance    *rebalanceNotifier
+
+	messages chan memberMessage
+	ctx      context.Context // Cancelled by Close
+	cancel   context.CancelFunc
+	done     chan struct{} // Closed when run returns
+
+	mu         sync.Mutex
+	generation *kafka.Generation // nil until the group is joined
+	revoked    bool              // The current generation's partitions were revoked
+	err        error             // Why the member stopped
+}
+
+// groupConfig returns the consumer group config of a reader config.
+func groupConfig(cfg kafka.ReaderConfig) kafka.ConsumerGroupConfig {
+	return kafka.ConsumerGroupConfig{
+		ID:               cfg.GroupID,
+		Brokers:          cfg.Brokers,
+		Dialer:           cfg.Dialer,
+		Topics:           []string{cfg.Topic},
+		SessionTimeout:   cfg.SessionTimeout,
+		RebalanceTimeout: rebalanceTimeout,
+		StartOffset:      cfg.StartOffset,
+	}
+}
+
+func newGroupMember(cfg kafka.ReaderConfig, rebalance *rebalanceNotifier) *groupMember {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &groupMember{
+		groupID:      cfg.GroupID,
+		topic:        cfg.Topic,
+		partitionCfg: partitionReaderConfig(cfg),
+		rebalance:    rebalance,
+		messages:     make(chan memberMessage, staticMemberBuffer),
+		ctx:          ctx,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+	}
+	group, err := kafka.NewConsumerGroup(groupConfig(cfg))
+	if err != nil {
+		// The config was validated by NewConsumer
+		m.err = err
+		cancel()
+		close(m.done)
+		return m
+	}
+	m.group = group
+	go m.run()
+	return m
+}
+
+// run reads the partitions of each generation until Close.
+func (m *groupMember) run() {
+	defer close(m.done)
+	for {
+		gen, err := m.group.Next(m.ctx)
+		if err != nil {
+			if m.ctx.Err() != nil || errors.Is(err, kafka.ErrGroupClosed) {
+				return
+			}
+			// The group backs off before joining again
+			log.Printf("Consumer group %s of topic %s lost its generation, rejoining: %v", m.groupID, m.topic, err)
+			continue
+		}
+		m.startGeneration(gen)
+	}
+}
+
+// startGeneration reads the partitions assigned in the generation until it ends, then reports
+// them as revoked.
+func (m *groupMember) startGeneration(gen *kafka.Generation) {
+	assignments := gen.Assignments[m.topic]
+	partitions := make([]int, len(assignments))
+	for i, a := range assignments {
+		partitions[i] = a.ID
+	}
+	sort.Ints(partitions)
+
+	m.mu.Lock()
+	m.generation, m.revoked = gen, false
+	m.mu.Unlock()
+	log.Printf("Member %s of group %s reads partitions %v of topic %s in generation %d", gen.MemberID, gen.GroupID, partitions, m.topic, gen.ID)
+	m.rebalance.assigned(partitions)
+
+	// A partition that cannot be read ends the generation, which rejoins the group
+	var readers sync.WaitGroup
+	for _, a := range assignments {
+		readers.Add(1)
+		gen.Start(func(ctx context.Context) {
+			defer readers.Done()
+			if err := m.readPartition(ctx, a, int(gen.ID)); err != nil {
+				log.Printf("Failed to read partition %d of topic %s, rejoining group %s: %v", a.ID, m.topic, gen.GroupID, err)
+			}
+		})
+	}
+	// The group joins the next generation once this returns
+	gen.Start(func(ctx context.Context) {
+		select {
+		case <-ctx.Done():
+		case <-m.ctx.Done():
+		}
+		m.mu.Lock()
+		m.revoked = true // Buffered messages are read again by the partitions' next owner
+		m.mu.Unlock()
+		readers.Wait()
+		if m.ctx.Err() != nil {
+			return // Closed; windows are flushed by the shutdown
+		}
+		m.rebalance.revoked(partitions)
+	})
+}
+
+// readPartition reads an assigned partition from its committed offset until ctx is cancelled,
+// returning nil, or reading fails.
+func (m *groupMember) readPartition(ctx context.Context, a kafka.PartitionAssignment, generation int) error {
+	cfg := m.partitionCfg
+	cfg.Partition = a.ID
+	reader := kafka.NewReader(cfg)
+	defer reader.Close()
+	if err := reader.SetOffset(a.Offset); err != nil {
+		return err
+	}
+	return forwardPartition(ctx, reader, generation, m.messages)
+}
+
+// FetchMessage returns the next message of the member's partitions. Messages read before the
+// latest rebalance are skipped: the partition is read again from its committed offset by
+// whichever member it is assigned to now.
+func (m *groupMember) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return kafka.Message{}, ctx.Err()
+		case <-m.ctx.Done():
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if m.err != nil {
+				return kafka.Message{}, m.err
+			}
+			return kafka.Message{}, errors.New("consumer group member is closed")
+		case msg := <-m.messages:
+			m.mu.Lock()
+			current := m.generation != nil && int(m.generation.ID) == msg.generation && !m.revoked
+			m.mu.Unlock()
+			if current {
+				return msg.Message, nil
+			}
+		}
+	}
+}
+
+// CommitMessages commits the offsets following the messages in the current generation.
+func (m *groupMember) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	m.mu.Lock()
+	gen := m.generation
+	m.mu.Unlock()
+	if gen == nil {
+		return errors.New("consumer group member has not joined the group")
+	}
+	next := make(map[int]int64)
+	for _, msg := range msgs {
+		if msg.Offset+1 > next[msg.Partition] {
+			next[msg.Partition] = msg.Offset + 1
+		}
+	}
+	return gen.CommitOffsets(map[string]map[int]int64{m.topic: next})
+}
+
+// Close stops reading and leaves the group.
+func (m *groupMember) Close() error {
+	m.cancel()
+	var err error
+	if m.group != nil {
+		err = m.group.Close()
+	}
+	<-m.done
+	return err
+}
+
+// partitionReaderConfig returns the template of a group member's partition readers.
+func partitionReaderConfig(cfg kafka.ReaderConfig) kafka.ReaderConfig {
+	return kafka.ReaderConfig{
+		Brokers:  cfg.Brokers,
+		Topic:    cfg.Topic,
+		Dialer:   cfg.Dialer,
+		MinBytes: cfg.MinBytes,
+		MaxBytes: cfg.MaxBytes,
+		MaxWait:  cfg.MaxWait,
+	}
+}
+
+// forwardPartition passes the messages of a partition reader on until ctx is cancelled,
+// returning nil, or reading fails.
+func forwardPartition(ctx context.Context, reader *kafka.Reader, generation int, messages chan<- memberMessage) error {
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		select {
+		case messages <- memberMessage{Message: msg, generation: generation}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
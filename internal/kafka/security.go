@@ -0,0 +1,108 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"stream-rag-agent/internal/config"
+)
+
+const dialTimeout = 10 * time.Second
+
+// Supported SASL mechanisms.
+const (
+	SASLPlain       = "plain"
+	SASLScramSHA256 = "scram-sha-256"
+	SASLScramSHA512 = "scram-sha-512"
+)
+
+// security holds the TLS and SASL settings of a cluster, shared by its readers, writers and
+// admin clients. The zero value connects in plain text without authentication.
+type security struct {
+	tls  *tls.Config
+	sasl sasl.Mechanism
+}
+
+func newSecurity(cluster config.KafkaClusterConfig) (security, error) {
+	var s security
+	if cluster.TLS.Enabled {
+		tlsConfig, err := tlsConfig(cluster.TLS)
+		if err != nil {
+			return s, fmt.Errorf("kafka cluster '%s': %w", cluster.Name, err)
+		}
+		s.tls = tlsConfig
+	}
+	if cluster.SASL.Mechanism != "" {
+		mechanism, err := saslMechanism(cluster.SASL)
+		if err != nil {
+			return s, fmt.Errorf("kafka cluster '%s': %w", cluster.Name, err)
+		}
+		s.sasl = mechanism
+	}
+	return s, nil
+}
+
+func tlsConfig(cfg config.KafkaTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in TLS CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func saslMechanism(cfg config.KafkaSASLConfig) (sasl.Mechanism, error) {
+	switch strings.ToLower(cfg.Mechanism) {
+	case SASLPlain:
+		return plain.Mechanism{Username: cfg.Username, Password: cfg.Password}, nil
+	case SASLScramSHA256:
+		return scram.Mechanism(scram.SHA256, cfg.Username, cfg.Password)
+	case SASLScramSHA512:
+		return scram.Mechanism(scram.SHA512, cfg.Username, cfg.Password)
+	default:
+		return nil, fmt.Errorf("unsupported SASL mechanism %q (use plain, scram-sha-256 or scram-sha-512)", cfg.Mechanism)
+	}
+}
+
+// dialer returns the dialer of consumer group readers, nil for plain unauthenticated
+// connections so the reader uses its default.
+func (s security) dialer() *kafka.Dialer {
+	if s.tls == nil && s.sasl == nil {
+		return nil
+	}
+	return &kafka.Dialer{Timeout: dialTimeout, DualStack: true, TLS: s.tls, SASLMechanism: s.sasl}
+}
+
+// transport returns the transport of writers and admin clients, nil for the default.
+func (s security) transport() kafka.RoundTripper {
+	if s.tls == nil && s.sasl == nil {
+		return nil
+	}
+	return &kafka.Transport{DialTimeout: dialTimeout, TLS: s.tls, SASL: s.sasl}
+}
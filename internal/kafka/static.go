@@ -0,0 +1,424 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"stream-rag-agent/internal/metrics"
+)
+
+const (
+	defaultStaticSessionTimeout = 5 * time.Minute // Long enough for a restart to rejoin without a rebalance
+	rebalanceTimeout            = time.Minute
+	heartbeatInterval           = 3 * time.Second
+	groupRequestTimeout         = 10 * time.Second // Of requests other than JoinGroup, which waits for the rebalance
+	rejoinBackoff               = 5 * time.Second
+	staticMemberBuffer          = 1024 // Messages fetched from the assigned partitions and not yet returned
+	consumerProtocolType        = "consumer"
+	rangeProtocol               = "range"
+)
+
+var staticRejoinsTotal = metrics.NewCounter("kafka_static_member_joins_total", "Joins of a topic's static consumer group member, by topic and reason (start, rebalance or error).")
+
+// groupReader reads a topic as a member of its consumer group. It is a *groupMember, or a
+// *staticMember for topics with a group_instance_id.
+type groupReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// staticMember is a consumer group member with a group.instance.id (static membership,
+// KIP-345), which kafka-go readers cannot join as. The coordinator keeps the partitions of a
+// static member assigned to it until its session times out instead of rebalancing when it
+// leaves, so a restarted agent resumes its partitions without a rebalance of the group. It
+// joins the group, assigns partitions as the group leader, reads its partitions with one
+// partition reader each and heartbeats until the next rebalance, then rejoins.
+type staticMember struct {
+	client         *kafka.Client
+	group          string
+	instanceID     string
+	topic          string
+	sessionTimeout time.Duration
+	partitionCfg   kafka.ReaderConfig // Template of the partition readers
+	startOffset    int64              // For partitions the group has no committed offset for
+	rebalance      *rebalanceNotifier
+
+	messages chan memberMessage
+	ctx      context.Context // Cancelled by Close
+	cancel   context.CancelFunc
+	done     chan struct{} // Closed when run returns
+
+	mu         sync.Mutex
+	memberID   string
+	generation int
+	revoked    bool  // The current generation's partitions were revoked
+	err        error // Why the member stopped, e.g. it was fenced by another instance
+}
+
+type memberMessage struct {
+	kafka.Message
+	generation int
+}
+
+func newStaticMember(cfg kafka.ReaderConfig, instanceID string, client *kafka.Client, rebalance *rebalanceNotifier) *staticMember {
+	ctx, cancel := context.WithCancel(context.Background())
+	sessionTimeout := cfg.SessionTimeout
+	if sessionTimeout <= 0 {
+		sessionTimeout = defaultStaticSessionTimeout
+	}
+	m := &staticMember{
+		client:         client,
+		group:          cfg.GroupID,
+		instanceID:     instanceID,
+		topic:          cfg.Topic,
+		sessionTimeout: sessionTimeout,
+		partitionCfg:   partitionReaderConfig(cfg),
+		startOffset:    cfg.StartOffset,
+		rebalance:      rebalance,
+		messages:       make(chan memberMessage, staticMemberBuffer),
+		ctx:            ctx,
+		cancel:         cancel,
+		done:           make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+// run keeps the member in the group until Close, rejoining after every rebalance.
+func (m *staticMember) run() {
+	defer close(m.done)
+	reason := "start"
+	for m.ctx.Err() == nil {
+		staticRejoinsTotal.Inc("topic", m.topic, "reason", reason)
+		err := m.session()
+		if m.ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, kafka.FencedInstanceID) {
+			m.mu.Lock()
+			m.err = fmt.Errorf("static member %s of group %s was fenced, another instance uses the same group_instance_id: %w", m.instanceID, m.group, err)
+			m.mu.Unlock()
+			log.Printf("Topic %s stops consuming: %v", m.topic, m.err)
+			m.cancel()
+			return
+		}
+		if errors.Is(err, kafka.RebalanceInProgress) {
+			reason = "rebalance"
+			log.Printf("Group %s is rebalancing, static member %s of topic %s rejoins", m.group, m.instanceID, m.topic)
+			continue
+		}
+		reason = "error"
+		log.Printf("Static member %s of topic %s lost its group session, rejoining in %s: %v", m.instanceID, m.topic, rejoinBackoff, err)
+		select {
+		case <-m.ctx.Done():
+		case <-time.After(rejoinBackoff):
+		}
+	}
+}
+
+// session joins the group, reads the assigned partitions and heartbeats until the member has
+// to rejoin, which the returned error tells why. The partitions are reported as revoked before
+// it returns, unless the member was closed.
+func (m *staticMember) session() error {
+	generation, partitions, err := m.join()
+	if err != nil {
+		return err
+	}
+	log.Printf("Static member %s of group %s reads partitions %v of topic %s in generation %d", m.instanceID, m.group, partitions, m.topic, generation)
+	m.rebalance.assigned(partitions)
+
+	ctx, cancel := context.WithCancelCause(m.ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel(nil)
+		m.mu.Lock()
+		m.revoked = true // Buffered messages are read again by the partitions' next owner
+		m.mu.Unlock()
+		wg.Wait()
+		if m.ctx.Err() == nil {
+			m.rebalance.revoked(partitions)
+		}
+	}()
+	offsets, err := m.startOffsets(ctx, partitions)
+	if err != nil {
+		return err
+	}
+	for _, p := range partitions {
+		cfg := m.partitionCfg
+		cfg.Partition = p
+		reader := kafka.NewReader(cfg)
+		if err := reader.SetOffset(offsets[p]); err != nil {
+			reader.Close()
+			return fmt.Errorf("failed to position partition %d: %w", p, err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer reader.Close()
+			if err := forwardPartition(ctx, reader, generation, m.messages); err != nil {
+				cancel(fmt.Errorf("failed to read partition %d: %w", reader.Config().Partition, err))
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-ticker.C:
+		}
+		heartbeatCtx, cancelHeartbeat := context.WithTimeout(ctx, groupRequestTimeout)
+		resp, err := m.client.Heartbeat(heartbeatCtx, &kafka.HeartbeatRequest{
+			GroupID:         m.group,
+			GenerationID:    int32(generation),
+			MemberID:        m.currentMemberID(),
+			GroupInstanceID: m.instanceID,
+		})
+		cancelHeartbeat()
+		if err == nil {
+			err = resp.Error
+		}
+		if err != nil {
+			return fmt.Errorf("heartbeat failed: %w", err)
+		}
+	}
+}
+
+// join joins the group and returns the generation and the partitions assigned to the member.
+// The leader of the group assigns the partitions of all members.
+func (m *staticMember) join() (int, []int, error) {
+	ctx, cancel := context.WithTimeout(m.ctx, rebalanceTimeout+m.sessionTimeout)
+	defer cancel()
+	request := &kafka.JoinGroupRequest{
+		GroupID:          m.group,
+		SessionTimeout:   m.sessionTimeout,
+		RebalanceTimeout: rebalanceTimeout,
+		MemberID:         m.currentMemberID(),
+		GroupInstanceID:  m.instanceID,
+		ProtocolType:     consumerProtocolType,
+		Protocols:        []kafka.GroupProtocol{{Name: rangeProtocol, Metadata: kafka.GroupProtocolSubscription{Topics: []string{m.topic}}}},
+	}
+	resp, err := m.client.JoinGroup(ctx, request)
+	if err == nil && errors.Is(resp.Error, kafka.MemberIDRequired) {
+		request.MemberID = resp.MemberID
+		resp, err = m.client.JoinGroup(ctx, request)
+	}
+	if err == nil {
+		err = resp.Error
+	}
+	if err != nil {
+		if errors.Is(err, kafka.UnknownMemberId) {
+			m.setMember("", 0)
+		}
+		return 0, nil, fmt.Errorf("failed to join group %s: %w", m.group, err)
+	}
+	m.setMember(resp.MemberID, resp.GenerationID)
+
+	syncRequest := &kafka.SyncGroupRequest{
+		GroupID:         m.group,
+		GenerationID:    resp.GenerationID,
+		MemberID:        resp.MemberID,
+		GroupInstanceID: m.instanceID,
+		ProtocolType:    consumerProtocolType,
+		ProtocolName:    resp.ProtocolName,
+	}
+	if resp.LeaderID == resp.MemberID {
+		if syncRequest.Assignments, err = m.assign(ctx, resp.Members); err != nil {
+			return 0, nil, err
+		}
+	}
+	synced, err := m.client.SyncGroup(ctx, syncRequest)
+	if err == nil {
+		err = synced.Error
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to sync group %s: %w", m.group, err)
+	}
+	partitions := synced.Assignment.AssignedPartitions[m.topic]
+	sort.Ints(partitions)
+	return resp.GenerationID, partitions, nil
+}
+
+// assign spreads the partitions of each subscribed topic over its subscribers in ranges,
+// ordered by group instance ID so that members keep their partitions across rebalances.
+func (m *staticMember) assign(ctx context.Context, members []kafka.JoinGroupResponseMember) ([]kafka.SyncGroupRequestAssignment, error) {
+	subscribers := make(map[string][]kafka.JoinGroupResponseMember)
+	for _, member := range members {
+		for _, topic := range member.Metadata.Topics {
+			subscribers[topic] = append(subscribers[topic], member)
+		}
+	}
+	assigned := make(map[string]map[string][]int, len(members))
+	for _, member := range members {
+		assigned[member.ID] = make(map[string][]int)
+	}
+	for topic, subs := range subscribers {
+		partitions, err := topicPartitionIDs(ctx, m.client, topic)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(subs, func(i, j int) bool {
+			if subs[i].GroupInstanceID != subs[j].GroupInstanceID {
+				return subs[i].GroupInstanceID < subs[j].GroupInstanceID
+			}
+			return subs[i].ID < subs[j].ID
+		})
+		per, extra := len(partitions)/len(subs), len(partitions)%len(subs)
+		next := 0
+		for i, sub := range subs {
+			n := per
+			if i < extra {
+				n++
+			}
+			assigned[sub.ID][topic] = partitions[next : next+n]
+			next += n
+		}
+	}
+
+	assignments := make([]kafka.SyncGroupRequestAssignment, 0, len(members))
+	for _, member := range members {
+		assignments = append(assignments, kafka.SyncGroupRequestAssignment{
+			MemberID:   member.ID,
+			Assignment: kafka.GroupProtocolAssignment{AssignedPartitions: assigned[member.ID]},
+		})
+	}
+	return assignments, nil
+}
+
+// startOffsets returns the group's committed offset of each partition, or the topic's start
+// offset for partitions without one.
+func (m *staticMember) startOffsets(ctx context.Context, partitions []int) (map[int]int64, error) {
+	offsets := make(map[int]int64, len(partitions))
+	if len(partitions) == 0 {
+		return offsets, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, groupRequestTimeout)
+	defer cancel()
+	resp, err := m.client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{GroupID: m.group, Topics: map[string][]int{m.topic: partitions}})
+	if err == nil {
+		err = resp.Error
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch committed offsets for topic %s: %w", m.topic, err)
+	}
+	for _, p := range partitions {
+		offsets[p] = m.startOffset
+	}
+	for _, p := range resp.Topics[m.topic] {
+		if p.Error == nil && p.CommittedOffset >= 0 {
+			offsets[p.Partition] = p.CommittedOffset
+		}
+	}
+	return offsets, nil
+}
+
+func (m *staticMember) currentMemberID() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.memberID
+}
+
+func (m *staticMember) setMember(memberID string, generation int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.memberID, m.generation, m.revoked = memberID, generation, false
+}
+
+// FetchMessage returns the next message of the member's partitions. Messages read before the
+// latest rebalance are skipped: the partition is read again from its committed offset by
+// whichever member it is assigned to now.
+func (m *staticMember) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return kafka.Message{}, ctx.Err()
+		case <-m.ctx.Done():
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if m.err != nil {
+				return kafka.Message{}, m.err
+			}
+			return kafka.Message{}, errors.New("static group member is closed")
+		case msg := <-m.messages:
+			m.mu.Lock()
+			current := msg.generation == m.generation && !m.revoked
+			m.mu.Unlock()
+			if current {
+				return msg.Message, nil
+			}
+		}
+	}
+}
+
+// CommitMessages commits the offsets following the messages in the current generation. It
+// fails when the group has rebalanced since.
+func (m *staticMember) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	next := make(map[int]int64)
+	for _, msg := range msgs {
+		if msg.Offset+1 > next[msg.Partition] {
+			next[msg.Partition] = msg.Offset + 1
+		}
+	}
+	commits := make([]kafka.OffsetCommit, 0, len(next))
+	for p, offset := range next {
+		commits = append(commits, kafka.OffsetCommit{Partition: p, Offset: offset})
+	}
+	m.mu.Lock()
+	memberID, generation := m.memberID, m.generation
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, groupRequestTimeout)
+	defer cancel()
+	resp, err := m.client.OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+		GroupID:      m.group,
+		GenerationID: generation,
+		MemberID:     memberID,
+		InstanceID:   m.instanceID,
+		Topics:       map[string][]kafka.OffsetCommit{m.topic: commits},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to commit offsets for topic %s: %w", m.topic, err)
+	}
+	for _, p := range resp.Topics[m.topic] {
+		if p.Error != nil {
+			return fmt.Errorf("failed to commit offset for topic %s, partition %d: %w", m.topic, p.Partition, p.Error)
+		}
+	}
+	return nil
+}
+
+// Close stops reading without leaving the group, so the member's partitions wait for it
+// until its session times out instead of being rebalanced.
+func (m *staticMember) Close() error {
+	m.cancel()
+	<-m.done
+	return nil
+}
+
+// Leave stops reading and removes the member from the group, which rebalances its partitions
+// right away, e.g. before the group's offsets are reset.
+func (m *staticMember) Leave() error {
+	m.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), groupRequestTimeout)
+	defer cancel()
+	resp, err := m.client.LeaveGroup(ctx, &kafka.LeaveGroupRequest{
+		GroupID: m.group,
+		Members: []kafka.LeaveGroupRequestMember{{ID: m.currentMemberID(), GroupInstanceID: m.instanceID}},
+	})
+	if err == nil {
+		err = resp.Error
+	}
+	if err != nil && !errors.Is(err, kafka.UnknownMemberId) {
+		return fmt.Errorf("static member %s failed to leave group %s: %w", m.instanceID, m.group, err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"stream-rag-agent/internal/metrics"
+)
+
+var (
+	throttledTotal      = metrics.NewCounter("kafka_consumer_throttled_total", "Messages delayed by the per-topic consumption throttle.")
+	throttleWaitSeconds = metrics.NewCounter("kafka_consumer_throttle_wait_seconds_total", "Time spent waiting on the per-topic consumption throttle.")
+)
+
+// tokenBucket limits consumption to rate messages per second with bursts of up to burst messages.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns nil when rate is not positive, meaning no throttling.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	b := float64(burst)
+	if b < 1 {
+		b = rate // Default to one second worth of messages
+		if b < 1 {
+			b = 1
+		}
+	}
+	return &tokenBucket{rate: rate, burst: b, tokens: b, last: time.Now()}
+}
+
+// reserve takes one token and returns how long the caller must wait before using it.
+func (tb *tokenBucket) reserve() time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+
+	tb.tokens--
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// Wait blocks until a token is available or the context is cancelled.
+func (tb *tokenBucket) Wait(ctx context.Context, topic string) error {
+	if tb == nil {
+		return nil
+	}
+	delay := tb.reserve()
+	if delay <= 0 {
+		return nil
+	}
+
+	throttledTotal.Inc("topic", topic)
+	throttleWaitSeconds.Add(delay.Seconds(), "topic", topic)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
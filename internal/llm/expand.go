@@ -0,0 +1,47 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ExpandQuery asks the LLM for up to n paraphrases or sub-questions of the prompt, used to
+// widen retrieval for vague questions. The original prompt is not included in the result.
+// The call is bounded by the deadline of ctx, if any.
+func (s *Service) ExpandQuery(ctx context.Context, prompt string, n int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to expand query: %w", err)
+	}
+
+	system := fmt.Sprintf("You help search a database of Kafka stream data. Rewrite the user's question as %d "+
+		"alternative search queries: paraphrases or more specific sub-questions that together cover what the user wants to know. "+
+		"Keep identifiers, codes and numbers as they are. "+
+		`Respond ONLY with JSON of the form {"queries": ["...", "..."]}.`, n)
+
+	raw, err := s.GenerateWithOptions(ctx, system, prompt, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand query: %w", err)
+	}
+
+	var result struct {
+		Queries []string `json:"queries"`
+	}
+	if err := json.Unmarshal([]byte(ExtractJSONObject(raw)), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse query expansion response: %w", err)
+	}
+
+	queries := make([]string, 0, n)
+	for _, q := range result.Queries {
+		q = strings.TrimSpace(q)
+		if q == "" || strings.EqualFold(q, strings.TrimSpace(prompt)) {
+			continue
+		}
+		queries = append(queries, q)
+		if len(queries) == n {
+			break
+		}
+	}
+	return queries, nil
+}
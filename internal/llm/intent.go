@@ -0,0 +1,46 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// IntentResult is the answering strategy the LLM chose for a question.
+type IntentResult struct {
+	Mode   string `json:"mode"`
+	Reason string `json:"reason"`
+}
+
+// ClassifyIntent asks the LLM which of the answering strategies, described by name in modes,
+// suits the question best. A mode outside modes is an error.
+func (s *Service) ClassifyIntent(question string, modes map[string]string) (*IntentResult, error) {
+	names := make([]string, 0, len(modes))
+	for name := range modes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var options strings.Builder
+	for _, name := range names {
+		options.WriteString(fmt.Sprintf("- %s: %s\n", name, modes[name]))
+	}
+	system := "You route questions about Kafka stream data to the strategy that answers them best. The strategies are:\n" +
+		options.String() +
+		`Respond ONLY with JSON of the form {"mode": "<strategy>", "reason": "<one short sentence>"}.`
+
+	raw, err := s.GenerateWithSystem(system, question)
+	if err != nil {
+		return nil, fmt.Errorf("failed to classify question: %w", err)
+	}
+
+	var result IntentResult
+	if err := json.Unmarshal([]byte(ExtractJSONObject(raw)), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse intent classification response: %w", err)
+	}
+	result.Mode = strings.ToLower(strings.TrimSpace(result.Mode))
+	if _, ok := modes[result.Mode]; !ok {
+		return nil, fmt.Errorf("intent classification returned unknown mode '%s' (expected one of %s)", result.Mode, strings.Join(names, ", "))
+	}
+	return &result, nil
+}
@@ -0,0 +1,43 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const judgeSystemPrompt = `You grade answers of an assistant that answers questions from windows of real-time Kafka data.
+Given the question, the context windows the assistant was given and its answer, score the answer from 1 (worst) to 5 (best) on:
+- groundedness: every claim and figure in the answer is supported by the context; 1 if the answer is mostly unsupported or contradicts the context
+- completeness: the answer addresses every part of the question as far as the context allows; an answer that correctly says the context does not contain the information is complete
+Respond ONLY with JSON of the form {"groundedness": <1-5>, "completeness": <1-5>, "reason": "<one short sentence>"}.`
+
+// JudgeResult is how a judge model scored an answer.
+type JudgeResult struct {
+	Groundedness int    `json:"groundedness"`
+	Completeness int    `json:"completeness"`
+	Reason       string `json:"reason"`
+}
+
+// JudgeAnswer asks model to score how well answer is grounded in the context it was generated
+// from and how completely it answers the question. Scores outside 1-5 are an error.
+func (s *Service) JudgeAnswer(model, question, contextText, answer string) (*JudgeResult, error) {
+	prompt := fmt.Sprintf("Question:\n%s\n\nContext:\n%s\n\nAnswer:\n%s", question, contextText, answer)
+	raw, err := s.GenerateWithOptions(context.Background(), judgeSystemPrompt, prompt, &GenerateOptions{Model: model})
+	if err != nil {
+		return nil, fmt.Errorf("failed to judge answer: %w", err)
+	}
+
+	var result JudgeResult
+	if err := json.Unmarshal([]byte(ExtractJSONObject(raw)), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse judge response: %w", err)
+	}
+	for name, score := range map[string]int{"groundedness": result.Groundedness, "completeness": result.Completeness} {
+		if score < 1 || score > 5 {
+			return nil, fmt.Errorf("judge returned %s score %d outside 1-5", name, score)
+		}
+	}
+	result.Reason = strings.TrimSpace(result.Reason)
+	return &result, nil
+}
@@ -2,67 +2,272 @@ package llm
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/faults"
+	"stream-rag-agent/pkg/errs"
+	"stream-rag-agent/pkg/hooks"
 )
 
+// dependency names Ollama in errs.DependencyError.
+const dependency = "ollama"
+
 type OllamaGenerateRequest struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
-	Stream bool   `json:"stream"`
+	Model   string           `json:"model"`
+	System  string           `json:"system,omitempty"`
+	Prompt  string           `json:"prompt"`
+	Stream  bool             `json:"stream"`
+	Options *GenerateOptions `json:"options,omitempty"`
+}
+
+// GenerateOptions are Ollama model options applied to a single request.
+type GenerateOptions struct {
+	NumPredict int           `json:"num_predict,omitempty"` // Maximum number of tokens to generate, 0 uses the model default
+	Model      string        `json:"-"`                     // Model to use instead of the configured one; not an Ollama option
+	Timeout    time.Duration `json:"-"`                     // Bound on the Ollama call, e.g. the rest of a query's deadline; 0 uses the client timeout
+}
+
+// context bounds a request by ctx and the options' timeout.
+func (o *GenerateOptions) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o == nil || o.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, o.Timeout)
+}
+
+// post sends a JSON request to Ollama within ctx and the options' timeout.
+func (s *Service) post(ctx context.Context, url string, body []byte, opts *GenerateOptions) (*http.Response, context.CancelFunc, error) {
+	ctx, cancel := opts.context(ctx)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return resp, cancel, nil
 }
 
 type OllamaGenerateResponse struct {
 	Response string `json:"response"`
 }
 
+type ChatMessage struct {
+	Role    string `json:"role"` // "system", "user" or "assistant"
+	Content string `json:"content"`
+}
+
+type OllamaChatRequest struct {
+	Model    string           `json:"model"`
+	Messages []ChatMessage    `json:"messages"`
+	Stream   bool             `json:"stream"`
+	Options  *GenerateOptions `json:"options,omitempty"`
+}
+
+type OllamaChatResponse struct {
+	Message ChatMessage `json:"message"`
+}
+
 type Service struct {
-	ollamaURL  string
-	llmModel   string
-	httpClient *http.Client
+	ollamaURL    string
+	llmModel     string
+	models       map[string]bool // Models requests may select, including llmModel
+	systemPrompt string
+	useChatAPI   bool
+	hooks        *hooks.Registry // BeforeGenerate/AfterGenerate hooks, nil if none
+	httpClient   *http.Client
 }
 
-func NewService(cfg *config.OllamaConfig) *Service {
+func NewService(cfg *config.OllamaConfig, hookRegistry *hooks.Registry) *Service {
+	models := map[string]bool{cfg.LLMModel: true}
+	for _, m := range cfg.Models {
+		models[m] = true
+	}
 	return &Service{
-		ollamaURL: cfg.URL,
-		llmModel:  cfg.LLMModel,
+		ollamaURL:    cfg.URL,
+		llmModel:     cfg.LLMModel,
+		models:       models,
+		systemPrompt: cfg.SystemPrompt,
+		useChatAPI:   cfg.UseChatAPI,
+		hooks:        hookRegistry,
 		httpClient: &http.Client{
 			Timeout: 120 * time.Second, // LLM calls can take longer
 		},
 	}
 }
 
+// Model returns the configured LLM model name.
+func (s *Service) Model() string {
+	return s.llmModel
+}
+
+// HasModel reports whether requests may select the model: the default or one of ollama.models.
+func (s *Service) HasModel(model string) bool {
+	return s.models[model]
+}
+
+// modelFor returns the model a request runs on.
+func (s *Service) modelFor(opts *GenerateOptions) string {
+	if opts != nil && opts.Model != "" {
+		return opts.Model
+	}
+	return s.llmModel
+}
+
+// SystemPrompt returns the configured system prompt override, or an empty string if none is set.
+func (s *Service) SystemPrompt() string {
+	return s.systemPrompt
+}
+
 func (s *Service) GenerateContent(prompt string) (string, error) {
+	return s.GenerateWithSystem("", prompt)
+}
+
+// GenerateWithSystem sends the instructions as a separate system message so they are not
+// mixed into the user content. It uses /api/chat when configured, otherwise /api/generate.
+func (s *Service) GenerateWithSystem(system, prompt string) (string, error) {
+	return s.GenerateWithOptions(context.Background(), system, prompt, nil)
+}
+
+// GenerateWithOptions is GenerateWithSystem with per-request model options, e.g. a token limit.
+// The call is cancelled with ctx, e.g. when the client of the request disconnects.
+func (s *Service) GenerateWithOptions(ctx context.Context, system, prompt string, opts *GenerateOptions) (string, error) {
+	messages := make([]ChatMessage, 0, 2)
+	if system != "" {
+		messages = append(messages, ChatMessage{Role: "system", Content: system})
+	}
+	messages = append(messages, ChatMessage{Role: "user", Content: prompt})
+	return s.withHooks(messages, opts, func(model string, messages []ChatMessage) (string, error) {
+		if s.useChatAPI {
+			return s.chat(ctx, model, messages, opts)
+		}
+		// Hooks may have added messages; /api/generate takes one system text and one prompt
+		var systemParts, promptParts []string
+		for _, m := range messages {
+			if m.Role == "system" {
+				systemParts = append(systemParts, m.Content)
+			} else {
+				promptParts = append(promptParts, m.Content)
+			}
+		}
+		return s.generate(ctx, model, strings.Join(systemParts, "\n\n"), strings.Join(promptParts, "\n\n"), opts)
+	})
+}
+
+// withHooks runs the BeforeGenerate hooks on the messages, the call and the AfterGenerate
+// hooks on its response.
+func (s *Service) withHooks(messages []ChatMessage, opts *GenerateOptions, call func(model string, messages []ChatMessage) (string, error)) (string, error) {
+	if s.hooks.Empty() {
+		return call(s.modelFor(opts), messages)
+	}
+	req := hooks.GenerateRequest{Model: s.modelFor(opts), Messages: make([]hooks.Message, 0, len(messages))}
+	for _, m := range messages {
+		req.Messages = append(req.Messages, hooks.Message{Role: m.Role, Content: m.Content})
+	}
+	if err := s.hooks.RunBeforeGenerate(&req); err != nil {
+		return "", err
+	}
+	messages = make([]ChatMessage, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		messages = append(messages, ChatMessage{Role: m.Role, Content: m.Content})
+	}
+	response, err := call(req.Model, messages)
+	if err != nil {
+		return "", err
+	}
+	return s.hooks.RunAfterGenerate(req, response)
+}
+
+func (s *Service) generate(ctx context.Context, model, system, prompt string, opts *GenerateOptions) (string, error) {
+	if err := faults.Inject(faults.Ollama); err != nil {
+		return "", errs.Dependency(dependency, 0, fmt.Errorf("failed to call ollama: %w", err))
+	}
 	reqBody, err := json.Marshal(OllamaGenerateRequest{
-		Model:  s.llmModel,
-		Prompt: prompt,
-		Stream: false,
+		Model:   model,
+		System:  system,
+		Prompt:  prompt,
+		Stream:  false,
+		Options: opts,
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal ollama generate request: %w", err)
 	}
 
 	url := fmt.Sprintf("%s/api/generate", s.ollamaURL)
-	resp, err := s.httpClient.Post(url, "application/json", bytes.NewReader(reqBody))
+	resp, cancel, err := s.post(ctx, url, reqBody, opts)
 	if err != nil {
-		return "", fmt.Errorf("failed to call ollama generate API: %w", err)
+		return "", errs.Dependency(dependency, 0, fmt.Errorf("failed to call ollama generate API: %w", err))
 	}
+	defer cancel()
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("ollama generate API returned non-OK status: %d, body: %s", resp.StatusCode, string(bodyBytes))
+		return "", errs.Dependency(dependency, resp.StatusCode, fmt.Errorf("ollama generate API returned non-OK status: %d, body: %s", resp.StatusCode, string(bodyBytes)))
 	}
 
 	var genResp OllamaGenerateResponse
 	if err := json.NewDecoder(resp.Body).Decode(&genResp); err != nil {
-		return "", fmt.Errorf("failed to decode ollama generate response: %w", err)
+		return "", errs.Dependency(dependency, resp.StatusCode, fmt.Errorf("failed to decode ollama generate response: %w", err))
 	}
 
 	return genResp.Response, nil
 }
+
+// Chat sends a role-tagged conversation to Ollama's /api/chat endpoint and returns the assistant reply.
+func (s *Service) Chat(messages []ChatMessage) (string, error) {
+	return s.ChatWithOptions(context.Background(), messages, nil)
+}
+
+// ChatWithOptions is Chat with per-request model options, cancelled with ctx.
+func (s *Service) ChatWithOptions(ctx context.Context, messages []ChatMessage, opts *GenerateOptions) (string, error) {
+	return s.withHooks(messages, opts, func(model string, messages []ChatMessage) (string, error) {
+		return s.chat(ctx, model, messages, opts)
+	})
+}
+
+func (s *Service) chat(ctx context.Context, model string, messages []ChatMessage, opts *GenerateOptions) (string, error) {
+	if err := faults.Inject(faults.Ollama); err != nil {
+		return "", errs.Dependency(dependency, 0, fmt.Errorf("failed to call ollama chat API: %w", err))
+	}
+	reqBody, err := json.Marshal(OllamaChatRequest{
+		Model:    model,
+		Messages: messages,
+		Stream:   false,
+		Options:  opts,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal ollama chat request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/api/chat", s.ollamaURL)
+	resp, cancel, err := s.post(ctx, url, reqBody, opts)
+	if err != nil {
+		return "", errs.Dependency(dependency, 0, fmt.Errorf("failed to call ollama chat API: %w", err))
+	}
+	defer cancel()
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return "", errs.Dependency(dependency, resp.StatusCode, fmt.Errorf("ollama chat API returned non-OK status: %d, body: %s", resp.StatusCode, string(bodyBytes)))
+	}
+
+	var chatResp OllamaChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return "", errs.Dependency(dependency, resp.StatusCode, fmt.Errorf("failed to decode ollama chat response: %w", err))
+	}
+
+	return chatResp.Message.Content, nil
+}
@@ -0,0 +1,63 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// TranslationResult holds the detected language of a text and its translation.
+type TranslationResult struct {
+	Language    string `json:"language"`
+	Translation string `json:"translation"`
+}
+
+// DetectAndTranslate asks the LLM to detect the language of text and translate it into
+// targetLanguage. Text already in the target language is returned unchanged.
+func (s *Service) DetectAndTranslate(text, targetLanguage string) (*TranslationResult, error) {
+	system := fmt.Sprintf("You detect the language of the user's text and translate it into %s. "+
+		"If the text is already in %s, return it unchanged. Keep identifiers, codes and numbers as they are. "+
+		`Respond ONLY with JSON of the form {"language": "<language name in English>", "translation": "<translated text>"}.`,
+		targetLanguage, targetLanguage)
+
+	raw, err := s.GenerateWithSystem(system, text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to translate text: %w", err)
+	}
+
+	var result TranslationResult
+	if err := json.Unmarshal([]byte(ExtractJSONObject(raw)), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse translation response: %w", err)
+	}
+	if result.Translation == "" {
+		result.Translation = text
+	}
+	return &result, nil
+}
+
+// Translate translates text into targetLanguage and returns only the translation.
+func (s *Service) Translate(text, targetLanguage string) (string, error) {
+	system := fmt.Sprintf("Translate the user's text into %s. Keep identifiers, codes, numbers and formatting as they are. "+
+		"Respond ONLY with the translation.", targetLanguage)
+	translated, err := s.GenerateWithSystem(system, text)
+	if err != nil {
+		return "", fmt.Errorf("failed to translate text: %w", err)
+	}
+	return strings.TrimSpace(translated), nil
+}
+
+// SameLanguage reports whether two language names refer to the same language, ignoring case.
+func SameLanguage(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+// ExtractJSONObject returns the first {...} block of an LLM response, which models
+// sometimes wrap in prose or code fences.
+func ExtractJSONObject(s string) string {
+	start := strings.Index(s, "{")
+	end := strings.LastIndex(s, "}")
+	if start < 0 || end < start {
+		return s
+	}
+	return s[start : end+1]
+}
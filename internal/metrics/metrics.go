@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// registry holds every metric created through NewCounter/NewGauge so they can be
+// rendered by Handler in the Prometheus text exposition format.
+var registry = struct {
+	mu      sync.Mutex
+	metrics []*metric
+}{}
+
+type metric struct {
+	name   string
+	help   string
+	kind   string // "counter" or "gauge"
+	mu     sync.Mutex
+	values map[string]float64 // Key: rendered label set, e.g. `topic="orders"`
+}
+
+func newMetric(name, help, kind string) *metric {
+	m := &metric{name: name, help: help, kind: kind, values: make(map[string]float64)}
+	registry.mu.Lock()
+	registry.metrics = append(registry.metrics, m)
+	registry.mu.Unlock()
+	return m
+}
+
+// labelKey renders label key/value pairs ("topic", "orders", ...) into a stable label string.
+func labelKey(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m *metric) add(v float64, labels []string) {
+	key := labelKey(labels)
+	m.mu.Lock()
+	m.values[key] += v
+	m.mu.Unlock()
+}
+
+func (m *metric) set(v float64, labels []string) {
+	key := labelKey(labels)
+	m.mu.Lock()
+	m.values[key] = v
+	m.mu.Unlock()
+}
+
+func (m *metric) get(labels []string) float64 {
+	key := labelKey(labels)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[key]
+}
+
+// Counter is a monotonically increasing value, optionally split by label pairs.
+type Counter struct{ m *metric }
+
+func NewCounter(name, help string) *Counter {
+	return &Counter{m: newMetric(name, help, "counter")}
+}
+
+func (c *Counter) Inc(labels ...string)            { c.m.add(1, labels) }
+func (c *Counter) Add(v float64, labels ...string) { c.m.add(v, labels) }
+func (c *Counter) Value(labels ...string) float64  { return c.m.get(labels) }
+
+// Gauge is a value that can go up and down, optionally split by label pairs.
+type Gauge struct{ m *metric }
+
+func NewGauge(name, help string) *Gauge {
+	return &Gauge{m: newMetric(name, help, "gauge")}
+}
+
+func (g *Gauge) Set(v float64, labels ...string) { g.m.set(v, labels) }
+func (g *Gauge) Add(v float64, labels ...string) { g.m.add(v, labels) }
+func (g *Gauge) Value(labels ...string) float64  { return g.m.get(labels) }
+
+// Handler serves all registered metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		registry.mu.Lock()
+		metrics := append([]*metric(nil), registry.metrics...)
+		registry.mu.Unlock()
+
+		for _, m := range metrics {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+			m.mu.Lock()
+			keys := make([]string, 0, len(m.values))
+			for k := range m.values {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				if k == "" {
+					fmt.Fprintf(w, "%s %g\n", m.name, m.values[k])
+				} else {
+					fmt.Fprintf(w, "%s{%s} %g\n", m.name, k, m.values[k])
+				}
+			}
+			m.mu.Unlock()
+		}
+	})
+}
@@ -0,0 +1,248 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"stream-rag-agent/internal/atrest"
+	"stream-rag-agent/internal/metrics"
+	"stream-rag-agent/internal/window"
+)
+
+const defaultFlushInterval = 30 * time.Second
+
+// eventsDir is the subdirectory holding events that could not be published.
+const eventsDir = "events"
+
+var (
+	pendingGauge = metrics.NewGauge("outbox_pending_windows", "Embedded windows waiting in the local outbox for Elasticsearch.")
+	flushedTotal = metrics.NewCounter("outbox_flushed_total", "Embedded windows flushed from the local outbox to Elasticsearch.")
+
+	pendingEventsGauge = metrics.NewGauge("outbox_pending_events", "Window summary events waiting in the local outbox for the output topic.")
+	flushedEventsTotal = metrics.NewCounter("outbox_flushed_events_total", "Window summary events published from the local outbox.")
+)
+
+// Saver persists an embedded window to the vector store.
+type Saver interface {
+	SaveEmbeddedWindow(ew *window.EmbeddedWindow) error
+}
+
+// Event is a message for the output topic that could not be published.
+type Event struct {
+	Key     []byte            `json:"key"`
+	Value   []byte            `json:"value"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// EventPublisher publishes an outboxed event.
+type EventPublisher interface {
+	PublishEvent(e *Event) error
+}
+
+// Outbox is a directory of embedded windows (one JSON file each) that could not be written
+// to Elasticsearch. Keeping them on disk preserves the embedding work until the store is back.
+// Its events subdirectory likewise keeps the summary events the output topic did not accept,
+// so a window is only acknowledged once its event is published or stored here.
+type Outbox struct {
+	dir      string
+	interval time.Duration
+	mu       sync.Mutex // Serializes flushes
+}
+
+func New(dir string, flushInterval time.Duration) (*Outbox, error) {
+	if err := os.MkdirAll(filepath.Join(dir, eventsDir), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+	o := &Outbox{dir: dir, interval: flushInterval}
+	o.updatePending()
+	return o, nil
+}
+
+// Put stores an embedded window in the outbox.
+func (o *Outbox) Put(ew *window.EmbeddedWindow) error {
+	data, err := json.Marshal(ew)
+	if err != nil {
+		return fmt.Errorf("failed to marshal embedded window for outbox: %w", err)
+	}
+	if err := put(o.dir, ew.WindowID, data); err != nil {
+		return err
+	}
+	log.Printf("Stored window '%s' in local outbox %s.", ew.WindowID, o.dir)
+	o.updatePending()
+	return nil
+}
+
+// PutEvent stores an event that could not be published in the outbox; id names the event
+// in file names and logs, e.g. its window ID.
+func (o *Outbox) PutEvent(id string, e *Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event for outbox: %w", err)
+	}
+	if err := put(filepath.Join(o.dir, eventsDir), id, data); err != nil {
+		return err
+	}
+	log.Printf("Stored event of '%s' in local outbox %s.", id, o.dir)
+	o.updatePending()
+	return nil
+}
+
+// put writes a file to dir. Writing to a temp file and renaming makes the write atomic, so a
+// crash never leaves a half-written file behind.
+func put(dir, id string, data []byte) error {
+	name := fmt.Sprintf("%d_%s.json", time.Now().UnixNano(), sanitize(id))
+	tmp := filepath.Join(dir, name+".tmp")
+	if err := atrest.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write outbox file: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		return fmt.Errorf("failed to commit outbox file: %w", err)
+	}
+	return nil
+}
+
+// Run periodically flushes the outbox until the context is cancelled. Events are published
+// with events, which may be nil when the output topic is disabled.
+func (o *Outbox) Run(ctx context.Context, saver Saver, events EventPublisher) {
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := o.Flush(saver); err != nil {
+				log.Printf("Outbox flush stopped: %v", err)
+			}
+			if events == nil {
+				continue
+			}
+			if err := o.FlushEvents(events); err != nil {
+				log.Printf("Outbox event flush stopped: %v", err)
+			}
+		}
+	}
+}
+
+// Flush writes outboxed windows to the store in the order they were stored, deleting each
+// one on success. It stops at the first failure since the store is most likely still down.
+func (o *Outbox) Flush(saver Saver) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	files, err := listFiles(o.dir)
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		data, err := atrest.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read outbox file %s: %w", path, err)
+		}
+		var ew window.EmbeddedWindow
+		if err := json.Unmarshal(data, &ew); err != nil {
+			log.Printf("Error: discarding corrupt outbox file %s: %v", path, err)
+			os.Remove(path)
+			continue
+		}
+		if err := saver.SaveEmbeddedWindow(&ew); err != nil {
+			o.updatePending()
+			return fmt.Errorf("failed to flush window '%s': %w", ew.WindowID, err)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove flushed outbox file %s: %w", path, err)
+		}
+		flushedTotal.Inc()
+		log.Printf("Flushed window '%s' from local outbox.", ew.WindowID)
+	}
+	o.updatePending()
+	return nil
+}
+
+// FlushEvents publishes outboxed events in the order they were stored, deleting each one on
+// success. It stops at the first failure since the output topic is most likely still down.
+func (o *Outbox) FlushEvents(p EventPublisher) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	files, err := listFiles(filepath.Join(o.dir, eventsDir))
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		data, err := atrest.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read outbox file %s: %w", path, err)
+		}
+		var e Event
+		if err := json.Unmarshal(data, &e); err != nil {
+			log.Printf("Error: discarding corrupt outbox file %s: %v", path, err)
+			os.Remove(path)
+			continue
+		}
+		if err := p.PublishEvent(&e); err != nil {
+			o.updatePending()
+			return fmt.Errorf("failed to publish event of '%s': %w", e.Key, err)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove published outbox file %s: %w", path, err)
+		}
+		flushedEventsTotal.Inc()
+		log.Printf("Published event of '%s' from local outbox.", e.Key)
+	}
+	o.updatePending()
+	return nil
+}
+
+// Pending returns the number of windows waiting in the outbox.
+func (o *Outbox) Pending() int {
+	files, _ := listFiles(o.dir)
+	return len(files)
+}
+
+// PendingEvents returns the number of events waiting in the outbox.
+func (o *Outbox) PendingEvents() int {
+	files, _ := listFiles(filepath.Join(o.dir, eventsDir))
+	return len(files)
+}
+
+func listFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list outbox directory: %w", err)
+	}
+	var files []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		files = append(files, filepath.Join(dir, e.Name()))
+	}
+	sort.Strings(files) // File names start with the store time
+	return files, nil
+}
+
+func (o *Outbox) updatePending() {
+	pendingGauge.Set(float64(o.Pending()))
+	pendingEventsGauge.Set(float64(o.PendingEvents()))
+}
+
+func sanitize(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, id)
+}
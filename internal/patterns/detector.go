@@ -0,0 +1,434 @@
+package patterns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"stream-rag-agent/internal/atrest"
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/embedding"
+	"stream-rag-agent/internal/metrics"
+	"stream-rag-agent/internal/window"
+	"stream-rag-agent/pkg/errs"
+)
+
+const (
+	defaultThreshold = 0.85
+	defaultTimeout   = 5 * time.Second
+	maxRecentAlerts  = 100
+)
+
+var (
+	ErrPatternNotFound = errs.New(errs.ErrNotFound, "pattern not found")
+
+	alertsTotal = metrics.NewCounter("pattern_alerts_total", "Windows similar to a known-bad pattern, by pattern and topic.")
+)
+
+// Pattern is a known-bad situation, described by an example incident whose embedding new
+// windows are compared with.
+type Pattern struct {
+	Name           string    `json:"name"`
+	Text           string    `json:"text"`
+	Threshold      float64   `json:"threshold,omitempty"` // 0 uses patterns.threshold
+	Topics         []string  `json:"topics,omitempty"`    // Only windows of these topics, all topics if empty
+	Embedding      []float32 `json:"-"`                   // Persisted in the patterns file, not returned by the API
+	EmbeddingModel string    `json:"embedding_model,omitempty"`
+	Workspace      string    `json:"workspace,omitempty"` // Workspace the pattern belongs to, empty for patterns shared by all
+	Source         string    `json:"source,omitempty"`    // "config" or "api"
+}
+
+// patternKey identifies a pattern: patterns of different workspaces may share a name.
+type patternKey struct {
+	workspace string
+	name      string
+}
+
+// savedPattern is a pattern as stored in the patterns file, with its embedding.
+type savedPattern struct {
+	*Pattern
+	Embedding []float32 `json:"embedding,omitempty"`
+}
+
+// Alert reports a window that matched a pattern.
+type Alert struct {
+	Pattern    string    `json:"pattern"`
+	Workspace  string    `json:"workspace,omitempty"` // Workspace of the pattern, empty for shared patterns
+	Similarity float64   `json:"similarity"`
+	Threshold  float64   `json:"threshold"`
+	WindowID   string    `json:"window_id"`
+	Topic      string    `json:"topic"`
+	Partition  int32     `json:"partition"`
+	StartTime  time.Time `json:"start_time"`
+	EndTime    time.Time `json:"end_time"`
+	Category   string    `json:"category,omitempty"`
+	At         time.Time `json:"at"`
+}
+
+// Detector compares embedded windows with the registered patterns. Patterns from the config
+// file are embedded when first needed, and any pattern is embedded again when the embedding
+// model changes, so vectors are always comparable with new windows. Patterns registered in a
+// workspace, and their alerts, are only seen in it; the others are shared by all workspaces.
+type Detector struct {
+	embedSvc   *embedding.Service
+	threshold  float64
+	webhookURL string
+	httpClient *http.Client
+	path       string
+
+	mu       sync.RWMutex
+	patterns map[patternKey]*Pattern
+	recent   []Alert // Newest last, at most maxRecentAlerts
+
+	embedMu sync.Mutex // Serializes embedding of stale patterns
+}
+
+// NewDetector loads the saved and configured patterns, nil when pattern alerting is disabled.
+func NewDetector(cfg config.PatternsConfig, embedSvc *embedding.Service) (*Detector, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	timeout := time.Duration(cfg.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	d := &Detector{
+		embedSvc:   embedSvc,
+		threshold:  cfg.Threshold,
+		webhookURL: cfg.WebhookURL,
+		httpClient: &http.Client{Timeout: timeout},
+		path:       cfg.File,
+		patterns:   make(map[patternKey]*Pattern),
+	}
+	if d.threshold <= 0 {
+		d.threshold = defaultThreshold
+	}
+
+	if d.path != "" {
+		data, err := atrest.ReadFile(d.path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read patterns file: %w", err)
+		}
+		if len(data) > 0 {
+			var saved []savedPattern
+			if err := json.Unmarshal(data, &saved); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal patterns file: %w", err)
+			}
+			for _, sp := range saved {
+				if sp.Pattern == nil {
+					continue
+				}
+				p := sp.Pattern
+				p.Embedding = sp.Embedding
+				p.Source = "api"
+				d.patterns[patternKey{p.Workspace, p.Name}] = p
+			}
+		}
+	}
+
+	// Patterns from the config file take precedence over saved ones with the same name
+	for _, def := range cfg.Definitions {
+		if def.Name == "" || def.Text == "" {
+			return nil, fmt.Errorf("patterns need a name and a text")
+		}
+		d.patterns[patternKey{name: def.Name}] = &Pattern{Name: def.Name, Text: def.Text, Threshold: def.Threshold, Topics: def.Topics, Source: "config"}
+	}
+	log.Printf("Pattern alerting enabled with %d patterns (threshold %.2f)", len(d.patterns), d.threshold)
+	return d, nil
+}
+
+// List returns the patterns of a workspace and the shared patterns it does not shadow.
+func (d *Detector) List(workspace string) []*Pattern {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	list := make([]*Pattern, 0, len(d.patterns))
+	for k, p := range d.patterns {
+		switch {
+		case k.workspace == workspace:
+		case k.workspace == "" && d.patterns[patternKey{workspace, k.name}] == nil:
+		default:
+			continue
+		}
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Get returns the pattern of a workspace, or the shared pattern, with the given name. An empty
+// workspace only sees shared patterns.
+func (d *Detector) Get(workspace, name string) (*Pattern, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if p, ok := d.patterns[patternKey{workspace, name}]; ok {
+		return p, nil
+	}
+	if p, ok := d.patterns[patternKey{name: name}]; ok {
+		return p, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrPatternNotFound, name)
+}
+
+// Put embeds the pattern's text and registers it in p.Workspace, replacing an API-registered
+// pattern with the same name. Patterns defined in the config file cannot be overwritten, nor
+// shadowed in a workspace.
+func (d *Detector) Put(p *Pattern) error {
+	if p.Name == "" || p.Text == "" {
+		return errs.New(errs.ErrInvalidRequest, "pattern name and text are required")
+	}
+	if p.Threshold < 0 || p.Threshold > 1 {
+		return errs.New(errs.ErrInvalidRequest, "pattern threshold must be between 0 and 1")
+	}
+	d.mu.RLock()
+	existing, ok := d.patterns[patternKey{name: p.Name}]
+	d.mu.RUnlock()
+	if ok && existing.Source == "config" {
+		return errs.Errorf(errs.ErrInvalidRequest, "pattern '%s' is defined in the config file and cannot be modified", p.Name)
+	}
+
+	vector, err := d.embedSvc.GetEmbedding(context.Background(), p.Text)
+	if err != nil {
+		return fmt.Errorf("failed to embed pattern '%s': %w", p.Name, err)
+	}
+	p.Embedding = vector
+	p.EmbeddingModel = d.embedSvc.Model()
+	p.Source = "api"
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.patterns[patternKey{p.Workspace, p.Name}] = p
+	return d.saveLocked()
+}
+
+// Delete removes an API-registered pattern of a workspace.
+func (d *Detector) Delete(workspace, name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	existing, ok := d.patterns[patternKey{workspace, name}]
+	if !ok {
+		if _, shared := d.patterns[patternKey{name: name}]; shared && workspace != "" {
+			return errs.Errorf(errs.ErrInvalidRequest, "pattern '%s' is shared by all workspaces and cannot be deleted from workspace '%s'", name, workspace)
+		}
+		return fmt.Errorf("%w: %s", ErrPatternNotFound, name)
+	}
+	if existing.Source == "config" {
+		return errs.Errorf(errs.ErrInvalidRequest, "pattern '%s' is defined in the config file and cannot be deleted", name)
+	}
+	delete(d.patterns, patternKey{workspace, name})
+	return d.saveLocked()
+}
+
+// DeleteWorkspace removes the patterns of a workspace and their alerts, and returns how many
+// patterns there were.
+func (d *Detector) DeleteWorkspace(workspace string) (int, error) {
+	if workspace == "" {
+		return 0, nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := 0
+	for k := range d.patterns {
+		if k.workspace == workspace {
+			delete(d.patterns, k)
+			n++
+		}
+	}
+	recent := d.recent[:0]
+	for _, a := range d.recent {
+		if a.Workspace != workspace {
+			recent = append(recent, a)
+		}
+	}
+	d.recent = recent
+	if n == 0 {
+		return 0, nil
+	}
+	return n, d.saveLocked()
+}
+
+// Alerts returns the most recent alerts of the patterns of a workspace and of the shared
+// patterns, newest first.
+func (d *Detector) Alerts(workspace string) []Alert {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	alerts := make([]Alert, 0, len(d.recent))
+	for i := len(d.recent) - 1; i >= 0; i-- {
+		if a := d.recent[i]; a.Workspace == "" || a.Workspace == workspace {
+			alerts = append(alerts, a)
+		}
+	}
+	return alerts
+}
+
+// Check compares a newly embedded window with every pattern of its topic and raises an alert
+// for each one it is at least as similar to as the pattern's threshold.
+func (d *Detector) Check(ew *window.EmbeddedWindow) []Alert {
+	if d == nil {
+		return nil
+	}
+	d.embedStale()
+
+	d.mu.RLock()
+	var alerts []Alert
+	for _, p := range d.patterns {
+		if p.EmbeddingModel != ew.EmbeddingModel || len(p.Embedding) != len(ew.Embedding) || !appliesTo(p, ew.Topic) {
+			continue
+		}
+		threshold := p.Threshold
+		if threshold == 0 {
+			threshold = d.threshold
+		}
+		similarity := cosineSimilarity(p.Embedding, ew.Embedding)
+		if similarity < threshold {
+			continue
+		}
+		alerts = append(alerts, Alert{
+			Pattern:    p.Name,
+			Workspace:  p.Workspace,
+			Similarity: similarity,
+			Threshold:  threshold,
+			WindowID:   ew.WindowID,
+			Topic:      ew.Topic,
+			Partition:  ew.Partition,
+			StartTime:  ew.StartTime,
+			EndTime:    ew.EndTime,
+			Category:   ew.Category,
+			At:         time.Now().UTC(),
+		})
+	}
+	d.mu.RUnlock()
+	if len(alerts) == 0 {
+		return nil
+	}
+
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Similarity > alerts[j].Similarity })
+	d.mu.Lock()
+	d.recent = append(d.recent, alerts...)
+	if len(d.recent) > maxRecentAlerts {
+		d.recent = d.recent[len(d.recent)-maxRecentAlerts:]
+	}
+	d.mu.Unlock()
+
+	for _, a := range alerts {
+		alertsTotal.Inc("pattern", a.Pattern, "topic", a.Topic)
+		log.Printf("ALERT: window %s of topic %s matches pattern '%s' (similarity %.3f >= %.3f)", a.WindowID, a.Topic, a.Pattern, a.Similarity, a.Threshold)
+		if d.webhookURL != "" {
+			go d.notify(a)
+		}
+	}
+	return alerts
+}
+
+// embedStale embeds patterns that have no embedding of the current model yet.
+func (d *Detector) embedStale() {
+	model := d.embedSvc.Model()
+	d.mu.RLock()
+	var stale []*Pattern
+	for _, p := range d.patterns {
+		if p.EmbeddingModel != model || len(p.Embedding) == 0 {
+			stale = append(stale, p)
+		}
+	}
+	d.mu.RUnlock()
+	if len(stale) == 0 {
+		return
+	}
+
+	d.embedMu.Lock()
+	defer d.embedMu.Unlock()
+	updated := false
+	for _, p := range stale {
+		vector, err := d.embedSvc.GetEmbedding(context.Background(), p.Text)
+		if err != nil {
+			log.Printf("Error embedding pattern '%s': %v", p.Name, err)
+			continue
+		}
+		embedded := *p
+		embedded.Embedding = vector
+		embedded.EmbeddingModel = model
+		d.mu.Lock()
+		if k := (patternKey{p.Workspace, p.Name}); d.patterns[k] == p { // Not replaced or deleted meanwhile
+			d.patterns[k] = &embedded
+			updated = updated || p.Source == "api"
+		}
+		d.mu.Unlock()
+	}
+	if updated {
+		d.mu.Lock()
+		if err := d.saveLocked(); err != nil {
+			log.Printf("Error saving re-embedded patterns: %v", err)
+		}
+		d.mu.Unlock()
+	}
+}
+
+// notify posts the alert to the configured webhook.
+func (d *Detector) notify(a Alert) {
+	body, err := json.Marshal(a)
+	if err != nil {
+		log.Printf("Error marshalling alert for pattern '%s': %v", a.Pattern, err)
+		return
+	}
+	resp, err := d.httpClient.Post(d.webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Error sending alert for pattern '%s' to webhook: %v", a.Pattern, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Alert webhook returned status %d for pattern '%s'", resp.StatusCode, a.Pattern)
+	}
+}
+
+func (d *Detector) saveLocked() error {
+	if d.path == "" {
+		return nil
+	}
+	saved := make([]savedPattern, 0, len(d.patterns))
+	for _, p := range d.patterns {
+		if p.Source == "api" {
+			saved = append(saved, savedPattern{Pattern: p, Embedding: p.Embedding})
+		}
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal patterns: %w", err)
+	}
+	if err := atrest.WriteFile(d.path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write patterns file: %w", err)
+	}
+	return nil
+}
+
+func appliesTo(p *Pattern, topic string) bool {
+	if len(p.Topics) == 0 {
+		return true
+	}
+	for _, t := range p.Topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,421 @@
+package schemaregistry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// avroSchema is a parsed Avro schema node.
+type avroSchema struct {
+	kind     string // Primitive name, or record, enum, array, map, union or fixed
+	name     string // Full name of named types
+	fields   []avroField
+	symbols  []string
+	items    *avroSchema // array
+	values   *avroSchema // map
+	branches []*avroSchema
+	size     int // fixed
+	logical  string
+	scale    int // decimal
+}
+
+type avroField struct {
+	name   string
+	schema *avroSchema
+}
+
+// avroNames holds the named types known while parsing a schema and its references.
+type avroNames map[string]*avroSchema
+
+// parseAvro parses an Avro schema in its JSON form. Named types it defines are added to
+// names, so schemas referencing them can be parsed afterwards.
+func parseAvro(schema string, names avroNames) (*avroSchema, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(schema), &v); err != nil {
+		// A bare primitive name like "string" is valid JSON; anything else is not a schema
+		return nil, fmt.Errorf("invalid Avro schema: %w", err)
+	}
+	return names.parse(v, "")
+}
+
+var avroPrimitives = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true,
+	"float": true, "double": true, "bytes": true, "string": true,
+}
+
+func (names avroNames) parse(v interface{}, namespace string) (*avroSchema, error) {
+	switch t := v.(type) {
+	case string:
+		if avroPrimitives[t] {
+			return &avroSchema{kind: t}, nil
+		}
+		if s, ok := names[fullName(t, namespace)]; ok {
+			return s, nil
+		}
+		if s, ok := names[t]; ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unknown Avro type %q", t)
+	case []interface{}:
+		union := &avroSchema{kind: "union"}
+		for _, b := range t {
+			branch, err := names.parse(b, namespace)
+			if err != nil {
+				return nil, err
+			}
+			union.branches = append(union.branches, branch)
+		}
+		return union, nil
+	case map[string]interface{}:
+		return names.parseComplex(t, namespace)
+	default:
+		return nil, fmt.Errorf("invalid Avro schema node %v", v)
+	}
+}
+
+func (names avroNames) parseComplex(t map[string]interface{}, namespace string) (*avroSchema, error) {
+	typ, ok := t["type"].(string)
+	if !ok {
+		// {"type": {...}} or {"type": [...]} wraps another schema
+		return names.parse(t["type"], namespace)
+	}
+
+	switch typ {
+	case "record", "error", "enum", "fixed":
+		name, _ := t["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("Avro %s without a name", typ)
+		}
+		if ns, ok := t["namespace"].(string); ok && !strings.Contains(name, ".") {
+			namespace = ns
+		}
+		s := &avroSchema{kind: typ, name: fullName(name, namespace)}
+		if typ == "error" {
+			s.kind = "record"
+		}
+		if i := strings.LastIndex(s.name, "."); i >= 0 {
+			namespace = s.name[:i]
+		}
+		// Register before parsing fields so recursive types resolve
+		names[s.name] = s
+		switch s.kind {
+		case "record":
+			fields, _ := t["fields"].([]interface{})
+			for _, f := range fields {
+				field, ok := f.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("invalid field in Avro record %s", s.name)
+				}
+				fieldName, _ := field["name"].(string)
+				fieldSchema, err := names.parse(field["type"], namespace)
+				if err != nil {
+					return nil, fmt.Errorf("field %s.%s: %w", s.name, fieldName, err)
+				}
+				s.fields = append(s.fields, avroField{name: fieldName, schema: fieldSchema})
+			}
+		case "enum":
+			symbols, _ := t["symbols"].([]interface{})
+			for _, sym := range symbols {
+				name, _ := sym.(string)
+				s.symbols = append(s.symbols, name)
+			}
+		case "fixed":
+			size, _ := t["size"].(float64)
+			s.size = int(size)
+			s.logical, _ = t["logicalType"].(string)
+			scale, _ := t["scale"].(float64)
+			s.scale = int(scale)
+		}
+		return s, nil
+	case "array":
+		items, err := names.parse(t["items"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		return &avroSchema{kind: "array", items: items}, nil
+	case "map":
+		values, err := names.parse(t["values"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		return &avroSchema{kind: "map", values: values}, nil
+	default:
+		base, err := names.parse(typ, namespace)
+		if err != nil {
+			return nil, err
+		}
+		logical, _ := t["logicalType"].(string)
+		if logical == "" || !avroPrimitives[base.kind] {
+			return base, nil
+		}
+		scale, _ := t["scale"].(float64)
+		return &avroSchema{kind: base.kind, logical: logical, scale: int(scale)}, nil
+	}
+}
+
+func fullName(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+// avroCodec decodes Avro binary data with goavro and renders the datum with the parsed
+// schema, so records keep their field order and logical types become readable values.
+type avroCodec struct {
+	schema *avroSchema
+	codec  *goavro.Codec
+}
+
+// newAvroCodec parses an Avro schema in its JSON form, after the schemas of its references,
+// which define the named types it uses.
+func newAvroCodec(schema string, references []string) (*avroCodec, error) {
+	names := make(avroNames)
+	for _, ref := range references {
+		if _, err := parseAvro(ref, names); err != nil {
+			return nil, err
+		}
+	}
+	s, err := parseAvro(schema, names)
+	if err != nil {
+		return nil, err
+	}
+	// goavro resolves no registry references, so it gets the schema with the referenced
+	// types inlined. Logical types are left out and rendered from the raw values instead.
+	spec, err := json.Marshal(s.goavroSchema(make(map[string]bool)))
+	if err != nil {
+		return nil, err
+	}
+	codec, err := goavro.NewCodec(string(spec))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Avro schema: %w", err)
+	}
+	return &avroCodec{schema: s, codec: codec}, nil
+}
+
+// decode decodes one datum into JSON-friendly values.
+func (c *avroCodec) decode(data []byte) (interface{}, error) {
+	native, rest, err := c.codec.NativeFromBinary(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%d trailing bytes after Avro datum", len(rest))
+	}
+	return c.schema.render(native)
+}
+
+// goavroSchema returns the schema in its JSON form with full names and without logical
+// types. Named types are defined where first used and referenced by name afterwards.
+func (s *avroSchema) goavroSchema(defined map[string]bool) interface{} {
+	switch s.kind {
+	case "record", "enum", "fixed":
+		if defined[s.name] {
+			return s.name
+		}
+		defined[s.name] = true
+		t := map[string]interface{}{"type": s.kind, "name": s.name}
+		switch s.kind {
+		case "record":
+			fields := make([]interface{}, len(s.fields))
+			for i, f := range s.fields {
+				fields[i] = map[string]interface{}{"name": f.name, "type": f.schema.goavroSchema(defined)}
+			}
+			t["fields"] = fields
+		case "enum":
+			t["symbols"] = s.symbols
+		case "fixed":
+			t["size"] = s.size
+		}
+		return t
+	case "union":
+		branches := make([]interface{}, len(s.branches))
+		for i, b := range s.branches {
+			branches[i] = b.goavroSchema(defined)
+		}
+		return branches
+	case "array":
+		return map[string]interface{}{"type": "array", "items": s.items.goavroSchema(defined)}
+	case "map":
+		return map[string]interface{}{"type": "map", "values": s.values.goavroSchema(defined)}
+	default:
+		return s.kind
+	}
+}
+
+// render converts a datum decoded by goavro into JSON-friendly values.
+func (s *avroSchema) render(v interface{}) (interface{}, error) {
+	switch s.kind {
+	case "int":
+		n, ok := v.(int32)
+		if !ok {
+			return nil, fmt.Errorf("unexpected Avro int %T", v)
+		}
+		return avroLogicalInt(s, int64(n)), nil
+	case "long":
+		n, ok := v.(int64)
+		if !ok {
+			return nil, fmt.Errorf("unexpected Avro long %T", v)
+		}
+		return avroLogicalInt(s, n), nil
+	case "float":
+		f, ok := v.(float32)
+		if !ok {
+			return nil, fmt.Errorf("unexpected Avro float %T", v)
+		}
+		return jsonFloat(float64(f)), nil
+	case "double":
+		f, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("unexpected Avro double %T", v)
+		}
+		return jsonFloat(f), nil
+	case "bytes", "fixed":
+		b, ok := v.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("unexpected Avro %s %T", s.kind, v)
+		}
+		return avroBytes(s, b), nil
+	case "union":
+		if v == nil {
+			return nil, nil
+		}
+		// goavro wraps the value of non-null branches in a map keyed by the branch's name
+		wrapped, ok := v.(map[string]interface{})
+		if !ok || len(wrapped) != 1 {
+			return nil, fmt.Errorf("unexpected Avro union value %T", v)
+		}
+		for name, value := range wrapped {
+			for _, b := range s.branches {
+				if name == b.name || b.name == "" && name == b.kind {
+					return b.render(value)
+				}
+			}
+			return nil, fmt.Errorf("unknown Avro union branch %q", name)
+		}
+	case "record":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unexpected Avro record %T", v)
+		}
+		// Field order is kept so the rendered context follows the schema
+		record := make(orderedObject, 0, len(s.fields))
+		for _, f := range s.fields {
+			value, err := f.schema.render(m[f.name])
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", s.name, f.name, err)
+			}
+			record = append(record, objectField{f.name, value})
+		}
+		return record, nil
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unexpected Avro array %T", v)
+		}
+		rendered := make([]interface{}, len(items))
+		for i, item := range items {
+			value, err := s.items.render(item)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = value
+		}
+		return rendered, nil
+	case "map":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unexpected Avro map %T", v)
+		}
+		rendered := make(map[string]interface{}, len(m))
+		for key, item := range m {
+			value, err := s.values.render(item)
+			if err != nil {
+				return nil, err
+			}
+			rendered[key] = value
+		}
+		return rendered, nil
+	}
+	// null, boolean, string and enum symbols are used as they are
+	return v, nil
+}
+
+// avroLogicalInt renders timestamps and dates readably; other integers stay numbers.
+func avroLogicalInt(s *avroSchema, n int64) interface{} {
+	switch s.logical {
+	case "timestamp-millis", "local-timestamp-millis":
+		return time.UnixMilli(n).UTC().Format(time.RFC3339Nano)
+	case "timestamp-micros", "local-timestamp-micros":
+		return time.UnixMicro(n).UTC().Format(time.RFC3339Nano)
+	case "date":
+		return time.Unix(n*86400, 0).UTC().Format("2006-01-02")
+	}
+	return n
+}
+
+// avroBytes renders decimals as numbers, UTF-8 text as a string and other bytes as base64.
+func avroBytes(s *avroSchema, b []byte) interface{} {
+	if s.logical == "decimal" {
+		unscaled := new(big.Int).SetBytes(b)
+		if len(b) > 0 && b[0]&0x80 != 0 { // Two's complement
+			unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+		}
+		value := new(big.Float).SetInt(unscaled)
+		if s.scale > 0 {
+			value.Quo(value, new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(s.scale)), nil)))
+		}
+		return json.Number(value.Text('f', s.scale))
+	}
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// jsonFloat keeps NaN and infinities, which JSON cannot represent, as strings.
+func jsonFloat(f float64) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Sprintf("%v", f)
+	}
+	return f
+}
+
+// orderedObject is a JSON object that keeps its fields in schema order.
+type orderedObject []objectField
+
+type objectField struct {
+	name  string
+	value interface{}
+}
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		name, err := json.Marshal(f.name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		sb.Write(name)
+		sb.WriteByte(':')
+		sb.Write(value)
+	}
+	sb.WriteByte('}')
+	return []byte(sb.String()), nil
+}
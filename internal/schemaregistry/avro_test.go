@@ -0,0 +1,220 @@
+package schemaregistry
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+const orderAvro = `{
+	"type": "record", "name": "Order", "namespace": "shop.v1",
+	"fields": [
+		{"name": "id", "type": "string"},
+		{"name": "paid", "type": "boolean"},
+		{"name": "quantity", "type": "int"},
+		{"name": "total_minor", "type": "long"},
+		{"name": "weight", "type": "float"},
+		{"name": "amount", "type": "double"},
+		{"name": "signature", "type": "bytes"},
+		{"name": "note", "type": ["null", "string"]},
+		{"name": "status", "type": {"type": "enum", "name": "Status", "symbols": ["PENDING", "PAID", "SHIPPED"]}},
+		{"name": "checksum", "type": {"type": "fixed", "name": "Checksum", "size": 4}},
+		{"name": "items", "type": {"type": "array", "items": {
+			"type": "record", "name": "Item",
+			"fields": [{"name": "sku", "type": "string"}, {"name": "quantity", "type": "int"}]
+		}}},
+		{"name": "attributes", "type": {"type": "map", "values": "long"}},
+		{"name": "created_at", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+		{"name": "delivery_date", "type": {"type": "int", "logicalType": "date"}},
+		{"name": "price", "type": {"type": "bytes", "logicalType": "decimal", "precision": 10, "scale": 2}},
+		{"name": "gift", "type": ["null", "shop.v1.Item"]}
+	]
+}`
+
+// order returns the native goavro form of an Order with the given fields replaced.
+func order(overrides map[string]interface{}) map[string]interface{} {
+	o := map[string]interface{}{
+		"id":            "o-1",
+		"paid":          true,
+		"quantity":      int32(-3),
+		"total_minor":   int64(-9007199254740993),
+		"weight":        float32(0.5),
+		"amount":        1234.5,
+		"signature":     []byte{0xde, 0xad, 0xbe, 0xef},
+		"note":          goavro.Union("string", "leave at the door"),
+		"status":        "SHIPPED",
+		"checksum":      []byte("ab\x00c"),
+		"items":         []interface{}{map[string]interface{}{"sku": "A-1", "quantity": int32(2)}, map[string]interface{}{"sku": "Ünï", "quantity": int32(0)}},
+		"attributes":    map[string]interface{}{"weight_g": int64(1200), "boxes": int64(1)},
+		"created_at":    time.Date(2026, 3, 1, 9, 30, 0, 250e6, time.UTC),
+		"delivery_date": time.Date(2026, 3, 4, 0, 0, 0, 0, time.UTC),
+		"price":         big.NewRat(123456, 100),
+		"gift":          goavro.Union("shop.v1.Item", map[string]interface{}{"sku": "G-1", "quantity": int32(1)}),
+	}
+	for k, v := range overrides {
+		o[k] = v
+	}
+	return o
+}
+
+// TestAvroConformance decodes payloads encoded by goavro and checks the JSON rendered for them.
+func TestAvroConformance(t *testing.T) {
+	codec, err := goavro.NewCodec(orderAvro)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := newAvroCodec(orderAvro, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		datum map[string]interface{}
+		want  string
+	}{
+		{
+			name:  "every type",
+			datum: order(nil),
+			want: `{"id":"o-1","paid":true,"quantity":-3,"total_minor":-9007199254740993,"weight":0.5,` +
+				`"amount":1234.5,"signature":"3q2+7w==","note":"leave at the door","status":"SHIPPED",` +
+				`"checksum":"ab\u0000c","items":[{"sku":"A-1","quantity":2},{"sku":"Ünï","quantity":0}],` +
+				`"attributes":{"boxes":1,"weight_g":1200},"created_at":"2026-03-01T09:30:00.25Z",` +
+				`"delivery_date":"2026-03-04","price":1234.56,"gift":{"sku":"G-1","quantity":1}}`,
+		},
+		{
+			name: "null branches and empty blocks",
+			datum: order(map[string]interface{}{
+				"paid": false, "note": nil, "gift": nil, "items": []interface{}{}, "attributes": map[string]interface{}{},
+				"signature": []byte{}, "status": "PENDING",
+			}),
+			want: `{"id":"o-1","paid":false,"quantity":-3,"total_minor":-9007199254740993,"weight":0.5,` +
+				`"amount":1234.5,"signature":"","note":null,"status":"PENDING","checksum":"ab\u0000c","items":[],` +
+				`"attributes":{},"created_at":"2026-03-01T09:30:00.25Z","delivery_date":"2026-03-04","price":1234.56,"gift":null}`,
+		},
+		{
+			name: "dates before the epoch and a negative decimal",
+			datum: order(map[string]interface{}{
+				"created_at":    time.Date(1969, 12, 31, 23, 59, 59, 0, time.UTC),
+				"delivery_date": time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC),
+				"price":         big.NewRat(-5, 100),
+			}),
+			want: `{"id":"o-1","paid":true,"quantity":-3,"total_minor":-9007199254740993,"weight":0.5,` +
+				`"amount":1234.5,"signature":"3q2+7w==","note":"leave at the door","status":"SHIPPED",` +
+				`"checksum":"ab\u0000c","items":[{"sku":"A-1","quantity":2},{"sku":"Ünï","quantity":0}],` +
+				`"attributes":{"boxes":1,"weight_g":1200},"created_at":"1969-12-31T23:59:59Z",` +
+				`"delivery_date":"1969-12-31","price":-0.05,"gift":{"sku":"G-1","quantity":1}}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			payload, err := codec.BinaryFromNative(nil, tc.datum)
+			if err != nil {
+				t.Fatalf("reference encoder rejected the datum: %v", err)
+			}
+			value, err := decoder.decode(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("decoded\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestAvroBlocks checks arrays and maps written in several blocks, including blocks with a
+// negative count followed by their size in bytes, which goavro does not write but accepts.
+func TestAvroBlocks(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		schema  string
+		payload []byte
+		want    string
+	}{
+		{
+			name:    "array in two blocks",
+			schema:  `{"type": "array", "items": "long"}`,
+			payload: []byte{0x02, 0x02, 0x04, 0x04, 0x06, 0x00}, // [1], [2, 3]
+			want:    `[1,2,3]`,
+		},
+		{
+			name:    "array block with its size",
+			schema:  `{"type": "array", "items": "long"}`,
+			payload: []byte{0x03, 0x04, 0x02, 0x04, 0x00}, // -2 items in 2 bytes: [1, 2]
+			want:    `[1,2]`,
+		},
+		{
+			name:    "map block with its size",
+			schema:  `{"type": "map", "values": "int"}`,
+			payload: []byte{0x03, 0x08, 0x02, 'a', 0x02, 0x02, 'b', 0x04, 0x00}, // -2 entries in 8 bytes
+			want:    `{"a":1,"b":2}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			codec, err := goavro.NewCodec(tc.schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			native, _, err := codec.NativeFromBinary(tc.payload)
+			if err != nil {
+				t.Fatalf("reference decoder rejected the payload: %v", err)
+			}
+			reference, err := json.Marshal(native)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(reference) != tc.want {
+				t.Fatalf("reference decoder read %s, want %s", reference, tc.want)
+			}
+
+			decoder, err := newAvroCodec(tc.schema, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			value, err := decoder.decode(tc.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("decoded %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeAvroMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		schema  string
+		payload []byte
+	}{
+		{"trailing bytes", `"long"`, []byte{0x02, 0x00}},
+		{"truncated string", `"string"`, []byte{0x0a, 'a', 'b'}},
+		{"negative length", `"bytes"`, []byte{0x01}},
+		{"enum index out of range", `{"type": "enum", "name": "E", "symbols": ["A"]}`, []byte{0x02}},
+		{"union index out of range", `["null", "long"]`, []byte{0x04}},
+		{"truncated fixed", `{"type": "fixed", "name": "F", "size": 4}`, []byte{1, 2}},
+		{"unterminated array", `{"type": "array", "items": "long"}`, []byte{0x02, 0x02}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			decoder, err := newAvroCodec(tc.schema, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v, err := decoder.decode(tc.payload); err == nil {
+				t.Errorf("decoded %v, want an error", v)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package schemaregistry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"stream-rag-agent/internal/config"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// ValueFormat is the kafka.topics[].value_format of topics whose values are decoded through the registry.
+const ValueFormat = "schema_registry"
+
+// Schema types as reported by the registry; an empty type means Avro.
+const (
+	TypeAvro       = "AVRO"
+	TypeProtobuf   = "PROTOBUF"
+	TypeJSONSchema = "JSON"
+)
+
+// registrySchema is a schema as returned by /schemas/ids/{id} and /subjects/{subject}/versions/{version}.
+type registrySchema struct {
+	Schema     string      `json:"schema"`
+	SchemaType string      `json:"schemaType"`
+	References []reference `json:"references"`
+}
+
+type reference struct {
+	Name    string `json:"name"` // Avro: full name of the referenced type; Protobuf: import path
+	Subject string `json:"subject"`
+	Version int    `json:"version"`
+}
+
+// Client fetches schemas from a Confluent-compatible Schema Registry. Schemas are immutable
+// per ID, so they are cached for the life of the process.
+type Client struct {
+	baseURL    string
+	username   string
+	password   string
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	byID      map[int]*registrySchema
+	bySubject map[string]*registrySchema // "subject/version" -> schema, for references
+}
+
+func NewClient(cfg config.SchemaRegistryConfig) (*Client, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("kafka.schema_registry.url is required for topics with value_format schema_registry")
+	}
+	timeout := time.Duration(cfg.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Client{
+		baseURL:    strings.TrimRight(cfg.URL, "/"),
+		username:   cfg.Username,
+		password:   cfg.Password,
+		httpClient: &http.Client{Timeout: timeout},
+		byID:       make(map[int]*registrySchema),
+		bySubject:  make(map[string]*registrySchema),
+	}, nil
+}
+
+// schemaByID returns the schema registered under id.
+func (c *Client) schemaByID(id int) (*registrySchema, error) {
+	c.mu.Lock()
+	s, ok := c.byID[id]
+	c.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+	s, err := c.get(fmt.Sprintf("/schemas/ids/%d", id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch schema %d: %w", id, err)
+	}
+	c.mu.Lock()
+	c.byID[id] = s
+	c.mu.Unlock()
+	return s, nil
+}
+
+// referenced returns the schema a reference points to.
+func (c *Client) referenced(ref reference) (*registrySchema, error) {
+	key := fmt.Sprintf("%s/%d", ref.Subject, ref.Version)
+	c.mu.Lock()
+	s, ok := c.bySubject[key]
+	c.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+	s, err := c.get(fmt.Sprintf("/subjects/%s/versions/%d", url.PathEscape(ref.Subject), ref.Version))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch referenced schema %s version %d: %w", ref.Subject, ref.Version, err)
+	}
+	c.mu.Lock()
+	c.bySubject[key] = s
+	c.mu.Unlock()
+	return s, nil
+}
+
+func (c *Client) get(path string) (*registrySchema, error) {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("schema registry returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var s registrySchema
+	if err := json.Unmarshal(body, &s); err != nil {
+		return nil, fmt.Errorf("invalid schema registry response: %w", err)
+	}
+	if s.SchemaType == "" {
+		s.SchemaType = TypeAvro
+	}
+	return &s, nil
+}
+
+// withReferences calls visit for every schema s references, depth first, so referenced
+// types are known before the schema that uses them is parsed.
+func (c *Client) withReferences(s *registrySchema, visit func(ref reference, rs *registrySchema) error) error {
+	seen := make(map[string]bool)
+	var walk func(s *registrySchema) error
+	walk = func(s *registrySchema) error {
+		for _, ref := range s.References {
+			key := fmt.Sprintf("%s/%d", ref.Subject, ref.Version)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			rs, err := c.referenced(ref)
+			if err != nil {
+				return err
+			}
+			if err := walk(rs); err != nil {
+				return err
+			}
+			if err := visit(ref, rs); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(s)
+}
@@ -0,0 +1,150 @@
+package schemaregistry
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"stream-rag-agent/internal/metrics"
+)
+
+// headerSize is the Confluent wire format header: magic byte 0 and a big-endian schema ID.
+const headerSize = 5
+
+var decodeFailuresTotal = metrics.NewCounter("schema_registry_decode_failures_total", "Schema Registry payloads that could not be decoded into JSON, by topic.")
+
+// Decoder converts payloads in the Confluent wire format into JSON, so they are rendered into
+// window contexts like any JSON message.
+type Decoder struct {
+	client *Client
+
+	mu      sync.Mutex
+	schemas map[int]*parsedSchema
+}
+
+// parsedSchema is a registry schema parsed together with its references.
+type parsedSchema struct {
+	schemaType string
+	avro       *avroCodec
+	proto      *protoFile
+}
+
+func NewDecoder(client *Client) *Decoder {
+	return &Decoder{client: client, schemas: make(map[int]*parsedSchema)}
+}
+
+// Decode returns the JSON form of a Schema Registry framed payload of the topic.
+func (d *Decoder) Decode(topic string, payload []byte) ([]byte, error) {
+	value, err := d.decode(payload)
+	if err != nil {
+		decodeFailuresTotal.Inc("topic", topic)
+		return nil, err
+	}
+	return value, nil
+}
+
+func (d *Decoder) decode(payload []byte) ([]byte, error) {
+	if len(payload) < headerSize || payload[0] != 0 {
+		return nil, fmt.Errorf("payload is not in the Schema Registry wire format")
+	}
+	id := int(binary.BigEndian.Uint32(payload[1:headerSize]))
+	s, err := d.schema(id)
+	if err != nil {
+		return nil, err
+	}
+	data := payload[headerSize:]
+
+	var value interface{}
+	switch s.schemaType {
+	case TypeJSONSchema:
+		return data, nil
+	case TypeAvro:
+		if value, err = s.avro.decode(data); err != nil {
+			return nil, fmt.Errorf("failed to decode Avro payload of schema %d: %w", id, err)
+		}
+	case TypeProtobuf:
+		indexes, n, err := messageIndexes(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode Protobuf payload of schema %d: %w", id, err)
+		}
+		m, err := s.proto.messageAt(indexes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode Protobuf payload of schema %d: %w", id, err)
+		}
+		if value, err = decodeProto(m, data[n:]); err != nil {
+			return nil, fmt.Errorf("failed to decode Protobuf payload of schema %d: %w", id, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported schema type %s of schema %d", s.schemaType, id)
+	}
+	return json.Marshal(value)
+}
+
+// schema returns the parsed schema with the given ID, fetching it and its references on first use.
+func (d *Decoder) schema(id int) (*parsedSchema, error) {
+	d.mu.Lock()
+	s, ok := d.schemas[id]
+	d.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+
+	rs, err := d.client.schemaByID(id)
+	if err != nil {
+		return nil, err
+	}
+	s = &parsedSchema{schemaType: rs.SchemaType}
+	switch rs.SchemaType {
+	case TypeAvro:
+		var references []string
+		err = d.client.withReferences(rs, func(_ reference, ref *registrySchema) error {
+			references = append(references, ref.Schema)
+			return nil
+		})
+		if err == nil {
+			s.avro, err = newAvroCodec(rs.Schema, references)
+		}
+	case TypeProtobuf:
+		types := newProtoTypes()
+		err = d.client.withReferences(rs, func(_ reference, ref *registrySchema) error {
+			_, err := types.parse(ref.Schema)
+			return err
+		})
+		if err == nil {
+			s.proto, err = types.parse(rs.Schema)
+		}
+		if err == nil {
+			err = types.resolve()
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("schema %d: %w", id, err)
+	}
+
+	d.mu.Lock()
+	d.schemas[id] = s
+	d.mu.Unlock()
+	return s, nil
+}
+
+// messageIndexes reads the Protobuf message indexes that follow the schema ID: a zigzag
+// varint count and as many zigzag varint indexes, where a count of 0 stands for [0].
+// It returns the indexes and the number of bytes read.
+func messageIndexes(data []byte) ([]int, int, error) {
+	count, n := binary.Varint(data)
+	if n <= 0 || count < 0 || count > int64(len(data)) {
+		return nil, 0, fmt.Errorf("invalid message indexes")
+	}
+	pos := n
+	indexes := make([]int, 0, count)
+	for i := int64(0); i < count; i++ {
+		index, n := binary.Varint(data[pos:])
+		if n <= 0 {
+			return nil, 0, fmt.Errorf("invalid message indexes")
+		}
+		indexes = append(indexes, int(index))
+		pos += n
+	}
+	return indexes, pos, nil
+}
@@ -0,0 +1,127 @@
+package schemaregistry
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/linkedin/goavro/v2"
+
+	"stream-rag-agent/internal/config"
+)
+
+const (
+	itemAvro     = `{"type": "record", "name": "Item", "namespace": "shop.v1", "fields": [{"name": "sku", "type": "string"}, {"name": "quantity", "type": "int"}]}`
+	shipmentAvro = `{"type": "record", "name": "Shipment", "namespace": "shop.v1", "fields": [{"name": "carrier", "type": "string"}, {"name": "items", "type": {"type": "array", "items": "Item"}}]}`
+)
+
+// fakeRegistry serves the schemas of the tests and counts the requests per path.
+type fakeRegistry struct {
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests[r.URL.Path]++
+	f.mu.Unlock()
+
+	schemas := map[string]registrySchema{
+		"/schemas/ids/1": {
+			Schema:     shipmentAvro,
+			References: []reference{{Name: "shop.v1.Item", Subject: "shop.v1.Item", Version: 3}},
+		},
+		"/subjects/shop.v1.Item/versions/3": {Schema: itemAvro},
+		"/schemas/ids/2":                    {Schema: paymentsProto, SchemaType: TypeProtobuf},
+		"/schemas/ids/3":                    {Schema: `{"type": "object"}`, SchemaType: TypeJSONSchema},
+	}
+	s, ok := schemas[r.URL.Path]
+	if !ok {
+		http.Error(w, `{"error_code":40403,"message":"Schema not found"}`, http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(s)
+}
+
+// frame prepends the Confluent wire format header and, for Protobuf, the message indexes.
+func frame(id uint32, indexes []int, data []byte) []byte {
+	b := binary.BigEndian.AppendUint32([]byte{0}, id)
+	if indexes != nil {
+		b = binary.AppendVarint(b, int64(len(indexes)))
+		for _, i := range indexes {
+			b = binary.AppendVarint(b, int64(i))
+		}
+	}
+	return append(b, data...)
+}
+
+func TestDecoder(t *testing.T) {
+	registry := &fakeRegistry{requests: make(map[string]int)}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	client, err := NewClient(config.SchemaRegistryConfig{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDecoder(client)
+
+	// goavro resolves no registry references, so it gets the schema with Item inlined
+	codec, err := goavro.NewCodec(`{"type": "record", "name": "Shipment", "namespace": "shop.v1", "fields": [
+		{"name": "carrier", "type": "string"}, {"name": "items", "type": {"type": "array", "items": ` + itemAvro + `}}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shipment, err := codec.BinaryFromNative(nil, map[string]interface{}{
+		"carrier": "DHL",
+		"items":   []interface{}{map[string]interface{}{"sku": "A-1", "quantity": int32(2)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := paymentsFiles(t, paymentsDescriptor())
+	transaction := encodeProto(t, files, "payments.v1.Transaction", `{"id": "tx-1", "status": "STATUS_DECLINED"}`)
+	refund := encodeProto(t, files, "payments.v1.Refund", `{"transactionId": "tx-1", "amount": 10}`)
+	reason := encodeProto(t, files, "payments.v1.Refund.Reason", `{"code": "fraud"}`)
+
+	for _, tc := range []struct {
+		name    string
+		payload []byte
+		want    string // Empty if decoding fails
+	}{
+		{"Avro with a reference", frame(1, nil, shipment), `{"carrier":"DHL","items":[{"sku":"A-1","quantity":2}]}`},
+		{"Protobuf first message", frame(2, []int{}, transaction), `{"id":"tx-1","status":"STATUS_DECLINED"}`},
+		{"Protobuf message index", frame(2, []int{1}, refund), `{"transaction_id":"tx-1","amount":10}`},
+		{"Protobuf nested message index", frame(2, []int{1, 0}, reason), `{"code":"fraud"}`},
+		{"JSON Schema", frame(3, nil, []byte(`{"ok":true}`)), `{"ok":true}`},
+		{"Protobuf message index out of range", frame(2, []int{2}, refund), ""},
+		{"unknown schema", frame(4, nil, []byte(`{}`)), ""},
+		{"not framed", []byte(`{"ok":true}`), ""},
+		{"short header", []byte{0, 0, 1}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := d.Decode("shipments", tc.payload)
+			if tc.want == "" {
+				if err == nil {
+					t.Errorf("decoded %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("decoded %s, want %s", got, tc.want)
+			}
+		})
+	}
+
+	// Schemas are immutable per ID, so each is fetched once however many payloads use it
+	for path, n := range registry.requests {
+		if n != 1 && path != "/schemas/ids/4" {
+			t.Errorf("%s was requested %d times, want once", path, n)
+		}
+	}
+}
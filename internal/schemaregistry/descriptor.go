@@ -0,0 +1,78 @@
+package schemaregistry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"stream-rag-agent/internal/metrics"
+)
+
+// ProtobufValueFormat is the kafka.topics[].value_format of topics carrying raw Protobuf
+// values, decoded with a descriptor set instead of the registry.
+const ProtobufValueFormat = "protobuf"
+
+var protobufDecodeFailuresTotal = metrics.NewCounter("protobuf_decode_failures_total", "Raw Protobuf values that could not be decoded into JSON with the topic's descriptor set, by topic.")
+
+// DescriptorDecoder converts raw Protobuf values, without the Schema Registry framing, into
+// JSON with a message type of a compiled descriptor set.
+type DescriptorDecoder struct {
+	message protoreflect.MessageDescriptor
+}
+
+// NewDescriptorDecoder loads the FileDescriptorSet at path, as written by
+// protoc --include_imports --descriptor_set_out, and decodes values as the message type with
+// the fully qualified name messageType (e.g. payments.v1.Transaction).
+func NewDescriptorDecoder(path, messageType string) (*DescriptorDecoder, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read descriptor set: %w", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid descriptor set %s: %w", path, err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("descriptor set %s: %w (was it written with --include_imports?)", path, err)
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(strings.TrimPrefix(messageType, ".")))
+	if err != nil {
+		return nil, fmt.Errorf("message type %q is not in descriptor set %s", messageType, path)
+	}
+	m, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%q in descriptor set %s is not a message type", messageType, path)
+	}
+	return &DescriptorDecoder{message: m}, nil
+}
+
+// Decode returns the protojson form of a raw Protobuf value of the topic, with the field
+// names of the .proto file.
+func (d *DescriptorDecoder) Decode(topic string, payload []byte) ([]byte, error) {
+	msg := dynamicpb.NewMessage(d.message)
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		protobufDecodeFailuresTotal.Inc("topic", topic)
+		return nil, fmt.Errorf("failed to decode Protobuf value as %s: %w", d.message.FullName(), err)
+	}
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	if err != nil {
+		protobufDecodeFailuresTotal.Inc("topic", topic)
+		return nil, fmt.Errorf("failed to render Protobuf value as %s: %w", d.message.FullName(), err)
+	}
+	// protojson varies its whitespace on purpose; compact it so equal values render alike
+	var out bytes.Buffer
+	if err := json.Compact(&out, data); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,786 @@
+package schemaregistry
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// protoTypes holds the message and enum types of parsed .proto files by fully qualified name
+// (without the leading dot).
+type protoTypes struct {
+	messages map[string]*protoMessage
+	enums    map[string]*protoEnum
+}
+
+type protoFile struct {
+	pkg      string
+	messages []*protoMessage // Top-level messages in declaration order, indexed by the wire format
+}
+
+type protoMessage struct {
+	name     string // Fully qualified
+	fields   map[int]*protoField
+	order    []*protoField   // Declaration order, used for output
+	nested   []*protoMessage // In declaration order
+	mapEntry bool
+}
+
+type protoField struct {
+	name     string
+	number   int
+	typeName string // Scalar type or, once resolved, the full name of a message or enum
+	repeated bool
+	scope    string // Full name of the declaring message, for resolving typeName
+	message  *protoMessage
+	enum     *protoEnum
+}
+
+type protoEnum struct {
+	name   string
+	values map[int]string
+}
+
+var protoScalars = map[string]bool{
+	"double": true, "float": true, "int32": true, "int64": true, "uint32": true, "uint64": true,
+	"sint32": true, "sint64": true, "fixed32": true, "fixed64": true, "sfixed32": true, "sfixed64": true,
+	"bool": true, "string": true, "bytes": true,
+}
+
+// wellKnownProto declares the well-known types payloads commonly use without the registry
+// listing them as references. They are rendered specially, see wellKnownValue.
+const wellKnownProto = `
+syntax = "proto3";
+package google.protobuf;
+message Timestamp { int64 seconds = 1; int32 nanos = 2; }
+message Duration { int64 seconds = 1; int32 nanos = 2; }
+message DoubleValue { double value = 1; }
+message FloatValue { float value = 1; }
+message Int64Value { int64 value = 1; }
+message UInt64Value { uint64 value = 1; }
+message Int32Value { int32 value = 1; }
+message UInt32Value { uint32 value = 1; }
+message BoolValue { bool value = 1; }
+message StringValue { string value = 1; }
+message BytesValue { bytes value = 1; }
+`
+
+func newProtoTypes() *protoTypes {
+	t := &protoTypes{messages: make(map[string]*protoMessage), enums: make(map[string]*protoEnum)}
+	if _, err := t.parse(wellKnownProto); err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// parse parses a .proto file and registers its types. Field types are resolved once all
+// files are parsed, see resolve.
+func (t *protoTypes) parse(source string) (*protoFile, error) {
+	p := &protoParser{tokens: tokenizeProto(source), types: t}
+	file, err := p.file()
+	if err != nil {
+		return nil, fmt.Errorf("invalid Protobuf schema: %w", err)
+	}
+	return file, nil
+}
+
+// resolve links every field to its message or enum type.
+func (t *protoTypes) resolve() error {
+	for _, m := range t.messages {
+		for _, f := range m.order {
+			if protoScalars[f.typeName] || f.message != nil || f.enum != nil {
+				continue
+			}
+			name, ok := t.lookup(f.typeName, f.scope)
+			if !ok {
+				return fmt.Errorf("unknown type %q of field %s.%s", f.typeName, m.name, f.name)
+			}
+			f.typeName = name
+			f.message = t.messages[name]
+			f.enum = t.enums[name]
+		}
+	}
+	return nil
+}
+
+// lookup resolves a type name the way protoc does: relative to the declaring scope, then
+// each enclosing scope, unless it is fully qualified with a leading dot.
+func (t *protoTypes) lookup(name, scope string) (string, bool) {
+	if strings.HasPrefix(name, ".") {
+		name = name[1:]
+		_, isMessage := t.messages[name]
+		_, isEnum := t.enums[name]
+		return name, isMessage || isEnum
+	}
+	for {
+		candidate := name
+		if scope != "" {
+			candidate = scope + "." + name
+		}
+		if _, ok := t.messages[candidate]; ok {
+			return candidate, true
+		}
+		if _, ok := t.enums[candidate]; ok {
+			return candidate, true
+		}
+		if scope == "" {
+			return "", false
+		}
+		if i := strings.LastIndex(scope, "."); i >= 0 {
+			scope = scope[:i]
+		} else {
+			scope = ""
+		}
+	}
+}
+
+// messageAt returns the message selected by the Confluent message indexes: the first picks a
+// top-level message of the file, each further index a message nested in the previous one.
+func (f *protoFile) messageAt(indexes []int) (*protoMessage, error) {
+	if len(indexes) == 0 {
+		indexes = []int{0}
+	}
+	list := f.messages
+	var m *protoMessage
+	for _, i := range indexes {
+		if i < 0 || i >= len(list) {
+			return nil, fmt.Errorf("message index %v out of range", indexes)
+		}
+		m = list[i]
+		list = m.nested
+	}
+	return m, nil
+}
+
+// protoParser is a lenient recursive descent parser for the parts of the .proto language
+// that describe message layouts; options, services and extensions are skipped.
+type protoParser struct {
+	tokens []string
+	pos    int
+	types  *protoTypes
+	pkg    string
+}
+
+func (p *protoParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *protoParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *protoParser) expect(want string) error {
+	if got := p.next(); got != want {
+		return fmt.Errorf("expected %q, found %q", want, got)
+	}
+	return nil
+}
+
+// skipStatement skips to the end of the current statement, including any braced body.
+func (p *protoParser) skipStatement() error {
+	for p.pos < len(p.tokens) {
+		switch p.next() {
+		case ";":
+			return nil
+		case "{":
+			return p.skipBlock()
+		}
+	}
+	return fmt.Errorf("unexpected end of schema")
+}
+
+// skipBlock skips tokens up to the brace closing an already consumed "{".
+func (p *protoParser) skipBlock() error {
+	depth := 1
+	for p.pos < len(p.tokens) {
+		switch p.next() {
+		case "{":
+			depth++
+		case "}":
+			depth--
+			if depth == 0 {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("unexpected end of schema")
+}
+
+func (p *protoParser) file() (*protoFile, error) {
+	file := &protoFile{}
+	for p.pos < len(p.tokens) {
+		switch tok := p.next(); tok {
+		case "package":
+			p.pkg = p.next()
+			file.pkg = p.pkg
+			if err := p.expect(";"); err != nil {
+				return nil, err
+			}
+		case "message":
+			m, err := p.message(p.pkg)
+			if err != nil {
+				return nil, err
+			}
+			file.messages = append(file.messages, m)
+		case "enum":
+			if err := p.enum(p.pkg); err != nil {
+				return nil, err
+			}
+		case ";":
+		default: // syntax, edition, import, option, service, extend
+			if err := p.skipStatement(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return file, nil
+}
+
+func qualify(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+func (p *protoParser) message(scope string) (*protoMessage, error) {
+	m := &protoMessage{name: qualify(scope, p.next()), fields: make(map[int]*protoField)}
+	p.types.messages[m.name] = m
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for {
+		switch tok := p.peek(); tok {
+		case "}":
+			p.next()
+			return m, nil
+		case "":
+			return nil, fmt.Errorf("unexpected end of message %s", m.name)
+		case "message":
+			p.next()
+			nested, err := p.message(m.name)
+			if err != nil {
+				return nil, err
+			}
+			m.nested = append(m.nested, nested)
+		case "enum":
+			p.next()
+			if err := p.enum(m.name); err != nil {
+				return nil, err
+			}
+		case "oneof":
+			p.next()
+			p.next() // Name
+			if err := p.expect("{"); err != nil {
+				return nil, err
+			}
+			for p.peek() != "}" && p.peek() != "" {
+				if p.peek() == "option" {
+					if err := p.skipStatement(); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				if err := p.field(m, false); err != nil {
+					return nil, err
+				}
+			}
+			p.next()
+		case "map":
+			if err := p.mapField(m); err != nil {
+				return nil, err
+			}
+		case "option", "reserved", "extensions", "extend", "group", ";":
+			if err := p.skipStatement(); err != nil {
+				return nil, err
+			}
+		case "repeated":
+			p.next()
+			if err := p.field(m, true); err != nil {
+				return nil, err
+			}
+		case "optional", "required":
+			p.next()
+			if err := p.field(m, false); err != nil {
+				return nil, err
+			}
+		default:
+			if err := p.field(m, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// field parses "type name = number [options];".
+func (p *protoParser) field(m *protoMessage, repeated bool) error {
+	typeName, name := p.next(), p.next()
+	if err := p.expect("="); err != nil {
+		return fmt.Errorf("field %s.%s: %w", m.name, name, err)
+	}
+	number, err := strconv.Atoi(p.next())
+	if err != nil {
+		return fmt.Errorf("field %s.%s: invalid number: %w", m.name, name, err)
+	}
+	if err := p.fieldOptions(); err != nil {
+		return err
+	}
+	m.add(&protoField{name: name, number: number, typeName: typeName, repeated: repeated, scope: m.name})
+	return nil
+}
+
+// mapField parses "map<K, V> name = number;" into a repeated field of a synthetic entry
+// message, which is how maps are encoded on the wire.
+func (p *protoParser) mapField(m *protoMessage) error {
+	p.next()
+	if err := p.expect("<"); err != nil {
+		return err
+	}
+	keyType := p.next()
+	if err := p.expect(","); err != nil {
+		return err
+	}
+	valueType := p.next()
+	if err := p.expect(">"); err != nil {
+		return err
+	}
+	name := p.next()
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	number, err := strconv.Atoi(p.next())
+	if err != nil {
+		return fmt.Errorf("map field %s.%s: invalid number: %w", m.name, name, err)
+	}
+	if err := p.fieldOptions(); err != nil {
+		return err
+	}
+	entry := &protoMessage{name: m.name + "." + name + "Entry", fields: make(map[int]*protoField), mapEntry: true}
+	entry.add(&protoField{name: "key", number: 1, typeName: keyType, scope: m.name})
+	entry.add(&protoField{name: "value", number: 2, typeName: valueType, scope: m.name})
+	p.types.messages[entry.name] = entry
+	m.add(&protoField{name: name, number: number, typeName: entry.name, repeated: true, message: entry})
+	return nil
+}
+
+// fieldOptions skips "[...]" and the closing semicolon.
+func (p *protoParser) fieldOptions() error {
+	if p.peek() == "[" {
+		for depth := 0; ; {
+			switch p.next() {
+			case "[":
+				depth++
+			case "]":
+				depth--
+			case "":
+				return fmt.Errorf("unexpected end of field options")
+			}
+			if depth == 0 {
+				break
+			}
+		}
+	}
+	return p.expect(";")
+}
+
+func (p *protoParser) enum(scope string) error {
+	e := &protoEnum{name: qualify(scope, p.next()), values: make(map[int]string)}
+	p.types.enums[e.name] = e
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for {
+		switch tok := p.next(); tok {
+		case "}":
+			return nil
+		case "":
+			return fmt.Errorf("unexpected end of enum %s", e.name)
+		case "option", "reserved":
+			if err := p.skipStatement(); err != nil {
+				return err
+			}
+		case ";":
+		default:
+			if err := p.expect("="); err != nil {
+				return fmt.Errorf("enum %s: %w", e.name, err)
+			}
+			number, err := strconv.Atoi(p.next())
+			if err != nil {
+				return fmt.Errorf("enum %s: invalid value: %w", e.name, err)
+			}
+			if err := p.fieldOptions(); err != nil {
+				return err
+			}
+			if _, ok := e.values[number]; !ok { // First name wins for aliases
+				e.values[number] = tok
+			}
+		}
+	}
+}
+
+func (m *protoMessage) add(f *protoField) {
+	m.fields[f.number] = f
+	m.order = append(m.order, f)
+}
+
+// tokenizeProto splits a .proto file into identifiers, numbers, quoted strings and symbols,
+// dropping comments.
+func tokenizeProto(src string) []string {
+	var tokens []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += end + 4
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != c {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			tokens = append(tokens, src[i:min(j+1, len(src))])
+			i = j + 1
+		case isProtoWordByte(c) || c == '-' || c == '+':
+			j := i + 1
+			for j < len(src) && isProtoWordByte(src[j]) {
+				j++
+			}
+			tokens = append(tokens, src[i:j])
+			i = j
+		default:
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+	return tokens
+}
+
+func isProtoWordByte(c byte) bool {
+	return c == '_' || c == '.' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+// protoReader decodes the Protobuf wire format.
+type protoReader struct {
+	data []byte
+	pos  int
+}
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireStart   = 3
+	wireEnd     = 4
+	wireFixed32 = 5
+)
+
+// decodeProto decodes a message into JSON-friendly values, keeping fields in declaration
+// order. Fields unknown to the schema are kept under their field number.
+func decodeProto(m *protoMessage, data []byte) (interface{}, error) {
+	r := &protoReader{data: data}
+	values := make(map[int]interface{})
+	var unknown orderedObject
+	for r.pos < len(r.data) {
+		key, err := r.varint()
+		if err != nil {
+			return nil, err
+		}
+		number, wire := int(key>>3), int(key&7)
+		f, ok := m.fields[number]
+		if !ok {
+			v, err := r.unknown(wire)
+			if err != nil {
+				return nil, fmt.Errorf("%s field %d: %w", m.name, number, err)
+			}
+			if v != nil {
+				unknown = append(unknown, objectField{strconv.Itoa(number), v})
+			}
+			continue
+		}
+		if err := r.field(f, wire, values); err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", m.name, f.name, err)
+		}
+	}
+
+	if v, ok := wellKnownValue(m, values); ok {
+		return v, nil
+	}
+	out := make(orderedObject, 0, len(values)+len(unknown))
+	for _, f := range m.order {
+		if v, ok := values[f.number]; ok {
+			out = append(out, objectField{f.name, v})
+		}
+	}
+	return append(out, unknown...), nil
+}
+
+// field decodes one occurrence of a known field into values.
+func (r *protoReader) field(f *protoField, wire int, values map[int]interface{}) error {
+	// Packed repeated scalars arrive as one length-delimited run
+	if f.repeated && wire == wireBytes && f.message == nil && f.typeName != "string" && f.typeName != "bytes" {
+		b, err := r.lengthDelimited()
+		if err != nil {
+			return err
+		}
+		packed := &protoReader{data: b}
+		list, _ := values[f.number].([]interface{})
+		for packed.pos < len(packed.data) {
+			v, err := packed.scalar(f, scalarWireType(f))
+			if err != nil {
+				return err
+			}
+			list = append(list, v)
+		}
+		values[f.number] = list
+		return nil
+	}
+
+	var v interface{}
+	var err error
+	if f.message != nil {
+		if wire != wireBytes {
+			return fmt.Errorf("wire type %d for a message", wire)
+		}
+		var b []byte
+		if b, err = r.lengthDelimited(); err != nil {
+			return err
+		}
+		if v, err = decodeProto(f.message, b); err != nil {
+			return err
+		}
+	} else if v, err = r.scalar(f, wire); err != nil {
+		return err
+	}
+
+	switch {
+	case f.message != nil && f.message.mapEntry:
+		m, _ := values[f.number].(map[string]interface{})
+		if m == nil {
+			m = make(map[string]interface{})
+		}
+		entry := v.(orderedObject)
+		var key string
+		var value interface{}
+		for _, ef := range entry {
+			if ef.name == "key" {
+				key = fmt.Sprintf("%v", ef.value)
+			} else if ef.name == "value" {
+				value = ef.value
+			}
+		}
+		m[key] = value
+		values[f.number] = m
+	case f.repeated:
+		list, _ := values[f.number].([]interface{})
+		values[f.number] = append(list, v)
+	default:
+		values[f.number] = v // Last one wins
+	}
+	return nil
+}
+
+// scalarWireType is the wire type of an unpacked scalar field.
+func scalarWireType(f *protoField) int {
+	switch f.typeName {
+	case "double", "fixed64", "sfixed64":
+		return wireFixed64
+	case "float", "fixed32", "sfixed32":
+		return wireFixed32
+	default:
+		return wireVarint
+	}
+}
+
+func (r *protoReader) scalar(f *protoField, wire int) (interface{}, error) {
+	switch wire {
+	case wireVarint:
+		u, err := r.varint()
+		if err != nil {
+			return nil, err
+		}
+		switch f.typeName {
+		case "int32":
+			return int32(u), nil
+		case "int64":
+			return int64(u), nil
+		case "uint32":
+			return uint32(u), nil
+		case "sint32", "sint64":
+			return int64(u>>1) ^ -int64(u&1), nil
+		case "bool":
+			return u != 0, nil
+		}
+		if f.enum != nil {
+			if name, ok := f.enum.values[int(int32(u))]; ok {
+				return name, nil
+			}
+			return int32(u), nil
+		}
+		return u, nil
+	case wireFixed64:
+		b, err := r.bytes(8)
+		if err != nil {
+			return nil, err
+		}
+		u := binary.LittleEndian.Uint64(b)
+		switch f.typeName {
+		case "double":
+			return jsonFloat(math.Float64frombits(u)), nil
+		case "sfixed64":
+			return int64(u), nil
+		}
+		return u, nil
+	case wireFixed32:
+		b, err := r.bytes(4)
+		if err != nil {
+			return nil, err
+		}
+		u := binary.LittleEndian.Uint32(b)
+		switch f.typeName {
+		case "float":
+			return jsonFloat(float64(math.Float32frombits(u))), nil
+		case "sfixed32":
+			return int32(u), nil
+		}
+		return u, nil
+	case wireBytes:
+		b, err := r.lengthDelimited()
+		if err != nil {
+			return nil, err
+		}
+		if f.typeName == "string" {
+			return string(b), nil
+		}
+		return avroBytes(&avroSchema{}, b), nil
+	default:
+		return nil, fmt.Errorf("unsupported wire type %d", wire)
+	}
+}
+
+// unknown decodes a field the schema does not declare as well as possible without its type;
+// groups are skipped and yield nil.
+func (r *protoReader) unknown(wire int) (interface{}, error) {
+	switch wire {
+	case wireVarint:
+		return r.varint()
+	case wireFixed64:
+		b, err := r.bytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return binary.LittleEndian.Uint64(b), nil
+	case wireFixed32:
+		b, err := r.bytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return binary.LittleEndian.Uint32(b), nil
+	case wireBytes:
+		b, err := r.lengthDelimited()
+		if err != nil {
+			return nil, err
+		}
+		return avroBytes(&avroSchema{}, b), nil
+	case wireStart:
+		return nil, r.skipGroup()
+	default:
+		return nil, fmt.Errorf("unexpected wire type %d", wire)
+	}
+}
+
+func (r *protoReader) skipGroup() error {
+	for {
+		key, err := r.varint()
+		if err != nil {
+			return err
+		}
+		if int(key&7) == wireEnd {
+			return nil
+		}
+		if _, err := r.unknown(int(key & 7)); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *protoReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid varint at byte %d", r.pos)
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *protoReader) bytes(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, fmt.Errorf("unexpected end of data at byte %d", r.pos)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *protoReader) lengthDelimited() ([]byte, error) {
+	n, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.data)) {
+		return nil, fmt.Errorf("length %d exceeds the payload", n)
+	}
+	return r.bytes(int(n))
+}
+
+// wellKnownValue renders timestamps, durations and wrapper types as plain values.
+func wellKnownValue(m *protoMessage, values map[int]interface{}) (interface{}, bool) {
+	if !strings.HasPrefix(m.name, "google.protobuf.") {
+		return nil, false
+	}
+	switch m.name {
+	case "google.protobuf.Timestamp", "google.protobuf.Duration":
+		seconds, _ := values[1].(int64)
+		nanos, _ := values[2].(int32)
+		if m.name == "google.protobuf.Duration" {
+			return (time.Duration(seconds)*time.Second + time.Duration(nanos)).String(), true
+		}
+		return time.Unix(seconds, int64(nanos)).UTC().Format(time.RFC3339Nano), true
+	default:
+		if strings.HasSuffix(m.name, "Value") {
+			if v, ok := values[1]; ok {
+				return v, true
+			}
+			// A wrapper holding the default value has nothing on the wire
+			switch m.fields[1].typeName {
+			case "string", "bytes":
+				return "", true
+			case "bool":
+				return false, true
+			default:
+				return 0, true
+			}
+		}
+	}
+	return nil, false
+}
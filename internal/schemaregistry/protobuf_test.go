@@ -0,0 +1,427 @@
+package schemaregistry
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// paymentsProto is the schema as the registry serves it. paymentsDescriptor describes the same
+// file for the reference encoder, which cannot parse .proto source.
+const paymentsProto = `
+syntax = "proto3";
+package payments.v1;
+
+import "google/protobuf/timestamp.proto";
+import "google/protobuf/duration.proto";
+import "google/protobuf/wrappers.proto";
+
+option go_package = "example.com/payments/v1";
+
+// Transaction is a card payment.
+message Transaction {
+  enum Status {
+    STATUS_UNSPECIFIED = 0;
+    STATUS_SETTLED = 1;
+    STATUS_DECLINED = 2;
+  }
+  message Merchant {
+    string name = 1;
+    string country = 2;
+  }
+  message Line {
+    string sku = 1;
+    int32 quantity = 2;
+  }
+
+  string id = 1;
+  int32 quantity = 2;
+  int64 amount_minor = 3;
+  uint32 attempts = 4;
+  uint64 sequence = 5;
+  sint32 balance_delta = 6;
+  sint64 ledger_delta = 7;
+  fixed32 terminal = 8;
+  fixed64 card_hash = 9;
+  sfixed32 offset_minutes = 10;
+  sfixed64 correction = 11;
+  double amount = 12;
+  float fee_rate = 13;
+  bool refunded = 14;
+  bytes signature = 15;
+  Status status = 16;
+  Merchant merchant = 17;
+  repeated Line lines = 18;
+  repeated string tags = 19;
+  repeated int32 risk_scores = 20;
+  map<string, string> metadata = 21;
+  google.protobuf.Timestamp created_at = 22;
+  google.protobuf.Duration settlement_delay = 23;
+  google.protobuf.StringValue note = 24 [deprecated = true];
+  oneof instrument {
+    string card_last4 = 25;
+    string iban = 26;
+  }
+}
+
+message Refund {
+  message Reason {
+    string code = 1;
+  }
+  string transaction_id = 1;
+  Reason reason = 2;
+  double amount = 3;
+}
+`
+
+func paymentsDescriptor() *descriptorpb.FileDescriptorProto {
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:   typ.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	repeated := func(f *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+		f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		return f
+	}
+	oneof := func(f *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+		f.OneofIndex = proto.Int32(0)
+		return f
+	}
+	const (
+		tString   = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		tInt32    = descriptorpb.FieldDescriptorProto_TYPE_INT32
+		tDouble   = descriptorpb.FieldDescriptorProto_TYPE_DOUBLE
+		tMessage  = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+		tEnum     = descriptorpb.FieldDescriptorProto_TYPE_ENUM
+		tInt64    = descriptorpb.FieldDescriptorProto_TYPE_INT64
+		tUint32   = descriptorpb.FieldDescriptorProto_TYPE_UINT32
+		tUint64   = descriptorpb.FieldDescriptorProto_TYPE_UINT64
+		tSint32   = descriptorpb.FieldDescriptorProto_TYPE_SINT32
+		tSint64   = descriptorpb.FieldDescriptorProto_TYPE_SINT64
+		tFixed32  = descriptorpb.FieldDescriptorProto_TYPE_FIXED32
+		tFixed64  = descriptorpb.FieldDescriptorProto_TYPE_FIXED64
+		tSfixed32 = descriptorpb.FieldDescriptorProto_TYPE_SFIXED32
+		tSfixed64 = descriptorpb.FieldDescriptorProto_TYPE_SFIXED64
+		tFloat    = descriptorpb.FieldDescriptorProto_TYPE_FLOAT
+		tBool     = descriptorpb.FieldDescriptorProto_TYPE_BOOL
+		tBytes    = descriptorpb.FieldDescriptorProto_TYPE_BYTES
+	)
+	enumValue := func(name string, number int32) *descriptorpb.EnumValueDescriptorProto {
+		return &descriptorpb.EnumValueDescriptorProto{Name: proto.String(name), Number: proto.Int32(number)}
+	}
+
+	return &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("payments/v1/payments.proto"),
+		Package:    proto.String("payments.v1"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto", "google/protobuf/duration.proto", "google/protobuf/wrappers.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Transaction"),
+				EnumType: []*descriptorpb.EnumDescriptorProto{{
+					Name:  proto.String("Status"),
+					Value: []*descriptorpb.EnumValueDescriptorProto{enumValue("STATUS_UNSPECIFIED", 0), enumValue("STATUS_SETTLED", 1), enumValue("STATUS_DECLINED", 2)},
+				}},
+				NestedType: []*descriptorpb.DescriptorProto{
+					{Name: proto.String("Merchant"), Field: []*descriptorpb.FieldDescriptorProto{field("name", 1, tString, ""), field("country", 2, tString, "")}},
+					{Name: proto.String("Line"), Field: []*descriptorpb.FieldDescriptorProto{field("sku", 1, tString, ""), field("quantity", 2, tInt32, "")}},
+					{
+						Name:    proto.String("MetadataEntry"),
+						Field:   []*descriptorpb.FieldDescriptorProto{field("key", 1, tString, ""), field("value", 2, tString, "")},
+						Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+					},
+				},
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("id", 1, tString, ""),
+					field("quantity", 2, tInt32, ""),
+					field("amount_minor", 3, tInt64, ""),
+					field("attempts", 4, tUint32, ""),
+					field("sequence", 5, tUint64, ""),
+					field("balance_delta", 6, tSint32, ""),
+					field("ledger_delta", 7, tSint64, ""),
+					field("terminal", 8, tFixed32, ""),
+					field("card_hash", 9, tFixed64, ""),
+					field("offset_minutes", 10, tSfixed32, ""),
+					field("correction", 11, tSfixed64, ""),
+					field("amount", 12, tDouble, ""),
+					field("fee_rate", 13, tFloat, ""),
+					field("refunded", 14, tBool, ""),
+					field("signature", 15, tBytes, ""),
+					field("status", 16, tEnum, ".payments.v1.Transaction.Status"),
+					field("merchant", 17, tMessage, ".payments.v1.Transaction.Merchant"),
+					repeated(field("lines", 18, tMessage, ".payments.v1.Transaction.Line")),
+					repeated(field("tags", 19, tString, "")),
+					repeated(field("risk_scores", 20, tInt32, "")),
+					repeated(field("metadata", 21, tMessage, ".payments.v1.Transaction.MetadataEntry")),
+					field("created_at", 22, tMessage, ".google.protobuf.Timestamp"),
+					field("settlement_delay", 23, tMessage, ".google.protobuf.Duration"),
+					field("note", 24, tMessage, ".google.protobuf.StringValue"),
+					oneof(field("card_last4", 25, tString, "")),
+					oneof(field("iban", 26, tString, "")),
+				},
+				OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("instrument")}},
+			},
+			{
+				Name: proto.String("Refund"),
+				NestedType: []*descriptorpb.DescriptorProto{
+					{Name: proto.String("Reason"), Field: []*descriptorpb.FieldDescriptorProto{field("code", 1, tString, "")}},
+				},
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("transaction_id", 1, tString, ""),
+					field("reason", 2, tMessage, ".payments.v1.Refund.Reason"),
+					field("amount", 3, tDouble, ""),
+				},
+			},
+		},
+	}
+}
+
+// appendUnknown appends to a payload what a newer writer would add: field 99 as a varint, field
+// 100 as bytes, a group 101, which is skipped, and an unpacked element of risk_scores.
+func appendUnknown(b []byte) []byte {
+	b = protowire.AppendTag(b, 99, protowire.VarintType)
+	b = protowire.AppendVarint(b, 5)
+	b = protowire.AppendTag(b, 100, protowire.BytesType)
+	b = protowire.AppendString(b, "extra")
+	b = protowire.AppendTag(b, 101, protowire.StartGroupType)
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, 1)
+	b = protowire.AppendTag(b, 101, protowire.EndGroupType)
+	b = protowire.AppendTag(b, 20, protowire.VarintType)
+	return protowire.AppendVarint(b, 9)
+}
+
+// protoCases are payloads encoded by google.golang.org/protobuf from their protojson form,
+// with the JSON the .proto schema decoder must render for them. The DescriptorDecoder renders
+// protojson instead, given in descriptor where it differs.
+var protoCases = []struct {
+	name       string
+	message    string
+	input      string // protojson
+	unknown    bool   // Append the fields of appendUnknown
+	want       string
+	descriptor string // Empty if the same as want
+}{
+	{
+		name:    "every field type",
+		message: "payments.v1.Transaction",
+		input: `{
+			"id": "tx-1", "quantity": -3, "amountMinor": "-9007199254740993", "attempts": 4294967295,
+			"sequence": "18446744073709551615", "balanceDelta": -150, "ledgerDelta": "-9223372036854775808",
+			"terminal": 4000000000, "cardHash": "12345678901234567890", "offsetMinutes": -120,
+			"correction": "-42", "amount": 1234.5, "feeRate": 0.25, "refunded": true, "signature": "3q2+7w==",
+			"status": "STATUS_SETTLED", "merchant": {"name": "Café Ünïcode", "country": "DE"},
+			"lines": [{"sku": "A-1", "quantity": 2}, {"sku": "B-2"}], "tags": ["card", "eu"],
+			"riskScores": [0, -1, 300], "metadata": {"region": "eu", "channel": "pos"},
+			"createdAt": "2026-03-01T09:30:00.250Z", "settlementDelay": "90.500s", "note": "manual review",
+			"iban": "DE89370400440532013000"
+		}`,
+		want: `{"id":"tx-1","quantity":-3,"amount_minor":-9007199254740993,"attempts":4294967295,` +
+			`"sequence":18446744073709551615,"balance_delta":-150,"ledger_delta":-9223372036854775808,` +
+			`"terminal":4000000000,"card_hash":12345678901234567890,"offset_minutes":-120,"correction":-42,` +
+			`"amount":1234.5,"fee_rate":0.25,"refunded":true,"signature":"3q2+7w==","status":"STATUS_SETTLED",` +
+			`"merchant":{"name":"Café Ünïcode","country":"DE"},"lines":[{"sku":"A-1","quantity":2},{"sku":"B-2"}],` +
+			`"tags":["card","eu"],"risk_scores":[0,-1,300],"metadata":{"channel":"pos","region":"eu"},` +
+			`"created_at":"2026-03-01T09:30:00.25Z","settlement_delay":"1m30.5s","note":"manual review",` +
+			`"iban":"DE89370400440532013000"}`,
+		descriptor: `{"id":"tx-1","quantity":-3,"amount_minor":"-9007199254740993","attempts":4294967295,` +
+			`"sequence":"18446744073709551615","balance_delta":-150,"ledger_delta":"-9223372036854775808",` +
+			`"terminal":4000000000,"card_hash":"12345678901234567890","offset_minutes":-120,"correction":"-42",` +
+			`"amount":1234.5,"fee_rate":0.25,"refunded":true,"signature":"3q2+7w==","status":"STATUS_SETTLED",` +
+			`"merchant":{"name":"Café Ünïcode","country":"DE"},"lines":[{"sku":"A-1","quantity":2},{"sku":"B-2"}],` +
+			`"tags":["card","eu"],"risk_scores":[0,-1,300],"metadata":{"channel":"pos","region":"eu"},` +
+			`"created_at":"2026-03-01T09:30:00.250Z","settlement_delay":"90.500s","note":"manual review",` +
+			`"iban":"DE89370400440532013000"}`,
+	},
+	{
+		name:    "proto3 defaults are not on the wire",
+		message: "payments.v1.Transaction",
+		input:   `{"id": "tx-2", "quantity": 0, "refunded": false, "status": "STATUS_UNSPECIFIED", "merchant": {}, "note": ""}`,
+		want:    `{"id":"tx-2","merchant":{},"note":""}`,
+	},
+	{
+		name:    "enum value unknown to the schema",
+		message: "payments.v1.Transaction",
+		input:   `{"status": 7, "createdAt": "1969-12-31T23:59:59Z", "settlementDelay": "-2s"}`,
+		want:    `{"status":7,"created_at":"1969-12-31T23:59:59Z","settlement_delay":"-2s"}`,
+	},
+	{
+		name:       "UTF-8 bytes and the other oneof member",
+		message:    "payments.v1.Transaction",
+		input:      `{"signature": "c2lnbmVk", "cardLast4": "4242"}`,
+		want:       `{"signature":"signed","card_last4":"4242"}`,
+		descriptor: `{"signature":"c2lnbmVk","card_last4":"4242"}`,
+	},
+	{
+		name:       "unknown fields",
+		message:    "payments.v1.Transaction",
+		input:      `{"id": "tx-3", "riskScores": [1, 2]}`,
+		unknown:    true,
+		want:       `{"id":"tx-3","risk_scores":[1,2,9],"99":5,"100":"extra"}`,
+		descriptor: `{"id":"tx-3","risk_scores":[1,2,9]}`, // protojson drops unknown fields
+	},
+	{
+		name:    "second top-level message",
+		message: "payments.v1.Refund",
+		input:   `{"transactionId": "tx-1", "reason": {"code": "duplicate"}, "amount": -0.5}`,
+		want:    `{"transaction_id":"tx-1","reason":{"code":"duplicate"},"amount":-0.5}`,
+	},
+	{
+		name:    "nested message",
+		message: "payments.v1.Refund.Reason",
+		input:   `{"code": "fraud"}`,
+		want:    `{"code":"fraud"}`,
+	},
+}
+
+// paymentsFiles registers the file of paymentsDescriptor for the reference encoder.
+func paymentsFiles(t *testing.T, fdp *descriptorpb.FileDescriptorProto) *protoregistry.Files {
+	t.Helper()
+	file, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := new(protoregistry.Files)
+	if err := files.RegisterFile(file); err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// encodeProto encodes the protojson input as the named message.
+func encodeProto(t *testing.T, files *protoregistry.Files, message, input string) []byte {
+	t.Helper()
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := dynamicpb.NewMessage(desc.(protoreflect.MessageDescriptor))
+	if err := protojson.Unmarshal([]byte(input), msg); err != nil {
+		t.Fatalf("reference encoder rejected the input: %v", err)
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestProtobufConformance decodes the reference payloads with the types parsed from the
+// registry's .proto source and with a DescriptorDecoder loading the compiled descriptor set.
+func TestProtobufConformance(t *testing.T) {
+	fdp := paymentsDescriptor()
+	files := paymentsFiles(t, fdp)
+
+	types := newProtoTypes()
+	if _, err := types.parse(paymentsProto); err != nil {
+		t.Fatal(err)
+	}
+	if err := types.resolve(); err != nil {
+		t.Fatal(err)
+	}
+
+	// As written by protoc --include_imports --descriptor_set_out
+	set, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(timestamppb.File_google_protobuf_timestamp_proto),
+		protodesc.ToFileDescriptorProto(durationpb.File_google_protobuf_duration_proto),
+		protodesc.ToFileDescriptorProto(wrapperspb.File_google_protobuf_wrappers_proto),
+		fdp,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setPath := filepath.Join(t.TempDir(), "payments.desc")
+	if err := os.WriteFile(setPath, set, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range protoCases {
+		t.Run(tc.name, func(t *testing.T) {
+			payload := encodeProto(t, files, tc.message, tc.input)
+			if tc.unknown {
+				payload = appendUnknown(payload)
+			}
+
+			m, ok := types.messages[tc.message]
+			if !ok {
+				t.Fatalf("message %s is not in the parsed schema", tc.message)
+			}
+			value, err := decodeProto(m, payload)
+			if err != nil {
+				t.Fatalf("decoding with the .proto schema: %v", err)
+			}
+			got, err := json.Marshal(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Errorf(".proto schema decoded\n%s\nwant\n%s", got, tc.want)
+			}
+
+			d, err := NewDescriptorDecoder(setPath, "."+tc.message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err = d.Decode("payments", payload)
+			if err != nil {
+				t.Fatalf("decoding with the descriptor set: %v", err)
+			}
+			want := tc.descriptor
+			if want == "" {
+				want = tc.want
+			}
+			if string(got) != want {
+				t.Errorf("descriptor set decoded\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeProtoMalformed(t *testing.T) {
+	types := newProtoTypes()
+	if _, err := types.parse(paymentsProto); err != nil {
+		t.Fatal(err)
+	}
+	if err := types.resolve(); err != nil {
+		t.Fatal(err)
+	}
+	m := types.messages["payments.v1.Transaction"]
+
+	for _, tc := range []struct {
+		name    string
+		payload []byte
+	}{
+		{"truncated varint", []byte{0x10, 0xff}},
+		{"length past the end", []byte{0x0a, 0x05, 'a'}},
+		{"truncated fixed64", []byte{0x49, 1, 2, 3}},
+		{"message with a varint wire type", []byte{0x88, 0x01, 0x01}},
+		{"end group without a start", []byte{0x0c}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if v, err := decodeProto(m, tc.payload); err == nil {
+				t.Errorf("decoded %v, want an error", v)
+			}
+		})
+	}
+}
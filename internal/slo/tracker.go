@@ -0,0 +1,145 @@
+package slo
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/metrics"
+)
+
+const (
+	recentWindowSize = 100 // Number of recent windows used to compute the burn rate
+
+	// latencyWeight is the weight of each window's latency in the smoothed latency that
+	// shedding is decided on, so fast windows (e.g. dropped ones) wind shedding down over a
+	// few windows instead of switching it off at once.
+	latencyWeight = 0.2
+)
+
+var (
+	latencySeconds = metrics.NewGauge("window_processing_latency_seconds", "Latency from window close until the last window was processed: indexed, stored in the outbox, dropped or failed.")
+	breachesTotal  = metrics.NewCounter("window_processing_slo_breaches_total", "Windows whose close-to-index latency exceeded the SLO.")
+	burnRate       = metrics.NewGauge("window_processing_slo_burn_rate", "Error budget burn rate over the recent windows (1.0 = burning exactly at budget).")
+	backlogGauge   = metrics.NewGauge("window_processing_backlog", "Closed windows waiting for or undergoing processing.")
+	shedTotal      = metrics.NewCounter("window_processing_shed_total", "Windows degraded or dropped by the shedding policy.")
+)
+
+// Tracker measures window close-to-index latency against the configured SLO and decides
+// when shedding should kick in (latency over SLO while a backlog has built up).
+type Tracker struct {
+	cfg      config.ProcessingSLOConfig
+	slo      time.Duration
+	mu       sync.Mutex
+	backlog  int
+	smoothed time.Duration // Exponentially weighted latency of the processed windows
+	recent   []bool        // Ring buffer of recent SLO breaches
+	next     int
+}
+
+func NewTracker(cfg config.ProcessingSLOConfig) *Tracker {
+	return &Tracker{
+		cfg:    cfg,
+		slo:    time.Duration(cfg.LatencySeconds * float64(time.Second)),
+		recent: make([]bool, 0, recentWindowSize),
+	}
+}
+
+// Enabled reports whether an SLO has been configured.
+func (t *Tracker) Enabled() bool {
+	return t.slo > 0
+}
+
+// Begin marks a closed window as entering the processing backlog.
+func (t *Tracker) Begin() {
+	t.mu.Lock()
+	t.backlog++
+	backlogGauge.Set(float64(t.backlog))
+	t.mu.Unlock()
+}
+
+// Done removes a window from the backlog, whether it was indexed or not.
+func (t *Tracker) Done() {
+	t.mu.Lock()
+	t.backlog--
+	backlogGauge.Set(float64(t.backlog))
+	t.mu.Unlock()
+}
+
+// Observe records the latency from close to the end of processing of every closed window,
+// however it ended: indexed, stored in the outbox, dropped by shedding or failed.
+func (t *Tracker) Observe(topic string, latency time.Duration) {
+	latencySeconds.Set(latency.Seconds(), "topic", topic)
+	if !t.Enabled() {
+		return
+	}
+
+	breached := latency > t.slo
+	if breached {
+		breachesTotal.Inc("topic", topic)
+		log.Printf("Window processing for topic %s took %s, exceeding SLO of %s", topic, latency, t.slo)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.recent) == 0 {
+		t.smoothed = latency
+	} else {
+		t.smoothed += time.Duration(latencyWeight * float64(latency-t.smoothed))
+	}
+	if len(t.recent) < recentWindowSize {
+		t.recent = append(t.recent, breached)
+	} else {
+		t.recent[t.next] = breached
+		t.next = (t.next + 1) % recentWindowSize
+	}
+	burnRate.Set(t.burnRateLocked())
+}
+
+func (t *Tracker) burnRateLocked() float64 {
+	if len(t.recent) == 0 {
+		return 0
+	}
+	breaches := 0
+	for _, b := range t.recent {
+		if b {
+			breaches++
+		}
+	}
+	budget := 1 - t.cfg.Target
+	if budget <= 0 {
+		budget = 0.01 // Default to a 99% target
+	}
+	return float64(breaches) / float64(len(t.recent)) / budget
+}
+
+// Shedding reports whether the pipeline is currently over its SLO, by the smoothed latency of
+// the processed windows, with a backlog.
+func (t *Tracker) Shedding() bool {
+	if !t.Enabled() {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.smoothed > t.slo && t.backlog > t.cfg.BacklogThreshold
+}
+
+// ShouldDrop reports whether a window of a topic with the given priority should be skipped entirely.
+func (t *Tracker) ShouldDrop(topic string, priority int) bool {
+	if priority >= t.cfg.ShedBelowPriority || !t.Shedding() {
+		return false
+	}
+	shedTotal.Inc("topic", topic, "policy", "drop")
+	return true
+}
+
+// MaxRenderedMessages returns how many messages should be rendered into the context text,
+// reducing the rendering work while shedding. Zero means use the default.
+func (t *Tracker) MaxRenderedMessages(topic string) int {
+	if t.cfg.ShedMaxMessages <= 0 || !t.Shedding() {
+		return 0
+	}
+	shedTotal.Inc("topic", topic, "policy", "reduced_context")
+	return t.cfg.ShedMaxMessages
+}
@@ -0,0 +1,120 @@
+package kinesis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"stream-rag-agent/internal/atrest"
+)
+
+// shardEnd is the checkpoint of a closed shard that was read to the end.
+const shardEnd = "SHARD_END"
+
+// checkpointStore keeps the sequence number of the last acknowledged record of each shard.
+type checkpointStore interface {
+	// load returns the checkpoint of the shard, empty if it has none.
+	load(ctx context.Context, shardID string) (string, error)
+	save(ctx context.Context, shardID, sequence string) error
+}
+
+// fileCheckpoints stores the checkpoints of a stream in a local JSON file, replaced atomically.
+type fileCheckpoints struct {
+	path string
+
+	mu          sync.Mutex
+	checkpoints map[string]string
+}
+
+func newFileCheckpoints(dir, application, stream string) (*fileCheckpoints, error) {
+	if dir == "" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+	f := &fileCheckpoints{
+		path:        filepath.Join(dir, fmt.Sprintf("%s-%s.json", application, stream)),
+		checkpoints: make(map[string]string),
+	}
+	data, err := atrest.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoints: %w", err)
+	}
+	if err := json.Unmarshal(data, &f.checkpoints); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoints in %s: %w", f.path, err)
+	}
+	return f, nil
+}
+
+func (f *fileCheckpoints) load(ctx context.Context, shardID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.checkpoints[shardID], nil
+}
+
+func (f *fileCheckpoints) save(ctx context.Context, shardID, sequence string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.checkpoints[shardID] = sequence
+	data, err := json.MarshalIndent(f.checkpoints, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoints: %w", err)
+	}
+	tmp := f.path + ".tmp"
+	if err := atrest.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write checkpoints: %w", err)
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		return fmt.Errorf("failed to write checkpoints: %w", err)
+	}
+	return nil
+}
+
+// dynamoCheckpoints stores checkpoints as items of a DynamoDB table keyed by
+// "<application>/<stream>/<shard>", so agents on other hosts can take over the stream.
+type dynamoCheckpoints struct {
+	client *dynamodb.Client
+	table  string
+	prefix string
+}
+
+func (d *dynamoCheckpoints) key(shardID string) map[string]dbtypes.AttributeValue {
+	return map[string]dbtypes.AttributeValue{"shard_key": &dbtypes.AttributeValueMemberS{Value: d.prefix + shardID}}
+}
+
+func (d *dynamoCheckpoints) load(ctx context.Context, shardID string) (string, error) {
+	out, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            d.key(shardID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read checkpoint of shard %s from %s: %w", shardID, d.table, err)
+	}
+	if seq, ok := out.Item["sequence_number"].(*dbtypes.AttributeValueMemberS); ok {
+		return seq.Value, nil
+	}
+	return "", nil
+}
+
+func (d *dynamoCheckpoints) save(ctx context.Context, shardID, sequence string) error {
+	item := d.key(shardID)
+	item["sequence_number"] = &dbtypes.AttributeValueMemberS{Value: sequence}
+	item["updated_at"] = &dbtypes.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)}
+	if _, err := d.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(d.table), Item: item}); err != nil {
+		return fmt.Errorf("failed to write checkpoint of shard %s to %s: %w", shardID, d.table, err)
+	}
+	return nil
+}
@@ -0,0 +1,442 @@
+// Package kinesis reads topics from Amazon Kinesis Data Streams. A Consumer reads every
+// shard of a stream, follows resharding, and checkpoints acknowledged records to DynamoDB or
+// a local file. It is a source.Source.
+package kinesis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/metrics"
+	"stream-rag-agent/pkg/windowing"
+)
+
+// SourceName is the value of a topic's source option that selects this package.
+const SourceName = "kinesis"
+
+const (
+	defaultPollInterval       = time.Second
+	defaultMaxRecords         = 1000
+	defaultCheckpointInterval = 10 * time.Second
+	defaultApplicationName    = "stream-rag-agent"
+	minPollInterval           = 200 * time.Millisecond // GetRecords allows 5 calls per second per shard
+	shardDiscoveryInterval    = time.Minute
+	retryDelay                = time.Second
+	recordBufferSize          = 1000
+)
+
+var (
+	recordsRead        = metrics.NewCounter("kinesis_records_total", "Records read from Kinesis streams, by stream.")
+	millisBehindLatest = metrics.NewGauge("kinesis_millis_behind_latest", "How far the last records read from a shard are behind the tip of the stream, by stream and shard.")
+	streamShards       = metrics.NewGauge("kinesis_shards", "Shards of a Kinesis stream being read.")
+)
+
+// record is a message read from a shard, or the end of a closed shard.
+type record struct {
+	shardID  string
+	sequence string
+	msg      windowing.Message
+	end      bool
+}
+
+// Consumer reads a Kinesis stream. Kinesis sequence numbers do not fit the int64 offsets of
+// windowing.Message, so offsets count the records read from each shard since the agent
+// started; the partition is the number of the shard ID (shardId-000000000012 is 12).
+type Consumer struct {
+	stream             string
+	client             *kinesis.Client
+	checkpoints        checkpointStore
+	startPosition      types.ShardIteratorType
+	pollInterval       time.Duration
+	maxRecords         int32
+	checkpointInterval time.Duration
+
+	records chan record
+	last    record // Returned by the preceding Fetch
+
+	mu       sync.Mutex
+	acked    map[string]string // Shard ID to the sequence number of the last committed record
+	saved    map[string]string // Checkpoints as last written
+	finished map[string]bool   // Closed shards read to the end
+	readers  sync.WaitGroup
+}
+
+// NewConsumer creates a consumer of the stream named by the topic. Checkpoints are namespaced
+// by kinesis.application_name, or groupID when it is empty.
+func NewConsumer(topicCfg config.KafkaTopicConfig, cfg config.KinesisConfig, groupID string) (*Consumer, error) {
+	ctx := context.Background()
+	var opts []func(*awsconfig.LoadOptions) error
+	if cfg.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(cfg.Region))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	if cfg.Endpoint != "" {
+		awsCfg.BaseEndpoint = aws.String(cfg.Endpoint)
+	}
+
+	c := &Consumer{
+		stream:             topicCfg.Name,
+		client:             kinesis.NewFromConfig(awsCfg),
+		pollInterval:       time.Duration(cfg.PollIntervalMs) * time.Millisecond,
+		maxRecords:         int32(cfg.MaxRecords),
+		checkpointInterval: time.Duration(cfg.Checkpoint.IntervalSeconds) * time.Second,
+		records:            make(chan record, recordBufferSize),
+		acked:              make(map[string]string),
+		saved:              make(map[string]string),
+		finished:           make(map[string]bool),
+	}
+	if c.pollInterval <= 0 {
+		c.pollInterval = defaultPollInterval
+	}
+	if c.maxRecords <= 0 {
+		c.maxRecords = defaultMaxRecords
+	}
+	if c.checkpointInterval <= 0 {
+		c.checkpointInterval = defaultCheckpointInterval
+	}
+	switch cfg.StartPosition {
+	case "", "latest":
+		c.startPosition = types.ShardIteratorTypeLatest
+	case "trim_horizon":
+		c.startPosition = types.ShardIteratorTypeTrimHorizon
+	default:
+		return nil, fmt.Errorf("invalid kinesis.start_position '%s' (expected latest or trim_horizon)", cfg.StartPosition)
+	}
+
+	application := cfg.ApplicationName
+	if application == "" {
+		application = groupID
+	}
+	if application == "" {
+		application = defaultApplicationName
+	}
+	if cfg.Checkpoint.DynamoDBTable != "" {
+		c.checkpoints = &dynamoCheckpoints{
+			client: dynamodb.NewFromConfig(awsCfg),
+			table:  cfg.Checkpoint.DynamoDBTable,
+			prefix: application + "/" + c.stream + "/",
+		}
+		log.Printf("Checkpointing Kinesis stream %s to DynamoDB table %s", c.stream, cfg.Checkpoint.DynamoDBTable)
+	} else {
+		files, err := newFileCheckpoints(cfg.Checkpoint.Directory, application, c.stream)
+		if err != nil {
+			return nil, err
+		}
+		c.checkpoints = files
+		log.Printf("Checkpointing Kinesis stream %s to %s", c.stream, files.path)
+	}
+	return c, nil
+}
+
+// Topic returns the name of the stream.
+func (c *Consumer) Topic() string {
+	return c.stream
+}
+
+// Fetch returns the next record of any shard. Records of a shard are returned in order, and
+// the records of a parent shard before those of its children.
+func (c *Consumer) Fetch(ctx context.Context) (windowing.Message, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return windowing.Message{}, ctx.Err()
+		case r := <-c.records:
+			if r.end {
+				// Every record of the shard has been fetched and committed
+				c.mu.Lock()
+				c.acked[r.shardID] = shardEnd
+				c.mu.Unlock()
+				continue
+			}
+			c.last = r
+			return r.msg, nil
+		}
+	}
+}
+
+// Commit acknowledges the record returned by the preceding Fetch. Acknowledged positions are
+// checkpointed periodically by DiscoverShards and when the consumer is closed.
+func (c *Consumer) Commit(ctx context.Context, msg windowing.Message) error {
+	if c.last.msg.Partition != msg.Partition || c.last.msg.Offset != msg.Offset {
+		return fmt.Errorf("message of partition %d, offset %d was not the last fetched", msg.Partition, msg.Offset)
+	}
+	c.mu.Lock()
+	c.acked[c.last.shardID] = c.last.sequence
+	c.mu.Unlock()
+	return nil
+}
+
+// Close writes the checkpoints of the records acknowledged since the last periodic save.
+func (c *Consumer) Close() error {
+	return c.saveCheckpoints(context.Background())
+}
+
+func (c *Consumer) saveCheckpoints(ctx context.Context) error {
+	c.mu.Lock()
+	pending := make(map[string]string)
+	for shardID, seq := range c.acked {
+		if c.saved[shardID] != seq {
+			pending[shardID] = seq
+		}
+	}
+	c.mu.Unlock()
+
+	var errs []error
+	for shardID, seq := range pending {
+		if err := c.checkpoints.save(ctx, shardID, seq); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.mu.Lock()
+		c.saved[shardID] = seq
+		c.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// DiscoverShards lists the shards of the stream, starts reading those whose parents have been
+// read, and calls onPartition for each of them; it keeps checking for shards created by
+// resharding and saving checkpoints until ctx is cancelled, then waits for the shard readers
+// to stop.
+func (c *Consumer) DiscoverShards(ctx context.Context, onPartition func(partition int32)) {
+	started := make(map[string]bool)
+	var discover func()
+	discover = func() {
+		shards, err := c.listShards(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Shard discovery for Kinesis stream %s failed: %v", c.stream, err)
+			}
+			return
+		}
+		listed := make(map[string]bool, len(shards))
+		for _, s := range shards {
+			listed[aws.ToString(s.ShardId)] = true
+		}
+		c.mu.Lock()
+		parentDone := func(parent *string) bool {
+			return parent == nil || !listed[*parent] || c.finished[*parent]
+		}
+		var ready []types.Shard
+		for _, s := range shards {
+			id := aws.ToString(s.ShardId)
+			if started[id] || !parentDone(s.ParentShardId) || !parentDone(s.AdjacentParentShardId) {
+				continue
+			}
+			ready = append(ready, s)
+		}
+		c.mu.Unlock()
+
+		ended := false
+		for _, s := range ready {
+			id := aws.ToString(s.ShardId)
+			sequence, err := c.checkpoints.load(ctx, id)
+			if err != nil {
+				log.Printf("Kinesis stream %s: %v", c.stream, err)
+				continue
+			}
+			started[id] = true
+			if sequence == shardEnd {
+				c.mu.Lock()
+				c.finished[id] = true
+				c.acked[id], c.saved[id] = shardEnd, shardEnd
+				c.mu.Unlock()
+				ended = true
+				continue
+			}
+			position := c.startPosition
+			if s.ParentShardId != nil && listed[*s.ParentShardId] {
+				position = types.ShardIteratorTypeTrimHorizon // Continue where the parent ended
+			}
+			partition := shardPartition(id)
+			onPartition(partition)
+			c.readers.Add(1)
+			go c.readShard(ctx, id, partition, sequence, position)
+		}
+		streamShards.Set(float64(len(started)), "stream", c.stream)
+		if ended {
+			discover() // Children of shards finished before the restart
+		}
+	}
+
+	discover()
+	log.Printf("Kinesis stream %s has %d shards", c.stream, len(started))
+	discovery := time.NewTicker(shardDiscoveryInterval)
+	defer discovery.Stop()
+	checkpoint := time.NewTicker(c.checkpointInterval)
+	defer checkpoint.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			c.readers.Wait()
+			return
+		case <-discovery.C:
+			discover()
+		case <-checkpoint.C:
+			if err := c.saveCheckpoints(ctx); err != nil {
+				log.Printf("Kinesis stream %s: %v", c.stream, err)
+			}
+			// A shard read to the end lets its children start without waiting a minute
+			c.mu.Lock()
+			ended := false
+			for id, seq := range c.acked {
+				if seq == shardEnd && !c.finished[id] {
+					c.finished[id] = true
+					ended = true
+				}
+			}
+			c.mu.Unlock()
+			if ended {
+				discover()
+			}
+		}
+	}
+}
+
+func (c *Consumer) listShards(ctx context.Context) ([]types.Shard, error) {
+	var shards []types.Shard
+	input := &kinesis.ListShardsInput{StreamName: aws.String(c.stream)}
+	for {
+		out, err := c.client.ListShards(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list shards: %w", err)
+		}
+		shards = append(shards, out.Shards...)
+		if out.NextToken == nil {
+			return shards, nil
+		}
+		// The stream name must not be repeated with a continuation token
+		input = &kinesis.ListShardsInput{NextToken: out.NextToken}
+	}
+}
+
+// readShard reads a shard until it is closed and read to the end, or ctx is cancelled.
+// sequence is the checkpoint to continue after, empty to start at position.
+func (c *Consumer) readShard(ctx context.Context, shardID string, partition int32, sequence string, position types.ShardIteratorType) {
+	defer c.readers.Done()
+	log.Printf("Reading shard %s of Kinesis stream %s as partition %d", shardID, c.stream, partition)
+	var offset int64
+	iterator := ""
+	for ctx.Err() == nil {
+		if iterator == "" {
+			var err error
+			if iterator, err = c.shardIterator(ctx, shardID, sequence, position); err != nil {
+				log.Printf("Kinesis stream %s: %v", c.stream, err)
+				sleep(ctx, retryDelay)
+				continue
+			}
+		}
+
+		out, err := c.client.GetRecords(ctx, &kinesis.GetRecordsInput{ShardIterator: aws.String(iterator), Limit: aws.Int32(c.maxRecords)})
+		if err != nil {
+			var expired *types.ExpiredIteratorException
+			var throttled *types.ProvisionedThroughputExceededException
+			switch {
+			case ctx.Err() != nil:
+				return
+			case errors.As(err, &expired):
+				iterator = "" // Resume after the last record read
+			case errors.As(err, &throttled):
+				sleep(ctx, retryDelay)
+			default:
+				log.Printf("Failed to get records of shard %s of Kinesis stream %s: %v", shardID, c.stream, err)
+				sleep(ctx, retryDelay)
+			}
+			continue
+		}
+		if out.MillisBehindLatest != nil {
+			millisBehindLatest.Set(float64(*out.MillisBehindLatest), "stream", c.stream, "shard", shardID)
+		}
+
+		for _, r := range out.Records {
+			msg := windowing.Message{
+				Topic:     c.stream,
+				Partition: partition,
+				Offset:    offset,
+				Key:       []byte(aws.ToString(r.PartitionKey)),
+				Value:     r.Data,
+				Timestamp: aws.ToTime(r.ApproximateArrivalTimestamp),
+			}
+			if !c.send(ctx, record{shardID: shardID, sequence: aws.ToString(r.SequenceNumber), msg: msg}) {
+				return
+			}
+			offset++
+			sequence = aws.ToString(r.SequenceNumber)
+			recordsRead.Inc("stream", c.stream)
+		}
+
+		if out.NextShardIterator == nil {
+			log.Printf("Shard %s of Kinesis stream %s is closed and read to the end", shardID, c.stream)
+			c.send(ctx, record{shardID: shardID, end: true})
+			return
+		}
+		iterator = *out.NextShardIterator
+		if len(out.Records) == 0 {
+			sleep(ctx, c.pollInterval)
+		} else {
+			sleep(ctx, minPollInterval)
+		}
+	}
+}
+
+func (c *Consumer) shardIterator(ctx context.Context, shardID, sequence string, position types.ShardIteratorType) (string, error) {
+	input := &kinesis.GetShardIteratorInput{
+		StreamName:        aws.String(c.stream),
+		ShardId:           aws.String(shardID),
+		ShardIteratorType: position,
+	}
+	if sequence != "" {
+		input.ShardIteratorType = types.ShardIteratorTypeAfterSequenceNumber
+		input.StartingSequenceNumber = aws.String(sequence)
+	}
+	out, err := c.client.GetShardIterator(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to get iterator of shard %s: %w", shardID, err)
+	}
+	return aws.ToString(out.ShardIterator), nil
+}
+
+// send queues a record for Fetch, blocking while the buffer is full. It returns false when
+// ctx is cancelled.
+func (c *Consumer) send(ctx context.Context, r record) bool {
+	select {
+	case c.records <- r:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// shardPartition maps a shard ID to a partition number: the number at the end of the ID, or a
+// hash of IDs without one.
+func shardPartition(shardID string) int32 {
+	if n, err := strconv.ParseInt(shardID[strings.LastIndex(shardID, "-")+1:], 10, 32); err == nil {
+		return int32(n)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(shardID))
+	return int32(h.Sum32() & 0x7fffffff)
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,301 @@
+// Package mqtt reads topics from an MQTT v5 broker, e.g. sensor streams. A Consumer
+// subscribes to the MQTT topic filters of a topic with a persistent session and acknowledges
+// QoS 1 messages only once the window holding them has been processed, so messages of windows
+// lost in a crash are redelivered when the agent reconnects. It is a source.Source.
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/metrics"
+	"stream-rag-agent/internal/window"
+	"stream-rag-agent/pkg/windowing"
+)
+
+// SourceName is the value of a topic's source option that selects this package.
+const SourceName = "mqtt"
+
+const (
+	defaultURL            = "mqtt://localhost:1883"
+	defaultClientID       = "stream-rag-agent"
+	defaultQoS            = 1
+	defaultSessionExpiry  = time.Hour
+	defaultReceiveMaximum = math.MaxUint16
+	defaultKeepAlive      = 30 * time.Second
+	setupTimeout          = 10 * time.Second
+	receiveBuffer         = 1024 // Messages received and not yet fetched
+)
+
+var (
+	messagesAcked   = metrics.NewCounter("mqtt_messages_acked_total", "QoS 1 MQTT messages acknowledged after their window was processed, by topic and result (ok, or failed when the window failed).")
+	pendingMessages = metrics.NewGauge("mqtt_pending_acks", "QoS 1 MQTT messages of a topic buffered in windows and not yet acknowledged.")
+)
+
+// Consumer reads a topic's MQTT topic filters. Messages are delivered as partition 0 with a
+// sequence number counted since the consumer started as offset, their MQTT topic as key and
+// their user properties as headers.
+type Consumer struct {
+	topic    string
+	conn     *autopaho.ConnectionManager
+	received chan received
+	closed   chan struct{} // Closed by Close, releases receive
+	nextSeq  int64         // Offset of the next message, only used by Fetch
+
+	mu      sync.Mutex
+	pending map[int64]pendingMsg // By offset, fetched QoS 1 messages not yet acknowledged
+}
+
+type received struct {
+	publish *paho.Publish
+	client  *paho.Client // Client of the connection the message arrived on, which acknowledges it
+	at      time.Time
+}
+
+type pendingMsg struct {
+	received
+	fetched windowing.Message
+}
+
+// NewConsumer connects to the broker with the client ID <mqtt.client_id>_<topic>, or
+// groupID when mqtt.client_id is empty, and subscribes to the topic's MQTT topic filters on
+// every (re)connection.
+func NewConsumer(topicCfg config.KafkaTopicConfig, cfg config.MQTTConfig, groupID string) (*Consumer, error) {
+	rawURLs := cfg.URLs
+	if len(rawURLs) == 0 {
+		rawURLs = []string{defaultURL}
+	}
+	urls := make([]*url.URL, 0, len(rawURLs))
+	for _, raw := range rawURLs {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mqtt.urls entry '%s': %w", raw, err)
+		}
+		urls = append(urls, u)
+	}
+
+	qos := defaultQoS
+	if cfg.QoS != nil {
+		qos = *cfg.QoS
+	}
+	if qos != 0 && qos != 1 {
+		return nil, fmt.Errorf("invalid mqtt.qos %d (expected 0 or 1)", qos)
+	}
+	sessionExpiry := time.Duration(cfg.SessionExpirySeconds) * time.Second
+	if sessionExpiry <= 0 {
+		sessionExpiry = defaultSessionExpiry
+	}
+	receiveMaximum := cfg.ReceiveMaximum
+	if receiveMaximum <= 0 || receiveMaximum > math.MaxUint16 {
+		receiveMaximum = defaultReceiveMaximum
+	}
+	keepAlive := time.Duration(cfg.KeepAliveSeconds) * time.Second
+	if keepAlive <= 0 {
+		keepAlive = defaultKeepAlive
+	}
+
+	filters := topicCfg.MQTTTopics
+	if len(filters) == 0 {
+		filters = []string{topicCfg.Name}
+	}
+	subscribe := &paho.Subscribe{}
+	for _, filter := range filters {
+		subscribe.Subscriptions = append(subscribe.Subscriptions, paho.SubscribeOptions{Topic: filter, QoS: byte(qos)})
+	}
+
+	prefix := cfg.ClientID
+	if prefix == "" {
+		prefix = groupID
+	}
+	if prefix == "" {
+		prefix = defaultClientID
+	}
+	clientID := prefix + "_" + topicCfg.Name
+
+	c := &Consumer{
+		topic:    topicCfg.Name,
+		received: make(chan received, receiveBuffer),
+		closed:   make(chan struct{}),
+		pending:  make(map[int64]pendingMsg),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), setupTimeout)
+	defer cancel()
+	maxReceive := uint16(receiveMaximum)
+	conn, err := autopaho.NewConnection(context.Background(), autopaho.ClientConfig{
+		ServerUrls:                    urls,
+		KeepAlive:                     uint16(keepAlive / time.Second),
+		CleanStartOnInitialConnection: false, // Resume the session, and its unacknowledged messages, after a restart
+		SessionExpiryInterval:         uint32(sessionExpiry / time.Second),
+		ConnectUsername:               cfg.Username,
+		ConnectPassword:               []byte(cfg.Password),
+		ConnectPacketBuilder: func(connect *paho.Connect, _ *url.URL) (*paho.Connect, error) {
+			if connect.Properties == nil {
+				connect.Properties = &paho.ConnectProperties{}
+			}
+			connect.Properties.ReceiveMaximum = &maxReceive
+			return connect, nil
+		},
+		OnConnectionUp: func(cm *autopaho.ConnectionManager, _ *paho.Connack) {
+			// Must not block: subscribe in the background
+			go func() {
+				subCtx, cancel := context.WithTimeout(context.Background(), setupTimeout)
+				defer cancel()
+				if _, err := cm.Subscribe(subCtx, subscribe); err != nil {
+					log.Printf("Failed to subscribe topic %s to MQTT topics %v: %v", c.topic, filters, err)
+					return
+				}
+				log.Printf("Topic %s is read from MQTT topics %v with QoS %d by client %s", c.topic, filters, qos, clientID)
+			}()
+		},
+		OnConnectError: func(err error) {
+			log.Printf("Failed to connect to MQTT broker for topic %s: %v", c.topic, err)
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID:                   clientID,
+			EnableManualAcknowledgment: true,
+			OnPublishReceived:          []func(paho.PublishReceived) (bool, error){c.receive},
+			OnClientError: func(err error) {
+				log.Printf("MQTT client error for topic %s: %v", c.topic, err)
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT brokers %v: %w", rawURLs, err)
+	}
+	if err := conn.AwaitConnection(ctx); err != nil {
+		conn.Disconnect(context.Background())
+		return nil, fmt.Errorf("failed to connect to MQTT brokers %v: %w", rawURLs, err)
+	}
+	c.conn = conn
+	return c, nil
+}
+
+// receive queues a message for Fetch. Blocking while the queue is full stops the client
+// reading from the broker, which in turn stops sending.
+func (c *Consumer) receive(pr paho.PublishReceived) (bool, error) {
+	select {
+	case c.received <- received{publish: pr.Packet, client: pr.Client, at: time.Now()}:
+	case <-c.closed:
+	}
+	return true, nil
+}
+
+// Topic returns the name of the topic.
+func (c *Consumer) Topic() string {
+	return c.topic
+}
+
+// Fetch returns the next message, keeping QoS 1 messages to be acknowledged once their
+// window is processed. MQTT messages carry no timestamp; the time they arrived is used.
+func (c *Consumer) Fetch(ctx context.Context) (windowing.Message, error) {
+	var r received
+	select {
+	case <-ctx.Done():
+		return windowing.Message{}, ctx.Err()
+	case r = <-c.received:
+	}
+
+	fetched := windowing.Message{
+		Topic:     c.topic,
+		Offset:    c.nextSeq,
+		Key:       []byte(r.publish.Topic),
+		Value:     r.publish.Payload,
+		Timestamp: r.at,
+		Headers:   messageHeaders(r.publish.Properties),
+	}
+	c.nextSeq++
+	if fetched.Value == nil {
+		fetched.Value = []byte{} // An empty payload is not a tombstone
+	}
+	if r.publish.QoS > 0 {
+		c.mu.Lock()
+		c.pending[fetched.Offset] = pendingMsg{received: r, fetched: fetched}
+		pendingMessages.Set(float64(len(c.pending)), "topic", c.topic)
+		c.mu.Unlock()
+	}
+	return fetched, nil
+}
+
+// messageHeaders returns the first value of each user property, and the content type, nil if
+// there are none.
+func messageHeaders(props *paho.PublishProperties) map[string]string {
+	if props == nil || (len(props.User) == 0 && props.ContentType == "") {
+		return nil
+	}
+	headers := make(map[string]string, len(props.User)+1)
+	for _, p := range props.User {
+		if _, ok := headers[p.Key]; !ok {
+			headers[p.Key] = p.Value
+		}
+	}
+	if props.ContentType != "" {
+		headers["content-type"] = props.ContentType
+	}
+	return headers
+}
+
+// Commit does nothing: messages are acknowledged by the processor returned by AckAfter.
+func (c *Consumer) Commit(ctx context.Context, msg windowing.Message) error {
+	return nil
+}
+
+// AckAfter wraps the processor of the topic's windows: once a window has been processed, its
+// QoS 1 messages are acknowledged. MQTT has no negative acknowledgement and acknowledgements
+// are sent in the order messages arrived, so messages of windows that failed are acknowledged
+// as well rather than holding back those of later windows; only messages of windows lost in a
+// crash are redelivered. assigner is the topic's window assigner, which tells the messages of
+// concurrent windows (key shards) apart.
+func (c *Consumer) AckAfter(processor window.WindowProcessor, assigner windowing.WindowAssigner) window.WindowProcessor {
+	return windowing.ProcessorFunc[*window.Window](func(w *window.Window) error {
+		err := processor.ProcessWindow(w)
+		c.ack(w, assigner, err)
+		return err
+	})
+}
+
+// ack acknowledges the pending messages of the window: those with offsets in the window's
+// range that the assigner files under the window's key.
+func (c *Consumer) ack(w *window.Window, assigner windowing.WindowAssigner, processErr error) {
+	if w.FirstOffset < 0 {
+		return
+	}
+	c.mu.Lock()
+	var msgs []received
+	for offset, p := range c.pending {
+		if offset >= w.FirstOffset && offset <= w.LastOffset && assigner.Assign(p.fetched) == w.Key {
+			msgs = append(msgs, p.received)
+			delete(c.pending, offset)
+		}
+	}
+	pendingMessages.Set(float64(len(c.pending)), "topic", c.topic)
+	c.mu.Unlock()
+
+	result := "ok"
+	if processErr != nil {
+		result = "failed"
+	}
+	for _, r := range msgs {
+		if err := r.client.Ack(r.publish); err != nil {
+			log.Printf("Failed to acknowledge message on %s of topic %s: %v", r.publish.Topic, c.topic, err)
+			continue
+		}
+		messagesAcked.Inc("topic", c.topic, "result", result)
+	}
+}
+
+// Close disconnects from the broker. Messages not yet acknowledged are redelivered when the
+// session is resumed within mqtt.session_expiry_seconds.
+func (c *Consumer) Close() error {
+	close(c.closed)
+	ctx, cancel := context.WithTimeout(context.Background(), setupTimeout)
+	defer cancel()
+	return c.conn.Disconnect(ctx)
+}
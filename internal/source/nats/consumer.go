@@ -0,0 +1,271 @@
+// Package nats reads topics from NATS JetStream. A Consumer reads the subjects of a topic
+// through a durable pull consumer and acknowledges messages only once the window holding them
+// has been processed, so messages of windows that failed (or were lost in a crash) are
+// redelivered. It is a source.Source.
+package nats
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/metrics"
+	"stream-rag-agent/internal/window"
+	"stream-rag-agent/pkg/windowing"
+)
+
+// SourceName is the value of a topic's source option that selects this package.
+const SourceName = "nats"
+
+const (
+	defaultURL           = nats.DefaultURL
+	defaultMaxAckPending = 10000
+	defaultDurable       = "stream-rag-agent"
+	minAckWait           = time.Minute
+	setupTimeout         = 10 * time.Second
+)
+
+var (
+	messagesSettled = metrics.NewCounter("nats_messages_settled_total", "JetStream messages acknowledged after their window was processed, or negatively acknowledged for redelivery when it failed, by topic and result.")
+	pendingMessages = metrics.NewGauge("nats_pending_acks", "JetStream messages of a topic buffered in windows and not yet acknowledged.")
+)
+
+// Consumer reads a topic's subjects from JetStream. Messages are delivered as partition 0
+// with their stream sequence number as offset, their subject as key and their headers.
+type Consumer struct {
+	topic    string
+	conn     *nats.Conn
+	messages jetstream.MessagesContext
+
+	mu      sync.Mutex
+	pending map[int64]pendingMsg // By stream sequence, fetched and not yet settled
+}
+
+type pendingMsg struct {
+	msg     jetstream.Msg
+	fetched windowing.Message
+}
+
+// NewConsumer creates (or updates) the durable consumer of the topic and starts pulling
+// messages. The durable is named after nats.durable, or groupID when it is empty, and the
+// topic.
+func NewConsumer(topicCfg config.KafkaTopicConfig, cfg config.NATSConfig, groupID string) (*Consumer, error) {
+	url := cfg.URL
+	if url == "" {
+		url = defaultURL
+	}
+	opts := []nats.Option{nats.Name("stream-rag-agent"), nats.MaxReconnects(-1)}
+	if cfg.CredentialsFile != "" {
+		opts = append(opts, nats.UserCredentials(cfg.CredentialsFile))
+	}
+	if cfg.Username != "" {
+		opts = append(opts, nats.UserInfo(cfg.Username, cfg.Password))
+	}
+	if cfg.Token != "" {
+		opts = append(opts, nats.Token(cfg.Token))
+	}
+	conn, err := nats.Connect(url, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS at %s: %w", url, err)
+	}
+	c, err := newConsumer(conn, topicCfg, cfg, groupID)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func newConsumer(conn *nats.Conn, topicCfg config.KafkaTopicConfig, cfg config.NATSConfig, groupID string) (*Consumer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), setupTimeout)
+	defer cancel()
+	js, err := jetstream.New(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open JetStream: %w", err)
+	}
+
+	subjects := topicCfg.Subjects
+	if len(subjects) == 0 {
+		subjects = []string{topicCfg.Name}
+	}
+	stream := cfg.Stream
+	if stream == "" {
+		if stream, err = js.StreamNameBySubject(ctx, subjects[0]); err != nil {
+			return nil, fmt.Errorf("failed to find the JetStream stream of subject %s: %w", subjects[0], err)
+		}
+	}
+
+	prefix := cfg.Durable
+	if prefix == "" {
+		prefix = groupID
+	}
+	if prefix == "" {
+		prefix = defaultDurable
+	}
+	consumerCfg := jetstream.ConsumerConfig{
+		Durable:        durableName(prefix, topicCfg.Name),
+		Description:    "stream-rag-agent topic " + topicCfg.Name,
+		AckPolicy:      jetstream.AckExplicitPolicy,
+		AckWait:        time.Duration(cfg.AckWaitSeconds) * time.Second,
+		MaxAckPending:  cfg.MaxAckPending,
+		FilterSubjects: subjects,
+	}
+	if consumerCfg.AckWait <= 0 {
+		consumerCfg.AckWait = max(2*time.Duration(topicCfg.WindowDurationSeconds)*time.Second+time.Minute, minAckWait)
+	}
+	if consumerCfg.MaxAckPending <= 0 {
+		consumerCfg.MaxAckPending = defaultMaxAckPending
+	}
+	switch cfg.DeliverPolicy {
+	case "", "all":
+		consumerCfg.DeliverPolicy = jetstream.DeliverAllPolicy
+	case "new":
+		consumerCfg.DeliverPolicy = jetstream.DeliverNewPolicy
+	default:
+		return nil, fmt.Errorf("invalid nats.deliver_policy '%s' (expected all or new)", cfg.DeliverPolicy)
+	}
+	if len(subjects) == 1 {
+		// Servers before 2.10 only support a single filter subject
+		consumerCfg.FilterSubject, consumerCfg.FilterSubjects = subjects[0], nil
+	}
+
+	cons, err := js.CreateOrUpdateConsumer(ctx, stream, consumerCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create durable consumer %s on stream %s: %w", consumerCfg.Durable, stream, err)
+	}
+	messages, err := cons.Messages()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pull from durable consumer %s: %w", consumerCfg.Durable, err)
+	}
+	log.Printf("Topic %s is read from JetStream stream %s, subjects %v, by durable consumer %s (ack wait %s)",
+		topicCfg.Name, stream, subjects, consumerCfg.Durable, consumerCfg.AckWait)
+	return &Consumer{
+		topic:    topicCfg.Name,
+		conn:     conn,
+		messages: messages,
+		pending:  make(map[int64]pendingMsg),
+	}, nil
+}
+
+// durableName derives a durable consumer name, which may not contain '.', '*', '>' or
+// whitespace, from the prefix and the topic.
+func durableName(prefix, topic string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\n', '\r':
+			return '_'
+		}
+		return r
+	}, prefix+"_"+topic)
+}
+
+// Topic returns the name of the topic.
+func (c *Consumer) Topic() string {
+	return c.topic
+}
+
+// Fetch returns the next message, keeping it to be acknowledged once its window is processed.
+func (c *Consumer) Fetch(ctx context.Context) (windowing.Message, error) {
+	msg, err := c.messages.Next(jetstream.NextContext(ctx))
+	if err != nil {
+		return windowing.Message{}, err
+	}
+	meta, err := msg.Metadata()
+	if err != nil {
+		// Not a JetStream message; it cannot be acknowledged or redelivered
+		return windowing.Message{}, fmt.Errorf("failed to read metadata of message on %s: %w", msg.Subject(), err)
+	}
+	fetched := windowing.Message{
+		Topic:     c.topic,
+		Offset:    int64(meta.Sequence.Stream),
+		Key:       []byte(msg.Subject()),
+		Value:     msg.Data(),
+		Timestamp: meta.Timestamp,
+		Headers:   messageHeaders(msg.Headers()),
+	}
+	c.mu.Lock()
+	c.pending[fetched.Offset] = pendingMsg{msg: msg, fetched: fetched}
+	pendingMessages.Set(float64(len(c.pending)), "topic", c.topic)
+	c.mu.Unlock()
+	return fetched, nil
+}
+
+// messageHeaders returns the first value of each header, nil if there are none.
+func messageHeaders(header nats.Header) map[string]string {
+	if len(header) == 0 {
+		return nil
+	}
+	headers := make(map[string]string, len(header))
+	for name, values := range header {
+		if len(values) > 0 {
+			headers[name] = values[0]
+		}
+	}
+	return headers
+}
+
+// Commit does nothing: messages are acknowledged by the processor returned by AckAfter.
+func (c *Consumer) Commit(ctx context.Context, msg windowing.Message) error {
+	return nil
+}
+
+// AckAfter wraps the processor of the topic's windows: once a window has been processed, its
+// messages are acknowledged, or negatively acknowledged for redelivery when processing failed.
+// Windows dropped while shedding or vetoed by a webhook count as processed. assigner is the
+// topic's window assigner, which tells the messages of concurrent windows (key shards) apart.
+func (c *Consumer) AckAfter(processor window.WindowProcessor, assigner windowing.WindowAssigner) window.WindowProcessor {
+	return windowing.ProcessorFunc[*window.Window](func(w *window.Window) error {
+		err := processor.ProcessWindow(w)
+		c.settle(w, assigner, err)
+		return err
+	})
+}
+
+// settle settles the pending messages of the window: those with stream sequences in the
+// window's range that the assigner files under the window's key.
+func (c *Consumer) settle(w *window.Window, assigner windowing.WindowAssigner, processErr error) {
+	if w.FirstOffset < 0 {
+		return
+	}
+	c.mu.Lock()
+	var msgs []jetstream.Msg
+	for seq, p := range c.pending {
+		if seq >= w.FirstOffset && seq <= w.LastOffset && assigner.Assign(p.fetched) == w.Key {
+			msgs = append(msgs, p.msg)
+			delete(c.pending, seq)
+		}
+	}
+	pendingMessages.Set(float64(len(c.pending)), "topic", c.topic)
+	c.mu.Unlock()
+
+	result := "ack"
+	if processErr != nil {
+		result = "nak"
+	}
+	for _, msg := range msgs {
+		var err error
+		if processErr != nil {
+			err = msg.Nak()
+		} else {
+			err = msg.Ack()
+		}
+		if err != nil {
+			log.Printf("Failed to %s message on %s of topic %s: %v", result, msg.Subject(), c.topic, err)
+			continue
+		}
+		messagesSettled.Inc("topic", c.topic, "result", result)
+	}
+}
+
+// Close stops pulling and closes the connection. Messages still pending are redelivered by
+// JetStream after their ack wait.
+func (c *Consumer) Close() error {
+	c.messages.Stop()
+	return c.conn.Drain()
+}
@@ -0,0 +1,440 @@
+// Package redis reads topics from Redis Streams. A Consumer reads the stream keys of a topic
+// as a member of a consumer group and acknowledges entries (XACK) only once the window holding
+// them has been processed, so entries of windows that failed, or were lost in a crash, are
+// read again. It is a source.Source.
+package redis
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/metrics"
+	"stream-rag-agent/internal/window"
+	"stream-rag-agent/pkg/windowing"
+)
+
+// SourceName is the value of a topic's source option that selects this package.
+const SourceName = "redis"
+
+const (
+	defaultAddr     = "localhost:6379"
+	defaultGroup    = "stream-rag-agent"
+	defaultCount    = 100
+	blockTimeout    = 2 * time.Second // Bounds how long Fetch misses a cancelled context
+	claimInterval   = 30 * time.Second
+	minClaimIdle    = time.Minute
+	setupTimeout    = 10 * time.Second
+	startEarliest   = "earliest"
+	startLatest     = "latest"
+	busyGroupPrefix = "BUSYGROUP" // Error of XGROUP CREATE for an existing group
+)
+
+var (
+	entriesSettled = metrics.NewCounter("redis_entries_settled_total", "Redis stream entries acknowledged after their window was processed, or left pending to be claimed again when it failed, by topic and result.")
+	pendingEntries = metrics.NewGauge("redis_pending_acks", "Redis stream entries of a topic buffered in windows and not yet acknowledged.")
+	claimedEntries = metrics.NewCounter("redis_entries_claimed_total", "Idle pending Redis stream entries claimed to be read again, by topic.")
+)
+
+// Consumer reads a topic's stream keys. Entries are delivered with the index of their stream
+// key as partition, a sequence number counted since the consumer started as offset, the time
+// of their entry ID as timestamp, and their key field (or stream key) as key.
+type Consumer struct {
+	topic      string
+	client     *goredis.Client
+	group      string
+	consumer   string
+	streams    []string
+	partitions map[string]int32 // By stream key
+	valueField string
+	keyField   string
+	count      int64
+	claimIdle  time.Duration // 0 when claiming is disabled
+
+	// Only used by Fetch
+	backlog   map[string]string // By stream key: entry ID after which this consumer's own pending entries are read next
+	claimFrom map[string]string // By stream key: where the next XAUTOCLAIM scan starts
+	nextClaim time.Time
+	queue     []windowing.Message // Read and not yet returned
+	nextSeq   int64
+
+	mu      sync.Mutex
+	pending map[int64]pendingEntry // By offset, fetched and not yet acknowledged
+	ids     map[string]bool        // Stream key and entry ID of the pending entries, see entryKey
+}
+
+type pendingEntry struct {
+	stream  string
+	id      string
+	fetched windowing.Message
+}
+
+// NewConsumer connects to Redis and creates the consumer group <redis.group>_<topic>, or
+// groupID when redis.group is empty, on each stream key of the topic unless it exists.
+func NewConsumer(topicCfg config.KafkaTopicConfig, cfg config.RedisConfig, groupID string) (*Consumer, error) {
+	addr := cfg.Addr
+	if addr == "" {
+		addr = defaultAddr
+	}
+	opts := &goredis.Options{Addr: addr, Username: cfg.Username, Password: cfg.Password, DB: cfg.DB}
+	if cfg.TLS {
+		opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	client := goredis.NewClient(opts)
+	c, err := newConsumer(client, topicCfg, cfg, groupID)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func newConsumer(client *goredis.Client, topicCfg config.KafkaTopicConfig, cfg config.RedisConfig, groupID string) (*Consumer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), setupTimeout)
+	defer cancel()
+
+	start := "0"
+	switch cfg.StartPosition {
+	case "", startEarliest:
+	case startLatest:
+		start = "$"
+	default:
+		return nil, fmt.Errorf("invalid redis.start_position '%s' (expected %s or %s)", cfg.StartPosition, startEarliest, startLatest)
+	}
+	consumer := os.ExpandEnv(cfg.Consumer)
+	if consumer == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("redis.consumer is not set and the host name is unknown: %w", err)
+		}
+		consumer = host
+	}
+	prefix := cfg.Group
+	if prefix == "" {
+		prefix = groupID
+	}
+	if prefix == "" {
+		prefix = defaultGroup
+	}
+	claimIdle := time.Duration(cfg.ClaimIdleSeconds) * time.Second
+	switch {
+	case cfg.ClaimIdleSeconds < 0:
+		claimIdle = 0
+	case claimIdle == 0:
+		claimIdle = max(2*time.Duration(topicCfg.WindowDurationSeconds)*time.Second+time.Minute, minClaimIdle)
+	}
+	count := int64(cfg.Count)
+	if count <= 0 {
+		count = defaultCount
+	}
+
+	streams := topicCfg.StreamKeys
+	if len(streams) == 0 {
+		streams = []string{topicCfg.Name}
+	}
+	c := &Consumer{
+		topic:      topicCfg.Name,
+		client:     client,
+		group:      prefix + "_" + topicCfg.Name,
+		consumer:   consumer,
+		streams:    streams,
+		partitions: make(map[string]int32, len(streams)),
+		valueField: cfg.ValueField,
+		keyField:   cfg.KeyField,
+		count:      count,
+		claimIdle:  claimIdle,
+		backlog:    make(map[string]string, len(streams)),
+		claimFrom:  make(map[string]string, len(streams)),
+		pending:    make(map[int64]pendingEntry),
+		ids:        make(map[string]bool),
+	}
+	for i, stream := range streams {
+		err := client.XGroupCreateMkStream(ctx, stream, c.group, start).Err()
+		if err != nil && !strings.HasPrefix(err.Error(), busyGroupPrefix) {
+			return nil, fmt.Errorf("failed to create consumer group %s on Redis stream %s: %w", c.group, stream, err)
+		}
+		c.partitions[stream] = int32(i)
+		c.backlog[stream] = "0" // Entries read before a restart and never acknowledged
+		c.claimFrom[stream] = "0-0"
+	}
+	log.Printf("Topic %s is read from Redis streams %v at %s by consumer %s of group %s (claim idle %s)",
+		topicCfg.Name, streams, client.Options().Addr, consumer, c.group, claimIdle)
+	return c, nil
+}
+
+// Topic returns the name of the topic.
+func (c *Consumer) Topic() string {
+	return c.topic
+}
+
+// Streams returns the stream keys of the topic, in partition order.
+func (c *Consumer) Streams() []string {
+	return c.streams
+}
+
+// Fetch returns the next entry, keeping it to be acknowledged once its window is processed.
+// Entries this consumer read before it restarted and never acknowledged come first, then
+// idle entries claimed from the group, then new entries.
+func (c *Consumer) Fetch(ctx context.Context) (windowing.Message, error) {
+	for len(c.queue) == 0 {
+		if err := ctx.Err(); err != nil {
+			return windowing.Message{}, err
+		}
+		if err := c.read(ctx); err != nil {
+			return windowing.Message{}, err
+		}
+	}
+	msg := c.queue[0]
+	c.queue = c.queue[1:]
+	return msg, nil
+}
+
+// read queues the next entries, if any arrive within blockTimeout.
+func (c *Consumer) read(ctx context.Context) error {
+	if len(c.backlog) > 0 {
+		return c.readBacklog(ctx)
+	}
+	if c.claimIdle > 0 && time.Now().After(c.nextClaim) {
+		c.nextClaim = time.Now().Add(claimInterval)
+		if err := c.claim(ctx); err != nil {
+			return err
+		}
+		if len(c.queue) > 0 {
+			return nil
+		}
+	}
+
+	streams := make([]string, 0, 2*len(c.streams))
+	streams = append(streams, c.streams...)
+	for range c.streams {
+		streams = append(streams, ">")
+	}
+	result, err := c.client.XReadGroup(ctx, &goredis.XReadGroupArgs{
+		Group:    c.group,
+		Consumer: c.consumer,
+		Streams:  streams,
+		Count:    c.count,
+		Block:    blockTimeout,
+	}).Result()
+	if errors.Is(err, goredis.Nil) {
+		return nil // Nothing arrived
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read Redis streams %v: %w", c.streams, err)
+	}
+	for _, s := range result {
+		c.enqueue(ctx, s.Stream, s.Messages)
+	}
+	return nil
+}
+
+// readBacklog queues entries delivered to this consumer before it started that were never
+// acknowledged, a page per stream key at a time.
+func (c *Consumer) readBacklog(ctx context.Context) error {
+	streams := make([]string, 0, 2*len(c.backlog))
+	ids := make([]string, 0, len(c.backlog))
+	for stream, after := range c.backlog {
+		streams = append(streams, stream)
+		ids = append(ids, after)
+	}
+	result, err := c.client.XReadGroup(ctx, &goredis.XReadGroupArgs{
+		Group:    c.group,
+		Consumer: c.consumer,
+		Streams:  append(streams, ids...),
+		Count:    c.count,
+		Block:    -1, // Pending entries are returned right away
+	}).Result()
+	if err != nil && !errors.Is(err, goredis.Nil) {
+		return fmt.Errorf("failed to read pending entries of Redis streams %v: %w", streams, err)
+	}
+	read := make(map[string]bool, len(result))
+	for _, s := range result {
+		if len(s.Messages) == 0 {
+			continue
+		}
+		read[s.Stream] = true
+		c.backlog[s.Stream] = s.Messages[len(s.Messages)-1].ID
+		c.enqueue(ctx, s.Stream, s.Messages)
+	}
+	for _, stream := range streams {
+		if !read[stream] {
+			delete(c.backlog, stream)
+		}
+	}
+	return nil
+}
+
+// claim queues idle entries of the group that were delivered and never acknowledged, e.g. by
+// an agent that stopped for good or in windows that failed. Entries still buffered in this
+// consumer's windows are skipped.
+func (c *Consumer) claim(ctx context.Context) error {
+	for _, stream := range c.streams {
+		entries, next, err := c.client.XAutoClaim(ctx, &goredis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    c.group,
+			MinIdle:  c.claimIdle,
+			Start:    c.claimFrom[stream],
+			Count:    c.count,
+			Consumer: c.consumer,
+		}).Result()
+		if err != nil {
+			return fmt.Errorf("failed to claim idle entries of Redis stream %s: %w", stream, err)
+		}
+		c.claimFrom[stream] = next
+		c.mu.Lock()
+		claimed := entries[:0]
+		for _, entry := range entries {
+			if !c.ids[entryKey(stream, entry.ID)] {
+				claimed = append(claimed, entry)
+			}
+		}
+		c.mu.Unlock()
+		if len(claimed) > 0 {
+			log.Printf("Claimed %d idle entries of Redis stream %s for topic %s", len(claimed), stream, c.topic)
+			claimedEntries.Add(float64(len(claimed)), "topic", c.topic)
+		}
+		c.enqueue(ctx, stream, claimed)
+	}
+	return nil
+}
+
+// enqueue converts entries of a stream into messages and queues them. Entries deleted from
+// the stream while pending have no fields; they are acknowledged right away.
+func (c *Consumer) enqueue(ctx context.Context, stream string, entries []goredis.XMessage) {
+	var deleted []string
+	c.mu.Lock()
+	for _, entry := range entries {
+		if entry.Values == nil {
+			deleted = append(deleted, entry.ID)
+			continue
+		}
+		fetched := c.message(stream, entry)
+		c.pending[fetched.Offset] = pendingEntry{stream: stream, id: entry.ID, fetched: fetched}
+		c.ids[entryKey(stream, entry.ID)] = true
+		c.queue = append(c.queue, fetched)
+	}
+	pendingEntries.Set(float64(len(c.pending)), "topic", c.topic)
+	c.mu.Unlock()
+	if len(deleted) > 0 {
+		if err := c.client.XAck(ctx, stream, c.group, deleted...).Err(); err != nil {
+			log.Printf("Failed to acknowledge %d deleted entries of Redis stream %s: %v", len(deleted), stream, err)
+		}
+	}
+}
+
+// message converts an entry: the value is its value field, or all its fields as a JSON
+// object, and the other fields become headers when a value field is configured.
+func (c *Consumer) message(stream string, entry goredis.XMessage) windowing.Message {
+	msg := windowing.Message{
+		Topic:     c.topic,
+		Partition: c.partitions[stream],
+		Offset:    c.nextSeq,
+		Key:       []byte(stream),
+		Timestamp: entryTime(entry.ID),
+	}
+	c.nextSeq++
+	if key, ok := entry.Values[c.keyField]; ok && c.keyField != "" {
+		msg.Key = []byte(fmt.Sprint(key))
+	}
+	value, ok := entry.Values[c.valueField]
+	if !ok || c.valueField == "" {
+		msg.Value, _ = json.Marshal(entry.Values) // Field values are strings
+		return msg
+	}
+	msg.Value = []byte(fmt.Sprint(value))
+	for field, v := range entry.Values {
+		if field == c.valueField || field == c.keyField {
+			continue
+		}
+		if msg.Headers == nil {
+			msg.Headers = make(map[string]string, len(entry.Values))
+		}
+		msg.Headers[field] = fmt.Sprint(v)
+	}
+	return msg
+}
+
+// entryTime returns the time of an entry ID (<milliseconds>-<sequence>), when the entry was
+// added unless the producer chose the ID.
+func entryTime(id string) time.Time {
+	ms, _, _ := strings.Cut(id, "-")
+	millis, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return time.Now()
+	}
+	return time.UnixMilli(millis)
+}
+
+func entryKey(stream, id string) string {
+	return stream + " " + id
+}
+
+// Commit does nothing: entries are acknowledged by the processor returned by AckAfter.
+func (c *Consumer) Commit(ctx context.Context, msg windowing.Message) error {
+	return nil
+}
+
+// AckAfter wraps the processor of the topic's windows: once a window has been processed, its
+// entries are acknowledged. Entries of windows that failed stay pending in the group and are
+// claimed and read again once they have been idle for redis.claim_idle_seconds. Windows
+// dropped while shedding or vetoed by a webhook count as processed. assigner is the topic's
+// window assigner, which tells the entries of concurrent windows (key shards) apart.
+func (c *Consumer) AckAfter(processor window.WindowProcessor, assigner windowing.WindowAssigner) window.WindowProcessor {
+	return windowing.ProcessorFunc[*window.Window](func(w *window.Window) error {
+		err := processor.ProcessWindow(w)
+		c.settle(w, assigner, err)
+		return err
+	})
+}
+
+// settle settles the pending entries of the window: those of its partition with offsets in
+// the window's range that the assigner files under the window's key.
+func (c *Consumer) settle(w *window.Window, assigner windowing.WindowAssigner, processErr error) {
+	if w.FirstOffset < 0 {
+		return
+	}
+	c.mu.Lock()
+	ids := make(map[string][]string) // By stream key
+	settled := 0
+	for offset, p := range c.pending {
+		if offset >= w.FirstOffset && offset <= w.LastOffset && p.fetched.Partition == w.Partition && assigner.Assign(p.fetched) == w.Key {
+			ids[p.stream] = append(ids[p.stream], p.id)
+			delete(c.pending, offset)
+			delete(c.ids, entryKey(p.stream, p.id))
+			settled++
+		}
+	}
+	pendingEntries.Set(float64(len(c.pending)), "topic", c.topic)
+	c.mu.Unlock()
+
+	if processErr != nil {
+		entriesSettled.Add(float64(settled), "topic", c.topic, "result", "retry")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), setupTimeout)
+	defer cancel()
+	for stream, streamIDs := range ids {
+		if err := c.client.XAck(ctx, stream, c.group, streamIDs...).Err(); err != nil {
+			log.Printf("Failed to acknowledge %d entries of Redis stream %s of topic %s: %v", len(streamIDs), stream, c.topic, err)
+			continue
+		}
+		entriesSettled.Add(float64(len(streamIDs)), "topic", c.topic, "result", "ack")
+	}
+}
+
+// Close closes the connection. Entries not yet acknowledged stay pending in the group: they
+// are read again when the agent restarts with the same consumer name, or claimed by another
+// consumer once they are idle for redis.claim_idle_seconds.
+func (c *Consumer) Close() error {
+	return c.client.Close()
+}
@@ -0,0 +1,133 @@
+// Package source defines the pull-based stream sources the agent windows: Kafka, the demo
+// generator, or any other backend (NATS, Pulsar, files) that can fetch messages one at a
+// time and acknowledge them once they are buffered.
+package source
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"stream-rag-agent/pkg/windowing"
+)
+
+// fetchRetryDelay is how long Run waits after a failed fetch before trying again.
+const fetchRetryDelay = time.Second
+
+// Source is a stream of messages. Fetch and Commit are called from a single goroutine:
+// Commit acknowledges the message returned by the preceding Fetch.
+type Source interface {
+	// Fetch blocks until the next message is available or ctx is cancelled.
+	Fetch(ctx context.Context) (windowing.Message, error)
+	// Commit records that the message has been handed to the windows, so it is not delivered
+	// again after a restart. Sources without positions can return nil.
+	Commit(ctx context.Context, msg windowing.Message) error
+	Close() error
+}
+
+// BatchSource is a Source that can fetch and commit several messages at once, which saves a
+// round trip per message at high throughput.
+type BatchSource interface {
+	Source
+	// FetchBatch blocks until at least one message is available or ctx is cancelled, then
+	// returns it with the messages that follow within the source's batch limits.
+	FetchBatch(ctx context.Context) ([]windowing.Message, error)
+	// CommitBatch acknowledges the messages returned by the preceding FetchBatch.
+	CommitBatch(ctx context.Context, msgs []windowing.Message) error
+}
+
+// PausableSink is a sink that can hold fetching back, e.g. while ingestion of its topic is
+// paused (see ingestion.Sink).
+type PausableSink interface {
+	windowing.Sink
+	// WaitResumed blocks while the sink is paused, until ctx is cancelled.
+	WaitResumed(ctx context.Context)
+}
+
+// waitResumed waits for a PausableSink to be resumed before the next fetch.
+func waitResumed(ctx context.Context, sink windowing.Sink) {
+	if pausable, ok := sink.(PausableSink); ok {
+		pausable.WaitResumed(ctx)
+	}
+}
+
+// Run feeds the source into the sink, committing each message once the sink has it, until ctx
+// is cancelled. Fetch errors are logged and retried. A BatchSource is fed a batch at a time.
+// Nothing is fetched while a PausableSink is paused.
+func Run(ctx context.Context, name string, src Source, sink windowing.Sink) error {
+	log.Printf("Starting source for topic: %s", name)
+	if batches, ok := src.(BatchSource); ok {
+		return runBatches(ctx, name, batches, sink)
+	}
+	for {
+		waitResumed(ctx, sink)
+		msg, err := src.Fetch(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Printf("Stopping source for topic: %s", name)
+				return nil
+			}
+			log.Printf("Error fetching message from topic %s: %v", name, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(fetchRetryDelay):
+			}
+			continue
+		}
+
+		sink.AddMessage(msg)
+
+		if err := src.Commit(ctx, msg); err != nil {
+			log.Printf("Error committing offset for topic %s, partition %d, offset %d: %v", msg.Topic, msg.Partition, msg.Offset, err)
+		}
+	}
+}
+
+// runBatches is Run for a BatchSource: each batch is handed to the sink, in one call when it
+// is a windowing.BatchSink, and then committed.
+func runBatches(ctx context.Context, name string, src BatchSource, sink windowing.Sink) error {
+	batchSink, _ := sink.(windowing.BatchSink)
+	for {
+		waitResumed(ctx, sink)
+		msgs, err := src.FetchBatch(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Printf("Stopping source for topic: %s", name)
+				return nil
+			}
+			log.Printf("Error fetching messages from topic %s: %v", name, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(fetchRetryDelay):
+			}
+			continue
+		}
+
+		if batchSink != nil {
+			batchSink.AddMessages(msgs)
+		} else {
+			for _, msg := range msgs {
+				sink.AddMessage(msg)
+			}
+		}
+
+		if err := src.CommitBatch(ctx, msgs); err != nil {
+			last := msgs[len(msgs)-1]
+			log.Printf("Error committing %d offsets for topic %s (last: partition %d, offset %d): %v", len(msgs), name, last.Partition, last.Offset, err)
+		}
+	}
+}
+
+// Adapt returns the source as a windowing.Source, for embedding it in a windowing.Engine.
+func Adapt(name string, src Source) windowing.Source {
+	return adapter{name: name, src: src}
+}
+
+type adapter struct {
+	name string
+	src  Source
+}
+
+func (a adapter) Run(ctx context.Context, sink windowing.Sink) error {
+	return Run(ctx, a.name, a.src, sink)
+}
@@ -0,0 +1,233 @@
+package vectordb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	elastic "github.com/olivere/elastic/v7"
+	"stream-rag-agent/internal/atrest"
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/metrics"
+)
+
+const (
+	defaultBulkMaxRetries     = 3
+	defaultBulkRetryBackoff   = 500 * time.Millisecond
+	defaultRecentBulkFailures = 100
+
+	requestFailedType = "bulk_request_failed" // Type of documents whose retry request failed as a whole
+)
+
+var (
+	bulkRetriesTotal      = metrics.NewCounter("elasticsearch_bulk_retries_total", "Documents resent after Elasticsearch rejected them within a bulk request, by kind.")
+	documentFailuresTotal = metrics.NewCounter("elasticsearch_document_failures_total", "Documents of bulk requests that could not be indexed, by kind and Elasticsearch error type.")
+)
+
+// DocumentFailure is a document of a bulk request that Elasticsearch did not index.
+type DocumentFailure struct {
+	Kind         string    `json:"kind"` // "window" or "event"
+	Index        string    `json:"index"`
+	ID           string    `json:"id"`
+	Status       int       `json:"status"` // Status of the document's bulk item
+	Type         string    `json:"type"`   // Elasticsearch error type, e.g. mapper_parsing_exception
+	Reason       string    `json:"reason"`
+	Attempts     int       `json:"attempts"`
+	FailedAt     time.Time `json:"failed_at"`
+	DeadLettered bool      `json:"dead_lettered"` // Whether the document was appended to the dead-letter directory
+
+	position int // Of the document in the bulk request
+}
+
+// deadLetter is a line of a dead-letter file: the failure and the document that failed.
+type deadLetter struct {
+	DocumentFailure
+	Document interface{} `json:"document"`
+}
+
+// bulkDocument is a document to index with a bulk request.
+type bulkDocument struct {
+	index string
+	id    string
+	doc   interface{}
+}
+
+// bulkFailures retries rejected documents and keeps the most recent permanent failures.
+type bulkFailures struct {
+	maxRetries int
+	backoff    time.Duration
+	dir        string
+	limit      int
+
+	mu     sync.Mutex
+	recent []DocumentFailure // Oldest first
+}
+
+func newBulkFailures(cfg config.BulkFailuresConfig) (*bulkFailures, error) {
+	f := &bulkFailures{
+		maxRetries: cfg.MaxRetries,
+		backoff:    time.Duration(cfg.RetryBackoffMs) * time.Millisecond,
+		dir:        cfg.DeadLetterDir,
+		limit:      cfg.RecentErrors,
+	}
+	if f.maxRetries <= 0 {
+		f.maxRetries = defaultBulkMaxRetries
+	}
+	if f.backoff <= 0 {
+		f.backoff = defaultBulkRetryBackoff
+	}
+	if f.limit <= 0 {
+		f.limit = defaultRecentBulkFailures
+	}
+	if f.dir != "" {
+		if err := os.MkdirAll(f.dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create dead-letter directory: %w", err)
+		}
+	}
+	return f, nil
+}
+
+// retryable reports whether a document rejected with the status may be indexed later:
+// rejected executions and server-side failures are, mapping and size errors are not.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// bulkIndex indexes the documents, resending only those rejected with a retryable status.
+// Documents that still fail are recorded and returned. An error is returned, and nothing
+// recorded, when the first request fails as a whole.
+func (c *ElasticsearchClient) bulkIndex(ctx context.Context, kind string, docs []bulkDocument) ([]DocumentFailure, error) {
+	pending := make([]int, len(docs))
+	for i := range pending {
+		pending[i] = i
+	}
+
+	var failures []DocumentFailure
+	backoff := c.bulkFailures.backoff
+	for attempt := 1; len(pending) > 0; attempt++ {
+		bulk := c.client.Bulk()
+		for _, i := range pending {
+			bulk.Add(elastic.NewBulkIndexRequest().Index(docs[i].index).Id(docs[i].id).Doc(docs[i].doc))
+		}
+		resp, err := bulk.Do(ctx)
+		if err != nil {
+			if attempt == 1 {
+				return nil, err
+			}
+			for _, i := range pending {
+				failures = append(failures, DocumentFailure{Type: requestFailedType, Reason: err.Error(), Attempts: attempt, position: i})
+			}
+			break
+		}
+
+		var retry []int
+		for j, item := range resp.Items {
+			res := item["index"]
+			if j >= len(pending) || res == nil || res.Error == nil {
+				continue
+			}
+			i := pending[j]
+			if retryable(res.Status) && attempt <= c.bulkFailures.maxRetries {
+				retry = append(retry, i)
+				continue
+			}
+			failures = append(failures, DocumentFailure{Status: res.Status, Type: res.Error.Type, Reason: res.Error.Reason, Attempts: attempt, position: i})
+		}
+		pending = retry
+		if len(pending) == 0 {
+			break
+		}
+
+		bulkRetriesTotal.Add(float64(len(pending)), "kind", kind)
+		log.Printf("Retrying %d %s documents rejected by Elasticsearch in %s", len(pending), kind, backoff)
+		select {
+		case <-ctx.Done():
+			for _, i := range pending {
+				failures = append(failures, DocumentFailure{Type: requestFailedType, Reason: ctx.Err().Error(), Attempts: attempt, position: i})
+			}
+			pending = nil
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+
+	for j := range failures {
+		f := &failures[j]
+		doc := docs[f.position]
+		f.Kind, f.Index, f.ID, f.FailedAt = kind, doc.index, doc.id, time.Now()
+		c.bulkFailures.record(f, doc.doc)
+	}
+	return failures, nil
+}
+
+// record keeps the failure for DocumentFailures and appends it with the document to the
+// dead-letter file of its kind and day.
+func (b *bulkFailures) record(f *DocumentFailure, doc interface{}) {
+	log.Printf("Elasticsearch did not index %s document '%s' into '%s' after %d attempt(s): %s: %s", f.Kind, f.ID, f.Index, f.Attempts, f.Type, f.Reason)
+	documentFailuresTotal.Inc("kind", f.Kind, "type", f.Type)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dir != "" {
+		if err := b.deadLetter(f, doc); err != nil {
+			log.Printf("Failed to dead-letter %s document '%s': %v", f.Kind, f.ID, err)
+		} else {
+			f.DeadLettered = true
+		}
+	}
+	b.recent = append(b.recent, *f)
+	if len(b.recent) > b.limit {
+		b.recent = b.recent[len(b.recent)-b.limit:]
+	}
+}
+
+// deadLetter appends a JSON line to <dir>/<kind>-<YYYYMMDD>.jsonl.
+func (b *bulkFailures) deadLetter(f *DocumentFailure, doc interface{}) error {
+	line := deadLetter{DocumentFailure: *f, Document: doc}
+	line.DeadLettered = true
+	data, err := json.Marshal(line)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter: %w", err)
+	}
+	if data, err = atrest.SealLine(data); err != nil {
+		return fmt.Errorf("failed to encrypt dead letter: %w", err)
+	}
+	path := filepath.Join(b.dir, fmt.Sprintf("%s-%s.jsonl", f.Kind, f.FailedAt.Format("20060102")))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open dead-letter file: %w", err)
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write dead-letter file: %w", err)
+	}
+	return file.Close()
+}
+
+// DocumentFailures returns the most recent documents that bulk requests failed to index,
+// newest first.
+func (c *ElasticsearchClient) DocumentFailures() []DocumentFailure {
+	b := c.bulkFailures
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	failures := make([]DocumentFailure, 0, len(b.recent))
+	for i := len(b.recent) - 1; i >= 0; i-- {
+		failures = append(failures, b.recent[i])
+	}
+	return failures
+}
+
+// failedPositions returns the positions in the bulk request of the failed documents.
+func failedPositions(failures []DocumentFailure) map[int]bool {
+	positions := make(map[int]bool, len(failures))
+	for _, f := range failures {
+		positions[f.position] = true
+	}
+	return positions
+}
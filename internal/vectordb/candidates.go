@@ -0,0 +1,70 @@
+package vectordb
+
+import (
+	"context"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"stream-rag-agent/internal/config"
+)
+
+const (
+	defaultMinNumCandidates = 100
+	defaultMaxNumCandidates = 10000 // Elasticsearch's upper limit
+	defaultCandidatesRatio  = 0.01
+	docCountTTL             = time.Minute
+)
+
+// candidateTuner sizes the kNN num_candidates from the index document count so recall does
+// not degrade as the index grows. The count is cached and refreshed at most once a minute.
+type candidateTuner struct {
+	cfg       config.NumCandidatesConfig
+	mu        sync.Mutex
+	docCount  int64
+	refreshed time.Time
+}
+
+func newCandidateTuner(cfg config.NumCandidatesConfig) *candidateTuner {
+	if cfg.Min <= 0 {
+		cfg.Min = defaultMinNumCandidates
+	}
+	if cfg.Max <= 0 || cfg.Max > defaultMaxNumCandidates {
+		cfg.Max = defaultMaxNumCandidates
+	}
+	if cfg.Ratio <= 0 {
+		cfg.Ratio = defaultCandidatesRatio
+	}
+	return &candidateTuner{cfg: cfg}
+}
+
+// NumCandidates returns the num_candidates used for a kNN search returning k results:
+// ratio × index documents, bounded by the configured min and max and never below k.
+func (c *ElasticsearchClient) NumCandidates(k int) int {
+	t := c.candidates
+	t.mu.Lock()
+	if time.Since(t.refreshed) > docCountTTL {
+		count, err := c.client.Count(c.searchIndices()...).Do(context.Background())
+		if err != nil {
+			log.Printf("Warning: could not count documents of index '%s' to size num_candidates: %v", c.indexName, err)
+		} else {
+			t.docCount = count
+		}
+		t.refreshed = time.Now()
+	}
+	docCount := t.docCount
+	t.mu.Unlock()
+
+	n := int(math.Ceil(float64(docCount) * t.cfg.Ratio))
+	if n < t.cfg.Min {
+		n = t.cfg.Min
+	}
+	if n > t.cfg.Max {
+		n = t.cfg.Max
+	}
+	if n < k {
+		n = k
+	}
+	return n
+}
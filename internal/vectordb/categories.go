@@ -0,0 +1,71 @@
+package vectordb
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"stream-rag-agent/internal/window"
+)
+
+const categoryIndexInfix = "_category_"
+
+// categoryIndices tracks the per-category windows indices (<index_name>_category_<category>)
+// that exist, creating them with the windows mapping on first use.
+type categoryIndices struct {
+	mu      sync.Mutex
+	ensured map[string]bool
+}
+
+func newCategoryIndices(enabled bool) *categoryIndices {
+	if !enabled {
+		return nil
+	}
+	return &categoryIndices{ensured: make(map[string]bool)}
+}
+
+// writeIndex returns the index a window is stored in: its category's index when categorized
+// windows are routed, the main index otherwise.
+func (c *ElasticsearchClient) writeIndex(ew *window.EmbeddedWindow) (string, error) {
+	if c.categories == nil || ew.Category == "" {
+		return c.indexName, nil
+	}
+	name := c.indexName + categoryIndexInfix + ew.Category
+
+	c.categories.mu.Lock()
+	defer c.categories.mu.Unlock()
+	if !c.categories.ensured[name] {
+		if err := c.createIndexWithMapping(name); err != nil {
+			return "", fmt.Errorf("failed to create category index: %w", err)
+		}
+		c.categories.ensured[name] = true
+	}
+	return name, nil
+}
+
+// searchIndices returns the indices windows are read from: the main index and, when routing
+// is enabled, every category index.
+func (c *ElasticsearchClient) searchIndices() []string {
+	if c.categories == nil {
+		return []string{c.indexName}
+	}
+	return []string{c.indexName, c.indexName + categoryIndexInfix + "*"}
+}
+
+// windowIndices resolves searchIndices to the names of the existing indices.
+func (c *ElasticsearchClient) windowIndices() ([]string, error) {
+	if c.categories == nil {
+		return []string{c.indexName}, nil
+	}
+	names, err := c.client.IndexNames()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list indices: %w", err)
+	}
+	indices := []string{c.indexName}
+	for _, name := range names {
+		if strings.HasPrefix(name, c.indexName+categoryIndexInfix) {
+			indices = append(indices, name)
+		}
+	}
+	return indices, nil
+}
@@ -0,0 +1,106 @@
+package vectordb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"stream-rag-agent/pkg/errs"
+)
+
+// maxCoverageWindows bounds the windows examined by a coverage report.
+const maxCoverageWindows = 10000
+
+// OffsetRange is an inclusive range of offsets of one partition.
+type OffsetRange struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+// CoveredRange is the offset range an indexed window covers.
+type CoveredRange struct {
+	WindowID    string `json:"window_id"`
+	FirstOffset int64  `json:"first_offset"`
+	LastOffset  int64  `json:"last_offset"`
+}
+
+// OffsetCoverage tells which offsets of a partition are covered by indexed windows, to verify
+// that a replay or backfill indexed every message exactly once.
+type OffsetCoverage struct {
+	Topic     string         `json:"topic"`
+	Partition int32          `json:"partition"`
+	From      int64          `json:"from"`
+	To        int64          `json:"to"`
+	Windows   []CoveredRange `json:"windows"`
+	Gaps      []OffsetRange  `json:"gaps"`     // Offsets no window covers
+	Overlaps  []OffsetRange  `json:"overlaps"` // Offsets covered by more than one window
+	Truncated bool           `json:"truncated,omitempty"`
+}
+
+// OffsetCoverage reports the windows of a topic partition overlapping the offsets [from, to]
+// and the gaps and overlaps between them. Windows indexed before offsets were recorded are
+// not found. Gaps are not necessarily lost messages: compacted topics and transaction markers
+// leave offsets without messages as well.
+func (c *ElasticsearchClient) OffsetCoverage(topic string, partition int32, from, to int64) (*OffsetCoverage, error) {
+	if from < 0 || to < from {
+		return nil, errs.Errorf(errs.ErrInvalidRequest, "invalid offset range [%d, %d]", from, to)
+	}
+	ctx := context.Background()
+	query := map[string]interface{}{"bool": map[string]interface{}{"filter": []map[string]interface{}{
+		{"term": map[string]interface{}{"topic": topic}},
+		{"term": map[string]interface{}{"partition": partition}},
+		{"range": map[string]interface{}{"last_offset": map[string]interface{}{"gte": from}}},
+		{"range": map[string]interface{}{"first_offset": map[string]interface{}{"lte": to}}},
+	}}}
+	scroll := c.client.Scroll(c.searchIndices()...).
+		Body(map[string]interface{}{
+			"query":   query,
+			"sort":    []interface{}{map[string]interface{}{"first_offset": "asc"}, map[string]interface{}{"last_offset": "asc"}},
+			"_source": []string{"window_id", "first_offset", "last_offset"},
+		}).
+		Size(scrollBatchSize).
+		KeepAlive("1m")
+	defer func() {
+		if err := scroll.Clear(ctx); err != nil {
+			log.Printf("Error clearing Elasticsearch scroll: %v", err)
+		}
+	}()
+
+	coverage := &OffsetCoverage{Topic: topic, Partition: partition, From: from, To: to, Windows: []CoveredRange{}, Gaps: []OffsetRange{}, Overlaps: []OffsetRange{}}
+	for len(coverage.Windows) < maxCoverageWindows {
+		result, err := scroll.Do(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, esError(fmt.Errorf("failed to search windows of topic %s, partition %d: %w", topic, partition, err))
+		}
+		for _, hit := range result.Hits.Hits {
+			ew, err := fromDocument(hit.Source)
+			if err != nil || ew.FirstOffset == nil || ew.LastOffset == nil {
+				continue
+			}
+			coverage.Windows = append(coverage.Windows, CoveredRange{WindowID: ew.WindowID, FirstOffset: *ew.FirstOffset, LastOffset: *ew.LastOffset})
+		}
+	}
+	if len(coverage.Windows) >= maxCoverageWindows {
+		coverage.Windows = coverage.Windows[:maxCoverageWindows]
+		coverage.Truncated = true
+	}
+
+	// Windows are sorted by first offset; walk them keeping the highest offset covered so far
+	next := from // Lowest offset not covered yet
+	for _, w := range coverage.Windows {
+		if w.FirstOffset > next {
+			coverage.Gaps = append(coverage.Gaps, OffsetRange{From: next, To: min(w.FirstOffset-1, to)})
+		} else if w.FirstOffset < next && next > from {
+			coverage.Overlaps = append(coverage.Overlaps, OffsetRange{From: max(w.FirstOffset, from), To: min(next-1, w.LastOffset, to)})
+		}
+		next = max(next, w.LastOffset+1)
+	}
+	if next <= to && !coverage.Truncated {
+		coverage.Gaps = append(coverage.Gaps, OffsetRange{From: next, To: to})
+	}
+	return coverage, nil
+}
@@ -0,0 +1,136 @@
+package vectordb
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/metrics"
+	"stream-rag-agent/internal/window"
+)
+
+const defaultCompareRate = 0.1
+
+var (
+	dualWritesTotal      = metrics.NewCounter("vector_store_dual_writes_total", "Writes mirrored to the secondary vector store, by kind (window or events) and result.")
+	dualComparisonsTotal = metrics.NewCounter("vector_store_dual_read_comparisons_total", "Sampled searches repeated on the secondary vector store, by result (match, mismatch or error).")
+	dualReadOverlapGauge = metrics.NewGauge("vector_store_dual_read_overlap", "Share of the primary's results also returned by the secondary in the most recent comparison.")
+	dualReadOverlapTotal = metrics.NewCounter("vector_store_dual_read_overlap_sum", "Sum of the overlap of all comparisons; divide by the comparison count for the average.")
+)
+
+// mirror dual-writes to a secondary store while migrating to it. Reads are served by the
+// primary; a sample of searches is repeated on the secondary to measure consistency.
+type mirror struct {
+	secondary   *ElasticsearchClient
+	compareRate float64
+}
+
+// newMirror connects to the secondary store, or returns nil when dual-write is disabled.
+func newMirror(cfg config.ElasticsearchConfig) (*mirror, error) {
+	dw := cfg.DualWrite
+	if !dw.Enabled {
+		return nil, nil
+	}
+	if len(dw.Addresses) == 0 {
+		return nil, fmt.Errorf("dual_write.addresses must be set when dual-write is enabled")
+	}
+	secondaryCfg := cfg
+	secondaryCfg.Addresses = dw.Addresses
+	if dw.IndexName != "" {
+		secondaryCfg.IndexName = dw.IndexName
+	}
+	secondaryCfg.Snapshot = config.SnapshotConfig{}
+	secondaryCfg.DualWrite = config.DualWriteConfig{}
+	secondaryCfg.BulkFailures.DeadLetterDir = "" // Documents failing on the secondary are still held by the primary
+	secondary, err := NewElasticsearchClient(&secondaryCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to dual-write secondary: %w", err)
+	}
+
+	rate := dw.CompareSampleRate
+	if rate == 0 {
+		rate = defaultCompareRate
+	}
+	log.Printf("Dual-write enabled: windows are also written to index '%s' on %v", secondaryCfg.IndexName, dw.Addresses)
+	return &mirror{secondary: secondary, compareRate: rate}, nil
+}
+
+// saveWindow writes the window to the secondary. Failures are logged and counted but not
+// returned, so the secondary cannot hold up ingestion.
+func (m *mirror) saveWindow(ew *window.EmbeddedWindow) {
+	if m == nil {
+		return
+	}
+	m.record("window", m.secondary.SaveEmbeddedWindow(ew))
+}
+
+func (m *mirror) saveEvents(events []StructuredEvent) {
+	if m == nil || len(events) == 0 {
+		return
+	}
+	m.record("events", m.secondary.SaveEvents(events))
+}
+
+func (m *mirror) record(kind string, err error) {
+	if err != nil {
+		log.Printf("Dual-write of %s to secondary failed: %v", kind, err)
+		dualWritesTotal.Inc("kind", kind, "result", "error")
+		return
+	}
+	dualWritesTotal.Inc("kind", kind, "result", "ok")
+}
+
+// compare repeats a sample of searches on the secondary in the background and records how
+// many of the primary's results it returned too. The comparison outlives the query, so it
+// does not use the query's context.
+func (m *mirror) compare(queryEmbedding []float32, k int, filter *SearchFilter, primary []window.EmbeddedWindow) {
+	if m == nil || rand.Float64() >= m.compareRate {
+		return
+	}
+	if filter != nil && filter.Explain != nil {
+		// The secondary's scores do not explain the query's results
+		unexplained := *filter
+		unexplained.Explain = nil
+		filter = &unexplained
+	}
+	go func() {
+		secondary, err := m.secondary.SearchSimilarWindows(context.Background(), queryEmbedding, k, filter)
+		if err != nil {
+			log.Printf("Dual-read comparison failed: %v", err)
+			dualComparisonsTotal.Inc("result", "error")
+			return
+		}
+		overlap := resultOverlap(primary, secondary)
+		dualReadOverlapGauge.Set(overlap)
+		dualReadOverlapTotal.Add(overlap)
+		if overlap == 1 {
+			dualComparisonsTotal.Inc("result", "match")
+		} else {
+			log.Printf("Dual-read comparison: secondary returned %.0f%% of the primary's %d results", overlap*100, len(primary))
+			dualComparisonsTotal.Inc("result", "mismatch")
+		}
+	}()
+}
+
+// resultOverlap is the share of the primary's windows that are also in the secondary's results.
+func resultOverlap(primary, secondary []window.EmbeddedWindow) float64 {
+	if len(primary) == 0 {
+		if len(secondary) == 0 {
+			return 1
+		}
+		return 0
+	}
+	found := make(map[string]bool, len(secondary))
+	for _, w := range secondary {
+		found[w.WindowID] = true
+	}
+	matched := 0
+	for _, w := range primary {
+		if found[w.WindowID] {
+			matched++
+		}
+	}
+	return float64(matched) / float64(len(primary))
+}
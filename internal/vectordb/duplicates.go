@@ -0,0 +1,127 @@
+package vectordb
+
+import (
+	"sort"
+
+	"stream-rag-agent/internal/window"
+	"stream-rag-agent/pkg/errs"
+)
+
+// MaxDuplicateDistance bounds the Hamming distance accepted by NearDuplicates.
+const MaxDuplicateDistance = 7
+
+const duplicateExamples = 5
+
+// DuplicatePair is two windows of different topics with near-identical context text.
+type DuplicatePair struct {
+	WindowA  string `json:"window_a"`
+	WindowB  string `json:"window_b"`
+	Distance int    `json:"distance"` // Differing bits between the windows' signatures
+}
+
+// TopicPairDuplicates summarizes near-duplicate windows between two topics. Many pairs
+// suggest a duplicated pipeline or a mirrored topic inflating the index.
+type TopicPairDuplicates struct {
+	TopicA   string          `json:"topic_a"`
+	TopicB   string          `json:"topic_b"`
+	Pairs    int             `json:"pairs"`
+	Examples []DuplicatePair `json:"examples"`
+}
+
+type DuplicateReport struct {
+	WindowsScanned int                   `json:"windows_scanned"`
+	Unsigned       int                   `json:"unsigned"` // Windows indexed before signatures were stored
+	MaxDistance    int                   `json:"max_distance"`
+	TopicPairs     []TopicPairDuplicates `json:"topic_pairs"`
+}
+
+type signedWindow struct {
+	id        string
+	topic     string
+	signature uint64
+}
+
+// NearDuplicates scans the windows matching the filter and reports pairs from different
+// topics whose signatures differ in at most maxDistance bits. Signatures are split into
+// maxDistance+1 bands; by the pigeonhole principle such pairs share at least one band
+// exactly, so only windows sharing a band are compared.
+func (c *ElasticsearchClient) NearDuplicates(filter *SearchFilter, maxDistance int) (*DuplicateReport, error) {
+	if maxDistance < 0 || maxDistance > MaxDuplicateDistance {
+		return nil, errs.Errorf(errs.ErrInvalidRequest, "max distance must be between 0 and %d", MaxDuplicateDistance)
+	}
+	report := &DuplicateReport{MaxDistance: maxDistance}
+
+	var windows []signedWindow
+	err := c.ScrollWindows(filter, func(batch []window.EmbeddedWindow) error {
+		for _, ew := range batch {
+			report.WindowsScanned++
+			signature, err := window.ParseSimHash(ew.SimHash)
+			if ew.SimHash == "" || err != nil {
+				report.Unsigned++
+				continue
+			}
+			windows = append(windows, signedWindow{id: ew.WindowID, topic: ew.Topic, signature: signature})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bands := maxDistance + 1
+	width := 64 / bands
+	type bandKey struct {
+		band  int
+		value uint64
+	}
+	buckets := make(map[bandKey][]int)
+	for i, w := range windows {
+		for b := 0; b < bands; b++ {
+			key := bandKey{band: b, value: (w.signature >> (b * width)) & (1<<width - 1)}
+			buckets[key] = append(buckets[key], i)
+		}
+	}
+
+	type pairKey struct{ a, b int }
+	compared := make(map[pairKey]bool)
+	byTopics := make(map[[2]string]*TopicPairDuplicates)
+	for _, members := range buckets {
+		for x := 0; x < len(members); x++ {
+			for y := x + 1; y < len(members); y++ {
+				a, b := windows[members[x]], windows[members[y]]
+				if a.topic == b.topic {
+					continue
+				}
+				key := pairKey{members[x], members[y]}
+				if compared[key] {
+					continue
+				}
+				compared[key] = true
+
+				distance := window.HammingDistance(a.signature, b.signature)
+				if distance > maxDistance {
+					continue
+				}
+				if a.topic > b.topic {
+					a, b = b, a
+				}
+				topics := [2]string{a.topic, b.topic}
+				entry, ok := byTopics[topics]
+				if !ok {
+					entry = &TopicPairDuplicates{TopicA: a.topic, TopicB: b.topic}
+					byTopics[topics] = entry
+				}
+				entry.Pairs++
+				if len(entry.Examples) < duplicateExamples {
+					entry.Examples = append(entry.Examples, DuplicatePair{WindowA: a.id, WindowB: b.id, Distance: distance})
+				}
+			}
+		}
+	}
+
+	for _, entry := range byTopics {
+		report.TopicPairs = append(report.TopicPairs, *entry)
+	}
+	sort.Slice(report.TopicPairs, func(i, j int) bool { return report.TopicPairs[i].Pairs > report.TopicPairs[j].Pairs })
+	return report, nil
+}
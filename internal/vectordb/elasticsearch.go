@@ -9,12 +9,26 @@ import (
 
 	elastic "github.com/olivere/elastic/v7"
 	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/faults"
 	"stream-rag-agent/internal/window"
 )
 
+const defaultEmbeddingDims = 768 // nomic-embed-text
+
 type ElasticsearchClient struct {
-	client    *elastic.Client
-	indexName string
+	client        *elastic.Client
+	indexName     string
+	snapshotCfg   config.SnapshotConfig
+	embeddingDims []int
+	legacyDims    int // Dimension of the pre-migration "embedding" field, 0 if the index has none
+	candidates    *candidateTuner
+	fanout        *topicFanout     // nil unless per-topic search is enabled
+	mirror        *mirror          // nil unless dual-write is enabled
+	categories    *categoryIndices // nil unless categorized windows are routed to their own indices
+	settings      indexSettings
+	refresh       *refreshTuner
+	optimizer     *optimizer // nil unless scheduled merging is enabled
+	bulkFailures  *bulkFailures
 }
 
 func NewElasticsearchClient(cfg *config.ElasticsearchConfig) (*ElasticsearchClient, error) {
@@ -36,94 +50,236 @@ func NewElasticsearchClient(cfg *config.ElasticsearchConfig) (*ElasticsearchClie
 	}
 	log.Printf("Connected to Elasticsearch cluster: %v", cfg.Addresses)
 
+	embeddingDims := cfg.EmbeddingDims
+	if len(embeddingDims) == 0 {
+		embeddingDims = []int{defaultEmbeddingDims}
+	}
+
 	esClient := &ElasticsearchClient{
-		client:    client,
-		indexName: cfg.IndexName,
+		client:        client,
+		indexName:     cfg.IndexName,
+		snapshotCfg:   cfg.Snapshot,
+		embeddingDims: embeddingDims,
+		candidates:    newCandidateTuner(cfg.NumCandidates),
+		fanout:        newTopicFanout(cfg.TopicFanout),
+		categories:    newCategoryIndices(cfg.CategoryIndices),
+		settings:      newIndexSettings(cfg.IndexSettings),
+		refresh:       newRefreshTuner(cfg.IndexSettings),
 	}
 
-	err = esClient.createIndexWithMapping()
+	err = esClient.createIndexWithMapping(esClient.indexName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create elasticsearch index with mapping: %w", err)
 	}
 
+	if err := esClient.ensureSnapshotRepository(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
+	if esClient.mirror, err = newMirror(*cfg); err != nil {
+		return nil, err
+	}
+	if esClient.optimizer, err = newOptimizer(cfg.Optimize); err != nil {
+		return nil, err
+	}
+	if esClient.bulkFailures, err = newBulkFailures(cfg.BulkFailures); err != nil {
+		return nil, err
+	}
+
 	return esClient, nil
 }
 
-func (c *ElasticsearchClient) createIndexWithMapping() error {
+// createIndexWithMapping creates a windows index, or adds missing fields to an existing one.
+func (c *ElasticsearchClient) createIndexWithMapping(name string) error {
 	ctx := context.Background()
-	exists, err := c.client.IndexExists(c.indexName).Do(ctx)
+	exists, err := c.client.IndexExists(name).Do(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to check if index exists: %w", err)
 	}
 
 	if exists {
-		log.Printf("Elasticsearch index '%s' already exists. Skipping creation.", c.indexName)
-		return nil
+		log.Printf("Elasticsearch index '%s' already exists. Ensuring embedding fields are mapped.", name)
+		if err := c.applyIndexSettings(name); err != nil {
+			return err
+		}
+		return c.ensureEmbeddingFields(name)
 	}
 
-	// Mapping for the index (adjust dimension based on your Ollama embedding model)
-	// nomic-embed-text typically has 768 dimensions
-	mapping := `{
-		"settings": {
-			"number_of_shards": 1,
-			"number_of_replicas": 0
-		},
+	// Mapping for the index. One dense_vector field is created per configured embedding
+	// dimension (embedding_768, embedding_1024, ...) so different models can coexist.
+	mapping := fmt.Sprintf(`{
+		"settings": %s,
 		"mappings": {
 			"properties": {
-				"window_id":      {"type": "keyword"},
-				"topic":          {"type": "keyword"},
-				"partition":      {"type": "integer"},
-				"start_time":     {"type": "date"},
-				"end_time":       {"type": "date"},
-				"message_count":  {"type": "integer"},
-				"context_text":   {"type": "text"},
-				"embedding": {
-					"type": "dense_vector",
-					"dims": 768,  // IMPORTANT: Adjust this dimension based on your Ollama embedding model
-					"index": true,
-                    "similarity": "cosine"
-				}
+				"window_id":              {"type": "keyword"},
+				"topic":                  {"type": "keyword"},
+				"partition":              {"type": "integer"},
+				"shard":                  {"type": "integer"},
+				"group_key":              {"type": "keyword"},
+				"start_time":             {"type": "date"},
+				"end_time":               {"type": "date"},
+				"message_count":          {"type": "integer"},
+				"context_text":           {"type": "text"},
+				"topic_context":          {"type": "text"},
+				"context_version":        {"type": "keyword"},
+				"context_effective_from": {"type": "date"},
+				"embedding_model":        {"type": "keyword"},
+				"embedding_model_digest": {"type": "keyword"},
+				"template_version":       {"type": "keyword"},
+				"embedded_at":            {"type": "date"},
+				"embedding_dims":         {"type": "integer"},
+				"embedding_chunks":       {"type": "integer"},
+				"chunk_ranges":           {"type": "object", "enabled": false},
+				"sampling_policy":        {"type": "keyword"},
+				"sampling_rate":          {"type": "float"},
+				"sampled_from":           {"type": "integer"},
+				"simhash":                {"type": "keyword"},
+				"annotations":            {"type": "flattened"},
+				"close_reason":           {"type": "keyword"},
+				"truncated":              {"type": "boolean"},
+				"parse_failures":         {"type": "integer"},
+				"category":               {"type": "keyword"},
+				"first_offset":           {"type": "long"},
+				"last_offset":            {"type": "long"},
+				"headers":                {"type": "flattened"},
+				%s
 			}
 		}
-	}`
+	}`, c.settings.json(), c.embeddingFieldMappings())
 
-	createIndex, err := c.client.CreateIndex(c.indexName).BodyString(mapping).Do(ctx)
+	createIndex, err := c.client.CreateIndex(name).BodyString(mapping).Do(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to create index '%s': %w", c.indexName, err)
+		return fmt.Errorf("failed to create index '%s': %w", name, err)
 	}
 	if !createIndex.Acknowledged {
-		return fmt.Errorf("failed to create index '%s': not acknowledged", c.indexName)
+		return fmt.Errorf("failed to create index '%s': not acknowledged", name)
 	}
-	log.Printf("Elasticsearch index '%s' created successfully.", c.indexName)
+	log.Printf("Elasticsearch index '%s' created successfully.", name)
 	return nil
 }
 
 func (c *ElasticsearchClient) SaveEmbeddedWindow(ew *window.EmbeddedWindow) error {
 	ctx := context.Background()
+	if err := faults.Inject(faults.Elasticsearch); err != nil {
+		return esError(fmt.Errorf("failed to save embedded window to Elasticsearch: %w", err))
+	}
+
+	doc, err := c.toDocument(ew)
+	if err != nil {
+		return esError(fmt.Errorf("failed to save embedded window to Elasticsearch: %w", err))
+	}
+
+	index, err := c.writeIndex(ew)
+	if err != nil {
+		return esError(fmt.Errorf("failed to save embedded window to Elasticsearch: %w", err))
+	}
 
 	// Use the window ID as the document ID for idempotency
-	_, err := c.client.Index().
-		Index(c.indexName).
+	_, err = c.client.Index().
+		Index(index).
 		Id(ew.WindowID).
-		BodyJson(ew).
+		BodyJson(doc).
 		Do(ctx)
 
 	if err != nil {
-		return fmt.Errorf("failed to save embedded window to Elasticsearch: %w", err)
+		return esError(fmt.Errorf("failed to save embedded window to Elasticsearch: %w", err))
 	}
-	log.Printf("Saved window '%s' to Elasticsearch index '%s'.", ew.WindowID, c.indexName)
+	log.Printf("Saved window '%s' to Elasticsearch index '%s'.", ew.WindowID, index)
+	c.observeIndexing()
+	c.mirror.saveWindow(ew)
 	return nil
 }
 
-func (c *ElasticsearchClient) SearchSimilarWindows(queryEmbedding []float32, k int) ([]window.EmbeddedWindow, error) {
-	ctx := context.Background()
+// SaveEmbeddedWindows indexes the windows with a single bulk request. Windows Elasticsearch
+// rejects are retried or recorded, see bulkIndex, and returned; an error means none was saved.
+func (c *ElasticsearchClient) SaveEmbeddedWindows(ews []window.EmbeddedWindow) ([]DocumentFailure, error) {
+	if len(ews) == 0 {
+		return nil, nil
+	}
+	if err := faults.Inject(faults.Elasticsearch); err != nil {
+		return nil, esError(fmt.Errorf("failed to bulk index embedded windows: %w", err))
+	}
+
+	docs := make([]bulkDocument, len(ews))
+	for i := range ews {
+		doc, err := c.toDocument(&ews[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to bulk index embedded windows: window '%s': %w", ews[i].WindowID, err)
+		}
+		index, err := c.writeIndex(&ews[i])
+		if err != nil {
+			return nil, esError(fmt.Errorf("failed to bulk index embedded windows: %w", err))
+		}
+		docs[i] = bulkDocument{index: index, id: ews[i].WindowID, doc: doc}
+	}
 
+	failures, err := c.bulkIndex(context.Background(), "window", docs)
+	if err != nil {
+		return nil, esError(fmt.Errorf("failed to bulk index embedded windows: %w", err))
+	}
+	failed := failedPositions(failures)
+	for i := range ews {
+		if !failed[i] {
+			c.observeIndexing()
+			c.mirror.saveWindow(&ews[i])
+		}
+	}
+	log.Printf("Bulk indexed %d of %d windows into Elasticsearch.", len(ews)-len(failures), len(ews))
+	return failures, nil
+}
+
+// SearchSimilarWindows returns the k windows most similar to the query embedding, restricted
+// by the optional filter. The searches give up when ctx is done.
+func (c *ElasticsearchClient) SearchSimilarWindows(ctx context.Context, queryEmbedding []float32, k int, filter *SearchFilter) ([]window.EmbeddedWindow, error) {
+	if err := faults.Inject(faults.Elasticsearch); err != nil {
+		return nil, esError(fmt.Errorf("failed to execute elasticsearch k-NN search: %w", err))
+	}
+
+	var hits []scoredWindow
+	var err error
+	var recency *RecencyDecay
+	if filter != nil {
+		recency = filter.Recency
+	}
+	if c.fanout != nil {
+		hits, err = c.searchPerTopic(ctx, queryEmbedding, recency.candidates(k), filter)
+	} else {
+		hits, err = c.searchKNN(ctx, queryEmbedding, recency.candidates(k), filter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	hits = recency.rerank(hits, k)
+	filter.explain("vector", hits)
+	foundWindows := make([]window.EmbeddedWindow, 0, len(hits))
+	for _, h := range hits {
+		foundWindows = append(foundWindows, h.window)
+	}
+	log.Printf("DEBUG: Found %d similar windows.", len(foundWindows))
+	c.mirror.compare(queryEmbedding, k, filter, foundWindows)
+	return foundWindows, nil
+}
+
+// scoredWindow is a search hit with its relevance score.
+type scoredWindow struct {
+	window    window.EmbeddedWindow
+	score     float64
+	relevance float64 // Score before the recency decay, set by rerank
+	recency   float64 // Recency factor applied by rerank, 0 if none
+}
+
+// searchKNN runs a single kNN search over the index.
+func (c *ElasticsearchClient) searchKNN(ctx context.Context, queryEmbedding []float32, k int, filter *SearchFilter) ([]scoredWindow, error) {
+	// The vector field is selected by the query embedding's dimension, i.e. its model
+	numCandidates := c.NumCandidates(k)
+	knn, err := c.knnClauses(queryEmbedding, k, numCandidates, filter)
+	if err != nil {
+		return nil, err
+	}
 	searchBody := map[string]interface{}{
-		"knn": map[string]interface{}{
-			"field":          "embedding",
-			"query_vector":   queryEmbedding,
-			"k":              k,
-			"num_candidates": 100,
+		"knn": knn,
+		"_source": map[string]interface{}{
+			"excludes": c.vectorFields(),
 		},
 	}
 
@@ -131,33 +287,35 @@ func (c *ElasticsearchClient) SearchSimilarWindows(queryEmbedding []float32, k i
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal k-NN query map for debug log: %w", err)
 	}
-	log.Printf("DEBUG: Sending ES k-NN search request to index '%s' with body: %s", c.indexName, string(debugQueryJSON))
+	log.Printf("DEBUG: Sending ES k-NN search request to indices %v (k=%d, num_candidates=%d) with body: %s", c.searchIndices(), k, numCandidates, string(debugQueryJSON))
 
 	searchResult, err := c.client.Search().
-		Index(c.indexName).
+		Index(c.searchIndices()...).
 		Source(searchBody).
 		Do(ctx)
 
 	if err != nil {
 		log.Printf("ERROR: Elasticsearch search failed: %v", err)
-		return nil, fmt.Errorf("failed to execute elasticsearch k-NN search: %w", err)
+		return nil, esError(fmt.Errorf("failed to execute elasticsearch k-NN search: %w", err))
 	}
 
 	if searchResult.Hits == nil || searchResult.Hits.Hits == nil {
 		log.Println("DEBUG: No hits found for the k-NN search.")
-		return []window.EmbeddedWindow{}, nil
+		return nil, nil
 	}
 
-	var foundWindows []window.EmbeddedWindow
+	var hits []scoredWindow
 	for _, hit := range searchResult.Hits.Hits {
-		var ew window.EmbeddedWindow
-		if err := json.Unmarshal(hit.Source, &ew); err != nil {
+		ew, err := fromDocument(hit.Source)
+		if err != nil {
 			log.Printf("Error unmarshaling embedded window from ES hit: %v", err)
 			continue
 		}
-		foundWindows = append(foundWindows, ew)
+		score := 0.0
+		if hit.Score != nil {
+			score = *hit.Score
+		}
+		hits = append(hits, scoredWindow{window: ew, score: score})
 	}
-
-	log.Printf("DEBUG: Found %d similar windows.", len(foundWindows))
-	return foundWindows, nil
+	return hits, nil
 }
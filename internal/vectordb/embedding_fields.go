@@ -0,0 +1,213 @@
+package vectordb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"stream-rag-agent/internal/window"
+)
+
+// legacyEmbeddingField is the single vector field used before per-dimension fields existed.
+const legacyEmbeddingField = "embedding"
+
+// embeddingField returns the dense_vector field holding vectors of the given dimension.
+func embeddingField(dims int) string {
+	return fmt.Sprintf("embedding_%d", dims)
+}
+
+func embeddingFieldMapping(dims int) map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "dense_vector",
+		"dims":       dims,
+		"index":      true,
+		"similarity": "cosine",
+	}
+}
+
+// embeddingFieldMappings renders the configured vector fields as mapping properties.
+func (c *ElasticsearchClient) embeddingFieldMappings() string {
+	fields := make([]string, 0, len(c.embeddingDims))
+	for _, dims := range c.embeddingDims {
+		body, _ := json.Marshal(embeddingFieldMapping(dims))
+		fields = append(fields, fmt.Sprintf("%q: %s", embeddingField(dims), body))
+	}
+	return strings.Join(fields, ",\n\t\t\t\t")
+}
+
+// vectorFields lists the vector fields, which searches exclude from _source: prompts do not
+// need them. A wildcard would also drop embedding_model and the other embedding_* metadata.
+func (c *ElasticsearchClient) vectorFields() []string {
+	fields := []string{legacyEmbeddingField}
+	for _, dims := range c.embeddingDims {
+		fields = append(fields, embeddingField(dims))
+	}
+	return fields
+}
+
+func (c *ElasticsearchClient) supportsDims(dims int) bool {
+	for _, d := range c.embeddingDims {
+		if d == dims {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureEmbeddingFields adds any configured embedding fields missing from an existing index
+// and detects the legacy "embedding" field so it keeps being searched during migration.
+func (c *ElasticsearchClient) ensureEmbeddingFields(name string) error {
+	ctx := context.Background()
+	mappings, err := c.client.GetMapping().Index(name).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get mapping of index '%s': %w", name, err)
+	}
+
+	properties := map[string]interface{}{}
+	for _, indexMapping := range mappings {
+		if m, ok := indexMapping.(map[string]interface{}); ok {
+			if mp, ok := m["mappings"].(map[string]interface{}); ok {
+				if props, ok := mp["properties"].(map[string]interface{}); ok {
+					properties = props
+				}
+			}
+		}
+	}
+
+	if legacy, ok := properties[legacyEmbeddingField].(map[string]interface{}); ok {
+		if dims, ok := legacy["dims"].(float64); ok {
+			c.legacyDims = int(dims)
+			log.Printf("Index '%s' has legacy '%s' field (%d dims); it will be searched alongside %s.",
+				name, legacyEmbeddingField, c.legacyDims, embeddingField(c.legacyDims))
+		}
+	}
+
+	missing := map[string]interface{}{}
+	for _, dims := range c.embeddingDims {
+		if _, ok := properties[embeddingField(dims)]; !ok {
+			missing[embeddingField(dims)] = embeddingFieldMapping(dims)
+		}
+	}
+	// Window signatures were added after the first release; map them as keywords, not text
+	if _, ok := properties["simhash"]; !ok {
+		missing["simhash"] = map[string]interface{}{"type": "keyword"}
+	}
+	// Webhook annotations and message headers have arbitrary keys; flattened keeps them from
+	// growing the mapping
+	for _, field := range []string{"annotations", "headers"} {
+		if _, ok := properties[field]; !ok {
+			missing[field] = map[string]interface{}{"type": "flattened"}
+		}
+	}
+	// Chunk ranges are only kept in the source, to locate chunks in context_text
+	if _, ok := properties["chunk_ranges"]; !ok {
+		missing["chunk_ranges"] = map[string]interface{}{"type": "object", "enabled": false}
+	}
+	// Window quality, chunking, category, offset, shard and embedding provenance metadata were
+	// added later as well
+	for field, typ := range map[string]string{"close_reason": "keyword", "truncated": "boolean", "parse_failures": "integer", "embedding_chunks": "integer", "category": "keyword", "first_offset": "long", "last_offset": "long", "shard": "integer", "group_key": "keyword", "embedding_model_digest": "keyword", "template_version": "keyword", "embedded_at": "date"} {
+		if _, ok := properties[field]; !ok {
+			missing[field] = map[string]interface{}{"type": typ}
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	_, err = c.client.PutMapping().
+		Index(name).
+		BodyJson(map[string]interface{}{"properties": missing}).
+		Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to add missing fields to index '%s': %w", name, err)
+	}
+	added := make([]string, 0, len(missing))
+	for field := range missing {
+		added = append(added, field)
+	}
+	log.Printf("Added fields to index '%s': %v", name, added)
+	return nil
+}
+
+// toDocument converts an embedded window into the indexed document, storing the vector in
+// the field matching its dimension.
+func (c *ElasticsearchClient) toDocument(ew *window.EmbeddedWindow) (map[string]interface{}, error) {
+	dims := len(ew.Embedding)
+	if !c.supportsDims(dims) {
+		return nil, fmt.Errorf("embedding dimension %d is not configured in elasticsearch.embedding_dims %v", dims, c.embeddingDims)
+	}
+
+	body, err := json.Marshal(ew)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal embedded window: %w", err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("failed to convert embedded window to document: %w", err)
+	}
+
+	delete(doc, legacyEmbeddingField)
+	doc[embeddingField(dims)] = ew.Embedding
+	doc["embedding_dims"] = dims
+	return doc, nil
+}
+
+// fromDocument decodes an indexed document, reading the vector from whichever field holds it.
+func fromDocument(source json.RawMessage) (window.EmbeddedWindow, error) {
+	var ew window.EmbeddedWindow
+	if err := json.Unmarshal(source, &ew); err != nil {
+		return ew, err
+	}
+	if len(ew.Embedding) > 0 {
+		return ew, nil
+	}
+
+	var meta struct {
+		EmbeddingDims int `json:"embedding_dims"`
+	}
+	if err := json.Unmarshal(source, &meta); err != nil || meta.EmbeddingDims == 0 {
+		return ew, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(source, &fields); err != nil {
+		return ew, nil
+	}
+	if raw, ok := fields[embeddingField(meta.EmbeddingDims)]; ok {
+		_ = json.Unmarshal(raw, &ew.Embedding)
+	}
+	return ew, nil
+}
+
+// knnClauses builds the kNN clauses for a query vector: the per-dimension field, plus the
+// legacy field when it holds vectors of the same dimension.
+func (c *ElasticsearchClient) knnClauses(queryEmbedding []float32, k, numCandidates int, filter *SearchFilter) ([]map[string]interface{}, error) {
+	dims := len(queryEmbedding)
+	if !c.supportsDims(dims) && dims != c.legacyDims {
+		return nil, fmt.Errorf("query embedding dimension %d is not configured in elasticsearch.embedding_dims %v", dims, c.embeddingDims)
+	}
+
+	filterQuery := filter.query()
+	clause := func(field string) map[string]interface{} {
+		knn := map[string]interface{}{
+			"field":          field,
+			"query_vector":   queryEmbedding,
+			"k":              k,
+			"num_candidates": numCandidates,
+		}
+		if filterQuery != nil {
+			knn["filter"] = filterQuery
+		}
+		return knn
+	}
+
+	var clauses []map[string]interface{}
+	if c.supportsDims(dims) {
+		clauses = append(clauses, clause(embeddingField(dims)))
+	}
+	if dims == c.legacyDims {
+		clauses = append(clauses, clause(legacyEmbeddingField))
+	}
+	return clauses, nil
+}
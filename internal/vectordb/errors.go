@@ -0,0 +1,19 @@
+package vectordb
+
+import (
+	"errors"
+
+	elastic "github.com/olivere/elastic/v7"
+	"stream-rag-agent/pkg/errs"
+)
+
+// esError marks err, a failed Elasticsearch call, as an errs.DependencyError with the status
+// of the response, so e.g. rejected executions match errs.ErrRateLimited.
+func esError(err error) error {
+	status := 0
+	var e *elastic.Error
+	if errors.As(err, &e) {
+		status = e.Status
+	}
+	return errs.Dependency("elasticsearch", status, err)
+}
@@ -0,0 +1,311 @@
+package vectordb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	elastic "github.com/olivere/elastic/v7"
+	"stream-rag-agent/pkg/errs"
+)
+
+// StructuredEvent is one message's extracted fields, indexed in the events index so that
+// structured questions can be answered with exact aggregations instead of sampled text.
+type StructuredEvent struct {
+	Topic     string                 `json:"topic"`
+	WindowID  string                 `json:"window_id"`
+	Partition int32                  `json:"partition"`
+	Offset    int64                  `json:"offset"`
+	Timestamp time.Time              `json:"timestamp"`
+	Fields    map[string]interface{} `json:"fields"`
+}
+
+// EventField describes a structured field available for aggregations.
+type EventField struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // Elasticsearch type, e.g. "keyword", "double", "date"
+}
+
+func (c *ElasticsearchClient) eventsIndex() string {
+	return c.indexName + "_events"
+}
+
+func (c *ElasticsearchClient) ensureEventsIndex() error {
+	ctx := context.Background()
+	exists, err := c.client.IndexExists(c.eventsIndex()).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check if events index exists: %w", err)
+	}
+	if exists {
+		return nil
+	}
+
+	// Strings are mapped as keywords so they can be grouped and filtered on exactly
+	mapping := `{
+		"settings": ` + c.settings.json() + `,
+		"mappings": {
+			"dynamic_templates": [
+				{"strings_as_keywords": {"match_mapping_type": "string", "mapping": {"type": "keyword"}}}
+			],
+			"properties": {
+				"topic":     {"type": "keyword"},
+				"window_id": {"type": "keyword"},
+				"partition": {"type": "integer"},
+				"offset":    {"type": "long"},
+				"timestamp": {"type": "date"},
+				"fields":    {"type": "object"}
+			}
+		}
+	}`
+	if _, err := c.client.CreateIndex(c.eventsIndex()).BodyString(mapping).Do(ctx); err != nil {
+		return fmt.Errorf("failed to create events index '%s': %w", c.eventsIndex(), err)
+	}
+	log.Printf("Elasticsearch events index '%s' created successfully.", c.eventsIndex())
+	return nil
+}
+
+// SaveEvents bulk-indexes structured events, using topic/partition/offset as the document ID.
+// Events Elasticsearch rejects are retried or recorded, see bulkIndex; the others are saved.
+func (c *ElasticsearchClient) SaveEvents(events []StructuredEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	if err := c.ensureEventsIndex(); err != nil {
+		return err
+	}
+
+	docs := make([]bulkDocument, len(events))
+	for i := range events {
+		e := &events[i]
+		docs[i] = bulkDocument{index: c.eventsIndex(), id: fmt.Sprintf("%s_%d_%d", e.Topic, e.Partition, e.Offset), doc: e}
+	}
+	failures, err := c.bulkIndex(context.Background(), "event", docs)
+	if err != nil {
+		return esError(fmt.Errorf("failed to bulk index events: %w", err))
+	}
+	if len(failures) == 0 {
+		c.mirror.saveEvents(events)
+		return nil
+	}
+
+	failed := failedPositions(failures)
+	indexed := make([]StructuredEvent, 0, len(events)-len(failures))
+	for i := range events {
+		if !failed[i] {
+			indexed = append(indexed, events[i])
+		}
+	}
+	c.mirror.saveEvents(indexed)
+	return fmt.Errorf("failed to index %d of %d events (first error: %s: %s)", len(failures), len(events), failures[0].Type, failures[0].Reason)
+}
+
+// EventFields lists the structured fields present in the events index, optionally per topic.
+func (c *ElasticsearchClient) EventFields() ([]EventField, error) {
+	mappings, err := c.client.GetMapping().Index(c.eventsIndex()).Do(context.Background())
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, esError(fmt.Errorf("failed to get events mapping: %w", err))
+	}
+
+	var fields []EventField
+	for _, indexMapping := range mappings {
+		m, _ := indexMapping.(map[string]interface{})
+		mp, _ := m["mappings"].(map[string]interface{})
+		props, _ := mp["properties"].(map[string]interface{})
+		fieldsProp, _ := props["fields"].(map[string]interface{})
+		fieldProps, _ := fieldsProp["properties"].(map[string]interface{})
+		for name, def := range fieldProps {
+			d, _ := def.(map[string]interface{})
+			typ, _ := d["type"].(string)
+			fields = append(fields, EventField{Name: name, Type: typ})
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields, nil
+}
+
+// AggregationSpec is the constrained description of a structured question that the LLM
+// produces. It is validated and translated to an Elasticsearch aggregation by the client,
+// so the model never writes raw query DSL.
+type AggregationSpec struct {
+	Topic   string            `json:"topic,omitempty"`
+	Metric  string            `json:"metric"`             // count, avg, sum, min, max or cardinality
+	Field   string            `json:"field,omitempty"`    // Field the metric is computed on (not used by count)
+	GroupBy string            `json:"group_by,omitempty"` // Optional keyword field to group by
+	Filters map[string]string `json:"filters,omitempty"`  // Exact-match filters: field -> value
+	From    *time.Time        `json:"from,omitempty"`
+	To      *time.Time        `json:"to,omitempty"`
+
+	ExcludeTopics []string `json:"-"` // Topics left out of the aggregation, e.g. restricted by the egress policy
+}
+
+type AggregationRow struct {
+	Group string  `json:"group,omitempty"`
+	Value float64 `json:"value"`
+	Count int64   `json:"count"`
+}
+
+type AggregationResult struct {
+	Spec AggregationSpec  `json:"spec"`
+	Rows []AggregationRow `json:"rows"`
+}
+
+var allowedMetrics = map[string]bool{"count": true, "avg": true, "sum": true, "min": true, "max": true, "cardinality": true}
+
+// Validate checks the spec against the known structured fields.
+func (spec *AggregationSpec) Validate(fields []EventField) error {
+	known := make(map[string]string, len(fields))
+	for _, f := range fields {
+		known[f.Name] = f.Type
+	}
+	if !allowedMetrics[spec.Metric] {
+		return errs.Errorf(errs.ErrInvalidRequest, "unsupported metric %q", spec.Metric)
+	}
+	if spec.Metric != "count" {
+		typ, ok := known[spec.Field]
+		if !ok {
+			return errs.Errorf(errs.ErrInvalidRequest, "unknown field %q", spec.Field)
+		}
+		if spec.Metric != "cardinality" && (typ == "keyword" || typ == "text" || typ == "boolean") {
+			return errs.Errorf(errs.ErrInvalidRequest, "metric %s needs a numeric field, %q is %s", spec.Metric, spec.Field, typ)
+		}
+	}
+	if spec.GroupBy != "" {
+		if _, ok := known[spec.GroupBy]; !ok {
+			return errs.Errorf(errs.ErrInvalidRequest, "unknown group_by field %q", spec.GroupBy)
+		}
+	}
+	for f := range spec.Filters {
+		if _, ok := known[f]; !ok {
+			return errs.Errorf(errs.ErrInvalidRequest, "unknown filter field %q", f)
+		}
+	}
+	return nil
+}
+
+// Aggregate runs the validated spec against the events index.
+func (c *ElasticsearchClient) Aggregate(spec AggregationSpec) (*AggregationResult, error) {
+	var filters []map[string]interface{}
+	if spec.Topic != "" {
+		filters = append(filters, map[string]interface{}{"term": map[string]interface{}{"topic": spec.Topic}})
+	}
+	for f, v := range spec.Filters {
+		filters = append(filters, map[string]interface{}{"term": map[string]interface{}{"fields." + f: v}})
+	}
+	boolQuery := map[string]interface{}{"filter": filters}
+	if len(spec.ExcludeTopics) > 0 {
+		boolQuery["must_not"] = []map[string]interface{}{{"terms": map[string]interface{}{"topic": spec.ExcludeTopics}}}
+	}
+	if spec.From != nil || spec.To != nil {
+		r := map[string]interface{}{}
+		if spec.From != nil {
+			r["gte"] = spec.From
+		}
+		if spec.To != nil {
+			r["lte"] = spec.To
+		}
+		filters = append(filters, map[string]interface{}{"range": map[string]interface{}{"timestamp": r}})
+	}
+
+	metricAgg := map[string]interface{}{}
+	if spec.Metric != "count" {
+		metricAgg[spec.Metric] = map[string]interface{}{"field": "fields." + spec.Field}
+	}
+
+	aggs := map[string]interface{}{}
+	if spec.GroupBy != "" {
+		groupAgg := map[string]interface{}{
+			"terms": map[string]interface{}{"field": "fields." + spec.GroupBy, "size": 50},
+		}
+		if len(metricAgg) > 0 {
+			groupAgg["aggs"] = map[string]interface{}{"metric": metricAgg}
+		}
+		aggs["groups"] = groupAgg
+	} else if len(metricAgg) > 0 {
+		aggs["metric"] = metricAgg
+	}
+
+	body := map[string]interface{}{
+		"size":             0,
+		"track_total_hits": true,
+		"query":            map[string]interface{}{"bool": boolQuery},
+	}
+	if len(aggs) > 0 {
+		body["aggs"] = aggs
+	}
+
+	debugJSON, _ := json.Marshal(body)
+	log.Printf("DEBUG: Sending ES aggregation to index '%s' with body: %s", c.eventsIndex(), string(debugJSON))
+
+	result, err := c.client.Search().Index(c.eventsIndex()).Source(body).Do(context.Background())
+	if err != nil {
+		return nil, esError(fmt.Errorf("failed to execute aggregation: %w", err))
+	}
+
+	out := &AggregationResult{Spec: spec}
+	total := int64(0)
+	if result.Hits != nil && result.Hits.TotalHits != nil {
+		total = result.Hits.TotalHits.Value
+	}
+
+	if spec.GroupBy != "" {
+		var groups struct {
+			Buckets []struct {
+				Key      interface{} `json:"key"`
+				DocCount int64       `json:"doc_count"`
+				Metric   struct {
+					Value *float64 `json:"value"`
+				} `json:"metric"`
+			} `json:"buckets"`
+		}
+		if raw, ok := result.Aggregations["groups"]; ok {
+			if err := json.Unmarshal(raw, &groups); err != nil {
+				return nil, fmt.Errorf("failed to decode aggregation: %w", err)
+			}
+		}
+		for _, b := range groups.Buckets {
+			row := AggregationRow{Group: fmt.Sprintf("%v", b.Key), Count: b.DocCount, Value: float64(b.DocCount)}
+			if b.Metric.Value != nil {
+				row.Value = *b.Metric.Value
+			}
+			out.Rows = append(out.Rows, row)
+		}
+		return out, nil
+	}
+
+	row := AggregationRow{Count: total, Value: float64(total)}
+	if raw, ok := result.Aggregations["metric"]; ok {
+		var metric struct {
+			Value *float64 `json:"value"`
+		}
+		if err := json.Unmarshal(raw, &metric); err != nil {
+			return nil, fmt.Errorf("failed to decode aggregation: %w", err)
+		}
+		if metric.Value != nil {
+			row.Value = *metric.Value
+		}
+	}
+	out.Rows = append(out.Rows, row)
+	return out, nil
+}
+
+// String renders the result as lines of text for the LLM.
+func (r *AggregationResult) String() string {
+	var sb strings.Builder
+	spec, _ := json.Marshal(r.Spec)
+	sb.WriteString(fmt.Sprintf("Aggregation: %s\n", spec))
+	for _, row := range r.Rows {
+		if row.Group != "" {
+			sb.WriteString(fmt.Sprintf("  - %s: %s=%g (events: %d)\n", row.Group, r.Spec.Metric, row.Value, row.Count))
+		} else {
+			sb.WriteString(fmt.Sprintf("  - %s=%g (events: %d)\n", r.Spec.Metric, row.Value, row.Count))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,37 @@
+package vectordb
+
+// WindowScore explains where a window returned by a search ranked.
+type WindowScore struct {
+	Kind          string  `json:"kind"`                     // vector or keyword
+	Score         float64 `json:"score"`                    // Score the window was ranked by
+	Relevance     float64 `json:"relevance"`                // Similarity (or BM25) score before the recency decay
+	RecencyFactor float64 `json:"recency_factor,omitempty"` // Multiplier of the recency decay, 0 without decay
+}
+
+// SearchExplanation collects the scores of the windows searches return, for explaining a
+// query's retrieval. Set it on the SearchFilter; searches with the filter record into it. A
+// window returned by several searches, e.g. for paraphrases of a question, keeps its best
+// score.
+type SearchExplanation struct {
+	Scores map[string]WindowScore // By window ID
+}
+
+// explain records the scores of the returned hits if the filter collects them.
+func (f *SearchFilter) explain(kind string, hits []scoredWindow) {
+	if f == nil || f.Explain == nil {
+		return
+	}
+	e := f.Explain
+	if e.Scores == nil {
+		e.Scores = make(map[string]WindowScore)
+	}
+	for _, h := range hits {
+		score := WindowScore{Kind: kind, Score: h.score, Relevance: h.score}
+		if h.recency > 0 {
+			score.Relevance, score.RecencyFactor = h.relevance, h.recency
+		}
+		if previous, ok := e.Scores[h.window.WindowID]; !ok || score.Score > previous.Score {
+			e.Scores[h.window.WindowID] = score
+		}
+	}
+}
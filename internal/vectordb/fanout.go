@@ -0,0 +1,268 @@
+package vectordb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"stream-rag-agent/internal/config"
+)
+
+const (
+	defaultMinKPerTopic = 1
+	defaultCentroidTTL  = 5 * time.Minute
+	centroidSampleSize  = 50  // Most recent windows averaged into a topic centroid
+	maxFanoutTopics     = 100 // Topics considered when the filter does not name any
+)
+
+// topicFanout searches every topic separately and concurrently, giving each a share of k
+// proportional to how close the query is to the topic's centroid, so one busy topic cannot
+// crowd the others out of the results.
+type topicFanout struct {
+	minK int
+	ttl  time.Duration
+
+	mu        sync.Mutex
+	centroids map[int]map[string][]float64 // Embedding dims -> topic -> centroid
+	refreshed map[int]time.Time
+}
+
+func newTopicFanout(cfg config.TopicFanoutConfig) *topicFanout {
+	if !cfg.Enabled {
+		return nil
+	}
+	f := &topicFanout{
+		minK:      cfg.MinKPerTopic,
+		ttl:       time.Duration(cfg.CentroidTTLSeconds) * time.Second,
+		centroids: make(map[int]map[string][]float64),
+		refreshed: make(map[int]time.Time),
+	}
+	if f.minK <= 0 {
+		f.minK = defaultMinKPerTopic
+	}
+	if f.ttl <= 0 {
+		f.ttl = defaultCentroidTTL
+	}
+	return f
+}
+
+// topicShare is one topic's part of a fanned-out search.
+type topicShare struct {
+	topic    string
+	affinity float64 // Cosine similarity of the query to the topic centroid, at least 0
+	k        int
+}
+
+// searchPerTopic runs one kNN search per topic concurrently and merges the hits by score
+// normalized within each topic and weighted by the topic's affinity.
+func (c *ElasticsearchClient) searchPerTopic(ctx context.Context, queryEmbedding []float32, k int, filter *SearchFilter) ([]scoredWindow, error) {
+	centroids, err := c.topicCentroids(ctx, len(queryEmbedding))
+	if err != nil {
+		log.Printf("Warning: per-topic search unavailable, searching the whole index: %v", err)
+		return c.searchKNN(ctx, queryEmbedding, k, filter)
+	}
+
+	shares := c.fanout.allocate(queryEmbedding, k, searchTopics(filter, centroids), centroids)
+	if len(shares) == 0 {
+		return c.searchKNN(ctx, queryEmbedding, k, filter)
+	}
+
+	results := make([][]scoredWindow, len(shares))
+	errs := make([]error, len(shares))
+	var wg sync.WaitGroup
+	for i, share := range shares {
+		topicFilter := SearchFilter{}
+		if filter != nil {
+			topicFilter = *filter
+		}
+		topicFilter.Topics = []string{share.topic}
+		wg.Add(1)
+		go func(i int, share topicShare, f *SearchFilter) {
+			defer wg.Done()
+			results[i], errs[i] = c.searchKNN(ctx, queryEmbedding, share.k, f)
+		}(i, share, &topicFilter)
+	}
+	wg.Wait()
+
+	var merged []scoredWindow
+	failed := 0
+	for i, hits := range results {
+		if errs[i] != nil {
+			log.Printf("Warning: search of topic %s failed: %v", shares[i].topic, errs[i])
+			failed++
+			continue
+		}
+		if len(hits) == 0 {
+			continue
+		}
+		top := hits[0].score
+		for _, h := range hits {
+			normalized := shares[i].affinity
+			if top > 0 {
+				normalized *= h.score / top
+			}
+			merged = append(merged, scoredWindow{window: h.window, score: normalized})
+		}
+	}
+	if failed == len(shares) {
+		return nil, esError(fmt.Errorf("failed to execute elasticsearch k-NN search: %w", errs[0]))
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].score > merged[j].score })
+	if len(merged) > k {
+		merged = merged[:k]
+	}
+	log.Printf("DEBUG: Per-topic search over %d topics (%v) returned %d windows", len(shares), shares, len(merged))
+	return merged, nil
+}
+
+// searchTopics returns the topics to fan out to: the filter's topics, or every topic with a
+// centroid, minus excluded topics.
+func searchTopics(filter *SearchFilter, centroids map[string][]float64) []string {
+	var topics []string
+	if filter != nil && len(filter.Topics) > 0 {
+		topics = filter.Topics
+	} else {
+		for t := range centroids {
+			topics = append(topics, t)
+		}
+		sort.Strings(topics)
+	}
+	if filter == nil || len(filter.ExcludeTopics) == 0 {
+		return topics
+	}
+	excluded := make(map[string]bool, len(filter.ExcludeTopics))
+	for _, t := range filter.ExcludeTopics {
+		excluded[t] = true
+	}
+	kept := topics[:0:0]
+	for _, t := range topics {
+		if !excluded[t] {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// allocate splits k over the topics in proportion to their affinity, after giving every topic
+// at least minK. When there are more topics than k allows, only the closest ones are searched.
+func (f *topicFanout) allocate(queryEmbedding []float32, k int, topics []string, centroids map[string][]float64) []topicShare {
+	query := make([]float64, len(queryEmbedding))
+	for i, x := range queryEmbedding {
+		query[i] = float64(x)
+	}
+	shares := make([]topicShare, 0, len(topics))
+	for _, t := range topics {
+		affinity := 0.0
+		if centroid, ok := centroids[t]; ok && len(centroid) == len(query) {
+			affinity = math.Max(cosineSimilarity(query, centroid), 0)
+		}
+		shares = append(shares, topicShare{topic: t, affinity: affinity})
+	}
+	sort.SliceStable(shares, func(i, j int) bool { return shares[i].affinity > shares[j].affinity })
+
+	if maxTopics := max(k/f.minK, 1); len(shares) > maxTopics {
+		shares = shares[:maxTopics]
+	}
+	total := 0.0
+	for i := range shares {
+		shares[i].k = f.minK
+		total += shares[i].affinity
+	}
+	if total == 0 {
+		// No centroids to compare with: weigh topics equally
+		for i := range shares {
+			shares[i].affinity = 1
+		}
+		total = float64(len(shares))
+	}
+	remaining := k - f.minK*len(shares)
+	if remaining <= 0 {
+		return shares
+	}
+
+	// Largest remainder: floor of each proportional share, then the leftovers by fraction
+	fractions := make([]float64, len(shares))
+	assigned := 0
+	for i := range shares {
+		exact := float64(remaining) * shares[i].affinity / total
+		extra := int(exact)
+		shares[i].k += extra
+		assigned += extra
+		fractions[i] = exact - float64(extra)
+	}
+	order := make([]int, len(shares))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return fractions[order[a]] > fractions[order[b]] })
+	for _, i := range order[:remaining-assigned] {
+		shares[i].k++
+	}
+	return shares
+}
+
+// topicCentroids returns the centroid of each topic's recent windows embedded with the given
+// dimension, sampling the index at most once per TTL.
+func (c *ElasticsearchClient) topicCentroids(ctx context.Context, dims int) (map[string][]float64, error) {
+	f := c.fanout
+	f.mu.Lock()
+	if centroids, ok := f.centroids[dims]; ok && time.Since(f.refreshed[dims]) < f.ttl {
+		f.mu.Unlock()
+		return centroids, nil
+	}
+	f.mu.Unlock()
+
+	topicsResult, err := c.client.Search().Index(c.searchIndices()...).Source(map[string]interface{}{
+		"size": 0,
+		"aggs": map[string]interface{}{"topics": map[string]interface{}{"terms": map[string]interface{}{"field": "topic", "size": maxFanoutTopics}}},
+	}).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list topics of index '%s': %w", c.indexName, err)
+	}
+	var topics struct {
+		Buckets []struct {
+			Key string `json:"key"`
+		} `json:"buckets"`
+	}
+	if raw, ok := topicsResult.Aggregations["topics"]; ok {
+		if err := json.Unmarshal(raw, &topics); err != nil {
+			return nil, fmt.Errorf("failed to decode topics of index '%s': %w", c.indexName, err)
+		}
+	}
+
+	centroids := make(map[string][]float64, len(topics.Buckets))
+	for _, b := range topics.Buckets {
+		filter := &SearchFilter{Topics: []string{b.Key}}
+		result, err := c.client.Search().Index(c.searchIndices()...).Source(map[string]interface{}{
+			"size":  centroidSampleSize,
+			"query": filter.query(),
+			"sort":  []map[string]interface{}{{"end_time": map[string]interface{}{"order": "desc"}}},
+		}).Do(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sample embeddings of topic %s: %w", b.Key, err)
+		}
+		var vectors [][]float32
+		for _, hit := range result.Hits.Hits {
+			ew, err := fromDocument(hit.Source)
+			if err != nil || len(ew.Embedding) != dims {
+				continue
+			}
+			vectors = append(vectors, ew.Embedding)
+		}
+		if centroid := centroidOf(vectors); centroid != nil {
+			centroids[b.Key] = centroid
+		}
+	}
+
+	f.mu.Lock()
+	f.centroids[dims] = centroids
+	f.refreshed[dims] = time.Now()
+	f.mu.Unlock()
+	return centroids, nil
+}
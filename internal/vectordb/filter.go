@@ -0,0 +1,111 @@
+package vectordb
+
+import (
+	"strings"
+	"time"
+
+	"stream-rag-agent/internal/window"
+)
+
+// SearchFilter narrows a similarity search to a subset of windows.
+type SearchFilter struct {
+	Topics          []string          // Only windows of these topics, all topics if empty
+	From            time.Time         // Only windows ending at or after From, if set
+	To              time.Time         // Only windows starting at or before To, if set
+	Entities        []string          // Only windows whose context text mentions all of these values
+	Categories      []string          // Only windows labeled with one of these categories, all windows if empty
+	Headers         map[string]string // Only windows with a message carrying each of these header values
+	EmbeddingModels []string          // Only windows embedded by one of these embedding models, all windows if empty
+
+	ExcludeTopics []string // Never windows of these topics, e.g. restricted by the egress policy
+
+	Recency *RecencyDecay      // Reranks similarity and keyword results by window age; nil ranks by relevance only
+	Explain *SearchExplanation // Collects the scores of returned windows, nil if not needed
+}
+
+// query converts the filter into an Elasticsearch bool query, or nil if it matches everything.
+func (f *SearchFilter) query() map[string]interface{} {
+	if f == nil {
+		return nil
+	}
+
+	var must []map[string]interface{}
+	if len(f.Topics) > 0 {
+		must = append(must, map[string]interface{}{"terms": map[string]interface{}{"topic": f.Topics}})
+	}
+	if !f.From.IsZero() {
+		must = append(must, map[string]interface{}{"range": map[string]interface{}{"end_time": map[string]interface{}{"gte": f.From}}})
+	}
+	if !f.To.IsZero() {
+		must = append(must, map[string]interface{}{"range": map[string]interface{}{"start_time": map[string]interface{}{"lte": f.To}}})
+	}
+	if len(f.Categories) > 0 {
+		must = append(must, map[string]interface{}{"terms": map[string]interface{}{"category": f.Categories}})
+	}
+	if len(f.EmbeddingModels) > 0 {
+		must = append(must, map[string]interface{}{"terms": map[string]interface{}{"embedding_model": f.EmbeddingModels}})
+	}
+	for name, value := range f.Headers {
+		must = append(must, map[string]interface{}{"term": map[string]interface{}{"headers." + name: value}})
+	}
+	for _, entity := range f.Entities {
+		must = append(must, map[string]interface{}{"match_phrase": map[string]interface{}{"context_text": entity}})
+	}
+
+	boolQuery := map[string]interface{}{}
+	if len(must) > 0 {
+		boolQuery["filter"] = must
+	}
+	if len(f.ExcludeTopics) > 0 {
+		boolQuery["must_not"] = []map[string]interface{}{{"terms": map[string]interface{}{"topic": f.ExcludeTopics}}}
+	}
+	if len(boolQuery) == 0 {
+		return nil
+	}
+	return map[string]interface{}{"bool": boolQuery}
+}
+
+// matches applies the filter to a window in memory, as query does in Elasticsearch.
+func (f *SearchFilter) matches(ew window.EmbeddedWindow) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Topics) > 0 && !containsString(f.Topics, ew.Topic) {
+		return false
+	}
+	if len(f.Categories) > 0 && !containsString(f.Categories, ew.Category) {
+		return false
+	}
+	if len(f.EmbeddingModels) > 0 && !containsString(f.EmbeddingModels, ew.EmbeddingModel) {
+		return false
+	}
+	for name, value := range f.Headers {
+		if !containsString(ew.Headers[name], value) {
+			return false
+		}
+	}
+	if containsString(f.ExcludeTopics, ew.Topic) {
+		return false
+	}
+	if !f.From.IsZero() && ew.EndTime.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && ew.StartTime.After(f.To) {
+		return false
+	}
+	for _, entity := range f.Entities {
+		if !strings.Contains(strings.ToLower(ew.ContextText), strings.ToLower(entity)) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
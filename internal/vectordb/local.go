@@ -0,0 +1,405 @@
+package vectordb
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"stream-rag-agent/internal/atrest"
+	"stream-rag-agent/internal/metrics"
+	"stream-rag-agent/internal/window"
+)
+
+const localJournalFile = "windows.jsonl"
+
+// defaultLocalMaxWindows is how many windows a LocalStore keeps unless dev.max_windows is set.
+const defaultLocalMaxWindows = 10000
+
+var localEvictedTotal = metrics.NewCounter("local_store_evicted_windows_total", "Windows dropped from the dev-mode local store because it held more than dev.max_windows.")
+
+// LocalStore is an embedded WindowStore for development: windows are appended to a JSON
+// lines journal in a directory and searched by brute-force cosine similarity in memory. It
+// needs no external service. Rather than an embedded database, it uses a journal like the
+// agent's other local files, so encryption at rest seals it line by line; in exchange it
+// holds its windows in memory. It keeps at most dev.max_windows of them, dropping the ones
+// that ended earliest, and is not meant for production volumes.
+type LocalStore struct {
+	mu         sync.RWMutex
+	path       string
+	journal    *os.File
+	windows    map[string]window.EmbeddedWindow // By window ID
+	maxWindows int                              // -1 keeps every window
+	entries    int                              // Journal entries, including superseded and evicted windows
+}
+
+// NewLocalStore opens (or creates) the store in dir, replaying its journal. maxWindows bounds
+// the windows kept (0 uses the default, -1 keeps all). The journal is compacted when it holds
+// many superseded or evicted entries.
+func NewLocalStore(dir string, maxWindows int) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create local store directory: %w", err)
+	}
+	s := newLocalStore(dir, maxWindows)
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	if err := s.compactIfSparse(); err != nil {
+		return nil, err
+	}
+	if s.journal == nil {
+		if err := s.openJournal(); err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("Local store %s loaded with %d windows", s.path, len(s.windows))
+	return s, nil
+}
+
+// LoadLocalStore loads the windows of the store in dir for reading, e.g. next to an agent that
+// is writing to it: the journal is neither compacted nor appended to, and windows written
+// afterwards are not seen. maxWindows is as for NewLocalStore.
+func LoadLocalStore(dir string, maxWindows int) (*LocalStore, error) {
+	s := newLocalStore(dir, maxWindows)
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	log.Printf("Local store %s loaded read-only with %d windows", s.path, len(s.windows))
+	return s, nil
+}
+
+func newLocalStore(dir string, maxWindows int) *LocalStore {
+	if maxWindows == 0 {
+		maxWindows = defaultLocalMaxWindows
+	}
+	return &LocalStore{path: filepath.Join(dir, localJournalFile), windows: make(map[string]window.EmbeddedWindow), maxWindows: maxWindows}
+}
+
+func (s *LocalStore) openJournal() error {
+	journal, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open local store journal: %w", err)
+	}
+	s.journal = journal
+	return nil
+}
+
+// evict drops the windows that ended earliest once the store holds more than maxWindows,
+// down to nine tenths of it so that not every window saved sorts the store. Callers hold
+// s.mu or have not published the store yet.
+func (s *LocalStore) evict() {
+	if s.maxWindows < 0 || len(s.windows) <= s.maxWindows {
+		return
+	}
+	windows := make([]*window.EmbeddedWindow, 0, len(s.windows))
+	for id := range s.windows {
+		ew := s.windows[id]
+		windows = append(windows, &ew)
+	}
+	sort.Slice(windows, func(i, j int) bool {
+		if !windows[i].EndTime.Equal(windows[j].EndTime) {
+			return windows[i].EndTime.Before(windows[j].EndTime)
+		}
+		return windows[i].WindowID < windows[j].WindowID
+	})
+	// Keep 90% of the limit to evict in batches, but at least one window for small limits
+	drop := len(windows) - max(s.maxWindows*9/10, 1)
+	for _, ew := range windows[:drop] {
+		delete(s.windows, ew.WindowID)
+	}
+	localEvictedTotal.Add(float64(drop))
+	log.Printf("Local store %s dropped its %d oldest windows, it holds at most %d", s.path, drop, s.maxWindows)
+}
+
+// compactIfSparse compacts the journal when it holds more than twice as many entries as
+// windows, reopening it if it was open. Callers hold s.mu or have not published the store yet.
+func (s *LocalStore) compactIfSparse() error {
+	if s.entries <= 2*len(s.windows) || s.entries <= 100 {
+		return nil
+	}
+	if s.journal != nil {
+		if err := s.journal.Close(); err != nil {
+			return fmt.Errorf("failed to compact local store journal: %w", err)
+		}
+		s.journal = nil
+	}
+	if err := s.compact(); err != nil {
+		return err
+	}
+	s.entries = len(s.windows)
+	return s.openJournal()
+}
+
+// replay loads the journal, later entries replacing earlier ones of the same window, and
+// counts its entries. Windows beyond maxWindows are evicted as they are read.
+func (s *LocalStore) replay() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open local store journal: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64<<20)
+	for scanner.Scan() {
+		line, err := atrest.OpenLine(scanner.Bytes())
+		if errors.Is(err, atrest.ErrNoKey) {
+			return fmt.Errorf("failed to read local store journal: %w", err)
+		}
+		var ew window.EmbeddedWindow
+		if err == nil {
+			err = json.Unmarshal(line, &ew)
+		}
+		if err != nil {
+			// A torn write at the end of the journal after a crash
+			log.Printf("Skipping unreadable local store journal entry: %v", err)
+			continue
+		}
+		s.windows[ew.WindowID] = ew
+		s.entries++
+		s.evict()
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read local store journal: %w", err)
+	}
+	return nil
+}
+
+// compact rewrites the journal with one entry per window.
+func (s *LocalStore) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to compact local store journal: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	for _, ew := range s.windows {
+		line, err := journalLine(&ew)
+		if err == nil {
+			_, err = w.Write(line)
+		}
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("failed to compact local store journal: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to compact local store journal: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to compact local store journal: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// journalLine renders a journal entry, encrypted when encryption is enabled.
+func journalLine(ew *window.EmbeddedWindow) ([]byte, error) {
+	data, err := json.Marshal(ew)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal embedded window: %w", err)
+	}
+	line, err := atrest.SealLine(data)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+func (s *LocalStore) SaveEmbeddedWindow(ew *window.EmbeddedWindow) error {
+	line, err := journalLine(ew)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.journal == nil {
+		return fmt.Errorf("local store %s is read-only", s.path)
+	}
+	if _, err := s.journal.Write(line); err != nil {
+		return fmt.Errorf("failed to append window to local store journal: %w", err)
+	}
+	s.windows[ew.WindowID] = *ew
+	s.entries++
+	s.evict()
+	return s.compactIfSparse()
+}
+
+// SaveEvents discards structured events; structured queries need Elasticsearch.
+func (s *LocalStore) SaveEvents(events []StructuredEvent) error {
+	return nil
+}
+
+func (s *LocalStore) SearchSimilarWindows(ctx context.Context, queryEmbedding []float32, k int, filter *SearchFilter) ([]window.EmbeddedWindow, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var hits []scoredWindow
+	for _, ew := range s.windows {
+		if len(ew.Embedding) != len(queryEmbedding) || !filter.matches(ew) {
+			continue
+		}
+		hits = append(hits, scoredWindow{window: ew, score: cosineSimilarity32(queryEmbedding, ew.Embedding)})
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].score > hits[j].score })
+	if filter != nil {
+		hits = filter.Recency.rerank(hits, len(hits))
+	}
+
+	filter.explain("vector", hits[:min(k, len(hits))])
+	found := make([]window.EmbeddedWindow, 0, min(k, len(hits)))
+	for i := 0; i < len(hits) && i < k; i++ {
+		w := hits[i].window
+		w.Embedding = nil // Vectors are not needed to build prompts
+		found = append(found, w)
+	}
+	return found, nil
+}
+
+// SearchKeywordWindows ranks the windows matching the filter by BM25 over their context text,
+// with the usual parameters k1 = 1.2 and b = 0.75. Windows sharing no term with text are left out.
+func (s *LocalStore) SearchKeywordWindows(ctx context.Context, text string, k int, filter *SearchFilter) ([]window.EmbeddedWindow, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	const k1, b = 1.2, 0.75
+	queryTerms := keywordTerms(text)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	type document struct {
+		window window.EmbeddedWindow
+		terms  map[string]int
+		length int
+	}
+	var docs []document
+	docFreq := make(map[string]int)
+	totalLength := 0
+	for _, ew := range s.windows {
+		if !filter.matches(ew) {
+			continue
+		}
+		terms := make(map[string]int)
+		tokens := keywordTokens(ew.ContextText)
+		for _, t := range tokens {
+			terms[t]++
+		}
+		for t := range queryTerms {
+			if terms[t] > 0 {
+				docFreq[t]++
+			}
+		}
+		docs = append(docs, document{window: ew, terms: terms, length: len(tokens)})
+		totalLength += len(tokens)
+	}
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	avgLength := float64(totalLength) / float64(len(docs))
+
+	var hits []scoredWindow
+	for _, d := range docs {
+		score := 0.0
+		for t := range queryTerms {
+			tf := float64(d.terms[t])
+			if tf == 0 {
+				continue
+			}
+			idf := math.Log(1 + (float64(len(docs))-float64(docFreq[t])+0.5)/(float64(docFreq[t])+0.5))
+			score += idf * tf * (k1 + 1) / (tf + k1*(1-b+b*float64(d.length)/max(avgLength, 1)))
+		}
+		if score > 0 {
+			hits = append(hits, scoredWindow{window: d.window, score: score})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].score > hits[j].score })
+	if filter != nil {
+		hits = filter.Recency.rerank(hits, len(hits))
+	}
+
+	filter.explain("keyword", hits[:min(k, len(hits))])
+	found := make([]window.EmbeddedWindow, 0, min(k, len(hits)))
+	for i := 0; i < len(hits) && i < k; i++ {
+		w := hits[i].window
+		w.Embedding = nil
+		found = append(found, w)
+	}
+	return found, nil
+}
+
+// LatestWindows returns the k windows matching the filter that ended last, newest first.
+func (s *LocalStore) LatestWindows(ctx context.Context, k int, filter *SearchFilter) ([]window.EmbeddedWindow, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var found []window.EmbeddedWindow
+	for _, ew := range s.windows {
+		if filter.matches(ew) {
+			ew.Embedding = nil
+			found = append(found, ew)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if !found[i].EndTime.Equal(found[j].EndTime) {
+			return found[i].EndTime.After(found[j].EndTime)
+		}
+		return found[i].WindowID < found[j].WindowID
+	})
+	return found[:min(k, len(found))], nil
+}
+
+// keywordTokens splits text into lower-cased words and numbers.
+func keywordTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// keywordTerms returns the distinct tokens of text.
+func keywordTerms(text string) map[string]bool {
+	terms := make(map[string]bool)
+	for _, t := range keywordTokens(text) {
+		terms[t] = true
+	}
+	return terms
+}
+
+func (s *LocalStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.journal == nil {
+		return nil
+	}
+	return s.journal.Close()
+}
+
+// cosineSimilarity32 is cosineSimilarity for stored float32 vectors.
+func cosineSimilarity32(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
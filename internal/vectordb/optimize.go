@@ -0,0 +1,180 @@
+package vectordb
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/metrics"
+)
+
+const (
+	defaultOptimizeInterval    = 30 * time.Minute
+	defaultMaxSegmentsPerShard = 20
+	defaultMergeToSegments     = 1
+)
+
+var (
+	segmentsPerShard = metrics.NewGauge("elasticsearch_segments_per_shard", "Average segments per primary shard of a windows index, as last checked by the optimizer.")
+	forcemergesTotal = metrics.NewCounter("elasticsearch_forcemerges_total", "Force merges run by the optimizer, by index and result.")
+)
+
+// optimizer merges the segments of the windows indices during a daily low-traffic period.
+// Windows are indexed one by one and replaced when re-embedded, which leaves many small
+// segments and deleted documents behind; every segment is searched separately by kNN.
+type optimizer struct {
+	interval    time.Duration
+	from, to    time.Duration // Schedule as offsets from midnight; equal means any time
+	maxSegments float64
+	mergeTo     int
+	maxRate     float64
+
+	lastIndexed int64 // Documents indexed into the windows indices at the previous check
+	lastCheck   time.Time
+}
+
+// newOptimizer returns nil when optimization is disabled.
+func newOptimizer(cfg config.OptimizeConfig) (*optimizer, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	o := &optimizer{
+		interval:    time.Duration(cfg.CheckIntervalMinutes) * time.Minute,
+		maxSegments: float64(cfg.MaxSegmentsPerShard),
+		mergeTo:     cfg.MergeToSegments,
+		maxRate:     cfg.MaxDocsPerSecond,
+	}
+	if o.interval <= 0 {
+		o.interval = defaultOptimizeInterval
+	}
+	if o.maxSegments <= 0 {
+		o.maxSegments = defaultMaxSegmentsPerShard
+	}
+	if o.mergeTo <= 0 {
+		o.mergeTo = defaultMergeToSegments
+	}
+	if cfg.Schedule != "" {
+		var fromH, fromM, toH, toM int
+		if _, err := fmt.Sscanf(cfg.Schedule, "%d:%d-%d:%d", &fromH, &fromM, &toH, &toM); err != nil ||
+			fromH > 23 || toH > 23 || fromM > 59 || toM > 59 || fromH < 0 || toH < 0 || fromM < 0 || toM < 0 {
+			return nil, fmt.Errorf("invalid elasticsearch.optimize.schedule %q, expected HH:MM-HH:MM", cfg.Schedule)
+		}
+		o.from = time.Duration(fromH)*time.Hour + time.Duration(fromM)*time.Minute
+		o.to = time.Duration(toH)*time.Hour + time.Duration(toM)*time.Minute
+	}
+	return o, nil
+}
+
+// inSchedule reports whether t falls into the daily period, which may span midnight.
+func (o *optimizer) inSchedule(t time.Time) bool {
+	if o.from == o.to {
+		return true
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	if o.from < o.to {
+		return offset >= o.from && offset < o.to
+	}
+	return offset >= o.from || offset < o.to
+}
+
+// RunOptimizer checks the segment counts of the windows indices periodically and force
+// merges those with too many segments, as long as the time is within the schedule (in loc)
+// and windows are not being bulk loaded, until ctx is cancelled. It returns immediately
+// when optimization is disabled.
+func (c *ElasticsearchClient) RunOptimizer(ctx context.Context, loc *time.Location) {
+	o := c.optimizer
+	if o == nil {
+		return
+	}
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := c.optimize(ctx, now.In(loc)); err != nil {
+				log.Printf("Index optimizer: %v", err)
+			}
+		}
+	}
+}
+
+func (c *ElasticsearchClient) optimize(ctx context.Context, now time.Time) error {
+	o := c.optimizer
+	indices, err := c.windowIndices()
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.IndexStats(indices...).Metric("segments", "indexing").Level("shards").Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get segment stats of %v: %w", indices, err)
+	}
+
+	// The indexing rate since the previous check tells whether traffic is low
+	var indexed int64
+	var crowded []string
+	for name, stats := range resp.Indices {
+		if stats.Primaries == nil {
+			continue
+		}
+		if stats.Primaries.Indexing != nil {
+			indexed += stats.Primaries.Indexing.IndexTotal
+		}
+		shards := max(len(stats.Shards), 1)
+		perShard := 0.0
+		if stats.Primaries.Segments != nil {
+			perShard = float64(stats.Primaries.Segments.Count) / float64(shards)
+		}
+		segmentsPerShard.Set(perShard, "index", name)
+		if perShard > o.maxSegments {
+			crowded = append(crowded, name)
+		}
+	}
+	rate := -1.0
+	if !o.lastCheck.IsZero() && indexed >= o.lastIndexed {
+		rate = float64(indexed-o.lastIndexed) / now.Sub(o.lastCheck).Seconds()
+	}
+	o.lastIndexed, o.lastCheck = indexed, now
+
+	if len(crowded) == 0 || !o.inSchedule(now) {
+		return nil
+	}
+	if c.bulkLoading() {
+		log.Printf("Index optimizer: postponing merge of %v during a bulk load", crowded)
+		return nil
+	}
+	if o.maxRate > 0 && (rate < 0 || rate > o.maxRate) {
+		if rate >= 0 {
+			log.Printf("Index optimizer: postponing merge of %v, indexing %.1f windows/s exceeds %.1f", crowded, rate, o.maxRate)
+		}
+		return nil
+	}
+
+	for _, index := range crowded {
+		log.Printf("Index optimizer: force merging %s to %d segments per shard", index, o.mergeTo)
+		start := time.Now()
+		if _, err := c.client.Forcemerge(index).MaxNumSegments(o.mergeTo).Do(ctx); err != nil {
+			forcemergesTotal.Inc("index", index, "result", "error")
+			log.Printf("Index optimizer: force merge of %s failed: %v", index, err)
+			continue
+		}
+		forcemergesTotal.Inc("index", index, "result", "ok")
+		if _, err := c.client.Refresh(index).Do(ctx); err != nil {
+			log.Printf("Index optimizer: refresh of %s after merging failed: %v", index, err)
+		}
+		log.Printf("Index optimizer: merged %s in %s", index, time.Since(start).Round(time.Second))
+	}
+	return nil
+}
+
+// bulkLoading reports whether the refresh interval is relaxed for a bulk load.
+func (c *ElasticsearchClient) bulkLoading() bool {
+	t := c.refresh
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.bulkLoads > 0 || t.rateHigh
+}
@@ -0,0 +1,329 @@
+package vectordb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// QueryRecord is one answered (or failed) question, kept in the queries index for usage analytics.
+type QueryRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	Endpoint  string    `json:"endpoint"`
+	Mode      string    `json:"mode,omitempty"`
+	Model     string    `json:"model,omitempty"`
+	APIKey    string    `json:"api_key,omitempty"` // Name of the API key, not the key itself
+	Question  string    `json:"question"`
+	Topics    []string  `json:"topics,omitempty"` // Topics of the windows the answer was based on
+	Sources   int       `json:"sources"`
+	Status    int       `json:"status"` // HTTP status of the response
+	Failed    bool      `json:"failed"`
+	LatencyMs int64     `json:"latency_ms"`
+	Judge     *Judgment `json:"judge,omitempty"` // Scores of the answer, nil if it was not judged
+}
+
+// Judgment is how a judge model scored an answer, from 1 (worst) to 5 (best).
+type Judgment struct {
+	Model        string `json:"model"`
+	ConfigLabel  string `json:"config_label,omitempty"`
+	Groundedness int    `json:"groundedness"` // Claims of the answer supported by the retrieved windows
+	Completeness int    `json:"completeness"` // How fully the answer addresses the question
+	Reason       string `json:"reason,omitempty"`
+}
+
+// TermCount is a value with the number of queries, and failed queries, it occurred in.
+type TermCount struct {
+	Term   string `json:"term"`
+	Count  int64  `json:"count"`
+	Failed int64  `json:"failed"`
+}
+
+type AnalyticsBucket struct {
+	Start           time.Time `json:"start"`
+	Total           int64     `json:"total"`
+	Failed          int64     `json:"failed"`
+	AvgGroundedness *float64  `json:"avg_groundedness,omitempty"` // Of the judged answers, nil if none were judged
+	AvgCompleteness *float64  `json:"avg_completeness,omitempty"`
+}
+
+// JudgeScores are the average judge scores of the answers recorded with a config label.
+type JudgeScores struct {
+	ConfigLabel     string  `json:"config_label"`
+	Judged          int64   `json:"judged"`
+	AvgGroundedness float64 `json:"avg_groundedness"`
+	AvgCompleteness float64 `json:"avg_completeness"`
+}
+
+// QueryAnalytics summarizes the queries of a period.
+type QueryAnalytics struct {
+	From         time.Time         `json:"from"`
+	To           time.Time         `json:"to"`
+	Total        int64             `json:"total"`
+	Failed       int64             `json:"failed"`
+	FailureRate  float64           `json:"failure_rate"`
+	AvgLatencyMs float64           `json:"avg_latency_ms"`
+	Topics       []TermCount       `json:"topics"`
+	Modes        []TermCount       `json:"modes"`
+	Endpoints    []TermCount       `json:"endpoints"`
+	TopQuestions []TermCount       `json:"top_questions"`
+	Timeline     []AnalyticsBucket `json:"timeline"`
+
+	// Judge scores of the period, see query.judge
+	Judged          int64         `json:"judged"`
+	AvgGroundedness float64       `json:"avg_groundedness,omitempty"`
+	AvgCompleteness float64       `json:"avg_completeness,omitempty"`
+	JudgeLabels     []JudgeScores `json:"judge_labels,omitempty"` // By config label, to compare configurations
+}
+
+func (c *ElasticsearchClient) queriesIndex() string {
+	return c.indexName + "_queries"
+}
+
+func (c *ElasticsearchClient) ensureQueriesIndex() error {
+	ctx := context.Background()
+	exists, err := c.client.IndexExists(c.queriesIndex()).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check if queries index exists: %w", err)
+	}
+	if exists {
+		return nil
+	}
+
+	mapping := `{
+		"settings": ` + c.settings.json() + `,
+		"mappings": {
+			"properties": {
+				"timestamp":  {"type": "date"},
+				"endpoint":   {"type": "keyword"},
+				"mode":       {"type": "keyword"},
+				"model":      {"type": "keyword"},
+				"api_key":    {"type": "keyword"},
+				"question":   {"type": "text", "fields": {"raw": {"type": "keyword", "ignore_above": 512}}},
+				"topics":     {"type": "keyword"},
+				"sources":    {"type": "integer"},
+				"status":     {"type": "integer"},
+				"failed":     {"type": "boolean"},
+				"latency_ms": {"type": "long"},
+				"judge": {"properties": {
+					"model":        {"type": "keyword"},
+					"config_label": {"type": "keyword"},
+					"groundedness": {"type": "integer"},
+					"completeness": {"type": "integer"},
+					"reason":       {"type": "text"}
+				}}
+			}
+		}
+	}`
+	if _, err := c.client.CreateIndex(c.queriesIndex()).BodyString(mapping).Do(ctx); err != nil {
+		return fmt.Errorf("failed to create queries index '%s': %w", c.queriesIndex(), err)
+	}
+	log.Printf("Elasticsearch queries index '%s' created successfully.", c.queriesIndex())
+	return nil
+}
+
+// SaveQuery records a query for analytics.
+func (c *ElasticsearchClient) SaveQuery(rec QueryRecord) error {
+	if err := c.ensureQueriesIndex(); err != nil {
+		return err
+	}
+	if _, err := c.client.Index().Index(c.queriesIndex()).BodyJson(rec).Do(context.Background()); err != nil {
+		return fmt.Errorf("failed to index query record: %w", err)
+	}
+	return nil
+}
+
+// QueryAnalytics aggregates the queries recorded between from and to, with a timeline in
+// buckets of the given interval (an Elasticsearch fixed interval such as "1h").
+func (c *ElasticsearchClient) QueryAnalytics(from, to time.Time, interval string) (*QueryAnalytics, error) {
+	if err := c.ensureQueriesIndex(); err != nil {
+		return nil, err
+	}
+	failed := map[string]interface{}{"failed": map[string]interface{}{"filter": map[string]interface{}{"term": map[string]interface{}{"failed": true}}}}
+	scores := map[string]interface{}{
+		"groundedness": map[string]interface{}{"avg": map[string]interface{}{"field": "judge.groundedness"}},
+		"completeness": map[string]interface{}{"avg": map[string]interface{}{"field": "judge.completeness"}},
+	}
+	timelineAggs := map[string]interface{}{"failed": failed["failed"]}
+	for name, agg := range scores {
+		timelineAggs[name] = agg
+	}
+	terms := func(field string, size int) map[string]interface{} {
+		return map[string]interface{}{"terms": map[string]interface{}{"field": field, "size": size}, "aggs": failed}
+	}
+	body := map[string]interface{}{
+		"size":             0,
+		"track_total_hits": true,
+		"query": map[string]interface{}{"range": map[string]interface{}{"timestamp": map[string]interface{}{
+			"gte": from, "lte": to,
+		}}},
+		"aggs": map[string]interface{}{
+			"failed":        failed["failed"],
+			"latency":       map[string]interface{}{"avg": map[string]interface{}{"field": "latency_ms"}},
+			"topics":        terms("topics", 20),
+			"modes":         terms("mode", 10),
+			"endpoints":     terms("endpoint", 10),
+			"top_questions": terms("question.raw", 10),
+			"judged": map[string]interface{}{
+				"filter": map[string]interface{}{"exists": map[string]interface{}{"field": "judge.groundedness"}},
+				"aggs":   scores,
+			},
+			"judge_labels": map[string]interface{}{
+				"terms": map[string]interface{}{"field": "judge.config_label", "size": 20},
+				"aggs":  scores,
+			},
+			"timeline": map[string]interface{}{
+				"date_histogram": map[string]interface{}{"field": "timestamp", "fixed_interval": interval, "min_doc_count": 0},
+				"aggs":           timelineAggs,
+			},
+		},
+	}
+
+	result, err := c.client.Search().Index(c.queriesIndex()).Source(body).Do(context.Background())
+	if err != nil {
+		return nil, esError(fmt.Errorf("failed to aggregate query analytics: %w", err))
+	}
+
+	out := &QueryAnalytics{From: from, To: to}
+	if result.Hits != nil && result.Hits.TotalHits != nil {
+		out.Total = result.Hits.TotalHits.Value
+	}
+	var failedAgg struct {
+		DocCount int64 `json:"doc_count"`
+	}
+	var latency struct {
+		Value *float64 `json:"value"`
+	}
+	decode := func(name string, v interface{}) error {
+		raw, ok := result.Aggregations[name]
+		if !ok {
+			return nil
+		}
+		if err := json.Unmarshal(raw, v); err != nil {
+			return fmt.Errorf("failed to decode %s analytics: %w", name, err)
+		}
+		return nil
+	}
+	if err := decode("failed", &failedAgg); err != nil {
+		return nil, err
+	}
+	if err := decode("latency", &latency); err != nil {
+		return nil, err
+	}
+	out.Failed = failedAgg.DocCount
+	if out.Total > 0 {
+		out.FailureRate = float64(out.Failed) / float64(out.Total)
+	}
+	if latency.Value != nil {
+		out.AvgLatencyMs = *latency.Value
+	}
+
+	type avg struct {
+		Value *float64 `json:"value"`
+	}
+	type bucket struct {
+		Key         interface{} `json:"key"`
+		KeyAsString string      `json:"key_as_string"`
+		DocCount    int64       `json:"doc_count"`
+		Failed      struct {
+			DocCount int64 `json:"doc_count"`
+		} `json:"failed"`
+		Groundedness avg `json:"groundedness"`
+		Completeness avg `json:"completeness"`
+	}
+	termCounts := func(name string) ([]TermCount, error) {
+		var agg struct {
+			Buckets []bucket `json:"buckets"`
+		}
+		if err := decode(name, &agg); err != nil {
+			return nil, err
+		}
+		counts := make([]TermCount, 0, len(agg.Buckets))
+		for _, b := range agg.Buckets {
+			counts = append(counts, TermCount{Term: fmt.Sprintf("%v", b.Key), Count: b.DocCount, Failed: b.Failed.DocCount})
+		}
+		return counts, nil
+	}
+	if out.Topics, err = termCounts("topics"); err != nil {
+		return nil, err
+	}
+	if out.Modes, err = termCounts("modes"); err != nil {
+		return nil, err
+	}
+	if out.Endpoints, err = termCounts("endpoints"); err != nil {
+		return nil, err
+	}
+	if out.TopQuestions, err = termCounts("top_questions"); err != nil {
+		return nil, err
+	}
+
+	var timeline struct {
+		Buckets []bucket `json:"buckets"`
+	}
+	if err := decode("timeline", &timeline); err != nil {
+		return nil, err
+	}
+	for _, b := range timeline.Buckets {
+		ms, _ := b.Key.(float64)
+		out.Timeline = append(out.Timeline, AnalyticsBucket{
+			Start: time.UnixMilli(int64(ms)).UTC(), Total: b.DocCount, Failed: b.Failed.DocCount,
+			AvgGroundedness: b.Groundedness.Value, AvgCompleteness: b.Completeness.Value,
+		})
+	}
+
+	var judged bucket
+	if err := decode("judged", &judged); err != nil {
+		return nil, err
+	}
+	out.Judged = judged.DocCount
+	if judged.Groundedness.Value != nil {
+		out.AvgGroundedness = *judged.Groundedness.Value
+	}
+	if judged.Completeness.Value != nil {
+		out.AvgCompleteness = *judged.Completeness.Value
+	}
+	var labels struct {
+		Buckets []bucket `json:"buckets"`
+	}
+	if err := decode("judge_labels", &labels); err != nil {
+		return nil, err
+	}
+	for _, b := range labels.Buckets {
+		label := JudgeScores{ConfigLabel: fmt.Sprintf("%v", b.Key), Judged: b.DocCount}
+		if b.Groundedness.Value != nil {
+			label.AvgGroundedness = *b.Groundedness.Value
+		}
+		if b.Completeness.Value != nil {
+			label.AvgCompleteness = *b.Completeness.Value
+		}
+		out.JudgeLabels = append(out.JudgeLabels, label)
+	}
+	return out, nil
+}
+
+// RecentQuestions returns up to limit questions recorded since from, newest first, with
+// whether answering them failed.
+func (c *ElasticsearchClient) RecentQuestions(from time.Time, limit int) ([]QueryRecord, error) {
+	if err := c.ensureQueriesIndex(); err != nil {
+		return nil, err
+	}
+	body := map[string]interface{}{
+		"size":  limit,
+		"query": map[string]interface{}{"range": map[string]interface{}{"timestamp": map[string]interface{}{"gte": from}}},
+		"sort":  []map[string]interface{}{{"timestamp": map[string]interface{}{"order": "desc"}}},
+	}
+	result, err := c.client.Search().Index(c.queriesIndex()).Source(body).Do(context.Background())
+	if err != nil {
+		return nil, esError(fmt.Errorf("failed to read recent questions: %w", err))
+	}
+	records := make([]QueryRecord, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		var rec QueryRecord
+		if err := json.Unmarshal(hit.Source, &rec); err != nil {
+			continue
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
@@ -0,0 +1,67 @@
+package vectordb
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// recencyCandidates is how many more windows than requested are retrieved when results are
+// reranked by recency, so recent windows just outside the top k by similarity can move up.
+const recencyCandidates = 3
+
+// RecencyDecay lowers the score of older windows like Elasticsearch's gauss decay function on
+// end_time: windows that ended within Offset of now keep their score, a window that ended
+// Scale beyond that keeps Decay of it, and older ones fall off along a Gaussian. Weight is
+// the share of the score that decays; the rest is kept whatever the window's age.
+type RecencyDecay struct {
+	Scale  time.Duration
+	Offset time.Duration
+	Decay  float64   // Between 0 and 1 exclusive
+	Weight float64   // Between 0 and 1
+	Origin time.Time // Zero uses the time of the search
+}
+
+// factor returns the multiplier of the score of a window that ended at end.
+func (d *RecencyDecay) factor(end, origin time.Time) float64 {
+	distance := origin.Sub(end)
+	if distance < 0 {
+		distance = -distance
+	}
+	distance -= d.Offset
+	if distance <= 0 || d.Scale <= 0 {
+		return 1
+	}
+	x := float64(distance) / float64(d.Scale)
+	gauss := math.Exp(math.Log(d.Decay) * x * x)
+	return 1 - d.Weight + d.Weight*gauss
+}
+
+// candidates returns how many windows to retrieve for k results.
+func (d *RecencyDecay) candidates(k int) int {
+	if d == nil {
+		return k
+	}
+	return k * recencyCandidates
+}
+
+// rerank multiplies the scores of the hits by their recency factor and returns the best k.
+func (d *RecencyDecay) rerank(hits []scoredWindow, k int) []scoredWindow {
+	if d == nil {
+		return hits
+	}
+	origin := d.Origin
+	if origin.IsZero() {
+		origin = time.Now()
+	}
+	for i := range hits {
+		hits[i].relevance = hits[i].score
+		hits[i].recency = d.factor(hits[i].window.EndTime, origin)
+		hits[i].score *= hits[i].recency
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].score > hits[j].score })
+	if len(hits) > k {
+		hits = hits[:k]
+	}
+	return hits
+}
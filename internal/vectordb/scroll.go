@@ -0,0 +1,56 @@
+package vectordb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"stream-rag-agent/internal/window"
+)
+
+const scrollBatchSize = 100
+
+// ScrollWindows iterates over all windows matching the filter in batches, calling fn for each
+// batch. Iteration stops early if fn returns an error.
+func (c *ElasticsearchClient) ScrollWindows(filter *SearchFilter, fn func([]window.EmbeddedWindow) error) error {
+	ctx := context.Background()
+
+	query := filter.query()
+	if query == nil {
+		query = map[string]interface{}{"match_all": map[string]interface{}{}}
+	}
+
+	scroll := c.client.Scroll(c.searchIndices()...).
+		Body(map[string]interface{}{"query": query}).
+		Size(scrollBatchSize).
+		KeepAlive("2m")
+	defer func() {
+		if err := scroll.Clear(ctx); err != nil {
+			log.Printf("Error clearing Elasticsearch scroll: %v", err)
+		}
+	}()
+
+	for {
+		result, err := scroll.Do(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to scroll windows in index '%s': %w", c.indexName, err)
+		}
+
+		batch := make([]window.EmbeddedWindow, 0, len(result.Hits.Hits))
+		for _, hit := range result.Hits.Hits {
+			ew, err := fromDocument(hit.Source)
+			if err != nil {
+				log.Printf("Error unmarshaling embedded window from ES hit: %v", err)
+				continue
+			}
+			batch = append(batch, ew)
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package vectordb
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"stream-rag-agent/internal/window"
+	"stream-rag-agent/pkg/errs"
+)
+
+// ErrInvalidCursor is returned for a pagination cursor that was not produced by SearchWindows.
+var ErrInvalidCursor = errs.New(errs.ErrInvalidRequest, "invalid cursor")
+
+// SearchPage is one page of a paginated window search.
+type SearchPage struct {
+	Windows    []window.EmbeddedWindow
+	Scores     []float64 // Relevance of each window, 0 when browsing without a text query
+	Total      int64     // Windows matching the search, across all pages
+	NextCursor string    // Pass to the next call to continue after this page, empty on the last page
+}
+
+// SearchWindows pages through the windows whose context text matches text (all windows
+// matching the filter when text is empty). Results are sorted by relevance, or by end time
+// when browsing, with the window ID as tie-breaker so the order is stable and cursors can
+// resume exactly after the last window of a page.
+func (c *ElasticsearchClient) SearchWindows(ctx context.Context, text string, filter *SearchFilter, size int, cursor string) (*SearchPage, error) {
+	boolQuery := map[string]interface{}{}
+	if q := filter.query(); q != nil {
+		boolQuery = q["bool"].(map[string]interface{})
+	}
+	sort := []interface{}{
+		map[string]interface{}{"end_time": map[string]interface{}{"order": "desc"}},
+		map[string]interface{}{"window_id": map[string]interface{}{"order": "asc"}},
+	}
+	if text != "" {
+		boolQuery["must"] = []map[string]interface{}{{"match": map[string]interface{}{"context_text": text}}}
+		sort = []interface{}{
+			map[string]interface{}{"_score": map[string]interface{}{"order": "desc"}},
+			map[string]interface{}{"window_id": map[string]interface{}{"order": "asc"}},
+		}
+	}
+
+	body := map[string]interface{}{
+		"size":             size,
+		"track_total_hits": true,
+		"query":            map[string]interface{}{"bool": boolQuery},
+		"sort":             sort,
+		"_source":          map[string]interface{}{"excludes": c.vectorFields()},
+	}
+	if cursor != "" {
+		after, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		body["search_after"] = after
+	}
+
+	result, err := c.client.Search().Index(c.searchIndices()...).Source(body).Do(ctx)
+	if err != nil {
+		return nil, esError(fmt.Errorf("failed to search windows: %w", err))
+	}
+
+	page := &SearchPage{}
+	if result.Hits == nil {
+		return page, nil
+	}
+	if result.Hits.TotalHits != nil {
+		page.Total = result.Hits.TotalHits.Value
+	}
+	for _, hit := range result.Hits.Hits {
+		ew, err := fromDocument(hit.Source)
+		if err != nil {
+			log.Printf("Error unmarshaling embedded window from ES hit: %v", err)
+			continue
+		}
+		score := 0.0
+		if hit.Score != nil {
+			score = *hit.Score
+		}
+		page.Windows = append(page.Windows, ew)
+		page.Scores = append(page.Scores, score)
+	}
+	if hits := result.Hits.Hits; len(hits) == size && size > 0 {
+		page.NextCursor = encodeCursor(hits[len(hits)-1].Sort)
+	}
+	return page, nil
+}
+
+// SearchKeywordWindows returns the k windows whose context text best matches text, ranked by
+// Elasticsearch's BM25 scoring and the filter's recency decay.
+func (c *ElasticsearchClient) SearchKeywordWindows(ctx context.Context, text string, k int, filter *SearchFilter) ([]window.EmbeddedWindow, error) {
+	var recency *RecencyDecay
+	if filter != nil {
+		recency = filter.Recency
+	}
+	page, err := c.SearchWindows(ctx, text, filter, recency.candidates(k), "")
+	if err != nil {
+		return nil, err
+	}
+	hits := make([]scoredWindow, len(page.Windows))
+	for i, ew := range page.Windows {
+		hits[i] = scoredWindow{window: ew, score: page.Scores[i]}
+	}
+	if recency == nil {
+		filter.explain("keyword", hits)
+		return page.Windows, nil
+	}
+	hits = recency.rerank(hits, k)
+	filter.explain("keyword", hits)
+	windows := make([]window.EmbeddedWindow, len(hits))
+	for i, h := range hits {
+		windows[i] = h.window
+	}
+	return windows, nil
+}
+
+// LatestWindows returns the k windows matching the filter that ended last, newest first.
+func (c *ElasticsearchClient) LatestWindows(ctx context.Context, k int, filter *SearchFilter) ([]window.EmbeddedWindow, error) {
+	page, err := c.SearchWindows(ctx, "", filter, k, "")
+	if err != nil {
+		return nil, err
+	}
+	return page.Windows, nil
+}
+
+// Cursors are the sort values of the last hit of a page, opaque to clients.
+func encodeCursor(sortValues []interface{}) string {
+	data, _ := json.Marshal(sortValues)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var after []interface{}
+	if err := json.Unmarshal(data, &after); err != nil || len(after) != 2 {
+		return nil, ErrInvalidCursor
+	}
+	return after, nil
+}
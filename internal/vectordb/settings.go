@@ -0,0 +1,197 @@
+package vectordb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/metrics"
+)
+
+const (
+	defaultReplicas                = 1
+	defaultRefreshInterval         = "1s"
+	defaultBackfillRefreshInterval = "30s"
+	docsPerShard                   = 5_000_000 // Windows per primary shard, keeping shards in the tens of GB
+	maxDerivedShards               = 32
+	indexingRateWindow             = 10 * time.Second // Period the indexing rate is measured over
+)
+
+var refreshRelaxed = metrics.NewGauge("elasticsearch_refresh_relaxed", "1 while the refresh interval of the windows indices is relaxed for a bulk load.")
+
+// indexSettings holds the settings of the indices the agent creates.
+type indexSettings struct {
+	shards   int
+	replicas int
+	refresh  string
+	explicit map[string]interface{} // Dynamic settings set in the config, applied to existing indices
+}
+
+func newIndexSettings(cfg config.IndexSettingsConfig) indexSettings {
+	s := indexSettings{shards: cfg.Shards, replicas: defaultReplicas, refresh: defaultRefreshInterval, explicit: map[string]interface{}{}}
+	if s.shards <= 0 {
+		s.shards = int((cfg.ExpectedDocuments + docsPerShard - 1) / docsPerShard)
+		s.shards = min(max(s.shards, 1), maxDerivedShards)
+	}
+	if cfg.Replicas != nil {
+		s.replicas = *cfg.Replicas
+		s.explicit["number_of_replicas"] = s.replicas
+	}
+	if cfg.RefreshInterval != "" {
+		s.refresh = cfg.RefreshInterval
+		s.explicit["refresh_interval"] = s.refresh
+	}
+	return s
+}
+
+// json returns the "settings" object of a create index request.
+func (s indexSettings) json() string {
+	body, _ := json.Marshal(map[string]interface{}{
+		"number_of_shards":   s.shards,
+		"number_of_replicas": s.replicas,
+		"refresh_interval":   s.refresh,
+	})
+	return string(body)
+}
+
+// applyIndexSettings updates existing indices with the dynamic settings set in the config.
+// Shard counts cannot be changed on existing indices.
+func (c *ElasticsearchClient) applyIndexSettings(indices ...string) error {
+	if len(c.settings.explicit) == 0 {
+		return nil
+	}
+	_, err := c.client.IndexPutSettings(indices...).
+		BodyJson(map[string]interface{}{"index": c.settings.explicit}).
+		Do(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to update settings of indices %v: %w", indices, err)
+	}
+	return nil
+}
+
+// refreshTuner relaxes the refresh interval of the windows indices while they are bulk
+// loaded, either explicitly (re-embedding) or because the indexing rate exceeds the
+// configured threshold (e.g. a consumer catching up), and restores it afterwards.
+type refreshTuner struct {
+	relaxedInterval string
+	threshold       float64 // Windows per second, 0 disables rate detection
+
+	mu          sync.Mutex
+	bulkLoads   int // Explicit bulk loads in progress
+	rateHigh    bool
+	windowStart time.Time
+	indexed     int
+
+	applyMu sync.Mutex // Serializes settings updates
+	relaxed bool       // State last applied to the indices
+}
+
+func newRefreshTuner(cfg config.IndexSettingsConfig) *refreshTuner {
+	t := &refreshTuner{relaxedInterval: cfg.BackfillRefreshInterval, threshold: cfg.BackfillDocsPerSecond, windowStart: time.Now()}
+	if t.relaxedInterval == "" {
+		t.relaxedInterval = defaultBackfillRefreshInterval
+	}
+	return t
+}
+
+// BeginBulkLoad relaxes the refresh interval until the returned function is called. Bulk
+// loads may overlap; the interval is restored when the last one ends.
+func (c *ElasticsearchClient) BeginBulkLoad() func() {
+	t := c.refresh
+	t.mu.Lock()
+	t.bulkLoads++
+	t.mu.Unlock()
+	c.applyRefresh()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			t.bulkLoads--
+			t.mu.Unlock()
+			c.applyRefresh()
+		})
+	}
+}
+
+// observeIndexing counts an indexed window and, once per measuring period, compares the
+// indexing rate with the threshold. Refresh stays relaxed until the rate halves.
+func (c *ElasticsearchClient) observeIndexing() {
+	t := c.refresh
+	if t.threshold <= 0 {
+		return
+	}
+	t.mu.Lock()
+	t.indexed++
+	elapsed := time.Since(t.windowStart)
+	if elapsed < indexingRateWindow {
+		t.mu.Unlock()
+		return
+	}
+	rate := float64(t.indexed) / elapsed.Seconds()
+	wasHigh := t.rateHigh
+	t.rateHigh = rate > t.threshold || (wasHigh && rate > t.threshold/2)
+	t.indexed = 0
+	t.windowStart = time.Now()
+	high := t.rateHigh
+	t.mu.Unlock()
+
+	if high == wasHigh {
+		return
+	}
+	if high {
+		log.Printf("Indexing rate of %.1f windows/s exceeds %.1f, treating it as a bulk load", rate, t.threshold)
+	} else {
+		log.Printf("Indexing rate back to %.1f windows/s", rate)
+	}
+	go c.applyRefresh()
+}
+
+// applyRefresh brings the refresh interval of the windows indices in line with the current
+// bulk load state. Concurrent calls converge on the latest state.
+func (c *ElasticsearchClient) applyRefresh() {
+	t := c.refresh
+	t.applyMu.Lock()
+	defer t.applyMu.Unlock()
+
+	t.mu.Lock()
+	want := t.bulkLoads > 0 || t.rateHigh
+	t.mu.Unlock()
+	if want == t.relaxed {
+		return
+	}
+
+	interval := c.settings.refresh
+	if want {
+		interval = t.relaxedInterval
+	}
+	indices, err := c.windowIndices()
+	if err != nil {
+		log.Printf("Error setting refresh interval: %v", err)
+		return
+	}
+	ctx := context.Background()
+	_, err = c.client.IndexPutSettings(indices...).
+		BodyJson(map[string]interface{}{"index": map[string]interface{}{"refresh_interval": interval}}).
+		Do(ctx)
+	if err != nil {
+		log.Printf("Error setting refresh interval of %v to %s: %v", indices, interval, err)
+		return
+	}
+	t.relaxed = want
+	if want {
+		refreshRelaxed.Set(1)
+		log.Printf("Relaxed refresh interval of %v to %s for a bulk load", indices, interval)
+		return
+	}
+	refreshRelaxed.Set(0)
+	// Make the bulk loaded windows searchable right away
+	if _, err := c.client.Refresh(indices...).Do(ctx); err != nil {
+		log.Printf("Error refreshing %v after a bulk load: %v", indices, err)
+	}
+	log.Printf("Restored refresh interval of %v to %s", indices, interval)
+}
@@ -0,0 +1,171 @@
+package vectordb
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"stream-rag-agent/pkg/errs"
+)
+
+// SnapshotInfo is a trimmed view of an Elasticsearch snapshot of the windows index.
+type SnapshotInfo struct {
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	Indices   []string  `json:"indices"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+}
+
+func (c *ElasticsearchClient) ensureSnapshotRepository() error {
+	if c.snapshotCfg.Repository == "" || c.snapshotCfg.Type == "" {
+		return nil
+	}
+
+	settings := map[string]interface{}{}
+	if c.snapshotCfg.Location != "" {
+		settings["location"] = c.snapshotCfg.Location
+	}
+	_, err := c.client.SnapshotCreateRepository(c.snapshotCfg.Repository).
+		Type(c.snapshotCfg.Type).
+		Settings(settings).
+		Do(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to register snapshot repository '%s': %w", c.snapshotCfg.Repository, err)
+	}
+	log.Printf("Elasticsearch snapshot repository '%s' registered.", c.snapshotCfg.Repository)
+	return nil
+}
+
+func (c *ElasticsearchClient) snapshotRepository() (string, error) {
+	if c.snapshotCfg.Repository == "" {
+		return "", fmt.Errorf("no snapshot repository configured")
+	}
+	return c.snapshotCfg.Repository, nil
+}
+
+// SnapshotName returns the name of a new snapshot: name, or one generated from the index name
+// and the current time if empty.
+func (c *ElasticsearchClient) SnapshotName(name string) string {
+	if name == "" {
+		name = fmt.Sprintf("%s-%s", c.indexName, time.Now().UTC().Format("20060102-150405"))
+	}
+	return name
+}
+
+// CreateSnapshot snapshots the windows index (and category indices, if routed) into the
+// configured repository and waits for completion, which takes as long as copying the new
+// segments: run it in the background.
+// An empty name generates one, see SnapshotName.
+func (c *ElasticsearchClient) CreateSnapshot(name string) (*SnapshotInfo, error) {
+	repo, err := c.snapshotRepository()
+	if err != nil {
+		return nil, err
+	}
+	name = c.SnapshotName(name)
+
+	resp, err := c.client.SnapshotCreate(repo, name).
+		BodyJson(map[string]interface{}{
+			"indices":              strings.Join(c.searchIndices(), ","),
+			"include_global_state": false,
+		}).
+		WaitForCompletion(true).
+		Do(context.Background())
+	if err != nil {
+		return nil, esError(fmt.Errorf("failed to create snapshot '%s': %w", name, err))
+	}
+	if resp.Snapshot == nil {
+		return &SnapshotInfo{Name: name, State: "IN_PROGRESS"}, nil
+	}
+	log.Printf("Created snapshot '%s' of index '%s' in repository '%s' (state: %s).", name, c.indexName, repo, resp.Snapshot.State)
+	return &SnapshotInfo{
+		Name:      resp.Snapshot.Snapshot,
+		State:     resp.Snapshot.State,
+		Indices:   resp.Snapshot.Indices,
+		StartTime: resp.Snapshot.StartTime,
+		EndTime:   resp.Snapshot.EndTime,
+	}, nil
+}
+
+// ListSnapshots returns all snapshots in the configured repository.
+func (c *ElasticsearchClient) ListSnapshots() ([]SnapshotInfo, error) {
+	repo, err := c.snapshotRepository()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.SnapshotGet(repo).Snapshot("_all").Do(context.Background())
+	if err != nil {
+		return nil, esError(fmt.Errorf("failed to list snapshots in repository '%s': %w", repo, err))
+	}
+
+	snapshots := make([]SnapshotInfo, 0, len(resp.Snapshots))
+	for _, s := range resp.Snapshots {
+		snapshots = append(snapshots, SnapshotInfo{
+			Name:      s.Snapshot,
+			State:     s.State,
+			Indices:   s.Indices,
+			StartTime: s.StartTime,
+			EndTime:   s.EndTime,
+		})
+	}
+	return snapshots, nil
+}
+
+// RestoreSnapshot restores the windows indices from the named snapshot and waits for the
+// restore to complete: run it in the background. The live indices are closed first because
+// Elasticsearch cannot restore over an open index; the restore reopens them. Windows saved in
+// the meantime fail and go to the outbox, so pause ingestion for the restore.
+func (c *ElasticsearchClient) RestoreSnapshot(name string) error {
+	repo, err := c.snapshotRepository()
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return errs.New(errs.ErrInvalidRequest, "snapshot name is required")
+	}
+
+	ctx := context.Background()
+	existing, err := c.windowIndices()
+	if err != nil {
+		return err
+	}
+	var closed []string
+	for _, index := range existing {
+		exists, err := c.client.IndexExists(index).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to check if index exists: %w", err)
+		}
+		if !exists {
+			continue
+		}
+		if _, err := c.client.CloseIndex(index).Do(ctx); err != nil {
+			c.reopenIndices(closed)
+			return fmt.Errorf("failed to close index '%s' before restore: %w", index, err)
+		}
+		closed = append(closed, index)
+	}
+
+	_, err = c.client.SnapshotRestore(repo, name).
+		Indices(c.searchIndices()...).
+		IncludeGlobalState(false).
+		WaitForCompletion(true).
+		Do(ctx)
+	if err != nil {
+		c.reopenIndices(closed)
+		return esError(fmt.Errorf("failed to restore snapshot '%s': %w", name, err))
+	}
+	log.Printf("Restored index '%s' from snapshot '%s' in repository '%s'.", c.indexName, name, repo)
+	return nil
+}
+
+// reopenIndices reopens indices closed for a restore that failed.
+func (c *ElasticsearchClient) reopenIndices(indices []string) {
+	for _, index := range indices {
+		if _, err := c.client.OpenIndex(index).Do(context.Background()); err != nil {
+			log.Printf("Error reopening index '%s' after failed restore: %v", index, err)
+		}
+	}
+}
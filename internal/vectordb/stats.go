@@ -0,0 +1,243 @@
+package vectordb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+const driftSampleSize = 200 // Windows sampled per topic and period for centroid drift
+
+// TopicStats describes the windows indexed for one topic.
+type TopicStats struct {
+	Topic        string       `json:"topic"`
+	Documents    int64        `json:"documents"`
+	OldestWindow *time.Time   `json:"oldest_window,omitempty"` // Earliest start_time
+	NewestWindow *time.Time   `json:"newest_window,omitempty"` // Latest end_time
+	Drift        []DriftPoint `json:"drift,omitempty"`
+}
+
+// DriftPoint is the embedding centroid movement of a topic in one period.
+type DriftPoint struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Windows  int       `json:"windows"`            // Windows sampled for the centroid
+	Distance *float64  `json:"distance,omitempty"` // Cosine distance from the previous period's centroid
+}
+
+type IndexStats struct {
+	Index          string           `json:"index"`
+	Documents      int64            `json:"documents"`
+	DeletedDocs    int64            `json:"deleted_documents"`
+	StoreSizeBytes int64            `json:"store_size_bytes"`
+	EmbeddingDims  []int            `json:"embedding_dims"` // Configured vector fields
+	DocsByDims     map[string]int64 `json:"documents_by_dims"`
+	DocsByModel    map[string]int64 `json:"documents_by_model"`
+	DocsByCategory map[string]int64 `json:"documents_by_category,omitempty"` // Categorized windows, see categories
+	Indices        []string         `json:"indices,omitempty"`               // Category indices included in the counts, if routed
+	Topics         []TopicStats     `json:"topics"`
+}
+
+// IndexStats gathers document counts, storage size, vector dimensions and the time span of
+// the windows index, per topic and category. With category indices, they are summed over
+// the main and all category indices.
+func (c *ElasticsearchClient) IndexStats() (*IndexStats, error) {
+	ctx := context.Background()
+	stats := &IndexStats{
+		Index:         c.indexName,
+		EmbeddingDims: c.embeddingDims,
+		DocsByDims:    map[string]int64{},
+		DocsByModel:   map[string]int64{},
+	}
+
+	indices, err := c.windowIndices()
+	if err != nil {
+		return nil, err
+	}
+	if len(indices) > 1 {
+		stats.Indices = indices[1:]
+	}
+	indexStats, err := c.client.IndexStats(indices...).Do(ctx)
+	if err != nil {
+		return nil, esError(fmt.Errorf("failed to get stats of index '%s': %w", c.indexName, err))
+	}
+	for _, name := range indices {
+		s, ok := indexStats.Indices[name]
+		if !ok {
+			continue
+		}
+		if s.Primaries != nil && s.Primaries.Docs != nil {
+			stats.Documents += s.Primaries.Docs.Count
+			stats.DeletedDocs += s.Primaries.Docs.Deleted
+		}
+		if s.Total != nil && s.Total.Store != nil {
+			stats.StoreSizeBytes += s.Total.Store.SizeInBytes
+		}
+	}
+
+	body := map[string]interface{}{
+		"size": 0,
+		"aggs": map[string]interface{}{
+			"topics": map[string]interface{}{
+				"terms": map[string]interface{}{"field": "topic", "size": 1000},
+				"aggs": map[string]interface{}{
+					"oldest": map[string]interface{}{"min": map[string]interface{}{"field": "start_time"}},
+					"newest": map[string]interface{}{"max": map[string]interface{}{"field": "end_time"}},
+				},
+			},
+			"dims":       map[string]interface{}{"terms": map[string]interface{}{"field": "embedding_dims", "size": 20}},
+			"models":     map[string]interface{}{"terms": map[string]interface{}{"field": "embedding_model", "size": 20}},
+			"categories": map[string]interface{}{"terms": map[string]interface{}{"field": "category", "size": 100}},
+		},
+	}
+	result, err := c.client.Search().Index(c.searchIndices()...).Source(body).Do(ctx)
+	if err != nil {
+		return nil, esError(fmt.Errorf("failed to aggregate index stats: %w", err))
+	}
+
+	type bucket struct {
+		Key      interface{} `json:"key"`
+		DocCount int64       `json:"doc_count"`
+		Oldest   struct {
+			Value *float64 `json:"value"`
+		} `json:"oldest"`
+		Newest struct {
+			Value *float64 `json:"value"`
+		} `json:"newest"`
+	}
+	buckets := func(name string) ([]bucket, error) {
+		var agg struct {
+			Buckets []bucket `json:"buckets"`
+		}
+		if raw, ok := result.Aggregations[name]; ok {
+			if err := json.Unmarshal(raw, &agg); err != nil {
+				return nil, fmt.Errorf("failed to decode %s aggregation: %w", name, err)
+			}
+		}
+		return agg.Buckets, nil
+	}
+
+	topics, err := buckets("topics")
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range topics {
+		ts := TopicStats{Topic: fmt.Sprintf("%v", b.Key), Documents: b.DocCount}
+		ts.OldestWindow = epochMillis(b.Oldest.Value)
+		ts.NewestWindow = epochMillis(b.Newest.Value)
+		stats.Topics = append(stats.Topics, ts)
+	}
+	dims, err := buckets("dims")
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range dims {
+		stats.DocsByDims[fmt.Sprintf("%v", b.Key)] = b.DocCount
+	}
+	models, err := buckets("models")
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range models {
+		stats.DocsByModel[fmt.Sprintf("%v", b.Key)] = b.DocCount
+	}
+	categories, err := buckets("categories")
+	if err != nil {
+		return nil, err
+	}
+	if len(categories) > 0 {
+		stats.DocsByCategory = make(map[string]int64, len(categories))
+		for _, b := range categories {
+			stats.DocsByCategory[fmt.Sprintf("%v", b.Key)] = b.DocCount
+		}
+	}
+	return stats, nil
+}
+
+func epochMillis(v *float64) *time.Time {
+	if v == nil {
+		return nil
+	}
+	t := time.UnixMilli(int64(*v)).UTC()
+	return &t
+}
+
+// CentroidDrift samples the embeddings of a topic's windows in consecutive periods ending
+// now and reports how far each period's centroid moved from the previous one. A growing
+// distance indicates a shift in the stream's content or in the embedding model.
+func (c *ElasticsearchClient) CentroidDrift(topic string, periods int, interval time.Duration) ([]DriftPoint, error) {
+	ctx := context.Background()
+	end := time.Now().UTC()
+	start := end.Add(-time.Duration(periods) * interval)
+
+	points := make([]DriftPoint, 0, periods)
+	var previous []float64
+	for from := start; from.Before(end); from = from.Add(interval) {
+		point := DriftPoint{From: from, To: from.Add(interval)}
+		filter := &SearchFilter{Topics: []string{topic}, From: point.From, To: point.To}
+		body := map[string]interface{}{"size": driftSampleSize, "query": filter.query()}
+		result, err := c.client.Search().Index(c.searchIndices()...).Source(body).Do(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sample embeddings of topic %s: %w", topic, err)
+		}
+
+		var vectors [][]float32
+		for _, hit := range result.Hits.Hits {
+			ew, err := fromDocument(hit.Source)
+			if err != nil || len(ew.Embedding) == 0 {
+				continue
+			}
+			vectors = append(vectors, ew.Embedding)
+		}
+		point.Windows = len(vectors)
+
+		centroid := centroidOf(vectors)
+		if centroid != nil && previous != nil && len(centroid) == len(previous) {
+			d := 1 - cosineSimilarity(centroid, previous)
+			point.Distance = &d
+		}
+		if centroid != nil {
+			previous = centroid
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+// centroidOf averages vectors of the first vector's dimension, ignoring others (windows
+// embedded by a different model).
+func centroidOf(vectors [][]float32) []float64 {
+	if len(vectors) == 0 {
+		return nil
+	}
+	centroid := make([]float64, len(vectors[0]))
+	n := 0
+	for _, v := range vectors {
+		if len(v) != len(centroid) {
+			continue
+		}
+		for i, x := range v {
+			centroid[i] += float64(x)
+		}
+		n++
+	}
+	for i := range centroid {
+		centroid[i] /= float64(n)
+	}
+	return centroid
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
@@ -0,0 +1,21 @@
+package vectordb
+
+import (
+	"context"
+
+	"stream-rag-agent/internal/window"
+)
+
+// WindowStore is the storage needed to ingest windows and answer questions from them. It is
+// implemented by ElasticsearchClient and, for local development, by LocalStore. Features
+// such as structured aggregations, snapshots and analytics need Elasticsearch.
+type WindowStore interface {
+	SaveEmbeddedWindow(ew *window.EmbeddedWindow) error
+	SaveEvents(events []StructuredEvent) error
+	SearchSimilarWindows(ctx context.Context, queryEmbedding []float32, k int, filter *SearchFilter) ([]window.EmbeddedWindow, error)
+	// SearchKeywordWindows ranks windows by BM25 relevance of their context text to text,
+	// for answering questions while the embedding service is unavailable.
+	SearchKeywordWindows(ctx context.Context, text string, k int, filter *SearchFilter) ([]window.EmbeddedWindow, error)
+	// LatestWindows returns the k windows matching the filter that ended last, newest first.
+	LatestWindows(ctx context.Context, k int, filter *SearchFilter) ([]window.EmbeddedWindow, error)
+}
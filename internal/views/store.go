@@ -0,0 +1,215 @@
+package views
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"stream-rag-agent/internal/atrest"
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/vectordb"
+	"stream-rag-agent/pkg/errs"
+)
+
+var ErrViewNotFound = errs.New(errs.ErrNotFound, "view not found")
+
+// View is a named, reusable retrieval scope: a topic subset, a time policy, entity, category,
+// message header and embedding model filters.
+type View struct {
+	Name            string            `json:"name"`
+	Topics          []string          `json:"topics,omitempty"`
+	LastSeconds     int               `json:"last_seconds,omitempty"` // Relative time policy: windows from the last N seconds
+	From            time.Time         `json:"from,omitempty"`         // Absolute time policy, used when LastSeconds is 0
+	To              time.Time         `json:"to,omitempty"`
+	Entities        []string          `json:"entities,omitempty"`         // Values the window context must mention, e.g. "ACC-0007"
+	Categories      []string          `json:"categories,omitempty"`       // Window categories, e.g. "fraud"
+	Headers         map[string]string `json:"headers,omitempty"`          // Message header values, e.g. {"tenant": "acme"}
+	EmbeddingModels []string          `json:"embedding_models,omitempty"` // Embedding models, e.g. ["nomic-embed-text"] during a re-embedding campaign
+	Workspace       string            `json:"workspace,omitempty"`        // Workspace the view belongs to, empty for views shared by all
+	Source          string            `json:"source,omitempty"`           // "config" or "api"
+}
+
+// viewKey identifies a view: views of different workspaces may share a name.
+type viewKey struct {
+	workspace string
+	name      string
+}
+
+// Filter translates the view into a search filter evaluated at the given time.
+func (v *View) Filter(now time.Time) *vectordb.SearchFilter {
+	f := &vectordb.SearchFilter{
+		Topics:          v.Topics,
+		From:            v.From,
+		To:              v.To,
+		Entities:        v.Entities,
+		Categories:      v.Categories,
+		Headers:         v.Headers,
+		EmbeddingModels: v.EmbeddingModels,
+	}
+	if v.LastSeconds > 0 {
+		f.From = now.Add(-time.Duration(v.LastSeconds) * time.Second)
+		f.To = time.Time{}
+	}
+	return f
+}
+
+// Store keeps views defined in the config file plus views created through the API,
+// the latter persisted to a JSON file so they survive restarts. Views created in a workspace
+// are only seen in it; the others are shared by all workspaces.
+type Store struct {
+	mu    sync.RWMutex
+	views map[viewKey]*View
+	path  string
+}
+
+func NewStore(cfg config.ViewsConfig) (*Store, error) {
+	s := &Store{views: make(map[viewKey]*View), path: cfg.File}
+
+	if s.path != "" {
+		data, err := atrest.ReadFile(s.path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read views file: %w", err)
+		}
+		if len(data) > 0 {
+			var saved []*View
+			if err := json.Unmarshal(data, &saved); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal views file: %w", err)
+			}
+			for _, v := range saved {
+				v.Source = "api"
+				s.views[viewKey{v.Workspace, v.Name}] = v
+			}
+		}
+	}
+
+	// Views from the config file take precedence over saved ones with the same name
+	for i := range cfg.Definitions {
+		v := toView(cfg.Definitions[i])
+		v.Source = "config"
+		s.views[viewKey{name: v.Name}] = v
+	}
+	return s, nil
+}
+
+func toView(d config.ViewDefinition) *View {
+	return &View{
+		Name:            d.Name,
+		Topics:          d.Topics,
+		LastSeconds:     d.LastSeconds,
+		From:            d.From,
+		To:              d.To,
+		Entities:        d.Entities,
+		Categories:      d.Categories,
+		Headers:         d.Headers,
+		EmbeddingModels: d.EmbeddingModels,
+	}
+}
+
+// Get returns the view of a workspace, or the shared view, with the given name. An empty
+// workspace only sees shared views.
+func (s *Store) Get(workspace, name string) (*View, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if v, ok := s.views[viewKey{workspace, name}]; ok {
+		return v, nil
+	}
+	if v, ok := s.views[viewKey{name: name}]; ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrViewNotFound, name)
+}
+
+// List returns the views of a workspace and the shared views it does not shadow.
+func (s *Store) List(workspace string) []*View {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]*View, 0, len(s.views))
+	for k, v := range s.views {
+		switch {
+		case k.workspace == workspace:
+		case k.workspace == "" && s.views[viewKey{workspace, k.name}] == nil:
+		default:
+			continue
+		}
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Put creates or replaces an API-managed view of v.Workspace. Views defined in the config file
+// cannot be overwritten, nor shadowed in a workspace.
+func (s *Store) Put(v *View) error {
+	if v.Name == "" {
+		return errs.New(errs.ErrInvalidRequest, "view name is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.views[viewKey{name: v.Name}]; ok && existing.Source == "config" {
+		return errs.Errorf(errs.ErrInvalidRequest, "view '%s' is defined in the config file and cannot be modified", v.Name)
+	}
+	v.Source = "api"
+	s.views[viewKey{v.Workspace, v.Name}] = v
+	return s.saveLocked()
+}
+
+// Delete removes an API-managed view of a workspace.
+func (s *Store) Delete(workspace, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, ok := s.views[viewKey{workspace, name}]
+	if !ok {
+		if _, shared := s.views[viewKey{name: name}]; shared && workspace != "" {
+			return errs.Errorf(errs.ErrInvalidRequest, "view '%s' is shared by all workspaces and cannot be deleted from workspace '%s'", name, workspace)
+		}
+		return fmt.Errorf("%w: %s", ErrViewNotFound, name)
+	}
+	if existing.Source == "config" {
+		return errs.Errorf(errs.ErrInvalidRequest, "view '%s' is defined in the config file and cannot be deleted", name)
+	}
+	delete(s.views, viewKey{workspace, name})
+	return s.saveLocked()
+}
+
+// DeleteWorkspace removes the views of a workspace and returns how many there were.
+func (s *Store) DeleteWorkspace(workspace string) (int, error) {
+	if workspace == "" {
+		return 0, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for k := range s.views {
+		if k.workspace == workspace {
+			delete(s.views, k)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.saveLocked()
+}
+
+func (s *Store) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	saved := make([]*View, 0, len(s.views))
+	for _, v := range s.views {
+		if v.Source == "api" {
+			saved = append(saved, v)
+		}
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal views: %w", err)
+	}
+	if err := atrest.WriteFile(s.path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write views file: %w", err)
+	}
+	return nil
+}
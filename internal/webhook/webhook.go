@@ -0,0 +1,169 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/metrics"
+	"stream-rag-agent/internal/window"
+)
+
+const (
+	defaultTimeout = 5 * time.Second
+
+	OnErrorAccept = "accept" // Index the window unchanged when the webhook fails (default)
+	OnErrorDrop   = "drop"   // Drop the window when the webhook fails
+
+	ActionAccept = "accept"
+	ActionVeto   = "veto"
+)
+
+var decisionsTotal = metrics.NewCounter("webhook_decisions_total", "Closed windows reviewed by a processor webhook, by topic and decision.")
+
+// Request is the body POSTed to the webhook for every closed window.
+type Request struct {
+	WindowID     string    `json:"window_id"`
+	Topic        string    `json:"topic"`
+	Partition    int32     `json:"partition"`
+	StartTime    time.Time `json:"start_time"`
+	EndTime      time.Time `json:"end_time"`
+	MessageCount int       `json:"message_count"`
+	ContextText  string    `json:"context_text"` // Rendered text that will be embedded
+	Messages     []Message `json:"messages"`
+}
+
+type Message struct {
+	Offset    int64           `json:"offset"`
+	Key       string          `json:"key,omitempty"`
+	Value     json.RawMessage `json:"value"` // The payload itself if it is JSON, otherwise a JSON string
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// Response is the webhook's decision. An empty 2xx body accepts the window unchanged.
+type Response struct {
+	Action      string            `json:"action"`                 // "accept" (default) or "veto"
+	Reason      string            `json:"reason,omitempty"`       // Logged when the window is vetoed
+	ContextText string            `json:"context_text,omitempty"` // Replaces the rendered text before embedding
+	Annotations map[string]string `json:"annotations,omitempty"`  // Appended to the text and stored with the window
+}
+
+// Client sends closed windows of one topic to its processor webhook.
+type Client struct {
+	url        string
+	onError    string
+	httpClient *http.Client
+}
+
+// New returns nil when the topic has no webhook configured.
+func New(cfg config.WebhookConfig) *Client {
+	if cfg.URL == "" {
+		return nil
+	}
+	timeout := time.Duration(cfg.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	onError := cfg.OnError
+	if onError == "" {
+		onError = OnErrorAccept
+	}
+	return &Client{url: cfg.URL, onError: onError, httpClient: &http.Client{Timeout: timeout}}
+}
+
+// Review posts the window to the webhook and returns its decision. When the call fails, the
+// decision follows the on_error policy and the error is returned for logging.
+func (c *Client) Review(w *window.Window, contextText string) (Response, error) {
+	resp, err := c.call(w, contextText)
+	if err != nil {
+		if c.onError == OnErrorDrop {
+			resp = Response{Action: ActionVeto, Reason: "webhook failed"}
+		} else {
+			resp = Response{Action: ActionAccept}
+		}
+	}
+	decisionsTotal.Inc("topic", w.Topic, "decision", resp.Action)
+	return resp, err
+}
+
+func (c *Client) call(w *window.Window, contextText string) (Response, error) {
+	req := Request{
+		WindowID:     w.ID,
+		Topic:        w.Topic,
+		Partition:    w.Partition,
+		StartTime:    w.StartTime,
+		EndTime:      w.EndTime,
+		MessageCount: w.MessageCount,
+		ContextText:  contextText,
+		Messages:     make([]Message, 0, len(w.Messages)),
+	}
+	for _, msg := range w.Messages {
+		value := json.RawMessage(msg.Value)
+		if !json.Valid(msg.Value) {
+			value, _ = json.Marshal(string(msg.Value))
+		}
+		req.Messages = append(req.Messages, Message{Offset: msg.Offset, Key: string(msg.Key), Value: value, Timestamp: msg.Timestamp})
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return Response{}, fmt.Errorf("failed to marshal webhook request: %w", err)
+	}
+	httpResp, err := c.httpClient.Post(c.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return Response{}, fmt.Errorf("failed to call processor webhook: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	respBody, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return Response{}, fmt.Errorf("failed to read processor webhook response: %w", err)
+	}
+	if httpResp.StatusCode < 200 || httpResp.StatusCode >= 300 {
+		return Response{}, fmt.Errorf("processor webhook returned non-OK status: %d, body: %s", httpResp.StatusCode, string(respBody))
+	}
+
+	var resp Response
+	if len(bytes.TrimSpace(respBody)) > 0 {
+		if err := json.Unmarshal(respBody, &resp); err != nil {
+			return Response{}, fmt.Errorf("failed to decode processor webhook response: %w", err)
+		}
+	}
+	switch resp.Action {
+	case "":
+		resp.Action = ActionAccept
+	case ActionAccept, ActionVeto:
+	default:
+		return Response{}, fmt.Errorf("processor webhook returned unknown action '%s'", resp.Action)
+	}
+	return resp, nil
+}
+
+// Apply returns the text to embed after the webhook's enrichment: the replacement text if
+// one was returned, followed by the annotations.
+func (r Response) Apply(contextText string) string {
+	if r.ContextText != "" {
+		contextText = r.ContextText
+	}
+	if len(r.Annotations) == 0 {
+		return contextText
+	}
+	keys := make([]string, 0, len(r.Annotations))
+	for k := range r.Annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString(strings.TrimRight(contextText, "\n"))
+	sb.WriteString("\nAnnotations:\n")
+	for _, k := range keys {
+		sb.WriteString(fmt.Sprintf("  - %s: %s\n", k, r.Annotations[k]))
+	}
+	return sb.String()
+}
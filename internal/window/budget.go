@@ -0,0 +1,131 @@
+package window
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"stream-rag-agent/internal/metrics"
+)
+
+const budgetCheckInterval = 500 * time.Millisecond // Minimum time between two enforcement passes
+
+var (
+	bufferedBytesGauge = metrics.NewGauge("window_buffered_bytes", "Bytes of message data buffered in open windows and closed windows awaiting processing.")
+	budgetBytesGauge   = metrics.NewGauge("window_memory_budget_bytes", "Configured memory budget for buffered window messages.")
+	earlyClosesTotal   = metrics.NewCounter("window_budget_early_closes_total", "Windows closed early because the memory budget was exceeded.")
+)
+
+// MemoryBudget bounds the message bytes buffered across the windows of all attached managers.
+// When the budget is exceeded, the largest (then oldest) open windows are closed early so
+// their messages are handed to processing instead of accumulating further.
+type MemoryBudget struct {
+	limit    int64
+	mu       sync.Mutex
+	used     int64
+	managers []*Manager
+	pressure chan struct{}
+}
+
+// NewMemoryBudget returns nil when no budget is configured.
+func NewMemoryBudget(limitBytes int64) *MemoryBudget {
+	if limitBytes <= 0 {
+		return nil
+	}
+	budgetBytesGauge.Set(float64(limitBytes))
+	return &MemoryBudget{limit: limitBytes, pressure: make(chan struct{}, 1)}
+}
+
+// Attach makes the manager account its buffered messages against the budget. It must be
+// called before the manager is started.
+func (b *MemoryBudget) Attach(m *Manager) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.managers = append(b.managers, m)
+	b.mu.Unlock()
+	m.budget = b
+}
+
+// add records a change in buffered bytes and signals Run when the budget is exceeded.
+// It is called with a window's slot lock held, so enforcement happens asynchronously.
+func (b *MemoryBudget) add(delta int64) {
+	if b == nil || delta == 0 {
+		return
+	}
+	b.mu.Lock()
+	b.used += delta
+	used := b.used
+	b.mu.Unlock()
+	bufferedBytesGauge.Set(float64(used))
+
+	if used > b.limit {
+		select {
+		case b.pressure <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run enforces the budget until the context is cancelled.
+func (b *MemoryBudget) Run(ctx context.Context) {
+	if b == nil {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.pressure:
+			b.enforce()
+			// Closed windows only release their memory once processed; give them time
+			// before closing more
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(budgetCheckInterval):
+			}
+		}
+	}
+}
+
+type openWindow struct {
+	manager *Manager
+	window  *Window
+	bytes   int64
+}
+
+func (b *MemoryBudget) enforce() {
+	b.mu.Lock()
+	excess := b.used - b.limit
+	managers := append([]*Manager(nil), b.managers...)
+	b.mu.Unlock()
+	if excess <= 0 {
+		return
+	}
+
+	var candidates []openWindow
+	for _, m := range managers {
+		candidates = append(candidates, m.openWindows()...)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].bytes != candidates[j].bytes {
+			return candidates[i].bytes > candidates[j].bytes
+		}
+		return candidates[i].window.StartTime.Before(candidates[j].window.StartTime)
+	})
+
+	for _, c := range candidates {
+		if excess <= 0 {
+			break
+		}
+		if c.manager.closeEarly(c.window) {
+			log.Printf("Memory budget exceeded by %d bytes: closed window %s (%d bytes) early.", excess, c.window.ID, c.bytes)
+			earlyClosesTotal.Inc("topic", c.window.Topic)
+			excess -= c.bytes
+		}
+	}
+}
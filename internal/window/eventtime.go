@@ -0,0 +1,180 @@
+package window
+
+import (
+	"log"
+	"time"
+
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/metrics"
+)
+
+const (
+	WindowTimeProcessing = "processing" // Windows open and close on the agent's clock (default)
+	WindowTimeEvent      = "event"      // Windows cover ranges of message timestamps and close once the watermark passes them
+)
+
+const (
+	LateDataDrop = "drop" // Messages of closed event-time windows are dropped, and only counted (default)
+	LateDataKeep = "keep" // They are added to the oldest open window of their key and marked as late
+)
+
+var (
+	lateMessagesTotal = metrics.NewCounter("window_late_messages_total", "Messages that arrived after the watermark passed their event-time window, by topic and action (drop, keep).")
+	watermarkLag      = metrics.NewGauge("window_watermark_lag_seconds", "How far the event-time watermark of a window key trails the agent's clock, by topic and key.")
+)
+
+// eventTime holds the event-time settings of a manager whose windows cover fixed ranges of
+// message timestamps. Each window key (partition or key shard) tracks its own watermark: the
+// newest timestamp it has seen less the allowed lateness. A window closes once the watermark
+// passes its end, so messages arriving out of order by up to the allowed lateness still land
+// in the window of their timestamp.
+type eventTime struct {
+	duration time.Duration // Length of the timestamp range of a window
+	lateness time.Duration // How far timestamps may trail the newest one
+	keepLate bool          // Add late messages to the oldest open window instead of dropping them
+}
+
+// newEventTime returns the event-time settings of the topic, or nil for processing-time
+// windows, which an invalid event-time configuration falls back to.
+func newEventTime(cfg config.KafkaTopicConfig, slide time.Duration) *eventTime {
+	switch cfg.WindowTime {
+	case "", WindowTimeProcessing:
+		return nil
+	case WindowTimeEvent:
+	default:
+		log.Printf("Warning: Unknown window_time '%s' of topic %s (expected %s or %s), using processing time", cfg.WindowTime, cfg.Name, WindowTimeProcessing, WindowTimeEvent)
+		return nil
+	}
+	if cfg.WindowDurationSeconds <= 0 {
+		log.Printf("Warning: Event-time windows of topic %s need a window_duration_seconds, using processing time", cfg.Name)
+		return nil
+	}
+	if slide > 0 {
+		log.Printf("Warning: Event-time windows of topic %s are tumbling, but window_type is %s; using processing time", cfg.Name, WindowTypeSliding)
+		return nil
+	}
+	keepLate := false
+	switch cfg.LateData {
+	case "", LateDataDrop:
+	case LateDataKeep:
+		keepLate = true
+	default:
+		log.Printf("Warning: Unknown late_data '%s' of topic %s (expected %s or %s), dropping late messages", cfg.LateData, cfg.Name, LateDataDrop, LateDataKeep)
+	}
+	return &eventTime{
+		duration: time.Duration(cfg.WindowDurationSeconds) * time.Second,
+		lateness: time.Duration(max(cfg.AllowedLatenessSeconds, 0)) * time.Second,
+		keepLate: keepLate,
+	}
+}
+
+// rangeStart returns the start of the timestamp range holding t.
+func (e *eventTime) rangeStart(t time.Time) time.Time {
+	return t.Truncate(e.duration)
+}
+
+// eventWindow returns the open window of the slot covering the message's timestamp, opening
+// it if needed, or nil if the message is late and dropped. Callers hold s.mu.
+func (m *Manager) eventWindow(s *slot, msg RawKafkaMessage) *Window {
+	s.lastArrival = time.Now()
+	start := m.eventTime.rangeStart(msg.Timestamp)
+	if !s.maxEventTime.IsZero() && !start.Add(m.eventTime.duration).After(s.watermark(m.eventTime)) {
+		return m.late(s, msg)
+	}
+
+	for _, w := range s.openWindows() {
+		if w.StartTime.Equal(start) {
+			return w
+		}
+	}
+	w := m.newWindow(s, s.window.Key, s.window.Topic, s.window.Partition, s.window.Shard, start, len(s.window.Messages))
+	if start.After(s.window.StartTime) {
+		s.older = append(s.older, s.window)
+		s.window = w
+		return w
+	}
+	// A range between the open ones, or before them; older stays sorted by start
+	i := len(s.older)
+	for i > 0 && s.older[i-1].StartTime.After(start) {
+		i--
+	}
+	s.older = append(s.older, nil)
+	copy(s.older[i+1:], s.older[i:])
+	s.older[i] = w
+	return w
+}
+
+// late handles a message whose window the watermark already passed: it is added to the oldest
+// open window of the slot, or dropped while still counting towards that window's offset range,
+// so sources acknowledging messages by offset range settle it. Callers hold s.mu.
+func (m *Manager) late(s *slot, msg RawKafkaMessage) *Window {
+	oldest := s.window
+	if len(s.older) > 0 {
+		oldest = s.older[0]
+	}
+	if m.eventTime.keepLate {
+		lateMessagesTotal.Inc("topic", m.config.Name, "action", LateDataKeep)
+		oldest.LateMessages++
+		return oldest
+	}
+	lateMessagesTotal.Inc("topic", m.config.Name, "action", LateDataDrop)
+	oldest.trackOffset(msg.Offset)
+	return nil
+}
+
+// watermark returns the time up to which the slot's windows are complete.
+func (s *slot) watermark(e *eventTime) time.Time {
+	return s.maxEventTime.Add(-e.lateness)
+}
+
+// advanceWatermark moves the slot's watermark to a newer timestamp and closes the windows it
+// passed. The newest window is never among them: it covers the newest timestamp. Callers hold
+// s.mu.
+func (m *Manager) advanceWatermark(s *slot, timestamp time.Time) {
+	if !timestamp.After(s.maxEventTime) {
+		return
+	}
+	s.maxEventTime = timestamp
+	watermark := s.watermark(m.eventTime)
+	watermarkLag.Set(time.Since(watermark).Seconds(), "topic", m.config.Name, "key", s.window.Key)
+	for len(s.older) > 0 && !s.older[0].StartTime.Add(m.eventTime.duration).After(watermark) {
+		w := s.older[0]
+		log.Printf("Window for %s/%d passed by the watermark (%s). Closing.", m.config.Name, w.Partition, watermark.Format(time.RFC3339))
+		m.closeOpenWindow(s, w, CloseWatermark)
+	}
+}
+
+// eventTimeFlusher closes the slot's windows that hold messages once no message arrived for
+// the window duration plus the allowed lateness, since the watermark of an idle key does not
+// move, or all of them on an explicit flush.
+func (m *Manager) eventTimeFlusher(s *slot) {
+	idle := m.eventTime.duration + m.eventTime.lateness
+	ticker := time.NewTicker(m.eventTime.duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.mu.Lock()
+			idleKey := false
+			if !s.lastArrival.IsZero() && now.Sub(s.lastArrival) >= idle {
+				for _, w := range append([]*Window(nil), s.openWindows()...) {
+					if w.SeenCount > 0 {
+						log.Printf("Window for %s/%d idle for %s. Closing.", m.config.Name, w.Partition, idle)
+						m.closeOpenWindow(s, w, CloseTimeout)
+					}
+				}
+				idleKey = m.grouper != nil
+			}
+			s.mu.Unlock()
+			if idleKey && m.retire(s) {
+				return
+			}
+		case reason := <-s.flush:
+			s.mu.Lock()
+			log.Printf("Windows for %s/%d explicitly flushed (%s). Closing.", m.config.Name, s.window.Partition, reason)
+			m.closeAll(s, reason)
+			s.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package window
+
+import (
+	"hash/fnv"
+
+	"stream-rag-agent/internal/metrics"
+)
+
+var openKeys = metrics.NewGauge("window_open_keys", "Keys of a topic grouped by key (group_by_key or group_by_field) that have open windows.")
+
+// retire removes the slot of a key grouped by group_by_key or group_by_field once none of its
+// windows saw a message, stopping its flusher, so keys that went quiet do not keep windows
+// and goroutines forever. Their empty windows are dropped unprocessed; the key's next message
+// opens a new slot. It reports whether the slot was removed. Callers must not hold s.mu, which
+// is taken after the manager's lock.
+func (m *Manager) retire(s *slot) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, w := range s.openWindows() {
+		if w.SeenCount > 0 {
+			return false
+		}
+	}
+	for _, w := range s.openWindows() {
+		w.IsClosed = true
+		m.untrack(w)
+		m.wal.done(w)
+	}
+	delete(m.slots, s.window.Key)
+	s.retired = true
+	m.countKeys()
+	return true
+}
+
+// countKeys exports the number of open keys of a topic grouped by key. Callers hold m.mu.
+func (m *Manager) countKeys() {
+	if m.grouper != nil {
+		openKeys.Set(float64(len(m.slots)), "topic", m.config.Name)
+	}
+}
+
+// keyHash shortens a grouping key for window IDs.
+func keyHash(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
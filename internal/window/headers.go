@@ -0,0 +1,47 @@
+package window
+
+import (
+	"sort"
+	"strings"
+)
+
+// HeaderValues returns the distinct values of each message header in the window, sorted, or
+// nil if no message carries headers. They are indexed with the window so trace IDs and
+// tenant tags can be filtered on.
+func (w *Window) HeaderValues() map[string][]string {
+	var values map[string][]string
+	seen := make(map[string]map[string]bool)
+	for _, msg := range w.Messages {
+		for name, value := range msg.Headers {
+			if seen[name] == nil {
+				seen[name] = make(map[string]bool)
+			}
+			if seen[name][value] {
+				continue
+			}
+			seen[name][value] = true
+			if values == nil {
+				values = make(map[string][]string)
+			}
+			values[name] = append(values[name], value)
+		}
+	}
+	for _, v := range values {
+		sort.Strings(v)
+	}
+	return values
+}
+
+// headersString renders the headers of a message as "name=value" pairs sorted by name.
+func headersString(headers map[string]string) string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + headers[name]
+	}
+	return strings.Join(pairs, ", ")
+}
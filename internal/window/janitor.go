@@ -0,0 +1,172 @@
+package window
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/metrics"
+)
+
+const (
+	defaultStaleAfterDurations = 3
+	defaultJanitorInterval     = time.Minute
+)
+
+var (
+	staleClosesTotal     = metrics.NewCounter("window_stale_closes_total", "Windows the janitor force-closed after they stayed open for several times their duration, by topic and whether they were orphaned (no longer held by the slot of their key).")
+	flusherRestartsTotal = metrics.NewCounter("window_flusher_restarts_total", "Flusher goroutines the janitor restarted for keys whose flusher was gone, by topic.")
+)
+
+// Janitor force-closes windows that stay open far beyond their duration, e.g. because the
+// flusher goroutine of their key returned early or their key was taken over by a new slot, so
+// they do not hold messages and memory forever. Such windows are anomalies: each is logged and
+// counted, and a key whose flusher is gone gets a new one.
+type Janitor struct {
+	staleAfter int // Multiple of a window's expected lifetime after which it is stale
+	interval   time.Duration
+	mu         sync.Mutex
+	managers   []*Manager
+}
+
+// NewJanitor returns nil when the janitor is disabled.
+func NewJanitor(cfg config.WindowJanitorConfig) *Janitor {
+	if cfg.StaleAfterDurations < 0 {
+		return nil
+	}
+	staleAfter := cfg.StaleAfterDurations
+	switch {
+	case staleAfter == 0:
+		staleAfter = defaultStaleAfterDurations
+	case staleAfter < 2:
+		// Windows close up to a flusher tick after their duration
+		log.Printf("Warning: window_janitor.stale_after_durations %d would close healthy windows; using 2", staleAfter)
+		staleAfter = 2
+	}
+	interval := time.Duration(cfg.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultJanitorInterval
+	}
+	return &Janitor{staleAfter: staleAfter, interval: interval}
+}
+
+// Attach makes the janitor watch the manager's windows.
+func (j *Janitor) Attach(m *Manager) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.managers = append(j.managers, m)
+	j.mu.Unlock()
+}
+
+// Run scans the windows of the attached managers every interval until the context is
+// cancelled.
+func (j *Janitor) Run(ctx context.Context) {
+	if j == nil {
+		return
+	}
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			j.sweep(now)
+		}
+	}
+}
+
+func (j *Janitor) sweep(now time.Time) {
+	j.mu.Lock()
+	managers := append([]*Manager(nil), j.managers...)
+	j.mu.Unlock()
+	for _, m := range managers {
+		lifetime := m.lifetime()
+		if lifetime <= 0 {
+			continue // Windows without a duration only close on their limits
+		}
+		limit := time.Duration(j.staleAfter) * lifetime
+		for w, s := range m.openedBefore(now.Add(-limit)) {
+			m.forceClose(s, w, now, limit)
+		}
+	}
+}
+
+// lifetime returns how long the manager's windows are expected to stay open, 0 if they have
+// no duration.
+func (m *Manager) lifetime() time.Duration {
+	if m.eventTime != nil {
+		// The watermark passes a range once it is complete and the allowed lateness has passed
+		return m.eventTime.duration + m.eventTime.lateness
+	}
+	return time.Duration(m.config.WindowDurationSeconds) * time.Second
+}
+
+// openedBefore returns the open windows opened before cutoff, with the slot each was opened in.
+func (m *Manager) openedBefore(cutoff time.Time) map[*Window]*slot {
+	m.liveMu.Lock()
+	defer m.liveMu.Unlock()
+	var stale map[*Window]*slot
+	for w, s := range m.live {
+		if w.opened.Before(cutoff) {
+			if stale == nil {
+				stale = make(map[*Window]*slot)
+			}
+			stale[w] = s
+		}
+	}
+	return stale
+}
+
+// untrack forgets a window that is closed.
+func (m *Manager) untrack(w *Window) {
+	m.liveMu.Lock()
+	delete(m.live, w)
+	m.liveMu.Unlock()
+}
+
+// forceClose closes a window of slot s that has been open for longer than limit. A window its
+// slot still holds is closed like any other; one its slot lost, or whose slot is no longer the
+// one of its key, is orphaned and is processed without a successor. The slot of the key gets a
+// new flusher if its own is gone.
+func (m *Manager) forceClose(s *slot, w *Window, now time.Time, limit time.Duration) {
+	m.mu.RLock()
+	current := m.slots[w.Key] == s
+	m.mu.RUnlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w.IsClosed {
+		return
+	}
+	orphaned := !current || !s.holds(w)
+	log.Printf("Warning: Window %s of topic %s has been open for %s, longer than the %s allowed (orphaned: %t, flushers: %d). Force-closing it.",
+		w.ID, m.config.Name, now.Sub(w.opened).Round(time.Second), limit, orphaned, s.flushers.Load())
+	if orphaned {
+		m.finish(w, CloseStale)
+	} else {
+		m.closeOpenWindow(s, w, CloseStale)
+	}
+	staleClosesTotal.Inc("topic", m.config.Name, "orphaned", strconv.FormatBool(orphaned))
+
+	if current && !s.retired && s.flushers.Load() == 0 {
+		log.Printf("Warning: Flusher of window key %s of topic %s is gone. Restarting it.", w.Key, m.config.Name)
+		flusherRestartsTotal.Inc("topic", m.config.Name)
+		m.startFlusher(s, s.window)
+	}
+}
+
+// holds reports whether w is one of the slot's open windows. Callers hold s.mu.
+func (s *slot) holds(w *Window) bool {
+	for _, open := range s.openWindows() {
+		if open == w {
+			return true
+		}
+	}
+	return false
+}
@@ -3,133 +3,720 @@ package window
 import (
 	"fmt"
 	"log"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"stream-rag-agent/internal/codec"
 	"stream-rag-agent/internal/config"
+	"stream-rag-agent/pkg/windowing"
 )
 
-type WindowProcessor interface {
-	ProcessWindow(w *Window) error
-}
+// maxPreallocatedMessages caps the message capacity reserved for a new window, and that of the
+// message slices kept for reuse.
+const maxPreallocatedMessages = 4096
+
+// WindowProcessor handles the agent's closed windows. The manager reuses a window's Messages
+// slice once ProcessWindow returns, so processors must not keep it.
+type WindowProcessor = windowing.Processor[*Window]
 
+var (
+	// messageSlices holds the message slices of processed windows for new windows, so a busy
+	// partition does not allocate a window's worth of messages every time one closes.
+	messageSlices sync.Pool // *[]RawKafkaMessage
+	// slotBatches holds the slot slices AddMessages resolves a batch into.
+	slotBatches = sync.Pool{New: func() interface{} { return new([]*slot) }}
+)
+
+// Manager is the agent's windowing engine: a windowing.Sink that buffers messages into
+// windows chosen by its assigner and closes them when its trigger fires.
 type Manager struct {
-	windows      map[string]*Window // Key: topic_partition_id -> Window
-	mu           sync.Mutex
-	config       config.KafkaTopicConfig
-	processor    WindowProcessor
-	flushTrigger chan struct{}
+	slots     map[string]*slot    // Key: window key from the assigner -> its open window
+	indexed   map[slotIndex]*slot // The same slots by partition and shard, unless grouped by key
+	mu        sync.RWMutex        // Guards slots and indexed only; each slot locks its own window
+	config    config.KafkaTopicConfig
+	assigner  windowing.WindowAssigner
+	sharder   *windowing.KeyHashAssigner // nil unless the topic's partitions are sharded by key
+	grouper   *windowing.KeyAssigner     // nil unless the topic's windows are kept per message key
+	trigger   windowing.Trigger
+	keyFields []string // JSON fields extracted from every message into its Fields, see keyFieldsOf
+	processor WindowProcessor
+	sampler   *sampler       // nil when the topic is not downsampled
+	location  *time.Location // Reporting time zone for rendered context
+	budget    *MemoryBudget  // nil when no memory budget is configured
+	trends    *trendTracker  // nil when trends are disabled for the topic
+	offsetIDs bool           // Closed windows are identified by their offset range, see UseOffsetIDs
+	slide     time.Duration  // Time between the starts of overlapping sliding windows, 0 for tumbling windows
+	eventTime *eventTime     // nil unless windows cover ranges of message timestamps, see WindowTimeEvent
+
+	liveMu sync.Mutex        // Guards live and wal; taken after slot locks
+	live   map[*Window]*slot // Open windows with the slot they were opened in, for the janitor
+	wal    *walLog           // nil unless the topic's messages are logged, see WAL
 }
 
-func NewManager(cfg config.KafkaTopicConfig, processor WindowProcessor) *Manager {
+// slot holds the open window of one window key (by default a partition). Messages for
+// different keys are added under their own slot lock, so partitions do not contend. With
+// sliding or event-time windows, the slot also holds the earlier windows of the key that are
+// still open.
+type slot struct {
+	mu      sync.Mutex
+	window  *Window     // The newest open window
+	older   []*Window   // Earlier windows that are still open, oldest first; sliding and event-time windows only
+	flush   chan string // Close reason of an explicit flush, read by the slot's flusher
+	windows []*Window   // Reused by openWindows
+	retired bool        // Removed from the manager as an idle key, see retire
+
+	flushers atomic.Int32 // Running flusher goroutines, see startFlusher
+
+	maxEventTime time.Time // Newest message timestamp of the key, event-time windows only
+	lastArrival  time.Time // When the key's latest message arrived, event-time windows only
+}
+
+// slotIndex locates the slot of a partition, or of a key shard of one (shard -1 if the topic is
+// not sharded), without building its window key for every message.
+type slotIndex struct {
+	partition int32
+	shard     int
+}
+
+// openWindows returns the open windows of the slot, the newest last. The slice is reused.
+func (s *slot) openWindows() []*Window {
+	s.windows = append(append(s.windows[:0], s.older...), s.window)
+	return s.windows
+}
+
+func NewManager(cfg config.KafkaTopicConfig, processor WindowProcessor, loc *time.Location) *Manager {
+	assigner := NewAssigner(cfg)
+	var sharder *windowing.KeyHashAssigner
+	var grouper *windowing.KeyAssigner
+	switch a := assigner.(type) {
+	case windowing.KeyHashAssigner:
+		sharder = &a
+	case windowing.KeyAssigner:
+		grouper = &a
+	}
+	slide := slideInterval(cfg)
 	return &Manager{
-		windows:      make(map[string]*Window),
-		config:       cfg,
-		processor:    processor,
-		flushTrigger: make(chan struct{}, 1),
+		slots:    make(map[string]*slot),
+		indexed:  make(map[slotIndex]*slot),
+		config:   cfg,
+		assigner: assigner,
+		sharder:  sharder,
+		grouper:  grouper,
+		trigger: windowing.CountOrTimeTrigger{
+			MaxMessages: cfg.WindowMaxMessages,
+			MaxBytes:    cfg.WindowMaxBytes,
+			Duration:    time.Duration(cfg.WindowDurationSeconds) * time.Second,
+		},
+		keyFields: keyFieldsOf(cfg),
+		processor: processor,
+		location:  loc,
+		sampler:   newSampler(cfg.Sampling),
+		trends:    newTrendTracker(cfg.Trends),
+		slide:     slide,
+		eventTime: newEventTime(cfg, slide),
+		live:      make(map[*Window]*slot),
 	}
 }
 
+// keyFieldsOf lists the JSON fields the manager reads from the messages of the topic, to group
+// or shard windows by, to sample by, for key statistics and for the event time.
+func keyFieldsOf(cfg config.KafkaTopicConfig) []string {
+	var fields []string
+	add := func(field string) {
+		if field != "" && !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+	if cfg.GroupByKey || cfg.GroupByField != "" {
+		add(cfg.GroupByField)
+	} else if cfg.KeySharding.Shards > 1 {
+		add(cfg.KeySharding.KeyField)
+	}
+	if newSampler(cfg.Sampling) != nil {
+		add(cfg.Sampling.KeyField)
+	}
+	add(cfg.StatsKeyField)
+	add(cfg.EventTimeField)
+	return fields
+}
+
+// UseOffsetIDs identifies closed windows by topic, partition (and shard) and offset range
+// instead of by start time, so a window rebuilt from the same messages after a replay
+// overwrites the indexed one rather than duplicating it. It is only meant for sources whose
+// offsets are stable across restarts, such as Kafka offsets or JetStream sequences. Call it
+// before Start.
+func (m *Manager) UseOffsetIDs() {
+	m.offsetIDs = true
+}
+
+// NewAssigner returns the window assigner of the topic: one window per partition, one per
+// message key (or group_by_field value) of each partition with group_by_key or
+// group_by_field, or one per key shard of each partition when key_sharding is configured.
+func NewAssigner(cfg config.KafkaTopicConfig) windowing.WindowAssigner {
+	if cfg.GroupByKey || cfg.GroupByField != "" {
+		if cfg.KeySharding.Shards > 1 {
+			log.Printf("Warning: Topic %s groups windows by key, which makes key_sharding redundant; ignoring it", cfg.Name)
+		}
+		field := cfg.GroupByField
+		return windowing.KeyAssigner{KeyFunc: func(msg RawKafkaMessage) string { return messageKey(msg, field) }}
+	}
+	if cfg.KeySharding.Shards <= 1 {
+		return windowing.PartitionAssigner{}
+	}
+	sharder := windowing.KeyHashAssigner{Shards: cfg.KeySharding.Shards} // Hashes the message key
+	if field := cfg.KeySharding.KeyField; field != "" {
+		sharder.KeyFunc = func(msg RawKafkaMessage) string { return messageKey(msg, field) }
+	}
+	return sharder
+}
+
+// Start opens the window of a partition (or of each of its key shards), e.g. one found by
+// partition discovery. Partitions that were not started get their window when their first
+// message arrives.
 func (m *Manager) Start(partition int32) {
 	log.Printf("Starting window manager for topic: %s, partition: %d", m.config.Name, partition)
+	m.open(partition)
+}
+
+// open opens the windows of a partition that has none yet. Messages are added externally; the
+// slot's flusher closes the window on time. Windows grouped by key are opened by the first
+// message of their key.
+func (m *Manager) open(partition int32) {
+	if m.grouper != nil {
+		return
+	}
+	now := time.Now()
+	if m.sharder != nil {
+		for shard := 0; shard < m.sharder.Shards; shard++ {
+			m.openKey(windowing.ShardKey(m.config.Name, partition, shard), m.config.Name, partition, shard, now, false)
+		}
+		return
+	}
+	m.openSlot(RawKafkaMessage{Topic: m.config.Name, Partition: partition, Timestamp: now}, false)
+}
+
+// slotFor returns the slot of the message's window key, opening a window for new keys.
+func (m *Manager) slotFor(msg RawKafkaMessage) *slot {
+	return m.openSlot(msg, true)
+}
+
+// openSlot returns the slot of the message's window key, opening a window for new keys and
+// warning about it when unexpected is set.
+func (m *Manager) openSlot(msg RawKafkaMessage, unexpected bool) *slot {
+	shard := -1
+	if m.sharder != nil {
+		shard = m.sharder.Shard(msg)
+	}
+	if m.grouper == nil {
+		m.mu.RLock()
+		s, ok := m.indexed[slotIndex{msg.Partition, shard}]
+		m.mu.RUnlock()
+		if ok {
+			return s
+		}
+	}
+	var key string
+	switch {
+	case m.grouper != nil:
+		key = m.assigner.Assign(msg)
+	case m.sharder != nil:
+		key = windowing.ShardKey(msg.Topic, msg.Partition, shard)
+	default:
+		key = windowing.PartitionKey(msg.Topic, msg.Partition)
+	}
+	return m.openKey(key, msg.Topic, msg.Partition, shard, msg.Timestamp, unexpected)
+}
+
+// openKey returns the slot of the window key, opening a window starting at startTime (or at
+// the start of its event-time range) if the key has none.
+func (m *Manager) openKey(key, topic string, partition int32, shard int, startTime time.Time, unexpected bool) *slot {
+	m.mu.RLock()
+	s, ok := m.slots[key]
+	m.mu.RUnlock()
+	if ok {
+		return s
+	}
 
-	currentWindow := NewWindow(m.config.Name, partition, time.Now(), m.config.Context)
 	m.mu.Lock()
-	m.windows[fmt.Sprintf("%s_%d", m.config.Name, partition)] = currentWindow
-	m.mu.Unlock()
+	defer m.mu.Unlock()
+	if s, ok := m.slots[key]; ok {
+		return s
+	}
+	if unexpected && len(m.slots) > 0 && m.grouper == nil {
+		log.Printf("Warning: No active window for topic %s, partition %d. Creating new.", topic, partition)
+	}
+	if m.eventTime != nil {
+		startTime = m.eventTime.rangeStart(startTime)
+	}
+	s = &slot{flush: make(chan string, 1)}
+	s.window = m.newWindow(s, key, topic, partition, shard, startTime, 0)
+	m.slots[key] = s
+	if m.grouper == nil {
+		m.indexed[slotIndex{partition, shard}] = s
+	}
+	m.countKeys()
+	m.startFlusher(s, s.window)
+	return s
+}
 
-	// Goroutine for time-based window closing
-	go m.timeBasedFlusher(currentWindow)
+// startFlusher starts the goroutine closing the slot's windows on time: one per slot for
+// sliding and event-time windows, or one per window, w, for tumbling windows. Callers hold
+// s.mu, or have not published the slot yet.
+func (m *Manager) startFlusher(s *slot, w *Window) {
+	s.flushers.Add(1)
+	go func() {
+		defer s.flushers.Add(-1)
+		switch {
+		case m.slide > 0:
+			m.slidingFlusher(s)
+		case m.eventTime != nil:
+			m.eventTimeFlusher(s)
+		default:
+			m.timeBasedFlusher(s, w)
+		}
+	}()
+}
 
-	// Keep this goroutine alive, messages are added externally.
-	// We only need this goroutine to process windows when they close.
+// newWindow creates a window stamped with the topic's current context description and version.
+// Room for sizeHint messages (the previous window's count) is reserved up front so a busy
+// partition does not regrow the message slice in every window. shard is the key shard of the
+// window, -1 if the topic is not sharded. The window is tracked as open in slot s until it is
+// finished.
+func (m *Manager) newWindow(s *slot, key, topic string, partition int32, shard int, startTime time.Time, sizeHint int) *Window {
+	w := NewWindow(topic, partition, startTime, m.config.Context)
+	w.Key = key
+	w.opened = time.Now()
+	m.liveMu.Lock()
+	m.live[w] = s
+	m.wal.opened(w)
+	m.liveMu.Unlock()
+	if m.grouper != nil {
+		w.GroupKey = strings.TrimPrefix(key, windowing.PartitionKey(topic, partition)+"@")
+		w.GroupField = m.config.GroupByField
+	}
+	idTime := startTime
+	if m.eventTime != nil {
+		// An event-time range is opened again after an early close or a restart, so the window
+		// is identified by when it was opened instead
+		idTime = time.Now()
+		w.ID = fmt.Sprintf("%s_%d_%d", topic, partition, idTime.UnixNano())
+	}
+	if shard >= 0 {
+		// Shards of a partition open their windows at the same time
+		w.Shard = shard
+		w.ID = fmt.Sprintf("%s_%d_s%d_%d", topic, partition, shard, idTime.UnixNano())
+	}
+	if m.grouper != nil {
+		// So do keys whose first messages arrive in one batch
+		w.ID = fmt.Sprintf("%s_%d_k%08x_%d", topic, partition, keyHash(w.GroupKey), idTime.UnixNano())
+	}
+	w.ContextVersion = m.config.ResolvedContextVersion()
+	w.ContextEffectiveFrom = m.config.ContextEffectiveFrom
+	w.Location = m.location
+	if m.config.WindowMaxMessages > 0 && (sizeHint == 0 || sizeHint > m.config.WindowMaxMessages) {
+		sizeHint = m.config.WindowMaxMessages
+	}
+	if sizeHint > 0 {
+		w.Messages = messageSlice(min(sizeHint, maxPreallocatedMessages))
+	}
+	return w
+}
+
+// messageSlice returns an empty message slice with room for n messages, reusing the slice of a
+// processed window if one is large enough.
+func messageSlice(n int) []RawKafkaMessage {
+	if p, ok := messageSlices.Get().(*[]RawKafkaMessage); ok && cap(*p) >= n {
+		return (*p)[:0]
+	}
+	return make([]RawKafkaMessage, 0, n)
+}
+
+// recycleMessages keeps the message slice of a processed window for reuse, after dropping its
+// references to the payloads.
+func recycleMessages(w *Window) {
+	msgs := w.Messages[:cap(w.Messages)]
+	w.Messages = nil
+	if len(msgs) == 0 || len(msgs) > maxPreallocatedMessages {
+		return
+	}
+	clear(msgs)
+	messageSlices.Put(&msgs)
 }
 
 // AddMessage adds a message to the current window for its topic/partition.
 // This is called by the Kafka consumer.
 func (m *Manager) AddMessage(msg RawKafkaMessage) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	msg = m.readFields(m.decompress(msg))
+	for {
+		s := m.slotFor(msg)
+		s.mu.Lock()
+		if !s.retired {
+			m.add(s, msg)
+			m.wal.flush()
+			s.mu.Unlock()
+			return
+		}
+		// The key went idle and was retired meanwhile, slotFor opens it again
+		s.mu.Unlock()
+	}
+}
 
-	key := fmt.Sprintf("%s_%d", msg.Topic, msg.Partition)
-	currentWindow, ok := m.windows[key]
-	if !ok {
-		log.Printf("Warning: No active window for topic %s, partition %d. Creating new.", msg.Topic, msg.Partition)
-		currentWindow = NewWindow(msg.Topic, msg.Partition, msg.Timestamp, m.config.Context)
-		m.windows[key] = currentWindow
-		go m.timeBasedFlusher(currentWindow) // Ensure flusher is running for new window
+// AddMessages adds a batch of messages in order, locking a window once for each run of
+// consecutive messages it receives. Slots are resolved before any is locked, since opening
+// one takes the manager's lock.
+func (m *Manager) AddMessages(msgs []RawKafkaMessage) {
+	for i := range msgs {
+		msgs[i] = m.decompress(msgs[i])
 	}
+	m.addMessages(msgs)
+}
 
-	currentWindow.AddMessage(msg)
+// addMessages adds a batch of decompressed messages like AddMessages.
+func (m *Manager) addMessages(msgs []RawKafkaMessage) {
+	batch := slotBatches.Get().(*[]*slot)
+	defer func() {
+		clear(*batch)
+		slotBatches.Put(batch)
+	}()
+	slots := (*batch)[:0]
+	for i := range msgs {
+		msgs[i] = m.readFields(msgs[i])
+		slots = append(slots, m.slotFor(msgs[i]))
+	}
+	*batch = slots
+	for i := 0; i < len(msgs); {
+		s := slots[i]
+		s.mu.Lock()
+		if s.retired {
+			s.mu.Unlock()
+			for j := i; j < len(msgs); j++ {
+				if slots[j] == s {
+					slots[j] = m.slotFor(msgs[j])
+				}
+			}
+			continue
+		}
+		for ; i < len(msgs) && slots[i] == s; i++ {
+			m.add(s, msgs[i])
+		}
+		m.wal.flush()
+		s.mu.Unlock()
+	}
+}
 
-	// Check if message count limit is reached
-	if m.config.WindowMaxMessages > 0 && currentWindow.MessageCount >= m.config.WindowMaxMessages {
-		log.Printf("Window for %s/%d reached max messages (%d). Closing.", m.config.Name, currentWindow.Partition, currentWindow.MessageCount)
-		m.closeWindow(currentWindow)
+// decompress decodes application-level compressed payloads before they are parsed as JSON.
+func (m *Manager) decompress(msg RawKafkaMessage) RawKafkaMessage {
+	if m.config.PayloadCompression != "" && !msg.IsTombstone() {
+		value, err := codec.Decompress(msg.Value, m.config.PayloadCompression, m.config.MaxDecompressedBytes)
+		if err != nil {
+			log.Printf("Warning: Could not decompress message (Offset: %d) on topic %s: %v. Using raw payload.", msg.Offset, msg.Topic, err)
+		} else {
+			msg.Value = value
+		}
 	}
+	return msg
 }
 
-// timeBasedFlusher closes the window after a specified duration.
-func (m *Manager) timeBasedFlusher(w *Window) {
-	ticker := time.NewTicker(time.Duration(m.config.WindowDurationSeconds) * time.Second)
-	defer ticker.Stop()
+// readFields parses the payload once to read the topic's key fields into msg.Fields, before
+// the message is assigned, sampled and counted by the key statistics. With event_time_field,
+// the field's time replaces the record time as the message's timestamp, so windows, ordering
+// and out-of-order marks follow when events happened; messages without it keep the record time.
+func (m *Manager) readFields(msg RawKafkaMessage) RawKafkaMessage {
+	if len(m.keyFields) == 0 || msg.IsTombstone() || msg.Fields != nil {
+		return msg
+	}
+	msg.Fields = extractFields(msg.Value, m.keyFields)
+	if field := m.config.EventTimeField; field != "" {
+		if t, ok := parseEventTime(msg.Fields[field]); ok {
+			msg.Timestamp = t
+		}
+	}
+	return msg
+}
+
+// add adds the message to the slot's open windows, or to the window of its timestamp for
+// event-time windows. The caller has locked the slot.
+func (m *Manager) add(s *slot, msg RawKafkaMessage) {
+	var windows []*Window
+	if m.eventTime != nil {
+		if msg.Timestamp.IsZero() {
+			msg.Timestamp = time.Now()
+		}
+		w := m.eventWindow(s, msg)
+		if w == nil {
+			return // Late and dropped
+		}
+		s.windows = append(s.windows[:0], w)
+		windows = s.windows
+	} else {
+		windows = s.openWindows()
+	}
+	// Logged once the message's windows are open, before it can close one
+	m.wal.log(s.window.Key, msg)
+
+	var buffered int64
+	for _, w := range windows {
+		buffered -= w.bytes
+	}
+	if msg.IsTombstone() {
+		// Deletions on compacted topics bypass sampling and are annotated, not rendered as messages
+		for _, w := range windows {
+			w.AddTombstone(msg)
+		}
+		m.sampler.forget(msg)
+	} else if m.sampler != nil {
+		m.sampler.add(windows, msg)
+	} else {
+		for _, w := range windows {
+			w.AddMessage(msg)
+		}
+	}
+	for _, w := range windows {
+		buffered += w.bytes
+	}
+	m.budget.add(buffered)
+
+	for _, w := range windows {
+		if !m.trigger.OnMessage(w.state()) {
+			continue
+		}
+		if limit := m.config.WindowMaxMessages; limit > 0 && w.MessageCount >= limit {
+			log.Printf("Window for %s/%d reached max messages (%d). Closing.", m.config.Name, w.Partition, w.MessageCount)
+			m.closeOpenWindow(s, w, CloseMaxMessages)
+		} else {
+			log.Printf("Window for %s/%d reached max bytes (%d bytes in %d messages). Closing.", m.config.Name, w.Partition, w.bytes, w.MessageCount)
+			m.closeOpenWindow(s, w, CloseMaxBytes)
+		}
+	}
+	if m.eventTime != nil {
+		m.advanceWatermark(s, msg.Timestamp)
+	}
+}
+
+// timeBasedFlusher closes the window when the trigger's timer fires, or on an explicit flush.
+func (m *Manager) timeBasedFlusher(s *slot, w *Window) {
+	var tick <-chan time.Time
+	if interval := m.trigger.Interval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
 
 	for {
 		select {
-		case <-ticker.C:
-			m.mu.Lock()
-			if !w.IsClosed && time.Since(w.StartTime) >= time.Duration(m.config.WindowDurationSeconds)*time.Second {
+		case now := <-tick:
+			s.mu.Lock()
+			if w.IsClosed {
+				// Closed by the message limit or the memory budget
+				s.mu.Unlock()
+				return
+			}
+			if m.trigger.OnTimer(w.state(), now) {
+				if m.grouper != nil && w.SeenCount == 0 {
+					s.mu.Unlock()
+					if m.retire(s) {
+						return
+					}
+					s.mu.Lock()
+					if w.IsClosed {
+						s.mu.Unlock()
+						return
+					}
+				}
 				log.Printf("Window for %s/%d timed out (%d sec). Closing.", m.config.Name, w.Partition, m.config.WindowDurationSeconds)
-				m.closeWindow(w)
-				m.mu.Unlock()
+				m.closeWindow(s, CloseTimeout)
+				s.mu.Unlock()
 				return
 			}
-			m.mu.Unlock()
-		case <-m.flushTrigger:
-			m.mu.Lock()
+			s.mu.Unlock()
+		case reason := <-s.flush:
+			s.mu.Lock()
 			if !w.IsClosed {
-				log.Printf("Window for %s/%d explicitly flushed. Closing.", m.config.Name, w.Partition)
-				m.closeWindow(w)
+				log.Printf("Window for %s/%d explicitly flushed (%s). Closing.", m.config.Name, w.Partition, reason)
+				m.closeWindow(s, reason)
 			}
-			m.mu.Unlock()
+			s.mu.Unlock()
 			return // Stop this flusher goroutine
 		}
 	}
 }
 
-func (m *Manager) closeWindow(w *Window) {
+// closeWindow closes the slot's newest window, opens its successor right away so incoming
+// messages never land in a window that is being processed, and processes the closed window in
+// the background. The successor of an event-time window covers the same range. The returned channel is closed once it is processed, nil if the window was
+// already closed. Callers hold s.mu.
+func (m *Manager) closeWindow(s *slot, reason string) <-chan struct{} {
+	w := s.window
+	size := len(w.Messages) // Read before finish hands the window to its processor
+	processed := m.finish(w, reason)
+	if processed == nil {
+		return nil
+	}
+	start := w.ClosedAt
+	if m.eventTime != nil {
+		start = w.StartTime
+	}
+	s.window = m.newWindow(s, w.Key, w.Topic, w.Partition, w.Shard, start, size)
+	if m.slide == 0 && m.eventTime == nil {
+		// The sliding and event-time flushers serve all windows of the slot
+		m.startFlusher(s, s.window)
+	}
+	return processed
+}
+
+// closeOpenWindow closes one of the slot's open windows: the newest, which is replaced as
+// by closeWindow, or an earlier sliding or event-time window. It returns nil if w is not open in the slot.
+// Callers hold s.mu.
+func (m *Manager) closeOpenWindow(s *slot, w *Window, reason string) <-chan struct{} {
+	if w == s.window {
+		return m.closeWindow(s, reason)
+	}
+	for i, older := range s.older {
+		if older == w {
+			last := len(s.older) - 1
+			copy(s.older[i:], s.older[i+1:])
+			s.older[last] = nil // Not kept alive by the backing array
+			s.older = s.older[:last]
+			return m.finish(w, reason)
+		}
+	}
+	return nil
+}
+
+// closeAll closes all open windows of the slot, returning the channels closed once they are
+// processed. Callers hold s.mu.
+func (m *Manager) closeAll(s *slot, reason string) []<-chan struct{} {
+	var pending []<-chan struct{}
+	for _, w := range s.older {
+		if processed := m.finish(w, reason); processed != nil {
+			pending = append(pending, processed)
+		}
+	}
+	clear(s.older)
+	s.older = s.older[:0]
+	if processed := m.closeWindow(s, reason); processed != nil {
+		pending = append(pending, processed)
+	}
+	return pending
+}
+
+// finish closes a window and processes it in the background. The returned channel is closed
+// once it is processed, nil if the window was already closed.
+func (m *Manager) finish(w *Window, reason string) <-chan struct{} {
 	if w.IsClosed {
-		return
+		return nil
 	}
 	w.IsClosed = true
-	w.EndTime = time.Now()
+	w.CloseReason = reason
+	m.untrack(w)
+	if m.offsetIDs && w.FirstOffset >= 0 {
+		w.ID = OffsetWindowID(w.Topic, w.Partition, w.Shard, w.FirstOffset, w.LastOffset)
+	}
+	w.ClosedAt = time.Now()
+	w.EndTime = w.ClosedAt
+	if m.eventTime != nil {
+		w.EndTime = w.StartTime.Add(m.eventTime.duration)
+	}
+	w.orderMessages(m.config.MessageOrder == MessageOrderEventTime)
+	w.Trend = m.trends.observe(w)
 
+	processed := make(chan struct{})
 	go func() {
+		defer close(processed)
+		// Computed here rather than under the slot lock, which holds up the key's messages
+		w.ParseFailures = countParseFailures(w.Messages)
+		w.KeyStats = ComputeKeyStats(w.Messages, m.config.StatsKeyField, m.config.StatsTopKeys)
 		err := m.processor.ProcessWindow(w)
 		if err != nil {
 			log.Printf("Error processing window %s: %v", w.ID, err)
 		}
-		// After processing, remove the closed window and start a new one for continuous streaming
-		m.mu.Lock()
-		defer m.mu.Unlock()
-		key := fmt.Sprintf("%s_%d", w.Topic, w.Partition)
-		delete(m.windows, key) // Remove old window
-		newWindow := NewWindow(w.Topic, w.Partition, time.Now(), m.config.Context)
-		m.windows[key] = newWindow
-		go m.timeBasedFlusher(newWindow) // Start flusher for the new window
+		m.budget.add(-w.bytes)
+		m.wal.done(w)
+		recycleMessages(w)
 	}()
+	return processed
 }
 
-func (m *Manager) FlushAllWindows() {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	for _, w := range m.windows {
-		if !w.IsClosed {
+// openWindows lists the manager's open windows with their buffered bytes.
+func (m *Manager) openWindows() []openWindow {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var open []openWindow
+	for _, s := range m.slots {
+		s.mu.Lock()
+		for _, w := range s.openWindows() {
+			if !w.IsClosed && w.bytes > 0 {
+				open = append(open, openWindow{manager: m, window: w, bytes: w.bytes})
+			}
+		}
+		s.mu.Unlock()
+	}
+	return open
+}
+
+// closeEarly closes the window if it is still open, reporting whether it did.
+func (m *Manager) closeEarly(w *Window) bool {
+	m.mu.RLock()
+	s, ok := m.slots[w.Key]
+	m.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return m.closeOpenWindow(s, w, CloseMemoryBudget) != nil
+}
+
+// FlushAllWindows closes all open windows, recording reason (e.g. CloseShutdown) as their
+// close reason.
+func (m *Manager) FlushAllWindows(reason string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, s := range m.slots {
+		s.mu.Lock()
+		open := !s.window.IsClosed
+		s.mu.Unlock()
+		if open {
 			select {
-			case m.flushTrigger <- struct{}{}:
+			case s.flush <- reason:
 			default:
 				// Already flushing or channel full, ignore
 			}
 		}
 	}
 }
+
+// PartitionsAssigned opens the windows of partitions the consumer group assigned to this
+// agent, unless they are open already.
+func (m *Manager) PartitionsAssigned(partitions []int32) {
+	for _, p := range partitions {
+		m.open(p)
+	}
+}
+
+// PartitionsRevoked closes the windows of partitions the consumer group revoked from this
+// agent (CloseRebalance) and waits until they are processed, so their messages are indexed
+// before another agent takes the partitions over.
+func (m *Manager) PartitionsRevoked(partitions []int32) {
+	revoked := make(map[int32]bool, len(partitions))
+	for _, p := range partitions {
+		revoked[p] = true
+	}
+	var pending []<-chan struct{}
+	m.mu.RLock()
+	for _, s := range m.slots {
+		s.mu.Lock()
+		if w := s.window; revoked[w.Partition] && !w.IsClosed {
+			log.Printf("Window for %s/%d flushed, the partition was revoked. Closing.", m.config.Name, w.Partition)
+			pending = append(pending, m.closeAll(s, CloseRebalance)...)
+		}
+		s.mu.Unlock()
+	}
+	m.mu.RUnlock()
+	for _, processed := range pending {
+		<-processed
+	}
+}
@@ -0,0 +1,59 @@
+package window
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/pkg/windowing"
+)
+
+// BenchmarkManagerAddMessage measures the cost of adding one message to an open window,
+// including the share of closing and replacing a window every 1000 messages.
+func BenchmarkManagerAddMessage(b *testing.B) {
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	for _, bc := range []struct {
+		name string
+		cfg  config.KafkaTopicConfig
+	}{
+		{"partition", config.KafkaTopicConfig{}},
+		{"key_sharding", config.KafkaTopicConfig{KeySharding: config.KeyShardingConfig{Shards: 4}}},
+		{"stats_key_field", config.KafkaTopicConfig{StatsKeyField: "account_id"}},
+		{"group_by_field", config.KafkaTopicConfig{GroupByField: "account_id", StatsKeyField: "account_id"}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			cfg := bc.cfg
+			cfg.Name = "payments"
+			cfg.WindowDurationSeconds = 3600
+			cfg.WindowMaxMessages = 1000
+			m := NewManager(cfg, windowing.ProcessorFunc[*Window](func(*Window) error { return nil }), time.UTC)
+
+			msgs := make([]RawKafkaMessage, 1024)
+			for i := range msgs {
+				msgs[i] = RawKafkaMessage{
+					Topic:     cfg.Name,
+					Partition: int32(i % 4),
+					Key:       []byte(fmt.Sprintf("ACC-%04d", i%97)),
+					Value:     []byte(fmt.Sprintf(`{"account_id":"ACC-%04d","amount":%d.50,"currency":"EUR"}`, i%97, i)),
+					Timestamp: time.Now(),
+				}
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				msg := msgs[i%len(msgs)]
+				msg.Offset = int64(i)
+				m.AddMessage(msg)
+			}
+			b.StopTimer()
+			m.FlushAllWindows(CloseShutdown)
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package window
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	MessageOrderArrival   = "arrival"    // Render messages in the order they were consumed (default)
+	MessageOrderEventTime = "event_time" // Sort messages by their timestamp when the window closes
+)
+
+// parseEventTime reads an event time extracted from a payload field: an RFC 3339 string, or a
+// Unix time in seconds or, for values too large to be seconds, milliseconds.
+func parseEventTime(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, true
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}, false
+	}
+	if n >= 1e11 {
+		return time.UnixMilli(int64(n)), true
+	}
+	return time.Unix(0, int64(n*float64(time.Second))), true
+}
+
+// orderMessages records which messages arrived out of timestamp order (later than a message
+// with a newer timestamp) and, when sortByEventTime is set, sorts the messages by timestamp.
+// It is called when the window closes. Timestamps are event times from event_time_field where
+// the topic sets one, see Manager.readFields.
+func (w *Window) orderMessages(sortByEventTime bool) {
+	var newest time.Time
+	for _, msg := range w.Messages {
+		if msg.Timestamp.Before(newest) {
+			if w.late == nil {
+				w.late = make(map[int64]time.Duration)
+			}
+			lateness := newest.Sub(msg.Timestamp)
+			w.late[msg.Offset] = lateness
+			w.MaxLateness = max(w.MaxLateness, lateness)
+			continue
+		}
+		newest = msg.Timestamp
+	}
+	w.OutOfOrder = len(w.late)
+
+	if sortByEventTime && w.OutOfOrder > 0 {
+		sort.SliceStable(w.Messages, func(i, j int) bool {
+			return w.Messages[i].Timestamp.Before(w.Messages[j].Timestamp)
+		})
+	}
+	w.SortedByEventTime = sortByEventTime
+}
+
+// orderingString describes how the rendered messages are ordered, empty when they arrived in
+// timestamp order and were not re-sorted.
+func (w *Window) orderingString() string {
+	switch {
+	case w.OutOfOrder == 0 && w.SortedByEventTime:
+		return "messages are in event-time order"
+	case w.OutOfOrder == 0:
+		return ""
+	case w.SortedByEventTime:
+		return fmt.Sprintf("messages are sorted by event time; %d of %d arrived out of order (up to %s late) and are marked",
+			w.OutOfOrder, len(w.Messages), w.MaxLateness)
+	default:
+		return fmt.Sprintf("messages are in arrival order; %d of %d arrived out of order (up to %s late) and are marked, so their position does not reflect when they happened",
+			w.OutOfOrder, len(w.Messages), w.MaxLateness)
+	}
+}
+
+// lateness returns how much older than an earlier-arrived message the message is, 0 if it
+// arrived in order.
+func (w *Window) lateness(msg RawKafkaMessage) time.Duration {
+	return w.late[msg.Offset]
+}
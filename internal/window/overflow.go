@@ -0,0 +1,127 @@
+package window
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxOverflowCategories is the number of distinct values above which a string field is
+	// treated as an identifier and left out of the overflow summary.
+	maxOverflowCategories = 10
+	maxOverflowFields     = 8
+)
+
+// fieldOverflow accumulates one JSON field over the messages cut from the rendered context.
+type fieldOverflow struct {
+	counts   map[string]int // String values -> occurrences, nil once too many distinct values were seen
+	values   int
+	numbers  int
+	min, max float64
+	sum      float64
+}
+
+// overflowSummary describes the messages left out of the rendered context so the LLM knows
+// what they contained: value counts of categorical fields and min/max/sum of numeric fields.
+// It returns the lines to render below the truncation marker, each indented like a message.
+func (w *Window) overflowSummary(messages []RawKafkaMessage) []string {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	fields := make(map[string]*fieldOverflow)
+	unparsed := 0
+	for _, msg := range messages {
+		var data map[string]interface{}
+		if err := json.Unmarshal(msg.Value, &data); err != nil {
+			unparsed++
+			continue
+		}
+		for k, v := range data {
+			f, ok := fields[k]
+			if !ok {
+				f = &fieldOverflow{counts: make(map[string]int), min: math.Inf(1), max: math.Inf(-1)}
+				fields[k] = f
+			}
+			f.add(v)
+		}
+	}
+
+	first, last := messages[0], messages[len(messages)-1]
+	lines := []string{fmt.Sprintf("offsets %d-%d, %s - %s", first.Offset, last.Offset, w.formatTime(first.Timestamp), w.formatTime(last.Timestamp))}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if line := fields[name].String(); line != "" {
+			lines = append(lines, fmt.Sprintf("%s: %s", name, line))
+			if len(lines) > maxOverflowFields {
+				break
+			}
+		}
+	}
+	if unparsed > 0 {
+		lines = append(lines, fmt.Sprintf("%d non-JSON messages", unparsed))
+	}
+	return lines
+}
+
+func (f *fieldOverflow) add(v interface{}) {
+	switch value := v.(type) {
+	case float64:
+		f.numbers++
+		f.min = math.Min(f.min, value)
+		f.max = math.Max(f.max, value)
+		f.sum += value
+	case string:
+		if f.counts == nil {
+			return
+		}
+		f.counts[value]++
+		f.values++
+		if len(f.counts) > maxOverflowCategories {
+			f.counts = nil
+		}
+	case bool:
+		if f.counts != nil {
+			f.counts[fmt.Sprintf("%t", value)]++
+			f.values++
+		}
+	}
+}
+
+// String renders the field as "min 1.5, max 980, sum 12345.6" for numbers or
+// "purchase=25, refund=10" for categories; empty for identifiers and nested values.
+func (f *fieldOverflow) String() string {
+	if f.numbers > 0 {
+		return fmt.Sprintf("min %s, max %s, sum %s", formatNumber(f.min), formatNumber(f.max), formatNumber(f.sum))
+	}
+	if len(f.counts) == 0 || (f.values > 1 && len(f.counts) == f.values) {
+		return "" // No values, or all distinct like IDs
+	}
+	values := make([]KeyCount, 0, len(f.counts))
+	for k, c := range f.counts {
+		values = append(values, KeyCount{Key: k, Count: c})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return values[i].Key < values[j].Key
+	})
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		parts = append(parts, fmt.Sprintf("%s=%d", v.Key, v.Count))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatNumber(v float64) string {
+	return fmt.Sprintf("%.2f", v)
+}
@@ -0,0 +1,74 @@
+package window
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Reasons a window was closed.
+const (
+	CloseTimeout      = "timeout"       // The window duration elapsed
+	CloseMaxMessages  = "max_messages"  // The message limit was reached
+	CloseMaxBytes     = "max_bytes"     // The byte limit was reached
+	CloseWatermark    = "watermark"     // The event-time watermark passed the end of the window
+	CloseMemoryBudget = "memory_budget" // Closed early to stay within the memory budget
+	CloseFlush        = "flush"         // Flushed on request
+	CloseShutdown     = "shutdown"      // Flushed while the agent stopped
+	CloseRebalance    = "rebalance"     // Flushed because the partition was revoked
+	CloseStale        = "stale"         // Force-closed by the janitor after staying open far beyond its duration
+)
+
+// IsPartialClose reports whether a window closed for this reason was cut short, i.e. holds
+// less than a full window's duration or messages.
+func IsPartialClose(reason string) bool {
+	switch reason {
+	case CloseMemoryBudget, CloseFlush, CloseShutdown, CloseRebalance:
+		return true
+	}
+	return false
+}
+
+// countParseFailures counts messages whose payload is not valid JSON; they are rendered raw.
+func countParseFailures(msgs []RawKafkaMessage) int {
+	failures := 0
+	for _, msg := range msgs {
+		if !json.Valid(msg.Value) {
+			failures++
+		}
+	}
+	return failures
+}
+
+// renderedMessages returns how many messages ToContextStringN spells out for maxMessages.
+func (w *Window) renderedMessages(maxMessages int) int {
+	if maxMessages <= 0 {
+		maxMessages = defaultSummarizeMessages
+	}
+	return min(maxMessages, w.MessageCount)
+}
+
+// Truncated reports whether rendering with maxMessages leaves messages out of the context
+// text (they are only summarized).
+func (w *Window) Truncated(maxMessages int) bool {
+	return w.renderedMessages(maxMessages) < w.MessageCount
+}
+
+// QualityNotes describes what makes a stored window partial or degraded, for prompts and
+// debugging; empty for a complete window.
+func (ew *EmbeddedWindow) QualityNotes() string {
+	var notes []string
+	if IsPartialClose(ew.CloseReason) {
+		notes = append(notes, fmt.Sprintf("partial window, closed early (%s)", strings.ReplaceAll(ew.CloseReason, "_", " ")))
+	}
+	if ew.Truncated {
+		notes = append(notes, "not all messages are listed")
+	}
+	if ew.SamplingPolicy != "" {
+		notes = append(notes, fmt.Sprintf("sampled (%.1f%% of %d messages kept)", ew.SamplingRate*100, ew.SampledFrom))
+	}
+	if ew.ParseFailures > 0 {
+		notes = append(notes, fmt.Sprintf("%d messages could not be parsed", ew.ParseFailures))
+	}
+	return strings.Join(notes, "; ")
+}
@@ -0,0 +1,142 @@
+package window
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sync"
+
+	"stream-rag-agent/internal/config"
+)
+
+const (
+	SamplingNone        = "none"
+	SamplingRate        = "rate"         // Keep each message with a fixed probability
+	SamplingReservoir   = "reservoir"    // Keep a uniform sample of at most N messages per key per window
+	SamplingOnlyChanged = "only_changed" // Keep a message only if its payload differs from the key's previous one
+)
+
+// sampler downsamples messages before they enter a window. It is used under the lock of the
+// window's slot; state shared across windows (lastValue) has its own lock.
+type sampler struct {
+	cfg       config.SamplingConfig
+	mu        sync.Mutex
+	lastValue map[string]string // Key -> last kept payload, for only_changed
+}
+
+// newSampler returns nil when no sampling policy is configured.
+func newSampler(cfg config.SamplingConfig) *sampler {
+	if cfg.Policy == "" || cfg.Policy == SamplingNone {
+		return nil
+	}
+	return &sampler{cfg: cfg, lastValue: make(map[string]string)}
+}
+
+// add offers a message to the windows holding it (several overlapping sliding windows, or
+// one), keeping, replacing or dropping it per the policy. The rate and only_changed policies
+// decide once per message, so overlapping windows agree; reservoirs are kept per window.
+// Every offered message counts towards the windows' SeenCount.
+func (s *sampler) add(windows []*Window, msg RawKafkaMessage) {
+	keep := true
+	switch s.cfg.Policy {
+	case SamplingRate:
+		keep = rand.Float64() < s.cfg.Rate
+	case SamplingReservoir:
+		for _, w := range windows {
+			s.addToReservoir(w, msg)
+		}
+		return
+	case SamplingOnlyChanged:
+		key := messageKey(msg, s.cfg.KeyField)
+		value := s.comparableValue(msg)
+		s.mu.Lock()
+		last, ok := s.lastValue[key]
+		keep = !ok || last != value
+		if keep {
+			s.lastValue[key] = value
+		}
+		s.mu.Unlock()
+	}
+	for _, w := range windows {
+		w.SamplingPolicy = s.cfg.Policy
+		if keep {
+			w.AddMessage(msg)
+		} else {
+			w.dropSampled(msg)
+		}
+	}
+}
+
+// addToReservoir keeps the message in the window's reservoir of its key, replacing a kept
+// message or dropping it once the reservoir is full.
+func (s *sampler) addToReservoir(w *Window, msg RawKafkaMessage) {
+	w.SamplingPolicy = s.cfg.Policy
+	key := messageKey(msg, s.cfg.KeyField)
+	if w.reservoirs == nil {
+		w.reservoirs = make(map[string][]int)
+	}
+	positions := w.reservoirs[key]
+	seen := w.keySeen(key)
+	if len(positions) < s.cfg.ReservoirSize {
+		w.reservoirs[key] = append(positions, len(w.Messages))
+		w.AddMessage(msg)
+		return
+	}
+	// Classic reservoir sampling: replace a kept message with probability size/seen
+	if j := rand.Intn(seen); j < s.cfg.ReservoirSize {
+		w.bytes += msg.Size() - w.Messages[positions[j]].Size()
+		w.Messages[positions[j]] = msg
+	}
+	w.dropSampled(msg)
+}
+
+// dropSampled accounts for a message the sampler dropped from the window.
+func (w *Window) dropSampled(msg RawKafkaMessage) {
+	w.SeenCount++
+	w.trackOffset(msg.Offset)
+	w.EndTime = msg.Timestamp
+}
+
+// forget drops the last kept payload of a deleted key, so a re-created entity is kept by
+// only_changed even if its payload matches the one before the deletion.
+func (s *sampler) forget(tombstone RawKafkaMessage) {
+	if s == nil || s.cfg.KeyField != "" {
+		return // Keys taken from the payload cannot be resolved for a tombstone
+	}
+	s.mu.Lock()
+	delete(s.lastValue, string(tombstone.Key))
+	s.mu.Unlock()
+}
+
+// comparableValue returns the payload with the configured ignore_fields removed, so that
+// e.g. a changing timestamp alone does not count as a change.
+func (s *sampler) comparableValue(msg RawKafkaMessage) string {
+	if len(s.cfg.IgnoreFields) == 0 {
+		return string(msg.Value)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(msg.Value, &data); err != nil {
+		return string(msg.Value)
+	}
+	for _, f := range s.cfg.IgnoreFields {
+		delete(data, f)
+	}
+	normalized, _ := json.Marshal(data) // Map keys are marshalled in sorted order
+	return string(normalized)
+}
+
+// keySeen increments and returns how many messages with this key were offered to the window.
+func (w *Window) keySeen(key string) int {
+	if w.reservoirCounts == nil {
+		w.reservoirCounts = make(map[string]int)
+	}
+	w.reservoirCounts[key]++
+	return w.reservoirCounts[key]
+}
+
+// SamplingRate returns the fraction of offered messages that were kept, 1 when not sampled.
+func (w *Window) SamplingRate() float64 {
+	if w.SeenCount == 0 || w.SamplingPolicy == "" {
+		return 1
+	}
+	return float64(w.MessageCount) / float64(w.SeenCount)
+}
@@ -0,0 +1,60 @@
+package window
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SimHash computes a 64-bit locality-sensitive signature of a window's context text: texts
+// sharing most of their tokens get signatures that differ in few bits. The header lines
+// (topic, topic context, window ID and time range) are skipped so that the same messages
+// arriving on mirrored topics hash alike.
+func SimHash(contextText string) uint64 {
+	if i := strings.Index(contextText, "\nMessages:\n"); i >= 0 {
+		contextText = contextText[i:]
+	}
+
+	var weights [64]int
+	tokens := strings.FieldsFunc(strings.ToLower(contextText), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '.' && r != '-' && r != '_'
+	})
+	for _, tok := range tokens {
+		h := fnv.New64a()
+		h.Write([]byte(tok))
+		v := h.Sum64()
+		for i := 0; i < 64; i++ {
+			if v&(1<<i) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+
+	var signature uint64
+	for i, w := range weights {
+		if w > 0 {
+			signature |= 1 << i
+		}
+	}
+	return signature
+}
+
+// FormatSimHash renders a signature the way it is stored in Elasticsearch.
+func FormatSimHash(signature uint64) string {
+	return fmt.Sprintf("%016x", signature)
+}
+
+// ParseSimHash parses a stored signature.
+func ParseSimHash(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
+
+// HammingDistance is the number of differing bits between two signatures.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,71 @@
+package window
+
+import (
+	"log"
+	"time"
+
+	"stream-rag-agent/internal/config"
+)
+
+const (
+	WindowTypeTumbling = "tumbling" // Each message is in one window; a window opens when the previous one closes (default)
+	WindowTypeSliding  = "sliding"  // Windows of window_duration_seconds open every slide_interval_seconds and overlap
+)
+
+// slideInterval returns the time between the starts of the topic's sliding windows, or 0 for
+// tumbling windows, which an invalid sliding configuration falls back to.
+func slideInterval(cfg config.KafkaTopicConfig) time.Duration {
+	switch cfg.WindowType {
+	case "", WindowTypeTumbling:
+		return 0
+	case WindowTypeSliding:
+	default:
+		log.Printf("Warning: Unknown window_type '%s' of topic %s (expected %s or %s), using tumbling windows", cfg.WindowType, cfg.Name, WindowTypeTumbling, WindowTypeSliding)
+		return 0
+	}
+	if cfg.SlideIntervalSeconds <= 0 || cfg.SlideIntervalSeconds >= cfg.WindowDurationSeconds {
+		log.Printf("Warning: slide_interval_seconds of topic %s must be positive and shorter than window_duration_seconds (%d), using tumbling windows", cfg.Name, cfg.WindowDurationSeconds)
+		return 0
+	}
+	return time.Duration(cfg.SlideIntervalSeconds) * time.Second
+}
+
+// slidingFlusher opens a new window of the slot every slide interval and closes the windows
+// that have been open for the window duration, or all of them on an explicit flush.
+func (m *Manager) slidingFlusher(s *slot) {
+	ticker := time.NewTicker(m.slide)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.mu.Lock()
+			m.hop(s, now)
+			s.mu.Unlock()
+			if m.grouper != nil && m.retire(s) {
+				return
+			}
+		case reason := <-s.flush:
+			s.mu.Lock()
+			log.Printf("Windows for %s/%d explicitly flushed (%s). Closing.", m.config.Name, s.window.Partition, reason)
+			m.closeAll(s, reason)
+			s.mu.Unlock()
+		}
+	}
+}
+
+// hop opens the slot's next window at now and closes the windows it outlasts. Windows start
+// on ticks of the slide interval, so a window is due once it is open for the window duration
+// less half an interval, which absorbs ticker jitter. Callers hold s.mu.
+func (m *Manager) hop(s *slot, now time.Time) {
+	previous := s.window
+	s.older = append(s.older, previous)
+	s.window = m.newWindow(s, previous.Key, previous.Topic, previous.Partition, previous.Shard, now, len(previous.Messages))
+
+	duration := time.Duration(m.config.WindowDurationSeconds) * time.Second
+	for len(s.older) > 0 && now.Sub(s.older[0].StartTime) >= duration-m.slide/2 {
+		w := s.older[0]
+		log.Printf("Window for %s/%d timed out (%d sec). Closing.", m.config.Name, w.Partition, m.config.WindowDurationSeconds)
+		m.closeOpenWindow(s, w, CloseTimeout)
+	}
+}
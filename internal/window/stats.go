@@ -0,0 +1,115 @@
+package window
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const defaultTopKeys = 3
+
+// parsedPayloads holds the maps extractFields parses payloads into, which json.Unmarshal
+// reuses.
+var parsedPayloads = sync.Pool{New: func() interface{} { return make(map[string]interface{}) }}
+
+type KeyCount struct {
+	Key   string
+	Count int
+}
+
+// KeyStats summarizes how messages in a window are distributed over their keys.
+type KeyStats struct {
+	Field    string // JSON field the keys were read from, empty for the Kafka message key
+	Distinct int
+	Top      []KeyCount // Most frequent keys, highest count first
+}
+
+// ComputeKeyStats counts distinct keys in the messages, reading the key from the given JSON
+// field or, when field is empty, from the Kafka message key. Messages without a key are skipped.
+func ComputeKeyStats(messages []RawKafkaMessage, field string, topN int) *KeyStats {
+	if topN <= 0 {
+		topN = defaultTopKeys
+	}
+
+	counts := make(map[string]int)
+	for _, msg := range messages {
+		key := messageKey(msg, field)
+		if key == "" {
+			continue
+		}
+		counts[key]++
+	}
+	if len(counts) == 0 {
+		return nil
+	}
+
+	top := make([]KeyCount, 0, len(counts))
+	for k, c := range counts {
+		top = append(top, KeyCount{Key: k, Count: c})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Key < top[j].Key
+	})
+	if len(top) > topN {
+		top = top[:topN]
+	}
+
+	return &KeyStats{Field: field, Distinct: len(counts), Top: top}
+}
+
+// messageKey returns the value of the JSON field of the message, or its Kafka key when field is
+// empty. Fields the manager extracted when the message was windowed are not parsed again.
+func messageKey(msg RawKafkaMessage, field string) string {
+	if field == "" {
+		return string(msg.Key)
+	}
+	if v, ok := msg.Fields[field]; ok {
+		return v
+	}
+	return extractFields(msg.Value, []string{field})[field]
+}
+
+// extractFields parses a JSON payload once and returns the values of the fields, rendered as
+// strings. Fields that are missing or null, and all fields of payloads that are not JSON
+// objects, map to "".
+func extractFields(value []byte, fields []string) map[string]string {
+	values := make(map[string]string, len(fields))
+	data := parsedPayloads.Get().(map[string]interface{})
+	defer func() {
+		clear(data)
+		parsedPayloads.Put(data)
+	}()
+	if err := json.Unmarshal(value, &data); err != nil {
+		clear(data)
+	}
+	for _, field := range fields {
+		switch v := data[field].(type) {
+		case nil:
+			values[field] = ""
+		case string:
+			values[field] = v
+		default:
+			values[field] = fmt.Sprintf("%v", v)
+		}
+	}
+	return values
+}
+
+// String renders the statistics as one line, e.g.
+// "42 distinct account_id values; most active: ACC-0007 (9 events), ACC-0100 (5 events)".
+func (s *KeyStats) String() string {
+	label := "keys"
+	if s.Field != "" {
+		label = s.Field + " values"
+	}
+	parts := make([]string, 0, len(s.Top))
+	for _, kc := range s.Top {
+		parts = append(parts, fmt.Sprintf("%s (%d events)", kc.Key, kc.Count))
+	}
+	return fmt.Sprintf("%d distinct %s; most active: %s", s.Distinct, label, strings.Join(parts, ", "))
+}
@@ -0,0 +1,36 @@
+package window
+
+import "encoding/json"
+
+// ExtractFields returns the requested top-level fields of a JSON message. The single field
+// "*" selects every scalar field. Nil is returned for non-JSON messages or when none of the
+// fields are present.
+func ExtractFields(msg RawKafkaMessage, fields []string) map[string]interface{} {
+	if len(fields) == 0 {
+		return nil
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(msg.Value, &data); err != nil {
+		return nil
+	}
+
+	out := make(map[string]interface{})
+	if len(fields) == 1 && fields[0] == "*" {
+		for k, v := range data {
+			switch v.(type) {
+			case string, float64, bool:
+				out[k] = v
+			}
+		}
+	} else {
+		for _, f := range fields {
+			if v, ok := data[f]; ok && v != nil {
+				out[f] = v
+			}
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
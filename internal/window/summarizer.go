@@ -0,0 +1,194 @@
+package window
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+
+	"stream-rag-agent/internal/config"
+)
+
+// Summarizers of a topic's summarizer.type.
+const (
+	SummarizerMessages = "messages" // The first messages field by field, aggregates of the rest (default)
+	SummarizerFull     = "full"     // Every message field by field
+	SummarizerStats    = "stats"    // Aggregates of the messages' fields, no messages
+	SummarizerTemplate = "template" // A Go text/template executed with a SummaryData
+)
+
+// Summarizer renders closed windows into the text that is embedded and given to the LLM.
+type Summarizer interface {
+	// Summarize renders the window. A positive maxMessages caps the messages spelled out, e.g.
+	// while shedding load.
+	Summarize(w *Window, maxMessages int) (string, error)
+	// Truncated reports whether Summarize leaves messages of the window out of the text (they
+	// are at most aggregated).
+	Truncated(w *Window, maxMessages int) bool
+	// Version identifies the rendering. It is stored with embedded windows, so windows
+	// rendered differently can be told apart and re-embedded.
+	Version() string
+}
+
+// NewSummarizer returns the summarizer of the topic. Unknown types fall back to messages; a
+// template that does not parse is an error.
+func NewSummarizer(cfg config.KafkaTopicConfig) (Summarizer, error) {
+	s := cfg.Summarizer
+	switch s.Type {
+	case "", SummarizerMessages:
+		return messagesSummarizer{max: s.MaxMessages}, nil
+	case SummarizerFull:
+		return fullSummarizer{}, nil
+	case SummarizerStats:
+		return statsSummarizer{}, nil
+	case SummarizerTemplate:
+		if s.Template == "" {
+			return nil, fmt.Errorf("summarizer template of topic %s is empty", cfg.Name)
+		}
+		tmpl, err := template.New(cfg.Name).Parse(s.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid summarizer template of topic %s: %w", cfg.Name, err)
+		}
+		sum := sha256.Sum256([]byte(s.Template))
+		return templateSummarizer{tmpl: tmpl, max: s.MaxMessages, version: SummarizerTemplate + "-" + hex.EncodeToString(sum[:4])}, nil
+	default:
+		log.Printf("Warning: Unknown summarizer type '%s' of topic %s (expected %s, %s, %s or %s), using %s", s.Type, cfg.Name, SummarizerMessages, SummarizerFull, SummarizerStats, SummarizerTemplate, SummarizerMessages)
+		return messagesSummarizer{max: s.MaxMessages}, nil
+	}
+}
+
+// listed returns how many messages to spell out: maxMessages capped by the configured
+// maximum, if any.
+func listed(configured, maxMessages int) int {
+	if configured > 0 && (maxMessages <= 0 || maxMessages > configured) {
+		return configured
+	}
+	return maxMessages
+}
+
+// messagesSummarizer renders windows with ToContextStringN.
+type messagesSummarizer struct {
+	max int // Messages spelled out, 0 for the default
+}
+
+func (s messagesSummarizer) Summarize(w *Window, maxMessages int) (string, error) {
+	return w.ToContextStringN(listed(s.max, maxMessages))
+}
+
+func (s messagesSummarizer) Truncated(w *Window, maxMessages int) bool {
+	return w.Truncated(listed(s.max, maxMessages))
+}
+
+func (s messagesSummarizer) Version() string {
+	return ContextTemplateVersion
+}
+
+// fullSummarizer renders windows like messagesSummarizer, but spells out every message unless
+// maxMessages caps them.
+type fullSummarizer struct{}
+
+func (fullSummarizer) Summarize(w *Window, maxMessages int) (string, error) {
+	if maxMessages <= 0 {
+		maxMessages = len(w.Messages)
+	}
+	return w.ToContextStringN(maxMessages)
+}
+
+func (fullSummarizer) Truncated(w *Window, maxMessages int) bool {
+	return maxMessages > 0 && maxMessages < w.MessageCount
+}
+
+func (fullSummarizer) Version() string {
+	return SummarizerFull + "-" + ContextTemplateVersion
+}
+
+// statsSummarizer renders the window's header and aggregates of its messages' fields, the
+// value counts of categorical fields and min/max/sum of numeric ones, for topics whose
+// individual messages say little, e.g. metrics.
+type statsSummarizer struct{}
+
+func (statsSummarizer) Summarize(w *Window, maxMessages int) (string, error) {
+	if len(w.Messages) == 0 {
+		return w.emptyContextString(), nil
+	}
+	var sb strings.Builder
+	w.writeContextHeader(&sb)
+	sb.WriteString("Messages (aggregated):\n")
+	for _, line := range w.overflowSummary(w.Messages) {
+		sb.WriteString(fmt.Sprintf("  - %s\n", line))
+	}
+	return sb.String(), nil
+}
+
+func (statsSummarizer) Truncated(w *Window, maxMessages int) bool {
+	return w.MessageCount > 0
+}
+
+func (statsSummarizer) Version() string {
+	return SummarizerStats + "-" + ContextTemplateVersion
+}
+
+// SummaryData is what summarizer templates are executed with: the window, e.g. {{.Topic}},
+// {{.MessageCount}} or {{.KeyStats}}, and its messages.
+type SummaryData struct {
+	*Window
+	Start    string           // Start of the time range, RFC3339 in the reporting time zone
+	End      string           // End of the time range
+	Listed   []SummaryMessage // The first max_messages messages
+	Omitted  int              // Messages not listed
+	Overflow []string         // Aggregates of the messages not listed, as rendered by the messages summarizer
+}
+
+// SummaryMessage is a message of a SummaryData.
+type SummaryMessage struct {
+	Offset    int64
+	Key       string
+	Timestamp string // RFC3339 in the reporting time zone
+	Headers   map[string]string
+	Fields    map[string]interface{} // The JSON payload, nil if the message is not a JSON object
+	Raw       string                 // The payload as text
+}
+
+// templateSummarizer renders windows with a topic's template.
+type templateSummarizer struct {
+	tmpl    *template.Template
+	max     int // Messages listed, 0 for the default
+	version string
+}
+
+func (s templateSummarizer) Summarize(w *Window, maxMessages int) (string, error) {
+	n := w.renderedMessages(listed(s.max, maxMessages))
+	data := SummaryData{
+		Window:  w,
+		Start:   w.formatTime(w.StartTime),
+		End:     w.formatTime(w.EndTime),
+		Listed:  make([]SummaryMessage, 0, n),
+		Omitted: w.MessageCount - n,
+	}
+	for _, msg := range w.Messages[:n] {
+		sm := SummaryMessage{Offset: msg.Offset, Key: string(msg.Key), Timestamp: w.formatTime(msg.Timestamp), Headers: msg.Headers, Raw: string(msg.Value)}
+		if err := json.Unmarshal(msg.Value, &sm.Fields); err != nil {
+			sm.Fields = nil
+		}
+		data.Listed = append(data.Listed, sm)
+	}
+	if n < len(w.Messages) {
+		data.Overflow = w.overflowSummary(w.Messages[n:])
+	}
+	var sb strings.Builder
+	if err := s.tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("failed to execute summarizer template: %w", err)
+	}
+	return sb.String(), nil
+}
+
+func (s templateSummarizer) Truncated(w *Window, maxMessages int) bool {
+	return w.Truncated(listed(s.max, maxMessages))
+}
+
+func (s templateSummarizer) Version() string {
+	return s.version
+}
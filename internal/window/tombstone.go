@@ -0,0 +1,48 @@
+package window
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const maxRenderedDeletions = 20
+
+// Deletion records a tombstone (a message with a null value) seen on a compacted topic:
+// the key it carried has been deleted.
+type Deletion struct {
+	Key       string
+	Offset    int64
+	Timestamp time.Time
+}
+
+// AddTombstone records a deletion instead of adding the message to the window's messages.
+func (w *Window) AddTombstone(msg RawKafkaMessage) {
+	w.Deletions = append(w.Deletions, Deletion{Key: string(msg.Key), Offset: msg.Offset, Timestamp: msg.Timestamp})
+	w.SeenCount++
+	w.trackOffset(msg.Offset)
+	w.EndTime = msg.Timestamp
+}
+
+// TombstoneOnly reports whether the window holds deletions but no messages, so there is
+// nothing worth embedding.
+func (w *Window) TombstoneOnly() bool {
+	return w.MessageCount == 0 && len(w.Deletions) > 0
+}
+
+// deletionsString renders the window's deletions as one line.
+func (w *Window) deletionsString() string {
+	var parts []string
+	for i, d := range w.Deletions {
+		if i == maxRenderedDeletions {
+			parts = append(parts, fmt.Sprintf("and %d more", len(w.Deletions)-maxRenderedDeletions))
+			break
+		}
+		key := d.Key
+		if key == "" {
+			key = "(no key)"
+		}
+		parts = append(parts, fmt.Sprintf("entity %s was deleted (offset %d)", key, d.Offset))
+	}
+	return strings.Join(parts, "; ")
+}
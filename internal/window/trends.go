@@ -0,0 +1,181 @@
+package window
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"stream-rag-agent/internal/config"
+)
+
+const defaultTrailingWindows = 10
+
+// TrendStats holds the rates of a window and the average rates of the windows before it, so
+// the LLM can tell whether activity is rising or falling.
+type TrendStats struct {
+	Current     trendSample
+	Trailing    trendSample // Average over the preceding windows
+	Windows     int         // Preceding windows averaged
+	AmountField string      // Empty when no amount is tracked
+}
+
+type trendSample struct {
+	MessagesPerMin float64
+	ErrorRatio     float64
+	AmountPerMin   float64
+}
+
+// trendTracker keeps the recent rates of each window key (by default a partition), so each
+// window is compared with its own predecessors.
+type trendTracker struct {
+	cfg      config.TrendConfig
+	trailing int
+
+	mu      sync.Mutex
+	history map[string][]trendSample // Window key -> most recent samples, oldest first
+}
+
+func newTrendTracker(cfg config.TrendConfig) *trendTracker {
+	if !cfg.Enabled {
+		return nil
+	}
+	t := &trendTracker{cfg: cfg, trailing: cfg.TrailingWindows, history: make(map[string][]trendSample)}
+	if t.trailing <= 0 {
+		t.trailing = defaultTrailingWindows
+	}
+	return t
+}
+
+// observe measures the closed window, records it and returns its trend; nil when the tracker
+// is disabled or there is nothing to compare. Empty windows are recorded, so quiet periods
+// lower the trailing average.
+func (t *trendTracker) observe(w *Window) *TrendStats {
+	if t == nil {
+		return nil
+	}
+	current := t.measure(w)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	previous := t.history[w.Key]
+	t.history[w.Key] = append(previous, current)
+	if len(t.history[w.Key]) > t.trailing {
+		t.history[w.Key] = t.history[w.Key][1:]
+	}
+	if len(previous) == 0 || w.MessageCount == 0 {
+		return nil
+	}
+
+	var trailing trendSample
+	for _, s := range previous {
+		trailing.MessagesPerMin += s.MessagesPerMin
+		trailing.ErrorRatio += s.ErrorRatio
+		trailing.AmountPerMin += s.AmountPerMin
+	}
+	n := float64(len(previous))
+	trailing.MessagesPerMin /= n
+	trailing.ErrorRatio /= n
+	trailing.AmountPerMin /= n
+	return &TrendStats{Current: current, Trailing: trailing, Windows: len(previous), AmountField: t.cfg.AmountField}
+}
+
+// measure computes the rates of a window over its time range. Sampled windows are scaled up
+// to the messages offered to them.
+func (t *trendTracker) measure(w *Window) trendSample {
+	minutes := w.EndTime.Sub(w.StartTime).Minutes()
+	if minutes < 1.0/60 {
+		minutes = 1.0 / 60
+	}
+	scale := 1.0
+	if rate := w.SamplingRate(); w.SamplingPolicy != "" && rate > 0 {
+		scale = 1 / rate
+	}
+
+	var errors int
+	var amount float64
+	for _, msg := range w.Messages {
+		var data map[string]interface{}
+		if err := json.Unmarshal(msg.Value, &data); err != nil {
+			if t.cfg.ErrorField == "" {
+				errors++
+			}
+			continue
+		}
+		if t.cfg.ErrorField != "" && t.isError(data[t.cfg.ErrorField]) {
+			errors++
+		}
+		if t.cfg.AmountField != "" {
+			amount += numericValue(data[t.cfg.AmountField])
+		}
+	}
+	sample := trendSample{
+		MessagesPerMin: float64(w.SeenCount) / minutes,
+		AmountPerMin:   amount * scale / minutes,
+	}
+	if len(w.Messages) > 0 {
+		sample.ErrorRatio = float64(errors) / float64(len(w.Messages))
+	}
+	return sample
+}
+
+func (t *trendTracker) isError(v interface{}) bool {
+	if v == nil {
+		return false
+	}
+	value := fmt.Sprintf("%v", v)
+	if len(t.cfg.ErrorValues) == 0 {
+		return value != "" && value != "false" && value != "0"
+	}
+	for _, e := range t.cfg.ErrorValues {
+		if strings.EqualFold(value, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// numericValue reads a JSON number or numeric string, 0 otherwise.
+func numericValue(v interface{}) float64 {
+	switch value := v.(type) {
+	case float64:
+		return value
+	case string:
+		f, err := strconv.ParseFloat(value, 64)
+		if err == nil {
+			return f
+		}
+	}
+	return 0
+}
+
+// String renders the trend as one line, e.g. "120.0 messages/min (+35.0% vs 88.9), error
+// ratio 2.0% (+1.5 pts vs 0.5%), amount 5400.00/min (-10.0% vs 6000.00), trailing average
+// of 10 windows".
+func (s *TrendStats) String() string {
+	parts := []string{
+		fmt.Sprintf("%.1f messages/min (%s vs %.1f)", s.Current.MessagesPerMin, relativeChange(s.Current.MessagesPerMin, s.Trailing.MessagesPerMin), s.Trailing.MessagesPerMin),
+		fmt.Sprintf("error ratio %.1f%% (%+.1f pts vs %.1f%%)", s.Current.ErrorRatio*100, (s.Current.ErrorRatio-s.Trailing.ErrorRatio)*100, s.Trailing.ErrorRatio*100),
+	}
+	if s.AmountField != "" {
+		parts = append(parts, fmt.Sprintf("%s %s/min (%s vs %s)", s.AmountField, formatNumber(s.Current.AmountPerMin),
+			relativeChange(s.Current.AmountPerMin, s.Trailing.AmountPerMin), formatNumber(s.Trailing.AmountPerMin)))
+	}
+	if s.Windows == 1 {
+		parts = append(parts, "compared with the previous window")
+	} else {
+		parts = append(parts, fmt.Sprintf("trailing average of %d windows", s.Windows))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func relativeChange(current, trailing float64) string {
+	if trailing == 0 {
+		if current == 0 {
+			return "unchanged"
+		}
+		return "new"
+	}
+	return fmt.Sprintf("%+.1f%%", (current-trailing)/trailing*100)
+}
@@ -6,27 +6,58 @@ import (
 	"log"
 	"strings"
 	"time"
+
+	"stream-rag-agent/pkg/windowing"
 )
 
-type RawKafkaMessage struct {
-	Topic     string
-	Partition int32
-	Offset    int64
-	Key       []byte
-	Value     []byte
-	Timestamp time.Time
-}
+const defaultSummarizeMessages = 10
+
+// ContextTemplateVersion identifies how ToContextString renders windows into the text that is
+// embedded; it is the Version of the messages Summarizer. Bump it whenever the rendering
+// changes, so windows embedded from differently rendered text can be told apart and
+// re-embedded.
+const ContextTemplateVersion = "1"
+
+// RawKafkaMessage is the message type of the windowing engine.
+type RawKafkaMessage = windowing.Message
 
 type Window struct {
-	ID           string // Unique ID for this window (e.g., topic_partition_offset)
-	Topic        string
-	Partition    int32
-	StartTime    time.Time
-	EndTime      time.Time
-	Messages     []RawKafkaMessage
-	Context      string // Context provided for the topic from config file
-	IsClosed     bool
-	MessageCount int
+	ID                   string // Unique ID for this window: topic_partition_startnanos (opening time for event-time windows), or the OffsetWindowID once closed
+	Key                  string // Key the window assigner filed the window under
+	Topic                string
+	Partition            int32
+	Shard                int    // Key shard of the partition the window holds, -1 if the topic is not sharded
+	GroupKey             string // Message key (or group_by_field value) of the window's messages, empty unless the topic is grouped by key
+	GroupField           string // group_by_field, empty when grouped by message key
+	StartTime            time.Time
+	EndTime              time.Time
+	Messages             []RawKafkaMessage
+	Context              string    // Context provided for the topic from config file
+	ContextVersion       string    // Version of the topic context that applied to this window
+	ContextEffectiveFrom time.Time // When that context version started to apply
+	IsClosed             bool
+	ClosedAt             time.Time      // Wall-clock time the window was closed, used for latency tracking
+	Location             *time.Location // Time zone timestamps are rendered in, nil keeps their own zone
+	MessageCount         int
+	KeyStats             *KeyStats     // Computed when the window closes, nil if messages carry no keys
+	Trend                *TrendStats   // Rates vs. preceding windows, computed at close when trends are enabled
+	SeenCount            int           // Messages offered to the window, including those dropped by sampling
+	FirstOffset          int64         // Lowest offset offered to the window, including dropped messages and tombstones; -1 if none
+	LastOffset           int64         // Highest such offset, -1 if none
+	SamplingPolicy       string        // Sampling policy applied to this window, empty if none
+	Deletions            []Deletion    // Tombstones seen in this window, not counted in Messages
+	SortedByEventTime    bool          // Messages were sorted by timestamp at close
+	OutOfOrder           int           // Messages that arrived after a message with a newer timestamp
+	MaxLateness          time.Duration // Largest gap between such a message and the newest one before it
+	CloseReason          string        // Why the window was closed, one of the Close* constants
+	ParseFailures        int           // Messages whose payload is not valid JSON, counted at close
+	LateMessages         int           // Messages of event-time windows that had closed, kept in this window (late_data keep)
+
+	bytes           int64                   // Size of the buffered message keys and values, for the memory budget
+	opened          time.Time               // Wall-clock time the manager opened the window, for the janitor
+	reservoirs      map[string][]int        // Key -> positions in Messages, for reservoir sampling
+	reservoirCounts map[string]int          // Key -> messages offered, for reservoir sampling
+	late            map[int64]time.Duration // Offset -> lateness of out-of-order messages, set at close
 }
 
 func NewWindow(topic string, partition int32, startTime time.Time, topicContext string) *Window {
@@ -34,68 +65,198 @@ func NewWindow(topic string, partition int32, startTime time.Time, topicContext
 		ID:           fmt.Sprintf("%s_%d_%d", topic, partition, startTime.UnixNano()),
 		Topic:        topic,
 		Partition:    partition,
+		Shard:        -1,
 		StartTime:    startTime,
 		Context:      topicContext,
 		Messages:     make([]RawKafkaMessage, 0),
 		IsClosed:     false,
 		MessageCount: 0,
+		FirstOffset:  -1,
+		LastOffset:   -1,
 	}
 }
 
+// OffsetWindowID is the deterministic ID of a window holding the offsets first to last of a
+// partition (or of one of its key shards, shard -1 if the topic is not sharded), e.g.
+// orders_3_1200-1499 or orders_3_s1_1200-1499.
+func OffsetWindowID(topic string, partition int32, shard int, first, last int64) string {
+	if shard >= 0 {
+		return fmt.Sprintf("%s_%d_s%d_%d-%d", topic, partition, shard, first, last)
+	}
+	return fmt.Sprintf("%s_%d_%d-%d", topic, partition, first, last)
+}
+
 func (w *Window) AddMessage(msg RawKafkaMessage) {
 	w.Messages = append(w.Messages, msg)
+	w.bytes += msg.Size()
 	w.MessageCount++
+	w.SeenCount++
+	w.trackOffset(msg.Offset)
 	w.EndTime = msg.Timestamp // Update end time with the latest message
 }
 
+// trackOffset widens the offset range the window covers.
+func (w *Window) trackOffset(offset int64) {
+	if w.FirstOffset < 0 || offset < w.FirstOffset {
+		w.FirstOffset = offset
+	}
+	if offset > w.LastOffset {
+		w.LastOffset = offset
+	}
+}
+
+// state describes the window to a windowing.Trigger.
+func (w *Window) state() windowing.WindowState {
+	return windowing.WindowState{Key: w.Key, Start: w.StartTime, Messages: w.MessageCount, Bytes: w.bytes}
+}
+
 func (w *Window) ToContextString() (string, error) {
+	return w.ToContextStringN(defaultSummarizeMessages)
+}
+
+// ToContextStringN renders the window like ToContextString but with at most maxMessages
+// messages spelled out (0 uses the default).
+func (w *Window) ToContextStringN(maxMessages int) (string, error) {
 	if len(w.Messages) == 0 {
-		return fmt.Sprintf("Topic: %s, Window ID: %s, No messages in this window.", w.Topic, w.ID), nil
+		return w.emptyContextString(), nil
 	}
 
 	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("Kafka Topic: %s\n", w.Topic))
-	sb.WriteString(fmt.Sprintf("Topic Context: %s\n", w.Context))
-	sb.WriteString(fmt.Sprintf("Window ID: %s, Time Range: %s - %s, Total Messages: %d\n",
-		w.ID, w.StartTime.Format(time.RFC3339), w.EndTime.Format(time.RFC3339), w.MessageCount))
+	w.writeContextHeader(&sb)
 	sb.WriteString("Messages:\n")
 
-	maxSummarizeMessages := 10
-	if w.MessageCount < maxSummarizeMessages {
-		maxSummarizeMessages = w.MessageCount
-	}
+	maxSummarizeMessages := w.renderedMessages(maxMessages)
 
 	for i := 0; i < maxSummarizeMessages; i++ {
-		msg := w.Messages[i]
-		var data map[string]interface{}
-		if err := json.Unmarshal(msg.Value, &data); err != nil {
-			log.Printf("Warning: Could not unmarshal message (Offset: %d) as JSON: %v. Using raw string.\n", msg.Offset, err)
-			sb.WriteString(fmt.Sprintf("  - Raw Message (Offset: %d): %s\n", msg.Offset, string(msg.Value)))
-		} else {
-			sb.WriteString(fmt.Sprintf("  - Message (Offset: %d) Details:\n", msg.Offset))
-
-			for k, v := range data {
-				sb.WriteString(fmt.Sprintf("    - %s: %v\n", k, v))
-			}
-
-		}
+		w.writeMessage(&sb, w.Messages[i])
 	}
 
 	if w.MessageCount > maxSummarizeMessages {
-		sb.WriteString(fmt.Sprintf("  ...and %d more messages (truncated for summary).\n", w.MessageCount-maxSummarizeMessages))
+		sb.WriteString(fmt.Sprintf("  ...and %d more messages (truncated for summary), which contain:\n", w.MessageCount-maxSummarizeMessages))
+		for _, line := range w.overflowSummary(w.Messages[maxSummarizeMessages:]) {
+			sb.WriteString(fmt.Sprintf("    - %s\n", line))
+		}
 	}
 
 	return sb.String(), nil
 }
 
+// emptyContextString renders a window without messages.
+func (w *Window) emptyContextString() string {
+	if len(w.Deletions) > 0 {
+		return fmt.Sprintf("Topic: %s, Window ID: %s, No messages in this window. Deletions: %s.", w.Topic, w.ID, w.deletionsString())
+	}
+	return fmt.Sprintf("Topic: %s, Window ID: %s, No messages in this window.", w.Topic, w.ID)
+}
+
+// writeContextHeader renders what the context text tells about the window before its
+// messages: topic, context, time range and the window's statistics.
+func (w *Window) writeContextHeader(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf("Kafka Topic: %s\n", w.Topic))
+	if w.ContextVersion != "" {
+		sb.WriteString(fmt.Sprintf("Topic Context (version %s): %s\n", w.ContextVersion, w.Context))
+	} else {
+		sb.WriteString(fmt.Sprintf("Topic Context: %s\n", w.Context))
+	}
+	sb.WriteString(fmt.Sprintf("Window ID: %s, Time Range: %s - %s, Total Messages: %d\n",
+		w.ID, w.formatTime(w.StartTime), w.formatTime(w.EndTime), w.MessageCount))
+	if w.GroupKey != "" {
+		label := "message key"
+		if w.GroupField != "" {
+			label = w.GroupField
+		}
+		sb.WriteString(fmt.Sprintf("All messages of this window have %s %s.\n", label, w.GroupKey))
+	}
+	if w.SamplingPolicy != "" {
+		sb.WriteString(fmt.Sprintf("Sampling: %s policy kept %d of %d messages (%.1f%%); counts and totals are approximate.\n",
+			w.SamplingPolicy, w.MessageCount, w.SeenCount, w.SamplingRate()*100))
+	}
+	if w.KeyStats != nil {
+		sb.WriteString(fmt.Sprintf("Key Statistics: %s\n", w.KeyStats))
+	}
+	if w.Trend != nil {
+		sb.WriteString(fmt.Sprintf("Trend: %s\n", w.Trend))
+	}
+	if len(w.Deletions) > 0 {
+		sb.WriteString(fmt.Sprintf("Deletions: %s\n", w.deletionsString()))
+	}
+	if ordering := w.orderingString(); ordering != "" {
+		sb.WriteString(fmt.Sprintf("Ordering: %s\n", ordering))
+	}
+	if w.LateMessages > 0 {
+		sb.WriteString(fmt.Sprintf("Late data: %d messages belong to earlier windows that had already closed, so their timestamps are outside the time range.\n", w.LateMessages))
+	}
+}
+
+// writeMessage renders a message field by field, or as a raw string if it is not JSON.
+func (w *Window) writeMessage(sb *strings.Builder, msg RawKafkaMessage) {
+	label := fmt.Sprintf("Offset: %d", msg.Offset)
+	if lateness := w.lateness(msg); lateness > 0 {
+		label += fmt.Sprintf(", out of order: %s late", lateness)
+	}
+	if len(msg.Headers) > 0 {
+		label += fmt.Sprintf(", headers: %s", headersString(msg.Headers))
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(msg.Value, &data); err != nil {
+		log.Printf("Warning: Could not unmarshal message (Offset: %d) as JSON: %v. Using raw string.\n", msg.Offset, err)
+		sb.WriteString(fmt.Sprintf("  - Raw Message (%s): %s\n", label, string(msg.Value)))
+		return
+	}
+	sb.WriteString(fmt.Sprintf("  - Message (%s) Details:\n", label))
+	for k, v := range data {
+		sb.WriteString(fmt.Sprintf("    - %s: %v\n", k, v))
+	}
+}
+
+// formatTime renders t as RFC3339 in the window's reporting time zone.
+func (w *Window) formatTime(t time.Time) string {
+	if w.Location != nil {
+		t = t.In(w.Location)
+	}
+	return t.Format(time.RFC3339)
+}
+
+// ChunkRange locates a chunk of a window's context text that was embedded on its own. Start
+// and End are character offsets into the text as embedded, which is ContextText unless a
+// BeforeEmbed hook rewrote it.
+type ChunkRange struct {
+	Index int `json:"index"` // Position of the chunk, from 0
+	Start int `json:"start"` // Offset of the chunk's first character
+	End   int `json:"end"`   // Offset just past its last character
+}
+
 type EmbeddedWindow struct {
-	WindowID      string            `json:"window_id"`
-	Topic         string            `json:"topic"`
-	Partition     int32             `json:"partition"`
-	StartTime     time.Time         `json:"start_time"`
-	EndTime       time.Time         `json:"end_time"`
-	MessageCount  int               `json:"message_count"`
-	ContextText   string            `json:"context_text"`             // The text that was embedded
-	Embedding     []float32         `json:"embedding"`                // The vector embedding
-	KafkaMessages []RawKafkaMessage `json:"kafka_messages,omitempty"` // Store raw messages if needed, or just their IDs
+	WindowID             string              `json:"window_id"`
+	Topic                string              `json:"topic"`
+	Partition            int32               `json:"partition"`
+	Shard                *int                `json:"shard,omitempty"`     // Key shard of the partition, nil if the topic is not sharded
+	GroupKey             string              `json:"group_key,omitempty"` // Message key (or group_by_field value) of the window's messages, empty unless the topic is grouped by key
+	StartTime            time.Time           `json:"start_time"`
+	EndTime              time.Time           `json:"end_time"`
+	MessageCount         int                 `json:"message_count"`
+	ContextText          string              `json:"context_text"`                     // The text that was embedded
+	TopicContext         string              `json:"topic_context,omitempty"`          // Topic description that applied when the window was rendered
+	ContextVersion       string              `json:"context_version,omitempty"`        // Version of that topic description
+	ContextEffectiveFrom *time.Time          `json:"context_effective_from,omitempty"` // When that version started to apply
+	Embedding            []float32           `json:"embedding"`                        // The vector embedding
+	EmbeddingModel       string              `json:"embedding_model,omitempty"`        // Model that produced Embedding
+	EmbeddingModelDigest string              `json:"embedding_model_digest,omitempty"` // Digest of that model's weights in Ollama, empty if unknown
+	TemplateVersion      string              `json:"template_version,omitempty"`       // Version of the Summarizer that rendered ContextText
+	EmbeddedAt           *time.Time          `json:"embedded_at,omitempty"`            // When Embedding was computed, nil for windows indexed before it was recorded
+	EmbeddingChunks      int                 `json:"embedding_chunks,omitempty"`       // Chunks averaged into Embedding when ContextText exceeded the limit
+	ChunkRanges          []ChunkRange        `json:"chunk_ranges,omitempty"`           // Where each of those chunks lies in the text, in chunk order
+	SamplingPolicy       string              `json:"sampling_policy,omitempty"`        // Sampling applied before windowing, empty if none
+	SamplingRate         float64             `json:"sampling_rate,omitempty"`          // Fraction of messages kept by sampling
+	SampledFrom          int                 `json:"sampled_from,omitempty"`           // Messages seen before sampling
+	SimHash              string              `json:"simhash,omitempty"`                // Locality-sensitive signature of ContextText, see SimHash
+	FirstOffset          *int64              `json:"first_offset,omitempty"`           // Offset range of the window's partition the window covers, nil for windows indexed before offsets were recorded
+	LastOffset           *int64              `json:"last_offset,omitempty"`
+	Annotations          map[string]string   `json:"annotations,omitempty"`    // Added by the topic's processor webhook
+	CloseReason          string              `json:"close_reason,omitempty"`   // Why the window was closed, see IsPartialClose
+	Truncated            bool                `json:"truncated,omitempty"`      // Not all messages are spelled out in ContextText
+	ParseFailures        int                 `json:"parse_failures,omitempty"` // Messages that were not valid JSON
+	Category             string              `json:"category,omitempty"`       // Content category assigned by the classifier, empty if none
+	Headers              map[string][]string `json:"headers,omitempty"`        // Distinct values of each message header, see Window.HeaderValues
+	KafkaMessages        []RawKafkaMessage   `json:"kafka_messages,omitempty"` // Store raw messages if needed, or just their IDs
 }
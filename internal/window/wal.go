@@ -0,0 +1,396 @@
+package window
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"stream-rag-agent/internal/atrest"
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/internal/metrics"
+)
+
+const defaultWALCompactInterval = time.Minute
+
+var (
+	walRestoredMessages = metrics.NewCounter("window_wal_restored_messages_total", "Messages of windows that were open at the last shutdown or crash, restored from the window WAL on startup, by topic.")
+	walWriteErrors      = metrics.NewCounter("window_wal_write_errors_total", "Failed appends to the window WAL, whose messages are lost in a crash before their windows are processed, by topic.")
+)
+
+// WAL persists the messages of open windows to a write-ahead log per topic, so windows that
+// are open when the agent crashes are restored on startup instead of lost: sources that commit
+// messages once they are handed to the windows do not deliver them again. Each message is
+// appended to the log of its topic when it is added to its windows; once the windows of a key
+// are processed, a record marks its earlier messages as done, and the log is compacted when it
+// mostly holds done messages.
+type WAL struct {
+	dir      string
+	fsync    bool
+	interval time.Duration
+	mu       sync.Mutex
+	logs     []*walLog
+}
+
+// walLog is the write-ahead log of one topic.
+type walLog struct {
+	topic      string
+	path       string
+	fsync      bool
+	mu         sync.Mutex
+	file       *os.File
+	buf        []byte // Records logged but not written yet, see flush
+	next       int64  // Sequence number of the next message
+	keys       map[string]*walKey
+	pending    int  // Messages in the file whose windows are not processed yet
+	superseded int  // Messages in the file that are done, dropped by the next compaction
+	failing    bool // The last write failed; logged once until a write succeeds
+}
+
+// walKey tracks the log of one window key.
+type walKey struct {
+	windows map[*Window]int64 // Open or processing windows -> sequence number of the first message they can hold
+	seqs    []int64           // Sequence numbers of the key's pending messages, ascending
+}
+
+// walRecord is a line of the log: a message of a window key, or the sequence number below
+// which the key's messages are done.
+type walRecord struct {
+	Key string           `json:"key"`
+	Seq int64            `json:"seq,omitempty"`
+	Msg *RawKafkaMessage `json:"msg,omitempty"`
+	Low int64            `json:"low,omitempty"`
+}
+
+// NewWAL returns nil when the WAL is disabled.
+func NewWAL(cfg config.WindowWALConfig) (*WAL, error) {
+	if cfg.Dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create window WAL directory: %w", err)
+	}
+	interval := time.Duration(cfg.CompactIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultWALCompactInterval
+	}
+	return &WAL{dir: cfg.Dir, fsync: cfg.Fsync, interval: interval}, nil
+}
+
+// Attach logs the manager's messages and restores the messages of the windows that were open
+// when the topic's log was last written, adding them to the manager's windows again. Call it
+// before messages are added to the manager, and only for sources that do not deliver messages
+// again whose windows were not processed.
+func (wal *WAL) Attach(m *Manager) error {
+	if wal == nil {
+		return nil
+	}
+	l := &walLog{
+		topic: m.config.Name,
+		path:  filepath.Join(wal.dir, url.PathEscape(m.config.Name)+".wal"),
+		fsync: wal.fsync,
+		keys:  make(map[string]*walKey),
+	}
+	restored, err := l.read()
+	if err != nil {
+		return err
+	}
+	// The restored messages are logged again to a new file, which replaces the old one once
+	// they are all written
+	tmp := l.path + ".tmp"
+	l.file, err = os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create window WAL: %w", err)
+	}
+
+	m.liveMu.Lock()
+	for w := range m.live {
+		l.opened(w) // Opened by Start, before any message
+	}
+	m.wal = l
+	m.liveMu.Unlock()
+
+	if len(restored) > 0 {
+		m.addMessages(restored)
+		log.Printf("Restored %d messages of windows of topic %s that were open when the agent stopped from the window WAL", len(restored), l.topic)
+		walRestoredMessages.Add(float64(len(restored)), "topic", l.topic)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to write window WAL: %w", err)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return fmt.Errorf("failed to write window WAL: %w", err)
+	}
+
+	wal.mu.Lock()
+	wal.logs = append(wal.logs, l)
+	wal.mu.Unlock()
+	return nil
+}
+
+// Run compacts the logs of the attached managers every interval until the context is
+// cancelled.
+func (wal *WAL) Run(ctx context.Context) {
+	if wal == nil {
+		return
+	}
+	ticker := time.NewTicker(wal.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			wal.mu.Lock()
+			logs := append([]*walLog(nil), wal.logs...)
+			wal.mu.Unlock()
+			for _, l := range logs {
+				if err := l.compact(); err != nil {
+					log.Printf("Error compacting window WAL of topic %s: %v", l.topic, err)
+				}
+			}
+		}
+	}
+}
+
+// read returns the messages of the log that are not done, in the order they were logged.
+func (l *walLog) read() ([]RawKafkaMessage, error) {
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open window WAL: %w", err)
+	}
+	defer f.Close()
+
+	var records []walRecord
+	low := make(map[string]int64)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64<<20)
+	for scanner.Scan() {
+		line, err := atrest.OpenLine(scanner.Bytes())
+		if errors.Is(err, atrest.ErrNoKey) {
+			return nil, fmt.Errorf("failed to read window WAL: %w", err)
+		}
+		var r walRecord
+		if err == nil {
+			err = json.Unmarshal(line, &r)
+		}
+		if err != nil {
+			// A torn write at the end of the log after a crash
+			log.Printf("Skipping unreadable window WAL record of topic %s: %v", l.topic, err)
+			continue
+		}
+		if r.Msg == nil {
+			low[r.Key] = r.Low
+			continue
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read window WAL: %w", err)
+	}
+
+	var msgs []RawKafkaMessage
+	for _, r := range records {
+		if r.Seq >= low[r.Key] {
+			msgs = append(msgs, *r.Msg)
+		}
+	}
+	return msgs, nil
+}
+
+// opened starts tracking a new window, which holds messages logged from now on. Callers hold
+// m.liveMu.
+func (l *walLog) opened(w *Window) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	k, ok := l.keys[w.Key]
+	if !ok {
+		k = &walKey{windows: make(map[*Window]int64)}
+		l.keys[w.Key] = k
+	}
+	k.windows[w] = l.next
+}
+
+// log buffers a message of a window key for the next flush. Callers hold the key's slot lock
+// until the flush.
+func (l *walLog) log(key string, msg RawKafkaMessage) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	seq := l.next
+	l.next++
+	if k, ok := l.keys[key]; ok {
+		k.seqs = append(k.seqs, seq)
+		l.pending++
+	}
+	logged := msg // Escapes to the heap here rather than for every message of topics without a log
+	l.record(walRecord{Key: key, Seq: seq, Msg: &logged})
+}
+
+// record buffers a record. Callers hold l.mu.
+func (l *walLog) record(r walRecord) {
+	data, err := json.Marshal(r)
+	if err == nil {
+		data, err = atrest.SealLine(data)
+	}
+	if err != nil {
+		l.failed(err)
+		return
+	}
+	l.buf = append(append(l.buf, data...), '\n')
+}
+
+// flush writes the buffered records, syncing them with fsync.
+func (l *walLog) flush() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.write()
+}
+
+// write writes the buffered records. Callers hold l.mu.
+func (l *walLog) write() {
+	if len(l.buf) == 0 {
+		return
+	}
+	_, err := l.file.Write(l.buf)
+	if err == nil && l.fsync {
+		err = l.file.Sync()
+	}
+	l.buf = l.buf[:0]
+	if err != nil {
+		l.failed(err)
+		return
+	}
+	if l.failing {
+		log.Printf("Window WAL of topic %s is written again", l.topic)
+		l.failing = false
+	}
+}
+
+// failed reports a record that could not be written. Callers hold l.mu.
+func (l *walLog) failed(err error) {
+	walWriteErrors.Inc("topic", l.topic)
+	if !l.failing {
+		log.Printf("Error writing window WAL of topic %s, messages of open windows are lost in a crash: %v", l.topic, err)
+		l.failing = true
+	}
+}
+
+// done stops tracking a window once it is processed, marking the messages of its key that no
+// other open or processing window holds as done.
+func (l *walLog) done(w *Window) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	k, ok := l.keys[w.Key]
+	if !ok {
+		return
+	}
+	delete(k.windows, w)
+	low := l.next
+	for _, first := range k.windows {
+		low = min(low, first)
+	}
+	done := 0
+	for done < len(k.seqs) && k.seqs[done] < low {
+		done++
+	}
+	if done > 0 {
+		k.seqs = k.seqs[done:]
+		l.pending -= done
+		l.superseded += done
+		l.record(walRecord{Key: w.Key, Low: low})
+	}
+	if len(k.windows) == 0 && len(k.seqs) == 0 {
+		delete(l.keys, w.Key)
+	}
+	l.write()
+}
+
+// compact rewrites the log with its pending messages once it holds at least as many done ones.
+func (l *walLog) compact() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.superseded == 0 || l.superseded < l.pending {
+		return nil
+	}
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tmp := l.path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64<<20)
+	for scanner.Scan() {
+		line, err := atrest.OpenLine(scanner.Bytes())
+		var r walRecord
+		if err == nil {
+			err = json.Unmarshal(line, &r)
+		}
+		if err != nil || r.Msg == nil || !l.isPending(r.Key, r.Seq) {
+			continue
+		}
+		w.Write(scanner.Bytes())
+		w.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	l.file.Close()
+	l.file = file
+	l.superseded = 0
+	return nil
+}
+
+// isPending reports whether a logged message is not done. Callers hold l.mu.
+func (l *walLog) isPending(key string, seq int64) bool {
+	k, ok := l.keys[key]
+	return ok && len(k.seqs) > 0 && seq >= k.seqs[0]
+}
@@ -0,0 +1,180 @@
+package window
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"stream-rag-agent/internal/config"
+)
+
+// walRecorder records the offsets of the processed windows by group key, empty for
+// partition windows.
+type walRecorder struct {
+	mu      sync.Mutex
+	offsets map[string][]int64
+}
+
+func (r *walRecorder) ProcessWindow(w *Window) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, msg := range w.Messages {
+		r.offsets[w.GroupKey] = append(r.offsets[w.GroupKey], msg.Offset)
+	}
+	return nil
+}
+
+// waitPending waits until the log holds n messages whose windows are not processed.
+func waitPending(t *testing.T, l *walLog, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mu.Lock()
+		pending := l.pending
+		l.mu.Unlock()
+		if pending == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("WAL has %d pending messages, want %d", pending, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWALRestore(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	partition := config.KafkaTopicConfig{WindowMaxMessages: 10}
+	grouped := config.KafkaTopicConfig{GroupByField: "account_id", WindowMaxMessages: 2}
+	interleaved := []string{"A", "B", "A", "C", "B", "A", "C", "B"} // Closes A {0,2}, B {1,4} and C {3,6}
+
+	for _, tc := range []struct {
+		name      string
+		cfg       config.KafkaTopicConfig
+		accounts  []string // Account of each message, offsets count from 0
+		compact   bool
+		torn      bool
+		wantLines int // Lines of the log after compaction
+		want      map[string][]int64
+	}{
+		{
+			name:     "crash mid-window",
+			cfg:      partition,
+			accounts: []string{"A", "B", "C"},
+			want:     map[string][]int64{"": {0, 1, 2}},
+		},
+		{
+			name:     "restore after a processed window",
+			cfg:      config.KafkaTopicConfig{WindowMaxMessages: 3},
+			accounts: []string{"A", "B", "C", "D", "E"},
+			want:     map[string][]int64{"": {3, 4}},
+		},
+		{
+			name:     "interleaved keys",
+			cfg:      grouped,
+			accounts: interleaved,
+			want:     map[string][]int64{"A": {5}, "B": {7}},
+		},
+		{
+			name:      "compaction with interleaved keys",
+			cfg:       grouped,
+			accounts:  interleaved,
+			compact:   true,
+			wantLines: 2,
+			want:      map[string][]int64{"A": {5}, "B": {7}},
+		},
+		{
+			name:     "torn last line",
+			cfg:      partition,
+			accounts: []string{"A", "B", "C"},
+			torn:     true,
+			want:     map[string][]int64{"": {0, 1, 2}},
+		},
+		{
+			name:      "torn last line after compaction",
+			cfg:       grouped,
+			accounts:  interleaved,
+			compact:   true,
+			torn:      true,
+			wantLines: 2,
+			want:      map[string][]int64{"A": {5}, "B": {7}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := tc.cfg
+			cfg.Name = "payments"
+			cfg.WindowDurationSeconds = 3600
+
+			wal, err := NewWAL(config.WindowWALConfig{Dir: dir})
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := &walRecorder{offsets: make(map[string][]int64)}
+			m := NewManager(cfg, before, time.UTC)
+			if err := wal.Attach(m); err != nil {
+				t.Fatal(err)
+			}
+			for i, account := range tc.accounts {
+				m.AddMessage(RawKafkaMessage{
+					Topic:     cfg.Name,
+					Offset:    int64(i),
+					Key:       []byte(account),
+					Value:     []byte(fmt.Sprintf(`{"account_id":%q,"amount":%d}`, account, i)),
+					Timestamp: time.Now(),
+				})
+			}
+			pending := 0
+			for _, offsets := range tc.want {
+				pending += len(offsets)
+			}
+			waitPending(t, m.wal, pending)
+
+			path := m.wal.path
+			if tc.compact {
+				if err := m.wal.compact(); err != nil {
+					t.Fatal(err)
+				}
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if lines := bytes.Count(data, []byte("\n")); lines != tc.wantLines {
+					t.Errorf("compacted log has %d lines, want %d", lines, tc.wantLines)
+				}
+			}
+			if tc.torn {
+				f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.WriteString(`{"key":"payments_0","seq":99,"msg":{"topic":"pay`)
+				f.Close()
+			}
+
+			// Crash: the first manager is abandoned with its windows open
+			restarted, err := NewWAL(config.WindowWALConfig{Dir: dir})
+			if err != nil {
+				t.Fatal(err)
+			}
+			after := &walRecorder{offsets: make(map[string][]int64)}
+			m2 := NewManager(cfg, after, time.UTC)
+			if err := restarted.Attach(m2); err != nil {
+				t.Fatal(err)
+			}
+			m2.PartitionsRevoked([]int32{0})
+
+			if !reflect.DeepEqual(after.offsets, tc.want) {
+				t.Errorf("restored %v, want %v", after.offsets, tc.want)
+			}
+		})
+	}
+}
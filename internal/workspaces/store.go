@@ -0,0 +1,244 @@
+// Package workspaces groups the artifacts teams save in one deployment, views, alert
+// patterns, chat sessions and saved queries, into workspaces, each usable only with the API
+// keys it names. Workspaces and their saved queries are persisted to a JSON file so they
+// survive restarts; views and patterns keep their own stores and name their workspace.
+package workspaces
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"stream-rag-agent/internal/atrest"
+	"stream-rag-agent/internal/config"
+	"stream-rag-agent/pkg/errs"
+)
+
+// defaultFile is where workspaces are persisted unless workspaces.file is set.
+const defaultFile = "./workspaces.json"
+
+var (
+	ErrWorkspaceNotFound = errs.New(errs.ErrNotFound, "workspace not found")
+	ErrQueryNotFound     = errs.New(errs.ErrNotFound, "saved query not found")
+
+	// validName matches workspace and saved query names, which appear in URL paths
+	validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+)
+
+// Workspace is a named group of saved artifacts.
+type Workspace struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	APIKeys     []string  `json:"api_keys,omitempty"` // Names of the API keys that may use the workspace, any key if empty
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Allows reports whether the API key with the given name may use the workspace.
+func (w *Workspace) Allows(keyName string) bool {
+	if len(w.APIKeys) == 0 {
+		return true
+	}
+	for _, name := range w.APIKeys {
+		if name == keyName {
+			return true
+		}
+	}
+	return false
+}
+
+// SavedQuery is a /query request saved under a name, to be run again.
+type SavedQuery struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Request     json.RawMessage `json:"request"` // Body of a /query request
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// savedWorkspace is a workspace as persisted, with its saved queries.
+type savedWorkspace struct {
+	*Workspace
+	Queries []*SavedQuery `json:"queries,omitempty"`
+}
+
+type workspace struct {
+	*Workspace
+	queries map[string]*SavedQuery
+}
+
+// Store keeps the workspaces and their saved queries.
+type Store struct {
+	mu         sync.RWMutex
+	workspaces map[string]*workspace
+	path       string
+}
+
+func NewStore(cfg config.WorkspacesConfig) (*Store, error) {
+	s := &Store{workspaces: make(map[string]*workspace), path: cfg.File}
+	if s.path == "" {
+		s.path = defaultFile
+	}
+	data, err := atrest.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read workspaces file: %w", err)
+	}
+	if len(data) > 0 {
+		var saved []savedWorkspace
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal workspaces file: %w", err)
+		}
+		for _, sw := range saved {
+			if sw.Workspace == nil {
+				continue
+			}
+			ws := &workspace{Workspace: sw.Workspace, queries: make(map[string]*SavedQuery, len(sw.Queries))}
+			for _, q := range sw.Queries {
+				ws.queries[q.Name] = q
+			}
+			s.workspaces[ws.Name] = ws
+		}
+	}
+	return s, nil
+}
+
+func (s *Store) Get(name string) (*Workspace, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ws, ok := s.workspaces[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrWorkspaceNotFound, name)
+	}
+	return ws.Workspace, nil
+}
+
+func (s *Store) List() []*Workspace {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]*Workspace, 0, len(s.workspaces))
+	for _, ws := range s.workspaces {
+		list = append(list, ws.Workspace)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Put creates or replaces a workspace, keeping the creation time and saved queries of the one
+// it replaces.
+func (s *Store) Put(w *Workspace) error {
+	if !validName.MatchString(w.Name) {
+		return errs.Errorf(errs.ErrInvalidRequest, "invalid workspace name '%s': use letters, digits, '.', '_' and '-'", w.Name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queries := make(map[string]*SavedQuery)
+	w.CreatedAt = time.Now().UTC()
+	if existing, ok := s.workspaces[w.Name]; ok {
+		queries = existing.queries
+		w.CreatedAt = existing.CreatedAt
+	}
+	s.workspaces[w.Name] = &workspace{Workspace: w, queries: queries}
+	return s.saveLocked()
+}
+
+// Delete removes a workspace with its saved queries. The views, patterns and chat sessions of
+// the workspace are removed by their owners.
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.workspaces[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrWorkspaceNotFound, name)
+	}
+	delete(s.workspaces, name)
+	return s.saveLocked()
+}
+
+// Queries returns the saved queries of a workspace, by name.
+func (s *Store) Queries(workspace string) ([]*SavedQuery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ws, ok := s.workspaces[workspace]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrWorkspaceNotFound, workspace)
+	}
+	list := make([]*SavedQuery, 0, len(ws.queries))
+	for _, q := range ws.queries {
+		list = append(list, q)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func (s *Store) Query(workspace, name string) (*SavedQuery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ws, ok := s.workspaces[workspace]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrWorkspaceNotFound, workspace)
+	}
+	q, ok := ws.queries[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrQueryNotFound, name)
+	}
+	return q, nil
+}
+
+// PutQuery creates or replaces a saved query of a workspace, keeping the creation time of the
+// one it replaces.
+func (s *Store) PutQuery(workspace string, q *SavedQuery) error {
+	if !validName.MatchString(q.Name) {
+		return errs.Errorf(errs.ErrInvalidRequest, "invalid saved query name '%s': use letters, digits, '.', '_' and '-'", q.Name)
+	}
+	if len(q.Request) == 0 {
+		return errs.New(errs.ErrInvalidRequest, "saved query request is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ws, ok := s.workspaces[workspace]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrWorkspaceNotFound, workspace)
+	}
+	q.CreatedAt = time.Now().UTC()
+	if existing, ok := ws.queries[q.Name]; ok {
+		q.CreatedAt = existing.CreatedAt
+	}
+	ws.queries[q.Name] = q
+	return s.saveLocked()
+}
+
+func (s *Store) DeleteQuery(workspace, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ws, ok := s.workspaces[workspace]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrWorkspaceNotFound, workspace)
+	}
+	if _, ok := ws.queries[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrQueryNotFound, name)
+	}
+	delete(ws.queries, name)
+	return s.saveLocked()
+}
+
+func (s *Store) saveLocked() error {
+	saved := make([]savedWorkspace, 0, len(s.workspaces))
+	for _, ws := range s.workspaces {
+		sw := savedWorkspace{Workspace: ws.Workspace, Queries: make([]*SavedQuery, 0, len(ws.queries))}
+		for _, q := range ws.queries {
+			sw.Queries = append(sw.Queries, q)
+		}
+		sort.Slice(sw.Queries, func(i, j int) bool { return sw.Queries[i].Name < sw.Queries[j].Name })
+		saved = append(saved, sw)
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Name < saved[j].Name })
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal workspaces: %w", err)
+	}
+	if err := atrest.WriteFile(s.path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write workspaces file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// Package errs defines the categories of errors returned by the agent's packages, so programs
+// embedding them (or API clients) can handle failures with errors.Is and errors.As instead of
+// matching messages:
+//
+//	if errors.Is(err, errs.ErrDependencyUnavailable) { retry later }
+//
+//	var depErr *errs.DependencyError
+//	if errors.As(err, &depErr) { log.Print(depErr.Dependency, depErr.StatusCode) }
+//
+// Errors keep their messages and their wrapped causes; the categories are matched in
+// addition, e.g. a timed out Ollama call is both ErrDependencyUnavailable and
+// context.DeadlineExceeded.
+package errs
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// ErrNotFound: the requested window, view, pattern or model does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidRequest: the input was rejected and retrying it unchanged will fail again.
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrRateLimited: a dependency rejected the call because of load; retry with backoff.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrDependencyUnavailable: Ollama or Elasticsearch could not be reached, timed out or
+	// failed.
+	ErrDependencyUnavailable = errors.New("dependency unavailable")
+)
+
+// categorized is an error that also matches a category, without the category's message.
+type categorized struct {
+	err      error
+	category error
+}
+
+func (e *categorized) Error() string { return e.err.Error() }
+
+func (e *categorized) Unwrap() error { return e.err }
+
+func (e *categorized) Is(target error) bool { return target == e.category }
+
+// New returns an error with the message that matches category with errors.Is, for declaring
+// package-level sentinels such as views.ErrViewNotFound.
+func New(category error, msg string) error {
+	return &categorized{err: errors.New(msg), category: category}
+}
+
+// Errorf is fmt.Errorf for an error that also matches category with errors.Is.
+func Errorf(category error, format string, args ...any) error {
+	return &categorized{err: fmt.Errorf(format, args...), category: category}
+}
+
+// DependencyError is a failed call to a service the agent depends on. It matches the
+// category derived from the response status with errors.Is.
+type DependencyError struct {
+	Dependency string // "ollama" or "elasticsearch"
+	StatusCode int    // HTTP status of the response, 0 if none was received
+	Err        error  // Describes the failure, wrapping its cause
+}
+
+// Dependency returns err as a DependencyError, nil if err is nil.
+func Dependency(dependency string, statusCode int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &DependencyError{Dependency: dependency, StatusCode: statusCode, Err: err}
+}
+
+func (e *DependencyError) Error() string { return e.Err.Error() }
+
+func (e *DependencyError) Unwrap() error { return e.Err }
+
+func (e *DependencyError) Is(target error) bool { return target == e.Category() }
+
+// Category returns the category of the failure: no response, a server error or a timeout
+// makes the dependency unavailable, and client errors are attributed to the request.
+func (e *DependencyError) Category() error {
+	switch {
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode == http.StatusRequestTimeout:
+		return ErrDependencyUnavailable
+	case e.StatusCode >= 400 && e.StatusCode < 500:
+		return ErrInvalidRequest
+	default:
+		return ErrDependencyUnavailable
+	}
+}
+
+// HTTPStatus returns the status the API responds with for err, or fallback if err has no
+// category. A dependency rejecting the agent's call, e.g. for a model that is not pulled, is
+// not the client's fault and makes the service unavailable; only rate limiting is passed on.
+func HTTPStatus(err error, fallback int) int {
+	var depErr *DependencyError
+	if errors.As(err, &depErr) && depErr.Category() != ErrRateLimited {
+		return http.StatusServiceUnavailable
+	}
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrDependencyUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return fallback
+	}
+}
+
+// FromHTTPStatus returns the category of an API error response, nil for other statuses.
+func FromHTTPStatus(statusCode int) error {
+	switch statusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusBadRequest:
+		return ErrInvalidRequest
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusServiceUnavailable:
+		return ErrDependencyUnavailable
+	default:
+		return nil
+	}
+}
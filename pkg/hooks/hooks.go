@@ -0,0 +1,131 @@
+// Package hooks lets deployments observe and change the agent's LLM and embedding calls
+// without modifying its code: BeforeGenerate hooks see (and may rewrite or reject) the
+// messages sent to the model, AfterGenerate hooks may rewrite its response and BeforeEmbed
+// hooks may rewrite text before it is embedded, e.g. for logging, redaction or prompt
+// augmentation.
+//
+// Hooks are registered on a Registry, either from Go code (a plugin exporting
+// "func Register(*hooks.Registry)", see LoadPlugin) or as HTTP webhooks (see Webhook).
+package hooks
+
+import "sync"
+
+// Hook points, as used in configuration.
+const (
+	PointBeforeGenerate = "before_generate"
+	PointAfterGenerate  = "after_generate"
+	PointBeforeEmbed    = "before_embed"
+)
+
+// Message is one role-tagged message of a generation request.
+type Message struct {
+	Role    string `json:"role"` // "system", "user" or "assistant"
+	Content string `json:"content"`
+}
+
+// GenerateRequest is an LLM call about to be made. Single-prompt generations appear as an
+// optional system message followed by the user message.
+type GenerateRequest struct {
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages"`
+}
+
+// BeforeGenerate may modify the request in place; an error aborts the call.
+type BeforeGenerate func(req *GenerateRequest) error
+
+// AfterGenerate returns the response to use instead of the model's; an error fails the call.
+type AfterGenerate func(req GenerateRequest, response string) (string, error)
+
+// BeforeEmbed returns the text to embed instead of the given one; an error fails the call.
+type BeforeEmbed func(text string) (string, error)
+
+// Registry holds the registered hooks. Hooks of a point run in registration order, each
+// seeing the result of the previous one. A nil *Registry has no hooks.
+type Registry struct {
+	mu             sync.RWMutex
+	beforeGenerate []BeforeGenerate
+	afterGenerate  []AfterGenerate
+	beforeEmbed    []BeforeEmbed
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) OnBeforeGenerate(h BeforeGenerate) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.beforeGenerate = append(r.beforeGenerate, h)
+}
+
+func (r *Registry) OnAfterGenerate(h AfterGenerate) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.afterGenerate = append(r.afterGenerate, h)
+}
+
+func (r *Registry) OnBeforeEmbed(h BeforeEmbed) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.beforeEmbed = append(r.beforeEmbed, h)
+}
+
+// Empty reports whether no hooks are registered.
+func (r *Registry) Empty() bool {
+	if r == nil {
+		return true
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.beforeGenerate) == 0 && len(r.afterGenerate) == 0 && len(r.beforeEmbed) == 0
+}
+
+// RunBeforeGenerate runs the BeforeGenerate hooks on req.
+func (r *Registry) RunBeforeGenerate(req *GenerateRequest) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	hooks := r.beforeGenerate
+	r.mu.RUnlock()
+	for _, h := range hooks {
+		if err := h(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunAfterGenerate runs the AfterGenerate hooks and returns the final response.
+func (r *Registry) RunAfterGenerate(req GenerateRequest, response string) (string, error) {
+	if r == nil {
+		return response, nil
+	}
+	r.mu.RLock()
+	hooks := r.afterGenerate
+	r.mu.RUnlock()
+	for _, h := range hooks {
+		var err error
+		if response, err = h(req, response); err != nil {
+			return "", err
+		}
+	}
+	return response, nil
+}
+
+// RunBeforeEmbed runs the BeforeEmbed hooks and returns the text to embed.
+func (r *Registry) RunBeforeEmbed(text string) (string, error) {
+	if r == nil {
+		return text, nil
+	}
+	r.mu.RLock()
+	hooks := r.beforeEmbed
+	r.mu.RUnlock()
+	for _, h := range hooks {
+		var err error
+		if text, err = h(text); err != nil {
+			return "", err
+		}
+	}
+	return text, nil
+}
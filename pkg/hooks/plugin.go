@@ -0,0 +1,25 @@
+package hooks
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// LoadPlugin opens a Go plugin (built with -buildmode=plugin against the same module version)
+// and calls its exported "func Register(*hooks.Registry)" to register its hooks.
+func LoadPlugin(path string, r *Registry) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open hook plugin %s: %w", path, err)
+	}
+	sym, err := p.Lookup("Register")
+	if err != nil {
+		return fmt.Errorf("hook plugin %s has no Register function: %w", path, err)
+	}
+	register, ok := sym.(func(*Registry))
+	if !ok {
+		return fmt.Errorf("hook plugin %s: Register must be func(*hooks.Registry), got %T", path, sym)
+	}
+	register(r)
+	return nil
+}
@@ -0,0 +1,118 @@
+package hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// WebhookRequest is POSTed to a webhook at each hook point it is registered for. Only the
+// fields of the point are set.
+type WebhookRequest struct {
+	Point    string           `json:"point"`
+	Request  *GenerateRequest `json:"request,omitempty"`  // before_generate, after_generate
+	Response string           `json:"response,omitempty"` // after_generate
+	Text     string           `json:"text,omitempty"`     // before_embed
+}
+
+// WebhookResponse is the webhook's answer. Fields left empty keep the original; an empty
+// 2xx body changes nothing. "reject": true fails the call with the given reason.
+type WebhookResponse struct {
+	Messages []Message `json:"messages,omitempty"` // before_generate: replacement messages
+	Response string    `json:"response,omitempty"` // after_generate: replacement response
+	Text     string    `json:"text,omitempty"`     // before_embed: replacement text
+	Reject   bool      `json:"reject,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// Webhook implements hooks as calls to an HTTP endpoint.
+type Webhook struct {
+	url        string
+	httpClient *http.Client
+}
+
+func NewWebhook(url string, timeout time.Duration) *Webhook {
+	return &Webhook{url: url, httpClient: &http.Client{Timeout: timeout}}
+}
+
+// Register adds the webhook to the registry at the given points.
+func (wh *Webhook) Register(r *Registry, points []string) error {
+	for _, point := range points {
+		switch point {
+		case PointBeforeGenerate:
+			r.OnBeforeGenerate(wh.beforeGenerate)
+		case PointAfterGenerate:
+			r.OnAfterGenerate(wh.afterGenerate)
+		case PointBeforeEmbed:
+			r.OnBeforeEmbed(wh.beforeEmbed)
+		default:
+			return fmt.Errorf("unknown hook point %q (use %s, %s or %s)", point, PointBeforeGenerate, PointAfterGenerate, PointBeforeEmbed)
+		}
+	}
+	return nil
+}
+
+func (wh *Webhook) beforeGenerate(req *GenerateRequest) error {
+	resp, err := wh.call(WebhookRequest{Point: PointBeforeGenerate, Request: req})
+	if err != nil {
+		return err
+	}
+	if len(resp.Messages) > 0 {
+		req.Messages = resp.Messages
+	}
+	return nil
+}
+
+func (wh *Webhook) afterGenerate(req GenerateRequest, response string) (string, error) {
+	resp, err := wh.call(WebhookRequest{Point: PointAfterGenerate, Request: &req, Response: response})
+	if err != nil {
+		return "", err
+	}
+	if resp.Response != "" {
+		return resp.Response, nil
+	}
+	return response, nil
+}
+
+func (wh *Webhook) beforeEmbed(text string) (string, error) {
+	resp, err := wh.call(WebhookRequest{Point: PointBeforeEmbed, Text: text})
+	if err != nil {
+		return "", err
+	}
+	if resp.Text != "" {
+		return resp.Text, nil
+	}
+	return text, nil
+}
+
+func (wh *Webhook) call(req WebhookRequest) (WebhookResponse, error) {
+	var out WebhookResponse
+	body, err := json.Marshal(req)
+	if err != nil {
+		return out, fmt.Errorf("failed to marshal %s hook request: %w", req.Point, err)
+	}
+	resp, err := wh.httpClient.Post(wh.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return out, fmt.Errorf("%s hook %s failed: %w", req.Point, wh.url, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return out, fmt.Errorf("failed to read %s hook response: %w", req.Point, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return out, fmt.Errorf("%s hook %s returned status %d: %s", req.Point, wh.url, resp.StatusCode, string(data))
+	}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &out); err != nil {
+			return out, fmt.Errorf("failed to decode %s hook response: %w", req.Point, err)
+		}
+	}
+	if out.Reject {
+		return out, fmt.Errorf("rejected by %s hook: %s", req.Point, out.Reason)
+	}
+	return out, nil
+}
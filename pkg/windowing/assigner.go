@@ -0,0 +1,81 @@
+package windowing
+
+import "fmt"
+
+// PartitionAssigner keeps one window per topic partition, the agent's tumbling windows.
+type PartitionAssigner struct{}
+
+func (PartitionAssigner) Assign(msg Message) string {
+	return PartitionKey(msg.Topic, msg.Partition)
+}
+
+// PartitionKey is the window key PartitionAssigner uses for a topic partition.
+func PartitionKey(topic string, partition int32) string {
+	return fmt.Sprintf("%s_%d", topic, partition)
+}
+
+// KeyHashAssigner splits every topic partition into Shards windows by a hash of the message
+// key, so the windows of a hot partition close and are processed in parallel while all
+// messages of a key stay in the same window sequence, in order.
+type KeyHashAssigner struct {
+	Shards  int
+	KeyFunc func(msg Message) string // Key to hash, nil uses the message key
+}
+
+func (a KeyHashAssigner) Assign(msg Message) string {
+	return ShardKey(msg.Topic, msg.Partition, a.Shard(msg))
+}
+
+// Shard returns the shard of the message, between 0 and Shards-1.
+func (a KeyHashAssigner) Shard(msg Message) int {
+	if a.Shards <= 1 {
+		return 0
+	}
+	var h uint32
+	if a.KeyFunc != nil {
+		h = fnv32a(a.KeyFunc(msg))
+	} else {
+		h = fnv32a(msg.Key)
+	}
+	return int(h % uint32(a.Shards))
+}
+
+// fnv32a is the FNV-1a hash of hash/fnv, computed without allocating a hash.Hash32 for every
+// message.
+func fnv32a[T string | []byte](data T) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(data); i++ {
+		h ^= uint32(data[i])
+		h *= 16777619
+	}
+	return h
+}
+
+// ShardKey is the window key KeyHashAssigner uses for a shard of a topic partition.
+func ShardKey(topic string, partition int32, shard int) string {
+	return fmt.Sprintf("%s_%d#%d", topic, partition, shard)
+}
+
+// KeyAssigner keeps a window per message key (or KeyFunc value) of every topic partition, so
+// each window holds the messages of a single entity, e.g. an account. Keys are unbounded, so
+// windows of keys that go quiet should be dropped by the sink.
+type KeyAssigner struct {
+	KeyFunc func(msg Message) string // Key to group by, nil uses the message key
+}
+
+func (a KeyAssigner) Assign(msg Message) string {
+	return GroupKey(msg.Topic, msg.Partition, a.Key(msg))
+}
+
+// Key returns the key the message is grouped by.
+func (a KeyAssigner) Key(msg Message) string {
+	if a.KeyFunc != nil {
+		return a.KeyFunc(msg)
+	}
+	return string(msg.Key)
+}
+
+// GroupKey is the window key KeyAssigner uses for a key of a topic partition.
+func GroupKey(topic string, partition int32, key string) string {
+	return fmt.Sprintf("%s_%d@%s", topic, partition, key)
+}
@@ -0,0 +1,133 @@
+package windowing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Window is a closed group of messages produced by Engine.
+type Window struct {
+	ID       string
+	Key      string
+	Start    time.Time
+	End      time.Time
+	Messages []Message
+
+	bytes int64
+}
+
+func (w *Window) state() WindowState {
+	return WindowState{Key: w.Key, Start: w.Start, Messages: len(w.Messages), Bytes: w.bytes}
+}
+
+// Engine buffers messages into windows chosen by an assigner and hands them to a processor
+// when the trigger closes them. Windows are processed on their own goroutine; Flush and Run
+// wait for them to finish.
+type Engine struct {
+	assigner  WindowAssigner
+	trigger   Trigger
+	processor Processor[*Window]
+
+	mu         sync.Mutex
+	windows    map[string]*Window
+	processing sync.WaitGroup
+}
+
+func NewEngine(assigner WindowAssigner, trigger Trigger, processor Processor[*Window]) *Engine {
+	return &Engine{
+		assigner:  assigner,
+		trigger:   trigger,
+		processor: processor,
+		windows:   make(map[string]*Window),
+	}
+}
+
+// AddMessage adds a message to its window, closing the window if the trigger fires.
+func (e *Engine) AddMessage(msg Message) {
+	key := e.assigner.Assign(msg)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	w, ok := e.windows[key]
+	if !ok {
+		start := msg.Timestamp
+		if start.IsZero() {
+			start = time.Now()
+		}
+		w = &Window{ID: fmt.Sprintf("%s_%d", key, start.UnixNano()), Key: key, Start: start}
+		e.windows[key] = w
+	}
+	w.Messages = append(w.Messages, msg)
+	w.bytes += msg.Size()
+	if !msg.Timestamp.IsZero() {
+		w.End = msg.Timestamp
+	}
+
+	if e.trigger.OnMessage(w.state()) {
+		e.close(w)
+	}
+}
+
+// Run consumes src into the engine, closing windows on the trigger's timer, until ctx is
+// cancelled or the source returns. Open windows are flushed before Run returns.
+func (e *Engine) Run(ctx context.Context, src Source) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if interval := e.trigger.Interval(); interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case now := <-ticker.C:
+					e.tick(now)
+				}
+			}
+		}()
+	}
+
+	err := src.Run(ctx, e)
+	e.Flush()
+	return err
+}
+
+func (e *Engine) tick(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, w := range e.windows {
+		if e.trigger.OnTimer(w.state(), now) {
+			e.close(w)
+		}
+	}
+}
+
+// Flush closes every open window and waits until all closed windows are processed.
+func (e *Engine) Flush() {
+	e.mu.Lock()
+	for _, w := range e.windows {
+		e.close(w)
+	}
+	e.mu.Unlock()
+	e.processing.Wait()
+}
+
+// close removes the window so the next message for its key opens a new one. Callers hold e.mu.
+func (e *Engine) close(w *Window) {
+	delete(e.windows, w.Key)
+	if w.End.IsZero() {
+		w.End = time.Now()
+	}
+	e.processing.Add(1)
+	go func() {
+		defer e.processing.Done()
+		if err := e.processor.ProcessWindow(w); err != nil {
+			log.Printf("Error processing window %s: %v", w.ID, err)
+		}
+	}()
+}
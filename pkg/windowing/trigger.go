@@ -0,0 +1,24 @@
+package windowing
+
+import "time"
+
+// CountOrTimeTrigger closes a window once it holds MaxMessages messages or MaxBytes bytes of
+// messages, or has been open for Duration, whichever comes first. A zero limit disables that
+// condition.
+type CountOrTimeTrigger struct {
+	MaxMessages int
+	MaxBytes    int64
+	Duration    time.Duration
+}
+
+func (t CountOrTimeTrigger) OnMessage(state WindowState) bool {
+	return t.MaxMessages > 0 && state.Messages >= t.MaxMessages || t.MaxBytes > 0 && state.Bytes >= t.MaxBytes
+}
+
+func (t CountOrTimeTrigger) OnTimer(state WindowState, now time.Time) bool {
+	return t.Duration > 0 && now.Sub(state.Start) >= t.Duration
+}
+
+func (t CountOrTimeTrigger) Interval() time.Duration {
+	return t.Duration
+}
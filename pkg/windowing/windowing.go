@@ -0,0 +1,96 @@
+// Package windowing is the stream windowing engine used by the agent, exposed through small
+// interfaces so other Go programs can embed it: a Source delivers messages to a Sink, a
+// WindowAssigner decides which window a message belongs to, a Trigger decides when a window
+// closes and a Processor handles closed windows.
+//
+// Engine is a self-contained implementation of the sink. The agent's own window manager
+// (internal/window) implements the same interfaces with its sampling, memory budget and
+// context rendering on top.
+package windowing
+
+import (
+	"context"
+	"time"
+)
+
+// Message is a single record read from a stream.
+type Message struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte // nil for tombstones on compacted topics
+	Timestamp time.Time
+	Headers   map[string]string // Message headers, e.g. trace IDs or tenant tags; nil if none
+
+	// Fields holds values of JSON fields of Value that a sink reads several times, e.g. to
+	// assign the message and again to compute statistics, so the payload is parsed once. It is
+	// derived from Value and nil unless the sink extracted fields.
+	Fields map[string]string `json:"-"`
+}
+
+// Size approximates the memory held by a buffered message.
+func (m Message) Size() int64 {
+	size := len(m.Key) + len(m.Value)
+	for name, value := range m.Headers {
+		size += len(name) + len(value)
+	}
+	return int64(size)
+}
+
+// IsTombstone reports whether the message is a tombstone, i.e. a deletion of its key.
+func (m Message) IsTombstone() bool {
+	return m.Value == nil
+}
+
+// Sink accepts messages from a Source, typically a window manager.
+type Sink interface {
+	AddMessage(msg Message)
+}
+
+// BatchSink is a Sink that can take several messages at once, e.g. to lock a window once
+// for all of them. Messages are added in order.
+type BatchSink interface {
+	Sink
+	AddMessages(msgs []Message)
+}
+
+// Source delivers messages to a sink until its context is cancelled or it fails.
+type Source interface {
+	Run(ctx context.Context, sink Sink) error
+}
+
+// WindowAssigner maps a message to the key of the window it belongs to. Messages with the
+// same key are buffered in the same window until a trigger closes it.
+type WindowAssigner interface {
+	Assign(msg Message) string
+}
+
+// WindowState describes an open window to a Trigger.
+type WindowState struct {
+	Key      string
+	Start    time.Time
+	Messages int   // Messages buffered in the window
+	Bytes    int64 // Size of the buffered messages
+}
+
+// Trigger decides when a window closes. OnMessage is consulted after every message added to
+// the window; OnTimer every Interval while it is open (never if Interval is 0).
+type Trigger interface {
+	OnMessage(state WindowState) bool
+	OnTimer(state WindowState, now time.Time) bool
+	Interval() time.Duration
+}
+
+// Processor handles windows once they close. W is the window type of the implementation,
+// e.g. *Window for Engine.
+type Processor[W any] interface {
+	ProcessWindow(w W) error
+}
+
+// ProcessorFunc adapts a function to the Processor interface.
+type ProcessorFunc[W any] func(w W) error
+
+func (f ProcessorFunc[W]) ProcessWindow(w W) error {
+	return f(w)
+}